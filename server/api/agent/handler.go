@@ -38,6 +38,7 @@ func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
 	{
 		applications.GET("/by-app/:app_id/agents", h.GetAgentsByApplicationID)
 		applications.POST("/by-app/:app_id/agents", h.CreateAgent)
+		applications.POST("/by-app/:app_id/orchestrate", h.Orchestrate)
 	}
 
 	// 智能体操作
@@ -169,6 +170,10 @@ func (h *Handler) CreateAgent(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此应用"})
 			return
 		}
+		if errors.Is(err, ErrInvalidMemoryConfig) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Error("Failed to create agent", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建智能体失败"})
 		return
@@ -220,6 +225,10 @@ func (h *Handler) UpdateAgent(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此智能体"})
 			return
 		}
+		if errors.Is(err, ErrInvalidMemoryConfig) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Error("Failed to update agent", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新智能体失败"})
 		return
@@ -444,3 +453,47 @@ func (h *Handler) GetAgents(c *gin.Context) {
 	// 使用统一响应格式返回数据
 	response.SuccessWithPagination(c, agents, int64(page), int64(pageSize), total)
 } 
+
+// Orchestrate 在应用下的多个智能体之间执行委派或转交
+func (h *Handler) Orchestrate(c *gin.Context) {
+	// 从URL获取应用ID
+	appID, err := uuid.Parse(c.Param("app_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的应用ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.OrchestrateAgentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.service.Orchestrate(c.Request.Context(), appID, req, userID.(uuid.UUID))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrApplicationNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+		case errors.Is(err, ErrUnauthorized):
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此应用"})
+		case errors.Is(err, ErrAgentNotInApplication):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrOrchestrationLimit), errors.Is(err, ErrApprovalNotSupported):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to orchestrate agents", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "多智能体编排失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
 	"github.com/zhuiye8/Lyss/server/models"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -15,18 +18,24 @@ var (
 	ErrModelConfigNotFound = errors.New("模型配置不存在")
 	ErrKnowledgeBaseNotFound = errors.New("知识库不存在")
 	ErrUnauthorized       = errors.New("无权访问此资源")
+	ErrAgentNotInApplication = errors.New("入口智能体不属于该应用")
+	ErrOrchestrationLimit    = errors.New("超出多智能体编排的深度或次数限制")
+	ErrApprovalNotSupported  = errors.New("编排中的工具调用需要人工审批，暂不支持")
+	ErrInvalidMemoryConfig   = errors.New("记忆配置无效")
 )
 
 // Service 提供智能体相关功能
 type Service struct {
 	db *gorm.DB
+	orchestrator *coreAgent.Orchestrator
 	logger *zap.Logger
 }
 
 // NewService 创建新的智能体服务
-func NewService(db *gorm.DB) *Service {
+func NewService(db *gorm.DB, orchestrator *coreAgent.Orchestrator) *Service {
 	return &Service{
 		db: db,
+		orchestrator: orchestrator,
 		logger: zap.L().With(zap.String("service", "agent")),
 	}
 }
@@ -112,6 +121,12 @@ func (s *Service) GetAgentByID(id uuid.UUID, userID uuid.UUID) (*models.AgentRes
 	return &response, nil
 }
 
+// Authorize 检查用户能否访问智能体，供工作流等其他模块复用
+func (s *Service) Authorize(id uuid.UUID, userID uuid.UUID) error {
+	_, err := s.GetAgentByID(id, userID)
+	return err
+}
+
 // CreateAgent 创建新的智能体
 func (s *Service) CreateAgent(appID uuid.UUID, req models.CreateAgentRequest, userID uuid.UUID) (*models.AgentResponse, error) {
 	// 检查应用是否存在及用户权限
@@ -129,6 +144,10 @@ func (s *Service) CreateAgent(appID uuid.UUID, req models.CreateAgentRequest, us
 		return nil, ErrUnauthorized
 	}
 
+	if _, err := coreAgent.ParseMemoryConfig(req.MemoryConfig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMemoryConfig, err)
+	}
+
 	// 检查模型配置是否存在
 	var modelConfig models.ModelConfig
 	if err := s.db.First(&modelConfig, "id = ?", req.ModelConfigID).Error; err != nil {
@@ -149,6 +168,7 @@ func (s *Service) CreateAgent(appID uuid.UUID, req models.CreateAgentRequest, us
 		Tools:            req.Tools,
 		Variables:        req.Variables,
 		MaxHistoryLength: req.MaxHistoryLength,
+		MemoryConfig:     req.MemoryConfig,
 	}
 
 	// 默认值处理
@@ -167,9 +187,9 @@ func (s *Service) CreateAgent(appID uuid.UUID, req models.CreateAgentRequest, us
 	// 处理关联的知识库
 	if len(req.KnowledgeBaseIDs) > 0 {
 		for _, kbID := range req.KnowledgeBaseIDs {
-			// 验证知识库存在
+			// 验证知识库存在且当前用户可以使用
 			var kb models.KnowledgeBase
-			if err := tx.First(&kb, "id = ?", kbID).Error; err != nil {
+			if err := tx.Scopes(accessibleKnowledgeBases(userID)).First(&kb, "id = ?", kbID).Error; err != nil {
 				tx.Rollback()
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					return nil, ErrKnowledgeBaseNotFound
@@ -267,6 +287,14 @@ func (s *Service) UpdateAgent(id uuid.UUID, req models.UpdateAgentRequest, userI
 		updates["max_history_length"] = req.MaxHistoryLength
 	}
 
+	if req.MemoryConfig != nil {
+		if _, err := coreAgent.ParseMemoryConfig(req.MemoryConfig); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMemoryConfig, err)
+		}
+		updates["memory_config"] = req.MemoryConfig
+	}
+
 	// 更新智能体
 	if len(updates) > 0 {
 		if err := tx.Model(&agent).Updates(updates).Error; err != nil {
@@ -287,9 +315,9 @@ func (s *Service) UpdateAgent(id uuid.UUID, req models.UpdateAgentRequest, userI
 
 		// 添加新关联
 		for _, kbID := range req.KnowledgeBaseIDs {
-			// 验证知识库存在
+			// 验证知识库存在且当前用户可以使用
 			var kb models.KnowledgeBase
-			if err := tx.First(&kb, "id = ?", kbID).Error; err != nil {
+			if err := tx.Scopes(accessibleKnowledgeBases(userID)).First(&kb, "id = ?", kbID).Error; err != nil {
 				tx.Rollback()
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					return nil, ErrKnowledgeBaseNotFound
@@ -497,3 +525,49 @@ func (s *Service) GetAgents(userID uuid.UUID, page, pageSize int, searchQuery st
 	
 	return response, total, nil
 } 
+
+// Orchestrate 在应用下的智能体之间执行多智能体编排
+func (s *Service) Orchestrate(ctx context.Context, appID uuid.UUID, req models.OrchestrateAgentsRequest, userID uuid.UUID) (*coreAgent.OrchestrationResult, error) {
+	var application models.Application
+	if err := s.db.First(&application, "id = ?", appID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrApplicationNotFound
+		}
+		s.logger.Error("Failed to find application", zap.Error(err))
+		return nil, err
+	}
+
+	if application.CreatedBy != userID {
+		return nil, ErrUnauthorized
+	}
+
+	result, err := s.orchestrator.Run(ctx, coreAgent.OrchestrationRequest{
+		ApplicationID: appID,
+		EntryAgentID:  req.EntryAgentID,
+		Mode:          coreAgent.OrchestrationMode(req.Mode),
+		Input:         req.Input,
+		Scratchpad:    req.Scratchpad,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, coreAgent.ErrAgentNotInApplication):
+			return nil, ErrAgentNotInApplication
+		case errors.Is(err, coreAgent.ErrMaxHandoffsExceeded), errors.Is(err, coreAgent.ErrMaxAgentCallsExceeded):
+			return nil, ErrOrchestrationLimit
+		case errors.Is(err, coreAgent.ErrApprovalRequired):
+			return nil, ErrApprovalNotSupported
+		}
+		s.logger.Error("Failed to orchestrate agents", zap.Error(err), zap.String("app_id", appID.String()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// accessibleKnowledgeBases 限定为用户创建的或属于其所有项目的知识库
+func accessibleKnowledgeBases(userID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("created_by = ? OR project_id IN (?)", userID,
+			db.Session(&gorm.Session{NewDB: true}).Model(&models.Project{}).Select("id").Where("owner_id = ?", userID))
+	}
+}
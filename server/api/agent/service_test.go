@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestService 创建使用测试数据库的智能体服务
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t,
+		&models.Project{},
+		&models.Application{},
+		&models.Agent{},
+		&models.ModelConfig{},
+		&models.KnowledgeBase{},
+	)
+	orchestrator := coreAgent.NewOrchestrator(coreAgent.NewAgentLoader(db, nil, nil), coreAgent.OrchestratorConfig{})
+	return NewService(db, orchestrator), db
+}
+
+func createTestApplication(t *testing.T, db *gorm.DB, userID uuid.UUID) *models.Application {
+	t.Helper()
+	application := models.Application{
+		Name:        "test",
+		Type:        "chat",
+		ProjectID:   uuid.New(),
+		Config:      "{}",
+		ModelConfig: "{}",
+		CreatedBy:   userID,
+	}
+	if err := db.Create(&application).Error; err != nil {
+		t.Fatalf("create application: %v", err)
+	}
+	return &application
+}
+
+func createTestAgent(t *testing.T, db *gorm.DB, applicationID uuid.UUID) *models.Agent {
+	t.Helper()
+	agent := models.Agent{Name: "test", ApplicationID: applicationID, ModelConfigID: uuid.New()}
+	if err := db.Create(&agent).Error; err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	return &agent
+}
+
+func TestOrchestrate(t *testing.T) {
+	service, db := newTestService(t)
+	ownerID := uuid.New()
+	application := createTestApplication(t, db, ownerID)
+	createTestAgent(t, db, application.ID)
+	outsider := createTestAgent(t, db, createTestApplication(t, db, ownerID).ID)
+
+	tests := []struct {
+		name    string
+		appID   uuid.UUID
+		userID  uuid.UUID
+		entryID uuid.UUID
+		wantErr error
+	}{
+		{"unknown application", uuid.New(), ownerID, outsider.ID, ErrApplicationNotFound},
+		{"application of another user", application.ID, uuid.New(), outsider.ID, ErrUnauthorized},
+		{"entry agent outside application", application.ID, ownerID, outsider.ID, ErrAgentNotInApplication},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.Orchestrate(context.Background(), tt.appID, models.OrchestrateAgentsRequest{
+				EntryAgentID: tt.entryID,
+				Input:        "hello",
+			}, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Orchestrate error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAgentMemoryConfig(t *testing.T) {
+	service, db := newTestService(t)
+	ownerID := uuid.New()
+	application := createTestApplication(t, db, ownerID)
+	agent := createTestAgent(t, db, application.ID)
+	invalid := models.JSONMap{"strategy": "forever"}
+
+	if _, err := service.CreateAgent(application.ID, models.CreateAgentRequest{
+		Name:          "test",
+		ModelConfigID: uuid.New(),
+		MemoryConfig:  invalid,
+	}, ownerID); !errors.Is(err, ErrInvalidMemoryConfig) {
+		t.Errorf("CreateAgent with invalid memory config error = %v, want %v", err, ErrInvalidMemoryConfig)
+	}
+	if _, err := service.UpdateAgent(agent.ID, models.UpdateAgentRequest{MemoryConfig: invalid}, ownerID); !errors.Is(err, ErrInvalidMemoryConfig) {
+		t.Errorf("UpdateAgent with invalid memory config error = %v, want %v", err, ErrInvalidMemoryConfig)
+	}
+	if _, err := service.UpdateAgent(agent.ID, models.UpdateAgentRequest{
+		MemoryConfig: models.JSONMap{"strategy": "token_window"},
+	}, uuid.New()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("UpdateAgent by another user error = %v, want %v", err, ErrUnauthorized)
+	}
+
+	if _, err := service.UpdateAgent(agent.ID, models.UpdateAgentRequest{
+		MemoryConfig: models.JSONMap{"strategy": "token_window"},
+	}, ownerID); err != nil {
+		t.Fatalf("UpdateAgent: %v", err)
+	}
+	var stored models.Agent
+	if err := db.First(&stored, "id = ?", agent.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.MemoryConfig["strategy"] != "token_window" {
+		t.Errorf("stored memory config = %v, want token_window", stored.MemoryConfig)
+	}
+}
@@ -0,0 +1,267 @@
+package agentrun
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// streamPollInterval 流式接口轮询新步骤的间隔
+const streamPollInterval = 500 * time.Millisecond
+
+// Handler 处理智能体运行相关的HTTP请求
+type Handler struct {
+	service        *Service
+	authMiddleware *middleware.AuthMiddleware
+	logger         *zap.Logger
+}
+
+// NewHandler 创建新的运行处理器
+func NewHandler(service *Service, authMiddleware *middleware.AuthMiddleware) *Handler {
+	return &Handler{
+		service:        service,
+		authMiddleware: authMiddleware,
+		logger:         zap.L().With(zap.String("handler", "agent_run")),
+	}
+}
+
+// RegisterRoutes 注册运行相关的路由
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// 智能体下的运行
+	agentRuns := router.Group("/agents/by-id/:agent_id/runs")
+	agentRuns.Use(h.authMiddleware.Authenticate())
+	{
+		agentRuns.POST("", h.StartRun)
+	}
+
+	// 运行操作
+	runs := router.Group("/runs")
+	runs.Use(h.authMiddleware.Authenticate())
+	{
+		runs.GET("/:id", h.GetRun)
+		runs.GET("/:id/steps", h.GetRunSteps)
+		runs.GET("/:id/stream", h.StreamRun)
+		runs.POST("/:id/cancel", h.CancelRun)
+		runs.POST("/:id/resume", h.ResumeRun)
+	}
+}
+
+// StartRun 启动一次异步运行
+func (h *Handler) StartRun(c *gin.Context) {
+	// 从URL获取智能体ID
+	agentIDStr := c.Param("agent_id")
+	agentID, err := uuid.Parse(agentIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的智能体ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.StartAgentRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	run, err := h.service.StartRun(agentID, req, userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, ErrAgentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "智能体不存在"})
+			return
+		}
+		if errors.Is(err, ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "对话不存在"})
+			return
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
+			return
+		}
+		if errors.Is(err, ErrApprovalPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": "对话中有等待审批的工具调用，请先处理"})
+			return
+		}
+		h.logger.Error("Failed to start run", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "启动运行失败"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// GetRun 获取运行状态
+func (h *Handler) GetRun(c *gin.Context) {
+	id, userID, ok := h.parseRunRequest(c)
+	if !ok {
+		return
+	}
+
+	run, err := h.service.GetRun(id, userID)
+	if err != nil {
+		h.handleError(c, err, "获取运行失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// GetRunSteps 获取运行步骤，after 参数用于增量拉取
+func (h *Handler) GetRunSteps(c *gin.Context) {
+	id, userID, ok := h.parseRunRequest(c)
+	if !ok {
+		return
+	}
+
+	after, _ := strconv.Atoi(c.DefaultQuery("after", "0"))
+
+	steps, err := h.service.GetRunSteps(id, after, userID)
+	if err != nil {
+		h.handleError(c, err, "获取运行步骤失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"steps": steps})
+}
+
+// StreamRun 以SSE方式推送运行步骤，直到运行结束或暂停等待审批
+func (h *Handler) StreamRun(c *gin.Context) {
+	id, userID, ok := h.parseRunRequest(c)
+	if !ok {
+		return
+	}
+
+	// 先检查权限，避免建立流后才返回错误
+	if _, err := h.service.GetRun(id, userID); err != nil {
+		h.handleError(c, err, "获取运行失败")
+		return
+	}
+
+	after, _ := strconv.Atoi(c.DefaultQuery("after", "0"))
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		// 先读取状态再读取步骤：结束前写入的步骤一定能在同一轮读到
+		run, err := h.service.GetRun(id, userID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "获取运行失败"})
+			return false
+		}
+
+		steps, err := h.service.GetRunSteps(id, after, userID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "获取运行步骤失败"})
+			return false
+		}
+		for _, step := range steps {
+			c.SSEvent(step.Type, step)
+			after = step.Sequence
+		}
+
+		status := coreAgent.RunStatus(run.Status)
+		if len(steps) == 0 && (status.IsTerminal() || status == coreAgent.RunStatusWaitingApproval) {
+			c.SSEvent("end", run)
+			return false
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			return true
+		}
+	})
+}
+
+// CancelRun 取消运行
+func (h *Handler) CancelRun(c *gin.Context) {
+	id, userID, ok := h.parseRunRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.CancelRun(id, userID); err != nil {
+		h.handleError(c, err, "取消运行失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ResumeRun 提交审批决定并恢复运行
+func (h *Handler) ResumeRun(c *gin.Context) {
+	id, userID, ok := h.parseRunRequest(c)
+	if !ok {
+		return
+	}
+
+	// 解析请求体
+	var req models.ResumeAgentRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	run, err := h.service.ResumeRun(id, req, userID)
+	if err != nil {
+		h.handleError(c, err, "恢复运行失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// parseRunRequest 解析运行ID和当前用户
+func (h *Handler) parseRunRequest(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	// 从URL获取运行ID
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的运行ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return id, userID.(uuid.UUID), true
+}
+
+// handleError 将服务错误转换为HTTP响应
+func (h *Handler) handleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrRunNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "运行不存在"})
+	case errors.Is(err, ErrUnauthorized):
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此运行"})
+	case errors.Is(err, ErrRunFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "运行已结束"})
+	case errors.Is(err, ErrRunNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "运行未处于等待审批状态"})
+	case errors.Is(err, ErrInvalidDecision):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Agent run request failed", zap.Error(err), zap.String("path", c.FullPath()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,134 @@
+package agentrun
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"gorm.io/gorm"
+)
+
+// newStreamServer 启动只注册流式接口的测试服务器，请求以 userID 的身份认证
+func newStreamServer(t *testing.T, service *Service, userID uuid.UUID) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	handler := NewHandler(service, nil)
+
+	router := gin.New()
+	router.GET("/runs/:id/stream", func(c *gin.Context) {
+		c.Set("user_id", userID)
+	}, handler.StreamRun)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// readEvents 读取SSE响应中的事件名，直到流结束
+func readEvents(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "event:") {
+			events = append(events, strings.TrimPrefix(line, "event:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func createTestSteps(t *testing.T, db *gorm.DB, runID uuid.UUID, from int, types ...string) {
+	t.Helper()
+	for i, stepType := range types {
+		if err := db.Create(&models.AgentRunStep{RunID: runID, Sequence: from + i, Type: stepType}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStreamRun(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID := uuid.New()
+	server := newStreamServer(t, service, userID)
+
+	completed := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusCompleted)})
+	createTestSteps(t, db, completed.ID, 1, "thinking", "tool_call", "response")
+	waiting := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusWaitingApproval)})
+	createTestSteps(t, db, waiting.ID, 1, "approval_required")
+	foreign := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: uuid.New(), Status: string(coreAgent.RunStatusCompleted)})
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantEvents []string
+	}{
+		{"completed run", "/runs/" + completed.ID.String() + "/stream", http.StatusOK,
+			[]string{"thinking", "tool_call", "response", "end"}},
+		{"resume after sequence", "/runs/" + completed.ID.String() + "/stream?after=2", http.StatusOK,
+			[]string{"response", "end"}},
+		{"waiting for approval", "/runs/" + waiting.ID.String() + "/stream", http.StatusOK,
+			[]string{"approval_required", "end"}},
+		{"run of another user", "/runs/" + foreign.ID.String() + "/stream", http.StatusForbidden, nil},
+		{"unknown run", "/runs/" + uuid.NewString() + "/stream", http.StatusNotFound, nil},
+		{"invalid run id", "/runs/invalid/stream", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantEvents == nil {
+				return
+			}
+			if got := readEvents(t, resp); strings.Join(got, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("events = %v, want %v", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestStreamRunFollowsRunningRun(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID := uuid.New()
+	server := newStreamServer(t, service, userID)
+
+	run := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusRunning)})
+	createTestSteps(t, db, run.ID, 1, "thinking")
+
+	// 流建立后运行继续产生步骤并结束
+	go func() {
+		time.Sleep(streamPollInterval / 2)
+		db.Create(&models.AgentRunStep{RunID: run.ID, Sequence: 2, Type: "response"})
+		db.Model(&models.AgentRun{}).Where("id = ?", run.ID).Update("status", string(coreAgent.RunStatusCompleted))
+	}()
+
+	resp, err := http.Get(server.URL + "/runs/" + run.ID.String() + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	want := []string{"thinking", "response", "end"}
+	if got := readEvents(t, resp); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package agentrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRunNotFound          = errors.New("运行不存在")
+	ErrAgentNotFound        = errors.New("智能体不存在")
+	ErrConversationNotFound = errors.New("对话不存在")
+	ErrUnauthorized         = errors.New("无权访问此资源")
+	ErrRunFinished          = errors.New("运行已结束")
+	ErrRunNotPending        = errors.New("运行未处于等待审批状态")
+	ErrApprovalPending      = errors.New("对话中有等待审批的工具调用")
+	ErrInvalidDecision      = errors.New("审批决定无效")
+)
+
+// Service 提供智能体异步运行相关功能
+type Service struct {
+	db         *gorm.DB
+	runManager *coreAgent.RunManager
+	logger     *zap.Logger
+}
+
+// NewService 创建新的运行服务
+func NewService(db *gorm.DB, runManager *coreAgent.RunManager) *Service {
+	return &Service{
+		db:         db,
+		runManager: runManager,
+		logger:     zap.L().With(zap.String("service", "agent_run")),
+	}
+}
+
+// StartRun 启动一次异步运行
+func (s *Service) StartRun(agentID uuid.UUID, req models.StartAgentRunRequest, userID uuid.UUID) (*models.AgentRunResponse, error) {
+	// 检查智能体是否存在
+	var agent models.Agent
+	if err := s.db.First(&agent, "id = ?", agentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAgentNotFound
+		}
+		s.logger.Error("Failed to find agent", zap.Error(err))
+		return nil, err
+	}
+
+	// 在对话中运行时检查对话归属
+	var guard coreAgent.MessageGuard
+	if req.ConversationID != nil {
+		var conversation models.Conversation
+		if err := s.db.First(&conversation, "id = ?", *req.ConversationID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrConversationNotFound
+			}
+			s.logger.Error("Failed to find conversation", zap.Error(err))
+			return nil, err
+		}
+		if conversation.UserID != userID || conversation.AgentID != agentID {
+			return nil, ErrUnauthorized
+		}
+
+		// 审批处理前不能在对话中追加新的输入，否则历史中的工具调用缺少工具结果。
+		// 检查与创建运行在同一事务中并持有对话行锁
+		guard = func(tx *gorm.DB) error {
+			return s.ensureNoPendingApproval(tx, conversation.ID)
+		}
+	}
+
+	run := models.AgentRun{
+		AgentID:        agentID,
+		ConversationID: req.ConversationID,
+		UserID:         userID,
+		Input:          req.Input,
+	}
+
+	if err := s.runManager.Submit(context.Background(), &run, guard); err != nil {
+		if errors.Is(err, ErrApprovalPending) {
+			return nil, ErrApprovalPending
+		}
+		s.logger.Error("Failed to submit agent run", zap.Error(err))
+		return nil, err
+	}
+
+	response := run.ToResponse()
+	return &response, nil
+}
+
+// GetRun 获取运行状态
+func (s *Service) GetRun(id uuid.UUID, userID uuid.UUID) (*models.AgentRunResponse, error) {
+	run, err := s.findRun(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := run.ToResponse()
+	return &response, nil
+}
+
+// GetRunSteps 获取运行中序号大于 after 的步骤
+func (s *Service) GetRunSteps(id uuid.UUID, after int, userID uuid.UUID) ([]models.AgentRunStep, error) {
+	if _, err := s.findRun(id, userID); err != nil {
+		return nil, err
+	}
+
+	var steps []models.AgentRunStep
+	if err := s.db.Order("sequence asc").Where("run_id = ? AND sequence > ?", id, after).Find(&steps).Error; err != nil {
+		s.logger.Error("Failed to find run steps", zap.Error(err))
+		return nil, err
+	}
+
+	return steps, nil
+}
+
+// CancelRun 取消运行
+func (s *Service) CancelRun(id uuid.UUID, userID uuid.UUID) error {
+	if _, err := s.findRun(id, userID); err != nil {
+		return err
+	}
+
+	if err := s.runManager.Cancel(context.Background(), id); err != nil {
+		if errors.Is(err, coreAgent.ErrRunFinished) {
+			return ErrRunFinished
+		}
+		s.logger.Error("Failed to cancel run", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ResumeRun 提交审批决定并恢复运行
+func (s *Service) ResumeRun(id uuid.UUID, req models.ResumeAgentRunRequest, userID uuid.UUID) (*models.AgentRunResponse, error) {
+	if _, err := s.findRun(id, userID); err != nil {
+		return nil, err
+	}
+
+	decisions := make([]coreAgent.ApprovalDecision, len(req.Decisions))
+	for i, d := range req.Decisions {
+		decisions[i] = coreAgent.ApprovalDecision{
+			ToolCallID: d.ToolCallID,
+			Action:     coreAgent.ApprovalAction(d.Action),
+			Arguments:  d.Arguments,
+			Reason:     d.Reason,
+		}
+	}
+
+	if err := s.runManager.Resume(context.Background(), id, decisions); err != nil {
+		if errors.Is(err, coreAgent.ErrRunNotPending) {
+			return nil, ErrRunNotPending
+		}
+		if errors.Is(err, coreAgent.ErrInvalidApprovalDecision) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDecision, err)
+		}
+		s.logger.Error("Failed to resume run", zap.Error(err))
+		return nil, err
+	}
+
+	return s.GetRun(id, userID)
+}
+
+// ensureNoPendingApproval 检查对话中没有等待审批的运行或工具调用
+func (s *Service) ensureNoPendingApproval(tx *gorm.DB, conversationID uuid.UUID) error {
+	var pending int64
+	if err := tx.Model(&models.AgentRun{}).
+		Where("conversation_id = ? AND status = ?", conversationID, string(coreAgent.RunStatusWaitingApproval)).
+		Count(&pending).Error; err != nil {
+		s.logger.Error("Failed to count runs waiting for approval", zap.Error(err))
+		return err
+	}
+	if pending == 0 {
+		if err := tx.Model(&models.ToolApproval{}).
+			Where("conversation_id = ? AND status = ?", conversationID, string(coreAgent.ApprovalStatusPending)).
+			Count(&pending).Error; err != nil {
+			s.logger.Error("Failed to count pending approvals", zap.Error(err))
+			return err
+		}
+	}
+	if pending > 0 {
+		return ErrApprovalPending
+	}
+	return nil
+}
+
+// findRun 查找运行并检查用户权限
+func (s *Service) findRun(id uuid.UUID, userID uuid.UUID) (*models.AgentRun, error) {
+	var run models.AgentRun
+	if err := s.db.First(&run, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRunNotFound
+		}
+		s.logger.Error("Failed to find run", zap.Error(err))
+		return nil, err
+	}
+
+	// 检查用户权限
+	if run.UserID != userID {
+		return nil, ErrUnauthorized
+	}
+
+	return &run, nil
+}
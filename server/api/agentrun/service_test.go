@@ -0,0 +1,252 @@
+package agentrun
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestService 创建使用测试数据库的运行服务，运行管理器不启动工作协程
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t,
+		&models.Agent{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.ToolApproval{},
+		&models.AgentRun{},
+		&models.AgentRunStep{},
+	)
+	runManager := coreAgent.NewRunManager(db, nil, coreAgent.RunManagerConfig{})
+	return NewService(db, runManager), db
+}
+
+func createTestAgent(t *testing.T, db *gorm.DB) *models.Agent {
+	t.Helper()
+	agent := models.Agent{Name: "test", ApplicationID: uuid.New(), ModelConfigID: uuid.New()}
+	if err := db.Create(&agent).Error; err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	return &agent
+}
+
+func createTestConversation(t *testing.T, db *gorm.DB, agentID, userID uuid.UUID) *models.Conversation {
+	t.Helper()
+	conversation := models.Conversation{AgentID: agentID, UserID: userID, Title: "test"}
+	if err := db.Create(&conversation).Error; err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	return &conversation
+}
+
+func createTestRun(t *testing.T, db *gorm.DB, run models.AgentRun) *models.AgentRun {
+	t.Helper()
+	if run.Input == "" {
+		run.Input = "hello"
+	}
+	if err := db.Create(&run).Error; err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	return &run
+}
+
+func TestStartRun(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	otherAgent := createTestAgent(t, db)
+	userID := uuid.New()
+
+	own := createTestConversation(t, db, agent.ID, userID)
+	otherUsers := createTestConversation(t, db, agent.ID, uuid.New())
+	otherAgents := createTestConversation(t, db, otherAgent.ID, userID)
+
+	waitingRun := createTestConversation(t, db, agent.ID, userID)
+	createTestRun(t, db, models.AgentRun{
+		AgentID: agent.ID, ConversationID: &waitingRun.ID, UserID: userID,
+		Status: string(coreAgent.RunStatusWaitingApproval),
+	})
+
+	pendingApproval := createTestConversation(t, db, agent.ID, userID)
+	if err := coreAgent.NewGormApprovalStore(db).Save(context.Background(), &coreAgent.ToolApprovalState{
+		ID:             uuid.NewString(),
+		AgentID:        agent.ID.String(),
+		ConversationID: pendingApproval.ID.String(),
+		Status:         coreAgent.ApprovalStatusPending,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := uuid.New()
+	tests := []struct {
+		name           string
+		agentID        uuid.UUID
+		conversationID *uuid.UUID
+		wantErr        error
+	}{
+		{"without conversation", agent.ID, nil, nil},
+		{"own conversation", agent.ID, &own.ID, nil},
+		{"unknown agent", uuid.New(), nil, ErrAgentNotFound},
+		{"unknown conversation", agent.ID, &missing, ErrConversationNotFound},
+		{"conversation of another user", agent.ID, &otherUsers.ID, ErrUnauthorized},
+		{"conversation of another agent", agent.ID, &otherAgents.ID, ErrUnauthorized},
+		{"run waiting for approval", agent.ID, &waitingRun.ID, ErrApprovalPending},
+		{"pending tool approval", agent.ID, &pendingApproval.ID, ErrApprovalPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run, err := service.StartRun(tt.agentID, models.StartAgentRunRequest{
+				Input:          "hello",
+				ConversationID: tt.conversationID,
+			}, userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StartRun error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if run.Status != string(coreAgent.RunStatusQueued) {
+				t.Errorf("status = %s, want %s", run.Status, coreAgent.RunStatusQueued)
+			}
+		})
+	}
+}
+
+func TestRunOwnership(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	run := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: ownerID, Status: string(coreAgent.RunStatusQueued)})
+
+	resume := models.ResumeAgentRunRequest{Decisions: []models.ToolApprovalDecision{{ToolCallID: "c1", Action: "approve"}}}
+	calls := map[string]func(id, userID uuid.UUID) error{
+		"GetRun": func(id, userID uuid.UUID) error {
+			_, err := service.GetRun(id, userID)
+			return err
+		},
+		"GetRunSteps": func(id, userID uuid.UUID) error {
+			_, err := service.GetRunSteps(id, 0, userID)
+			return err
+		},
+		"CancelRun": func(id, userID uuid.UUID) error {
+			return service.CancelRun(id, userID)
+		},
+		"ResumeRun": func(id, userID uuid.UUID) error {
+			_, err := service.ResumeRun(id, resume, userID)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(run.ID, uuid.New()); !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("%s by another user error = %v, want %v", name, err, ErrUnauthorized)
+			}
+			if err := call(uuid.New(), ownerID); !errors.Is(err, ErrRunNotFound) {
+				t.Errorf("%s of unknown run error = %v, want %v", name, err, ErrRunNotFound)
+			}
+		})
+	}
+
+	var stored models.AgentRun
+	if err := db.First(&stored, "id = ?", run.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != string(coreAgent.RunStatusQueued) {
+		t.Errorf("status after rejected calls = %s, want %s", stored.Status, coreAgent.RunStatusQueued)
+	}
+}
+
+func TestGetRunSteps(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID := uuid.New()
+	run := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusRunning)})
+	for i := 1; i <= 3; i++ {
+		if err := db.Create(&models.AgentRunStep{RunID: run.ID, Sequence: i, Type: "message"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps, err := service.GetRunSteps(run.ID, 1, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Sequence != 2 || steps[1].Sequence != 3 {
+		t.Errorf("steps after 1 = %+v, want sequences 2 and 3", steps)
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID := uuid.New()
+
+	queued := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusQueued)})
+	if err := service.CancelRun(queued.ID, userID); err != nil {
+		t.Fatalf("CancelRun: %v", err)
+	}
+	run, err := service.GetRun(queued.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != string(coreAgent.RunStatusCancelled) {
+		t.Errorf("status = %s, want %s", run.Status, coreAgent.RunStatusCancelled)
+	}
+
+	completed := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusCompleted)})
+	if err := service.CancelRun(completed.ID, userID); !errors.Is(err, ErrRunFinished) {
+		t.Errorf("CancelRun of completed run error = %v, want %v", err, ErrRunFinished)
+	}
+}
+
+func TestResumeRun(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID := uuid.New()
+
+	approvalID := uuid.New()
+	if err := coreAgent.NewGormApprovalStore(db).Save(context.Background(), &coreAgent.ToolApprovalState{
+		ID:      approvalID.String(),
+		AgentID: agent.ID.String(),
+		Status:  coreAgent.ApprovalStatusPending,
+		Pending: []coreAgent.PendingToolCall{{ToolCallID: "c1", ToolName: "write"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waiting := createTestRun(t, db, models.AgentRun{
+		AgentID: agent.ID, UserID: userID, ApprovalID: &approvalID,
+		Status: string(coreAgent.RunStatusWaitingApproval),
+	})
+	queued := createTestRun(t, db, models.AgentRun{AgentID: agent.ID, UserID: userID, Status: string(coreAgent.RunStatusQueued)})
+
+	decisions := func(ids ...string) models.ResumeAgentRunRequest {
+		req := models.ResumeAgentRunRequest{}
+		for _, id := range ids {
+			req.Decisions = append(req.Decisions, models.ToolApprovalDecision{ToolCallID: id, Action: "approve"})
+		}
+		return req
+	}
+
+	if _, err := service.ResumeRun(queued.ID, decisions("c1"), userID); !errors.Is(err, ErrRunNotPending) {
+		t.Errorf("ResumeRun of queued run error = %v, want %v", err, ErrRunNotPending)
+	}
+	if _, err := service.ResumeRun(waiting.ID, decisions("c9"), userID); !errors.Is(err, ErrInvalidDecision) {
+		t.Errorf("ResumeRun with unknown tool call error = %v, want %v", err, ErrInvalidDecision)
+	}
+
+	run, err := service.ResumeRun(waiting.ID, decisions("c1"), userID)
+	if err != nil {
+		t.Fatalf("ResumeRun: %v", err)
+	}
+	if run.Status != string(coreAgent.RunStatusQueued) {
+		t.Errorf("status = %s, want %s", run.Status, coreAgent.RunStatusQueued)
+	}
+	if _, err := service.ResumeRun(waiting.ID, decisions("c1"), userID); !errors.Is(err, ErrRunNotPending) {
+		t.Errorf("second ResumeRun error = %v, want %v", err, ErrRunNotPending)
+	}
+}
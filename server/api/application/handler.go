@@ -33,6 +33,7 @@ func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
 		applications.PUT("/:id", h.UpdateApplication)
 		applications.DELETE("/:id", h.DeleteApplication)
 		applications.GET("/project/:project_id", h.GetApplicationsByProject)
+		applications.POST("/:id/workflow/run", h.RunWorkflow)
 	}
 }
 
@@ -56,6 +57,9 @@ func (h *Handler) CreateApplication(c *gin.Context) {
 		} else if errors.Is(err, ErrNoPermission) {
 			status = http.StatusForbidden
 			errMsg = "没有权限在此项目下创建应用"
+		} else if errors.Is(err, ErrInvalidWorkflow) {
+			status = http.StatusBadRequest
+			errMsg = err.Error()
 		}
 
 		c.JSON(status, gin.H{"error": errMsg})
@@ -120,6 +124,9 @@ func (h *Handler) UpdateApplication(c *gin.Context) {
 		} else if errors.Is(err, ErrNoPermission) {
 			status = http.StatusForbidden
 			errMsg = "没有权限修改此应用"
+		} else if errors.Is(err, ErrInvalidWorkflow) {
+			status = http.StatusBadRequest
+			errMsg = err.Error()
 		}
 
 		c.JSON(status, gin.H{"error": errMsg})
@@ -186,3 +193,44 @@ func (h *Handler) GetApplicationsByProject(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"applications": applications})
 }
+
+// RunWorkflow 处理执行工作流请求
+func (h *Handler) RunWorkflow(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的应用ID"})
+		return
+	}
+
+	var req RunWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	result, err := h.service.RunWorkflow(id, req, userID.(uuid.UUID))
+	if err != nil {
+		status := http.StatusInternalServerError
+		errMsg := "执行工作流失败"
+
+		if errors.Is(err, ErrApplicationNotFound) {
+			status = http.StatusNotFound
+			errMsg = "应用不存在"
+		} else if errors.Is(err, ErrNoPermission) {
+			status = http.StatusForbidden
+			errMsg = "没有权限执行此应用"
+		} else if errors.Is(err, ErrNotWorkflow) {
+			status = http.StatusBadRequest
+			errMsg = "应用不是工作流类型"
+		} else if errors.Is(err, ErrInvalidWorkflow) {
+			status = http.StatusBadRequest
+			errMsg = err.Error()
+		}
+
+		c.JSON(status, gin.H{"error": errMsg})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
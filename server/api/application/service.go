@@ -1,29 +1,39 @@
 package application
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/workflow"
 	"github.com/zhuiye8/Lyss/server/models"
 	"gorm.io/gorm"
 )
 
+// workflowTimeout 单次工作流执行的时间上限
+const workflowTimeout = 10 * time.Minute
+
 var (
 	ErrApplicationNotFound = errors.New("应用不存在")
 	ErrProjectNotFound     = errors.New("项目不存在")
 	ErrNoPermission        = errors.New("没有操作权限")
+	ErrInvalidWorkflow     = errors.New("工作流定义无效")
+	ErrNotWorkflow         = errors.New("应用不是工作流类型")
 )
 
 // Service 提供应用管理功能
 type Service struct {
-	db *gorm.DB
+	db     *gorm.DB
+	engine *workflow.Engine
 }
 
 // NewService 创建新的应用服务
-func NewService(db *gorm.DB) *Service {
+func NewService(db *gorm.DB, engine *workflow.Engine) *Service {
 	return &Service{
-		db: db,
+		db:     db,
+		engine: engine,
 	}
 }
 
@@ -58,6 +68,10 @@ func (s *Service) CreateApplication(req CreateApplicationRequest, userID uuid.UU
 		return nil, ErrNoPermission
 	}
 
+	if err := validateConfig(req.Type, req.Config); err != nil {
+		return nil, err
+	}
+
 	application := models.Application{
 		Name:        req.Name,
 		Description: req.Description,
@@ -122,6 +136,20 @@ func (s *Service) UpdateApplication(id uuid.UUID, req UpdateApplicationRequest,
 		return nil, ErrNoPermission
 	}
 
+	// 类型或配置变化时重新校验工作流定义
+	if req.Type != "" || req.Config != "" {
+		appType, config := application.Type, application.Config
+		if req.Type != "" {
+			appType = req.Type
+		}
+		if req.Config != "" {
+			config = req.Config
+		}
+		if err := validateConfig(appType, config); err != nil {
+			return nil, err
+		}
+	}
+
 	// 更新应用字段
 	updates := map[string]interface{}{}
 	
@@ -217,4 +245,56 @@ func (s *Service) GetApplicationsByProject(projectID uuid.UUID, userID uuid.UUID
 	}
 	
 	return responses, nil
-} 
+}
+
+// RunWorkflowRequest 执行工作流请求
+type RunWorkflowRequest struct {
+	Inputs map[string]interface{} `json:"inputs"`
+}
+
+// RunWorkflow 执行工作流应用并返回输出与各节点的执行记录
+func (s *Service) RunWorkflow(id uuid.UUID, req RunWorkflowRequest, userID uuid.UUID) (*workflow.Result, error) {
+	application, err := s.GetApplicationByID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if application.Type != "workflow" {
+		return nil, ErrNotWorkflow
+	}
+
+	def, err := workflow.ParseDefinition(application.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWorkflow, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), workflowTimeout)
+	defer cancel()
+
+	result, err := s.engine.Execute(ctx, def, req.Inputs, userID)
+	if err != nil {
+		if errors.Is(err, workflow.ErrInvalidDefinition) || errors.Is(err, workflow.ErrMissingInput) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidWorkflow, err)
+		}
+		return nil, fmt.Errorf("执行工作流失败: %w", err)
+	}
+
+	return result, nil
+}
+
+// validateConfig 校验工作流应用的配置
+func validateConfig(appType, config string) error {
+	if appType != "workflow" || config == "" {
+		return nil
+	}
+
+	def, err := workflow.ParseDefinition(config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWorkflow, err)
+	}
+	if err := def.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWorkflow, err)
+	}
+
+	return nil
+}
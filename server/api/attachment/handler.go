@@ -0,0 +1,145 @@
+package attachment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// Handler 处理附件相关的HTTP请求
+type Handler struct {
+	service        *Service
+	authMiddleware *middleware.AuthMiddleware
+	logger         *zap.Logger
+}
+
+// NewHandler 创建新的附件处理器
+func NewHandler(service *Service, authMiddleware *middleware.AuthMiddleware) *Handler {
+	return &Handler{
+		service:        service,
+		authMiddleware: authMiddleware,
+		logger:         zap.L().With(zap.String("handler", "attachment")),
+	}
+}
+
+// RegisterRoutes 注册附件相关的路由
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	attachments := router.Group("/attachments")
+	attachments.Use(h.authMiddleware.Authenticate())
+	{
+		attachments.POST("", h.UploadAttachment)
+		attachments.GET("/:id", h.DownloadAttachment)
+		attachments.DELETE("/:id", h.DeleteAttachment)
+	}
+}
+
+// UploadAttachment 上传附件，表单字段 file 为文件，parse=true 时解析文档内容
+func (h *Handler) UploadAttachment(c *gin.Context) {
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少上传文件"})
+		return
+	}
+	if fileHeader.Size > h.service.config.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "文件过大"})
+		return
+	}
+	parse, _ := strconv.ParseBool(c.DefaultPostForm("parse", "false"))
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer file.Close()
+
+	// 调用服务
+	attachment, err := h.service.Upload(c.Request.Context(), userID.(uuid.UUID), fileHeader.Filename, file, parse)
+	if err != nil {
+		h.handleError(c, err, "上传附件失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// DownloadAttachment 下载附件
+func (h *Handler) DownloadAttachment(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	attachment, reader, err := h.service.Open(c.Request.Context(), id, userID)
+	if err != nil {
+		h.handleError(c, err, "读取附件失败")
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf("inline; filename*=UTF-8''%s", url.PathEscape(attachment.FileName)),
+	})
+}
+
+// DeleteAttachment 删除附件
+func (h *Handler) DeleteAttachment(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(c.Request.Context(), id, userID); err != nil {
+		h.handleError(c, err, "删除附件失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "附件已删除"})
+}
+
+// parseRequest 解析URL中的附件ID和当前用户
+func (h *Handler) parseRequest(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return id, userID.(uuid.UUID), true
+}
+
+// handleError 将服务错误转换为HTTP响应
+func (h *Handler) handleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrAttachmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "附件不存在"})
+	case errors.Is(err, ErrUnauthorized):
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此附件"})
+	case errors.Is(err, ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "文件过大"})
+	default:
+		h.logger.Error("Attachment request failed", zap.Error(err), zap.String("path", c.FullPath()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,490 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/api/config"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/storage"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMaxSize        = 20 << 20 // 20MB
+	defaultMaxParsedRunes = 50000
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("附件不存在")
+	ErrUnauthorized       = errors.New("无权访问此附件")
+	ErrFileTooLarge       = errors.New("文件过大")
+	ErrAttachmentInUse    = errors.New("附件已用于其他对话")
+	ErrVisionUnsupported  = errors.New("当前模型不支持图片，且未配置视觉模型")
+)
+
+// Config 附件服务配置
+type Config struct {
+	LocalDir       string // 本地存储的根目录
+	MaxSize        int64  // 单个文件大小上限（字节）
+	MaxParsedRunes int    // 文档解析后注入上下文的最大字符数
+}
+
+// Service 管理消息附件的上传、存储与读取，存储提供商由系统设置决定
+type Service struct {
+	db       *gorm.DB
+	settings *config.Service
+	config   Config
+	logger   *zap.Logger
+}
+
+// NewService 创建新的附件服务
+func NewService(db *gorm.DB, settings *config.Service, cfg Config) *Service {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxSize
+	}
+	if cfg.MaxParsedRunes <= 0 {
+		cfg.MaxParsedRunes = defaultMaxParsedRunes
+	}
+	return &Service{
+		db:       db,
+		settings: settings,
+		config:   cfg,
+		logger:   zap.L().With(zap.String("service", "attachment")),
+	}
+}
+
+// Upload 保存上传的文件，parse 为 true 时解析文档文本供对话使用
+func (s *Service) Upload(ctx context.Context, userID uuid.UUID, fileName string, reader io.Reader, parse bool) (*models.AttachmentResponse, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, s.config.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.config.MaxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	settings, err := s.settings.GetSystemSettings()
+	if err != nil {
+		return nil, err
+	}
+	store, err := s.storageFor(settings.StorageProvider)
+	if err != nil {
+		s.logger.Error("Failed to create storage", zap.Error(err))
+		return nil, err
+	}
+
+	fileName = filepath.Base(fileName)
+	contentType := detectContentType(fileName, data)
+	attachment := models.Attachment{
+		ID:              uuid.New(),
+		UserID:          userID,
+		FileName:        fileName,
+		ContentType:     contentType,
+		Size:            int64(len(data)),
+		Kind:            attachmentKind(contentType),
+		StorageProvider: settings.StorageProvider,
+	}
+	if attachment.StorageProvider == "" {
+		attachment.StorageProvider = storage.ProviderLocal
+	}
+	attachment.StorageKey = fmt.Sprintf("attachments/%s/%s%s", userID, attachment.ID, strings.ToLower(filepath.Ext(fileName)))
+
+	if parse && attachment.Kind == models.AttachmentKindDocument {
+		attachment.ExtractedText = s.extractText(data)
+	}
+
+	if err := store.Put(ctx, attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		s.logger.Error("Failed to store attachment", zap.Error(err))
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(&attachment).Error; err != nil {
+		s.logger.Error("Failed to create attachment", zap.Error(err))
+		if deleteErr := store.Delete(ctx, attachment.StorageKey); deleteErr != nil {
+			s.logger.Warn("Failed to remove stored attachment", zap.Error(deleteErr))
+		}
+		return nil, err
+	}
+
+	response := attachment.ToResponse()
+	return &response, nil
+}
+
+// Open 读取附件内容，调用方负责关闭
+func (s *Service) Open(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Attachment, io.ReadCloser, error) {
+	attachment, err := s.find(ctx, id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := s.open(ctx, attachment)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, nil, ErrAttachmentNotFound
+		}
+		s.logger.Error("Failed to read attachment", zap.Error(err))
+		return nil, nil, err
+	}
+	return attachment, reader, nil
+}
+
+// Delete 删除附件及存储中的文件
+func (s *Service) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	attachment, err := s.find(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, []models.Attachment{*attachment})
+}
+
+// DeleteForConversation 删除对话中的全部附件
+func (s *Service) DeleteForConversation(ctx context.Context, conversationID uuid.UUID) error {
+	var attachments []models.Attachment
+	if err := s.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Find(&attachments).Error; err != nil {
+		return err
+	}
+	return s.remove(ctx, attachments)
+}
+
+// PrepareForMessage 校验随消息发送的附件属于当前用户且未用于其他对话，并将其绑定到对话
+func (s *Service) PrepareForMessage(ctx context.Context, ids []uuid.UUID, userID uuid.UUID, conversationID uuid.UUID) ([]models.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var attachments []models.Attachment
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]models.Attachment, len(attachments))
+	for _, attachment := range attachments {
+		byID[attachment.ID] = attachment
+	}
+
+	ordered := make([]models.Attachment, 0, len(ids))
+	for _, id := range ids {
+		attachment, ok := byID[id]
+		if !ok {
+			return nil, ErrAttachmentNotFound
+		}
+		if attachment.UserID != userID {
+			return nil, ErrUnauthorized
+		}
+		if attachment.ConversationID != nil && *attachment.ConversationID != conversationID {
+			return nil, ErrAttachmentInUse
+		}
+		ordered = append(ordered, attachment)
+	}
+
+	// 条件更新防止并发请求把同一附件绑定到其他对话，更新行数不足说明附件已被占用，此时整体回滚
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Attachment{}).
+			Where("id IN ? AND user_id = ? AND (conversation_id IS NULL OR conversation_id = ?)", ids, userID, conversationID).
+			Update("conversation_id", conversationID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(byID)) {
+			return ErrAttachmentInUse
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
+
+// SandboxFiles 返回对话中的附件，代码解释器将其以只读方式放在 input/ 目录。
+// 文件内容在执行代码时才从存储中读取，同名文件追加序号
+func (s *Service) SandboxFiles(ctx context.Context, conversationID uuid.UUID, userID uuid.UUID) ([]coreAgent.SandboxFile, error) {
+	var attachments []models.Attachment
+	if err := s.db.WithContext(ctx).Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Order("created_at").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+
+	files := make([]coreAgent.SandboxFile, 0, len(attachments))
+	taken := make(map[string]bool, len(attachments))
+	for i := range attachments {
+		attachment := &attachments[i]
+		name := uniqueSandboxName(filepath.Base(attachment.FileName), taken)
+		taken[name] = true
+
+		files = append(files, coreAgent.SandboxFile{
+			Name: name,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				return s.open(ctx, attachment)
+			},
+		})
+	}
+	return files, nil
+}
+
+// uniqueSandboxName 文件名已被占用时追加序号，如 data_2.csv
+func uniqueSandboxName(name string, taken map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+// HasImages 附件中是否包含图片
+func HasImages(attachments []models.Attachment) bool {
+	for _, attachment := range attachments {
+		if attachment.Kind == models.AttachmentKindImage {
+			return true
+		}
+	}
+	return false
+}
+
+// Find 按ID批量查询用户的附件，忽略不存在或属于其他用户的ID
+func (s *Service) Find(ctx context.Context, ids []string, userID uuid.UUID) ([]models.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var attachments []models.Attachment
+	if err := s.db.WithContext(ctx).Where("id IN ? AND user_id = ?", ids, userID).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// VisionModelConfig 消息包含图片时选择模型：智能体的模型支持视觉时返回 nil，
+// 否则返回系统设置中的视觉模型配置ID，未配置时返回 ErrVisionUnsupported
+func (s *Service) VisionModelConfig(ctx context.Context, agent *models.Agent) (*uuid.UUID, error) {
+	var modelConfig models.ModelConfig
+	if err := s.db.WithContext(ctx).First(&modelConfig, "id = ?", agent.ModelConfigID).Error; err != nil {
+		return nil, err
+	}
+	// Model 也有 model_id 列，Preload 会把关联误判为 has one，因此按ID单独查询
+	var model models.Model
+	if err := s.db.WithContext(ctx).First(&model, "id = ?", modelConfig.ModelID).Error; err != nil {
+		return nil, err
+	}
+	if model.SupportsVision() {
+		return nil, nil
+	}
+
+	settings, err := s.settings.GetSystemSettings()
+	if err != nil {
+		return nil, err
+	}
+	if settings.VisionModel == "" {
+		return nil, ErrVisionUnsupported
+	}
+	visionID, err := uuid.Parse(settings.VisionModel)
+	if err != nil {
+		s.logger.Warn("Invalid vision model setting", zap.String("value", settings.VisionModel))
+		return nil, ErrVisionUnsupported
+	}
+	return &visionID, nil
+}
+
+// Resolver 返回只还原指定用户附件的解析器，供对话记忆读取历史时使用
+func (s *Service) Resolver(userID uuid.UUID) coreAgent.AttachmentResolver {
+	return &userResolver{service: s, userID: userID}
+}
+
+// userResolver 绑定用户的附件解析器
+type userResolver struct {
+	service *Service
+	userID  uuid.UUID
+}
+
+// ResolveAttachments 实现 coreAgent.AttachmentResolver
+func (r *userResolver) ResolveAttachments(ctx context.Context, ids []string, withImages bool) ([]eino.Content, error) {
+	return r.service.ResolveAttachments(ctx, ids, withImages, r.userID)
+}
+
+// ResolveAttachments 将消息引用的用户附件还原为模型输入：解析过的文档作为文本，图片按需读取原始数据，其余附件只提供文件名
+func (s *Service) ResolveAttachments(ctx context.Context, ids []string, withImages bool, userID uuid.UUID) ([]eino.Content, error) {
+	attachments, err := s.Find(ctx, ids, userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Attachment, len(attachments))
+	for _, attachment := range attachments {
+		byID[attachment.ID.String()] = attachment
+	}
+
+	contents := make([]eino.Content, 0, len(ids))
+	for _, id := range ids {
+		attachment, ok := byID[id]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case attachment.Kind == models.AttachmentKindImage && withImages:
+			data, err := s.readAll(ctx, &attachment)
+			if err != nil {
+				return contents, err
+			}
+			contents = append(contents, eino.Content{
+				Type:      eino.ContentTypeImage,
+				ImageData: &eino.ImageData{Data: data},
+			})
+		case attachment.ExtractedText != "":
+			contents = append(contents, eino.Content{
+				Type: eino.ContentTypeText,
+				Text: fmt.Sprintf("附件《%s》内容：\n%s", attachment.FileName, attachment.ExtractedText),
+			})
+		default:
+			contents = append(contents, eino.Content{
+				Type: eino.ContentTypeText,
+				Text: fmt.Sprintf("[附件：%s（%s）]", attachment.FileName, attachment.ContentType),
+			})
+		}
+	}
+	return contents, nil
+}
+
+func (s *Service) find(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.db.WithContext(ctx).First(&attachment, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		s.logger.Error("Failed to find attachment", zap.Error(err))
+		return nil, err
+	}
+	if attachment.UserID != userID {
+		return nil, ErrUnauthorized
+	}
+	return &attachment, nil
+}
+
+func (s *Service) open(ctx context.Context, attachment *models.Attachment) (io.ReadCloser, error) {
+	store, err := s.storageFor(attachment.StorageProvider)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(ctx, attachment.StorageKey)
+}
+
+func (s *Service) readAll(ctx context.Context, attachment *models.Attachment) ([]byte, error) {
+	reader, err := s.open(ctx, attachment)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// remove 删除附件记录，存储中的文件删除失败只记录日志
+func (s *Service) remove(ctx context.Context, attachments []models.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(attachments))
+	for i, attachment := range attachments {
+		ids[i] = attachment.ID
+	}
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Delete(&models.Attachment{}).Error; err != nil {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		store, err := s.storageFor(attachment.StorageProvider)
+		if err == nil {
+			err = store.Delete(ctx, attachment.StorageKey)
+		}
+		if err != nil {
+			s.logger.Warn("Failed to delete stored attachment",
+				zap.String("attachment_id", attachment.ID.String()), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// storageFor 按提供商创建存储，S3配置取自当前系统设置
+func (s *Service) storageFor(provider string) (storage.Storage, error) {
+	cfg := storage.Config{Provider: provider, LocalDir: s.config.LocalDir}
+	if provider == storage.ProviderS3 {
+		settings, err := s.settings.GetSystemSettings()
+		if err != nil {
+			return nil, err
+		}
+		secretKey, err := s.settings.GetS3SecretKey()
+		if err != nil {
+			return nil, err
+		}
+		cfg.S3 = storage.S3Config{
+			Endpoint:  settings.S3Config.Endpoint,
+			Bucket:    settings.S3Config.Bucket,
+			Region:    settings.S3Config.Region,
+			AccessKey: settings.S3Config.AccessKey,
+			SecretKey: secretKey,
+			UseSSL:    settings.S3Config.UseSSL,
+		}
+	}
+	return storage.New(cfg)
+}
+
+// extractText 解析文本类文档，超过上限时截断
+func (s *Service) extractText(data []byte) string {
+	if !utf8.Valid(data) {
+		return ""
+	}
+	text := strings.TrimSpace(string(data))
+	if utf8.RuneCountInString(text) > s.config.MaxParsedRunes {
+		text = string([]rune(text)[:s.config.MaxParsedRunes]) + "\n…（内容过长已截断）"
+	}
+	return text
+}
+
+// detectContentType 优先按扩展名判断类型，无法判断时根据文件内容识别
+func detectContentType(fileName string, data []byte) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))); contentType != "" {
+		return contentType
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".md", ".markdown":
+		return "text/markdown; charset=utf-8"
+	case ".csv":
+		return "text/csv; charset=utf-8"
+	}
+	return http.DetectContentType(data)
+}
+
+// attachmentKind 根据内容类型划分附件类型，文本类内容可解析为文档
+func attachmentKind(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return models.AttachmentKindImage
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/xml",
+		mediaType == "application/x-yaml",
+		mediaType == "application/yaml":
+		return models.AttachmentKindDocument
+	default:
+		return models.AttachmentKindFile
+	}
+}
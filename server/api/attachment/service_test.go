@@ -0,0 +1,341 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/api/config"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+func TestAttachmentKind(t *testing.T) {
+	tests := []struct {
+		fileName string
+		data     []byte
+		wantKind string
+	}{
+		{"photo.png", pngHeader, models.AttachmentKindImage},
+		{"config.json", []byte(`{"a":1}`), models.AttachmentKindDocument},
+		{"notes", []byte("plain notes"), models.AttachmentKindDocument},
+		{"README.md", []byte("# title"), models.AttachmentKindDocument},
+		{"report.pdf", []byte("%PDF-1.4"), models.AttachmentKindFile},
+		{"blob", []byte{0x00, 0x01, 0x02}, models.AttachmentKindFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			contentType := detectContentType(tt.fileName, tt.data)
+			if got := attachmentKind(contentType); got != tt.wantKind {
+				t.Errorf("attachmentKind(%q) = %s, want %s", contentType, got, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestUniqueSandboxName(t *testing.T) {
+	taken := map[string]bool{}
+	var got []string
+	for _, name := range []string{"data.csv", "data.csv", "data.csv", "notes"} {
+		unique := uniqueSandboxName(name, taken)
+		taken[unique] = true
+		got = append(got, unique)
+	}
+	if want := []string{"data.csv", "data_2.csv", "data_3.csv", "notes"}; !equalStrings(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	service := NewService(nil, nil, Config{MaxParsedRunes: 5})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"short", []byte("  你好  "), "你好"},
+		{"truncated", []byte("一二三四五六七"), "一二三四五\n…（内容过长已截断）"},
+		{"binary", []byte{0xff, 0xfe}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := service.extractText(tt.data); got != tt.want {
+				t.Errorf("extractText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestService 创建使用测试数据库和本地临时目录的附件服务
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t,
+		&models.Attachment{},
+		&models.Config{},
+		&models.Model{},
+		&models.ModelConfig{},
+	)
+	return NewService(db, config.NewService(db), Config{LocalDir: t.TempDir(), MaxSize: 64}), db
+}
+
+func upload(t *testing.T, service *Service, userID uuid.UUID, fileName string, data []byte, parse bool) *models.AttachmentResponse {
+	t.Helper()
+	attachment, err := service.Upload(context.Background(), userID, fileName, bytes.NewReader(data), parse)
+	if err != nil {
+		t.Fatalf("upload %s: %v", fileName, err)
+	}
+	return attachment
+}
+
+func TestUploadOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	service, db := newTestService(t)
+	ownerID := uuid.New()
+
+	if _, err := service.Upload(ctx, ownerID, "big", bytes.NewReader(make([]byte, 65)), false); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Upload of oversized file error = %v, want %v", err, ErrFileTooLarge)
+	}
+
+	parsed := upload(t, service, ownerID, "../../notes", []byte("meeting notes"), true)
+	if parsed.FileName != "notes" || parsed.Kind != models.AttachmentKindDocument || !parsed.Parsed {
+		t.Errorf("parsed upload = %+v, want parsed document named notes", parsed)
+	}
+	if raw := upload(t, service, ownerID, "notes", []byte("meeting notes"), false); raw.Parsed {
+		t.Error("upload without parse was parsed")
+	}
+
+	attachment, reader, err := service.Open(ctx, parsed.ID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "meeting notes" || attachment.StorageProvider != "local" {
+		t.Errorf("Open = %q from %s, want stored content from local storage", data, attachment.StorageProvider)
+	}
+
+	errTests := []struct {
+		name    string
+		id      uuid.UUID
+		userID  uuid.UUID
+		wantErr error
+	}{
+		{"unknown attachment", uuid.New(), ownerID, ErrAttachmentNotFound},
+		{"other user", parsed.ID, uuid.New(), ErrUnauthorized},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := service.Open(ctx, tt.id, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Open error = %v, want %v", err, tt.wantErr)
+			}
+			if err := service.Delete(ctx, tt.id, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := service.Delete(ctx, parsed.ID, ownerID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&models.Attachment{}).Where("id = ?", parsed.ID).Count(&count)
+	if count != 0 {
+		t.Error("attachment record kept after delete")
+	}
+	store, err := service.storageFor(attachment.StorageProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, attachment.StorageKey); err == nil {
+		t.Error("stored file kept after delete")
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService(t)
+	ownerID := uuid.New()
+	uploaded := upload(t, service, ownerID, "notes", []byte("notes"), false)
+
+	attachment, err := service.find(ctx, uploaded.ID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := service.storageFor(attachment.StorageProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, attachment.StorageKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := service.Open(ctx, uploaded.ID, ownerID); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("Open of missing file error = %v, want %v", err, ErrAttachmentNotFound)
+	}
+}
+
+func TestPrepareForMessage(t *testing.T) {
+	ctx := context.Background()
+	service, db := newTestService(t)
+	ownerID := uuid.New()
+	conversationID := uuid.New()
+	first := upload(t, service, ownerID, "a", []byte("a"), false)
+	second := upload(t, service, ownerID, "b", []byte("b"), false)
+	foreign := upload(t, service, uuid.New(), "c", []byte("c"), false)
+	used := upload(t, service, ownerID, "d", []byte("d"), false)
+	if _, err := service.PrepareForMessage(ctx, []uuid.UUID{used.ID}, ownerID, uuid.New()); err != nil {
+		t.Fatal(err)
+	}
+
+	errTests := []struct {
+		name    string
+		ids     []uuid.UUID
+		wantErr error
+	}{
+		{"unknown attachment", []uuid.UUID{first.ID, uuid.New()}, ErrAttachmentNotFound},
+		{"other user's attachment", []uuid.UUID{first.ID, foreign.ID}, ErrUnauthorized},
+		{"used in another conversation", []uuid.UUID{first.ID, used.ID}, ErrAttachmentInUse},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.PrepareForMessage(ctx, tt.ids, ownerID, conversationID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("PrepareForMessage error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	var bound int64
+	db.Model(&models.Attachment{}).Where("conversation_id = ?", conversationID).Count(&bound)
+	if bound != 0 {
+		t.Errorf("%d attachments bound by rejected requests, want 0", bound)
+	}
+
+	// 附件按请求顺序返回，同一对话中可以再次引用
+	for i := 0; i < 2; i++ {
+		attachments, err := service.PrepareForMessage(ctx, []uuid.UUID{second.ID, first.ID}, ownerID, conversationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(attachments) != 2 || attachments[0].ID != second.ID || attachments[1].ID != first.ID {
+			t.Fatalf("attachments = %+v, want b then a", attachments)
+		}
+	}
+
+	files, err := service.SandboxFiles(ctx, conversationID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d sandbox files, want 2", len(files))
+	}
+	if others, err := service.SandboxFiles(ctx, conversationID, uuid.New()); err != nil || len(others) != 0 {
+		t.Errorf("SandboxFiles of another user = %d files, %v, want none", len(others), err)
+	}
+
+	if err := service.DeleteForConversation(ctx, conversationID); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.Attachment{}).Where("conversation_id = ?", conversationID).Count(&bound)
+	if bound != 0 {
+		t.Errorf("%d attachments kept after DeleteForConversation, want 0", bound)
+	}
+}
+
+func TestResolveAttachments(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService(t)
+	ownerID := uuid.New()
+	image := upload(t, service, ownerID, "photo.png", pngHeader, false)
+	document := upload(t, service, ownerID, "notes", []byte("meeting notes"), true)
+	file := upload(t, service, ownerID, "blob", []byte{0x00, 0x01}, false)
+	foreign := upload(t, service, uuid.New(), "secret", []byte("secret"), true)
+
+	ids := []string{image.ID.String(), foreign.ID.String(), document.ID.String(), file.ID.String()}
+	contents, err := service.Resolver(ownerID).ResolveAttachments(ctx, ids, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 3 {
+		t.Fatalf("got %d contents, want 3 without the other user's attachment", len(contents))
+	}
+	if contents[0].Type != eino.ContentTypeImage || contents[0].ImageData == nil || !bytes.Equal(contents[0].ImageData.Data, pngHeader) {
+		t.Errorf("image content = %+v, want image data", contents[0])
+	}
+	if !strings.Contains(contents[1].Text, "meeting notes") {
+		t.Errorf("document content = %q, want extracted text", contents[1].Text)
+	}
+	if !strings.Contains(contents[2].Text, "blob") || strings.Contains(contents[2].Text, "\x00") {
+		t.Errorf("file content = %q, want file name only", contents[2].Text)
+	}
+
+	contents, err = service.ResolveAttachments(ctx, ids[:1], false, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 1 || contents[0].Type != eino.ContentTypeText || !strings.Contains(contents[0].Text, "photo.png") {
+		t.Errorf("image without vision = %+v, want file name placeholder", contents)
+	}
+}
+
+func TestVisionModelConfig(t *testing.T) {
+	ctx := context.Background()
+	service, db := newTestService(t)
+
+	agentWithModel := func(name string, modelType models.ModelType, capabilities ...string) *models.Agent {
+		model := models.Model{Name: name, Provider: "openai", ModelID: name, Type: modelType, Capabilities: capabilities, MaxTokens: 1000}
+		if err := db.Create(&model).Error; err != nil {
+			t.Fatal(err)
+		}
+		modelConfig := models.ModelConfig{Name: name, ModelID: model.ID, OrganizationID: uuid.New(), CreatedBy: uuid.New()}
+		if err := db.Create(&modelConfig).Error; err != nil {
+			t.Fatal(err)
+		}
+		return &models.Agent{ModelConfigID: modelConfig.ID}
+	}
+	vision := agentWithModel("vision", models.ModelTypeText, models.ModelCapabilityVision)
+	multimodal := agentWithModel("multimodal", models.ModelTypeMultimodal)
+	textOnly := agentWithModel("text", models.ModelTypeText, "function_calling")
+
+	for _, agent := range []*models.Agent{vision, multimodal} {
+		if id, err := service.VisionModelConfig(ctx, agent); err != nil || id != nil {
+			t.Errorf("VisionModelConfig of vision model = %v, %v, want nil", id, err)
+		}
+	}
+	if _, err := service.VisionModelConfig(ctx, textOnly); !errors.Is(err, ErrVisionUnsupported) {
+		t.Errorf("VisionModelConfig without setting error = %v, want %v", err, ErrVisionUnsupported)
+	}
+
+	setting := models.Config{Key: "model.vision", Value: "not-a-uuid", Scope: models.ScopeSystem}
+	if err := db.Create(&setting).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.VisionModelConfig(ctx, textOnly); !errors.Is(err, ErrVisionUnsupported) {
+		t.Errorf("VisionModelConfig with invalid setting error = %v, want %v", err, ErrVisionUnsupported)
+	}
+
+	visionID := vision.ModelConfigID
+	if err := db.Model(&setting).Update("value", visionID.String()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if id, err := service.VisionModelConfig(ctx, textOnly); err != nil || id == nil || *id != visionID {
+		t.Errorf("VisionModelConfig = %v, %v, want %s", id, err, visionID)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
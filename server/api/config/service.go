@@ -43,12 +43,15 @@ type UpdateSystemSettingsRequest struct {
 	AllowRegistration bool   `json:"allowRegistration"`
 	DefaultLanguage   string `json:"defaultLanguage"`
 	DefaultModel      string `json:"defaultModel"`
+	VisionModel       string `json:"visionModel"` // 智能体模型不支持图像时改用的模型配置ID
 	StorageProvider   string `json:"storageProvider"`
 	S3Config          struct {
+		Endpoint  string `json:"endpoint"` // S3兼容服务地址，例如 MinIO，为空时使用 AWS S3
 		Bucket    string `json:"bucket"`
 		Region    string `json:"region"`
 		AccessKey string `json:"accessKey"`
 		SecretKey string `json:"secretKey"`
+		UseSSL    bool   `json:"useSsl"`
 	} `json:"s3Config"`
 	EmailSettings struct {
 		SMTPServer   string `json:"smtpServer"`
@@ -57,6 +60,7 @@ type UpdateSystemSettingsRequest struct {
 		SMTPPassword string `json:"smtpPassword"`
 		SenderEmail  string `json:"senderEmail"`
 	} `json:"emailSettings"`
+	AutoTitle AutoTitleSettings `json:"autoTitle"`
 }
 
 // SystemSettings 系统设置响应
@@ -67,12 +71,15 @@ type SystemSettings struct {
 	AllowRegistration bool   `json:"allowRegistration"`
 	DefaultLanguage   string `json:"defaultLanguage"`
 	DefaultModel      string `json:"defaultModel"`
+	VisionModel       string `json:"visionModel"` // 智能体模型不支持图像时改用的模型配置ID
 	StorageProvider   string `json:"storageProvider"`
 	S3Config          struct {
+		Endpoint  string `json:"endpoint"` // S3兼容服务地址，例如 MinIO，为空时使用 AWS S3
 		Bucket    string `json:"bucket"`
 		Region    string `json:"region"`
 		AccessKey string `json:"accessKey"`
 		SecretKey string `json:"secretKey"`
+		UseSSL    bool   `json:"useSsl"`
 	} `json:"s3Config"`
 	EmailSettings struct {
 		SMTPServer   string `json:"smtpServer"`
@@ -81,6 +88,13 @@ type SystemSettings struct {
 		SMTPPassword string `json:"smtpPassword,omitempty"`
 		SenderEmail  string `json:"senderEmail"`
 	} `json:"emailSettings"`
+	AutoTitle AutoTitleSettings `json:"autoTitle"`
+}
+
+// AutoTitleSettings 对话自动命名设置，首轮对话后由指定模型生成标题、标签并识别语言
+type AutoTitleSettings struct {
+	Enabled       bool   `json:"enabled"`
+	ModelConfigID string `json:"modelConfigId"`
 }
 
 // UpsertConfig 创建或更新配置
@@ -328,6 +342,12 @@ func (s *Service) GetSystemSettings() (*SystemSettings, error) {
 			settings.S3Config.Bucket = config.Value
 		case "storage.s3.region":
 			settings.S3Config.Region = config.Value
+		case "model.vision":
+			settings.VisionModel = config.Value
+		case "storage.s3.endpoint":
+			settings.S3Config.Endpoint = config.Value
+		case "storage.s3.use_ssl":
+			settings.S3Config.UseSSL = config.Value == "true"
 		case "storage.s3.access_key":
 			settings.S3Config.AccessKey = config.Value
 		case "email.smtp_server":
@@ -340,12 +360,29 @@ func (s *Service) GetSystemSettings() (*SystemSettings, error) {
 			settings.EmailSettings.SMTPUser = config.Value
 		case "email.sender":
 			settings.EmailSettings.SenderEmail = config.Value
+		case "conversation.auto_title.enabled":
+			settings.AutoTitle.Enabled = config.Value == "true"
+		case "conversation.auto_title.model_config_id":
+			settings.AutoTitle.ModelConfigID = config.Value
 		}
 	}
 	
 	return settings, nil
 }
 
+// GetS3SecretKey 获取S3密钥，系统设置响应中不包含该值，仅供服务端访问存储使用
+func (s *Service) GetS3SecretKey() (string, error) {
+	var config models.Config
+	err := s.db.Where("key = ? AND scope = ? AND scope_id IS NULL", "storage.s3.secret_key", models.ScopeSystem).First(&config).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return config.Value, nil
+}
+
 // UpdateSystemSettings 更新系统设置
 func (s *Service) UpdateSystemSettings(req UpdateSystemSettingsRequest) error {
 	// 更新系统设置
@@ -392,6 +429,13 @@ func (s *Service) UpdateSystemSettings(req UpdateSystemSettingsRequest) error {
 		}
 	}
 	
+	if req.VisionModel != "" {
+		if err := s.setConfig(tx, "model.vision", req.VisionModel, models.ScopeSystem, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	
 	if req.StorageProvider != "" {
 		if err := s.setConfig(tx, "storage.provider", req.StorageProvider, models.ScopeSystem, nil); err != nil {
 			tx.Rollback()
@@ -401,6 +445,18 @@ func (s *Service) UpdateSystemSettings(req UpdateSystemSettingsRequest) error {
 	
 	// 更新S3配置
 	if req.StorageProvider == "s3" {
+		if req.S3Config.Endpoint != "" {
+			if err := s.setConfig(tx, "storage.s3.endpoint", req.S3Config.Endpoint, models.ScopeSystem, nil); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		
+		if err := s.setConfig(tx, "storage.s3.use_ssl", strconv.FormatBool(req.S3Config.UseSSL), models.ScopeSystem, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+		
 		if req.S3Config.Bucket != "" {
 			if err := s.setConfig(tx, "storage.s3.bucket", req.S3Config.Bucket, models.ScopeSystem, nil); err != nil {
 				tx.Rollback()
@@ -466,6 +522,19 @@ func (s *Service) UpdateSystemSettings(req UpdateSystemSettingsRequest) error {
 		}
 	}
 	
+	// 更新对话自动命名设置
+	if err := s.setConfig(tx, "conversation.auto_title.enabled", strconv.FormatBool(req.AutoTitle.Enabled), models.ScopeSystem, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	
+	if req.AutoTitle.ModelConfigID != "" {
+		if err := s.setConfig(tx, "conversation.auto_title.model_config_id", req.AutoTitle.ModelConfigID, models.ScopeSystem, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	
 	return tx.Commit().Error
 }
 
@@ -0,0 +1,453 @@
+package conversation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 导出格式
+const (
+	ExportFormatJSON     = "json"     // 带元数据的结构化JSON，可重新导入
+	ExportFormatMarkdown = "markdown" // 便于阅读的Markdown
+	ExportFormatJSONL    = "jsonl"    // OpenAI 对话微调格式，每行一个对话
+)
+
+// maxExportConversations 单次导出或导入的对话数上限
+const maxExportConversations = 1000
+
+var (
+	ErrUnsupportedFormat = errors.New("不支持的导出格式")
+	ErrInvalidImport     = errors.New("导入数据格式错误")
+	ErrInvalidFilter     = errors.New("无效的筛选条件")
+)
+
+// ExportFilter 批量导出的筛选条件
+type ExportFilter struct {
+	AgentID  *uuid.UUID
+	From     *time.Time
+	To       *time.Time
+	Feedback string // positive：有好评且没有差评的对话；negative：有差评的对话
+}
+
+// ExportConversation 按指定格式导出单个对话
+func (s *Service) ExportConversation(id uuid.UUID, userID uuid.UUID, format string) ([]byte, error) {
+	conversation, err := s.findConversation(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exported, err := s.exportConversations([]models.Conversation{*conversation})
+	if err != nil {
+		return nil, err
+	}
+	return encodeExport(exported, format)
+}
+
+// ExportConversations 按筛选条件导出当前用户的对话
+func (s *Service) ExportConversations(filter ExportFilter, userID uuid.UUID, format string) ([]byte, error) {
+	query := s.db.Preload("Agent").Where("user_id = ?", userID)
+	if filter.AgentID != nil {
+		query = query.Where("agent_id = ?", *filter.AgentID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	switch filter.Feedback {
+	case "":
+	case "positive":
+		query = query.
+			Where("EXISTS (SELECT 1 FROM messages WHERE messages.conversation_id = conversations.id AND messages.feedback = 'positive')").
+			Where("NOT EXISTS (SELECT 1 FROM messages WHERE messages.conversation_id = conversations.id AND messages.feedback = 'negative')")
+	case "negative":
+		query = query.Where("EXISTS (SELECT 1 FROM messages WHERE messages.conversation_id = conversations.id AND messages.feedback = 'negative')")
+	default:
+		return nil, ErrInvalidFilter
+	}
+
+	var conversations []models.Conversation
+	if err := query.Order("created_at asc").Limit(maxExportConversations).Find(&conversations).Error; err != nil {
+		s.logger.Error("Failed to find conversations for export", zap.Error(err))
+		return nil, err
+	}
+
+	exported, err := s.exportConversations(conversations)
+	if err != nil {
+		return nil, err
+	}
+	return encodeExport(exported, format)
+}
+
+// ImportConversations 将导出的对话导入到指定智能体下，用于准备评测数据
+func (s *Service) ImportConversations(agentID uuid.UUID, userID uuid.UUID, format string, data []byte) (*models.ImportConversationsResponse, error) {
+	var agent models.Agent
+	if err := s.db.First(&agent, "id = ?", agentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAgentNotFound
+		}
+		s.logger.Error("Failed to find agent", zap.Error(err))
+		return nil, err
+	}
+
+	var imported []models.ExportedConversation
+	var err error
+	switch format {
+	case ExportFormatJSON:
+		imported, err = decodeJSONExport(data)
+	case ExportFormatJSONL:
+		imported, err = decodeFineTuneJSONL(data)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(imported) == 0 || len(imported) > maxExportConversations {
+		return nil, fmt.Errorf("%w: conversation count must be between 1 and %d", ErrInvalidImport, maxExportConversations)
+	}
+
+	response := &models.ImportConversationsResponse{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, source := range imported {
+			conversation, err := importConversation(tx, agent, userID, source)
+			if err != nil {
+				return err
+			}
+			response.Conversations = append(response.Conversations, conversation.ToResponse())
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidImport) {
+			return nil, err
+		}
+		s.logger.Error("Failed to import conversations", zap.Error(err))
+		return nil, err
+	}
+
+	response.Imported = len(response.Conversations)
+	return response, nil
+}
+
+// exportConversations 读取对话当前分支上的消息
+func (s *Service) exportConversations(conversations []models.Conversation) ([]models.ExportedConversation, error) {
+	exported := make([]models.ExportedConversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		path, err := coreAgent.ActivePath(s.db, conversation.ID)
+		if err != nil {
+			s.logger.Error("Failed to load conversation messages", zap.Error(err))
+			return nil, err
+		}
+
+		item := models.ExportedConversation{
+			ID:        conversation.ID,
+			Title:     conversation.Title,
+			AgentID:   conversation.AgentID,
+			Status:    conversation.Status,
+			Metadata:  conversation.Metadata,
+			CreatedAt: conversation.CreatedAt,
+			UpdatedAt: conversation.UpdatedAt,
+			Messages:  make([]models.ExportedMessage, len(path)),
+		}
+		if conversation.Agent != nil {
+			item.AgentName = conversation.Agent.Name
+			item.SystemPrompt = conversation.Agent.SystemPrompt
+		}
+		for i, msg := range path {
+			item.Messages[i] = models.ExportedMessage{
+				ID:        msg.ID,
+				ParentID:  msg.ParentID,
+				Role:      msg.Role,
+				Content:   msg.Content,
+				Tokens:    msg.Tokens,
+				Feedback:  msg.Feedback,
+				Metadata:  msg.Metadata,
+				CreatedAt: msg.CreatedAt,
+			}
+		}
+		exported = append(exported, item)
+	}
+	return exported, nil
+}
+
+// encodeExport 将对话编码为指定格式
+func encodeExport(conversations []models.ExportedConversation, format string) ([]byte, error) {
+	switch format {
+	case ExportFormatJSON:
+		return json.MarshalIndent(models.ConversationExport{
+			Version:       models.ConversationExportVersion,
+			ExportedAt:    time.Now(),
+			Conversations: conversations,
+		}, "", "  ")
+	case ExportFormatMarkdown:
+		return encodeMarkdown(conversations), nil
+	case ExportFormatJSONL:
+		return encodeFineTuneJSONL(conversations)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// encodeMarkdown 将对话渲染为Markdown，多个对话之间以分隔线隔开
+func encodeMarkdown(conversations []models.ExportedConversation) []byte {
+	var buf bytes.Buffer
+	for i, conversation := range conversations {
+		if i > 0 {
+			buf.WriteString("\n---\n\n")
+		}
+		fmt.Fprintf(&buf, "# %s\n\n", conversation.Title)
+		fmt.Fprintf(&buf, "- 对话ID：%s\n", conversation.ID)
+		if conversation.AgentName != "" {
+			fmt.Fprintf(&buf, "- 智能体：%s\n", conversation.AgentName)
+		}
+		fmt.Fprintf(&buf, "- 创建时间：%s\n", conversation.CreatedAt.Format(time.RFC3339))
+
+		for _, exported := range conversation.Messages {
+			msg := coreAgent.RecordToMessage(models.Message{Role: exported.Role, Content: exported.Content, Metadata: exported.Metadata})
+			switch msg.Role {
+			case eino.RoleUser:
+				buf.WriteString("\n## 用户\n\n")
+			case eino.RoleAssistant:
+				buf.WriteString("\n## 助手\n\n")
+			case eino.RoleTool:
+				fmt.Fprintf(&buf, "\n## 工具结果（%s）\n\n", msg.ToolCallID)
+				fmt.Fprintf(&buf, "```\n%s\n```\n", msg.Content)
+				continue
+			default:
+				fmt.Fprintf(&buf, "\n## %s\n\n", msg.Role)
+			}
+
+			if msg.Content != "" {
+				buf.WriteString(msg.Content + "\n")
+			}
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&buf, "\n> 调用工具 `%s`（%s）：`%s`\n", call.Name, call.ID, call.Arguments)
+			}
+			switch exported.Feedback {
+			case "positive":
+				buf.WriteString("\n_👍 好评_\n")
+			case "negative":
+				buf.WriteString("\n_👎 差评_\n")
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// fineTuneExample OpenAI 对话微调数据中的一行
+type fineTuneExample struct {
+	Messages []fineTuneMessage `json:"messages"`
+}
+
+type fineTuneMessage struct {
+	Role       string             `json:"role"`
+	Content    string             `json:"content"`
+	ToolCalls  []fineTuneToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+	Weight     *int               `json:"weight,omitempty"` // 0 表示不学习该条助手回复
+}
+
+type fineTuneToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function fineTuneFunction `json:"function"`
+}
+
+type fineTuneFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// encodeFineTuneJSONL 按 OpenAI 对话微调格式输出，每个对话一行。
+// 对话以最后一条不含工具调用的助手回复结尾，差评的助手回复权重为0，没有助手回复的对话被跳过。
+func encodeFineTuneJSONL(conversations []models.ExportedConversation) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, conversation := range conversations {
+		var messages []fineTuneMessage
+		if conversation.SystemPrompt != "" {
+			messages = append(messages, fineTuneMessage{Role: eino.RoleSystem, Content: conversation.SystemPrompt})
+		}
+
+		end := -1
+		for _, exported := range conversation.Messages {
+			msg := coreAgent.RecordToMessage(models.Message{Role: exported.Role, Content: exported.Content, Metadata: exported.Metadata})
+			item := fineTuneMessage{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID}
+			for _, call := range msg.ToolCalls {
+				item.ToolCalls = append(item.ToolCalls, fineTuneToolCall{
+					ID:       call.ID,
+					Type:     "function",
+					Function: fineTuneFunction{Name: call.Name, Arguments: call.Arguments},
+				})
+			}
+			if msg.Role == eino.RoleAssistant && exported.Feedback == "negative" {
+				weight := 0
+				item.Weight = &weight
+			}
+			messages = append(messages, item)
+
+			if msg.Role == eino.RoleAssistant && len(msg.ToolCalls) == 0 {
+				end = len(messages)
+			}
+		}
+		if end < 0 {
+			continue
+		}
+
+		line, err := json.Marshal(fineTuneExample{Messages: messages[:end]})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeJSONExport 解析JSON格式的导出数据
+func decodeJSONExport(data []byte) ([]models.ExportedConversation, error) {
+	var export models.ConversationExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if export.Version > models.ConversationExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidImport, export.Version)
+	}
+	return export.Conversations, nil
+}
+
+// decodeFineTuneJSONL 解析 OpenAI 对话微调格式，系统消息保存到对话元数据中
+func decodeFineTuneJSONL(data []byte) ([]models.ExportedConversation, error) {
+	var conversations []models.ExportedConversation
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var example fineTuneExample
+		if err := json.Unmarshal([]byte(line), &example); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, lineNo, err)
+		}
+
+		conversation := models.ExportedConversation{Title: fmt.Sprintf("导入对话 %d", len(conversations)+1)}
+		for _, item := range example.Messages {
+			if item.Role == eino.RoleSystem {
+				conversation.SystemPrompt = item.Content
+				continue
+			}
+
+			msg := eino.Message{Role: item.Role, Content: item.Content, ToolCallID: item.ToolCallID}
+			for _, call := range item.ToolCalls {
+				msg.ToolCalls = append(msg.ToolCalls, eino.ToolCall{
+					ID:        call.ID,
+					Name:      call.Function.Name,
+					Arguments: call.Function.Arguments,
+				})
+			}
+			record, err := coreAgent.MessageToRecord(uuid.Nil, msg, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, lineNo, err)
+			}
+
+			exported := models.ExportedMessage{Role: record.Role, Content: record.Content, Metadata: record.Metadata}
+			if item.Weight != nil && *item.Weight == 0 {
+				exported.Feedback = "negative"
+			}
+			conversation.Messages = append(conversation.Messages, exported)
+		}
+		conversations = append(conversations, conversation)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return conversations, nil
+}
+
+// importConversation 创建对话并按顺序写入消息，消息组成一条分支
+func importConversation(tx *gorm.DB, agent models.Agent, userID uuid.UUID, source models.ExportedConversation) (*models.Conversation, error) {
+	metadata := models.JSONMap{}
+	for key, value := range source.Metadata {
+		metadata[key] = value
+	}
+	// 摘要按原对话的消息序号记录，导入后失效；导入标记由服务端重新生成
+	for _, key := range []string{"summary", "imported", "imported_from", "imported_system_prompt"} {
+		delete(metadata, key)
+	}
+	metadata["imported"] = true
+	if source.ID != uuid.Nil {
+		metadata["imported_from"] = source.ID.String()
+	}
+	if source.SystemPrompt != "" && source.SystemPrompt != agent.SystemPrompt {
+		metadata["imported_system_prompt"] = source.SystemPrompt
+	}
+
+	conversation := models.Conversation{
+		Title:    source.Title,
+		AgentID:  agent.ID,
+		UserID:   userID,
+		Status:   "active",
+		Metadata: metadata,
+	}
+	if conversation.Title == "" {
+		conversation.Title = "导入对话 " + time.Now().Format("2006-01-02 15:04:05")
+	}
+	if err := tx.Create(&conversation).Error; err != nil {
+		return nil, err
+	}
+
+	var parentID *uuid.UUID
+	for i, exported := range source.Messages {
+		switch exported.Role {
+		case eino.RoleUser, eino.RoleAssistant, eino.RoleTool:
+		default:
+			return nil, fmt.Errorf("%w: message %d has invalid role %q", ErrInvalidImport, i+1, exported.Role)
+		}
+		if exported.Feedback != "" && exported.Feedback != "positive" && exported.Feedback != "negative" {
+			return nil, fmt.Errorf("%w: message %d has invalid feedback %q", ErrInvalidImport, i+1, exported.Feedback)
+		}
+
+		// 只保留工具调用信息，附件、审批、运行等元数据引用的是服务端记录，不能从导入文件中获得
+		message, err := coreAgent.MessageToRecord(conversation.ID, coreAgent.RecordToMessage(models.Message{
+			Role:     exported.Role,
+			Content:  exported.Content,
+			Metadata: exported.Metadata,
+		}), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: message %d: %v", ErrInvalidImport, i+1, err)
+		}
+		message.ParentID = parentID
+		message.Tokens = exported.Tokens
+		message.Feedback = exported.Feedback
+		message.CreatedAt = exported.CreatedAt
+		if err := tx.Create(&message).Error; err != nil {
+			return nil, err
+		}
+		parentID = &message.ID
+	}
+
+	if parentID != nil {
+		if err := tx.Model(&conversation).Update("active_leaf_id", *parentID).Error; err != nil {
+			return nil, err
+		}
+		conversation.ActiveLeafID = parentID
+	}
+	conversation.Agent = &agent
+	return &conversation, nil
+}
@@ -0,0 +1,316 @@
+package conversation
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"gorm.io/gorm"
+)
+
+// createExportConversation 创建带工具调用的对话，feedback 非空时评价最后一条助手回复
+func createExportConversation(t *testing.T, db *gorm.DB, agentID, userID uuid.UUID, feedback string) *models.Conversation {
+	t.Helper()
+	conversation := createTestConversation(t, db, agentID, userID)
+	memory := coreAgent.NewGormMemory(db, conversation.ID, 0).WithMetadata(models.JSONMap{"run_id": uuid.NewString()})
+	ids := addMemoryMessages(t, memory,
+		eino.Message{Role: eino.RoleUser, Content: "what is 2+2"},
+		eino.Message{Role: eino.RoleAssistant, ToolCalls: []eino.ToolCall{{ID: "c1", Name: "calculator", Arguments: `{"expression":"2+2"}`}}},
+		eino.Message{Role: eino.RoleTool, Content: "4", ToolCallID: "c1"},
+		eino.Message{Role: eino.RoleAssistant, Content: "2+2 = 4"},
+	)
+	if feedback != "" {
+		if err := db.Model(&models.Message{}).Where("id = ?", ids[3]).Update("feedback", feedback).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conversation
+}
+
+// addMemoryMessages 通过指定记忆依次写入消息，返回各条消息的记录ID
+func addMemoryMessages(t *testing.T, memory *coreAgent.GormMemory, messages ...eino.Message) []uuid.UUID {
+	t.Helper()
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, msg := range messages {
+		if err := memory.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage(%s): %v", msg.Content, err)
+		}
+		records, err := memory.Records()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, records[len(records)-1].ID)
+	}
+	return ids
+}
+
+func TestExportConversation(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createExportConversation(t, db, agent.ID, ownerID, "positive")
+
+	data, err := service.ExportConversation(conversation.ID, ownerID, ExportFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export models.ConversationExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Version != models.ConversationExportVersion || len(export.Conversations) != 1 {
+		t.Fatalf("export = %+v, want one conversation", export)
+	}
+	if messages := export.Conversations[0].Messages; len(messages) != 4 || messages[3].Feedback != "positive" {
+		t.Errorf("exported messages = %+v, want 4 with feedback", messages)
+	}
+
+	markdown, err := service.ExportConversation(conversation.ID, ownerID, ExportFormatMarkdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# test", "## 用户", "调用工具 `calculator`", "## 工具结果（c1）", "_👍 好评_"} {
+		if !strings.Contains(string(markdown), want) {
+			t.Errorf("markdown does not contain %q:\n%s", want, markdown)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		id      uuid.UUID
+		userID  uuid.UUID
+		format  string
+		wantErr error
+	}{
+		{"unknown conversation", uuid.New(), ownerID, ExportFormatJSON, ErrConversationNotFound},
+		{"conversation of another user", conversation.ID, uuid.New(), ExportFormatJSON, ErrUnauthorized},
+		{"unsupported format", conversation.ID, ownerID, "csv", ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.ExportConversation(tt.id, tt.userID, tt.format); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ExportConversation error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExportConversationsFilter(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	otherAgent := createTestAgent(t, db)
+	userID := uuid.New()
+	positive := createExportConversation(t, db, agent.ID, userID, "positive")
+	negative := createExportConversation(t, db, agent.ID, userID, "negative")
+	unrated := createExportConversation(t, db, otherAgent.ID, userID, "")
+	createExportConversation(t, db, agent.ID, uuid.New(), "positive")
+
+	exportedIDs := func(filter ExportFilter) []uuid.UUID {
+		t.Helper()
+		data, err := service.ExportConversations(filter, userID, ExportFormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var export models.ConversationExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]uuid.UUID, len(export.Conversations))
+		for i, conversation := range export.Conversations {
+			ids[i] = conversation.ID
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name   string
+		filter ExportFilter
+		want   []uuid.UUID
+	}{
+		{"all of the user's conversations", ExportFilter{}, []uuid.UUID{positive.ID, negative.ID, unrated.ID}},
+		{"by agent", ExportFilter{AgentID: &otherAgent.ID}, []uuid.UUID{unrated.ID}},
+		{"positive feedback", ExportFilter{Feedback: "positive"}, []uuid.UUID{positive.ID}},
+		{"negative feedback", ExportFilter{Feedback: "negative"}, []uuid.UUID{negative.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := exportedIDs(tt.filter)
+			if len(got) != len(tt.want) {
+				t.Fatalf("exported %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("exported %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	if _, err := service.ExportConversations(ExportFilter{Feedback: "mixed"}, userID, ExportFormatJSON); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("ExportConversations with invalid feedback error = %v, want %v", err, ErrInvalidFilter)
+	}
+}
+
+func TestImportConversations(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	source := createExportConversation(t, db, agent.ID, ownerID, "negative")
+	if err := db.Model(source).Update("metadata", models.JSONMap{"topic": "math", "summary": "stale", "imported": false}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{ExportFormatJSON, ExportFormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			data, err := service.ExportConversation(source.ID, ownerID, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			importerID := uuid.New()
+			response, err := service.ImportConversations(agent.ID, importerID, format, data)
+			if err != nil {
+				t.Fatalf("ImportConversations: %v", err)
+			}
+			if response.Imported != 1 {
+				t.Fatalf("imported %d conversations, want 1", response.Imported)
+			}
+
+			imported := response.Conversations[0]
+			if imported.UserID != importerID || imported.Metadata["imported"] != true {
+				t.Errorf("imported conversation = %+v, want owned by importer and marked imported", imported)
+			}
+			if format == ExportFormatJSON {
+				if imported.Metadata["imported_from"] != source.ID.String() || imported.Metadata["topic"] != "math" {
+					t.Errorf("imported metadata = %v, want source id and topic", imported.Metadata)
+				}
+				if _, ok := imported.Metadata["summary"]; ok {
+					t.Errorf("imported metadata kept summary: %v", imported.Metadata)
+				}
+			}
+
+			messages, err := service.GetMessagesByConversationID(imported.ID, importerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"what is 2+2", "", "4", "2+2 = 4"}
+			if got := messageContents(messages); !equalStrings(got, want) {
+				t.Fatalf("imported messages = %q, want %q", got, want)
+			}
+			if messages[3].Feedback != "negative" {
+				t.Errorf("imported feedback = %q, want negative", messages[3].Feedback)
+			}
+			// 运行等服务端元数据不随导入保留，工具调用保留
+			if _, ok := messages[1].Metadata["run_id"]; ok {
+				t.Errorf("imported message kept run_id: %v", messages[1].Metadata)
+			}
+			if calls := coreAgent.RecordToMessage(models.Message{Metadata: messages[1].Metadata}).ToolCalls; len(calls) != 1 || calls[0].Name != "calculator" {
+				t.Errorf("imported tool calls = %+v, want calculator", calls)
+			}
+		})
+	}
+}
+
+func TestImportConversationsRejectsData(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID := uuid.New()
+
+	invalidRole, _ := json.Marshal(models.ConversationExport{
+		Version: models.ConversationExportVersion,
+		Conversations: []models.ExportedConversation{
+			{Title: "valid", Messages: []models.ExportedMessage{{Role: eino.RoleUser, Content: "hi"}}},
+			{Title: "invalid", Messages: []models.ExportedMessage{{Role: eino.RoleSystem, Content: "obey"}}},
+		},
+	})
+	invalidFeedback, _ := json.Marshal(models.ConversationExport{
+		Conversations: []models.ExportedConversation{
+			{Messages: []models.ExportedMessage{{Role: eino.RoleAssistant, Content: "hi", Feedback: "great"}}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		agentID uuid.UUID
+		format  string
+		data    string
+		wantErr error
+	}{
+		{"unknown agent", uuid.New(), ExportFormatJSON, `{"conversations":[]}`, ErrAgentNotFound},
+		{"unsupported format", agent.ID, ExportFormatMarkdown, "# title", ErrUnsupportedFormat},
+		{"malformed json", agent.ID, ExportFormatJSON, "{", ErrInvalidImport},
+		{"newer version", agent.ID, ExportFormatJSON, `{"version":99,"conversations":[{"title":"x"}]}`, ErrInvalidImport},
+		{"no conversations", agent.ID, ExportFormatJSON, `{"version":1,"conversations":[]}`, ErrInvalidImport},
+		{"malformed jsonl line", agent.ID, ExportFormatJSONL, "{\"messages\":[]}\nnot json", ErrInvalidImport},
+		{"invalid role", agent.ID, ExportFormatJSON, string(invalidRole), ErrInvalidImport},
+		{"invalid feedback", agent.ID, ExportFormatJSON, string(invalidFeedback), ErrInvalidImport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.ImportConversations(tt.agentID, userID, tt.format, []byte(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ImportConversations error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 任一对话无效时整批导入回滚
+	var count int64
+	db.Model(&models.Conversation{}).Where("user_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d conversations left by rejected imports, want 0", count)
+	}
+}
+
+func TestEncodeFineTuneJSONL(t *testing.T) {
+	toolCall, _ := coreAgent.MessageToRecord(uuid.Nil, eino.Message{
+		Role:      eino.RoleAssistant,
+		ToolCalls: []eino.ToolCall{{ID: "c1", Name: "search", Arguments: `{}`}},
+	}, nil)
+	conversations := []models.ExportedConversation{
+		{
+			SystemPrompt: "be brief",
+			Messages: []models.ExportedMessage{
+				{Role: eino.RoleUser, Content: "q1"},
+				{Role: eino.RoleAssistant, Content: "a1", Feedback: "negative"},
+				{Role: eino.RoleUser, Content: "q2"},
+				{Role: eino.RoleAssistant, Metadata: toolCall.Metadata},
+			},
+		},
+		// 没有助手回复的对话被跳过
+		{Messages: []models.ExportedMessage{{Role: eino.RoleUser, Content: "unanswered"}}},
+	}
+
+	data, err := encodeFineTuneJSONL(conversations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("encoded %d lines, want 1:\n%s", len(lines), data)
+	}
+
+	var example fineTuneExample
+	if err := json.Unmarshal([]byte(lines[0]), &example); err != nil {
+		t.Fatal(err)
+	}
+	// 以最后一条不含工具调用的助手回复结尾
+	if len(example.Messages) != 3 || example.Messages[0].Role != eino.RoleSystem || example.Messages[2].Content != "a1" {
+		t.Fatalf("messages = %+v, want system, q1 and a1", example.Messages)
+	}
+	if weight := example.Messages[2].Weight; weight == nil || *weight != 0 {
+		t.Errorf("negative reply weight = %v, want 0", weight)
+	}
+
+	decoded, err := decodeFineTuneJSONL(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0].SystemPrompt != "be brief" || len(decoded[0].Messages) != 2 || decoded[0].Messages[1].Feedback != "negative" {
+		t.Errorf("decoded = %+v, want system prompt and negative reply", decoded)
+	}
+}
@@ -2,10 +2,15 @@ package conversation
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/api/attachment"
 	"github.com/zhuiye8/Lyss/server/models"
 	"github.com/zhuiye8/Lyss/server/pkg/middleware"
 	"go.uber.org/zap"
@@ -41,11 +46,19 @@ func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
 	conversations := router.Group("/conversations")
 	conversations.Use(h.authMiddleware.Authenticate())
 	{
+		conversations.GET("/search", h.SearchMessages)
+		conversations.GET("/export", h.ExportConversations)
+		conversations.POST("/import", h.ImportConversations)
 		conversations.GET("/:id", h.GetConversationByID)
+		conversations.GET("/:id/export", h.ExportConversation)
 		conversations.DELETE("/:id", h.DeleteConversation)
 		conversations.GET("/message/:conv_id/messages", h.GetMessagesByConversationID)
 		conversations.POST("/message/:conv_id/messages", h.SendMessage)
 		conversations.POST("/message/:conv_id/regenerate", h.RegenerateResponse)
+		conversations.POST("/message/:conv_id/messages/:message_id/edit", h.EditMessage)
+		conversations.PUT("/message/:conv_id/branch", h.SwitchBranch)
+		conversations.GET("/message/:conv_id/approvals", h.GetPendingApprovals)
+		conversations.POST("/approvals/:id/decision", h.DecideToolApproval)
 	}
 
 	// 消息操作
@@ -243,6 +256,13 @@ func (h *Handler) SendMessage(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
 			return
 		}
+		if h.handleAttachmentError(c, err) {
+			return
+		}
+		if errors.Is(err, ErrApprovalPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": "对话中有等待审批的工具调用，请先处理"})
+			return
+		}
 		h.logger.Error("Failed to send message", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送消息失败"})
 		return
@@ -292,6 +312,10 @@ func (h *Handler) RegenerateResponse(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
 			return
 		}
+		if errors.Is(err, ErrApprovalPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": "对话中有等待审批的工具调用，请先处理"})
+			return
+		}
 		h.logger.Error("Failed to regenerate response", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新生成回复失败"})
 		return
@@ -300,6 +324,114 @@ func (h *Handler) RegenerateResponse(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// EditMessage 编辑用户消息并重新发送，原消息及其后续回复作为另一个分支保留
+func (h *Handler) EditMessage(c *gin.Context) {
+	// 从URL获取对话ID和消息ID
+	convID, err := uuid.Parse(c.Param("conv_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的对话ID"})
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的消息ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	response, err := h.service.EditMessage(convID, messageID, req, userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "对话不存在"})
+			return
+		}
+		if errors.Is(err, ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+			return
+		}
+		if errors.Is(err, ErrNotUserMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只能编辑用户消息"})
+			return
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
+			return
+		}
+		if h.handleAttachmentError(c, err) {
+			return
+		}
+		if errors.Is(err, ErrApprovalPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": "对话中有等待审批的工具调用，请先处理"})
+			return
+		}
+		h.logger.Error("Failed to edit message", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "编辑消息失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SwitchBranch 切换对话的当前分支，返回切换后的消息列表
+func (h *Handler) SwitchBranch(c *gin.Context) {
+	// 从URL获取对话ID
+	convID, err := uuid.Parse(c.Param("conv_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的对话ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.SwitchBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	messages, err := h.service.SwitchBranch(convID, req, userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "对话不存在"})
+			return
+		}
+		if errors.Is(err, ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+			return
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
+			return
+		}
+		h.logger.Error("Failed to switch branch", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "切换分支失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
 // ProvideFeedback 提供消息反馈
 func (h *Handler) ProvideFeedback(c *gin.Context) {
 	// 从URL获取消息ID
@@ -325,7 +457,7 @@ func (h *Handler) ProvideFeedback(c *gin.Context) {
 	}
 
 	// 调用服务
-	err = h.service.ProvideFeedback(id, req, userID.(uuid.UUID))
+	feedback, err := h.service.ProvideFeedback(id, req, userID.(uuid.UUID))
 	if err != nil {
 		if errors.Is(err, ErrMessageNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
@@ -335,12 +467,16 @@ func (h *Handler) ProvideFeedback(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此消息"})
 			return
 		}
+		if errors.Is(err, ErrInvalidFeedback) || errors.Is(err, ErrNotAssistantMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Error("Failed to provide feedback", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "提供反馈失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	c.JSON(http.StatusOK, gin.H{"success": true, "feedback": feedback})
 }
 
 // DeleteConversation 删除对话
@@ -377,4 +513,317 @@ func (h *Handler) DeleteConversation(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
-} 
+}
+
+// GetPendingApprovals 获取对话中等待审批的工具调用
+func (h *Handler) GetPendingApprovals(c *gin.Context) {
+	// 从URL获取对话ID
+	convIDStr := c.Param("conv_id")
+	convID, err := uuid.Parse(convIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的对话ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 调用服务
+	approvals, err := h.service.GetPendingApprovals(convID, userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "对话不存在"})
+			return
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
+			return
+		}
+		h.logger.Error("Failed to get pending approvals", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取审批请求失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"approvals": approvals})
+}
+
+// DecideToolApproval 批准、修改或拒绝待审批的工具调用
+func (h *Handler) DecideToolApproval(c *gin.Context) {
+	// 从URL获取审批ID
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的审批ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.ToolApprovalDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	response, run, err := h.service.DecideToolApproval(id, req, userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, ErrApprovalNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "审批请求不存在"})
+			return
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权处理此审批请求"})
+			return
+		}
+		if errors.Is(err, ErrApprovalResolved) {
+			c.JSON(http.StatusConflict, gin.H{"error": "审批请求已处理"})
+			return
+		}
+		if errors.Is(err, ErrInvalidDecision) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to decide tool approval", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "处理审批请求失败"})
+		return
+	}
+
+	// 异步运行中的审批由运行继续执行，返回重新排队的运行
+	if run != nil {
+		c.JSON(http.StatusAccepted, run)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SearchMessages 按关键词或语义搜索当前用户的对话历史，支持按智能体、时间和反馈筛选
+func (h *Handler) SearchMessages(c *gin.Context) {
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析搜索条件
+	params := SearchParams{
+		Query:    c.Query("q"),
+		Mode:     c.DefaultQuery("mode", SearchModeFullText),
+		Feedback: c.Query("feedback"),
+	}
+	params.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	params.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if agentIDStr := c.Query("agent_id"); agentIDStr != "" {
+		agentID, err := uuid.Parse(agentIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的智能体ID"})
+			return
+		}
+		params.AgentID = &agentID
+	}
+	for param, target := range map[string]**time.Time{"from": &params.From, "to": &params.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的时间参数 %s", param)})
+			return
+		}
+		*target = &t
+	}
+
+	results, err := h.service.SearchMessages(params, userID.(uuid.UUID))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidSearch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的搜索条件"})
+		case errors.Is(err, ErrSemanticSearchUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "语义搜索不可用"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// maxImportBytes 导入请求体的大小上限
+const maxImportBytes = 20 << 20
+
+// exportContentTypes 导出格式对应的响应类型和文件扩展名
+var exportContentTypes = map[string][2]string{
+	ExportFormatJSON:     {"application/json; charset=utf-8", "json"},
+	ExportFormatMarkdown: {"text/markdown; charset=utf-8", "md"},
+	ExportFormatJSONL:    {"application/jsonl; charset=utf-8", "jsonl"},
+}
+
+// ExportConversation 导出单个对话，format 可选 json、markdown、jsonl
+func (h *Handler) ExportConversation(c *gin.Context) {
+	// 从URL获取对话ID
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的对话ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	format := c.DefaultQuery("format", ExportFormatJSON)
+	data, err := h.service.ExportConversation(id, userID.(uuid.UUID), format)
+	if err != nil {
+		h.handleExportError(c, err)
+		return
+	}
+
+	h.writeExport(c, "conversation-"+id.String(), format, data)
+}
+
+// ExportConversations 按智能体、创建时间和反馈筛选并导出当前用户的对话
+func (h *Handler) ExportConversations(c *gin.Context) {
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析筛选条件
+	filter := ExportFilter{Feedback: c.Query("feedback")}
+	if agentIDStr := c.Query("agent_id"); agentIDStr != "" {
+		agentID, err := uuid.Parse(agentIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的智能体ID"})
+			return
+		}
+		filter.AgentID = &agentID
+	}
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的时间参数 %s", param)})
+			return
+		}
+		*target = &t
+	}
+
+	format := c.DefaultQuery("format", ExportFormatJSON)
+	data, err := h.service.ExportConversations(filter, userID.(uuid.UUID), format)
+	if err != nil {
+		h.handleExportError(c, err)
+		return
+	}
+
+	h.writeExport(c, "conversations-"+time.Now().Format("20060102150405"), format, data)
+}
+
+// ImportConversations 将 json 或 jsonl 格式的对话导入到指定智能体下
+func (h *Handler) ImportConversations(c *gin.Context) {
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	agentID, err := uuid.Parse(c.Query("agent_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的智能体ID"})
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "导入数据过大"})
+		return
+	}
+
+	format := c.DefaultQuery("format", ExportFormatJSON)
+	response, err := h.service.ImportConversations(agentID, userID.(uuid.UUID), format, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAgentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "智能体不存在"})
+		case errors.Is(err, ErrUnsupportedFormat):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的导入格式"})
+		case errors.Is(err, ErrInvalidImport):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to import conversations", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导入对话失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// writeExport 以附件形式返回导出内容
+func (h *Handler) writeExport(c *gin.Context, name, format string, data []byte) {
+	contentType := exportContentTypes[format]
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, contentType[1]))
+	c.Data(http.StatusOK, contentType[0], data)
+}
+
+// handleExportError 将导出错误转换为HTTP响应
+func (h *Handler) handleExportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrConversationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "对话不存在"})
+	case errors.Is(err, ErrUnauthorized):
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此对话"})
+	case errors.Is(err, ErrUnsupportedFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的导出格式"})
+	case errors.Is(err, ErrInvalidFilter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的筛选条件"})
+	default:
+		h.logger.Error("Failed to export conversations", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出对话失败"})
+	}
+}
+
+// handleAttachmentError 处理消息附件相关的错误，已写入响应时返回 true
+func (h *Handler) handleAttachmentError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, attachment.ErrAttachmentNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "附件不存在"})
+	case errors.Is(err, attachment.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此附件"})
+	case errors.Is(err, attachment.ErrAttachmentInUse), errors.Is(err, attachment.ErrVisionUnsupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// parseTimeParam 解析 RFC3339 时间或 YYYY-MM-DD 日期
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -0,0 +1,508 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 搜索模式
+const (
+	SearchModeFullText = "fulltext" // Postgres 全文检索
+	SearchModeSemantic = "semantic" // 基于向量的语义检索
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	snippetRunes       = 120 // 非全文检索结果的片段长度
+	indexBatchSize     = 100
+	searchCollection   = "conversation_messages" // 所有用户共用的消息向量集合，按 user_id 元数据隔离
+)
+
+var (
+	ErrSemanticSearchUnavailable = errors.New("语义搜索不可用")
+	ErrInvalidSearch             = errors.New("无效的搜索条件")
+)
+
+// textSearchConfigPattern 全文检索配置名只允许标识符，配置名会直接拼入SQL以匹配表达式索引
+var textSearchConfigPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SearchConfig 对话搜索配置
+type SearchConfig struct {
+	TextSearchConfig string            // Postgres 全文检索配置名，例如基于 zhparser 的 chinese
+	VectorDB         kb.VectorDatabase // 为空时不提供语义搜索
+	Embedding        *kb.EmbeddingManager
+	EmbeddingModel   string
+	IndexInterval    time.Duration // 后台为新消息写入向量索引的间隔
+}
+
+// SearchParams 搜索条件
+type SearchParams struct {
+	Query    string
+	Mode     string
+	AgentID  *uuid.UUID
+	From     *time.Time
+	To       *time.Time
+	Feedback string
+	Limit    int
+	Offset   int
+}
+
+// Searcher 在当前用户的对话历史中搜索消息
+type Searcher struct {
+	db         *gorm.DB
+	config     SearchConfig
+	textConfig string // 实际使用的全文检索配置，为空时退化为 ILIKE 匹配
+	logger     *zap.Logger
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// NewSearcher 创建对话搜索
+func NewSearcher(db *gorm.DB, config SearchConfig) *Searcher {
+	if config.IndexInterval <= 0 {
+		config.IndexInterval = 30 * time.Second
+	}
+	return &Searcher{
+		db:     db,
+		config: config,
+		logger: zap.L().With(zap.String("component", "conversation_search")),
+	}
+}
+
+// EnsureIndex 准备全文检索配置和索引。配置不存在时尝试基于 zhparser 创建，
+// 无法创建时退化为 ILIKE 匹配并尽量建立 pg_trgm 索引
+func (s *Searcher) EnsureIndex() error {
+	name := s.config.TextSearchConfig
+	if name != "" && !textSearchConfigPattern.MatchString(name) {
+		return fmt.Errorf("invalid text search config name %q", name)
+	}
+
+	if name != "" && !s.textSearchConfigExists(name) {
+		if err := s.createZhparserConfig(name); err != nil {
+			s.logger.Warn("Chinese text search configuration unavailable, falling back to ILIKE search",
+				zap.String("config", name), zap.Error(err))
+			name = ""
+		}
+	}
+
+	if name == "" {
+		// pg_trgm 需要相应权限，失败时 ILIKE 仍可工作，只是没有索引
+		if err := s.db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err == nil {
+			if err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING GIN (content gin_trgm_ops)").Error; err != nil {
+				s.logger.Warn("Failed to create trigram index", zap.Error(err))
+			}
+		} else {
+			s.logger.Warn("pg_trgm extension unavailable", zap.Error(err))
+		}
+		return nil
+	}
+
+	if err := s.db.Exec(fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS idx_messages_content_fts_%s ON messages USING GIN (%s)",
+		strings.ToLower(name), tsvectorExpr(name, "content"))).Error; err != nil {
+		return fmt.Errorf("failed to create full-text index: %w", err)
+	}
+	s.textConfig = name
+	return nil
+}
+
+// textSearchConfigExists 检查全文检索配置是否存在
+func (s *Searcher) textSearchConfigExists(name string) bool {
+	var count int64
+	if err := s.db.Raw("SELECT COUNT(*) FROM pg_ts_config WHERE cfgname = ?", name).Scan(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// createZhparserConfig 使用 zhparser 分词器创建中文全文检索配置
+func (s *Searcher) createZhparserConfig(name string) error {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS zhparser",
+		fmt.Sprintf("CREATE TEXT SEARCH CONFIGURATION %s (PARSER = zhparser)", name),
+		fmt.Sprintf("ALTER TEXT SEARCH CONFIGURATION %s ADD MAPPING FOR n,v,a,i,e,l,j WITH simple", name),
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Search 搜索当前用户对话中的用户与助手消息
+func (s *Searcher) Search(ctx context.Context, params SearchParams, userID uuid.UUID) ([]models.MessageSearchResult, error) {
+	params.Query = strings.TrimSpace(params.Query)
+	if params.Query == "" {
+		return nil, ErrInvalidSearch
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultSearchLimit
+	}
+	if params.Limit > maxSearchLimit {
+		params.Limit = maxSearchLimit
+	}
+	if params.Offset < 0 {
+		params.Offset = 0
+	}
+	switch params.Feedback {
+	case "", "positive", "negative":
+	default:
+		return nil, ErrInvalidSearch
+	}
+
+	switch params.Mode {
+	case "", SearchModeFullText:
+		return s.searchFullText(ctx, params, userID)
+	case SearchModeSemantic:
+		return s.searchSemantic(ctx, params, userID)
+	default:
+		return nil, ErrInvalidSearch
+	}
+}
+
+// searchRow 搜索查询的中间结果
+type searchRow struct {
+	models.MessageSearchResult
+	Content string
+}
+
+// baseQuery 返回限定为当前用户对话并应用筛选条件的消息查询
+func (s *Searcher) baseQuery(ctx context.Context, params SearchParams, userID uuid.UUID) *gorm.DB {
+	query := s.db.WithContext(ctx).Table("messages AS m").
+		Joins("JOIN conversations c ON c.id = m.conversation_id AND c.deleted_at IS NULL").
+		Where("c.user_id = ? AND m.role IN ?", userID, []string{"user", "assistant"})
+	if params.AgentID != nil {
+		query = query.Where("c.agent_id = ?", *params.AgentID)
+	}
+	if params.From != nil {
+		query = query.Where("m.created_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		query = query.Where("m.created_at < ?", *params.To)
+	}
+	if params.Feedback != "" {
+		query = query.Where("m.feedback = ?", params.Feedback)
+	}
+	return query
+}
+
+const searchColumns = "m.id AS message_id, m.conversation_id, c.title AS conversation_title, c.agent_id, m.role, m.feedback, m.created_at"
+
+// searchFullText 使用全文检索匹配并生成高亮片段，没有可用的全文检索配置时使用 ILIKE
+func (s *Searcher) searchFullText(ctx context.Context, params SearchParams, userID uuid.UUID) ([]models.MessageSearchResult, error) {
+	var rows []searchRow
+
+	if s.textConfig == "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(params.Query) + "%"
+		if err := s.baseQuery(ctx, params, userID).
+			Select(searchColumns+", m.content").
+			Where("m.content ILIKE ?", pattern).
+			Order("m.created_at DESC").
+			Limit(params.Limit).Offset(params.Offset).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		results := make([]models.MessageSearchResult, len(rows))
+		for i, row := range rows {
+			results[i] = row.MessageSearchResult
+			results[i].Snippet = highlightSnippet(row.Content, params.Query)
+			results[i].Score = 1
+		}
+		return results, nil
+	}
+
+	vector := tsvectorExpr(s.textConfig, "m.content")
+	// 片段中只保留 <mark> 标签，消息内容先做HTML转义
+	escapedContent := "replace(replace(replace(m.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')"
+	query := fmt.Sprintf("websearch_to_tsquery('%s', ?)", s.textConfig)
+	if err := s.baseQuery(ctx, params, userID).
+		Select(searchColumns+fmt.Sprintf(
+			", ts_headline('%s', %s, q, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10') AS snippet"+
+				", ts_rank(%s, q) AS score", s.textConfig, escapedContent, vector)).
+		Joins("CROSS JOIN "+query+" AS q", params.Query).
+		Where(vector + " @@ q").
+		Order("score DESC, m.created_at DESC").
+		Limit(params.Limit).Offset(params.Offset).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]models.MessageSearchResult, len(rows))
+	for i, row := range rows {
+		results[i] = row.MessageSearchResult
+	}
+	return results, nil
+}
+
+// searchSemantic 在用户的消息向量索引中检索，再按筛选条件和归属在数据库中复核
+func (s *Searcher) searchSemantic(ctx context.Context, params SearchParams, userID uuid.UUID) ([]models.MessageSearchResult, error) {
+	if s.config.VectorDB == nil || s.config.Embedding == nil {
+		return nil, ErrSemanticSearchUnavailable
+	}
+
+	resp, err := s.config.Embedding.Embed(ctx, kb.EmbeddingRequest{
+		Texts: []string{params.Query},
+		Model: s.config.EmbeddingModel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) == 0 {
+		return nil, errors.New("embedding model returned no vectors")
+	}
+
+	if err := s.config.VectorDB.CreateCollection(ctx, searchCollection, len(resp.Embeddings[0])); err != nil {
+		return nil, err
+	}
+	// 多取一些候选，给其余筛选条件和已删除的消息留出余量
+	matches, err := s.config.VectorDB.Search(ctx, searchCollection, resp.Embeddings[0], (params.Offset+params.Limit)*4,
+		semanticFilter(params, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uuid.UUID]float64, len(matches))
+	ids := make([]uuid.UUID, 0, len(matches))
+	for _, match := range matches {
+		id, err := uuid.Parse(match.ChunkID)
+		if err != nil {
+			continue
+		}
+		if _, seen := scores[id]; !seen {
+			ids = append(ids, id)
+		}
+		scores[id] = float64(match.Score)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var rows []searchRow
+	if err := s.baseQuery(ctx, params, userID).
+		Select(searchColumns+", m.content").
+		Where("m.id IN ?", ids).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]searchRow, len(rows))
+	for _, row := range rows {
+		byID[row.MessageID] = row
+	}
+
+	var results []models.MessageSearchResult
+	for _, id := range ids {
+		row, ok := byID[id]
+		if !ok {
+			continue
+		}
+		result := row.MessageSearchResult
+		result.Snippet = html.EscapeString(truncateRunes(row.Content, snippetRunes))
+		result.Score = scores[id]
+		results = append(results, result)
+	}
+
+	if params.Offset >= len(results) {
+		return nil, nil
+	}
+	results = results[params.Offset:]
+	if len(results) > params.Limit {
+		results = results[:params.Limit]
+	}
+	return results, nil
+}
+
+// Start 在配置了向量数据库时启动后台索引，为新消息写入语义检索向量
+func (s *Searcher) Start() {
+	if s.config.VectorDB == nil || s.config.Embedding == nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, s.stop = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.config.IndexInterval)
+		defer ticker.Stop()
+
+		for {
+			s.indexPending(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止后台索引
+func (s *Searcher) Stop() {
+	if s.stop == nil {
+		return
+	}
+	s.stop()
+	s.wg.Wait()
+}
+
+// indexPending 分批为尚未索引的消息写入向量
+func (s *Searcher) indexPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		indexed, err := s.indexBatch(ctx)
+		if err != nil {
+			s.logger.Warn("Failed to index messages for semantic search", zap.Error(err))
+			return
+		}
+		if indexed < indexBatchSize {
+			return
+		}
+	}
+}
+
+// indexBatch 为一批消息写入向量，返回处理的消息数
+func (s *Searcher) indexBatch(ctx context.Context) (int, error) {
+	var rows []struct {
+		ID             uuid.UUID
+		ConversationID uuid.UUID
+		AgentID        uuid.UUID
+		UserID         uuid.UUID
+		Role           string
+		Content        string
+	}
+	if err := s.db.WithContext(ctx).Table("messages AS m").
+		Select("m.id, m.conversation_id, c.agent_id, c.user_id, m.role, m.content").
+		Joins("JOIN conversations c ON c.id = m.conversation_id AND c.deleted_at IS NULL").
+		Where("m.indexed_at IS NULL AND m.role IN ? AND m.content <> ''", []string{"user", "assistant"}).
+		Order("m.sequence ASC").
+		Limit(indexBatchSize).
+		Scan(&rows).Error; err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	texts := make([]string, len(rows))
+	for i, row := range rows {
+		texts[i] = row.Content
+	}
+	resp, err := s.config.Embedding.Embed(ctx, kb.EmbeddingRequest{Texts: texts, Model: s.config.EmbeddingModel})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Embeddings) != len(rows) {
+		return 0, fmt.Errorf("embedding model returned %d vectors for %d messages", len(resp.Embeddings), len(rows))
+	}
+
+	// 所有用户共用一个集合，检索时按 user_id 元数据隔离
+	ids := make([]uuid.UUID, len(rows))
+	vectorIDs := make([]string, len(rows))
+	metadata := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+		vectorIDs[i] = row.ID.String()
+		metadata[i] = map[string]interface{}{
+			"content":         row.Content,
+			"conversation_id": row.ConversationID.String(),
+			"agent_id":        row.AgentID.String(),
+			"user_id":         row.UserID.String(),
+			"role":            row.Role,
+		}
+	}
+
+	if err := s.config.VectorDB.CreateCollection(ctx, searchCollection, len(resp.Embeddings[0])); err != nil {
+		return 0, err
+	}
+	if err := s.config.VectorDB.InsertVectors(ctx, searchCollection, vectorIDs, resp.Embeddings, metadata); err != nil {
+		return 0, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(&models.Message{}).
+		Where("id IN ?", ids).
+		Update("indexed_at", time.Now()).Error; err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// semanticFilter 将向量检索限定在当前用户的消息内，指定智能体时同时按智能体过滤
+func semanticFilter(params SearchParams, userID uuid.UUID) *kb.Filter {
+	filter := &kb.Filter{And: []*kb.Filter{
+		{Field: "user_id", Op: kb.FilterEq, Value: userID.String()},
+	}}
+	if params.AgentID != nil {
+		filter.And = append(filter.And, &kb.Filter{Field: "agent_id", Op: kb.FilterEq, Value: params.AgentID.String()})
+	}
+	return filter
+}
+
+// tsvectorExpr 返回与全文索引一致的 tsvector 表达式
+func tsvectorExpr(config, column string) string {
+	return fmt.Sprintf("to_tsvector('%s', %s)", config, column)
+}
+
+// highlightSnippet 截取首个匹配位置附近的内容并用 <mark> 标记匹配
+func highlightSnippet(content, query string) string {
+	index := -1
+	if lower := strings.ToLower(content); len(lower) == len(content) {
+		index = strings.Index(lower, strings.ToLower(query))
+	}
+	if index < 0 {
+		index = strings.Index(content, query)
+	}
+	if index < 0 {
+		return html.EscapeString(truncateRunes(content, snippetRunes))
+	}
+
+	// 匹配位置前保留约三分之一的片段长度
+	before := []rune(content[:index])
+	start := len(before) - snippetRunes/3
+	prefix := "…"
+	if start <= 0 {
+		start = 0
+		prefix = ""
+	}
+	matchEnd := index + len(query)
+	rest := []rune(content[matchEnd:])
+	tail := snippetRunes - (len(before) - start) - utf8.RuneCountInString(query)
+	suffix := ""
+	if tail < 0 {
+		tail = 0
+	}
+	if tail < len(rest) {
+		rest = rest[:tail]
+		suffix = "…"
+	}
+
+	return prefix + html.EscapeString(string(before[start:])) +
+		"<mark>" + html.EscapeString(content[index:matchEnd]) + "</mark>" +
+		html.EscapeString(string(rest)) + suffix
+}
+
+// truncateRunes 按字符截断文本
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
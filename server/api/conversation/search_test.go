@@ -0,0 +1,195 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	long := strings.Repeat("前文", 60) + "关键词" + strings.Repeat("后文", 60)
+	tests := []struct {
+		name    string
+		content string
+		query   string
+		want    string
+	}{
+		{"case insensitive", "Hello World", "world", "Hello <mark>World</mark>"},
+		{"escapes html", "<b>tag</b> match", "match", "&lt;b&gt;tag&lt;/b&gt; <mark>match</mark>"},
+		{"no match", "nothing here", "missing", "nothing here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightSnippet(tt.content, tt.query); got != tt.want {
+				t.Errorf("highlightSnippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	snippet := highlightSnippet(long, "关键词")
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") || !strings.Contains(snippet, "<mark>关键词</mark>") {
+		t.Errorf("snippet of long content = %q, want truncated around the match", snippet)
+	}
+	if runes := []rune(strings.NewReplacer("<mark>", "", "</mark>", "", "…", "").Replace(snippet)); len(runes) != snippetRunes {
+		t.Errorf("snippet has %d runes, want %d", len(runes), snippetRunes)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if got := truncateRunes("你好世界", 2); got != "你好…" {
+		t.Errorf("truncateRunes = %q, want 你好…", got)
+	}
+	if got := truncateRunes("short", 10); got != "short" {
+		t.Errorf("truncateRunes = %q, want short", got)
+	}
+}
+
+func TestSearchRejectsParams(t *testing.T) {
+	searcher := NewSearcher(nil, SearchConfig{})
+	tests := []struct {
+		name    string
+		params  SearchParams
+		wantErr error
+	}{
+		{"empty query", SearchParams{Query: "  "}, ErrInvalidSearch},
+		{"invalid feedback", SearchParams{Query: "tea", Feedback: "mixed"}, ErrInvalidSearch},
+		{"invalid mode", SearchParams{Query: "tea", Mode: "regex"}, ErrInvalidSearch},
+		{"semantic without vector database", SearchParams{Query: "tea", Mode: SearchModeSemantic}, ErrSemanticSearchUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := searcher.Search(context.Background(), tt.params, uuid.New()); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Search error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := NewSearcher(nil, SearchConfig{TextSearchConfig: "chinese; DROP TABLE messages"}).EnsureIndex(); err == nil {
+		t.Error("EnsureIndex with invalid config name succeeded, want error")
+	}
+}
+
+// searchFixture 两个用户的对话，用于检查搜索结果的归属与筛选
+type searchFixture struct {
+	searcher  *Searcher
+	userID    uuid.UUID
+	agentID   uuid.UUID
+	otherUser uuid.UUID
+}
+
+func newSearchFixture(t *testing.T, config SearchConfig) *searchFixture {
+	t.Helper()
+	_, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	otherAgent := createTestAgent(t, db)
+	fixture := &searchFixture{
+		searcher:  NewSearcher(db, config),
+		userID:    uuid.New(),
+		agentID:   agent.ID,
+		otherUser: uuid.New(),
+	}
+
+	conversation := createTestConversation(t, db, agent.ID, fixture.userID)
+	ids := addTestMessages(t, db, conversation.ID,
+		eino.Message{Role: eino.RoleUser, Content: "How do I brew green tea?"},
+		eino.Message{Role: eino.RoleAssistant, Content: "Steep green tea at 80°C."},
+		eino.Message{Role: eino.RoleTool, Content: "tea temperature table", ToolCallID: "c1"},
+	)
+	if err := db.Model(&models.Message{}).Where("id = ?", ids[1]).Update("feedback", "positive").Error; err != nil {
+		t.Fatal(err)
+	}
+	addTestMessages(t, db, createTestConversation(t, db, otherAgent.ID, fixture.userID).ID,
+		eino.Message{Role: eino.RoleUser, Content: "Black tea or coffee?"})
+	addTestMessages(t, db, createTestConversation(t, db, agent.ID, fixture.otherUser).ID,
+		eino.Message{Role: eino.RoleUser, Content: "My secret tea recipe"})
+
+	deleted := createTestConversation(t, db, agent.ID, fixture.userID)
+	addTestMessages(t, db, deleted.ID, eino.Message{Role: eino.RoleUser, Content: "deleted tea question"})
+	if err := db.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+// searchContents 返回搜索结果对应的消息片段，去掉高亮标记后排序
+func searchContents(t *testing.T, searcher *Searcher, params SearchParams, userID uuid.UUID) []string {
+	t.Helper()
+	results, err := searcher.Search(context.Background(), params, userID)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	contents := make([]string, len(results))
+	for i, result := range results {
+		contents[i] = strings.NewReplacer("<mark>", "", "</mark>", "").Replace(result.Snippet)
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func TestSearchFallbackMatch(t *testing.T) {
+	fixture := newSearchFixture(t, SearchConfig{})
+	tests := []struct {
+		name   string
+		params SearchParams
+		want   []string
+	}{
+		{"user and assistant messages of the user", SearchParams{Query: "TEA"},
+			[]string{"Black tea or coffee?", "How do I brew green tea?", "Steep green tea at 80°C."}},
+		{"by agent", SearchParams{Query: "tea", AgentID: &fixture.agentID},
+			[]string{"How do I brew green tea?", "Steep green tea at 80°C."}},
+		{"by feedback", SearchParams{Query: "tea", Feedback: "positive"}, []string{"Steep green tea at 80°C."}},
+		{"wildcards are literal", SearchParams{Query: "%"}, []string{}},
+		{"paged", SearchParams{Query: "green", Limit: 1, Offset: 1}, []string{"How do I brew green tea?"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchContents(t, fixture.searcher, tt.params, fixture.userID); !equalStrings(got, tt.want) {
+				t.Errorf("results = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSemantic(t *testing.T) {
+	embedding := kb.NewEmbeddingManager()
+	embedding.RegisterModel("mock", kb.NewMockEmbeddingModel(4, "mock"))
+	fixture := newSearchFixture(t, SearchConfig{
+		VectorDB:       kb.NewInMemoryVectorDB(),
+		Embedding:      embedding,
+		EmbeddingModel: "mock",
+	})
+
+	// 后台索引写入全部用户未删除对话中的用户与助手消息，工具结果不索引
+	indexed, err := fixture.searcher.indexBatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 4 {
+		t.Errorf("indexed %d messages, want 4", indexed)
+	}
+	if indexed, err := fixture.searcher.indexBatch(context.Background()); err != nil || indexed != 0 {
+		t.Errorf("second indexBatch = %d, %v, want nothing left", indexed, err)
+	}
+
+	// 检索结果限定在当前用户未删除的对话内
+	params := SearchParams{Query: "tea", Mode: SearchModeSemantic}
+	want := []string{"Black tea or coffee?", "How do I brew green tea?", "Steep green tea at 80°C."}
+	if got := searchContents(t, fixture.searcher, params, fixture.userID); !equalStrings(got, want) {
+		t.Errorf("results = %q, want %q", got, want)
+	}
+	params.AgentID = &fixture.agentID
+	params.Feedback = "positive"
+	if got := searchContents(t, fixture.searcher, params, fixture.userID); !equalStrings(got, []string{"Steep green tea at 80°C."}) {
+		t.Errorf("filtered results = %q, want the rated reply", got)
+	}
+	if got := searchContents(t, fixture.searcher, SearchParams{Query: "tea", Mode: SearchModeSemantic}, fixture.otherUser); !equalStrings(got, []string{"My secret tea recipe"}) {
+		t.Errorf("other user's results = %q, want only their message", got)
+	}
+}
@@ -1,10 +1,15 @@
 package conversation
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/cloudwego/eino"
 	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/api/attachment"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
 	"github.com/zhuiye8/Lyss/server/models"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -15,19 +20,38 @@ var (
 	ErrMessageNotFound      = errors.New("消息不存在")
 	ErrAgentNotFound        = errors.New("智能体不存在")
 	ErrUnauthorized         = errors.New("无权访问此资源")
+	ErrApprovalNotFound     = errors.New("审批请求不存在")
+	ErrApprovalResolved     = errors.New("审批请求已处理")
+	ErrApprovalPending      = errors.New("对话中有等待审批的工具调用")
+	ErrNotUserMessage       = errors.New("只能编辑用户消息")
+	ErrNotAssistantMessage  = errors.New("只能评价助手消息")
+	ErrInvalidFeedback      = errors.New("反馈需要提供评价或评分")
+	ErrInvalidDecision      = errors.New("审批决定无效")
 )
 
 // Service 提供对话相关功能
 type Service struct {
-	db     *gorm.DB
-	logger *zap.Logger
+	db            *gorm.DB
+	agentLoader   *coreAgent.AgentLoader
+	approvalStore coreAgent.ApprovalStore
+	searcher      *Searcher
+	titler        *Titler
+	attachments   *attachment.Service
+	runManager    *coreAgent.RunManager
+	logger        *zap.Logger
 }
 
-// NewService 创建新的对话服务
-func NewService(db *gorm.DB) *Service {
+// NewService 创建新的对话服务，runManager 用于恢复异步运行中暂停的审批
+func NewService(db *gorm.DB, agentLoader *coreAgent.AgentLoader, searcher *Searcher, titler *Titler, attachments *attachment.Service, runManager *coreAgent.RunManager) *Service {
 	return &Service{
-		db:     db,
-		logger: zap.L().With(zap.String("service", "conversation")),
+		db:            db,
+		agentLoader:   agentLoader,
+		approvalStore: coreAgent.NewGormApprovalStore(db),
+		searcher:      searcher,
+		titler:        titler,
+		attachments:   attachments,
+		runManager:    runManager,
+		logger:        zap.L().With(zap.String("service", "conversation")),
 	}
 }
 
@@ -59,6 +83,15 @@ func (s *Service) GetConversationsByAgentID(agentID uuid.UUID, userID uuid.UUID)
 	return conversationResponses, nil
 }
 
+// SearchMessages 在当前用户的对话历史中搜索消息
+func (s *Service) SearchMessages(params SearchParams, userID uuid.UUID) ([]models.MessageSearchResult, error) {
+	results, err := s.searcher.Search(context.Background(), params, userID)
+	if err != nil && !errors.Is(err, ErrInvalidSearch) && !errors.Is(err, ErrSemanticSearchUnavailable) {
+		s.logger.Error("Failed to search messages", zap.Error(err))
+	}
+	return results, err
+}
+
 // GetConversationByID 通过ID获取对话
 func (s *Service) GetConversationByID(id uuid.UUID, userID uuid.UUID) (*models.ConversationResponse, error) {
 	var conversation models.Conversation
@@ -103,6 +136,7 @@ func (s *Service) CreateConversation(req models.CreateConversationRequest, userI
 	// 如果未提供标题，使用时间戳生成一个
 	if conversation.Title == "" {
 		conversation.Title = "对话 " + time.Now().Format("2006-01-02 15:04:05")
+		conversation.DefaultTitle = true
 	}
 
 	if err := s.db.Create(&conversation).Error; err != nil {
@@ -137,22 +171,135 @@ func (s *Service) GetMessagesByConversationID(conversationID uuid.UUID, userID u
 		return nil, ErrUnauthorized
 	}
 
-	// 获取对话的所有消息
-	var messages []models.Message
-	if err := s.db.Order("created_at asc").Where("conversation_id = ?", conversationID).Find(&messages).Error; err != nil {
+	return s.activePathResponses(context.Background(), conversationID, userID)
+}
+
+// activePathResponses 返回当前分支上的消息，并附带每条消息在兄弟消息中的位置
+func (s *Service) activePathResponses(ctx context.Context, conversationID uuid.UUID, userID uuid.UUID) ([]models.MessageResponse, error) {
+	path, err := coreAgent.ActivePath(s.db.WithContext(ctx), conversationID)
+	if err != nil {
 		s.logger.Error("Failed to find messages", zap.Error(err))
 		return nil, err
 	}
 
-	// 转换为响应格式
-	messageResponses := make([]models.MessageResponse, len(messages))
-	for i, msg := range messages {
+	messageResponses := make([]models.MessageResponse, len(path))
+	for i, msg := range path {
 		messageResponses[i] = msg.ToResponse()
 	}
+	if err := s.attachAttachmentResponses(ctx, path, messageResponses, userID); err != nil {
+		s.logger.Error("Failed to find message attachments", zap.Error(err))
+		return nil, err
+	}
+
+	// 尚未记录分支的旧对话没有兄弟消息
+	if len(path) == 0 || (len(path) > 1 && path[len(path)-1].ParentID == nil) {
+		return messageResponses, nil
+	}
+
+	// 查询当前分支上各父消息的全部子消息，根消息之间互为兄弟
+	parentIDs := make([]uuid.UUID, 0, len(path))
+	hasRoot := false
+	for _, msg := range path {
+		if msg.ParentID == nil {
+			hasRoot = true
+		} else {
+			parentIDs = append(parentIDs, *msg.ParentID)
+		}
+	}
+
+	query := s.db.WithContext(ctx).Model(&models.Message{}).Select("id", "parent_id").Where("conversation_id = ?", conversationID)
+	switch {
+	case hasRoot && len(parentIDs) > 0:
+		query = query.Where("parent_id IN ? OR parent_id IS NULL", parentIDs)
+	case hasRoot:
+		query = query.Where("parent_id IS NULL")
+	default:
+		query = query.Where("parent_id IN ?", parentIDs)
+	}
+
+	var siblings []models.Message
+	if err := query.Order("sequence asc").Find(&siblings).Error; err != nil {
+		s.logger.Error("Failed to find sibling messages", zap.Error(err))
+		return nil, err
+	}
+
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for _, sibling := range siblings {
+		var parent uuid.UUID
+		if sibling.ParentID != nil {
+			parent = *sibling.ParentID
+		}
+		children[parent] = append(children[parent], sibling.ID)
+	}
+
+	for i, msg := range path {
+		var parent uuid.UUID
+		if msg.ParentID != nil {
+			parent = *msg.ParentID
+		}
+		ids := children[parent]
+		if len(ids) < 2 {
+			continue
+		}
+		for index, id := range ids {
+			if id == msg.ID {
+				messageResponses[i].Branch = &models.MessageBranch{Index: index, Count: len(ids), SiblingIDs: ids}
+				break
+			}
+		}
+	}
 
 	return messageResponses, nil
 }
 
+// attachAttachmentResponses 为引用了附件的消息附带附件信息
+func (s *Service) attachAttachmentResponses(ctx context.Context, path []models.Message, responses []models.MessageResponse, userID uuid.UUID) error {
+	if s.attachments == nil {
+		return nil
+	}
+
+	var ids []string
+	for _, msg := range path {
+		ids = append(ids, coreAgent.AttachmentIDs(msg)...)
+	}
+	attachments, err := s.attachments.Find(ctx, ids, userID)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]models.Attachment, len(attachments))
+	for _, item := range attachments {
+		byID[item.ID.String()] = item
+	}
+
+	for i, msg := range path {
+		for _, id := range coreAgent.AttachmentIDs(msg) {
+			if item, ok := byID[id]; ok {
+				responses[i].Attachments = append(responses[i].Attachments, item.ToResponse())
+			}
+		}
+	}
+	return nil
+}
+
+// findConversation 查找对话并检查用户权限
+func (s *Service) findConversation(conversationID uuid.UUID, userID uuid.UUID) (*models.Conversation, error) {
+	var conversation models.Conversation
+	if err := s.db.Preload("Agent").First(&conversation, "id = ?", conversationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		s.logger.Error("Failed to find conversation", zap.Error(err))
+		return nil, err
+	}
+
+	// 检查用户权限
+	if conversation.UserID != userID {
+		return nil, ErrUnauthorized
+	}
+
+	return &conversation, nil
+}
+
 // SendMessage 发送消息到对话
 func (s *Service) SendMessage(conversationID uuid.UUID, req models.SendMessageRequest, userID uuid.UUID) (*models.MessageResponse, error) {
 	// 检查对话是否存在及用户权限
@@ -170,53 +317,90 @@ func (s *Service) SendMessage(conversationID uuid.UUID, req models.SendMessageRe
 		return nil, ErrUnauthorized
 	}
 
-	// 创建用户消息
-	userMessage := models.Message{
-		ConversationID: conversationID,
-		Role:           "user",
-		Content:        req.Content,
-		Metadata:       models.JSONMap{},
+	if err := s.ensureNoPendingApproval(s.db, conversationID); err != nil {
+		return nil, err
 	}
 
-	// 开启事务
-	tx := s.db.Begin()
+	// 校验附件并绑定到对话
+	ctx := coreAgent.WithConversationID(context.Background(), conversationID.String())
+	attachmentIDs, err := s.prepareAttachments(ctx, req.AttachmentIDs, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if ctx, err = s.withSandboxFiles(ctx, &conversation); err != nil {
+		return nil, err
+	}
 
-	if err := tx.Create(&userMessage).Error; err != nil {
-		tx.Rollback()
-		s.logger.Error("Failed to create user message", zap.Error(err))
+	// 用户消息与回复由智能体记忆直接写入消息表
+	runtimeAgent, err := s.loadConversationAgent(ctx, &conversation, s.messageStore(ctx, conversationID), attachmentIDs)
+	if err != nil {
 		return nil, err
 	}
 
-	// 生成AI响应（示例，实际需要调用LLM服务）
-	// TODO: 当Agent运行时实现后，这里会调用Agent运行
-	aiResponse := models.Message{
-		ConversationID: conversationID,
-		Role:           "assistant",
-		Content:        "这是一个模拟的AI响应。实际功能将在Agent运行时实现后完成",
-		Metadata:       models.JSONMap{},
+	_, err = runtimeAgent.Chat(ctx, req.Content)
+	return s.assistantReply(conversationID, err)
+}
+
+// EditMessage 编辑用户消息并重新发送，新消息与原消息同属一个父消息，原分支保留
+func (s *Service) EditMessage(conversationID uuid.UUID, messageID uuid.UUID, req models.EditMessageRequest, userID uuid.UUID) (*models.MessageResponse, error) {
+	conversation, err := s.findConversation(conversationID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := tx.Create(&aiResponse).Error; err != nil {
-		tx.Rollback()
-		s.logger.Error("Failed to create AI response", zap.Error(err))
+	var message models.Message
+	if err := s.db.Where("id = ? AND conversation_id = ?", messageID, conversationID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		s.logger.Error("Failed to find message", zap.Error(err))
+		return nil, err
+	}
+	if message.Role != eino.RoleUser {
+		return nil, ErrNotUserMessage
+	}
+	if err := s.ensureNoPendingApproval(s.db, conversationID); err != nil {
 		return nil, err
 	}
 
-	// 更新对话的更新时间
-	if err := tx.Model(&conversation).Update("updated_at", time.Now()).Error; err != nil {
-		tx.Rollback()
-		s.logger.Error("Failed to update conversation time", zap.Error(err))
+	ctx, err := s.withSandboxFiles(coreAgent.WithConversationID(context.Background(), conversationID.String()), conversation)
+	if err != nil {
+		return nil, err
+	}
+	// 新消息沿用原消息的附件
+	runtimeAgent, err := s.loadConversationAgent(ctx, conversation, s.messageStore(ctx, conversationID).WithBranch(message.ParentID),
+		coreAgent.AttachmentIDs(message))
+	if err != nil {
 		return nil, err
 	}
 
-	// 提交事务
-	if err := tx.Commit().Error; err != nil {
-		s.logger.Error("Failed to commit transaction", zap.Error(err))
+	_, err = runtimeAgent.Chat(ctx, req.Content)
+	return s.assistantReply(conversationID, err)
+}
+
+// SwitchBranch 切换到包含指定消息的分支，返回切换后的当前分支
+func (s *Service) SwitchBranch(conversationID uuid.UUID, req models.SwitchBranchRequest, userID uuid.UUID) ([]models.MessageResponse, error) {
+	if _, err := s.findConversation(conversationID, userID); err != nil {
 		return nil, err
 	}
 
-	response := aiResponse.ToResponse()
-	return &response, nil
+	var count int64
+	if err := s.db.Model(&models.Message{}).Where("id = ? AND conversation_id = ?", req.MessageID, conversationID).
+		Count(&count).Error; err != nil {
+		s.logger.Error("Failed to find message", zap.Error(err))
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	ctx := context.Background()
+	if err := s.messageStore(ctx, conversationID).Checkout(req.MessageID); err != nil {
+		s.logger.Error("Failed to switch branch", zap.Error(err))
+		return nil, err
+	}
+
+	return s.activePathResponses(ctx, conversationID, userID)
 }
 
 // RegenerateResponse 重新生成AI回复
@@ -236,77 +420,320 @@ func (s *Service) RegenerateResponse(conversationID uuid.UUID, userID uuid.UUID)
 		return nil, ErrUnauthorized
 	}
 
-	// 获取最后一条用户消息
-	var lastUserMessage models.Message
-	if err := s.db.Where("conversation_id = ? AND role = ?", conversationID, "user").Order("created_at desc").First(&lastUserMessage).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("没有找到用户消息")
+	if err := s.ensureNoPendingApproval(s.db, conversationID); err != nil {
+		return nil, err
+	}
+
+	ctx, err := s.withSandboxFiles(coreAgent.WithConversationID(context.Background(), conversationID.String()), &conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获取当前分支上的最后一条用户消息
+	store := s.messageStore(ctx, conversationID)
+	path, err := store.Records()
+	if err != nil {
+		s.logger.Error("Failed to load active branch", zap.Error(err))
+		return nil, err
+	}
+	var lastUserMessage *models.Message
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Role == eino.RoleUser {
+			lastUserMessage = &path[i]
+			break
 		}
-		s.logger.Error("Failed to find last user message", zap.Error(err))
+	}
+	if lastUserMessage == nil {
+		return nil, errors.New("没有找到用户消息")
+	}
+
+	// 重放最后一条用户消息，新回复作为旧回复的兄弟分支，旧回复保留可切换
+	runtimeAgent, err := s.loadConversationAgent(ctx, &conversation, store.WithReplay(lastUserMessage.ID), nil)
+	if err != nil {
 		return nil, err
 	}
 
-	// 获取最后一条AI回复
-	var lastAIMessage models.Message
-	if err := s.db.Where("conversation_id = ? AND role = ?", conversationID, "assistant").Order("created_at desc").First(&lastAIMessage).Error; err != nil {
-		// 如果没有找到AI消息，可能是第一次对话
-		s.logger.Warn("No AI message found, creating new one")
+	_, err = runtimeAgent.Chat(ctx, lastUserMessage.Content)
+	return s.assistantReply(conversationID, err)
+}
+
+// messageStore 返回对话的消息表记忆，写入用户消息前在锁定对话的事务中确认没有等待审批的工具调用
+func (s *Service) messageStore(ctx context.Context, conversationID uuid.UUID) *coreAgent.GormMemory {
+	return coreAgent.NewGormMemory(s.db, conversationID, 0).WithContext(ctx).
+		WithGuard(func(tx *gorm.DB) error {
+			return s.ensureNoPendingApproval(tx, conversationID)
+		})
+}
+
+// loadConversationAgent 加载对话的智能体，并以消息表作为其记忆。
+// attachmentIDs 为本轮新写入的用户消息引用的附件；发送给模型的消息包含图片而智能体模型不支持视觉时改用视觉模型
+func (s *Service) loadConversationAgent(ctx context.Context, conversation *models.Conversation, store *coreAgent.GormMemory, attachmentIDs []string) (*coreAgent.Agent, error) {
+	modelConfigID, err := s.visionModelConfig(ctx, conversation, store, attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if s.attachments != nil {
+		store = store.WithAttachments(attachmentIDs).WithAttachmentResolver(s.attachments.Resolver(conversation.UserID))
+	}
+
+	var runtimeAgent *coreAgent.Agent
+	if modelConfigID != nil {
+		runtimeAgent, err = s.agentLoader.LoadWithModelConfig(ctx, conversation.AgentID, *modelConfigID)
 	} else {
-		// 删除最后一条AI回复
-		if err := s.db.Delete(&lastAIMessage).Error; err != nil {
-			s.logger.Error("Failed to delete last AI message", zap.Error(err))
-			return nil, err
+		runtimeAgent, err = s.agentLoader.Load(ctx, conversation.AgentID)
+	}
+	if err != nil {
+		if errors.Is(err, coreAgent.ErrAgentNotFound) {
+			return nil, ErrAgentNotFound
 		}
+		s.logger.Error("Failed to load agent", zap.Error(err))
+		return nil, err
 	}
 
-	// 生成新的AI响应（示例，实际需要调用LLM服务）
-	// TODO: 当Agent运行时实现后，这里会调用Agent运行
-	newAIResponse := models.Message{
-		ConversationID: conversationID,
-		Role:           "assistant",
-		Content:        "这是一个重新生成的模拟AI响应。实际功能将在Agent运行时实现后完成",
-		Metadata:       models.JSONMap{},
+	runtimeAgent.SetApprovalStore(s.approvalStore)
+	if conversation.Agent != nil {
+		// 按智能体配置的记忆策略读取历史
+		runtimeAgent.SetMemory(coreAgent.NewConversationMemory(store, conversation.Agent, runtimeAgent))
+		s.agentLoader.AttachLongTermMemory(runtimeAgent, conversation.Agent, conversation.UserID)
+	} else {
+		runtimeAgent.SetMemory(store)
 	}
+	return runtimeAgent, nil
+}
 
-	if err := s.db.Create(&newAIResponse).Error; err != nil {
-		s.logger.Error("Failed to create new AI response", zap.Error(err))
+// ensureNoPendingApproval 检查对话中没有等待审批的工具调用。暂停的运行已写入带工具调用的助手消息，
+// 在审批前写入新消息会使历史中的工具调用缺少对应的工具结果。
+// 写入用户消息时该检查会在持有对话行锁的事务中再次执行，见 messageStore
+func (s *Service) ensureNoPendingApproval(db *gorm.DB, conversationID uuid.UUID) error {
+	var approvals int64
+	if err := db.Model(&models.ToolApproval{}).
+		Where("conversation_id = ? AND status = ?", conversationID, string(coreAgent.ApprovalStatusPending)).
+		Count(&approvals).Error; err != nil {
+		s.logger.Error("Failed to count pending approvals", zap.Error(err))
+		return err
+	}
+
+	var runs int64
+	if err := db.Model(&models.AgentRun{}).
+		Where("conversation_id = ? AND status = ?", conversationID, string(coreAgent.RunStatusWaitingApproval)).
+		Count(&runs).Error; err != nil {
+		s.logger.Error("Failed to count runs waiting for approval", zap.Error(err))
+		return err
+	}
+
+	if approvals > 0 || runs > 0 {
+		return ErrApprovalPending
+	}
+	return nil
+}
+
+// withSandboxFiles 将对话中的附件提供给代码解释器
+func (s *Service) withSandboxFiles(ctx context.Context, conversation *models.Conversation) (context.Context, error) {
+	if s.attachments == nil {
+		return ctx, nil
+	}
+	files, err := s.attachments.SandboxFiles(ctx, conversation.ID, conversation.UserID)
+	if err != nil {
+		s.logger.Error("Failed to list conversation attachments", zap.Error(err))
+		return nil, err
+	}
+	return coreAgent.WithSandboxFiles(ctx, files), nil
+}
+
+// prepareAttachments 校验随消息发送的附件并绑定到对话，返回写入消息元数据的附件ID
+func (s *Service) prepareAttachments(ctx context.Context, ids []uuid.UUID, userID uuid.UUID, conversationID uuid.UUID) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if s.attachments == nil {
+		return nil, attachment.ErrAttachmentNotFound
+	}
+
+	attachments, err := s.attachments.PrepareForMessage(ctx, ids, userID, conversationID)
+	if err != nil {
 		return nil, err
 	}
+	attachmentIDs := make([]string, len(attachments))
+	for i, item := range attachments {
+		attachmentIDs[i] = item.ID.String()
+	}
+	return attachmentIDs, nil
+}
+
+// visionModelConfig 判断本轮发送给模型的消息是否包含图片，需要时返回替代的视觉模型配置ID。
+// 没有新附件时以当前分支上最后一条带附件的用户消息为准，与记忆只发送该消息图片的规则一致
+func (s *Service) visionModelConfig(ctx context.Context, conversation *models.Conversation, store *coreAgent.GormMemory, attachmentIDs []string) (*uuid.UUID, error) {
+	if s.attachments == nil {
+		return nil, nil
+	}
+
+	ids := attachmentIDs
+	if len(ids) == 0 {
+		path, err := store.Records()
+		if err != nil {
+			return nil, err
+		}
+		for i := len(path) - 1; i >= 0 && len(ids) == 0; i-- {
+			if path[i].Role == eino.RoleUser {
+				ids = coreAgent.AttachmentIDs(path[i])
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	attachments, err := s.attachments.Find(ctx, ids, conversation.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !attachment.HasImages(attachments) {
+		return nil, nil
+	}
+
+	agent := conversation.Agent
+	if agent == nil {
+		agent = &models.Agent{}
+		if err := s.db.WithContext(ctx).First(agent, "id = ?", conversation.AgentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrAgentNotFound
+			}
+			return nil, err
+		}
+	}
+	return s.attachments.VisionModelConfig(ctx, agent)
+}
+
+// assistantReply 返回智能体运行后最新的助手消息，运行因审批暂停时在消息上标记待审批ID
+func (s *Service) assistantReply(conversationID uuid.UUID, runErr error) (*models.MessageResponse, error) {
+	if errors.Is(runErr, coreAgent.ErrInvalidApprovalDecision) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDecision, runErr)
+	}
+	// 写入用户消息时发现并发请求已使运行等待审批
+	if errors.Is(runErr, ErrApprovalPending) {
+		return nil, ErrApprovalPending
+	}
 
-	// 更新对话的更新时间
-	if err := s.db.Model(&conversation).Update("updated_at", time.Now()).Error; err != nil {
-		s.logger.Error("Failed to update conversation time", zap.Error(err))
+	var approvalErr *coreAgent.ApprovalRequiredError
+	if runErr != nil && !errors.As(runErr, &approvalErr) {
+		s.logger.Error("Failed to run agent", zap.Error(runErr))
+		return nil, runErr
+	}
+
+	var reply models.Message
+	if err := s.db.Where("conversation_id = ? AND role = ?", conversationID, "assistant").
+		Order("sequence desc").First(&reply).Error; err != nil {
+		s.logger.Error("Failed to find assistant reply", zap.Error(err))
 		return nil, err
 	}
 
-	response := newAIResponse.ToResponse()
+	if approvalErr != nil {
+		metadata := models.JSONMap{}
+		for key, value := range reply.Metadata {
+			metadata[key] = value
+		}
+		metadata["pending_approval_id"] = approvalErr.ApprovalID
+		if err := s.db.Model(&reply).Update("metadata", metadata).Error; err != nil {
+			s.logger.Error("Failed to mark pending approval", zap.Error(err))
+			return nil, err
+		}
+		reply.Metadata = metadata
+	}
+
+	// 首轮对话完成后在后台生成标题
+	if runErr == nil && s.titler != nil {
+		s.titler.Schedule(conversationID)
+	}
+
+	response := reply.ToResponse()
 	return &response, nil
 }
 
-// ProvideFeedback 提供消息反馈
-func (s *Service) ProvideFeedback(messageID uuid.UUID, req models.MessageFeedbackRequest, userID uuid.UUID) error {
+// ProvideFeedback 提供消息反馈，保存评分、原因、评论与修正答案，并同步消息上的好评/差评标记
+func (s *Service) ProvideFeedback(messageID uuid.UUID, req models.MessageFeedbackRequest, userID uuid.UUID) (*models.MessageFeedbackResponse, error) {
+	rating, summary, err := feedbackRating(req)
+	if err != nil {
+		return nil, err
+	}
+
 	// 查找消息
 	var message models.Message
 	if err := s.db.Preload("Conversation").First(&message, "id = ?", messageID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrMessageNotFound
+			return nil, ErrMessageNotFound
 		}
 		s.logger.Error("Failed to find message", zap.Error(err))
-		return err
+		return nil, err
 	}
 
 	// 检查用户权限
 	if message.Conversation.UserID != userID {
-		return ErrUnauthorized
+		return nil, ErrUnauthorized
+	}
+	if message.Role != "assistant" {
+		return nil, ErrNotAssistantMessage
 	}
 
-	// 更新反馈
-	if err := s.db.Model(&message).Update("feedback", req.Feedback).Error; err != nil {
-		s.logger.Error("Failed to update message feedback", zap.Error(err))
-		return err
+	var feedback models.MessageFeedback
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("message_id = ? AND user_id = ?", messageID, userID).First(&feedback).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// 重新评价后回到待审核状态
+		feedback.MessageID = messageID
+		feedback.UserID = userID
+		feedback.ConversationID = message.ConversationID
+		feedback.AgentID = message.Conversation.AgentID
+		feedback.Rating = rating
+		feedback.Reasons = req.Reasons
+		if feedback.Reasons == nil {
+			feedback.Reasons = []string{}
+		}
+		feedback.Comment = req.Comment
+		feedback.CorrectedAnswer = req.CorrectedAnswer
+		feedback.Status = models.FeedbackStatusPending
+		feedback.ReviewerID = nil
+		feedback.ReviewNote = ""
+		feedback.ReviewedAt = nil
+		if err := tx.Save(&feedback).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&message).Update("feedback", summary).Error
+	})
+	if err != nil {
+		s.logger.Error("Failed to save message feedback", zap.Error(err))
+		return nil, err
 	}
 
-	return nil
+	response := feedback.ToResponse()
+	return &response, nil
+}
+
+// feedbackRating 根据请求得到1-5分评分与消息上的好评/差评标记，仅给出好评/差评时分别记为5分和1分
+func feedbackRating(req models.MessageFeedbackRequest) (int, string, error) {
+	rating := req.Rating
+	switch {
+	case rating == 0 && req.Feedback == "positive":
+		rating = 5
+	case rating == 0 && req.Feedback == "negative":
+		rating = 1
+	case rating == 0:
+		return 0, "", ErrInvalidFeedback
+	}
+
+	summary := req.Feedback
+	if summary == "" {
+		switch {
+		case rating >= models.PositiveRatingMin:
+			summary = "positive"
+		case rating <= models.NegativeRatingMax:
+			summary = "negative"
+		}
+	}
+	return rating, summary, nil
 }
 
 // DeleteConversation 删除对话
@@ -329,6 +756,13 @@ func (s *Service) DeleteConversation(id uuid.UUID, userID uuid.UUID) error {
 	// 开启事务
 	tx := s.db.Begin()
 
+	// 删除对话消息的反馈记录
+	if err := tx.Where("conversation_id = ?", id).Delete(&models.MessageFeedback{}).Error; err != nil {
+		tx.Rollback()
+		s.logger.Error("Failed to delete message feedback", zap.Error(err))
+		return err
+	}
+
 	// 删除对话的所有消息
 	if err := tx.Where("conversation_id = ?", id).Delete(&models.Message{}).Error; err != nil {
 		tx.Rollback()
@@ -349,5 +783,135 @@ func (s *Service) DeleteConversation(id uuid.UUID, userID uuid.UUID) error {
 		return err
 	}
 
+	// 删除对话附件及存储中的文件
+	if s.attachments != nil {
+		if err := s.attachments.DeleteForConversation(context.Background(), id); err != nil {
+			s.logger.Warn("Failed to delete conversation attachments", zap.Error(err))
+		}
+	}
+
 	return nil
 } 
+
+// GetPendingApprovals 获取对话中等待审批的工具调用
+func (s *Service) GetPendingApprovals(conversationID uuid.UUID, userID uuid.UUID) ([]models.ToolApprovalResponse, error) {
+	// 检查对话是否存在及用户权限
+	var conversation models.Conversation
+	if err := s.db.First(&conversation, "id = ?", conversationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		s.logger.Error("Failed to find conversation", zap.Error(err))
+		return nil, err
+	}
+
+	// 检查用户权限
+	if conversation.UserID != userID {
+		return nil, ErrUnauthorized
+	}
+
+	var approvals []models.ToolApproval
+	if err := s.db.Order("created_at asc").Where("conversation_id = ? AND status = ?", conversationID, "pending").Find(&approvals).Error; err != nil {
+		s.logger.Error("Failed to find tool approvals", zap.Error(err))
+		return nil, err
+	}
+
+	// 转换为响应格式
+	responses := make([]models.ToolApprovalResponse, len(approvals))
+	for i, approval := range approvals {
+		responses[i] = approval.ToResponse()
+	}
+
+	return responses, nil
+}
+
+// DecideToolApproval 提交审批决定并恢复智能体运行。对话中直接暂停的运行在当前请求中恢复并返回回复；
+// 异步运行暂停产生的审批交由运行管理器恢复，返回重新排队的运行
+func (s *Service) DecideToolApproval(approvalID uuid.UUID, req models.ToolApprovalDecisionRequest, userID uuid.UUID) (*models.MessageResponse, *models.AgentRunResponse, error) {
+	// 查找审批记录
+	var approval models.ToolApproval
+	if err := s.db.Preload("Conversation.Agent").First(&approval, "id = ?", approvalID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrApprovalNotFound
+		}
+		s.logger.Error("Failed to find tool approval", zap.Error(err))
+		return nil, nil, err
+	}
+
+	// 只有对话所有者可以审批
+	if approval.Conversation == nil || approval.Conversation.UserID != userID {
+		return nil, nil, ErrUnauthorized
+	}
+
+	if approval.Status != string(coreAgent.ApprovalStatusPending) {
+		return nil, nil, ErrApprovalResolved
+	}
+
+	decisions := make([]coreAgent.ApprovalDecision, len(req.Decisions))
+	for i, d := range req.Decisions {
+		decisions[i] = coreAgent.ApprovalDecision{
+			ToolCallID: d.ToolCallID,
+			Action:     coreAgent.ApprovalAction(d.Action),
+			Arguments:  d.Arguments,
+			Reason:     d.Reason,
+		}
+	}
+
+	// 审批属于异步运行时必须通过运行恢复，否则运行会一直停留在等待审批状态
+	var run models.AgentRun
+	err := s.db.Where("approval_id = ?", approvalID).First(&run).Error
+	if err == nil {
+		response, err := s.resumeRun(&run, approvalID, decisions, userID)
+		return nil, response, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.logger.Error("Failed to find run for approval", zap.Error(err))
+		return nil, nil, err
+	}
+
+	// 加载智能体并恢复运行，恢复过程中写入的消息记录审批ID
+	ctx := coreAgent.WithConversationID(context.Background(), approval.Conversation.ID.String())
+	store := s.messageStore(ctx, approval.Conversation.ID).WithMetadata(models.JSONMap{"approval_id": approvalID.String()})
+	runtimeAgent, err := s.loadConversationAgent(ctx, approval.Conversation, store, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = runtimeAgent.ResumeToolCalls(ctx, approvalID.String(), decisions)
+
+	// 记录审批人
+	if updateErr := s.db.Model(&models.ToolApproval{}).Where("id = ? AND status = ?", approvalID, "resolved").
+		Update("resolved_by", userID).Error; updateErr != nil {
+		s.logger.Warn("Failed to record approval reviewer", zap.Error(updateErr))
+	}
+
+	message, err := s.assistantReply(approval.Conversation.ID, err)
+	return message, nil, err
+}
+
+// resumeRun 提交审批决定并重新排队暂停的异步运行，审批在运行恢复时标记为已处理
+func (s *Service) resumeRun(run *models.AgentRun, approvalID uuid.UUID, decisions []coreAgent.ApprovalDecision, userID uuid.UUID) (*models.AgentRunResponse, error) {
+	if err := s.runManager.Resume(context.Background(), run.ID, decisions); err != nil {
+		if errors.Is(err, coreAgent.ErrRunNotPending) {
+			return nil, ErrApprovalResolved
+		}
+		if errors.Is(err, coreAgent.ErrInvalidApprovalDecision) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDecision, err)
+		}
+		s.logger.Error("Failed to resume run", zap.Error(err))
+		return nil, err
+	}
+
+	// 记录审批人
+	if err := s.db.Model(&models.ToolApproval{}).Where("id = ?", approvalID).
+		Update("resolved_by", userID).Error; err != nil {
+		s.logger.Warn("Failed to record approval reviewer", zap.Error(err))
+	}
+
+	if err := s.db.First(run, "id = ?", run.ID).Error; err != nil {
+		s.logger.Error("Failed to reload run", zap.Error(err))
+		return nil, err
+	}
+	response := run.ToResponse()
+	return &response, nil
+}
@@ -0,0 +1,383 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestService 创建使用测试数据库的对话服务，不加载智能体运行时
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t,
+		&models.Agent{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.MessageFeedback{},
+		&models.ToolApproval{},
+		&models.AgentRun{},
+	)
+	return NewService(db, nil, nil, nil, nil, nil), db
+}
+
+func createTestAgent(t *testing.T, db *gorm.DB) *models.Agent {
+	t.Helper()
+	agent := models.Agent{Name: "test", ApplicationID: uuid.New(), ModelConfigID: uuid.New()}
+	if err := db.Create(&agent).Error; err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	return &agent
+}
+
+func createTestConversation(t *testing.T, db *gorm.DB, agentID, userID uuid.UUID) *models.Conversation {
+	t.Helper()
+	conversation := models.Conversation{AgentID: agentID, UserID: userID, Title: "test"}
+	if err := db.Create(&conversation).Error; err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	return &conversation
+}
+
+// addTestMessages 通过对话记忆依次写入消息，返回各条消息的记录ID
+func addTestMessages(t *testing.T, db *gorm.DB, conversationID uuid.UUID, messages ...eino.Message) []uuid.UUID {
+	t.Helper()
+	return addMemoryMessages(t, coreAgent.NewGormMemory(db, conversationID, 0), messages...)
+}
+
+func messageContents(messages []models.MessageResponse) []string {
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	return contents
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetMessagesByConversationID(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createTestConversation(t, db, agent.ID, ownerID)
+	addTestMessages(t, db, conversation.ID,
+		eino.Message{Role: eino.RoleUser, Content: "q1"},
+		eino.Message{Role: eino.RoleAssistant, Content: "a1"},
+	)
+
+	messages, err := service.GetMessagesByConversationID(conversation.ID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := messageContents(messages), []string{"q1", "a1"}; !equalStrings(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+
+	if _, err := service.GetMessagesByConversationID(conversation.ID, uuid.New()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("GetMessagesByConversationID by another user error = %v, want %v", err, ErrUnauthorized)
+	}
+	if _, err := service.GetMessagesByConversationID(uuid.New(), ownerID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("GetMessagesByConversationID of unknown conversation error = %v, want %v", err, ErrConversationNotFound)
+	}
+}
+
+func TestSendMessageRejectsRequest(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createTestConversation(t, db, agent.ID, ownerID)
+
+	waiting := createTestConversation(t, db, agent.ID, ownerID)
+	if err := coreAgent.NewGormApprovalStore(db).Save(context.Background(), &coreAgent.ToolApprovalState{
+		ID:             uuid.NewString(),
+		AgentID:        agent.ID.String(),
+		ConversationID: waiting.ID.String(),
+		Status:         coreAgent.ApprovalStatusPending,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		conversationID uuid.UUID
+		userID         uuid.UUID
+		wantErr        error
+	}{
+		{"unknown conversation", uuid.New(), ownerID, ErrConversationNotFound},
+		{"conversation of another user", conversation.ID, uuid.New(), ErrUnauthorized},
+		{"pending tool approval", waiting.ID, ownerID, ErrApprovalPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.SendMessage(tt.conversationID, models.SendMessageRequest{Content: "hello"}, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("SendMessage error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	var count int64
+	db.Model(&models.Message{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d messages stored by rejected requests, want 0", count)
+	}
+}
+
+// createPendingApproval 在对话中创建等待审批的工具调用
+func createPendingApproval(t *testing.T, db *gorm.DB, agentID, conversationID uuid.UUID) {
+	t.Helper()
+	if err := coreAgent.NewGormApprovalStore(db).Save(context.Background(), &coreAgent.ToolApprovalState{
+		ID:             uuid.NewString(),
+		AgentID:        agentID.String(),
+		ConversationID: conversationID.String(),
+		Status:         coreAgent.ApprovalStatusPending,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEditMessageRejectsRequest(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createTestConversation(t, db, agent.ID, ownerID)
+	ids := addTestMessages(t, db, conversation.ID,
+		eino.Message{Role: eino.RoleUser, Content: "q1"},
+		eino.Message{Role: eino.RoleAssistant, Content: "a1"},
+	)
+	other := createTestConversation(t, db, agent.ID, ownerID)
+	otherIDs := addTestMessages(t, db, other.ID, eino.Message{Role: eino.RoleUser, Content: "elsewhere"})
+
+	waiting := createTestConversation(t, db, agent.ID, ownerID)
+	waitingIDs := addTestMessages(t, db, waiting.ID, eino.Message{Role: eino.RoleUser, Content: "q"})
+	createPendingApproval(t, db, agent.ID, waiting.ID)
+
+	tests := []struct {
+		name           string
+		conversationID uuid.UUID
+		messageID      uuid.UUID
+		userID         uuid.UUID
+		wantErr        error
+	}{
+		{"unknown conversation", uuid.New(), ids[0], ownerID, ErrConversationNotFound},
+		{"conversation of another user", conversation.ID, ids[0], uuid.New(), ErrUnauthorized},
+		{"unknown message", conversation.ID, uuid.New(), ownerID, ErrMessageNotFound},
+		{"message of another conversation", conversation.ID, otherIDs[0], ownerID, ErrMessageNotFound},
+		{"assistant message", conversation.ID, ids[1], ownerID, ErrNotUserMessage},
+		{"pending tool approval", waiting.ID, waitingIDs[0], ownerID, ErrApprovalPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.EditMessage(tt.conversationID, tt.messageID, models.EditMessageRequest{Content: "edited"}, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EditMessage error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegenerateResponseRejectsRequest(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createTestConversation(t, db, agent.ID, ownerID)
+	waiting := createTestConversation(t, db, agent.ID, ownerID)
+	createPendingApproval(t, db, agent.ID, waiting.ID)
+
+	tests := []struct {
+		name           string
+		conversationID uuid.UUID
+		userID         uuid.UUID
+		wantErr        error
+	}{
+		{"unknown conversation", uuid.New(), ownerID, ErrConversationNotFound},
+		{"conversation of another user", conversation.ID, uuid.New(), ErrUnauthorized},
+		{"pending tool approval", waiting.ID, ownerID, ErrApprovalPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.RegenerateResponse(tt.conversationID, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RegenerateResponse error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 没有用户消息时无法重新生成
+	if _, err := service.RegenerateResponse(conversation.ID, ownerID); err == nil {
+		t.Error("RegenerateResponse without user message succeeded, want error")
+	}
+}
+
+func TestSwitchBranch(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createTestConversation(t, db, agent.ID, ownerID)
+	ids := addTestMessages(t, db, conversation.ID,
+		eino.Message{Role: eino.RoleUser, Content: "q1"},
+		eino.Message{Role: eino.RoleAssistant, Content: "a1"},
+	)
+	// 重新生成的回复作为 a1 的兄弟消息
+	replay := coreAgent.NewGormMemory(db, conversation.ID, 0).WithReplay(ids[0])
+	for _, msg := range []eino.Message{{Role: eino.RoleUser, Content: "q1"}, {Role: eino.RoleAssistant, Content: "a1 regenerated"}} {
+		if err := replay.AddMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := createTestConversation(t, db, agent.ID, ownerID)
+	otherIDs := addTestMessages(t, db, other.ID, eino.Message{Role: eino.RoleUser, Content: "elsewhere"})
+
+	if _, err := service.SwitchBranch(conversation.ID, models.SwitchBranchRequest{MessageID: ids[1]}, uuid.New()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("SwitchBranch by another user error = %v, want %v", err, ErrUnauthorized)
+	}
+	if _, err := service.SwitchBranch(conversation.ID, models.SwitchBranchRequest{MessageID: otherIDs[0]}, ownerID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("SwitchBranch to message of another conversation error = %v, want %v", err, ErrMessageNotFound)
+	}
+
+	messages, err := service.SwitchBranch(conversation.ID, models.SwitchBranchRequest{MessageID: ids[1]}, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := messageContents(messages), []string{"q1", "a1"}; !equalStrings(got, want) {
+		t.Fatalf("messages = %v, want %v", got, want)
+	}
+	branch := messages[1].Branch
+	if branch == nil || branch.Index != 0 || branch.Count != 2 || branch.SiblingIDs[0] != ids[1] {
+		t.Errorf("branch = %+v, want first of 2 replies", branch)
+	}
+	if messages[0].Branch != nil {
+		t.Errorf("root message branch = %+v, want none", messages[0].Branch)
+	}
+}
+
+func TestFeedbackRating(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         models.MessageFeedbackRequest
+		wantRating  int
+		wantSummary string
+		wantErr     error
+	}{
+		{"positive only", models.MessageFeedbackRequest{Feedback: "positive"}, 5, "positive", nil},
+		{"negative only", models.MessageFeedbackRequest{Feedback: "negative"}, 1, "negative", nil},
+		{"high rating", models.MessageFeedbackRequest{Rating: 4}, 4, "positive", nil},
+		{"low rating", models.MessageFeedbackRequest{Rating: 2}, 2, "negative", nil},
+		{"neutral rating", models.MessageFeedbackRequest{Rating: 3}, 3, "", nil},
+		{"explicit feedback wins", models.MessageFeedbackRequest{Feedback: "positive", Rating: 3}, 3, "positive", nil},
+		{"empty request", models.MessageFeedbackRequest{Comment: "meh"}, 0, "", ErrInvalidFeedback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rating, summary, err := feedbackRating(tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("feedbackRating error = %v, want %v", err, tt.wantErr)
+			}
+			if rating != tt.wantRating || summary != tt.wantSummary {
+				t.Errorf("feedbackRating = %d, %q, want %d, %q", rating, summary, tt.wantRating, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestProvideFeedback(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	conversation := createTestConversation(t, db, agent.ID, ownerID)
+	ids := addTestMessages(t, db, conversation.ID,
+		eino.Message{Role: eino.RoleUser, Content: "q1"},
+		eino.Message{Role: eino.RoleAssistant, Content: "a1"},
+	)
+	questionID, replyID := ids[0], ids[1]
+
+	errTests := []struct {
+		name      string
+		messageID uuid.UUID
+		userID    uuid.UUID
+		req       models.MessageFeedbackRequest
+		wantErr   error
+	}{
+		{"no rating", replyID, ownerID, models.MessageFeedbackRequest{}, ErrInvalidFeedback},
+		{"unknown message", uuid.New(), ownerID, models.MessageFeedbackRequest{Rating: 1}, ErrMessageNotFound},
+		{"other user", replyID, uuid.New(), models.MessageFeedbackRequest{Rating: 1}, ErrUnauthorized},
+		{"user message", questionID, ownerID, models.MessageFeedbackRequest{Rating: 1}, ErrNotAssistantMessage},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.ProvideFeedback(tt.messageID, tt.req, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ProvideFeedback error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	first, err := service.ProvideFeedback(replyID, models.MessageFeedbackRequest{
+		Rating:  1,
+		Reasons: []string{models.FeedbackReasonInaccurate, models.FeedbackReasonOutdated},
+		Comment: "wrong year",
+	}, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.AgentID != agent.ID || first.ConversationID != conversation.ID || first.Status != models.FeedbackStatusPending {
+		t.Errorf("feedback = %+v, want pending feedback for the conversation's agent", first)
+	}
+	var stored models.MessageFeedback
+	if err := db.First(&stored, "id = ?", first.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{models.FeedbackReasonInaccurate, models.FeedbackReasonOutdated}; !equalStrings(stored.Reasons, want) {
+		t.Errorf("stored reasons = %q, want %q", stored.Reasons, want)
+	}
+
+	// 审核过的反馈在重新评价后回到待审核状态，并复用同一条记录
+	reviewerID := uuid.New()
+	if err := db.Model(&models.MessageFeedback{}).Where("id = ?", first.ID).Updates(map[string]interface{}{
+		"status":      models.FeedbackStatusReviewed,
+		"reviewer_id": reviewerID,
+		"review_note": "checked",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.ProvideFeedback(replyID, models.MessageFeedbackRequest{Feedback: "positive"}, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("re-rating created feedback %s, want %s", second.ID, first.ID)
+	}
+
+	stored = models.MessageFeedback{}
+	if err := db.First(&stored, "id = ?", first.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Rating != 5 || len(stored.Reasons) != 0 || stored.Comment != "" {
+		t.Errorf("stored feedback = %+v, want rating 5 without reasons or comment", stored)
+	}
+	if stored.Status != models.FeedbackStatusPending || stored.ReviewerID != nil || stored.ReviewNote != "" {
+		t.Errorf("stored review = %s, %v, %q, want cleared pending review", stored.Status, stored.ReviewerID, stored.ReviewNote)
+	}
+
+	var reply models.Message
+	if err := db.First(&reply, "id = ?", replyID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reply.Feedback != "positive" {
+		t.Errorf("message feedback = %q, want positive", reply.Feedback)
+	}
+}
@@ -0,0 +1,221 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/api/config"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/core/workflow"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTitleTimeout = 30 * time.Second
+	maxTitleRunes       = 50
+	maxTagRunes         = 32
+	maxTags             = 5
+	maxLanguageLength   = 16
+	titleExcerptRunes   = 1000 // 提示词中每条消息保留的长度
+)
+
+const titleSystemPrompt = `你负责为对话生成标题。根据用户与助手的第一轮对话，输出一个JSON对象，不要输出其他内容：
+{"title": "不超过20个字的简洁标题，使用对话所用的语言", "tags": ["最多5个主题标签，可以为空"], "language": "用户使用的语言，BCP 47代码，例如 zh-CN、en"}`
+
+// titleResult 模型返回的标题、标签与语言
+type titleResult struct {
+	Title    string   `json:"title"`
+	Tags     []string `json:"tags"`
+	Language string   `json:"language"`
+}
+
+// Titler 在首轮对话完成后于后台为对话生成标题、主题标签并识别语言
+type Titler struct {
+	db       *gorm.DB
+	settings *config.Service
+	llm      workflow.LLMClient
+	timeout  time.Duration
+	wg       sync.WaitGroup
+	logger   *zap.Logger
+}
+
+// NewTitler 创建对话自动命名器，开关与使用的模型配置由系统设置控制
+func NewTitler(db *gorm.DB, settings *config.Service, llm workflow.LLMClient, timeout time.Duration) *Titler {
+	if timeout <= 0 {
+		timeout = defaultTitleTimeout
+	}
+	return &Titler{
+		db:       db,
+		settings: settings,
+		llm:      llm,
+		timeout:  timeout,
+		logger:   zap.L().With(zap.String("service", "conversation_titler")),
+	}
+}
+
+// Schedule 异步为对话生成标题，不阻塞对话响应；已命名过的对话直接跳过
+func (t *Titler) Schedule(conversationID uuid.UUID) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		defer cancel()
+
+		if err := t.generate(ctx, conversationID); err != nil {
+			t.logger.Warn("Failed to generate conversation title",
+				zap.String("conversation_id", conversationID.String()), zap.Error(err))
+		}
+	}()
+}
+
+// Stop 等待进行中的命名任务结束
+func (t *Titler) Stop() {
+	t.wg.Wait()
+}
+
+func (t *Titler) generate(ctx context.Context, conversationID uuid.UUID) error {
+	settings, err := t.settings.GetSystemSettings()
+	if err != nil {
+		return err
+	}
+	if !settings.AutoTitle.Enabled || settings.AutoTitle.ModelConfigID == "" {
+		return nil
+	}
+
+	var conversation models.Conversation
+	if err := t.db.WithContext(ctx).Select("id", "auto_titled_at").
+		First(&conversation, "id = ?", conversationID).Error; err != nil {
+		return err
+	}
+	if conversation.AutoTitledAt != nil {
+		return nil
+	}
+
+	prompt, ok, err := t.firstExchange(conversationID)
+	if err != nil || !ok {
+		return err
+	}
+
+	// 先占用命名标记，避免并发的多轮对话重复调用模型
+	claim := t.db.WithContext(ctx).Model(&models.Conversation{}).
+		Where("id = ? AND auto_titled_at IS NULL", conversationID).
+		Update("auto_titled_at", time.Now())
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+
+	result, err := t.complete(ctx, settings.AutoTitle.ModelConfigID, prompt)
+	if err != nil {
+		// 释放标记，下一轮对话后重试
+		if resetErr := t.db.Model(&models.Conversation{}).Where("id = ?", conversationID).
+			Update("auto_titled_at", nil).Error; resetErr != nil {
+			t.logger.Error("Failed to reset auto title marker", zap.Error(resetErr))
+		}
+		return err
+	}
+
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Conversation{}).Where("id = ?", conversationID).
+			Updates(map[string]interface{}{"tags": models.StringArray(result.Tags), "language": result.Language}).Error; err != nil {
+			return err
+		}
+		if result.Title == "" {
+			return nil
+		}
+		// 只替换创建时生成的默认标题，用户自己填写的标题不覆盖
+		return tx.Model(&models.Conversation{}).
+			Where("id = ? AND (default_title = ? OR title = '')", conversationID, true).
+			Updates(map[string]interface{}{"title": result.Title, "default_title": false}).Error
+	})
+}
+
+// firstExchange 取当前分支上的第一条用户消息与其后的助手回复组成提示词，首轮尚未完成时返回 false
+func (t *Titler) firstExchange(conversationID uuid.UUID) (string, bool, error) {
+	path, err := coreAgent.ActivePath(t.db, conversationID)
+	if err != nil {
+		return "", false, err
+	}
+
+	var question, answer string
+	for _, message := range path {
+		switch {
+		case message.Role == "user" && question == "":
+			question = message.Content
+		case message.Role == "assistant" && question != "" && strings.TrimSpace(message.Content) != "":
+			answer = message.Content
+		}
+		if answer != "" {
+			break
+		}
+	}
+	if question == "" || answer == "" {
+		return "", false, nil
+	}
+
+	return fmt.Sprintf("用户：%s\n\n助手：%s", truncateRunes(question, titleExcerptRunes), truncateRunes(answer, titleExcerptRunes)), true, nil
+}
+
+func (t *Titler) complete(ctx context.Context, modelConfigID, prompt string) (*titleResult, error) {
+	temperature := 0.2
+	output, err := t.llm.Complete(ctx, workflow.LLMRequest{
+		ModelConfigID: modelConfigID,
+		SystemPrompt:  titleSystemPrompt,
+		Prompt:        prompt,
+		Temperature:   &temperature,
+		MaxTokens:     200,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseTitleResult(output)
+}
+
+// parseTitleResult 解析模型输出并清理标题、标签与语言
+func parseTitleResult(output string) (*titleResult, error) {
+	start := strings.Index(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("unexpected title output: %q", output)
+	}
+
+	var raw titleResult
+	if err := json.Unmarshal([]byte(output[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("invalid title output: %w", err)
+	}
+
+	result := &titleResult{
+		Title:    truncateRunes(strings.Trim(strings.TrimSpace(raw.Title), `"'“”《》`), maxTitleRunes),
+		Tags:     []string{},
+		Language: strings.TrimSpace(raw.Language),
+	}
+	// 语言应为简短的语言代码，过长视为无效
+	if len(result.Language) > maxLanguageLength {
+		result.Language = ""
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range raw.Tags {
+		tag = truncateRunes(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#")), maxTagRunes)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result.Tags = append(result.Tags, tag)
+		if len(result.Tags) == maxTags {
+			break
+		}
+	}
+	return result, nil
+}
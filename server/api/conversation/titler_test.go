@@ -0,0 +1,173 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/api/config"
+	"github.com/zhuiye8/Lyss/server/core/workflow"
+	"github.com/zhuiye8/Lyss/server/models"
+	"gorm.io/gorm"
+)
+
+// stubLLM 返回固定输出并记录请求的模型客户端
+type stubLLM struct {
+	output   string
+	err      error
+	requests []workflow.LLMRequest
+}
+
+func (l *stubLLM) Complete(ctx context.Context, req workflow.LLMRequest) (string, error) {
+	l.requests = append(l.requests, req)
+	return l.output, l.err
+}
+
+// newTestTitler 创建开启自动命名的命名器
+func newTestTitler(t *testing.T, db *gorm.DB, llm workflow.LLMClient) *Titler {
+	t.Helper()
+	if err := db.AutoMigrate(&models.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{
+		"conversation.auto_title.enabled":         "true",
+		"conversation.auto_title.model_config_id": "title-model",
+	} {
+		if err := db.Create(&models.Config{Key: key, Value: value, Scope: models.ScopeSystem}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewTitler(db, config.NewService(db), llm, 0)
+}
+
+func TestParseTitleResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		wantTitle    string
+		wantTags     []string
+		wantLanguage string
+		wantErr      bool
+	}{
+		{"plain", `{"title":"泡绿茶","tags":["茶","饮品"],"language":"zh-CN"}`, "泡绿茶", []string{"茶", "饮品"}, "zh-CN", false},
+		{"surrounding text", "好的：\n```json\n{\"title\":\"《泡绿茶》\",\"tags\":[]}\n```", "泡绿茶", []string{}, "", false},
+		{"dedupes and caps tags", `{"title":"t","tags":["#Tea"," tea ","a","b","c","d","e"]}`, "t", []string{"Tea", "a", "b", "c", "d"}, "", false},
+		{"invalid language", `{"title":"t","language":"this is not a language code"}`, "t", []string{}, "", false},
+		{"long title", `{"title":"` + strings.Repeat("长", 60) + `"}`, strings.Repeat("长", maxTitleRunes) + "…", []string{}, "", false},
+		{"no object", "Green tea", "", nil, "", true},
+		{"invalid json", `{"title": }`, "", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseTitleResult(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTitleResult error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if result.Title != tt.wantTitle || result.Language != tt.wantLanguage || !equalStrings(result.Tags, tt.wantTags) {
+				t.Errorf("result = %+v, want title %q, tags %q, language %q", result, tt.wantTitle, tt.wantTags, tt.wantLanguage)
+			}
+		})
+	}
+}
+
+func TestTitlerGenerate(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	llm := &stubLLM{output: `{"title":"Brewing green tea","tags":["tea"],"language":"en"}`}
+	titler := newTestTitler(t, db, llm)
+
+	newConversation := func(title string, defaultTitle bool, messages ...eino.Message) uuid.UUID {
+		conversation := models.Conversation{AgentID: agent.ID, UserID: uuid.New(), Title: title, DefaultTitle: defaultTitle}
+		if err := db.Create(&conversation).Error; err != nil {
+			t.Fatal(err)
+		}
+		addTestMessages(t, db, conversation.ID, messages...)
+		return conversation.ID
+	}
+	exchange := []eino.Message{
+		{Role: eino.RoleUser, Content: "How do I brew green tea?"},
+		{Role: eino.RoleAssistant, Content: "Steep it at 80°C."},
+	}
+	load := func(id uuid.UUID) models.Conversation {
+		var conversation models.Conversation
+		if err := db.First(&conversation, "id = ?", id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return conversation
+	}
+
+	defaultTitled := newConversation("对话 2026-01-01 00:00:00", true, exchange...)
+	if err := titler.generate(ctx, defaultTitled); err != nil {
+		t.Fatal(err)
+	}
+	conversation := load(defaultTitled)
+	if conversation.Title != "Brewing green tea" || conversation.DefaultTitle || conversation.Language != "en" || conversation.AutoTitledAt == nil {
+		t.Errorf("conversation = %+v, want generated title and language", conversation)
+	}
+	if !equalStrings(conversation.Tags, []string{"tea"}) {
+		t.Errorf("tags = %q, want tea", conversation.Tags)
+	}
+	if len(llm.requests) != 1 || llm.requests[0].ModelConfigID != "title-model" || !strings.Contains(llm.requests[0].Prompt, "80°C") {
+		t.Errorf("requests = %+v, want one request with the first exchange", llm.requests)
+	}
+
+	// 已命名过的对话不再调用模型
+	if err := titler.generate(ctx, defaultTitled); err != nil || len(llm.requests) != 1 {
+		t.Errorf("second generate = %v with %d requests, want skipped", err, len(llm.requests))
+	}
+
+	// 用户填写的标题不被覆盖，标签与语言仍然更新
+	userTitled := newConversation("My tea notes", false, exchange...)
+	if err := titler.generate(ctx, userTitled); err != nil {
+		t.Fatal(err)
+	}
+	if conversation := load(userTitled); conversation.Title != "My tea notes" || conversation.Language != "en" {
+		t.Errorf("conversation = %+v, want user title kept", conversation)
+	}
+
+	// 首轮对话未完成时不命名
+	unanswered := newConversation("", true, exchange[0])
+	if err := titler.generate(ctx, unanswered); err != nil {
+		t.Fatal(err)
+	}
+	if conversation := load(unanswered); conversation.AutoTitledAt != nil {
+		t.Error("conversation without reply was auto titled")
+	}
+
+	// 模型调用失败时释放标记，下一轮重试
+	llm.err = errors.New("model unavailable")
+	failed := newConversation("", true, exchange...)
+	if err := titler.generate(ctx, failed); err == nil {
+		t.Error("generate with failing model succeeded, want error")
+	}
+	if conversation := load(failed); conversation.AutoTitledAt != nil {
+		t.Error("auto title marker kept after failure")
+	}
+}
+
+func TestTitlerDisabled(t *testing.T) {
+	_, db := newTestService(t)
+	if err := db.AutoMigrate(&models.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	llm := &stubLLM{}
+	titler := NewTitler(db, config.NewService(db), llm, 0)
+
+	conversation := createTestConversation(t, db, createTestAgent(t, db).ID, uuid.New())
+	addTestMessages(t, db, conversation.ID,
+		eino.Message{Role: eino.RoleUser, Content: "hi"},
+		eino.Message{Role: eino.RoleAssistant, Content: "hello"},
+	)
+	titler.Schedule(conversation.ID)
+	titler.Stop()
+	if len(llm.requests) != 0 {
+		t.Errorf("%d requests with auto title disabled, want 0", len(llm.requests))
+	}
+}
@@ -10,10 +10,14 @@ import (
 
 // StatisticsResponse 统计数据响应
 type StatisticsResponse struct {
-	AgentCount        int `json:"agentCount"`
-	ConversationCount int `json:"conversationCount"`
-	UserCount         int `json:"userCount"`
-	TokenUsage        int `json:"tokenUsage"`
+	AgentCount        int     `json:"agentCount"`
+	ConversationCount int     `json:"conversationCount"`
+	UserCount         int     `json:"userCount"`
+	TokenUsage        int     `json:"tokenUsage"`
+	FeedbackCount     int     `json:"feedbackCount"`
+	PositiveRate      float64 `json:"positiveRate"`
+	AverageRating     float64 `json:"averageRating"`
+	PendingReviews    int     `json:"pendingReviews"`
 }
 
 // UsageData 使用趋势数据
@@ -3,6 +3,7 @@ package dashboard
 import (
 	"time"
 
+	"github.com/zhuiye8/Lyss/server/models"
 	"gorm.io/gorm"
 )
 
@@ -43,12 +44,36 @@ func (s *Service) GetStatistics() (StatisticsResponse, error) {
 		return StatisticsResponse{}, err
 	}
 
-	return StatisticsResponse{
+	// 查询消息反馈统计
+	var feedback struct {
+		Total          int
+		Positive       int
+		RatingSum      int
+		PendingReviews int
+	}
+	if err := s.db.Model(&models.MessageFeedback{}).
+		Select(`COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE rating >= ?) AS positive,
+			COALESCE(SUM(rating), 0) AS rating_sum,
+			COUNT(*) FILTER (WHERE rating <= ? AND status = ?) AS pending_reviews`,
+			models.PositiveRatingMin, models.NegativeRatingMax, models.FeedbackStatusPending).
+		Scan(&feedback).Error; err != nil {
+		return StatisticsResponse{}, err
+	}
+
+	stats := StatisticsResponse{
 		AgentCount:        int(agentCount),
 		ConversationCount: int(convCount),
 		UserCount:         int(userCount),
 		TokenUsage:        tokenUsage,
-	}, nil
+		FeedbackCount:     feedback.Total,
+		PendingReviews:    feedback.PendingReviews,
+	}
+	if feedback.Total > 0 {
+		stats.PositiveRate = float64(feedback.Positive) / float64(feedback.Total)
+		stats.AverageRating = float64(feedback.RatingSum) / float64(feedback.Total)
+	}
+	return stats, nil
 }
 
 // GetUsageTrend 获取使用趋势数据
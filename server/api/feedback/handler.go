@@ -0,0 +1,164 @@
+package feedback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// Handler 处理消息反馈审核相关的HTTP请求
+type Handler struct {
+	service        *Service
+	authMiddleware *middleware.AuthMiddleware
+	logger         *zap.Logger
+}
+
+// NewHandler 创建新的反馈处理器
+func NewHandler(service *Service, authMiddleware *middleware.AuthMiddleware) *Handler {
+	return &Handler{
+		service:        service,
+		authMiddleware: authMiddleware,
+		logger:         zap.L().With(zap.String("handler", "feedback")),
+	}
+}
+
+// RegisterRoutes 注册反馈审核相关的路由，仅管理员可访问
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	feedback := router.Group("/feedback")
+	feedback.Use(h.authMiddleware.Authenticate(), h.authMiddleware.RequireAdmin())
+	{
+		feedback.GET("/review", h.ListReviewQueue)
+		feedback.PUT("/:id/review", h.ReviewFeedback)
+		feedback.GET("/stats", h.GetStats)
+	}
+}
+
+// ListReviewQueue 列出待审核的差评消息
+func (h *Handler) ListReviewQueue(c *gin.Context) {
+	params := QueueParams{Status: c.Query("status")}
+	params.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	params.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	agentID, ok := parseAgentID(c)
+	if !ok {
+		return
+	}
+	params.AgentID = agentID
+
+	feedbacks, total, err := h.service.ListReviewQueue(params)
+	if err != nil {
+		h.handleError(c, err, "获取审核队列失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feedback": feedbacks, "total": total})
+}
+
+// ReviewFeedback 审核一条反馈
+func (h *Handler) ReviewFeedback(c *gin.Context) {
+	// 从URL获取反馈ID
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的反馈ID"})
+		return
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.ReviewFeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	feedback, err := h.service.ReviewFeedback(id, req, userID.(uuid.UUID))
+	if err != nil {
+		h.handleError(c, err, "审核反馈失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, feedback)
+}
+
+// GetStats 获取反馈统计
+func (h *Handler) GetStats(c *gin.Context) {
+	var params StatsParams
+	agentID, ok := parseAgentID(c)
+	if !ok {
+		return
+	}
+	params.AgentID = agentID
+
+	for param, target := range map[string]**time.Time{"from": &params.From, "to": &params.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的时间参数 %s", param)})
+			return
+		}
+		*target = &t
+	}
+
+	stats, err := h.service.GetStats(params)
+	if err != nil {
+		h.handleError(c, err, "获取反馈统计失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleError 将服务错误转换为HTTP响应
+func (h *Handler) handleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrFeedbackNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "反馈不存在"})
+	case errors.Is(err, ErrAgentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "智能体不存在"})
+	case errors.Is(err, ErrInvalidQuery):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的查询条件"})
+	default:
+		h.logger.Error("Feedback request failed", zap.Error(err), zap.String("path", c.FullPath()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// parseAgentID 解析可选的 agent_id 查询参数
+func parseAgentID(c *gin.Context) (*uuid.UUID, bool) {
+	agentIDStr := c.Query("agent_id")
+	if agentIDStr == "" {
+		return nil, true
+	}
+	agentID, err := uuid.Parse(agentIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的智能体ID"})
+		return nil, false
+	}
+	return &agentID, true
+}
+
+// parseTimeParam 解析 RFC3339 时间或 YYYY-MM-DD 日期
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -0,0 +1,334 @@
+package feedback
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultQueueLimit = 20
+	maxQueueLimit     = 100
+)
+
+var (
+	ErrFeedbackNotFound = errors.New("反馈不存在")
+	ErrAgentNotFound    = errors.New("智能体不存在")
+	ErrInvalidQuery     = errors.New("无效的查询条件")
+)
+
+// QueueParams 审核队列查询条件
+type QueueParams struct {
+	AgentID *uuid.UUID
+	Status  string // 为空时只列出待审核的反馈
+	Limit   int
+	Offset  int
+}
+
+// StatsParams 反馈统计查询条件
+type StatsParams struct {
+	AgentID *uuid.UUID
+	From    *time.Time
+	To      *time.Time
+}
+
+// StatsResponse 反馈统计，包含总体与按智能体的统计
+type StatsResponse struct {
+	Overall models.FeedbackStats   `json:"overall"`
+	Agents  []models.FeedbackStats `json:"agents"`
+}
+
+// Service 提供消息反馈的审核队列与统计
+type Service struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewService 创建新的反馈服务
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		db:     db,
+		logger: zap.L().With(zap.String("service", "feedback")),
+	}
+}
+
+// ListReviewQueue 按智能体列出差评消息，附带被评价的回复和对应的用户提问
+func (s *Service) ListReviewQueue(params QueueParams) ([]models.MessageFeedbackResponse, int64, error) {
+	if params.Status == "" {
+		params.Status = models.FeedbackStatusPending
+	}
+	switch params.Status {
+	case models.FeedbackStatusPending, models.FeedbackStatusReviewed, models.FeedbackStatusDismissed:
+	default:
+		return nil, 0, ErrInvalidQuery
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultQueueLimit
+	}
+	if params.Limit > maxQueueLimit {
+		params.Limit = maxQueueLimit
+	}
+	if params.Offset < 0 {
+		params.Offset = 0
+	}
+
+	if params.AgentID != nil {
+		if err := s.checkAgent(*params.AgentID); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	query := s.db.Model(&models.MessageFeedback{}).
+		Where("rating <= ? AND status = ?", models.NegativeRatingMax, params.Status)
+	if params.AgentID != nil {
+		query = query.Where("agent_id = ?", *params.AgentID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		s.logger.Error("Failed to count review queue", zap.Error(err))
+		return nil, 0, err
+	}
+
+	var feedbacks []models.MessageFeedback
+	if err := query.Preload("Agent").Preload("Reviewer").Preload("Message").
+		Order("created_at asc").Limit(params.Limit).Offset(params.Offset).
+		Find(&feedbacks).Error; err != nil {
+		s.logger.Error("Failed to list review queue", zap.Error(err))
+		return nil, 0, err
+	}
+
+	questions, err := s.questions(feedbacks)
+	if err != nil {
+		s.logger.Error("Failed to find feedback questions", zap.Error(err))
+		return nil, 0, err
+	}
+
+	responses := make([]models.MessageFeedbackResponse, len(feedbacks))
+	for i := range feedbacks {
+		responses[i] = feedbacks[i].ToResponse()
+		responses[i].QuestionContent = questions[feedbacks[i].MessageID]
+	}
+	return responses, total, nil
+}
+
+// ReviewFeedback 审核反馈，记录审核人、审核意见和修正答案
+func (s *Service) ReviewFeedback(id uuid.UUID, req models.ReviewFeedbackRequest, reviewerID uuid.UUID) (*models.MessageFeedbackResponse, error) {
+	var feedback models.MessageFeedback
+	if err := s.db.First(&feedback, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFeedbackNotFound
+		}
+		s.logger.Error("Failed to find feedback", zap.Error(err))
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"status":      req.Status,
+		"review_note": req.ReviewNote,
+		"reviewer_id": reviewerID,
+		"reviewed_at": time.Now(),
+	}
+	// 重新放回队列时清除审核信息
+	if req.Status == models.FeedbackStatusPending {
+		updates["reviewer_id"] = nil
+		updates["reviewed_at"] = nil
+	}
+	if req.CorrectedAnswer != "" {
+		updates["corrected_answer"] = req.CorrectedAnswer
+	}
+
+	if err := s.db.Model(&feedback).Updates(updates).Error; err != nil {
+		s.logger.Error("Failed to review feedback", zap.Error(err))
+		return nil, err
+	}
+
+	if err := s.db.Preload("Agent").Preload("Reviewer").Preload("Message").
+		First(&feedback, "id = ?", id).Error; err != nil {
+		s.logger.Error("Failed to reload feedback", zap.Error(err))
+		return nil, err
+	}
+
+	response := feedback.ToResponse()
+	return &response, nil
+}
+
+// GetStats 汇总反馈统计，按智能体分组并计算总体数据
+func (s *Service) GetStats(params StatsParams) (*StatsResponse, error) {
+	if params.AgentID != nil {
+		if err := s.checkAgent(*params.AgentID); err != nil {
+			return nil, err
+		}
+	}
+
+	scope := func(db *gorm.DB) *gorm.DB {
+		if params.AgentID != nil {
+			db = db.Where("agent_id = ?", *params.AgentID)
+		}
+		if params.From != nil {
+			db = db.Where("created_at >= ?", *params.From)
+		}
+		if params.To != nil {
+			db = db.Where("created_at < ?", *params.To)
+		}
+		return db
+	}
+
+	var rows []struct {
+		AgentID        uuid.UUID
+		Total          int64
+		Positive       int64
+		Negative       int64
+		RatingSum      int64
+		PendingReviews int64
+		Corrected      int64
+	}
+	if err := s.db.Model(&models.MessageFeedback{}).Scopes(scope).
+		Select(`agent_id,
+			COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE rating >= ?) AS positive,
+			COUNT(*) FILTER (WHERE rating <= ?) AS negative,
+			COALESCE(SUM(rating), 0) AS rating_sum,
+			COUNT(*) FILTER (WHERE rating <= ? AND status = ?) AS pending_reviews,
+			COUNT(*) FILTER (WHERE corrected_answer <> '') AS corrected`,
+			models.PositiveRatingMin, models.NegativeRatingMax, models.NegativeRatingMax, models.FeedbackStatusPending).
+		Group("agent_id").Order("total desc").Scan(&rows).Error; err != nil {
+		s.logger.Error("Failed to aggregate feedback", zap.Error(err))
+		return nil, err
+	}
+
+	var reasonRows []struct {
+		AgentID uuid.UUID
+		Reason  string
+		Count   int
+	}
+	if err := s.db.Model(&models.MessageFeedback{}).Scopes(scope).
+		Select("agent_id, UNNEST(reasons) AS reason, COUNT(*) AS count").
+		Group("agent_id, reason").Scan(&reasonRows).Error; err != nil {
+		s.logger.Error("Failed to aggregate feedback reasons", zap.Error(err))
+		return nil, err
+	}
+
+	agentIDs := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		agentIDs[i] = row.AgentID
+	}
+	names := make(map[uuid.UUID]string)
+	if len(agentIDs) > 0 {
+		var agents []models.Agent
+		if err := s.db.Select("id", "name").Where("id IN ?", agentIDs).Find(&agents).Error; err != nil {
+			s.logger.Error("Failed to find agents", zap.Error(err))
+			return nil, err
+		}
+		for _, agent := range agents {
+			names[agent.ID] = agent.Name
+		}
+	}
+
+	response := &StatsResponse{
+		Overall: models.FeedbackStats{Reasons: map[string]int{}},
+		Agents:  make([]models.FeedbackStats, len(rows)),
+	}
+	index := make(map[uuid.UUID]int, len(rows))
+	var ratingSum int64
+	for i, row := range rows {
+		agentID := row.AgentID
+		response.Agents[i] = models.FeedbackStats{
+			AgentID:        &agentID,
+			AgentName:      names[row.AgentID],
+			Total:          row.Total,
+			Positive:       row.Positive,
+			Negative:       row.Negative,
+			AverageRating:  averageRating(row.RatingSum, row.Total),
+			PendingReviews: row.PendingReviews,
+			Corrected:      row.Corrected,
+			Reasons:        map[string]int{},
+		}
+		index[row.AgentID] = i
+
+		response.Overall.Total += row.Total
+		response.Overall.Positive += row.Positive
+		response.Overall.Negative += row.Negative
+		response.Overall.PendingReviews += row.PendingReviews
+		response.Overall.Corrected += row.Corrected
+		ratingSum += row.RatingSum
+	}
+	response.Overall.AverageRating = averageRating(ratingSum, response.Overall.Total)
+
+	for _, row := range reasonRows {
+		if i, ok := index[row.AgentID]; ok {
+			response.Agents[i].Reasons[row.Reason] += row.Count
+		}
+		response.Overall.Reasons[row.Reason] += row.Count
+	}
+
+	return response, nil
+}
+
+// questions 查找每条被评价回复对应的用户提问，未记录父消息的旧对话取回复之前最近的用户消息
+func (s *Service) questions(feedbacks []models.MessageFeedback) (map[uuid.UUID]string, error) {
+	questions := make(map[uuid.UUID]string, len(feedbacks))
+
+	parentIDs := make([]uuid.UUID, 0, len(feedbacks))
+	for _, feedback := range feedbacks {
+		if feedback.Message != nil && feedback.Message.ParentID != nil {
+			parentIDs = append(parentIDs, *feedback.Message.ParentID)
+		}
+	}
+	parents := make(map[uuid.UUID]models.Message)
+	if len(parentIDs) > 0 {
+		var messages []models.Message
+		if err := s.db.Where("id IN ?", parentIDs).Find(&messages).Error; err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			parents[message.ID] = message
+		}
+	}
+
+	for _, feedback := range feedbacks {
+		reply := feedback.Message
+		if reply == nil {
+			continue
+		}
+		if reply.ParentID != nil {
+			// 父消息可能是工具结果，此时继续向上查找用户消息
+			if parent, ok := parents[*reply.ParentID]; ok && parent.Role == "user" {
+				questions[feedback.MessageID] = parent.Content
+				continue
+			}
+		}
+
+		var question models.Message
+		err := s.db.Where("conversation_id = ? AND role = ? AND sequence < ?", reply.ConversationID, "user", reply.Sequence).
+			Order("sequence desc").First(&question).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		questions[feedback.MessageID] = question.Content
+	}
+	return questions, nil
+}
+
+func (s *Service) checkAgent(agentID uuid.UUID) error {
+	var agent models.Agent
+	if err := s.db.Select("id").First(&agent, "id = ?", agentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAgentNotFound
+		}
+		s.logger.Error("Failed to find agent", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func averageRating(sum, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(sum) / float64(count)
+}
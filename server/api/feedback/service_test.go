@@ -0,0 +1,254 @@
+package feedback
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t,
+		&models.Agent{},
+		&models.User{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.MessageFeedback{},
+	)
+	return NewService(db), db
+}
+
+func createTestAgent(t *testing.T, db *gorm.DB, name string) *models.Agent {
+	t.Helper()
+	agent := models.Agent{Name: name, ApplicationID: uuid.New(), ModelConfigID: uuid.New()}
+	if err := db.Create(&agent).Error; err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	return &agent
+}
+
+func createTestMessage(t *testing.T, db *gorm.DB, conversationID uuid.UUID, role, content string, parentID *uuid.UUID) *models.Message {
+	t.Helper()
+	message := models.Message{ConversationID: conversationID, ParentID: parentID, Role: role, Content: content}
+	if err := db.Create(&message).Error; err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	return &message
+}
+
+func createTestFeedback(t *testing.T, db *gorm.DB, reply *models.Message, agentID uuid.UUID, rating int, status string, reasons ...string) *models.MessageFeedback {
+	t.Helper()
+	feedback := models.MessageFeedback{
+		MessageID:      reply.ID,
+		UserID:         uuid.New(),
+		ConversationID: reply.ConversationID,
+		AgentID:        agentID,
+		Rating:         rating,
+		Reasons:        reasons,
+		Status:         status,
+	}
+	if err := db.Create(&feedback).Error; err != nil {
+		t.Fatalf("create feedback: %v", err)
+	}
+	return &feedback
+}
+
+// feedbackFixture 两个智能体下的反馈：a1 有父消息，a2 为未记录父消息的旧回复
+type feedbackFixture struct {
+	agent, otherAgent *models.Agent
+	negative          *models.MessageFeedback // a1 的差评
+}
+
+func newFeedbackFixture(t *testing.T, db *gorm.DB) *feedbackFixture {
+	t.Helper()
+	agent := createTestAgent(t, db, "support")
+	otherAgent := createTestAgent(t, db, "sales")
+
+	conversation := models.Conversation{AgentID: agent.ID, UserID: uuid.New(), Title: "test"}
+	if err := db.Create(&conversation).Error; err != nil {
+		t.Fatal(err)
+	}
+	q1 := createTestMessage(t, db, conversation.ID, "user", "q1", nil)
+	a1 := createTestMessage(t, db, conversation.ID, "assistant", "a1", &q1.ID)
+	createTestMessage(t, db, conversation.ID, "user", "q2", nil)
+	a2 := createTestMessage(t, db, conversation.ID, "assistant", "a2", nil)
+	a3 := createTestMessage(t, db, conversation.ID, "assistant", "a3", nil)
+	a4 := createTestMessage(t, db, conversation.ID, "assistant", "a4", nil)
+
+	other := models.Conversation{AgentID: otherAgent.ID, UserID: uuid.New(), Title: "other"}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	q5 := createTestMessage(t, db, other.ID, "user", "q5", nil)
+	a5 := createTestMessage(t, db, other.ID, "assistant", "a5", &q5.ID)
+
+	negative := createTestFeedback(t, db, a1, agent.ID, 1, models.FeedbackStatusPending, models.FeedbackReasonInaccurate)
+	createTestFeedback(t, db, a2, agent.ID, 2, models.FeedbackStatusPending, models.FeedbackReasonInaccurate, models.FeedbackReasonOutdated)
+	createTestFeedback(t, db, a3, agent.ID, 5, models.FeedbackStatusPending)
+	createTestFeedback(t, db, a4, agent.ID, 1, models.FeedbackStatusDismissed)
+	createTestFeedback(t, db, a5, otherAgent.ID, 2, models.FeedbackStatusPending, models.FeedbackReasonIrrelevant)
+
+	return &feedbackFixture{agent: agent, otherAgent: otherAgent, negative: negative}
+}
+
+func TestListReviewQueue(t *testing.T) {
+	service, db := newTestService(t)
+	fixture := newFeedbackFixture(t, db)
+	unknownAgent := uuid.New()
+
+	tests := []struct {
+		name          string
+		params        QueueParams
+		wantMessages  []string
+		wantQuestions []string
+		wantTotal     int64
+	}{
+		{"pending negatives", QueueParams{}, []string{"a1", "a2", "a5"}, []string{"q1", "q2", "q5"}, 3},
+		{"one agent", QueueParams{AgentID: &fixture.agent.ID}, []string{"a1", "a2"}, []string{"q1", "q2"}, 2},
+		{"dismissed", QueueParams{Status: models.FeedbackStatusDismissed}, []string{"a4"}, []string{"q2"}, 1},
+		{"paged", QueueParams{Limit: 1, Offset: 1}, []string{"a2"}, []string{"q2"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, total, err := service.ListReviewQueue(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", total, tt.wantTotal)
+			}
+			messages := make([]string, len(queue))
+			questions := make([]string, len(queue))
+			for i, item := range queue {
+				messages[i] = item.MessageContent
+				questions[i] = item.QuestionContent
+			}
+			if !equalStrings(messages, tt.wantMessages) || !equalStrings(questions, tt.wantQuestions) {
+				t.Errorf("queue = %v / %v, want %v / %v", messages, questions, tt.wantMessages, tt.wantQuestions)
+			}
+		})
+	}
+
+	queue, _, err := service.ListReviewQueue(QueueParams{AgentID: &fixture.agent.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue[0].AgentName != "support" || !equalStrings(queue[0].Reasons, []string{models.FeedbackReasonInaccurate}) {
+		t.Errorf("first item = %+v, want support agent with inaccurate reason", queue[0])
+	}
+
+	errTests := []struct {
+		name    string
+		params  QueueParams
+		wantErr error
+	}{
+		{"invalid status", QueueParams{Status: "closed"}, ErrInvalidQuery},
+		{"unknown agent", QueueParams{AgentID: &unknownAgent}, ErrAgentNotFound},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := service.ListReviewQueue(tt.params); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ListReviewQueue error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReviewFeedback(t *testing.T) {
+	service, db := newTestService(t)
+	fixture := newFeedbackFixture(t, db)
+	reviewer := models.User{Username: "admin", Email: "admin@example.com"}
+	if err := db.Create(&reviewer).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.ReviewFeedback(uuid.New(), models.ReviewFeedbackRequest{Status: models.FeedbackStatusReviewed}, reviewer.ID); !errors.Is(err, ErrFeedbackNotFound) {
+		t.Errorf("ReviewFeedback of unknown feedback error = %v, want %v", err, ErrFeedbackNotFound)
+	}
+
+	reviewed, err := service.ReviewFeedback(fixture.negative.ID, models.ReviewFeedbackRequest{
+		Status:          models.FeedbackStatusReviewed,
+		ReviewNote:      "outdated source",
+		CorrectedAnswer: "fixed answer",
+	}, reviewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviewed.Status != models.FeedbackStatusReviewed || reviewed.ReviewerName != "admin" || reviewed.ReviewedAt == nil {
+		t.Errorf("reviewed feedback = %+v, want reviewed by admin", reviewed)
+	}
+	if reviewed.CorrectedAnswer != "fixed answer" || reviewed.MessageContent != "a1" {
+		t.Errorf("reviewed feedback = %+v, want corrected answer for a1", reviewed)
+	}
+	if _, total, err := service.ListReviewQueue(QueueParams{AgentID: &fixture.agent.ID}); err != nil || total != 1 {
+		t.Errorf("pending queue after review = %d, %v, want 1", total, err)
+	}
+
+	// 放回队列时清除审核人，但保留修正答案
+	reopened, err := service.ReviewFeedback(fixture.negative.ID, models.ReviewFeedbackRequest{Status: models.FeedbackStatusPending}, reviewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Status != models.FeedbackStatusPending || reopened.ReviewerID != nil || reopened.ReviewedAt != nil {
+		t.Errorf("reopened feedback = %+v, want pending without reviewer", reopened)
+	}
+	if reopened.CorrectedAnswer != "fixed answer" {
+		t.Errorf("corrected answer = %q, want it kept", reopened.CorrectedAnswer)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	service, db := newTestService(t)
+	fixture := newFeedbackFixture(t, db)
+
+	unknownAgent := uuid.New()
+	if _, err := service.GetStats(StatsParams{AgentID: &unknownAgent}); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("GetStats of unknown agent error = %v, want %v", err, ErrAgentNotFound)
+	}
+
+	stats, err := service.GetStats(StatsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overall := stats.Overall
+	if overall.Total != 5 || overall.Positive != 1 || overall.Negative != 4 || overall.PendingReviews != 3 {
+		t.Errorf("overall = %+v, want 5 total, 1 positive, 4 negative, 3 pending", overall)
+	}
+	if overall.AverageRating != 11.0/5 {
+		t.Errorf("average rating = %v, want %v", overall.AverageRating, 11.0/5)
+	}
+	if overall.Reasons[models.FeedbackReasonInaccurate] != 2 || overall.Reasons[models.FeedbackReasonIrrelevant] != 1 {
+		t.Errorf("overall reasons = %v", overall.Reasons)
+	}
+
+	if len(stats.Agents) != 2 || *stats.Agents[0].AgentID != fixture.agent.ID || stats.Agents[0].AgentName != "support" {
+		t.Fatalf("agents = %+v, want support first", stats.Agents)
+	}
+	if got := stats.Agents[1]; got.Total != 1 || got.Reasons[models.FeedbackReasonIrrelevant] != 1 || got.Reasons[models.FeedbackReasonInaccurate] != 0 {
+		t.Errorf("sales stats = %+v", got)
+	}
+
+	stats, err = service.GetStats(StatsParams{AgentID: &fixture.otherAgent.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Overall.Total != 1 || len(stats.Agents) != 1 {
+		t.Errorf("filtered stats = %+v, want only sales", stats)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,416 @@
+package knowledge
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// streamPollInterval 流式接口轮询文档进度的间隔
+const streamPollInterval = time.Second
+
+// Handler 处理知识库相关的HTTP请求
+type Handler struct {
+	service        *Service
+	authMiddleware *middleware.AuthMiddleware
+	logger         *zap.Logger
+}
+
+// NewHandler 创建新的知识库处理器
+func NewHandler(service *Service, authMiddleware *middleware.AuthMiddleware) *Handler {
+	return &Handler{
+		service:        service,
+		authMiddleware: authMiddleware,
+		logger:         zap.L().With(zap.String("handler", "knowledge")),
+	}
+}
+
+// RegisterRoutes 注册知识库相关的路由
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	knowledgeBases := router.Group("/knowledge-bases")
+	knowledgeBases.Use(h.authMiddleware.Authenticate())
+	{
+		knowledgeBases.POST("", h.CreateKnowledgeBase)
+		knowledgeBases.GET("", h.ListKnowledgeBases)
+		knowledgeBases.GET("/:id", h.GetKnowledgeBase)
+		knowledgeBases.PUT("/:id", h.UpdateKnowledgeBase)
+		knowledgeBases.DELETE("/:id", h.DeleteKnowledgeBase)
+
+		knowledgeBases.POST("/:id/documents", h.UploadDocument)
+		knowledgeBases.POST("/:id/documents/text", h.AddTextDocument)
+		knowledgeBases.GET("/:id/documents", h.ListDocuments)
+		knowledgeBases.GET("/:id/documents/:doc_id", h.GetDocument)
+		knowledgeBases.GET("/:id/documents/:doc_id/events", h.StreamDocument)
+		knowledgeBases.POST("/:id/documents/:doc_id/reindex", h.ReindexDocument)
+		knowledgeBases.POST("/:id/documents/:doc_id/retry", h.RetryDocument)
+		knowledgeBases.POST("/:id/documents/:doc_id/cancel", h.CancelDocument)
+		knowledgeBases.DELETE("/:id/documents/:doc_id", h.DeleteDocument)
+
+		knowledgeBases.POST("/:id/query", h.Query)
+	}
+}
+
+// CreateKnowledgeBase 创建知识库
+func (h *Handler) CreateKnowledgeBase(c *gin.Context) {
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	// 解析请求体
+	var req models.CreateKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	knowledgeBase, err := h.service.CreateKnowledgeBase(c.Request.Context(), req, userID.(uuid.UUID))
+	if err != nil {
+		h.handleError(c, err, "创建知识库失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, knowledgeBase)
+}
+
+// ListKnowledgeBases 列出当前用户可管理的知识库，可按 project_id 过滤
+func (h *Handler) ListKnowledgeBases(c *gin.Context) {
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	var projectID *uuid.UUID
+	if value := c.Query("project_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的项目ID"})
+			return
+		}
+		projectID = &id
+	}
+
+	knowledgeBases, err := h.service.ListKnowledgeBases(userID.(uuid.UUID), projectID)
+	if err != nil {
+		h.handleError(c, err, "获取知识库列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, knowledgeBases)
+}
+
+// GetKnowledgeBase 获取知识库详情
+func (h *Handler) GetKnowledgeBase(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	knowledgeBase, err := h.service.GetKnowledgeBase(id, userID)
+	if err != nil {
+		h.handleError(c, err, "获取知识库失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, knowledgeBase)
+}
+
+// UpdateKnowledgeBase 更新知识库
+func (h *Handler) UpdateKnowledgeBase(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	// 解析请求体
+	var req models.UpdateKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	knowledgeBase, err := h.service.UpdateKnowledgeBase(id, req, userID)
+	if err != nil {
+		h.handleError(c, err, "更新知识库失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, knowledgeBase)
+}
+
+// DeleteKnowledgeBase 删除知识库
+func (h *Handler) DeleteKnowledgeBase(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteKnowledgeBase(c.Request.Context(), id, userID); err != nil {
+		h.handleError(c, err, "删除知识库失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "知识库已删除"})
+}
+
+// UploadDocument 上传文档，表单字段 file 为文件，可重复的 tags 字段为文档标签，文档在后台异步入库
+func (h *Handler) UploadDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少上传文件"})
+		return
+	}
+
+	// 调用服务
+	doc, err := h.service.UploadDocument(c.Request.Context(), id, userID, fileHeader, c.PostFormArray("tags"))
+	if err != nil {
+		h.handleError(c, err, "上传文档失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, doc)
+}
+
+// AddTextDocument 以文本形式添加文档
+func (h *Handler) AddTextDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	// 解析请求体
+	var req models.AddTextDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	doc, err := h.service.AddTextDocument(c.Request.Context(), id, userID, req)
+	if err != nil {
+		h.handleError(c, err, "添加文档失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, doc)
+}
+
+// ListDocuments 列出知识库中的文档
+func (h *Handler) ListDocuments(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	docs, err := h.service.ListDocuments(c.Request.Context(), id, userID)
+	if err != nil {
+		h.handleError(c, err, "获取文档列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, docs)
+}
+
+// GetDocument 获取文档及其处理进度
+func (h *Handler) GetDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	doc, err := h.service.GetDocument(c.Request.Context(), id, userID, c.Param("doc_id"))
+	if err != nil {
+		h.handleError(c, err, "获取文档失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// StreamDocument 以SSE方式推送文档处理进度，直到文档索引完成或失败
+func (h *Handler) StreamDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+	documentID := c.Param("doc_id")
+
+	// 先检查权限，避免建立流后才返回错误
+	if _, err := h.service.GetDocument(c.Request.Context(), id, userID, documentID); err != nil {
+		h.handleError(c, err, "获取文档失败")
+		return
+	}
+
+	var lastStatus string
+	lastEmbedded := -1
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		doc, err := h.service.GetDocument(c.Request.Context(), id, userID, documentID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "获取文档失败"})
+			return false
+		}
+
+		if doc.Status == models.DocumentStatusIndexed || doc.Status == models.DocumentStatusFailed {
+			c.SSEvent("end", doc)
+			return false
+		}
+		if doc.Status != lastStatus || doc.EmbeddedChunks != lastEmbedded {
+			c.SSEvent("progress", doc)
+			lastStatus, lastEmbedded = doc.Status, doc.EmbeddedChunks
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			return true
+		}
+	})
+}
+
+// ReindexDocument 将文档重新排队以重建索引
+func (h *Handler) ReindexDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	doc, err := h.service.ReindexDocument(c.Request.Context(), id, userID, c.Param("doc_id"))
+	if err != nil {
+		h.handleError(c, err, "重建文档索引失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, doc)
+}
+
+// RetryDocument 重新处理失败的文档
+func (h *Handler) RetryDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	doc, err := h.service.RetryDocument(c.Request.Context(), id, userID, c.Param("doc_id"))
+	if err != nil {
+		h.handleError(c, err, "重试文档失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, doc)
+}
+
+// CancelDocument 取消排队或处理中的文档
+func (h *Handler) CancelDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.CancelDocument(c.Request.Context(), id, userID, c.Param("doc_id")); err != nil {
+		h.handleError(c, err, "取消文档处理失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "文档处理已取消"})
+}
+
+// DeleteDocument 删除文档
+func (h *Handler) DeleteDocument(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteDocument(c.Request.Context(), id, userID, c.Param("doc_id")); err != nil {
+		h.handleError(c, err, "删除文档失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "文档已删除"})
+}
+
+// Query 检索知识库
+func (h *Handler) Query(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	// 解析请求体
+	var req models.QueryKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 调用服务
+	resp, err := h.service.Query(c.Request.Context(), id, userID, req)
+	if err != nil {
+		h.handleError(c, err, "检索知识库失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// parseRequest 解析URL中的知识库ID和当前用户
+func (h *Handler) parseRequest(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	// 从URL获取知识库ID
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的知识库ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return id, userID.(uuid.UUID), true
+}
+
+// handleError 将服务错误转换为HTTP响应
+func (h *Handler) handleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrKnowledgeBaseNotFound), errors.Is(err, ErrDocumentNotFound), errors.Is(err, ErrProjectNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoPermission):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidEmbeddingModel), errors.Is(err, ErrUnsupportedDocument), errors.Is(err, ErrEmptyDocument),
+		errors.Is(err, ErrInvalidChunking), errors.Is(err, ErrInvalidFilter), errors.Is(err, ErrInvalidRetrieval):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrKnowledgeBaseArchived), errors.Is(err, ErrDuplicateDocument),
+		errors.Is(err, ErrDocumentInProgress), errors.Is(err, ErrDocumentNotInProgress), errors.Is(err, ErrDocumentNotFailed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Knowledge base request failed", zap.Error(err), zap.String("path", c.FullPath()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,520 @@
+package knowledge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrKnowledgeBaseNotFound = errors.New("知识库不存在")
+	ErrDocumentNotFound      = errors.New("文档不存在")
+	ErrProjectNotFound       = errors.New("项目不存在")
+	ErrNoPermission          = errors.New("没有操作权限")
+	ErrInvalidEmbeddingModel = errors.New("向量模型不存在")
+	ErrUnsupportedDocument   = errors.New("不支持的文档类型")
+	ErrEmptyDocument         = errors.New("文档内容为空")
+	ErrFileTooLarge          = errors.New("文件过大")
+	ErrKnowledgeBaseArchived = errors.New("知识库已归档")
+	ErrDuplicateDocument     = errors.New("知识库中已有相同内容的文档")
+	ErrDocumentInProgress    = errors.New("文档正在处理中")
+	ErrDocumentNotInProgress = errors.New("文档不在处理中")
+	ErrDocumentNotFailed     = errors.New("只能重试处理失败的文档")
+	ErrInvalidChunking       = errors.New("分块设置无效")
+	ErrInvalidFilter         = errors.New("过滤条件无效")
+	ErrInvalidRetrieval      = errors.New("检索设置无效")
+)
+
+// Config 知识库服务配置
+type Config struct {
+	DefaultEmbeddingModel string // 创建时未指定向量模型时使用
+	MaxDocumentSize       int64  // 上传文档的最大字节数
+}
+
+// Service 提供知识库及其文档的管理与检索，负责归属与权限检查，文档与向量数据由 core/kb 管理
+type Service struct {
+	db        *gorm.DB
+	manager   *kb.KnowledgeBaseManager
+	retriever kb.Retriever
+	config    Config
+	logger    *zap.Logger
+}
+
+// NewService 创建新的知识库服务
+func NewService(db *gorm.DB, manager *kb.KnowledgeBaseManager, retriever kb.Retriever, config Config) *Service {
+	return &Service{
+		db:        db,
+		manager:   manager,
+		retriever: retriever,
+		config:    config,
+		logger:    zap.L().With(zap.String("service", "knowledge")),
+	}
+}
+
+// Restore 启动时确保所有知识库的向量集合存在，使用内存向量数据库时重建空集合
+func (s *Service) Restore(ctx context.Context) error {
+	return s.manager.EnsureCollections(ctx)
+}
+
+// CreateKnowledgeBase 创建知识库，指定项目时要求当前用户是项目所有者
+func (s *Service) CreateKnowledgeBase(ctx context.Context, req models.CreateKnowledgeBaseRequest, userID uuid.UUID) (*models.KnowledgeBaseResponse, error) {
+	if req.ProjectID != nil {
+		var project models.Project
+		if err := s.db.Select("id", "owner_id").First(&project, "id = ?", *req.ProjectID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrProjectNotFound
+			}
+			s.logger.Error("Failed to find project", zap.Error(err))
+			return nil, err
+		}
+		if project.OwnerID != userID {
+			return nil, ErrNoPermission
+		}
+	}
+
+	embeddingModel := req.EmbeddingModel
+	if embeddingModel == "" {
+		embeddingModel = s.config.DefaultEmbeddingModel
+	}
+	if _, err := kb.GetEmbeddingManager().GetModel(embeddingModel); err != nil {
+		return nil, ErrInvalidEmbeddingModel
+	}
+
+	record := models.KnowledgeBase{
+		Name:            req.Name,
+		Description:     req.Description,
+		Type:            req.Type,
+		Config:          req.Config,
+		Status:          "active",
+		EmbeddingModel:  embeddingModel,
+		ChunkStrategy:   req.ChunkStrategy,
+		ChunkSize:       req.ChunkSize,
+		ChunkOverlap:    kb.DefaultChunkOverlap,
+		RetrievalMode:   req.RetrievalMode,
+		FusionMethod:    req.FusionMethod,
+		KeywordWeight:   kb.DefaultKeywordWeight,
+		Reranker:        req.Reranker,
+		RerankThreshold: req.RerankThreshold,
+		ProjectID:       req.ProjectID,
+		CreatedBy:       userID,
+	}
+	if record.Type == "" {
+		record.Type = "file"
+	}
+	if record.Config == "" {
+		record.Config = "{}"
+	}
+	if record.ChunkStrategy == "" {
+		record.ChunkStrategy = kb.ChunkStrategyRecursive
+	}
+	if record.ChunkSize == 0 {
+		record.ChunkSize = kb.DefaultChunkSize
+	}
+	if req.ChunkOverlap != nil {
+		record.ChunkOverlap = *req.ChunkOverlap
+	}
+	if err := s.validateChunking(&record); err != nil {
+		return nil, err
+	}
+	if record.RetrievalMode == "" {
+		record.RetrievalMode = kb.RetrievalModeHybrid
+	}
+	if record.FusionMethod == "" {
+		record.FusionMethod = kb.FusionRRF
+	}
+	if req.KeywordWeight != nil {
+		record.KeywordWeight = *req.KeywordWeight
+	}
+	if err := s.validateRetrieval(&record); err != nil {
+		return nil, err
+	}
+
+	if err := s.manager.CreateKnowledgeBase(ctx, &record); err != nil {
+		s.logger.Error("Failed to create knowledge base", zap.Error(err))
+		return nil, err
+	}
+
+	response := record.ToResponse()
+	return &response, nil
+}
+
+// ListKnowledgeBases 列出当前用户创建的以及其所有项目下的知识库
+func (s *Service) ListKnowledgeBases(userID uuid.UUID, projectID *uuid.UUID) ([]models.KnowledgeBaseResponse, error) {
+	query := s.db.Where("created_by = ? OR project_id IN (?)", userID,
+		s.db.Model(&models.Project{}).Select("id").Where("owner_id = ?", userID))
+	if projectID != nil {
+		query = query.Where("project_id = ?", *projectID)
+	}
+
+	var records []models.KnowledgeBase
+	if err := query.Order("created_at desc").Find(&records).Error; err != nil {
+		s.logger.Error("Failed to list knowledge bases", zap.Error(err))
+		return nil, err
+	}
+
+	responses := make([]models.KnowledgeBaseResponse, len(records))
+	for i := range records {
+		responses[i] = records[i].ToResponse()
+	}
+	return responses, nil
+}
+
+// GetKnowledgeBase 获取知识库详情
+func (s *Service) GetKnowledgeBase(id, userID uuid.UUID) (*models.KnowledgeBaseResponse, error) {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	response := record.ToResponse()
+	return &response, nil
+}
+
+// UpdateKnowledgeBase 更新知识库基本信息
+func (s *Service) UpdateKnowledgeBase(id uuid.UUID, req models.UpdateKnowledgeBaseRequest, userID uuid.UUID) (*models.KnowledgeBaseResponse, error) {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != "" {
+		updates["name"] = req.Name
+	}
+	if req.Description != "" {
+		updates["description"] = req.Description
+	}
+	if req.Config != "" {
+		updates["config"] = req.Config
+	}
+	if req.Status != "" {
+		updates["status"] = req.Status
+	}
+
+	// 分块设置需要整体校验，未修改的字段沿用当前值
+	chunking := *record
+	if req.ChunkStrategy != "" {
+		chunking.ChunkStrategy = req.ChunkStrategy
+		updates["chunk_strategy"] = req.ChunkStrategy
+	}
+	if req.ChunkSize != 0 {
+		chunking.ChunkSize = req.ChunkSize
+		updates["chunk_size"] = req.ChunkSize
+	}
+	if req.ChunkOverlap != nil {
+		chunking.ChunkOverlap = *req.ChunkOverlap
+		updates["chunk_overlap"] = *req.ChunkOverlap
+	}
+	if err := s.validateChunking(&chunking); err != nil {
+		return nil, err
+	}
+
+	// 检索设置同样整体校验
+	retrieval := *record
+	if req.RetrievalMode != "" {
+		retrieval.RetrievalMode = req.RetrievalMode
+		updates["retrieval_mode"] = req.RetrievalMode
+	}
+	if req.FusionMethod != "" {
+		retrieval.FusionMethod = req.FusionMethod
+		updates["fusion_method"] = req.FusionMethod
+	}
+	if req.KeywordWeight != nil {
+		retrieval.KeywordWeight = *req.KeywordWeight
+		updates["keyword_weight"] = *req.KeywordWeight
+	}
+	if req.Reranker != nil {
+		retrieval.Reranker = *req.Reranker
+		updates["reranker"] = *req.Reranker
+	}
+	if req.RerankThreshold != nil {
+		retrieval.RerankThreshold = *req.RerankThreshold
+		updates["rerank_threshold"] = *req.RerankThreshold
+	}
+	if err := s.validateRetrieval(&retrieval); err != nil {
+		return nil, err
+	}
+
+	if len(updates) > 0 {
+		if err := s.db.Model(record).Updates(updates).Error; err != nil {
+			s.logger.Error("Failed to update knowledge base", zap.Error(err))
+			return nil, err
+		}
+		if err := s.db.First(record, "id = ?", id).Error; err != nil {
+			s.logger.Error("Failed to reload knowledge base", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	response := record.ToResponse()
+	return &response, nil
+}
+
+// DeleteKnowledgeBase 删除知识库及其向量数据，并解除与智能体的关联
+func (s *Service) DeleteKnowledgeBase(ctx context.Context, id, userID uuid.UUID) error {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&models.AgentKnowledgeBase{}, "knowledge_base_id = ?", record.ID).Error; err != nil {
+		s.logger.Error("Failed to unlink knowledge base from agents", zap.Error(err))
+		return err
+	}
+	if err := s.manager.DeleteKnowledgeBase(ctx, record.ID.String()); err != nil {
+		return s.documentError(err, "Failed to delete knowledge base")
+	}
+	return nil
+}
+
+// UploadDocument 上传文件并加入入库队列
+func (s *Service) UploadDocument(ctx context.Context, id, userID uuid.UUID, file *multipart.FileHeader, tags []string) (*models.Document, error) {
+	if s.config.MaxDocumentSize > 0 && file.Size > s.config.MaxDocumentSize {
+		return nil, ErrFileTooLarge
+	}
+
+	record, err := s.writable(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.manager.AddDocument(ctx, record.ID.String(), file, tags)
+	if err != nil {
+		return nil, s.documentError(err, "Failed to add document")
+	}
+	return doc, nil
+}
+
+// AddTextDocument 将文本内容作为文档加入入库队列
+func (s *Service) AddTextDocument(ctx context.Context, id, userID uuid.UUID, req models.AddTextDocumentRequest) (*models.Document, error) {
+	record, err := s.writable(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	docType := kb.DocumentType(req.Type)
+	if docType == "" {
+		docType = kb.TypeText
+	}
+
+	doc, err := s.manager.AddTextDocument(ctx, record.ID.String(), req.Name, req.Content, docType, req.Tags)
+	if err != nil {
+		return nil, s.documentError(err, "Failed to add text document")
+	}
+	return doc, nil
+}
+
+// ListDocuments 列出知识库中的文档
+func (s *Service) ListDocuments(ctx context.Context, id, userID uuid.UUID) ([]models.Document, error) {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := s.manager.GetDocuments(ctx, record.ID.String())
+	if err != nil {
+		return nil, s.documentError(err, "Failed to list documents")
+	}
+	return docs, nil
+}
+
+// GetDocument 获取文档及其处理进度
+func (s *Service) GetDocument(ctx context.Context, id, userID uuid.UUID, documentID string) (*models.Document, error) {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.manager.GetDocument(ctx, record.ID.String(), documentID)
+	if err != nil {
+		return nil, s.documentError(err, "Failed to get document")
+	}
+	return doc, nil
+}
+
+// ReindexDocument 将文档重新排队，重新分块并向量化
+func (s *Service) ReindexDocument(ctx context.Context, id, userID uuid.UUID, documentID string) (*models.Document, error) {
+	record, err := s.writable(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.manager.ReindexDocument(ctx, record.ID.String(), documentID)
+	if err != nil {
+		return nil, s.documentError(err, "Failed to reindex document")
+	}
+	return doc, nil
+}
+
+// RetryDocument 重新处理失败的文档
+func (s *Service) RetryDocument(ctx context.Context, id, userID uuid.UUID, documentID string) (*models.Document, error) {
+	record, err := s.writable(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.manager.RetryDocument(ctx, record.ID.String(), documentID)
+	if err != nil {
+		return nil, s.documentError(err, "Failed to retry document")
+	}
+	return doc, nil
+}
+
+// CancelDocument 取消排队或处理中的文档
+func (s *Service) CancelDocument(ctx context.Context, id, userID uuid.UUID, documentID string) error {
+	record, err := s.writable(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.manager.CancelDocument(ctx, record.ID.String(), documentID); err != nil {
+		return s.documentError(err, "Failed to cancel document")
+	}
+	return nil
+}
+
+// DeleteDocument 删除文档及其向量数据
+func (s *Service) DeleteDocument(ctx context.Context, id, userID uuid.UUID, documentID string) error {
+	record, err := s.writable(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.manager.DeleteDocument(ctx, record.ID.String(), documentID); err != nil {
+		return s.documentError(err, "Failed to delete document")
+	}
+	return nil
+}
+
+// Query 在知识库中检索与查询相关的内容
+func (s *Service) Query(ctx context.Context, id, userID uuid.UUID, req models.QueryKnowledgeBaseRequest) (*kb.QueryResponse, error) {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := kb.ParseFilter(req.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+
+	resp, err := s.retriever.Retrieve(ctx, kb.QueryRequest{
+		KnowledgeBaseID: record.ID.String(),
+		Query:           req.Query,
+		TopK:            req.TopK,
+		Filter:          filter,
+	})
+	if err != nil {
+		s.logger.Error("Failed to query knowledge base", zap.String("knowledge_base_id", record.ID.String()), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Authorize 检查用户能否访问知识库，供工作流等其他模块复用
+func (s *Service) Authorize(id, userID uuid.UUID) error {
+	_, err := s.authorize(id, userID)
+	return err
+}
+
+// authorize 查找知识库并检查当前用户是创建者或所属项目的所有者
+func (s *Service) authorize(id, userID uuid.UUID) (*models.KnowledgeBase, error) {
+	var record models.KnowledgeBase
+	if err := s.db.First(&record, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKnowledgeBaseNotFound
+		}
+		s.logger.Error("Failed to find knowledge base", zap.Error(err))
+		return nil, err
+	}
+
+	if record.CreatedBy == userID {
+		return &record, nil
+	}
+	if record.ProjectID != nil {
+		var count int64
+		if err := s.db.Model(&models.Project{}).
+			Where("id = ? AND owner_id = ?", *record.ProjectID, userID).
+			Count(&count).Error; err != nil {
+			s.logger.Error("Failed to check project ownership", zap.Error(err))
+			return nil, err
+		}
+		if count > 0 {
+			return &record, nil
+		}
+	}
+	return nil, ErrNoPermission
+}
+
+// writable 获取可写入文档的知识库，已归档的知识库只读
+func (s *Service) writable(id, userID uuid.UUID) (*models.KnowledgeBase, error) {
+	record, err := s.authorize(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status == "archived" {
+		return nil, ErrKnowledgeBaseArchived
+	}
+	return record, nil
+}
+
+// validateChunking 检查分块策略、大小和重叠的组合是否可用
+func (s *Service) validateChunking(record *models.KnowledgeBase) error {
+	if _, err := kb.NewChunker(kb.ChunkingConfig{
+		Strategy:       record.ChunkStrategy,
+		Size:           record.ChunkSize,
+		Overlap:        record.ChunkOverlap,
+		EmbeddingModel: record.EmbeddingModel,
+	}, kb.GetEmbeddingManager()); err != nil {
+		return ErrInvalidChunking
+	}
+	return nil
+}
+
+// validateRetrieval 检查检索设置，指定的重排器需已注册
+func (s *Service) validateRetrieval(record *models.KnowledgeBase) error {
+	if err := kb.ValidateRetrievalSettings(record); err != nil {
+		return ErrInvalidRetrieval
+	}
+	if record.Reranker != "" {
+		if _, err := kb.GetRerankerManager().GetReranker(record.Reranker); err != nil {
+			return ErrInvalidRetrieval
+		}
+	}
+	return nil
+}
+
+// documentError 将知识库管理器的错误转换为服务错误
+func (s *Service) documentError(err error, message string) error {
+	switch {
+	case errors.Is(err, kb.ErrDocumentNotFound):
+		return ErrDocumentNotFound
+	case errors.Is(err, kb.ErrKnowledgeBaseNotFound):
+		return ErrKnowledgeBaseNotFound
+	case errors.Is(err, kb.ErrUnsupportedDocumentType):
+		return ErrUnsupportedDocument
+	case errors.Is(err, kb.ErrEmptyDocument):
+		return ErrEmptyDocument
+	case errors.Is(err, kb.ErrDuplicateDocument):
+		return ErrDuplicateDocument
+	case errors.Is(err, kb.ErrDocumentInProgress):
+		return ErrDocumentInProgress
+	case errors.Is(err, kb.ErrDocumentNotInProgress):
+		return ErrDocumentNotInProgress
+	case errors.Is(err, kb.ErrDocumentNotFailed):
+		return ErrDocumentNotFailed
+	case errors.Is(err, kb.ErrUnsupportedChunkStrategy):
+		return ErrInvalidChunking
+	case errors.Is(err, kb.ErrInvalidRetrievalSettings):
+		return ErrInvalidRetrieval
+	default:
+		s.logger.Error(message, zap.Error(err))
+		return err
+	}
+}
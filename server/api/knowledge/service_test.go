@@ -0,0 +1,490 @@
+package knowledge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// stubRetriever 记录收到的检索请求
+type stubRetriever struct {
+	requests []kb.QueryRequest
+}
+
+func (r *stubRetriever) Retrieve(ctx context.Context, req kb.QueryRequest) (*kb.QueryResponse, error) {
+	r.requests = append(r.requests, req)
+	return &kb.QueryResponse{Query: req.Query}, nil
+}
+
+// newTestService 创建使用测试数据库和内存向量数据库的知识库服务，入库流水线不启动，文档保持排队状态
+func newTestService(t *testing.T) (*Service, *gorm.DB, *stubRetriever) {
+	t.Helper()
+	db := testdb.Open(t,
+		&models.Project{},
+		&models.KnowledgeBase{},
+		&models.Document{},
+		&models.Chunk{},
+		&models.AgentKnowledgeBase{},
+	)
+	manager := kb.NewKnowledgeBaseManager(db, kb.NewInMemoryVectorDB(), kb.GetEmbeddingManager(), kb.DefaultProcessorRegistry)
+	retriever := &stubRetriever{}
+	service := NewService(db, manager, retriever, Config{DefaultEmbeddingModel: "mock", MaxDocumentSize: 1 << 10})
+	return service, db, retriever
+}
+
+func createTestProject(t *testing.T, db *gorm.DB, ownerID uuid.UUID) *models.Project {
+	t.Helper()
+	project := models.Project{Name: "test", OwnerID: ownerID, Config: "{}"}
+	if err := db.Create(&project).Error; err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	return &project
+}
+
+func createTestKnowledgeBase(t *testing.T, service *Service, userID uuid.UUID, projectID *uuid.UUID) *models.KnowledgeBaseResponse {
+	t.Helper()
+	record, err := service.CreateKnowledgeBase(context.Background(), models.CreateKnowledgeBaseRequest{Name: "docs", ProjectID: projectID}, userID)
+	if err != nil {
+		t.Fatalf("create knowledge base: %v", err)
+	}
+	return record
+}
+
+// moveToProject 将知识库移到项目下，模拟项目转让后创建者不再是项目所有者
+func moveToProject(t *testing.T, db *gorm.DB, id, projectID uuid.UUID) {
+	t.Helper()
+	if err := db.Model(&models.KnowledgeBase{}).Where("id = ?", id).Update("project_id", projectID).Error; err != nil {
+		t.Fatalf("move knowledge base: %v", err)
+	}
+}
+
+// archive 将知识库归档
+func archive(t *testing.T, service *Service, id, userID uuid.UUID) {
+	t.Helper()
+	if _, err := service.UpdateKnowledgeBase(id, models.UpdateKnowledgeBaseRequest{Status: "archived"}, userID); err != nil {
+		t.Fatalf("archive knowledge base: %v", err)
+	}
+}
+
+func TestCreateKnowledgeBase(t *testing.T) {
+	ctx := context.Background()
+	service, db, _ := newTestService(t)
+	userID := uuid.New()
+	project := createTestProject(t, db, userID)
+	otherProject := createTestProject(t, db, uuid.New())
+	missingProject := uuid.New()
+	overlap := 1000
+	weight := 2.0
+
+	errTests := []struct {
+		name    string
+		req     models.CreateKnowledgeBaseRequest
+		wantErr error
+	}{
+		{"unknown project", models.CreateKnowledgeBaseRequest{Name: "kb", ProjectID: &missingProject}, ErrProjectNotFound},
+		{"project of another user", models.CreateKnowledgeBaseRequest{Name: "kb", ProjectID: &otherProject.ID}, ErrNoPermission},
+		{"unknown embedding model", models.CreateKnowledgeBaseRequest{Name: "kb", EmbeddingModel: "missing"}, ErrInvalidEmbeddingModel},
+		{"overlap not below chunk size", models.CreateKnowledgeBaseRequest{Name: "kb", ChunkOverlap: &overlap}, ErrInvalidChunking},
+		{"keyword weight out of range", models.CreateKnowledgeBaseRequest{Name: "kb", KeywordWeight: &weight}, ErrInvalidRetrieval},
+		{"unknown reranker", models.CreateKnowledgeBaseRequest{Name: "kb", Reranker: "missing"}, ErrInvalidRetrieval},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.CreateKnowledgeBase(ctx, tt.req, userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateKnowledgeBase error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	var count int64
+	db.Model(&models.KnowledgeBase{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d knowledge bases stored by rejected requests, want 0", count)
+	}
+
+	record := createTestKnowledgeBase(t, service, userID, &project.ID)
+	if record.EmbeddingModel != "mock" || record.Type != "file" || record.Status != "active" {
+		t.Errorf("knowledge base = %+v, want active file knowledge base using the default embedding model", record)
+	}
+	if record.ChunkStrategy != kb.ChunkStrategyRecursive || record.RetrievalMode != kb.RetrievalModeHybrid || record.FusionMethod != kb.FusionRRF {
+		t.Errorf("knowledge base settings = %s/%s/%s, want defaults", record.ChunkStrategy, record.RetrievalMode, record.FusionMethod)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	service, db, _ := newTestService(t)
+	creatorID := uuid.New()
+	projectOwnerID := uuid.New()
+	project := createTestProject(t, db, projectOwnerID)
+	shared := createTestKnowledgeBase(t, service, creatorID, nil)
+	moveToProject(t, db, shared.ID, project.ID)
+	private := createTestKnowledgeBase(t, service, creatorID, nil)
+
+	tests := []struct {
+		name    string
+		id      uuid.UUID
+		userID  uuid.UUID
+		wantErr error
+	}{
+		{"creator", private.ID, creatorID, nil},
+		{"project owner", shared.ID, projectOwnerID, nil},
+		{"project owner without project", private.ID, projectOwnerID, ErrNoPermission},
+		{"other user", shared.ID, uuid.New(), ErrNoPermission},
+		{"unknown knowledge base", uuid.New(), creatorID, ErrKnowledgeBaseNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := service.Authorize(tt.id, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Authorize error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := service.GetKnowledgeBase(tt.id, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("GetKnowledgeBase error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	listTests := []struct {
+		name      string
+		userID    uuid.UUID
+		projectID *uuid.UUID
+		want      []uuid.UUID
+	}{
+		{"creator", creatorID, nil, []uuid.UUID{private.ID, shared.ID}},
+		{"creator in project", creatorID, &project.ID, []uuid.UUID{shared.ID}},
+		{"project owner", projectOwnerID, nil, []uuid.UUID{shared.ID}},
+		{"other user", uuid.New(), nil, nil},
+	}
+	for _, tt := range listTests {
+		t.Run("list "+tt.name, func(t *testing.T) {
+			records, err := service.ListKnowledgeBases(tt.userID, tt.projectID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := knowledgeBaseIDs(records); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ListKnowledgeBases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func knowledgeBaseIDs(records []models.KnowledgeBaseResponse) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func TestUpdateKnowledgeBase(t *testing.T) {
+	service, _, _ := newTestService(t)
+	userID := uuid.New()
+	record := createTestKnowledgeBase(t, service, userID, nil)
+	size := 200
+	overlap := 300
+	threshold := 1.5
+	missing := "missing"
+
+	errTests := []struct {
+		name    string
+		id      uuid.UUID
+		userID  uuid.UUID
+		req     models.UpdateKnowledgeBaseRequest
+		wantErr error
+	}{
+		{"unknown knowledge base", uuid.New(), userID, models.UpdateKnowledgeBaseRequest{Name: "x"}, ErrKnowledgeBaseNotFound},
+		{"other user", record.ID, uuid.New(), models.UpdateKnowledgeBaseRequest{Name: "x"}, ErrNoPermission},
+		// 只改分块大小时与当前的重叠一起校验
+		{"size below current overlap", record.ID, userID, models.UpdateKnowledgeBaseRequest{ChunkSize: size - 50}, ErrInvalidChunking},
+		{"overlap above size", record.ID, userID, models.UpdateKnowledgeBaseRequest{ChunkSize: size, ChunkOverlap: &overlap}, ErrInvalidChunking},
+		{"unknown strategy", record.ID, userID, models.UpdateKnowledgeBaseRequest{ChunkStrategy: "paragraph"}, ErrInvalidChunking},
+		{"threshold out of range", record.ID, userID, models.UpdateKnowledgeBaseRequest{RerankThreshold: &threshold}, ErrInvalidRetrieval},
+		{"unknown reranker", record.ID, userID, models.UpdateKnowledgeBaseRequest{Reranker: &missing}, ErrInvalidRetrieval},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.UpdateKnowledgeBase(tt.id, tt.req, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("UpdateKnowledgeBase error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	zero := 0
+	updated, err := service.UpdateKnowledgeBase(record.ID, models.UpdateKnowledgeBaseRequest{
+		Name:          "renamed",
+		ChunkSize:     size,
+		ChunkOverlap:  &zero,
+		RetrievalMode: kb.RetrievalModeVector,
+	}, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "renamed" || updated.ChunkSize != size || updated.ChunkOverlap != 0 || updated.RetrievalMode != kb.RetrievalModeVector {
+		t.Errorf("updated knowledge base = %+v", updated)
+	}
+}
+
+func TestArchivedKnowledgeBaseIsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	service, _, retriever := newTestService(t)
+	userID := uuid.New()
+	record := createTestKnowledgeBase(t, service, userID, nil)
+	doc, err := service.AddTextDocument(ctx, record.ID, userID, models.AddTextDocumentRequest{Name: "faq", Content: "refund policy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive(t, service, record.ID, userID)
+	documentID := doc.ID.String()
+
+	writes := map[string]func() error{
+		"add text document": func() error {
+			_, err := service.AddTextDocument(ctx, record.ID, userID, models.AddTextDocumentRequest{Name: "more", Content: "shipping"})
+			return err
+		},
+		"reindex document": func() error {
+			_, err := service.ReindexDocument(ctx, record.ID, userID, documentID)
+			return err
+		},
+		"retry document": func() error {
+			_, err := service.RetryDocument(ctx, record.ID, userID, documentID)
+			return err
+		},
+		"cancel document": func() error {
+			return service.CancelDocument(ctx, record.ID, userID, documentID)
+		},
+		"delete document": func() error {
+			return service.DeleteDocument(ctx, record.ID, userID, documentID)
+		},
+	}
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			if err := write(); !errors.Is(err, ErrKnowledgeBaseArchived) {
+				t.Errorf("error = %v, want %v", err, ErrKnowledgeBaseArchived)
+			}
+		})
+	}
+
+	// 归档后仍可读取和检索
+	if docs, err := service.ListDocuments(ctx, record.ID, userID); err != nil || len(docs) != 1 {
+		t.Errorf("ListDocuments = %d documents, %v, want 1", len(docs), err)
+	}
+	if _, err := service.GetDocument(ctx, record.ID, userID, documentID); err != nil {
+		t.Errorf("GetDocument error = %v", err)
+	}
+	if _, err := service.Query(ctx, record.ID, userID, models.QueryKnowledgeBaseRequest{Query: "refund"}); err != nil || len(retriever.requests) != 1 {
+		t.Errorf("Query error = %v, %d requests, want 1", err, len(retriever.requests))
+	}
+
+	// 重新启用后可以写入
+	if _, err := service.UpdateKnowledgeBase(record.ID, models.UpdateKnowledgeBaseRequest{Status: "active"}, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := writes["cancel document"](); err != nil {
+		t.Errorf("CancelDocument after reactivation error = %v", err)
+	}
+}
+
+func TestWritableRequiresPermission(t *testing.T) {
+	ctx := context.Background()
+	service, db, _ := newTestService(t)
+	creatorID := uuid.New()
+	projectOwnerID := uuid.New()
+	project := createTestProject(t, db, projectOwnerID)
+	record := createTestKnowledgeBase(t, service, creatorID, nil)
+	moveToProject(t, db, record.ID, project.ID)
+
+	tests := []struct {
+		name    string
+		id      uuid.UUID
+		userID  uuid.UUID
+		wantErr error
+	}{
+		{"project owner", record.ID, projectOwnerID, nil},
+		{"other user", record.ID, uuid.New(), ErrNoPermission},
+		{"unknown knowledge base", uuid.New(), creatorID, ErrKnowledgeBaseNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.AddTextDocument(ctx, tt.id, tt.userID, models.AddTextDocumentRequest{Name: tt.name, Content: tt.name})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddTextDocument error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteKnowledgeBase(t *testing.T) {
+	ctx := context.Background()
+	service, db, _ := newTestService(t)
+	userID := uuid.New()
+	record := createTestKnowledgeBase(t, service, userID, nil)
+	agentID := uuid.New()
+	if err := db.Create(&models.AgentKnowledgeBase{AgentID: agentID, KnowledgeBaseID: record.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.AddTextDocument(ctx, record.ID, userID, models.AddTextDocumentRequest{Name: "faq", Content: "refund policy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.DeleteKnowledgeBase(ctx, record.ID, uuid.New()); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("DeleteKnowledgeBase by other user error = %v, want %v", err, ErrNoPermission)
+	}
+	if err := service.DeleteKnowledgeBase(ctx, record.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.GetKnowledgeBase(record.ID, userID); !errors.Is(err, ErrKnowledgeBaseNotFound) {
+		t.Errorf("GetKnowledgeBase after delete error = %v, want %v", err, ErrKnowledgeBaseNotFound)
+	}
+	for _, model := range []interface{}{&models.AgentKnowledgeBase{}, &models.Document{}} {
+		var count int64
+		db.Model(model).Where("knowledge_base_id = ?", record.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d %T rows kept after delete, want 0", count, model)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	service, _, retriever := newTestService(t)
+	userID := uuid.New()
+	record := createTestKnowledgeBase(t, service, userID, nil)
+
+	if _, err := service.Query(ctx, record.ID, uuid.New(), models.QueryKnowledgeBaseRequest{Query: "refund"}); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("Query by other user error = %v, want %v", err, ErrNoPermission)
+	}
+	invalid := map[string]interface{}{"tags": map[string]interface{}{"near": "faq"}}
+	if _, err := service.Query(ctx, record.ID, userID, models.QueryKnowledgeBaseRequest{Query: "refund", Filters: invalid}); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Query with invalid filter error = %v, want %v", err, ErrInvalidFilter)
+	}
+	if len(retriever.requests) != 0 {
+		t.Fatalf("%d rejected queries reached the retriever", len(retriever.requests))
+	}
+
+	filters := map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}}
+	if _, err := service.Query(ctx, record.ID, userID, models.QueryKnowledgeBaseRequest{Query: "refund", TopK: 3, Filters: filters}); err != nil {
+		t.Fatal(err)
+	}
+	req := retriever.requests[0]
+	if req.KnowledgeBaseID != record.ID.String() || req.Query != "refund" || req.TopK != 3 || req.Filter == nil {
+		t.Errorf("retriever request = %+v", req)
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newTestService(t)
+	ownerID, otherID := uuid.New(), uuid.New()
+	record := createTestKnowledgeBase(t, service, ownerID, nil)
+
+	doc, err := service.AddTextDocument(ctx, record.ID, ownerID, models.AddTextDocumentRequest{Name: "faq", Content: "refund policy"})
+	if err != nil {
+		t.Fatalf("AddTextDocument: %v", err)
+	}
+	if doc.Type != string(kb.TypeText) || doc.Status != models.DocumentStatusQueued {
+		t.Errorf("document Type = %q, Status = %q, want queued text document", doc.Type, doc.Status)
+	}
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"duplicate content", func() error {
+			_, err := service.AddTextDocument(ctx, record.ID, ownerID, models.AddTextDocumentRequest{Name: "copy", Content: "refund policy"})
+			return err
+		}, ErrDuplicateDocument},
+		{"unsupported type", func() error {
+			_, err := service.AddTextDocument(ctx, record.ID, ownerID, models.AddTextDocumentRequest{Name: "doc", Content: "x", Type: "rtf"})
+			return err
+		}, ErrUnsupportedDocument},
+		{"file too large", func() error {
+			_, err := service.UploadDocument(ctx, record.ID, ownerID, &multipart.FileHeader{Filename: "big.txt", Size: 2 << 10}, nil)
+			return err
+		}, ErrFileTooLarge},
+		{"add by other user", func() error {
+			_, err := service.AddTextDocument(ctx, record.ID, otherID, models.AddTextDocumentRequest{Name: "doc", Content: "other"})
+			return err
+		}, ErrNoPermission},
+		{"list by other user", func() error {
+			_, err := service.ListDocuments(ctx, record.ID, otherID)
+			return err
+		}, ErrNoPermission},
+		{"get by other user", func() error {
+			_, err := service.GetDocument(ctx, record.ID, otherID, doc.ID.String())
+			return err
+		}, ErrNoPermission},
+		{"delete by other user", func() error {
+			return service.DeleteDocument(ctx, record.ID, otherID, doc.ID.String())
+		}, ErrNoPermission},
+		{"unknown document", func() error {
+			_, err := service.GetDocument(ctx, record.ID, ownerID, uuid.NewString())
+			return err
+		}, ErrDocumentNotFound},
+		{"invalid document id", func() error {
+			return service.DeleteDocument(ctx, record.ID, ownerID, "invalid")
+		}, ErrDocumentNotFound},
+		{"unknown knowledge base", func() error {
+			_, err := service.ListDocuments(ctx, uuid.New(), ownerID)
+			return err
+		}, ErrKnowledgeBaseNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.run(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	docs, err := service.ListDocuments(ctx, record.ID, ownerID)
+	if err != nil {
+		t.Fatalf("ListDocuments: %v", err)
+	}
+	if len(docs) != 1 || docs[0].ID != doc.ID {
+		t.Errorf("ListDocuments = %d documents, want only %s", len(docs), doc.ID)
+	}
+
+	if err := service.DeleteDocument(ctx, record.ID, ownerID, doc.ID.String()); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if _, err := service.GetDocument(ctx, record.ID, ownerID, doc.ID.String()); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("GetDocument after delete error = %v, want %v", err, ErrDocumentNotFound)
+	}
+}
+
+func TestDocumentError(t *testing.T) {
+	service := NewService(nil, nil, nil, Config{})
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{kb.ErrDocumentNotFound, ErrDocumentNotFound},
+		{kb.ErrKnowledgeBaseNotFound, ErrKnowledgeBaseNotFound},
+		{fmt.Errorf("wrapped: %w", kb.ErrUnsupportedDocumentType), ErrUnsupportedDocument},
+		{kb.ErrEmptyDocument, ErrEmptyDocument},
+		{kb.ErrDuplicateDocument, ErrDuplicateDocument},
+		{kb.ErrDocumentInProgress, ErrDocumentInProgress},
+		{kb.ErrDocumentNotInProgress, ErrDocumentNotInProgress},
+		{kb.ErrDocumentNotFailed, ErrDocumentNotFailed},
+		{kb.ErrUnsupportedChunkStrategy, ErrInvalidChunking},
+		{kb.ErrInvalidRetrievalSettings, ErrInvalidRetrieval},
+	}
+	for _, tt := range tests {
+		if got := service.documentError(tt.err, "test"); !errors.Is(got, tt.want) {
+			t.Errorf("documentError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+
+	other := errors.New("connection reset")
+	if got := service.documentError(other, "test"); got != other {
+		t.Errorf("documentError(%v) = %v, want the original error", other, got)
+	}
+}
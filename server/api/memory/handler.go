@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// Handler 处理用户长期记忆相关的HTTP请求
+type Handler struct {
+	service        *Service
+	authMiddleware *middleware.AuthMiddleware
+	logger         *zap.Logger
+}
+
+// NewHandler 创建新的长期记忆处理器
+func NewHandler(service *Service, authMiddleware *middleware.AuthMiddleware) *Handler {
+	return &Handler{
+		service:        service,
+		authMiddleware: authMiddleware,
+		logger:         zap.L().With(zap.String("handler", "memory")),
+	}
+}
+
+// RegisterRoutes 注册长期记忆相关的路由
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// 智能体下当前用户的记忆
+	agentMemories := router.Group("/agents/by-id/:agent_id/memories")
+	agentMemories.Use(h.authMiddleware.Authenticate())
+	{
+		agentMemories.GET("", h.ListMemories)
+		agentMemories.DELETE("", h.ClearMemories)
+	}
+
+	// 单条记忆操作
+	memories := router.Group("/memories")
+	memories.Use(h.authMiddleware.Authenticate())
+	{
+		memories.PUT("/:id", h.UpdateMemory)
+		memories.DELETE("/:id", h.DeleteMemory)
+	}
+}
+
+// ListMemories 列出智能体对当前用户的长期记忆
+func (h *Handler) ListMemories(c *gin.Context) {
+	agentID, userID, ok := h.parseRequest(c, "agent_id", "无效的智能体ID")
+	if !ok {
+		return
+	}
+
+	memories, err := h.service.ListMemories(agentID, userID)
+	if err != nil {
+		h.handleError(c, err, "获取记忆失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"memories": memories})
+}
+
+// ClearMemories 清空智能体对当前用户的长期记忆
+func (h *Handler) ClearMemories(c *gin.Context) {
+	agentID, userID, ok := h.parseRequest(c, "agent_id", "无效的智能体ID")
+	if !ok {
+		return
+	}
+
+	if err := h.service.ClearMemories(agentID, userID); err != nil {
+		h.handleError(c, err, "清空记忆失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "记忆已清空"})
+}
+
+// UpdateMemory 编辑一条长期记忆
+func (h *Handler) UpdateMemory(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c, "id", "无效的记忆ID")
+	if !ok {
+		return
+	}
+
+	// 解析请求体
+	var req models.UpdateUserMemoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	memory, err := h.service.UpdateMemory(id, req, userID)
+	if err != nil {
+		h.handleError(c, err, "更新记忆失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, memory)
+}
+
+// DeleteMemory 删除一条长期记忆
+func (h *Handler) DeleteMemory(c *gin.Context) {
+	id, userID, ok := h.parseRequest(c, "id", "无效的记忆ID")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteMemory(id, userID); err != nil {
+		h.handleError(c, err, "删除记忆失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "记忆已删除"})
+}
+
+// parseRequest 解析URL中的ID和当前用户
+func (h *Handler) parseRequest(c *gin.Context, param, invalidMessage string) (uuid.UUID, uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidMessage})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	// 从上下文获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return id, userID.(uuid.UUID), true
+}
+
+// handleError 将服务错误转换为HTTP响应
+func (h *Handler) handleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrMemoryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "记忆不存在"})
+	case errors.Is(err, ErrAgentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "智能体不存在"})
+	case errors.Is(err, ErrUnauthorized):
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此记忆"})
+	default:
+		h.logger.Error("Memory request failed", zap.Error(err), zap.String("path", c.FullPath()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMemoryNotFound = errors.New("记忆不存在")
+	ErrAgentNotFound  = errors.New("智能体不存在")
+	ErrUnauthorized   = errors.New("无权访问此资源")
+)
+
+// Service 提供用户长期记忆的查看、编辑和删除
+type Service struct {
+	db     *gorm.DB
+	store  *kb.UserMemoryStore
+	logger *zap.Logger
+}
+
+// NewService 创建新的长期记忆服务
+func NewService(db *gorm.DB, store *kb.UserMemoryStore) *Service {
+	return &Service{
+		db:     db,
+		store:  store,
+		logger: zap.L().With(zap.String("service", "memory")),
+	}
+}
+
+// ListMemories 列出当前用户在智能体下的记忆
+func (s *Service) ListMemories(agentID uuid.UUID, userID uuid.UUID) ([]models.UserMemoryResponse, error) {
+	if err := s.checkAgent(agentID); err != nil {
+		return nil, err
+	}
+
+	memories, err := s.store.List(context.Background(), agentID, userID)
+	if err != nil {
+		s.logger.Error("Failed to list memories", zap.Error(err))
+		return nil, err
+	}
+
+	responses := make([]models.UserMemoryResponse, len(memories))
+	for i := range memories {
+		responses[i] = memories[i].ToResponse()
+	}
+	return responses, nil
+}
+
+// ClearMemories 删除当前用户在智能体下的全部记忆
+func (s *Service) ClearMemories(agentID uuid.UUID, userID uuid.UUID) error {
+	if err := s.checkAgent(agentID); err != nil {
+		return err
+	}
+
+	if err := s.store.DeleteAll(context.Background(), agentID, userID); err != nil {
+		s.logger.Error("Failed to clear memories", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// UpdateMemory 编辑一条记忆
+func (s *Service) UpdateMemory(id uuid.UUID, req models.UpdateUserMemoryRequest, userID uuid.UUID) (*models.UserMemoryResponse, error) {
+	memory, err := s.findMemory(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Update(context.Background(), memory, req.Content); err != nil {
+		s.logger.Error("Failed to update memory", zap.Error(err))
+		return nil, err
+	}
+
+	updated, err := s.findMemory(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	response := updated.ToResponse()
+	return &response, nil
+}
+
+// DeleteMemory 删除一条记忆
+func (s *Service) DeleteMemory(id uuid.UUID, userID uuid.UUID) error {
+	memory, err := s.findMemory(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.Delete(context.Background(), memory); err != nil {
+		s.logger.Error("Failed to delete memory", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// checkAgent 检查智能体是否存在
+func (s *Service) checkAgent(agentID uuid.UUID) error {
+	var agent models.Agent
+	if err := s.db.Select("id").First(&agent, "id = ?", agentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAgentNotFound
+		}
+		s.logger.Error("Failed to find agent", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// findMemory 查找记忆并检查用户权限
+func (s *Service) findMemory(id uuid.UUID, userID uuid.UUID) (*models.UserMemory, error) {
+	memory, err := s.store.Get(context.Background(), id)
+	if err != nil {
+		if errors.Is(err, kb.ErrUserMemoryNotFound) {
+			return nil, ErrMemoryNotFound
+		}
+		s.logger.Error("Failed to find memory", zap.Error(err))
+		return nil, err
+	}
+
+	// 记忆只属于其对应的用户
+	if memory.UserID != userID {
+		return nil, ErrUnauthorized
+	}
+
+	return memory, nil
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestService 创建使用测试数据库的长期记忆服务，不使用向量数据库
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t, &models.Agent{}, &models.UserMemory{})
+	return NewService(db, kb.NewUserMemoryStore(db, nil, nil, "")), db
+}
+
+func createTestAgent(t *testing.T, db *gorm.DB) *models.Agent {
+	t.Helper()
+	agent := models.Agent{Name: "test", ApplicationID: uuid.New(), ModelConfigID: uuid.New()}
+	if err := db.Create(&agent).Error; err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	return &agent
+}
+
+func createTestMemory(t *testing.T, db *gorm.DB, agentID, userID uuid.UUID, content string) *models.UserMemory {
+	t.Helper()
+	memory := models.UserMemory{AgentID: agentID, UserID: userID, Content: content, Source: "extracted"}
+	if err := db.Create(&memory).Error; err != nil {
+		t.Fatalf("create memory: %v", err)
+	}
+	return &memory
+}
+
+func TestListAndClearMemories(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	userID, otherUser := uuid.New(), uuid.New()
+	createTestMemory(t, db, agent.ID, userID, "likes tea")
+	createTestMemory(t, db, agent.ID, otherUser, "likes coffee")
+
+	memories, err := service.ListMemories(agent.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memories) != 1 || memories[0].Content != "likes tea" {
+		t.Errorf("memories = %+v, want only the user's memory", memories)
+	}
+
+	if _, err := service.ListMemories(uuid.New(), userID); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("ListMemories of unknown agent error = %v, want %v", err, ErrAgentNotFound)
+	}
+	if err := service.ClearMemories(uuid.New(), userID); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("ClearMemories of unknown agent error = %v, want %v", err, ErrAgentNotFound)
+	}
+
+	if err := service.ClearMemories(agent.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if memories, _ := service.ListMemories(agent.ID, userID); len(memories) != 0 {
+		t.Errorf("%d memories after clear, want 0", len(memories))
+	}
+	if memories, _ := service.ListMemories(agent.ID, otherUser); len(memories) != 1 {
+		t.Error("ClearMemories removed another user's memory")
+	}
+}
+
+func TestMemoryOwnership(t *testing.T) {
+	service, db := newTestService(t)
+	agent := createTestAgent(t, db)
+	ownerID := uuid.New()
+	memory := createTestMemory(t, db, agent.ID, ownerID, "likes tea")
+
+	update := models.UpdateUserMemoryRequest{Content: "likes green tea"}
+	calls := map[string]func(id, userID uuid.UUID) error{
+		"UpdateMemory": func(id, userID uuid.UUID) error {
+			_, err := service.UpdateMemory(id, update, userID)
+			return err
+		},
+		"DeleteMemory": func(id, userID uuid.UUID) error {
+			return service.DeleteMemory(id, userID)
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(memory.ID, uuid.New()); !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("%s by another user error = %v, want %v", name, err, ErrUnauthorized)
+			}
+			if err := call(uuid.New(), ownerID); !errors.Is(err, ErrMemoryNotFound) {
+				t.Errorf("%s of unknown memory error = %v, want %v", name, err, ErrMemoryNotFound)
+			}
+		})
+	}
+
+	updated, err := service.UpdateMemory(memory.ID, update, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Content != "likes green tea" || updated.Source != "edited" {
+		t.Errorf("updated memory = %+v, want edited content", updated)
+	}
+
+	if err := service.DeleteMemory(memory.ID, ownerID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kb.NewUserMemoryStore(db, nil, nil, "").Get(context.Background(), memory.ID); !errors.Is(err, kb.ErrUserMemoryNotFound) {
+		t.Errorf("Get after delete error = %v, want %v", err, kb.ErrUserMemoryNotFound)
+	}
+}
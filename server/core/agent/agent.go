@@ -35,6 +35,7 @@ const (
 	EventToken       AgentRuntimeEventType = "token"       // 流式响应的单个token
 	EventComplete    AgentRuntimeEventType = "complete"    // 完成响应
 	EventError       AgentRuntimeEventType = "error"       // 发生错误
+	EventApprovalRequired AgentRuntimeEventType = "approval_required" // 工具调用等待人工审批
 )
 
 // AgentRuntimeEvent 表示运行时事件
@@ -58,14 +59,10 @@ type Agent struct {
 	SystemPrompt string                 `json:"system_prompt"`
 	einoAgent   *eino.Agent
 	Runtime     *AgentRuntime           `json:"-"`
-}
-
-// Tool 表示智能体可以使用的工具
-type Tool struct {
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	Parameters  map[string]interface{} `json:"parameters"`
-	Handler     ToolHandler            `json:"-"`
+	approvalStore ApprovalStore
+	contextWindow *ContextWindow
+	longTermMemory LongTermMemory
+	recalled      []string // 本轮召回的长期记忆
 }
 
 // ToolHandler 是处理工具调用的函数类型
@@ -218,6 +215,7 @@ func (a *Agent) ChatStream(ctx context.Context, userMessage string) (io.Reader,
 	if a.einoAgent == nil {
 		return nil, errors.New("agent not initialized, call InitEinoAgent first")
 	}
+	a.recallLongTerm(ctx, userMessage)
 
 	// 创建用户消息并添加到记忆
 	userMsg := eino.Message{
@@ -227,19 +225,16 @@ func (a *Agent) ChatStream(ctx context.Context, userMessage string) (io.Reader,
 
 	if a.Memory != nil {
 		if err := a.Memory.AddMessage(userMsg); err != nil {
+			// 消息被拒绝时（如对话中有等待审批的工具调用）不再继续本轮
+			if errors.Is(err, ErrMessageRejected) {
+				return nil, err
+			}
 			zap.L().Warn("Failed to add message to memory", zap.Error(err))
 		}
 	}
 
-	var messages []eino.Message
-
-	// 如果有系统提示词，添加它
-	if a.SystemPrompt != "" {
-		messages = append(messages, eino.Message{
-			Role:    eino.RoleSystem,
-			Content: a.SystemPrompt,
-		})
-	}
+	// 系统提示词及召回的长期记忆
+	messages := a.systemMessages()
 	
 	// 从记忆中获取历史消息
 	if a.Memory != nil {
@@ -260,6 +255,7 @@ func (a *Agent) ChatStream(ctx context.Context, userMessage string) (io.Reader,
 	a.emitEvent(ctx, EventThinking, nil)
 
 	// 使用stream方法获取流式响应
+	messages = a.fitContext(messages)
 	stream, err := a.einoAgent.ChatMultipleStream(ctx, messages)
 	if err != nil {
 		a.emitEvent(ctx, EventError, map[string]interface{}{
@@ -322,7 +318,8 @@ func (a *Agent) ChatStream(ctx context.Context, userMessage string) (io.Reader,
 				zap.L().Warn("Failed to add assistant message to memory", zap.Error(err))
 			}
 		}
-		
+		a.observeTurn(ctx, userMessage, fullContent)
+
 		// 发送完成事件
 		a.emitEvent(ctx, EventComplete, map[string]interface{}{
 			"content": fullContent,
@@ -334,6 +331,15 @@ func (a *Agent) ChatStream(ctx context.Context, userMessage string) (io.Reader,
 
 // Chat 与智能体进行对话
 func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
+	content, err := a.chat(ctx, userMessage)
+	if err == nil {
+		a.observeTurn(ctx, userMessage, content)
+	}
+	return content, err
+}
+
+// chat 处理一轮对话
+func (a *Agent) chat(ctx context.Context, userMessage string) (string, error) {
 	// 如果启用了流式响应，使用不同的处理方式
 	if a.Runtime != nil && a.Runtime.Streaming {
 		reader, err := a.ChatStream(ctx, userMessage)
@@ -353,6 +359,7 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 	if a.einoAgent == nil {
 		return "", errors.New("agent not initialized, call InitEinoAgent first")
 	}
+	a.recallLongTerm(ctx, userMessage)
 
 	// 创建用户消息并添加到记忆
 	userMsg := eino.Message{
@@ -362,19 +369,16 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 
 	if a.Memory != nil {
 		if err := a.Memory.AddMessage(userMsg); err != nil {
+			// 消息被拒绝时（如对话中有等待审批的工具调用）不再继续本轮
+			if errors.Is(err, ErrMessageRejected) {
+				return "", err
+			}
 			zap.L().Warn("Failed to add message to memory", zap.Error(err))
 		}
 	}
 
-	var messages []eino.Message
-
-	// 如果有系统提示词，添加它
-	if a.SystemPrompt != "" {
-		messages = append(messages, eino.Message{
-			Role:    eino.RoleSystem,
-			Content: a.SystemPrompt,
-		})
-	}
+	// 系统提示词及召回的长期记忆
+	messages := a.systemMessages()
 	
 	// 从记忆中获取历史消息
 	if a.Memory != nil {
@@ -395,6 +399,7 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 	a.emitEvent(ctx, EventThinking, nil)
 
 	// 发送消息并获取响应
+	messages = a.fitContext(messages)
 	resp, err := a.einoAgent.ChatMultiple(ctx, messages)
 	if err != nil {
 		zap.L().Error("Failed to chat with agent", zap.Error(err))
@@ -404,11 +409,12 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 		return "", err
 	}
 
-	// 将助手回复添加到记忆
+	// 将助手回复添加到记忆，保留工具调用以便恢复完整历史
 	if a.Memory != nil {
 		assistantMsg := eino.Message{
-			Role:    eino.RoleAssistant,
-			Content: resp.Content,
+			Role:      eino.RoleAssistant,
+			Content:   resp.Content,
+			ToolCalls: resp.ToolCalls,
 		}
 		if err := a.Memory.AddMessage(assistantMsg); err != nil {
 			zap.L().Warn("Failed to add assistant message to memory", zap.Error(err))
@@ -431,157 +437,324 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 // 处理工具调用
 func (a *Agent) handleToolCalls(ctx context.Context, resp eino.Response) (string, error) {
 	var results []eino.ToolResult
-	toolResultsMap := make(map[string]interface{})
+	var pending []PendingToolCall
 
 	// 处理每个工具调用
 	for _, call := range resp.ToolCalls {
-		// 发送工具调用事件
-		a.emitEvent(ctx, EventToolCall, map[string]interface{}{
-			"tool_id":   call.ID,
-			"tool_name": call.Name,
-			"arguments": call.Arguments,
-		})
-
-		var params map[string]interface{}
-		if err := json.Unmarshal([]byte(call.Arguments), &params); err != nil {
-			zap.L().Error("Failed to unmarshal tool arguments", zap.Error(err))
-			results = append(results, eino.ToolResult{
+		// 需要人工审批的调用先挂起
+		if a.requiresApproval(call) {
+			pending = append(pending, PendingToolCall{
 				ToolCallID: call.ID,
-				Error:      fmt.Sprintf("Invalid arguments: %v", err),
+				ToolName:   call.Name,
+				Arguments:  call.Arguments,
 			})
-			toolResultsMap[call.Name] = fmt.Sprintf("Error: Invalid arguments - %v", err)
 			continue
 		}
 
-		// 查找匹配的工具
-		var tool *Tool
-		for i := range a.Tools {
-			if a.Tools[i].Name == call.Name {
-				tool = &a.Tools[i]
-				break
-			}
+		results = append(results, a.executeToolCall(ctx, call))
+	}
+
+	// 存在待审批的调用时暂停运行，等待用户处理
+	if len(pending) > 0 {
+		return "", a.pauseForApproval(ctx, resp, results, pending)
+	}
+
+	return a.continueWithToolResults(ctx, resp, results)
+}
+
+// findTool 查找智能体上指定名称的工具
+func (a *Agent) findTool(name string) *Tool {
+	for i := range a.Tools {
+		if a.Tools[i].Name == name {
+			return &a.Tools[i]
 		}
+	}
+	return nil
+}
 
-		if tool == nil {
-			results = append(results, eino.ToolResult{
-				ToolCallID: call.ID,
-				Error:      fmt.Sprintf("Tool not found: %s", call.Name),
-			})
-			toolResultsMap[call.Name] = fmt.Sprintf("Error: Tool not found - %s", call.Name)
+// requiresApproval 检查工具调用是否需要人工审批
+func (a *Agent) requiresApproval(call eino.ToolCall) bool {
+	tool := a.findTool(call.Name)
+	if tool == nil || tool.RequiresApproval == nil {
+		return false
+	}
+
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(call.Arguments), &params); err != nil {
+		// 参数无效时无需审批，执行阶段会返回参数错误
+		return false
+	}
+
+	return tool.RequiresApproval(params)
+}
+
+// executeToolCall 执行单个工具调用并返回结果
+func (a *Agent) executeToolCall(ctx context.Context, call eino.ToolCall) eino.ToolResult {
+	// 发送工具调用事件
+	a.emitEvent(ctx, EventToolCall, map[string]interface{}{
+		"tool_id":   call.ID,
+		"tool_name": call.Name,
+		"arguments": call.Arguments,
+	})
+
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(call.Arguments), &params); err != nil {
+		zap.L().Error("Failed to unmarshal tool arguments", zap.Error(err))
+		return eino.ToolResult{
+			ToolCallID: call.ID,
+			Error:      fmt.Sprintf("Invalid arguments: %v", err),
+		}
+	}
+
+	// 查找匹配的工具
+	tool := a.findTool(call.Name)
+	if tool == nil {
+		return eino.ToolResult{
+			ToolCallID: call.ID,
+			Error:      fmt.Sprintf("Tool not found: %s", call.Name),
+		}
+	}
+
+	if tool.Handler == nil {
+		return a.toolError(ctx, call, "Tool handler not implemented")
+	}
+
+	// 执行工具
+	result, err := tool.Handler(ctx, params)
+	if err != nil {
+		return a.toolError(ctx, call, err.Error())
+	}
+
+	// 序列化结果
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return a.toolError(ctx, call, fmt.Sprintf("Failed to serialize tool result: %v", err))
+	}
+
+	// 发送工具结果事件（成功）
+	a.emitEvent(ctx, EventToolResult, map[string]interface{}{
+		"tool_id":   call.ID,
+		"tool_name": call.Name,
+		"result":    result,
+	})
+
+	return eino.ToolResult{
+		ToolCallID: call.ID,
+		Content:    string(resultJSON),
+	}
+}
+
+// toolError 生成工具错误结果并发送对应事件
+func (a *Agent) toolError(ctx context.Context, call eino.ToolCall, errMsg string) eino.ToolResult {
+	// 发送工具结果事件（错误）
+	a.emitEvent(ctx, EventToolResult, map[string]interface{}{
+		"tool_id":   call.ID,
+		"tool_name": call.Name,
+		"error":     errMsg,
+	})
+
+	return eino.ToolResult{
+		ToolCallID: call.ID,
+		Error:      errMsg,
+	}
+}
+
+// pauseForApproval 持久化当前运行状态并发送审批事件
+func (a *Agent) pauseForApproval(ctx context.Context, resp eino.Response, results []eino.ToolResult, pending []PendingToolCall) error {
+	state := &ToolApprovalState{
+		ID:             uuid.New().String(),
+		AgentID:        a.ID,
+		ConversationID: ConversationIDFromContext(ctx),
+		Status:         ApprovalStatusPending,
+		Content:        resp.Content,
+		ToolCalls:      resp.ToolCalls,
+		Results:        results,
+		Pending:        pending,
+		CreatedAt:      time.Now(),
+	}
+
+	if err := a.approvalStoreOrDefault().Save(ctx, state); err != nil {
+		a.emitEvent(ctx, EventError, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("failed to save approval state: %w", err)
+	}
+
+	// 发送审批事件
+	a.emitEvent(ctx, EventApprovalRequired, map[string]interface{}{
+		"approval_id":     state.ID,
+		"conversation_id": state.ConversationID,
+		"tool_calls":      pending,
+	})
+
+	return &ApprovalRequiredError{
+		ApprovalID: state.ID,
+		Calls:      pending,
+	}
+}
+
+// ResumeToolCalls 根据用户的审批决定恢复暂停的运行
+func (a *Agent) ResumeToolCalls(ctx context.Context, approvalID string, decisions []ApprovalDecision) (string, error) {
+	if a.einoAgent == nil {
+		return "", errors.New("agent not initialized, call InitEinoAgent first")
+	}
+
+	state, results, err := a.applyDecisions(ctx, approvalID, decisions)
+	if err != nil {
+		return "", err
+	}
+
+	resp := eino.Response{
+		Content:   state.Content,
+		ToolCalls: state.ToolCalls,
+	}
+	return a.continueWithToolResults(ctx, resp, results)
+}
+
+// ApplyToolDecisions 将审批标记为已处理并按决定执行待审批的调用，返回该轮全部工具结果。
+// 调用方持久化结果后通过 ContinueToolCalls 继续运行，重试时不会重复处理审批或执行工具
+func (a *Agent) ApplyToolDecisions(ctx context.Context, approvalID string, decisions []ApprovalDecision) ([]eino.ToolResult, error) {
+	_, results, err := a.applyDecisions(ctx, approvalID, decisions)
+	return results, err
+}
+
+// ContinueToolCalls 使用已得到的工具结果继续审批暂停的运行
+func (a *Agent) ContinueToolCalls(ctx context.Context, approvalID string, results []eino.ToolResult) (string, error) {
+	if a.einoAgent == nil {
+		return "", errors.New("agent not initialized, call InitEinoAgent first")
+	}
+
+	state, err := a.approvalStoreOrDefault().Get(ctx, approvalID)
+	if err != nil {
+		return "", err
+	}
+	if state.AgentID != a.ID {
+		return "", fmt.Errorf("approval %s does not belong to agent %s", approvalID, a.ID)
+	}
+
+	resp := eino.Response{
+		Content:   state.Content,
+		ToolCalls: state.ToolCalls,
+	}
+	return a.continueWithToolResults(ctx, resp, results)
+}
+
+// applyDecisions 将审批标记为已处理并按决定执行待审批的调用，返回按原调用顺序整理的该轮全部工具结果
+func (a *Agent) applyDecisions(ctx context.Context, approvalID string, decisions []ApprovalDecision) (*ToolApprovalState, []eino.ToolResult, error) {
+	store := a.approvalStoreOrDefault()
+	state, err := store.Get(ctx, approvalID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if state.AgentID != a.ID {
+		return nil, nil, fmt.Errorf("approval %s does not belong to agent %s", approvalID, a.ID)
+	}
+	if state.Status != ApprovalStatusPending {
+		return nil, nil, fmt.Errorf("approval %s has already been resolved", approvalID)
+	}
+
+	decisionMap, err := validateDecisions(state.Pending, decisions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 先标记为已处理，防止重复恢复
+	if err := store.Resolve(ctx, approvalID, decisions); err != nil {
+		return nil, nil, err
+	}
+
+	resultByID := make(map[string]eino.ToolResult, len(state.ToolCalls))
+	for _, r := range state.Results {
+		resultByID[r.ToolCallID] = r
+	}
+
+	// 只执行待审批的调用，已执行的调用沿用暂停时的结果
+	for _, call := range state.ToolCalls {
+		d, ok := decisionMap[call.ID]
+		if !ok {
 			continue
 		}
 
-		// 执行工具
-		if tool.Handler != nil {
-			result, err := tool.Handler(ctx, params)
-			if err != nil {
-				errMsg := err.Error()
-				results = append(results, eino.ToolResult{
-					ToolCallID: call.ID,
-					Error:      errMsg,
-				})
-				toolResultsMap[call.Name] = fmt.Sprintf("Error: %s", errMsg)
-				
-				// 发送工具结果事件（错误）
-				a.emitEvent(ctx, EventToolResult, map[string]interface{}{
-					"tool_id":   call.ID,
-					"tool_name": call.Name,
-					"error":     errMsg,
-				})
-				continue
+		switch d.Action {
+		case ApprovalApprove:
+			resultByID[call.ID] = a.executeToolCall(ctx, call)
+		case ApprovalEdit:
+			call.Arguments = d.Arguments
+			resultByID[call.ID] = a.executeToolCall(ctx, call)
+		case ApprovalReject:
+			errMsg := "用户拒绝执行该工具调用"
+			if d.Reason != "" {
+				errMsg += ": " + d.Reason
 			}
-			
-			// 序列化结果
-			resultJSON, err := json.Marshal(result)
-			if err != nil {
-				errMsg := fmt.Sprintf("Failed to serialize tool result: %v", err)
-				results = append(results, eino.ToolResult{
-					ToolCallID: call.ID,
-					Error:      errMsg,
-				})
-				toolResultsMap[call.Name] = fmt.Sprintf("Error: %s", errMsg)
-				
-				// 发送工具结果事件（错误）
-				a.emitEvent(ctx, EventToolResult, map[string]interface{}{
-					"tool_id":   call.ID,
-					"tool_name": call.Name,
-					"error":     errMsg,
-				})
-				continue
-			}
-			
-			results = append(results, eino.ToolResult{
-				ToolCallID: call.ID,
-				Content:    string(resultJSON),
-			})
-			toolResultsMap[call.Name] = result
-			
-			// 发送工具结果事件（成功）
-			a.emitEvent(ctx, EventToolResult, map[string]interface{}{
-				"tool_id":   call.ID,
-				"tool_name": call.Name,
-				"result":    result,
-			})
-		} else {
-			errMsg := "Tool handler not implemented"
-			results = append(results, eino.ToolResult{
-				ToolCallID: call.ID,
-				Error:      errMsg,
-			})
-			toolResultsMap[call.Name] = fmt.Sprintf("Error: %s", errMsg)
-			
-			// 发送工具结果事件（错误）
-			a.emitEvent(ctx, EventToolResult, map[string]interface{}{
-				"tool_id":   call.ID,
-				"tool_name": call.Name,
-				"error":     errMsg,
-			})
+			resultByID[call.ID] = a.toolError(ctx, call, errMsg)
+		}
+	}
+
+	// 按原调用顺序整理结果
+	results := make([]eino.ToolResult, 0, len(state.ToolCalls))
+	for _, call := range state.ToolCalls {
+		if r, ok := resultByID[call.ID]; ok {
+			results = append(results, r)
 		}
 	}
+	return state, results, nil
+}
 
+// continueWithToolResults 将工具结果发送回模型以获取最终回复
+func (a *Agent) continueWithToolResults(ctx context.Context, resp eino.Response, results []eino.ToolResult) (string, error) {
 	// 如果有工具结果，发送回模型以获取最终回复
 	if len(results) > 0 {
 		// 发送思考事件
 		a.emitEvent(ctx, EventThinking, nil)
 
-		var messages []eino.Message
+		// 系统提示词及召回的长期记忆
+		messages := a.systemMessages()
 
-		// 如果有系统提示词，添加它
-		if a.SystemPrompt != "" {
-			messages = append(messages, eino.Message{
-				Role:    eino.RoleSystem,
-				Content: a.SystemPrompt,
-			})
+		toolMsgs := make([]eino.Message, len(results))
+		for i, result := range results {
+			toolMsgs[i] = eino.Message{
+				Role:       eino.RoleTool,
+				ToolCallID: result.ToolCallID,
+				Content:    result.Content,
+				Error:      result.Error,
+			}
 		}
 
-		// 获取历史消息
+		// 带工具调用的助手消息已在记忆中，工具结果写入记忆后历史即完整
+		historyLoaded := false
 		if a.Memory != nil {
-			historyMsgs, err := a.Memory.GetMessages()
-			if err == nil {
-				messages = append(messages, historyMsgs...)
-			} else {
-				zap.L().Warn("Failed to get messages from memory", zap.Error(err))
+			stored := true
+			for _, msg := range toolMsgs {
+				if err := a.Memory.AddMessage(msg); err != nil {
+					zap.L().Warn("Failed to add tool message to memory", zap.Error(err))
+					stored = false
+					break
+				}
+			}
+			if stored {
+				historyMsgs, err := a.Memory.GetMessages()
+				if err == nil {
+					messages = append(messages, historyMsgs...)
+					historyLoaded = true
+				} else {
+					zap.L().Warn("Failed to get messages from memory", zap.Error(err))
+				}
 			}
 		}
 
-		// 添加助手消息和工具结果
-		messages = append(messages, eino.Message{
-			Role:    eino.RoleAssistant,
-			Content: resp.Content,
-			ToolCalls: resp.ToolCalls,
-		})
-
-		// 添加工具结果消息
-		for _, result := range results {
+		// 没有可用记忆时直接附加助手消息和工具结果
+		if !historyLoaded {
 			messages = append(messages, eino.Message{
-				Role:        eino.RoleTool,
-				ToolCallID:  result.ToolCallID,
-				Content:     result.Content,
-				Error:       result.Error,
+				Role:      eino.RoleAssistant,
+				Content:   resp.Content,
+				ToolCalls: resp.ToolCalls,
 			})
+			messages = append(messages, toolMsgs...)
 		}
 
 		// 获取新的回复
+		messages = a.fitContext(messages)
 		newResp, err := a.einoAgent.ChatMultiple(ctx, messages)
 		if err != nil {
 			zap.L().Error("Failed to chat with agent after tool call", zap.Error(err))
@@ -594,8 +767,9 @@ func (a *Agent) handleToolCalls(ctx context.Context, resp eino.Response) (string
 		// 将新的助手回复添加到记忆
 		if a.Memory != nil {
 			assistantMsg := eino.Message{
-				Role:    eino.RoleAssistant,
-				Content: newResp.Content,
+				Role:      eino.RoleAssistant,
+				Content:   newResp.Content,
+				ToolCalls: newResp.ToolCalls,
 			}
 			if err := a.Memory.AddMessage(assistantMsg); err != nil {
 				zap.L().Warn("Failed to add assistant message to memory", zap.Error(err))
@@ -618,6 +792,72 @@ func (a *Agent) handleToolCalls(ctx context.Context, resp eino.Response) (string
 	return resp.Content, nil
 }
 
+// Generate 直接调用模型生成回复，不读写记忆也不处理工具调用，用于摘要等辅助任务
+func (a *Agent) Generate(ctx context.Context, messages []eino.Message) (string, error) {
+	if a.einoAgent == nil {
+		return "", errors.New("agent not initialized, call InitEinoAgent first")
+	}
+
+	resp, err := a.einoAgent.ChatMultiple(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
+
+// SetContextWindow 设置上下文窗口，发送请求前按token预算裁剪历史
+func (a *Agent) SetContextWindow(window *ContextWindow) {
+	a.contextWindow = window
+}
+
+// ContextBudget 返回当前可供历史和检索内容使用的token预算，未设置上下文窗口时返回0
+func (a *Agent) ContextBudget() int {
+	if a.contextWindow == nil {
+		return 0
+	}
+	var fixed []eino.Message
+	if a.SystemPrompt != "" {
+		fixed = append(fixed, eino.Message{Role: eino.RoleSystem, Content: a.SystemPrompt})
+	}
+	return a.contextWindow.HistoryBudget(fixed, a.Tools)
+}
+
+// CountTokens 使用智能体模型的分词器统计文本token数
+func (a *Agent) CountTokens(text string) int {
+	if a.contextWindow == nil {
+		return approxTokenizer{}.Count(text)
+	}
+	return a.contextWindow.Tokenizer.Count(text)
+}
+
+// fitContext 按上下文窗口裁剪请求消息，开头的系统消息始终保留
+func (a *Agent) fitContext(messages []eino.Message) []eino.Message {
+	if a.contextWindow == nil {
+		return messages
+	}
+
+	fixed := 0
+	for fixed < len(messages) && messages[fixed].Role == eino.RoleSystem {
+		fixed++
+	}
+	budget := a.contextWindow.HistoryBudget(messages[:fixed], a.Tools)
+	history := FitHistory(a.contextWindow.Tokenizer, messages[fixed:], budget)
+	return append(messages[:fixed:fixed], history...)
+}
+
+// SetApprovalStore 设置审批状态存储
+func (a *Agent) SetApprovalStore(store ApprovalStore) {
+	a.approvalStore = store
+}
+
+// approvalStoreOrDefault 返回智能体使用的审批存储
+func (a *Agent) approvalStoreOrDefault() ApprovalStore {
+	if a.approvalStore != nil {
+		return a.approvalStore
+	}
+	return DefaultApprovalStore
+}
+
 // ClearMemory 清除智能体记忆
 func (a *Agent) ClearMemory() error {
 	if a.Memory != nil {
@@ -713,11 +953,12 @@ func (a *Agent) ProcessMultimodalContent(ctx context.Context, userMessage string
 		return "", err
 	}
 	
-	// 将助手回复添加到记忆
+	// 将助手回复添加到记忆，保留工具调用以便恢复完整历史
 	if a.Memory != nil {
 		assistantMsg := eino.Message{
-			Role:    eino.RoleAssistant,
-			Content: resp.Content,
+			Role:      eino.RoleAssistant,
+			Content:   resp.Content,
+			ToolCalls: resp.ToolCalls,
 		}
 		if err := a.Memory.AddMessage(assistantMsg); err != nil {
 			zap.L().Warn("Failed to add assistant message to memory", zap.Error(err))
@@ -0,0 +1,215 @@
+package agent
+
+// approval.go 提供敏感工具调用的人工审批支持
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino"
+)
+
+// ApprovalPolicy 根据调用参数判断一次工具调用是否需要人工审批
+type ApprovalPolicy func(params map[string]interface{}) bool
+
+// AlwaysRequireApproval 所有调用都需要审批
+func AlwaysRequireApproval(params map[string]interface{}) bool {
+	return true
+}
+
+// RequireApprovalForMethods 当 method 参数属于给定HTTP方法时需要审批
+func RequireApprovalForMethods(methods ...string) ApprovalPolicy {
+	return func(params map[string]interface{}) bool {
+		method, _ := params["method"].(string)
+		if method == "" {
+			method = "GET"
+		}
+		for _, m := range methods {
+			if strings.EqualFold(m, method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ApprovalAction 表示用户对待审批调用的处理方式
+type ApprovalAction string
+
+const (
+	ApprovalApprove ApprovalAction = "approve" // 按原参数执行
+	ApprovalEdit    ApprovalAction = "edit"    // 使用修改后的参数执行
+	ApprovalReject  ApprovalAction = "reject"  // 拒绝执行，错误将反馈给模型
+)
+
+// ApprovalStatus 表示审批状态
+type ApprovalStatus string
+
+const (
+	ApprovalStatusPending  ApprovalStatus = "pending"
+	ApprovalStatusResolved ApprovalStatus = "resolved"
+)
+
+var (
+	ErrApprovalRequired        = errors.New("tool call requires approval")
+	ErrApprovalNotFound        = errors.New("approval not found")
+	ErrInvalidApprovalDecision = errors.New("invalid approval decision")
+)
+
+// PendingToolCall 等待审批的工具调用
+type PendingToolCall struct {
+	ToolCallID string `json:"tool_call_id"`
+	ToolName   string `json:"tool_name"`
+	Arguments  string `json:"arguments"`
+}
+
+// ToolApprovalState 智能体在等待审批时暂停的运行状态
+type ToolApprovalState struct {
+	ID             string            `json:"id"`
+	AgentID        string            `json:"agent_id"`
+	ConversationID string            `json:"conversation_id,omitempty"`
+	Status         ApprovalStatus    `json:"status"`
+	Content        string            `json:"content"`    // 发起工具调用的助手消息内容
+	ToolCalls      []eino.ToolCall   `json:"tool_calls"` // 该轮的全部工具调用
+	Results        []eino.ToolResult `json:"results"`    // 已执行完成的工具结果
+	Pending        []PendingToolCall `json:"pending"`    // 等待审批的调用
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// ApprovalDecision 用户对单个工具调用的审批决定
+type ApprovalDecision struct {
+	ToolCallID string         `json:"tool_call_id"`
+	Action     ApprovalAction `json:"action"`
+	Arguments  string         `json:"arguments,omitempty"` // Action为edit时的新参数（JSON）
+	Reason     string         `json:"reason,omitempty"`
+}
+
+// validateDecisions 校验审批决定：只能针对待审批的调用、每个调用只有一个决定且所有待审批调用都有决定
+func validateDecisions(pending []PendingToolCall, decisions []ApprovalDecision) (map[string]ApprovalDecision, error) {
+	pendingIDs := make(map[string]bool, len(pending))
+	for _, p := range pending {
+		pendingIDs[p.ToolCallID] = true
+	}
+
+	decisionMap := make(map[string]ApprovalDecision, len(decisions))
+	for _, d := range decisions {
+		if !pendingIDs[d.ToolCallID] {
+			return nil, fmt.Errorf("%w: tool call %s is not awaiting approval", ErrInvalidApprovalDecision, d.ToolCallID)
+		}
+		if _, exists := decisionMap[d.ToolCallID]; exists {
+			return nil, fmt.Errorf("%w: duplicate decision for tool call %s", ErrInvalidApprovalDecision, d.ToolCallID)
+		}
+		switch d.Action {
+		case ApprovalApprove, ApprovalReject:
+		case ApprovalEdit:
+			if !json.Valid([]byte(d.Arguments)) {
+				return nil, fmt.Errorf("%w: invalid edited arguments for tool call %s", ErrInvalidApprovalDecision, d.ToolCallID)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown approval action %q", ErrInvalidApprovalDecision, d.Action)
+		}
+		decisionMap[d.ToolCallID] = d
+	}
+
+	for _, p := range pending {
+		if _, ok := decisionMap[p.ToolCallID]; !ok {
+			return nil, fmt.Errorf("%w: missing decision for tool call %s", ErrInvalidApprovalDecision, p.ToolCallID)
+		}
+	}
+	return decisionMap, nil
+}
+
+// ApprovalRequiredError 表示运行因等待审批而暂停
+type ApprovalRequiredError struct {
+	ApprovalID string
+	Calls      []PendingToolCall
+}
+
+// Error 实现 error 接口
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("%s: approval %s is waiting for %d tool call(s)", ErrApprovalRequired.Error(), e.ApprovalID, len(e.Calls))
+}
+
+// Is 使 errors.Is(err, ErrApprovalRequired) 成立
+func (e *ApprovalRequiredError) Is(target error) bool {
+	return target == ErrApprovalRequired
+}
+
+// ApprovalStore 持久化暂停的运行状态
+type ApprovalStore interface {
+	Save(ctx context.Context, state *ToolApprovalState) error
+	Get(ctx context.Context, id string) (*ToolApprovalState, error)
+	Resolve(ctx context.Context, id string, decisions []ApprovalDecision) error
+}
+
+// InMemoryApprovalStore 基于内存的审批状态存储
+type InMemoryApprovalStore struct {
+	states map[string]*ToolApprovalState
+	mu     sync.RWMutex
+}
+
+// NewInMemoryApprovalStore 创建内存审批存储
+func NewInMemoryApprovalStore() *InMemoryApprovalStore {
+	return &InMemoryApprovalStore{
+		states: make(map[string]*ToolApprovalState),
+	}
+}
+
+// Save 保存审批状态
+func (s *InMemoryApprovalStore) Save(ctx context.Context, state *ToolApprovalState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[state.ID] = state
+	return nil
+}
+
+// Get 获取审批状态
+func (s *InMemoryApprovalStore) Get(ctx context.Context, id string) (*ToolApprovalState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, exists := s.states[id]
+	if !exists {
+		return nil, ErrApprovalNotFound
+	}
+	return state, nil
+}
+
+// Resolve 将审批标记为已处理
+func (s *InMemoryApprovalStore) Resolve(ctx context.Context, id string, decisions []ApprovalDecision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, exists := s.states[id]
+	if !exists {
+		return ErrApprovalNotFound
+	}
+	if state.Status != ApprovalStatusPending {
+		return fmt.Errorf("approval %s is not pending", id)
+	}
+	state.Status = ApprovalStatusResolved
+	return nil
+}
+
+// DefaultApprovalStore 默认的审批存储
+var DefaultApprovalStore ApprovalStore = NewInMemoryApprovalStore()
+
+// conversationIDKey 是上下文中对话ID的键
+type conversationIDKey struct{}
+
+// WithConversationID 将对话ID附加到上下文，运行时事件和持久化状态会引用它
+func WithConversationID(ctx context.Context, conversationID string) context.Context {
+	return context.WithValue(ctx, conversationIDKey{}, conversationID)
+}
+
+// ConversationIDFromContext 从上下文中获取对话ID
+func ConversationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(conversationIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"gorm.io/gorm"
+)
+
+// GormApprovalStore 基于数据库的审批状态存储，服务重启或多副本部署时状态不会丢失
+type GormApprovalStore struct {
+	db *gorm.DB
+}
+
+// NewGormApprovalStore 创建数据库审批存储
+func NewGormApprovalStore(db *gorm.DB) *GormApprovalStore {
+	return &GormApprovalStore{db: db}
+}
+
+// Save 保存审批状态
+func (s *GormApprovalStore) Save(ctx context.Context, state *ToolApprovalState) error {
+	id, err := uuid.Parse(state.ID)
+	if err != nil {
+		return fmt.Errorf("invalid approval id: %w", err)
+	}
+	agentID, err := uuid.Parse(state.AgentID)
+	if err != nil {
+		return fmt.Errorf("invalid agent id: %w", err)
+	}
+
+	stateMap, err := toJSONMap(state)
+	if err != nil {
+		return err
+	}
+
+	record := models.ToolApproval{
+		ID:      id,
+		AgentID: agentID,
+		Status:  string(state.Status),
+		State:   stateMap,
+	}
+	if state.ConversationID != "" {
+		convID, err := uuid.Parse(state.ConversationID)
+		if err != nil {
+			return fmt.Errorf("invalid conversation id: %w", err)
+		}
+		record.ConversationID = &convID
+	}
+
+	return s.db.WithContext(ctx).Create(&record).Error
+}
+
+// Get 获取审批状态
+func (s *GormApprovalStore) Get(ctx context.Context, id string) (*ToolApprovalState, error) {
+	var record models.ToolApproval
+	if err := s.db.WithContext(ctx).First(&record, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrApprovalNotFound
+		}
+		return nil, err
+	}
+
+	data, err := json.Marshal(record.State)
+	if err != nil {
+		return nil, err
+	}
+
+	var state ToolApprovalState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode approval state: %w", err)
+	}
+	state.Status = ApprovalStatus(record.Status)
+
+	return &state, nil
+}
+
+// Resolve 将审批标记为已处理，仅当其仍处于待审批状态时生效
+func (s *GormApprovalStore) Resolve(ctx context.Context, id string, decisions []ApprovalDecision) error {
+	decisionMap := make(models.JSONMap, len(decisions))
+	for _, d := range decisions {
+		decisionMap[d.ToolCallID] = d
+	}
+
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&models.ToolApproval{}).
+		Where("id = ? AND status = ?", id, string(ApprovalStatusPending)).
+		Updates(map[string]interface{}{
+			"status":      string(ApprovalStatusResolved),
+			"decisions":   decisionMap,
+			"resolved_at": &now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("approval %s is not pending", id)
+	}
+
+	return nil
+}
+
+// toJSONMap 将结构体转换为JSONMap
+func toJSONMap(v interface{}) (models.JSONMap, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m models.JSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
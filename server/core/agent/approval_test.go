@@ -0,0 +1,247 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/eino"
+)
+
+func TestRequireApprovalForMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		params  map[string]interface{}
+		want    bool
+	}{
+		{"listed method", []string{"POST", "DELETE"}, map[string]interface{}{"method": "POST"}, true},
+		{"case insensitive", []string{"POST"}, map[string]interface{}{"method": "post"}, true},
+		{"unlisted method", []string{"POST"}, map[string]interface{}{"method": "GET"}, false},
+		{"missing method defaults to GET", []string{"POST"}, map[string]interface{}{}, false},
+		{"missing method with GET listed", []string{"GET"}, map[string]interface{}{}, true},
+		{"non-string method", []string{"POST"}, map[string]interface{}{"method": 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RequireApprovalForMethods(tt.methods...)(tt.params); got != tt.want {
+				t.Errorf("policy(%v) = %v, want %v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemoryApprovalStoreResolve(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryApprovalStore()
+	if err := store.Save(ctx, &ToolApprovalState{ID: "a1", Status: ApprovalStatusPending}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		wantErr  bool
+		notFound bool
+	}{
+		{"first resolve", "a1", false, false},
+		{"double resolve", "a1", true, false},
+		{"unknown approval", "missing", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Resolve(ctx, tt.id, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.notFound && !errors.Is(err, ErrApprovalNotFound) {
+				t.Errorf("Resolve error = %v, want %v", err, ErrApprovalNotFound)
+			}
+		})
+	}
+
+	state, err := store.Get(ctx, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != ApprovalStatusResolved {
+		t.Errorf("status = %s, want %s", state.Status, ApprovalStatusResolved)
+	}
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrApprovalNotFound) {
+		t.Errorf("Get error = %v, want %v", err, ErrApprovalNotFound)
+	}
+}
+
+func TestValidateDecisions(t *testing.T) {
+	pending := []PendingToolCall{{ToolCallID: "c2"}, {ToolCallID: "c3"}}
+
+	tests := []struct {
+		name      string
+		decisions []ApprovalDecision
+		wantErr   bool
+	}{
+		{"all pending decided", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalApprove},
+			{ToolCallID: "c3", Action: ApprovalEdit, Arguments: `{"method":"GET"}`},
+		}, false},
+		{"missing decision", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalApprove},
+		}, true},
+		{"unknown tool call", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalApprove},
+			{ToolCallID: "c3", Action: ApprovalApprove},
+			{ToolCallID: "c9", Action: ApprovalApprove},
+		}, true},
+		{"already executed tool call", []ApprovalDecision{
+			{ToolCallID: "c1", Action: ApprovalApprove},
+			{ToolCallID: "c2", Action: ApprovalApprove},
+			{ToolCallID: "c3", Action: ApprovalApprove},
+		}, true},
+		{"duplicate decision", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalApprove},
+			{ToolCallID: "c2", Action: ApprovalReject},
+			{ToolCallID: "c3", Action: ApprovalApprove},
+		}, true},
+		{"invalid edited arguments", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalEdit, Arguments: "{"},
+			{ToolCallID: "c3", Action: ApprovalApprove},
+		}, true},
+		{"unknown action", []ApprovalDecision{
+			{ToolCallID: "c2", Action: "skip"},
+			{ToolCallID: "c3", Action: ApprovalApprove},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateDecisions(pending, tt.decisions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateDecisions error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidApprovalDecision) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidApprovalDecision)
+			}
+		})
+	}
+}
+
+// approvalTestAgent 创建带有只读工具和需审批写工具的智能体，calls 记录每个调用ID的执行参数
+func approvalTestAgent(t *testing.T, calls map[string][]string) *Agent {
+	t.Helper()
+	agent, err := NewAgent("approval", "", "test-model", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.SetApprovalStore(NewInMemoryApprovalStore())
+
+	handler := func(name string) ToolHandler {
+		return func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			method, _ := params["method"].(string)
+			calls[name] = append(calls[name], method)
+			return map[string]interface{}{"tool": name, "method": method}, nil
+		}
+	}
+	agent.AddTool(Tool{Name: "read", Handler: handler("read")})
+	agent.AddTool(Tool{Name: "write", Handler: handler("write"), RequiresApproval: RequireApprovalForMethods("POST", "DELETE")})
+	return agent
+}
+
+func TestApprovalPauseResumeRoundTrip(t *testing.T) {
+	resp := eino.Response{
+		Content: "calling tools",
+		ToolCalls: []eino.ToolCall{
+			{ID: "c1", Name: "read", Arguments: `{}`},
+			{ID: "c2", Name: "write", Arguments: `{"method":"POST"}`},
+			{ID: "c3", Name: "write", Arguments: `{"method":"GET"}`},
+			{ID: "c4", Name: "write", Arguments: `{"method":"DELETE"}`},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		decisions   []ApprovalDecision
+		wantWrites  []string // write 工具在恢复时收到的 method
+		wantErrorID string   // 被拒绝的调用
+	}{
+		{"approve all", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalApprove},
+			{ToolCallID: "c4", Action: ApprovalApprove},
+		}, []string{"POST", "DELETE"}, ""},
+		{"edit and reject", []ApprovalDecision{
+			{ToolCallID: "c2", Action: ApprovalEdit, Arguments: `{"method":"PUT"}`},
+			{ToolCallID: "c4", Action: ApprovalReject, Reason: "too risky"},
+		}, []string{"PUT"}, "c4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			calls := map[string][]string{}
+			agent := approvalTestAgent(t, calls)
+
+			_, err := agent.handleToolCalls(ctx, resp)
+			var approvalErr *ApprovalRequiredError
+			if !errors.As(err, &approvalErr) {
+				t.Fatalf("handleToolCalls error = %v, want ApprovalRequiredError", err)
+			}
+			if len(approvalErr.Calls) != 2 || approvalErr.Calls[0].ToolCallID != "c2" || approvalErr.Calls[1].ToolCallID != "c4" {
+				t.Fatalf("pending calls = %+v, want c2 and c4", approvalErr.Calls)
+			}
+			// 无需审批的调用在暂停前执行
+			if len(calls["read"]) != 1 || len(calls["write"]) != 1 || calls["write"][0] != "GET" {
+				t.Fatalf("calls before approval = %v", calls)
+			}
+
+			// 非待审批调用的决定被拒绝，审批仍保持待处理
+			invalid := append([]ApprovalDecision{{ToolCallID: "c1", Action: ApprovalApprove}}, tt.decisions...)
+			if _, _, err := agent.applyDecisions(ctx, approvalErr.ApprovalID, invalid); !errors.Is(err, ErrInvalidApprovalDecision) {
+				t.Fatalf("applyDecisions with executed call error = %v, want %v", err, ErrInvalidApprovalDecision)
+			}
+
+			state, results, err := agent.applyDecisions(ctx, approvalErr.ApprovalID, tt.decisions)
+			if err != nil {
+				t.Fatalf("applyDecisions: %v", err)
+			}
+			if state.Content != resp.Content {
+				t.Errorf("state content = %q, want %q", state.Content, resp.Content)
+			}
+
+			if len(results) != len(resp.ToolCalls) {
+				t.Fatalf("got %d results, want %d", len(results), len(resp.ToolCalls))
+			}
+			for i, r := range results {
+				if r.ToolCallID != resp.ToolCalls[i].ID {
+					t.Errorf("results[%d] = %s, want %s", i, r.ToolCallID, resp.ToolCalls[i].ID)
+				}
+				if wantErr := r.ToolCallID == tt.wantErrorID; (r.Error != "") != wantErr {
+					t.Errorf("result %s error = %q, want error %v", r.ToolCallID, r.Error, wantErr)
+				}
+			}
+
+			if len(calls["read"]) != 1 {
+				t.Errorf("read executed %d times, want once", len(calls["read"]))
+			}
+			if got := calls["write"][1:]; !equalStrings(got, tt.wantWrites) {
+				t.Errorf("writes after approval = %v, want %v", got, tt.wantWrites)
+			}
+
+			// 审批只能处理一次
+			if _, _, err := agent.applyDecisions(ctx, approvalErr.ApprovalID, tt.decisions); err == nil {
+				t.Error("second applyDecisions succeeded, want error")
+			}
+			if got := calls["write"][1:]; !equalStrings(got, tt.wantWrites) {
+				t.Errorf("writes after second resume = %v, want %v", got, tt.wantWrites)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
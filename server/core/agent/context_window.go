@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"github.com/cloudwego/eino"
+)
+
+// defaultReservedOutputTokens 未配置回复长度时为回复预留的token数
+const defaultReservedOutputTokens = 1024
+
+// ContextWindow 模型上下文窗口的token预算
+type ContextWindow struct {
+	Tokenizer            Tokenizer
+	MaxTokens            int // 模型的上下文长度，取自 models.Model.MaxTokens
+	ReservedOutputTokens int // 为模型回复预留的token数
+}
+
+// NewContextWindow 创建上下文窗口，reservedOutput 不大于0时按默认值且不超过窗口的四分之一
+func NewContextWindow(tokenizer Tokenizer, maxTokens, reservedOutput int) *ContextWindow {
+	if reservedOutput <= 0 {
+		reservedOutput = defaultReservedOutputTokens
+		if quarter := maxTokens / 4; reservedOutput > quarter {
+			reservedOutput = quarter
+		}
+	}
+	return &ContextWindow{
+		Tokenizer:            tokenizer,
+		MaxTokens:            maxTokens,
+		ReservedOutputTokens: reservedOutput,
+	}
+}
+
+// HistoryBudget 扣除回复预留、固定消息（系统提示词等）和工具定义后可供历史使用的token数
+func (w *ContextWindow) HistoryBudget(fixed []eino.Message, tools []Tool) int {
+	budget := w.MaxTokens - w.ReservedOutputTokens -
+		CountMessagesTokens(w.Tokenizer, fixed) - CountToolsTokens(w.Tokenizer, tools)
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// FitHistory 从最新的消息开始保留不超过预算的连续历史。
+// 带工具调用的助手消息与其工具结果作为整体保留或丢弃，结果不完整的工具调用会被移除；
+// 最新一组消息单独超出预算时截断其内容而不是丢弃。
+func FitHistory(tokenizer Tokenizer, history []eino.Message, budget int) []eino.Message {
+	groups := groupMessages(history)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	used := 0
+	start := len(groups)
+	for i := len(groups) - 1; i >= 0; i-- {
+		cost := 0
+		for _, msg := range groups[i] {
+			cost += CountMessageTokens(tokenizer, msg)
+		}
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start = i
+	}
+
+	if start == len(groups) {
+		return truncateGroup(tokenizer, groups[len(groups)-1], budget)
+	}
+
+	var fitted []eino.Message
+	for _, group := range groups[start:] {
+		fitted = append(fitted, group...)
+	}
+	return fitted
+}
+
+// groupMessages 将历史划分为不可拆分的消息组
+func groupMessages(history []eino.Message) [][]eino.Message {
+	var groups [][]eino.Message
+	for i := 0; i < len(history); {
+		msg := history[i]
+
+		// 没有对应工具调用的工具结果直接丢弃
+		if msg.Role == eino.RoleTool {
+			i++
+			continue
+		}
+
+		if msg.Role != eino.RoleAssistant || len(msg.ToolCalls) == 0 {
+			groups = append(groups, []eino.Message{msg})
+			i++
+			continue
+		}
+
+		pending := make(map[string]bool, len(msg.ToolCalls))
+		for _, call := range msg.ToolCalls {
+			pending[call.ID] = true
+		}
+		group := []eino.Message{msg}
+		j := i + 1
+		for ; j < len(history) && history[j].Role == eino.RoleTool; j++ {
+			if pending[history[j].ToolCallID] {
+				delete(pending, history[j].ToolCallID)
+				group = append(group, history[j])
+			}
+		}
+		i = j
+
+		if len(pending) == 0 {
+			groups = append(groups, group)
+			continue
+		}
+
+		// 工具调用缺少结果（例如审批未完成），只保留助手的文本内容
+		if msg.Content != "" {
+			groups = append(groups, []eino.Message{{Role: msg.Role, Content: msg.Content}})
+		}
+	}
+	return groups
+}
+
+// truncateGroup 截断单独超出预算的消息组，工具结果平分剩余预算
+func truncateGroup(tokenizer Tokenizer, group []eino.Message, budget int) []eino.Message {
+	truncated := make([]eino.Message, len(group))
+	copy(truncated, group)
+
+	// 仅有一条消息时截断其内容
+	if len(truncated) == 1 {
+		msg := truncated[0]
+		msg.Content = ""
+		msg.Content = tokenizer.Truncate(group[0].Content, budget-CountMessageTokens(tokenizer, msg))
+		truncated[0] = msg
+		return truncated
+	}
+
+	// 工具调用组保留调用本身，截断各工具结果
+	overhead := 0
+	for i, msg := range truncated {
+		if i > 0 {
+			msg.Content = ""
+		}
+		overhead += CountMessageTokens(tokenizer, msg)
+	}
+	share := (budget - overhead) / (len(truncated) - 1)
+	for i := 1; i < len(truncated); i++ {
+		truncated[i].Content = tokenizer.Truncate(group[i].Content, share)
+	}
+	return truncated
+}
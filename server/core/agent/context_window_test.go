@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino"
+)
+
+func TestNewContextWindow(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxTokens      int
+		reservedOutput int
+		want           int
+	}{
+		{"configured reservation", 8000, 500, 500},
+		{"default reservation", 8000, 0, defaultReservedOutputTokens},
+		{"default capped at a quarter", 2000, 0, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := NewContextWindow(approxTokenizer{}, tt.maxTokens, tt.reservedOutput)
+			if window.ReservedOutputTokens != tt.want {
+				t.Errorf("reserved output = %d, want %d", window.ReservedOutputTokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryBudget(t *testing.T) {
+	tokenizer := approxTokenizer{}
+	window := NewContextWindow(tokenizer, 1000, 100)
+	system := []eino.Message{{Role: eino.RoleSystem, Content: "you are helpful"}}
+	tools := []Tool{{Name: "calc", Description: "adds numbers"}}
+
+	want := 1000 - 100 - CountMessagesTokens(tokenizer, system) - CountToolsTokens(tokenizer, tools)
+	if got := window.HistoryBudget(system, tools); got != want {
+		t.Errorf("HistoryBudget = %d, want %d", got, want)
+	}
+
+	small := NewContextWindow(tokenizer, 10, 8)
+	if got := small.HistoryBudget(system, tools); got != 0 {
+		t.Errorf("HistoryBudget over the window = %d, want 0", got)
+	}
+}
+
+// roles 返回消息的角色与内容，便于比较
+func roles(messages []eino.Message) []string {
+	got := make([]string, len(messages))
+	for i, msg := range messages {
+		got[i] = msg.Role + ":" + msg.Content
+	}
+	return got
+}
+
+func TestFitHistory(t *testing.T) {
+	tokenizer := approxTokenizer{}
+	cost := func(messages ...eino.Message) int {
+		total := 0
+		for _, msg := range messages {
+			total += CountMessageTokens(tokenizer, msg)
+		}
+		return total
+	}
+
+	q1, a1 := userMessage("first question"), assistantMessage("first answer")
+	q2 := userMessage("second question")
+	call := eino.Message{Role: eino.RoleAssistant, Content: "checking", ToolCalls: []eino.ToolCall{{ID: "c1", Name: "search"}, {ID: "c2", Name: "search"}}}
+	result1 := eino.Message{Role: eino.RoleTool, Content: "result one", ToolCallID: "c1"}
+	result2 := eino.Message{Role: eino.RoleTool, Content: "result two", ToolCallID: "c2"}
+	a2 := assistantMessage("second answer")
+	history := []eino.Message{q1, a1, q2, call, result1, result2, a2}
+
+	tests := []struct {
+		name   string
+		budget int
+		want   []eino.Message
+	}{
+		{"everything fits", cost(history...), history},
+		{"drops oldest messages", cost(q2, call, result1, result2, a2), []eino.Message{q2, call, result1, result2, a2}},
+		// 工具调用与结果整体保留或丢弃
+		{"keeps tool group whole", cost(call, result1, result2, a2) - 1, []eino.Message{a2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FitHistory(tokenizer, history, tt.budget); !equalStrings(roles(got), roles(tt.want)) {
+				t.Errorf("FitHistory = %v, want %v", roles(got), roles(tt.want))
+			}
+		})
+	}
+
+	t.Run("incomplete tool call", func(t *testing.T) {
+		// 缺少 c2 的结果时只保留助手文本，孤立的工具结果被丢弃
+		got := FitHistory(tokenizer, []eino.Message{result2, q1, call, result1, a1}, 1000)
+		want := []eino.Message{q1, assistantMessage("checking"), a1}
+		if !equalStrings(roles(got), roles(want)) {
+			t.Errorf("FitHistory = %v, want %v", roles(got), roles(want))
+		}
+		if len(got[1].ToolCalls) != 0 {
+			t.Errorf("incomplete tool calls kept: %+v", got[1].ToolCalls)
+		}
+	})
+
+	t.Run("truncates latest message", func(t *testing.T) {
+		long := userMessage(strings.Repeat("abcd", 100))
+		budget := cost(userMessage("")) + 10
+		got := FitHistory(tokenizer, []eino.Message{q1, long}, budget)
+		if len(got) != 1 || got[0].Content != strings.Repeat("abcd", 10) {
+			t.Errorf("FitHistory = %v, want latest message truncated to 10 tokens", roles(got))
+		}
+	})
+
+	t.Run("truncates tool results", func(t *testing.T) {
+		long1 := eino.Message{Role: eino.RoleTool, Content: strings.Repeat("abcd", 100), ToolCallID: "c1"}
+		long2 := eino.Message{Role: eino.RoleTool, Content: strings.Repeat("wxyz", 100), ToolCallID: "c2"}
+		empty1, empty2 := long1, long2
+		empty1.Content, empty2.Content = "", ""
+		budget := cost(call, empty1, empty2) + 20
+		got := FitHistory(tokenizer, []eino.Message{call, long1, long2}, budget)
+		if len(got) != 3 || got[0].Content != "checking" {
+			t.Fatalf("FitHistory = %v, want tool call with both results", roles(got))
+		}
+		if got[1].Content != strings.Repeat("abcd", 10) || got[2].Content != strings.Repeat("wxyz", 10) {
+			t.Errorf("tool results = %q, %q, want 10 tokens each", got[1].Content, got[2].Content)
+		}
+	})
+
+	if got := FitHistory(tokenizer, nil, 100); got != nil {
+		t.Errorf("FitHistory(nil) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,461 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 消息元数据中保存工具调用信息的键
+const (
+	metadataToolCalls  = "tool_calls"
+	metadataToolCallID = "tool_call_id"
+	metadataToolError  = "tool_error"
+)
+
+// MetadataAttachments 用户消息元数据中保存所引用附件ID列表的键
+const MetadataAttachments = "attachments"
+
+// ErrMessageRejected 表示写入检查拒绝了用户消息，本轮对话不应继续
+var ErrMessageRejected = errors.New("message rejected")
+
+// MessageGuard 在锁定对话的事务中、写入用户消息前执行的检查，返回错误时消息不会写入
+type MessageGuard func(tx *gorm.DB) error
+
+// AttachmentResolver 将消息引用的附件还原为模型输入。
+// 返回的文本内容会追加到消息正文，图片内容仅在 withImages 为 true 时返回
+type AttachmentResolver interface {
+	ResolveAttachments(ctx context.Context, ids []string, withImages bool) ([]eino.Content, error)
+}
+
+// ConversationMemory 持久化在对话消息表中的记忆
+type ConversationMemory interface {
+	Memory
+	ConversationID() uuid.UUID
+}
+
+// GormMemory 基于消息表的对话记忆，运行时与API共享同一份历史，多个服务实例之间也保持一致。
+// 消息通过 parent_id 组成树，对话的 active_leaf_id 指向当前分支的最后一条消息，历史沿当前分支读取。
+type GormMemory struct {
+	db             *gorm.DB
+	ctx            context.Context
+	conversationID uuid.UUID
+	maxMessages    int
+	metadata       models.JSONMap
+	branch         *branchPoint
+	attachments    *attachmentSet
+	resolver       AttachmentResolver
+	guard          MessageGuard
+}
+
+// attachmentSet 下一条用户消息引用的附件
+type attachmentSet struct {
+	ids  []string
+	used bool
+}
+
+// branchPoint 下一条写入的消息不接在当前分支末尾时的位置
+type branchPoint struct {
+	parentID *uuid.UUID // 新消息的父消息，为空时作为新的根消息
+	replayID *uuid.UUID // 重放已有的用户消息：不再写入，只将当前分支切换到该消息
+	used     bool
+}
+
+// NewGormMemory 创建对话记忆，maxMessages 为读取历史时保留的最近消息数，0表示不限制
+func NewGormMemory(db *gorm.DB, conversationID uuid.UUID, maxMessages int) *GormMemory {
+	return &GormMemory{
+		db:             db,
+		ctx:            context.Background(),
+		conversationID: conversationID,
+		maxMessages:    maxMessages,
+	}
+}
+
+// WithContext 返回使用指定上下文访问数据库的副本
+func (m *GormMemory) WithContext(ctx context.Context) *GormMemory {
+	copied := *m
+	copied.ctx = ctx
+	return &copied
+}
+
+// WithMetadata 返回写入消息时附加指定元数据的副本，例如运行ID或审批ID
+func (m *GormMemory) WithMetadata(metadata models.JSONMap) *GormMemory {
+	copied := *m
+	copied.metadata = make(models.JSONMap, len(m.metadata)+len(metadata))
+	for key, value := range m.metadata {
+		copied.metadata[key] = value
+	}
+	for key, value := range metadata {
+		copied.metadata[key] = value
+	}
+	return &copied
+}
+
+// WithMaxMessages 返回使用指定历史消息数上限的副本
+func (m *GormMemory) WithMaxMessages(maxMessages int) *GormMemory {
+	copied := *m
+	copied.maxMessages = maxMessages
+	return &copied
+}
+
+// WithBranch 返回下一条消息作为 parentID 子消息写入的副本，用于编辑消息后重新发送
+func (m *GormMemory) WithBranch(parentID *uuid.UUID) *GormMemory {
+	copied := *m
+	copied.branch = &branchPoint{parentID: parentID}
+	return &copied
+}
+
+// WithReplay 返回重放指定用户消息的副本：智能体写入该轮用户消息时不新增记录，
+// 新的回复作为该消息的另一个子消息，用于保留旧回复的重新生成
+func (m *GormMemory) WithReplay(messageID uuid.UUID) *GormMemory {
+	copied := *m
+	copied.branch = &branchPoint{replayID: &messageID}
+	return &copied
+}
+
+// WithAttachments 返回下一条写入的用户消息引用指定附件的副本
+func (m *GormMemory) WithAttachments(ids []string) *GormMemory {
+	copied := *m
+	if len(ids) > 0 {
+		copied.attachments = &attachmentSet{ids: ids}
+	}
+	return &copied
+}
+
+// WithAttachmentResolver 返回读取历史时还原附件内容的副本
+func (m *GormMemory) WithAttachmentResolver(resolver AttachmentResolver) *GormMemory {
+	copied := *m
+	copied.resolver = resolver
+	return &copied
+}
+
+// WithGuard 返回写入用户消息前执行指定检查的副本。检查与写入在同一事务中并持有对话行锁，
+// 并发请求不会同时通过检查
+func (m *GormMemory) WithGuard(guard MessageGuard) *GormMemory {
+	copied := *m
+	copied.guard = guard
+	return &copied
+}
+
+// ConversationID 返回记忆对应的对话ID
+func (m *GormMemory) ConversationID() uuid.UUID {
+	return m.conversationID
+}
+
+// AddMessage 将消息接在当前分支末尾写入消息表，并将当前分支指向新消息
+func (m *GormMemory) AddMessage(msg eino.Message) error {
+	record, err := MessageToRecord(m.conversationID, msg, m.metadata)
+	if err != nil {
+		return err
+	}
+
+	return m.db.WithContext(m.ctx).Transaction(func(tx *gorm.DB) error {
+		// 锁定对话，保证并发写入时父子关系不交错
+		var conversation models.Conversation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "active_leaf_id").
+			First(&conversation, "id = ?", m.conversationID).Error; err != nil {
+			return err
+		}
+
+		if m.guard != nil && msg.Role == eino.RoleUser {
+			if err := m.guard(tx); err != nil {
+				return fmt.Errorf("%w: %w", ErrMessageRejected, err)
+			}
+		}
+
+		parentID := conversation.ActiveLeafID
+		if parentID == nil {
+			if parentID, err = linkLegacyMessages(tx, m.conversationID); err != nil {
+				return err
+			}
+		}
+
+		if branch := m.branch; branch != nil && !branch.used {
+			if branch.replayID != nil && msg.Role == eino.RoleUser {
+				branch.used = true
+				return setActiveLeaf(tx, m.conversationID, *branch.replayID)
+			}
+			if branch.replayID == nil {
+				branch.used = true
+				parentID = branch.parentID
+			}
+		}
+
+		if set := m.attachments; set != nil && !set.used && msg.Role == eino.RoleUser {
+			set.used = true
+			record.Metadata[MetadataAttachments] = set.ids
+		}
+
+		record.ParentID = parentID
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return setActiveLeaf(tx, m.conversationID, record.ID)
+	})
+}
+
+// GetMessages 按数据库序号读取对话历史
+func (m *GormMemory) GetMessages() ([]eino.Message, error) {
+	records, err := m.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	return trimOrphanToolMessages(m.toMessages(records)), nil
+}
+
+// toMessages 将消息记录还原为运行时消息，并通过附件解析器展开附件：
+// 文档文本追加到消息正文，图片只随最后一条带附件的用户消息发送，避免每轮重复读取历史图片
+func (m *GormMemory) toMessages(records []models.Message) []eino.Message {
+	lastWithAttachments := -1
+	if m.resolver != nil {
+		for i := len(records) - 1; i >= 0; i-- {
+			if records[i].Role == eino.RoleUser && len(AttachmentIDs(records[i])) > 0 {
+				lastWithAttachments = i
+				break
+			}
+		}
+	}
+
+	messages := make([]eino.Message, 0, len(records))
+	for i, record := range records {
+		msg := RecordToMessage(record)
+		if ids := AttachmentIDs(record); m.resolver != nil && record.Role == eino.RoleUser && len(ids) > 0 {
+			contents, err := m.resolver.ResolveAttachments(m.ctx, ids, i == lastWithAttachments)
+			if err != nil {
+				zap.L().Warn("Failed to resolve message attachments",
+					zap.String("message_id", record.ID.String()), zap.Error(err))
+			}
+			msg = withAttachmentContents(msg, contents)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// AttachmentIDs 返回消息元数据中引用的附件ID
+func AttachmentIDs(record models.Message) []string {
+	var ids []string
+	if raw, ok := record.Metadata[MetadataAttachments]; ok {
+		if err := fromJSONValue(raw, &ids); err != nil {
+			return nil
+		}
+	}
+	return ids
+}
+
+// withAttachmentContents 文本内容追加到正文；含图片时改用多段内容，正文作为第一段
+func withAttachmentContents(msg eino.Message, contents []eino.Content) eino.Message {
+	var images []eino.Content
+	for _, content := range contents {
+		switch content.Type {
+		case eino.ContentTypeText:
+			msg.Content += "\n\n" + content.Text
+		case eino.ContentTypeImage:
+			images = append(images, content)
+		}
+	}
+	if len(images) > 0 {
+		msg.Contents = append([]eino.Content{{Type: eino.ContentTypeText, Text: msg.Content}}, images...)
+	}
+	return msg
+}
+
+// Records 返回当前分支上记忆窗口内的原始消息记录
+func (m *GormMemory) Records() ([]models.Message, error) {
+	records, err := ActivePath(m.db.WithContext(m.ctx), m.conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if m.maxMessages > 0 && len(records) > m.maxMessages {
+		records = records[len(records)-m.maxMessages:]
+	}
+	return records, nil
+}
+
+// Checkout 将当前分支切换到包含指定消息的分支，并沿最新的子消息走到分支末尾
+func (m *GormMemory) Checkout(messageID uuid.UUID) error {
+	return m.db.WithContext(m.ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := linkLegacyMessages(tx, m.conversationID); err != nil {
+			return err
+		}
+
+		var leaf models.Message
+		if err := tx.Raw(`
+			WITH RECURSIVE descendants AS (
+				SELECT id, sequence, 0 AS depth FROM messages WHERE id = ? AND conversation_id = ?
+				UNION ALL
+				SELECT child.id, child.sequence, d.depth + 1
+				FROM descendants d
+				CROSS JOIN LATERAL (
+					SELECT id, sequence FROM messages
+					WHERE parent_id = d.id
+					ORDER BY sequence DESC
+					LIMIT 1
+				) child
+			)
+			SELECT id FROM descendants ORDER BY depth DESC LIMIT 1`,
+			messageID, m.conversationID).Scan(&leaf).Error; err != nil {
+			return err
+		}
+		if leaf.ID == uuid.Nil {
+			return fmt.Errorf("message %s not found in conversation %s", messageID, m.conversationID)
+		}
+		return setActiveLeaf(tx, m.conversationID, leaf.ID)
+	})
+}
+
+// Clear 删除对话的全部消息
+func (m *GormMemory) Clear() error {
+	return m.db.WithContext(m.ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id = ?", m.conversationID).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Conversation{}).
+			Where("id = ?", m.conversationID).
+			Update("active_leaf_id", nil).Error
+	})
+}
+
+// ActivePath 返回对话当前分支从根到末尾的消息；尚未记录分支的旧对话按创建时间返回全部消息
+func ActivePath(db *gorm.DB, conversationID uuid.UUID) ([]models.Message, error) {
+	var conversation models.Conversation
+	if err := db.Select("id", "active_leaf_id").First(&conversation, "id = ?", conversationID).Error; err != nil {
+		return nil, err
+	}
+
+	var records []models.Message
+	if conversation.ActiveLeafID == nil {
+		if err := db.Where("conversation_id = ?", conversationID).Order("created_at ASC, id ASC").Find(&records).Error; err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+
+	if err := db.Raw(`
+		WITH RECURSIVE path AS (
+			SELECT messages.*, 0 AS depth FROM messages WHERE id = ? AND conversation_id = ?
+			UNION ALL
+			SELECT m.*, p.depth + 1 FROM messages m JOIN path p ON m.id = p.parent_id
+		)
+		SELECT * FROM path ORDER BY depth DESC`,
+		*conversation.ActiveLeafID, conversationID).Scan(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// linkLegacyMessages 为尚未记录分支的旧对话按创建时间补齐父子关系，返回最后一条消息的ID；
+// 已记录分支的对话返回空，新消息作为根消息写入。旧消息的序号是添加该列时补填的，不反映写入顺序
+func linkLegacyMessages(tx *gorm.DB, conversationID uuid.UUID) (*uuid.UUID, error) {
+	var linked int64
+	if err := tx.Model(&models.Message{}).
+		Where("conversation_id = ? AND parent_id IS NOT NULL", conversationID).
+		Limit(1).Count(&linked).Error; err != nil {
+		return nil, err
+	}
+	if linked > 0 {
+		return nil, nil
+	}
+
+	if err := tx.Exec(`
+		UPDATE messages SET parent_id = ordered.previous_id
+		FROM (
+			SELECT id, LAG(id) OVER (ORDER BY created_at, id) AS previous_id
+			FROM messages WHERE conversation_id = ?
+		) ordered
+		WHERE messages.id = ordered.id AND messages.parent_id IS NULL AND ordered.previous_id IS NOT NULL`,
+		conversationID).Error; err != nil {
+		return nil, err
+	}
+
+	var last models.Message
+	err := tx.Select("id").Where("conversation_id = ?", conversationID).Order("created_at DESC, id DESC").First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &last.ID, nil
+}
+
+// setActiveLeaf 将对话的当前分支指向指定消息并刷新对话的更新时间
+func setActiveLeaf(tx *gorm.DB, conversationID, leafID uuid.UUID) error {
+	return tx.Model(&models.Conversation{}).
+		Where("id = ?", conversationID).
+		Updates(map[string]interface{}{
+			"active_leaf_id": leafID,
+			"updated_at":     time.Now(),
+		}).Error
+}
+
+// MessageToRecord 将运行时消息转换为消息记录，工具调用信息保存在元数据中
+func MessageToRecord(conversationID uuid.UUID, msg eino.Message, metadata models.JSONMap) (models.Message, error) {
+	recordMetadata := make(models.JSONMap, len(metadata)+3)
+	for key, value := range metadata {
+		recordMetadata[key] = value
+	}
+
+	if len(msg.ToolCalls) > 0 {
+		calls, err := toJSONValue(msg.ToolCalls)
+		if err != nil {
+			return models.Message{}, fmt.Errorf("failed to encode tool calls: %w", err)
+		}
+		recordMetadata[metadataToolCalls] = calls
+	}
+	if msg.ToolCallID != "" {
+		recordMetadata[metadataToolCallID] = msg.ToolCallID
+	}
+	if msg.Error != "" {
+		recordMetadata[metadataToolError] = msg.Error
+	}
+
+	return models.Message{
+		ConversationID: conversationID,
+		Role:           msg.Role,
+		Content:        msg.Content,
+		Metadata:       recordMetadata,
+	}, nil
+}
+
+// RecordToMessage 将消息记录还原为运行时消息
+func RecordToMessage(record models.Message) eino.Message {
+	msg := eino.Message{
+		Role:    record.Role,
+		Content: record.Content,
+	}
+
+	if raw, ok := record.Metadata[metadataToolCalls]; ok {
+		var calls []eino.ToolCall
+		if err := fromJSONValue(raw, &calls); err == nil {
+			msg.ToolCalls = calls
+		}
+	}
+	if id, ok := record.Metadata[metadataToolCallID].(string); ok {
+		msg.ToolCallID = id
+	}
+	if errMsg, ok := record.Metadata[metadataToolError].(string); ok {
+		msg.Error = errMsg
+	}
+
+	return msg
+}
+
+// trimOrphanToolMessages 去掉窗口开头缺少对应工具调用的工具结果
+func trimOrphanToolMessages(messages []eino.Message) []eino.Message {
+	start := 0
+	for start < len(messages) && messages[start].Role == eino.RoleTool {
+		start++
+	}
+	return messages[start:]
+}
@@ -0,0 +1,340 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"gorm.io/gorm"
+)
+
+func TestGormMemoryGuardSerializesUserMessages(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+
+	// 对话中已有用户消息时拒绝写入，并发请求在对话行锁下依次检查，只有一个能通过
+	errBusy := errors.New("busy")
+	memory := NewGormMemory(db, conversation.ID, 0).WithGuard(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Message{}).
+			Where("conversation_id = ? AND role = ?", conversation.ID, eino.RoleUser).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errBusy
+		}
+		return nil
+	})
+
+	const writers = 8
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = memory.AddMessage(eino.Message{Role: eino.RoleUser, Content: "hello"})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrMessageRejected) || !errors.Is(err, errBusy):
+			t.Errorf("AddMessage error = %v, want rejection", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent user messages written, want 1", succeeded)
+	}
+
+	// 检查只针对用户消息
+	if err := memory.AddMessage(eino.Message{Role: eino.RoleAssistant, Content: "hi"}); err != nil {
+		t.Fatalf("AddMessage(assistant): %v", err)
+	}
+	var count int64
+	db.Model(&models.Message{}).Where("conversation_id = ?", conversation.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("%d messages stored, want 2", count)
+	}
+}
+
+// addTestMessages 依次写入消息，返回各条消息的记录ID
+func addTestMessages(t *testing.T, memory *GormMemory, messages ...eino.Message) []uuid.UUID {
+	t.Helper()
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, msg := range messages {
+		if err := memory.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage(%s): %v", msg.Content, err)
+		}
+		records, err := memory.Records()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, records[len(records)-1].ID)
+	}
+	return ids
+}
+
+// assertActivePath 检查当前分支上的消息内容
+func assertActivePath(t *testing.T, memory *GormMemory, want ...string) {
+	t.Helper()
+	records, err := memory.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(records))
+	for i, record := range records {
+		got[i] = record.Content
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("active path = %v, want %v", got, want)
+	}
+}
+
+func userMessage(content string) eino.Message {
+	return eino.Message{Role: eino.RoleUser, Content: content}
+}
+func assistantMessage(content string) eino.Message {
+	return eino.Message{Role: eino.RoleAssistant, Content: content}
+}
+
+func TestGormMemoryEditBranch(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+	memory := NewGormMemory(db, conversation.ID, 0)
+
+	ids := addTestMessages(t, memory, userMessage("q1"), assistantMessage("a1"), userMessage("q2"), assistantMessage("a2"))
+
+	// 编辑第二个问题：新问题接在 a1 之后，旧分支保留
+	edited := memory.WithBranch(&ids[1])
+	addTestMessages(t, edited, userMessage("q2 edited"), assistantMessage("a2 edited"))
+	assertActivePath(t, memory, "q1", "a1", "q2 edited", "a2 edited")
+
+	// 编辑第一个问题：新分支作为根消息
+	addTestMessages(t, memory.WithBranch(nil), userMessage("q1 edited"))
+	assertActivePath(t, memory, "q1 edited")
+
+	var count int64
+	db.Model(&models.Message{}).Where("conversation_id = ?", conversation.ID).Count(&count)
+	if count != 7 {
+		t.Errorf("%d messages stored, want 7", count)
+	}
+}
+
+func TestGormMemoryRegenerate(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+	memory := NewGormMemory(db, conversation.ID, 0)
+
+	ids := addTestMessages(t, memory, userMessage("q1"), assistantMessage("a1"))
+
+	// 重放用户消息时不新增记录，新回复作为 q1 的另一个子消息
+	replay := memory.WithReplay(ids[0])
+	if err := replay.AddMessage(userMessage("q1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.AddMessage(assistantMessage("a1 regenerated")); err != nil {
+		t.Fatal(err)
+	}
+	assertActivePath(t, memory, "q1", "a1 regenerated")
+
+	var children []models.Message
+	if err := db.Where("parent_id = ?", ids[0]).Find(&children).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 2 {
+		t.Errorf("q1 has %d replies, want 2", len(children))
+	}
+	var users int64
+	db.Model(&models.Message{}).Where("conversation_id = ? AND role = ?", conversation.ID, eino.RoleUser).Count(&users)
+	if users != 1 {
+		t.Errorf("%d user messages stored, want 1", users)
+	}
+}
+
+func TestGormMemoryCheckout(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+	memory := NewGormMemory(db, conversation.ID, 0)
+
+	ids := addTestMessages(t, memory, userMessage("q1"), assistantMessage("a1"), userMessage("q2"), assistantMessage("a2"))
+	addTestMessages(t, memory.WithBranch(&ids[1]), userMessage("q2 edited"), assistantMessage("a2 edited"))
+
+	// 切换到旧分支上的消息时沿最新的子消息走到分支末尾
+	if err := memory.Checkout(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	assertActivePath(t, memory, "q1", "a1", "q2", "a2")
+
+	// 切换到分叉点时选择最新的分支
+	if err := memory.Checkout(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertActivePath(t, memory, "q1", "a1", "q2 edited", "a2 edited")
+
+	// 其他对话的消息不能切换
+	other := NewGormMemory(db, createTestConversation(t, db).ID, 0)
+	if err := other.Checkout(ids[0]); err == nil {
+		t.Error("Checkout with message from another conversation succeeded, want error")
+	}
+}
+
+func TestGormMemoryLegacyConversation(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+
+	// 旧消息没有父子关系，序号是补填的，与创建顺序不一致
+	base := time.Now().Add(-time.Hour)
+	legacy := []struct {
+		content string
+		offset  time.Duration
+	}{
+		{"a1", 2 * time.Second},
+		{"q1", 1 * time.Second},
+		{"q2", 3 * time.Second},
+	}
+	for _, m := range legacy {
+		role := eino.RoleAssistant
+		if m.content[0] == 'q' {
+			role = eino.RoleUser
+		}
+		record := models.Message{ConversationID: conversation.ID, Role: role, Content: m.content, CreatedAt: base.Add(m.offset)}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	memory := NewGormMemory(db, conversation.ID, 0)
+	assertActivePath(t, memory, "q1", "a1", "q2")
+
+	// 写入新消息时按创建时间补齐父子关系，新消息接在最后创建的消息之后
+	addTestMessages(t, memory, assistantMessage("a2"))
+	assertActivePath(t, memory, "q1", "a1", "q2", "a2")
+
+	var roots int64
+	db.Model(&models.Message{}).Where("conversation_id = ? AND parent_id IS NULL", conversation.ID).Count(&roots)
+	if roots != 1 {
+		t.Errorf("%d root messages, want 1", roots)
+	}
+}
+
+func TestMessageRecordRoundTrip(t *testing.T) {
+	conversationID := uuid.New()
+	messages := []eino.Message{
+		userMessage("hello"),
+		{Role: eino.RoleAssistant, Content: "calling", ToolCalls: []eino.ToolCall{
+			{ID: "c1", Name: "search", Arguments: `{"q":"go"}`},
+		}},
+		{Role: eino.RoleTool, Content: "", ToolCallID: "c1", Error: "timeout"},
+	}
+	for _, msg := range messages {
+		record, err := MessageToRecord(conversationID, msg, models.JSONMap{"run_id": "r1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.ConversationID != conversationID || record.Metadata["run_id"] != "r1" {
+			t.Errorf("record = %+v, want conversation and run metadata", record)
+		}
+		got := RecordToMessage(record)
+		if got.Role != msg.Role || got.Content != msg.Content || got.ToolCallID != msg.ToolCallID || got.Error != msg.Error {
+			t.Errorf("round trip = %+v, want %+v", got, msg)
+		}
+		if len(got.ToolCalls) != len(msg.ToolCalls) {
+			t.Fatalf("round trip tool calls = %+v, want %+v", got.ToolCalls, msg.ToolCalls)
+		}
+		for i := range got.ToolCalls {
+			if got.ToolCalls[i] != msg.ToolCalls[i] {
+				t.Errorf("tool call %d = %+v, want %+v", i, got.ToolCalls[i], msg.ToolCalls[i])
+			}
+		}
+	}
+}
+
+func TestTrimOrphanToolMessages(t *testing.T) {
+	tool := eino.Message{Role: eino.RoleTool, ToolCallID: "c1"}
+	tests := []struct {
+		name     string
+		messages []eino.Message
+		want     int
+	}{
+		{"empty", nil, 0},
+		{"leading tool results", []eino.Message{tool, tool, userMessage("q"), tool}, 2},
+		{"only tool results", []eino.Message{tool}, 0},
+		{"no tool results", []eino.Message{userMessage("q"), assistantMessage("a")}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimOrphanToolMessages(tt.messages)
+			if len(got) != tt.want {
+				t.Fatalf("trimmed to %d messages, want %d", len(got), tt.want)
+			}
+			if len(got) > 0 && got[0].Role == eino.RoleTool {
+				t.Errorf("window starts with tool result %+v", got[0])
+			}
+		})
+	}
+}
+
+func TestGormMemoryWindow(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+	memory := NewGormMemory(db, conversation.ID, 0).WithMetadata(models.JSONMap{"run_id": "r1"})
+
+	addTestMessages(t, memory,
+		userMessage("q1"),
+		eino.Message{Role: eino.RoleAssistant, ToolCalls: []eino.ToolCall{{ID: "c1", Name: "search"}}},
+		eino.Message{Role: eino.RoleTool, Content: "result", ToolCallID: "c1"},
+		assistantMessage("a1"),
+	)
+
+	records, err := memory.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if record.Metadata["run_id"] != "r1" {
+			t.Errorf("message %q metadata = %v, want run_id", record.Content, record.Metadata)
+		}
+	}
+
+	// 窗口截断在工具调用与结果之间时，去掉开头的工具结果
+	messages, err := memory.WithMaxMessages(2).GetMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Content != "a1" {
+		t.Errorf("windowed messages = %+v, want only a1", messages)
+	}
+	messages, err = memory.GetMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 || messages[2].ToolCallID != "c1" {
+		t.Errorf("messages = %+v, want full history with tool result", messages)
+	}
+
+	if err := memory.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	assertActivePath(t, memory)
+	var stored models.Conversation
+	if err := db.First(&stored, "id = ?", conversation.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ActiveLeafID != nil {
+		t.Errorf("active leaf after Clear = %s, want nil", stored.ActiveLeafID)
+	}
+
+	// 清空后重新开始的消息作为根消息
+	addTestMessages(t, memory, userMessage("again"))
+	assertActivePath(t, memory, "again")
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// openTestDB 创建测试所需的表，未配置测试数据库时跳过
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t,
+		&models.Agent{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.ToolApproval{},
+		&models.AgentRun{},
+		&models.AgentRunStep{},
+	)
+}
+
+// createTestConversation 创建测试对话
+func createTestConversation(t *testing.T, db *gorm.DB) *models.Conversation {
+	t.Helper()
+	conversation := models.Conversation{AgentID: uuid.New(), UserID: uuid.New(), Title: "test"}
+	if err := db.Create(&conversation).Error; err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	return &conversation
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 // AgentType 表示智能体类型
@@ -32,12 +34,16 @@ type AgentTemplate struct {
 	Config       map[string]interface{} `json:"config,omitempty"`
 }
 
+// WorkflowRunner 以指定用户的身份执行工作流定义并返回工作流输出，由工作流引擎实现
+type WorkflowRunner func(ctx context.Context, definition json.RawMessage, inputs map[string]interface{}, userID uuid.UUID) (map[string]interface{}, error)
+
 // AgentFactory 智能体工厂，负责创建智能体实例
 type AgentFactory struct {
-	templates     map[string]AgentTemplate
-	toolRegistry  *ToolRegistry
-	modelProvider ModelProvider
-	mu            sync.RWMutex
+	templates      map[string]AgentTemplate
+	toolRegistry   *ToolRegistry
+	modelProvider  ModelProvider
+	workflowRunner WorkflowRunner
+	mu             sync.RWMutex
 }
 
 // ModelProvider 模型提供商接口
@@ -88,7 +94,7 @@ func (f *AgentFactory) registerDefaultTemplates() {
 		},
 	})
 	
-	// 工作流智能体模板
+	// 工作流智能体模板，创建时需要在配置的 workflow 字段中提供工作流定义
 	f.RegisterTemplate("default_workflow", AgentTemplate{
 		Type:         WorkflowAgent,
 		Name:         "工作流智能体",
@@ -102,6 +108,14 @@ func (f *AgentFactory) registerDefaultTemplates() {
 	})
 }
 
+// SetWorkflowRunner 设置工作流智能体使用的工作流执行器
+func (f *AgentFactory) SetWorkflowRunner(runner WorkflowRunner) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	
+	f.workflowRunner = runner
+}
+
 // RegisterTemplate 注册新的智能体模板
 func (f *AgentFactory) RegisterTemplate(templateID string, template AgentTemplate) error {
 	f.mu.Lock()
@@ -179,6 +193,13 @@ func (f *AgentFactory) CreateAgent(ctx context.Context, templateID, name, descri
 		}
 	}
 	
+	// 工作流智能体通过 run_workflow 工具执行配置的工作流
+	if template.Type == WorkflowAgent {
+		if err := f.addWorkflowTool(agent, config["workflow"]); err != nil {
+			return nil, err
+		}
+	}
+	
 	// 初始化Eino Agent
 	if f.modelProvider != nil {
 		apiKey, err := f.modelProvider.GetAPIKey(provider)
@@ -199,6 +220,57 @@ func (f *AgentFactory) CreateAgent(ctx context.Context, templateID, name, descri
 	return agent, nil
 }
 
+// addWorkflowTool 为智能体添加执行工作流定义的工具，工作流以上下文中的用户身份运行
+func (f *AgentFactory) addWorkflowTool(agent *Agent, workflow interface{}) error {
+	f.mu.RLock()
+	runner := f.workflowRunner
+	f.mu.RUnlock()
+	
+	if runner == nil {
+		return errors.New("workflow runner is not configured")
+	}
+	if workflow == nil {
+		return errors.New("workflow agent requires a workflow definition in config")
+	}
+	definition, err := json.Marshal(workflow)
+	if err != nil {
+		return fmt.Errorf("invalid workflow definition: %w", err)
+	}
+	
+	agent.AddTool(Tool{
+		Name:        "run_workflow",
+		Description: "执行智能体配置的工作流，返回工作流输出",
+		Category:    CategoryUtility,
+		IsBuiltin:   true,
+		Version:     "1.0",
+		Parameters: map[string]interface{}{
+			"inputs": map[string]interface{}{
+				"type":        "object",
+				"description": "工作流输入参数",
+			},
+		},
+		Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			inputs, _ := params["inputs"].(map[string]interface{})
+			return runner(ctx, definition, inputs, UserIDFromContext(ctx))
+		},
+	})
+	return nil
+}
+
+// userIDKey 是上下文中当前用户ID的键
+type userIDKey struct{}
+
+// WithUserID 将当前用户ID附加到上下文，工作流智能体以该用户的权限执行工作流
+func WithUserID(ctx context.Context, userID uuid.UUID) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext 从上下文中获取当前用户ID
+func UserIDFromContext(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(userIDKey{}).(uuid.UUID)
+	return id
+}
+
 // CreateCustomAgent 创建自定义智能体
 func (f *AgentFactory) CreateCustomAgent(ctx context.Context, name, description, model, provider, systemPrompt string, toolNames []string, config map[string]interface{}) (*Agent, error) {
 	// 创建基础智能体
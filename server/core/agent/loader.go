@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrAgentNotFound 数据库中不存在指定智能体
+var ErrAgentNotFound = errors.New("agent not found")
+
+// Decrypter 解密已加密存储的密钥
+type Decrypter interface {
+	Decrypt(encryptedText string) (string, error)
+}
+
+// AgentLoader 根据数据库中的智能体配置构建运行时智能体
+type AgentLoader struct {
+	db             *gorm.DB
+	decrypter      Decrypter
+	toolRegistry   *ToolRegistry
+	longTermMemory LongTermMemoryProvider
+	logger         *zap.Logger
+}
+
+// NewAgentLoader 创建智能体加载器
+func NewAgentLoader(db *gorm.DB, decrypter Decrypter, toolRegistry *ToolRegistry) *AgentLoader {
+	if toolRegistry == nil {
+		toolRegistry = DefaultToolRegistry
+	}
+	return &AgentLoader{
+		db:           db,
+		decrypter:    decrypter,
+		toolRegistry: toolRegistry,
+		logger:       zap.L().With(zap.String("component", "agent_loader")),
+	}
+}
+
+// Load 加载智能体配置并初始化运行时
+func (l *AgentLoader) Load(ctx context.Context, agentID uuid.UUID) (*Agent, error) {
+	return l.LoadWithTools(ctx, agentID)
+}
+
+// LoadWithTools 加载智能体并在初始化前附加额外工具，例如编排器注入的委派工具
+func (l *AgentLoader) LoadWithTools(ctx context.Context, agentID uuid.UUID, extraTools ...Tool) (*Agent, error) {
+	return l.load(ctx, agentID, nil, extraTools)
+}
+
+// LoadWithModelConfig 加载智能体但改用指定的模型配置，例如消息包含图片而智能体的模型不支持视觉时
+func (l *AgentLoader) LoadWithModelConfig(ctx context.Context, agentID uuid.UUID, modelConfigID uuid.UUID) (*Agent, error) {
+	return l.load(ctx, agentID, &modelConfigID, nil)
+}
+
+func (l *AgentLoader) load(ctx context.Context, agentID uuid.UUID, modelConfigID *uuid.UUID, extraTools []Tool) (*Agent, error) {
+	var record models.Agent
+	if err := l.db.WithContext(ctx).Preload("ModelConfig.Model").First(&record, "id = ?", agentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, err
+	}
+
+	if modelConfigID != nil {
+		var override models.ModelConfig
+		if err := l.db.WithContext(ctx).Preload("Model").First(&override, "id = ?", *modelConfigID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load model config %s: %w", *modelConfigID, err)
+		}
+		record.ModelConfig = &override
+	}
+
+	if record.ModelConfig == nil || record.ModelConfig.Model == nil {
+		return nil, fmt.Errorf("agent %s has no usable model config", agentID)
+	}
+	modelConfig := record.ModelConfig
+	model := modelConfig.Model
+
+	config := map[string]interface{}{}
+	if modelConfig.Parameters.Temperature != nil {
+		config["temperature"] = float64(*modelConfig.Parameters.Temperature)
+	}
+	if modelConfig.Parameters.MaxTokens != nil {
+		config["max_tokens"] = *modelConfig.Parameters.MaxTokens
+	}
+
+	agent, err := NewAgent(record.Name, record.Description, model.ModelID, string(model.Provider), config)
+	if err != nil {
+		return nil, err
+	}
+	// 运行时ID与数据库ID保持一致，审批等持久化状态依赖它
+	agent.ID = record.ID.String()
+	agent.SetSystemPrompt(record.SystemPrompt)
+
+	// 工具配置格式为 {"工具名": true/配置}，值为false表示禁用
+	for name, value := range record.Tools {
+		if enabled, ok := value.(bool); ok && !enabled {
+			continue
+		}
+		tool, err := l.toolRegistry.GetTool(name)
+		if err != nil {
+			l.logger.Warn("Tool configured on agent is not registered",
+				zap.String("agent_id", agent.ID), zap.String("tool", name))
+			continue
+		}
+		agent.AddTool(tool)
+	}
+	for _, tool := range extraTools {
+		agent.AddTool(tool)
+	}
+
+	// 按模型上下文长度限制每次请求的token数
+	if model.MaxTokens > 0 {
+		reservedOutput := 0
+		if modelConfig.Parameters.MaxTokens != nil {
+			reservedOutput = *modelConfig.Parameters.MaxTokens
+		}
+		tokenizer := TokenizerFor(string(model.Provider), model.ModelID)
+		agent.SetContextWindow(NewContextWindow(tokenizer, model.MaxTokens, reservedOutput))
+	}
+
+	providerConfig, err := ResolveProviderConfig(modelConfig, l.decrypter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := agent.InitEinoAgent(ctx, providerConfig.ApiKey, providerConfig.BaseURL); err != nil {
+		return nil, fmt.Errorf("failed to initialize agent: %w", err)
+	}
+
+	return agent, nil
+}
+
+// ResolveProviderConfig 返回模型配置实际使用的提供商设置，API密钥已解密
+func ResolveProviderConfig(modelConfig *models.ModelConfig, decrypter Decrypter) (models.ModelProviderConfig, error) {
+	// 模型配置的提供商设置优先于模型默认设置
+	providerConfig := modelConfig.ProviderConfig
+	if providerConfig.ApiKey == "" && modelConfig.Model != nil {
+		providerConfig = modelConfig.Model.ProviderConfig
+	}
+
+	if providerConfig.ApiKey != "" && decrypter != nil {
+		apiKey, err := decrypter.Decrypt(providerConfig.ApiKey)
+		if err != nil {
+			return providerConfig, fmt.Errorf("failed to decrypt api key: %w", err)
+		}
+		providerConfig.ApiKey = apiKey
+	}
+
+	return providerConfig, nil
+}
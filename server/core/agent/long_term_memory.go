@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+)
+
+// defaultLongTermTopK 每轮召回的长期记忆条数
+const defaultLongTermTopK = 5
+
+// observeTimeout 对话结束后提取长期记忆的超时时间
+const observeTimeout = 2 * time.Minute
+
+// LongTermMemory 跨对话保留的用户长期记忆
+type LongTermMemory interface {
+	// Recall 返回与本轮输入相关的记忆
+	Recall(ctx context.Context, query string) ([]string, error)
+	// Observe 从一轮对话中提取值得长期记住的信息
+	Observe(ctx context.Context, messages []eino.Message) error
+}
+
+// LongTermMemoryProvider 按（智能体，用户）提供长期记忆
+type LongTermMemoryProvider interface {
+	ForUser(agentID, userID uuid.UUID, runtime *Agent, topK int) LongTermMemory
+}
+
+// SetLongTermMemoryProvider 设置长期记忆提供者，未设置时长期记忆不可用
+func (l *AgentLoader) SetLongTermMemoryProvider(provider LongTermMemoryProvider) {
+	l.longTermMemory = provider
+}
+
+// AttachLongTermMemory 智能体开启长期记忆时为其挂载指定用户的记忆
+func (l *AgentLoader) AttachLongTermMemory(runtime *Agent, record *models.Agent, userID uuid.UUID) {
+	if l.longTermMemory == nil || record == nil {
+		return
+	}
+
+	config, err := ParseMemoryConfig(record.MemoryConfig)
+	if err != nil || !config.LongTerm {
+		return
+	}
+
+	topK := config.LongTermTopK
+	if topK <= 0 {
+		topK = defaultLongTermTopK
+	}
+	runtime.SetLongTermMemory(l.longTermMemory.ForUser(record.ID, userID, runtime, topK))
+}
+
+// SetLongTermMemory 设置智能体的长期记忆
+func (a *Agent) SetLongTermMemory(memory LongTermMemory) {
+	a.longTermMemory = memory
+}
+
+// recallLongTerm 召回与本轮输入相关的长期记忆，失败时不影响对话
+func (a *Agent) recallLongTerm(ctx context.Context, query string) {
+	a.recalled = nil
+	if a.longTermMemory == nil {
+		return
+	}
+
+	memories, err := a.longTermMemory.Recall(ctx, query)
+	if err != nil {
+		zap.L().Warn("Failed to recall long-term memory", zap.String("agent_id", a.ID), zap.Error(err))
+		return
+	}
+	a.recalled = memories
+}
+
+// observeTurn 在后台从本轮对话中提取长期记忆
+func (a *Agent) observeTurn(ctx context.Context, userMessage, reply string) {
+	if a.longTermMemory == nil {
+		return
+	}
+
+	memory := a.longTermMemory
+	conversationID := ConversationIDFromContext(ctx)
+	messages := []eino.Message{
+		{Role: eino.RoleUser, Content: userMessage},
+		{Role: eino.RoleAssistant, Content: reply},
+	}
+
+	go func() {
+		// 请求结束后继续执行，不沿用请求的上下文
+		observeCtx, cancel := context.WithTimeout(WithConversationID(context.Background(), conversationID), observeTimeout)
+		defer cancel()
+
+		if err := memory.Observe(observeCtx, messages); err != nil {
+			zap.L().Warn("Failed to extract long-term memory", zap.String("agent_id", a.ID), zap.Error(err))
+		}
+	}()
+}
+
+// systemMessages 返回请求开头的系统消息：系统提示词以及本轮召回的长期记忆
+func (a *Agent) systemMessages() []eino.Message {
+	var messages []eino.Message
+	if a.SystemPrompt != "" {
+		messages = append(messages, eino.Message{
+			Role:    eino.RoleSystem,
+			Content: a.SystemPrompt,
+		})
+	}
+	if len(a.recalled) > 0 {
+		messages = append(messages, eino.Message{
+			Role:    eino.RoleSystem,
+			Content: "以下是关于该用户的长期记忆，仅在相关时参考：\n- " + strings.Join(a.recalled, "\n- "),
+		})
+	}
+	return messages
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+// stubLongTermMemory 返回固定召回结果的长期记忆
+type stubLongTermMemory struct {
+	memories []string
+	err      error
+	topK     int
+}
+
+func (m *stubLongTermMemory) Recall(ctx context.Context, query string) ([]string, error) {
+	return m.memories, m.err
+}
+
+func (m *stubLongTermMemory) Observe(ctx context.Context, messages []eino.Message) error {
+	return nil
+}
+
+// stubLongTermMemoryProvider 记录最近一次创建的长期记忆
+type stubLongTermMemoryProvider struct {
+	last *stubLongTermMemory
+}
+
+func (p *stubLongTermMemoryProvider) ForUser(agentID, userID uuid.UUID, runtime *Agent, topK int) LongTermMemory {
+	p.last = &stubLongTermMemory{topK: topK}
+	return p.last
+}
+
+func TestAttachLongTermMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   models.JSONMap
+		wantTopK int // 0 表示不挂载
+	}{
+		{"disabled", nil, 0},
+		{"invalid config", models.JSONMap{"strategy": "forever", "long_term": true}, 0},
+		{"default top k", models.JSONMap{"long_term": true}, defaultLongTermTopK},
+		{"configured top k", models.JSONMap{"long_term": true, "long_term_top_k": 3}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &stubLongTermMemoryProvider{}
+			loader := NewAgentLoader(nil, nil, nil)
+			loader.SetLongTermMemoryProvider(provider)
+
+			runtime := &Agent{}
+			loader.AttachLongTermMemory(runtime, &models.Agent{MemoryConfig: tt.config}, uuid.New())
+			if tt.wantTopK == 0 {
+				if runtime.longTermMemory != nil || provider.last != nil {
+					t.Error("long-term memory attached, want none")
+				}
+				return
+			}
+			if runtime.longTermMemory == nil || provider.last.topK != tt.wantTopK {
+				t.Errorf("attached memory = %+v, want top k %d", provider.last, tt.wantTopK)
+			}
+		})
+	}
+
+	// 未设置提供者时不挂载
+	runtime := &Agent{}
+	NewAgentLoader(nil, nil, nil).AttachLongTermMemory(runtime, &models.Agent{MemoryConfig: models.JSONMap{"long_term": true}}, uuid.New())
+	if runtime.longTermMemory != nil {
+		t.Error("long-term memory attached without provider")
+	}
+}
+
+func TestRecallLongTerm(t *testing.T) {
+	runtime := &Agent{SystemPrompt: "be brief"}
+	runtime.SetLongTermMemory(&stubLongTermMemory{memories: []string{"likes tea", "lives in Paris"}})
+
+	runtime.recallLongTerm(context.Background(), "hello")
+	messages := runtime.systemMessages()
+	if len(messages) != 2 || messages[0].Content != "be brief" {
+		t.Fatalf("system messages = %+v, want prompt and memories", messages)
+	}
+	if want := "以下是关于该用户的长期记忆，仅在相关时参考：\n- likes tea\n- lives in Paris"; messages[1].Content != want {
+		t.Errorf("memory message = %q, want %q", messages[1].Content, want)
+	}
+
+	// 召回失败时清空上一轮的记忆，不影响对话
+	runtime.SetLongTermMemory(&stubLongTermMemory{err: errors.New("vector db unavailable")})
+	runtime.recallLongTerm(context.Background(), "hello")
+	if messages := runtime.systemMessages(); len(messages) != 1 {
+		t.Errorf("system messages after failed recall = %+v, want only the prompt", messages)
+	}
+}
@@ -0,0 +1,589 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+)
+
+// OrchestrationMode 多智能体协作模式
+type OrchestrationMode string
+
+const (
+	OrchestrationSupervisor OrchestrationMode = "supervisor" // 主管智能体把其他智能体当作工具调用
+	OrchestrationHandoff    OrchestrationMode = "handoff"    // 智能体之间转交对话
+)
+
+// 编排器注入到智能体的工具名称
+const (
+	delegateToolName        = "delegate_to_agent"
+	handoffToolName         = "handoff_to_agent"
+	scratchpadReadToolName  = "scratchpad_read"
+	scratchpadWriteToolName = "scratchpad_write"
+)
+
+// 编排器写入合并跟踪的记录类型，其余类型沿用智能体运行时事件
+const (
+	TraceDelegate       = "delegate"        // 委派任务给其他智能体
+	TraceDelegateResult = "delegate_result" // 被委派的智能体返回结果
+	TraceHandoff        = "handoff"         // 对话转交给其他智能体
+)
+
+var (
+	ErrUnknownOrchestrationMode = errors.New("unknown orchestration mode")
+	ErrAgentNotInApplication    = errors.New("agent does not belong to the application")
+	ErrMaxHandoffsExceeded      = errors.New("maximum number of handoffs exceeded")
+	ErrMaxAgentCallsExceeded    = errors.New("maximum number of agent calls exceeded")
+)
+
+// OrchestratorConfig 编排器的深度与循环限制
+type OrchestratorConfig struct {
+	MaxDepth      int // 委派的最大嵌套深度，入口智能体深度为0
+	MaxHandoffs   int // 单次编排允许的最大转交次数
+	MaxAgentCalls int // 单次编排中智能体运行的总次数上限
+}
+
+// DefaultOrchestratorConfig 返回默认的编排器配置
+func DefaultOrchestratorConfig() OrchestratorConfig {
+	return OrchestratorConfig{
+		MaxDepth:      2,
+		MaxHandoffs:   5,
+		MaxAgentCalls: 20,
+	}
+}
+
+// AgentProfile 可参与编排的智能体
+type AgentProfile struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+}
+
+// OrchestrationRequest 一次多智能体编排请求
+type OrchestrationRequest struct {
+	ApplicationID uuid.UUID
+	EntryAgentID  uuid.UUID
+	Mode          OrchestrationMode
+	Input         string
+	History       []eino.Message         // 交给入口智能体的历史消息
+	Scratchpad    map[string]interface{} // 草稿板初始内容
+}
+
+// OrchestrationTraceEntry 合并跟踪中的一条记录
+type OrchestrationTraceEntry struct {
+	Sequence  int         `json:"sequence"`
+	AgentID   string      `json:"agent_id"`
+	AgentName string      `json:"agent_name"`
+	Depth     int         `json:"depth"`
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// OrchestrationResult 编排结果
+type OrchestrationResult struct {
+	Output       string                    `json:"output"`
+	FinalAgentID string                    `json:"final_agent_id"`
+	Handoffs     int                       `json:"handoffs"`
+	AgentCalls   int                       `json:"agent_calls"`
+	Scratchpad   map[string]interface{}    `json:"scratchpad"`
+	Trace        []OrchestrationTraceEntry `json:"trace"`
+}
+
+// Scratchpad 参与同一次编排的智能体共享的草稿板
+type Scratchpad struct {
+	data map[string]interface{}
+	mu   sync.RWMutex
+}
+
+// NewScratchpad 创建草稿板
+func NewScratchpad(initial map[string]interface{}) *Scratchpad {
+	data := make(map[string]interface{}, len(initial))
+	for key, value := range initial {
+		data[key] = value
+	}
+	return &Scratchpad{data: data}
+}
+
+// Read 读取草稿板中的值
+func (s *Scratchpad) Read(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.data[key]
+	return value, ok
+}
+
+// Write 写入草稿板
+func (s *Scratchpad) Write(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = value
+}
+
+// Snapshot 返回草稿板内容的副本
+func (s *Scratchpad) Snapshot() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := make(map[string]interface{}, len(s.data))
+	for key, value := range s.data {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// Orchestrator 在同一应用的智能体之间进行委派与转交
+type Orchestrator struct {
+	loader *AgentLoader
+	config OrchestratorConfig
+	logger *zap.Logger
+}
+
+// NewOrchestrator 创建多智能体编排器
+func NewOrchestrator(loader *AgentLoader, config OrchestratorConfig) *Orchestrator {
+	defaults := DefaultOrchestratorConfig()
+	if config.MaxDepth <= 0 {
+		config.MaxDepth = defaults.MaxDepth
+	}
+	if config.MaxHandoffs <= 0 {
+		config.MaxHandoffs = defaults.MaxHandoffs
+	}
+	if config.MaxAgentCalls <= 0 {
+		config.MaxAgentCalls = defaults.MaxAgentCalls
+	}
+
+	return &Orchestrator{
+		loader: loader,
+		config: config,
+		logger: zap.L().With(zap.String("component", "orchestrator")),
+	}
+}
+
+// Candidates 返回应用下可参与编排的智能体
+func (o *Orchestrator) Candidates(ctx context.Context, applicationID uuid.UUID) ([]AgentProfile, error) {
+	var records []models.Agent
+	if err := o.loader.db.WithContext(ctx).
+		Where("application_id = ?", applicationID).
+		Order("created_at ASC").
+		Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	profiles := make([]AgentProfile, len(records))
+	for i, record := range records {
+		profiles[i] = AgentProfile{
+			ID:          record.ID,
+			Name:        record.Name,
+			Description: record.Description,
+		}
+	}
+	return profiles, nil
+}
+
+// orchestration 单次编排的共享状态
+type orchestration struct {
+	mode       OrchestrationMode
+	candidates []AgentProfile
+	scratchpad *Scratchpad
+	trace      []OrchestrationTraceEntry
+	agentCalls int
+	mu         sync.Mutex
+}
+
+// handoffRequest 智能体发起的转交请求
+type handoffRequest struct {
+	Target  AgentProfile
+	Reason  string
+	Context string
+}
+
+// Run 执行一次多智能体编排
+func (o *Orchestrator) Run(ctx context.Context, req OrchestrationRequest) (*OrchestrationResult, error) {
+	mode := req.Mode
+	switch mode {
+	case "":
+		mode = OrchestrationSupervisor
+	case OrchestrationSupervisor, OrchestrationHandoff:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOrchestrationMode, mode)
+	}
+
+	candidates, err := o.Candidates(ctx, req.ApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &orchestration{
+		mode:       mode,
+		candidates: candidates,
+		scratchpad: NewScratchpad(req.Scratchpad),
+	}
+
+	current, ok := run.find(req.EntryAgentID)
+	if !ok {
+		return nil, ErrAgentNotInApplication
+	}
+
+	history := req.History
+	input := req.Input
+	handoffs := 0
+	for {
+		output, handoff, err := o.runAgent(ctx, run, current, input, history, 0, []uuid.UUID{current.ID})
+		if err != nil {
+			return nil, err
+		}
+
+		if handoff == nil {
+			return &OrchestrationResult{
+				Output:       output,
+				FinalAgentID: current.ID.String(),
+				Handoffs:     handoffs,
+				AgentCalls:   run.agentCalls,
+				Scratchpad:   run.scratchpad.Snapshot(),
+				Trace:        run.trace,
+			}, nil
+		}
+
+		if handoffs >= o.config.MaxHandoffs {
+			return nil, ErrMaxHandoffsExceeded
+		}
+		handoffs++
+
+		run.record(current, 0, TraceHandoff, map[string]interface{}{
+			"to_agent_id":   handoff.Target.ID.String(),
+			"to_agent_name": handoff.Target.Name,
+			"reason":        handoff.Reason,
+			"context":       handoff.Context,
+		})
+
+		// 目标智能体继承累积的对话记录，包括本轮输入和转出智能体的回复，并以转交说明作为新的输入，
+		// 不再重复处理用户的原始输入
+		transferred := make([]eino.Message, 0, len(history)+2)
+		transferred = append(transferred, history...)
+		transferred = append(transferred, eino.Message{Role: eino.RoleUser, Content: input})
+		if output != "" {
+			transferred = append(transferred, eino.Message{Role: eino.RoleAssistant, Content: output})
+		}
+		history = transferred
+		input = handoffNote(current, handoff)
+		current = handoff.Target
+	}
+}
+
+// runAgent 加载并运行单个智能体，返回其回复以及可能的转交请求
+func (o *Orchestrator) runAgent(ctx context.Context, run *orchestration, profile AgentProfile, input string, history []eino.Message, depth int, stack []uuid.UUID) (string, *handoffRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	if !run.countCall(o.config.MaxAgentCalls) {
+		return "", nil, ErrMaxAgentCallsExceeded
+	}
+
+	var handoff *handoffRequest
+	tools := scratchpadTools(run.scratchpad)
+	switch run.mode {
+	case OrchestrationSupervisor:
+		// 达到深度上限或没有可委派对象时不再提供委派工具，调用链中的智能体不能被再次委派以避免循环
+		if depth < o.config.MaxDepth {
+			if targets := run.targets(stack); len(targets) > 0 {
+				tools = append(tools, o.delegateTool(run, profile, targets, depth, stack))
+			}
+		}
+	case OrchestrationHandoff:
+		if targets := run.targets([]uuid.UUID{profile.ID}); len(targets) > 0 {
+			tools = append(tools, handoffTool(targets, &handoff))
+		}
+	}
+
+	agent, err := o.loader.LoadWithTools(ctx, profile.ID, tools...)
+	if err != nil {
+		return "", nil, err
+	}
+	agent.AddCallback(run.recorder(profile, depth))
+
+	for _, msg := range history {
+		if err := agent.Memory.AddMessage(msg); err != nil {
+			return "", nil, fmt.Errorf("failed to seed agent memory: %w", err)
+		}
+	}
+
+	output, err := agent.Chat(ctx, input)
+	if err != nil {
+		return "", nil, err
+	}
+	return output, handoff, nil
+}
+
+// delegateTool 构建把任务委派给其他智能体的工具
+func (o *Orchestrator) delegateTool(run *orchestration, caller AgentProfile, targets []AgentProfile, depth int, stack []uuid.UUID) Tool {
+	return Tool{
+		Name:        delegateToolName,
+		Description: "将子任务委派给其他专业智能体并获取其回答。可用智能体：\n" + describeAgents(targets),
+		Category:    CategoryUtility,
+		Version:     "1.0",
+		Parameters: map[string]interface{}{
+			"agent_id": map[string]interface{}{
+				"type":        "string",
+				"description": "被委派的智能体ID",
+				"enum":        agentIDs(targets),
+			},
+			"task": map[string]interface{}{
+				"type":        "string",
+				"description": "交给该智能体完成的任务，需包含必要的上下文",
+			},
+		},
+		Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			target, err := targetFromParams(targets, params)
+			if err != nil {
+				return nil, err
+			}
+			task, _ := params["task"].(string)
+			if task == "" {
+				return nil, errors.New("task parameter must be a non-empty string")
+			}
+
+			run.record(caller, depth, TraceDelegate, map[string]interface{}{
+				"to_agent_id":   target.ID.String(),
+				"to_agent_name": target.Name,
+				"task":          task,
+			})
+
+			// 复制调用链，避免并发的工具调用共享底层数组
+			childStack := make([]uuid.UUID, len(stack), len(stack)+1)
+			copy(childStack, stack)
+			childStack = append(childStack, target.ID)
+
+			output, _, err := o.runAgent(ctx, run, target, task, nil, depth+1, childStack)
+			if err != nil {
+				run.record(target, depth+1, TraceDelegateResult, map[string]interface{}{"error": err.Error()})
+				return nil, err
+			}
+			run.record(target, depth+1, TraceDelegateResult, map[string]interface{}{"output": output})
+
+			return map[string]interface{}{
+				"agent_id":   target.ID.String(),
+				"agent_name": target.Name,
+				"output":     output,
+			}, nil
+		},
+	}
+}
+
+// handoffTool 构建把对话转交给其他智能体的工具，转交在当前智能体回复结束后生效
+func handoffTool(targets []AgentProfile, handoff **handoffRequest) Tool {
+	return Tool{
+		Name:        handoffToolName,
+		Description: "当其他智能体更适合处理用户的请求时，将对话转交给它。可用智能体：\n" + describeAgents(targets),
+		Category:    CategoryUtility,
+		Version:     "1.0",
+		Parameters: map[string]interface{}{
+			"agent_id": map[string]interface{}{
+				"type":        "string",
+				"description": "接手对话的智能体ID",
+				"enum":        agentIDs(targets),
+			},
+			"reason": map[string]interface{}{
+				"type":        "string",
+				"description": "转交原因",
+			},
+			"context": map[string]interface{}{
+				"type":        "string",
+				"description": "接手的智能体需要了解的上下文摘要",
+			},
+		},
+		Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			if *handoff != nil {
+				return nil, errors.New("conversation has already been handed off")
+			}
+			target, err := targetFromParams(targets, params)
+			if err != nil {
+				return nil, err
+			}
+			reason, _ := params["reason"].(string)
+			summary, _ := params["context"].(string)
+
+			*handoff = &handoffRequest{Target: target, Reason: reason, Context: summary}
+			return map[string]interface{}{
+				"status":     "handoff_accepted",
+				"agent_name": target.Name,
+			}, nil
+		},
+	}
+}
+
+// scratchpadTools 构建读写共享草稿板的工具
+func scratchpadTools(scratchpad *Scratchpad) []Tool {
+	return []Tool{
+		{
+			Name:        scratchpadReadToolName,
+			Description: "读取多个智能体共享的草稿板。不传key时返回全部内容",
+			Category:    CategoryUtility,
+			Version:     "1.0",
+			Parameters: map[string]interface{}{
+				"key": map[string]interface{}{
+					"type":        "string",
+					"description": "要读取的条目名称",
+				},
+			},
+			Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+				key, _ := params["key"].(string)
+				if key == "" {
+					return scratchpad.Snapshot(), nil
+				}
+				value, ok := scratchpad.Read(key)
+				return map[string]interface{}{
+					"key":    key,
+					"value":  value,
+					"exists": ok,
+				}, nil
+			},
+		},
+		{
+			Name:        scratchpadWriteToolName,
+			Description: "向多个智能体共享的草稿板写入条目，用于记录中间结论供其他智能体使用",
+			Category:    CategoryUtility,
+			Version:     "1.0",
+			Parameters: map[string]interface{}{
+				"key": map[string]interface{}{
+					"type":        "string",
+					"description": "条目名称",
+				},
+				"value": map[string]interface{}{
+					"type":        "string",
+					"description": "条目内容",
+				},
+			},
+			Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+				key, _ := params["key"].(string)
+				if key == "" {
+					return nil, errors.New("key parameter must be a non-empty string")
+				}
+				scratchpad.Write(key, params["value"])
+				return map[string]interface{}{"key": key, "written": true}, nil
+			},
+		},
+	}
+}
+
+// find 在候选智能体中查找指定ID
+func (r *orchestration) find(id uuid.UUID) (AgentProfile, bool) {
+	for _, candidate := range r.candidates {
+		if candidate.ID == id {
+			return candidate, true
+		}
+	}
+	return AgentProfile{}, false
+}
+
+// targets 返回排除指定智能体后的候选列表
+func (r *orchestration) targets(exclude []uuid.UUID) []AgentProfile {
+	var targets []AgentProfile
+	for _, candidate := range r.candidates {
+		excluded := false
+		for _, id := range exclude {
+			if candidate.ID == id {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			targets = append(targets, candidate)
+		}
+	}
+	return targets
+}
+
+// countCall 计入一次智能体运行，超过上限时返回false
+func (r *orchestration) countCall(limit int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.agentCalls >= limit {
+		return false
+	}
+	r.agentCalls++
+	return true
+}
+
+// record 追加合并跟踪记录
+func (r *orchestration) record(profile AgentProfile, depth int, entryType string, data interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.trace = append(r.trace, OrchestrationTraceEntry{
+		Sequence:  len(r.trace) + 1,
+		AgentID:   profile.ID.String(),
+		AgentName: profile.Name,
+		Depth:     depth,
+		Type:      entryType,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Data:      data,
+	})
+}
+
+// recorder 把智能体的运行时事件写入合并跟踪，流式token不记录
+func (r *orchestration) recorder(profile AgentProfile, depth int) AgentRuntimeCallback {
+	return func(ctx context.Context, event AgentRuntimeEvent) {
+		if event.Type == EventToken {
+			return
+		}
+		r.record(profile, depth, string(event.Type), event.Data)
+	}
+}
+
+// handoffNote 生成转交时交给目标智能体的输入
+func handoffNote(from AgentProfile, handoff *handoffRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "对话由智能体「%s」转交给你。", from.Name)
+	if handoff.Reason != "" {
+		fmt.Fprintf(&b, "\n转交原因：%s", handoff.Reason)
+	}
+	if handoff.Context != "" {
+		fmt.Fprintf(&b, "\n上下文：%s", handoff.Context)
+	}
+	b.WriteString("\n请根据以上对话记录继续处理用户的请求。")
+	return b.String()
+}
+
+// describeAgents 生成候选智能体列表说明
+func describeAgents(profiles []AgentProfile) string {
+	lines := make([]string, len(profiles))
+	for i, profile := range profiles {
+		lines[i] = fmt.Sprintf("- %s：%s（%s）", profile.ID, profile.Name, profile.Description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// agentIDs 返回候选智能体ID列表
+func agentIDs(profiles []AgentProfile) []string {
+	ids := make([]string, len(profiles))
+	for i, profile := range profiles {
+		ids[i] = profile.ID.String()
+	}
+	return ids
+}
+
+// targetFromParams 从工具参数中解析目标智能体
+func targetFromParams(targets []AgentProfile, params map[string]interface{}) (AgentProfile, error) {
+	raw, _ := params["agent_id"].(string)
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return AgentProfile{}, fmt.Errorf("invalid agent_id: %q", raw)
+	}
+	for _, target := range targets {
+		if target.ID == id {
+			return target, nil
+		}
+	}
+	return AgentProfile{}, fmt.Errorf("agent %s is not available for this call", id)
+}
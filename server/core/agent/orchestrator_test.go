@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+// testProfiles 创建参与编排测试的智能体
+func testProfiles(names ...string) []AgentProfile {
+	profiles := make([]AgentProfile, len(names))
+	for i, name := range names {
+		profiles[i] = AgentProfile{ID: uuid.New(), Name: name}
+	}
+	return profiles
+}
+
+func TestScratchpadTools(t *testing.T) {
+	ctx := context.Background()
+	scratchpad := NewScratchpad(map[string]interface{}{"topic": "pricing"})
+	tools := scratchpadTools(scratchpad)
+	read, write := tools[0].Handler, tools[1].Handler
+
+	if _, err := write(ctx, map[string]interface{}{"value": "x"}); err == nil {
+		t.Error("write without key succeeded, want error")
+	}
+	if _, err := write(ctx, map[string]interface{}{"key": "summary", "value": "done"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := read(ctx, map[string]interface{}{"key": "summary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := got.(map[string]interface{}); entry["value"] != "done" || entry["exists"] != true {
+		t.Errorf("read summary = %v, want value done", entry)
+	}
+	got, _ = read(ctx, map[string]interface{}{"key": "missing"})
+	if entry := got.(map[string]interface{}); entry["exists"] != false {
+		t.Errorf("read missing = %v, want exists false", entry)
+	}
+
+	all, _ := read(ctx, map[string]interface{}{})
+	snapshot := all.(map[string]interface{})
+	if len(snapshot) != 2 || snapshot["topic"] != "pricing" {
+		t.Errorf("read all = %v, want topic and summary", snapshot)
+	}
+	// 快照是副本，修改不影响草稿板
+	snapshot["topic"] = "changed"
+	if value, _ := scratchpad.Read("topic"); value != "pricing" {
+		t.Errorf("scratchpad topic = %v after modifying snapshot, want pricing", value)
+	}
+}
+
+func TestHandoffTool(t *testing.T) {
+	ctx := context.Background()
+	targets := testProfiles("billing", "support")
+
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		wantErr bool
+	}{
+		{"invalid agent id", map[string]interface{}{"agent_id": "billing"}, true},
+		{"agent outside targets", map[string]interface{}{"agent_id": uuid.NewString()}, true},
+		{"valid target", map[string]interface{}{"agent_id": targets[1].ID.String(), "reason": "refund", "context": "order 42"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handoff *handoffRequest
+			_, err := handoffTool(targets, &handoff).Handler(ctx, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handoff error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if handoff != nil {
+					t.Errorf("failed handoff recorded target %s", handoff.Target.Name)
+				}
+				return
+			}
+			if handoff == nil || handoff.Target.ID != targets[1].ID || handoff.Reason != "refund" || handoff.Context != "order 42" {
+				t.Errorf("handoff = %+v, want support with reason and context", handoff)
+			}
+		})
+	}
+
+	// 一次回复只能转交一次
+	var handoff *handoffRequest
+	tool := handoffTool(targets, &handoff)
+	if _, err := tool.Handler(ctx, map[string]interface{}{"agent_id": targets[0].ID.String()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tool.Handler(ctx, map[string]interface{}{"agent_id": targets[1].ID.String()}); err == nil {
+		t.Error("second handoff succeeded, want error")
+	}
+	if handoff.Target.ID != targets[0].ID {
+		t.Errorf("handoff target = %s, want first target", handoff.Target.Name)
+	}
+}
+
+func TestDelegateToolLimits(t *testing.T) {
+	profiles := testProfiles("supervisor", "researcher")
+	supervisor, researcher := profiles[0], profiles[1]
+	orchestrator := NewOrchestrator(nil, OrchestratorConfig{MaxAgentCalls: 1})
+
+	newRun := func() *orchestration {
+		return &orchestration{mode: OrchestrationSupervisor, candidates: profiles, scratchpad: NewScratchpad(nil)}
+	}
+	valid := map[string]interface{}{"agent_id": researcher.ID.String(), "task": "find sources"}
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		run := newRun()
+		tool := orchestrator.delegateTool(run, supervisor, []AgentProfile{researcher}, 0, []uuid.UUID{supervisor.ID})
+		for _, params := range []map[string]interface{}{
+			{"agent_id": supervisor.ID.String(), "task": "loop"}, // 调用链中的智能体不在可委派列表中
+			{"agent_id": researcher.ID.String()},
+		} {
+			if _, err := tool.Handler(context.Background(), params); err == nil {
+				t.Errorf("delegate %v succeeded, want error", params)
+			}
+		}
+		if len(run.trace) != 0 || run.agentCalls != 0 {
+			t.Errorf("rejected delegation recorded trace %v and %d calls", run.trace, run.agentCalls)
+		}
+	})
+
+	t.Run("agent call limit", func(t *testing.T) {
+		run := newRun()
+		run.agentCalls = 1 // 入口智能体已用完次数
+		tool := orchestrator.delegateTool(run, supervisor, []AgentProfile{researcher}, 0, []uuid.UUID{supervisor.ID})
+		if _, err := tool.Handler(context.Background(), valid); !errors.Is(err, ErrMaxAgentCallsExceeded) {
+			t.Fatalf("delegate error = %v, want %v", err, ErrMaxAgentCallsExceeded)
+		}
+		if len(run.trace) != 2 || run.trace[0].Type != TraceDelegate || run.trace[1].Type != TraceDelegateResult {
+			t.Fatalf("trace = %+v, want delegate and delegate_result", run.trace)
+		}
+		if run.trace[1].Depth != 1 || run.trace[1].AgentID != researcher.ID.String() {
+			t.Errorf("result entry = %+v, want researcher at depth 1", run.trace[1])
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		run := newRun()
+		tool := orchestrator.delegateTool(run, supervisor, []AgentProfile{researcher}, 0, []uuid.UUID{supervisor.ID})
+		if _, err := tool.Handler(ctx, valid); !errors.Is(err, context.Canceled) {
+			t.Errorf("delegate error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestOrchestrationTargets(t *testing.T) {
+	profiles := testProfiles("a", "b", "c")
+	run := &orchestration{candidates: profiles}
+
+	targets := run.targets([]uuid.UUID{profiles[0].ID, profiles[2].ID})
+	if len(targets) != 1 || targets[0].ID != profiles[1].ID {
+		t.Errorf("targets = %+v, want only b", targets)
+	}
+	if _, ok := run.find(uuid.New()); ok {
+		t.Error("find returned an agent outside the candidates")
+	}
+
+	for i := 0; i < 2; i++ {
+		if !run.countCall(2) {
+			t.Fatalf("call %d rejected below limit", i+1)
+		}
+	}
+	if run.countCall(2) {
+		t.Error("call above limit accepted")
+	}
+}
+
+func TestHandoffNote(t *testing.T) {
+	from := AgentProfile{Name: "triage"}
+	note := handoffNote(from, &handoffRequest{Reason: "billing question", Context: "order 42"})
+	for _, want := range []string{"triage", "billing question", "order 42"} {
+		if !strings.Contains(note, want) {
+			t.Errorf("note %q does not mention %q", note, want)
+		}
+	}
+	if note := handoffNote(from, &handoffRequest{}); strings.Contains(note, "转交原因") || strings.Contains(note, "上下文") {
+		t.Errorf("note without reason or context = %q", note)
+	}
+}
+
+func TestOrchestratorRunRejectsRequest(t *testing.T) {
+	if _, err := NewOrchestrator(nil, OrchestratorConfig{}).Run(context.Background(), OrchestrationRequest{
+		Mode: "round_robin",
+	}); !errors.Is(err, ErrUnknownOrchestrationMode) {
+		t.Errorf("Run with unknown mode error = %v, want %v", err, ErrUnknownOrchestrationMode)
+	}
+
+	db := openTestDB(t)
+	applicationID := uuid.New()
+	member := models.Agent{Name: "member", ApplicationID: applicationID, ModelConfigID: uuid.New()}
+	outsider := models.Agent{Name: "outsider", ApplicationID: uuid.New(), ModelConfigID: uuid.New()}
+	for _, agent := range []*models.Agent{&member, &outsider} {
+		if err := db.Create(agent).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orchestrator := NewOrchestrator(NewAgentLoader(db, nil, nil), OrchestratorConfig{})
+	candidates, err := orchestrator.Candidates(context.Background(), applicationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].ID != member.ID {
+		t.Errorf("candidates = %+v, want only the application's agent", candidates)
+	}
+
+	for _, mode := range []OrchestrationMode{OrchestrationSupervisor, OrchestrationHandoff} {
+		if _, err := orchestrator.Run(context.Background(), OrchestrationRequest{
+			ApplicationID: applicationID,
+			EntryAgentID:  outsider.ID,
+			Mode:          mode,
+			Input:         "hello",
+		}); !errors.Is(err, ErrAgentNotInApplication) {
+			t.Errorf("%s run with outside entry agent error = %v, want %v", mode, err, ErrAgentNotInApplication)
+		}
+	}
+}
@@ -0,0 +1,767 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RunStatus 表示异步运行的状态
+type RunStatus string
+
+const (
+	RunStatusQueued          RunStatus = "queued"           // 等待执行
+	RunStatusRunning         RunStatus = "running"          // 执行中
+	RunStatusWaitingApproval RunStatus = "waiting_approval" // 等待工具调用审批
+	RunStatusCompleted       RunStatus = "completed"        // 已完成
+	RunStatusFailed          RunStatus = "failed"           // 执行失败
+	RunStatusCancelled       RunStatus = "cancelled"        // 已取消
+)
+
+// IsTerminal 判断状态是否为终止状态
+func (s RunStatus) IsTerminal() bool {
+	return s == RunStatusCompleted || s == RunStatusFailed || s == RunStatusCancelled
+}
+
+var (
+	ErrRunNotFound   = errors.New("agent run not found")
+	ErrRunNotPending = errors.New("agent run is not waiting for approval")
+	ErrRunFinished   = errors.New("agent run has already finished")
+
+	// errLeaseLost 表示运行已被回收并由其他工作协程认领，当前执行不能再更新运行
+	errLeaseLost = errors.New("agent run lease lost")
+)
+
+// RunManagerConfig 运行管理器配置
+type RunManagerConfig struct {
+	Workers           int           // 工作协程数量
+	QueueSize         int           // 内存队列长度，队列满时依赖轮询拾取
+	PollInterval      time.Duration // 轮询数据库中排队运行的间隔
+	HeartbeatInterval time.Duration // 执行中运行的心跳间隔
+	LeaseTimeout      time.Duration // 心跳超过该时间未刷新的运行将被重新排队
+	MaxAttempts       int           // 最大执行次数
+}
+
+// DefaultRunManagerConfig 返回默认的运行管理器配置
+func DefaultRunManagerConfig() RunManagerConfig {
+	return RunManagerConfig{
+		Workers:           4,
+		QueueSize:         256,
+		PollInterval:      5 * time.Second,
+		HeartbeatInterval: 10 * time.Second,
+		LeaseTimeout:      time.Minute,
+		MaxAttempts:       3,
+	}
+}
+
+// RunManager 使用工作协程池异步执行智能体运行，运行状态和步骤持久化在数据库中
+type RunManager struct {
+	db            *gorm.DB
+	loader        *AgentLoader
+	approvalStore ApprovalStore
+	config        RunManagerConfig
+	queue         chan uuid.UUID
+	cancels       map[uuid.UUID]context.CancelFunc
+	mu            sync.Mutex
+	ctx           context.Context
+	stop          context.CancelFunc
+	wg            sync.WaitGroup
+	logger        *zap.Logger
+}
+
+// NewRunManager 创建运行管理器
+func NewRunManager(db *gorm.DB, loader *AgentLoader, config RunManagerConfig) *RunManager {
+	defaults := DefaultRunManagerConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if config.LeaseTimeout <= 0 {
+		config.LeaseTimeout = defaults.LeaseTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+
+	return &RunManager{
+		db:            db,
+		loader:        loader,
+		approvalStore: NewGormApprovalStore(db),
+		config:        config,
+		queue:         make(chan uuid.UUID, config.QueueSize),
+		cancels:       make(map[uuid.UUID]context.CancelFunc),
+		logger:        zap.L().With(zap.String("component", "run_manager")),
+	}
+}
+
+// Start 启动工作协程和轮询协程
+func (m *RunManager) Start() {
+	m.ctx, m.stop = context.WithCancel(context.Background())
+
+	for i := 0; i < m.config.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+
+	m.wg.Add(1)
+	go m.poll()
+}
+
+// Stop 停止所有工作协程，执行中的运行会在下次启动时因心跳超时重新排队
+func (m *RunManager) Stop() {
+	if m.stop == nil {
+		return
+	}
+	m.stop()
+	m.wg.Wait()
+}
+
+// Submit 创建并排队一次运行。运行关联对话且 guard 非空时，先在锁定对话的事务中执行检查，检查失败则不创建运行
+func (m *RunManager) Submit(ctx context.Context, run *models.AgentRun, guard MessageGuard) error {
+	run.Status = string(RunStatusQueued)
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if run.ConversationID != nil && guard != nil {
+			var conversation models.Conversation
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id").
+				First(&conversation, "id = ?", *run.ConversationID).Error; err != nil {
+				return err
+			}
+			if err := guard(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Create(run).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	m.enqueue(run.ID)
+	return nil
+}
+
+// Cancel 取消一次运行
+func (m *RunManager) Cancel(ctx context.Context, runID uuid.UUID) error {
+	now := time.Now()
+	result := m.db.WithContext(ctx).Model(&models.AgentRun{}).
+		Where("id = ? AND status IN ?", runID, []string{
+			string(RunStatusQueued), string(RunStatusRunning), string(RunStatusWaitingApproval),
+		}).
+		Updates(map[string]interface{}{
+			"status":      string(RunStatusCancelled),
+			"finished_at": &now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRunFinished
+	}
+
+	// 本实例上执行中的运行立即中断，其他实例会在心跳时发现状态变化
+	m.mu.Lock()
+	if cancel, ok := m.cancels[runID]; ok {
+		cancel()
+	}
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Resume 提交审批决定并重新排队等待审批的运行
+func (m *RunManager) Resume(ctx context.Context, runID uuid.UUID, decisions []ApprovalDecision) error {
+	var run models.AgentRun
+	if err := m.db.WithContext(ctx).First(&run, "id = ?", runID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRunNotFound
+		}
+		return err
+	}
+	if RunStatus(run.Status) != RunStatusWaitingApproval {
+		return ErrRunNotPending
+	}
+	// 提前校验决定，避免无效决定在运行恢复时才导致运行失败
+	if run.ApprovalID != nil {
+		approval, err := m.approvalStore.Get(ctx, run.ApprovalID.String())
+		if err != nil {
+			return err
+		}
+		if _, err := validateDecisions(approval.Pending, decisions); err != nil {
+			return err
+		}
+	}
+
+	state := run.State
+	if state == nil {
+		state = models.JSONMap{}
+	}
+	decisionData, err := toJSONValue(decisions)
+	if err != nil {
+		return err
+	}
+	// 决定只对当前的审批请求有效，恢复后再次暂停时不会被误用
+	state[stateDecisions] = decisionData
+	if run.ApprovalID != nil {
+		state[stateDecisionsApproval] = run.ApprovalID.String()
+	}
+
+	result := m.db.WithContext(ctx).Model(&models.AgentRun{}).
+		Where("id = ? AND status = ?", runID, string(RunStatusWaitingApproval)).
+		Updates(map[string]interface{}{
+			"status": string(RunStatusQueued),
+			"state":  state,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRunNotPending
+	}
+
+	m.enqueue(runID)
+	return nil
+}
+
+// enqueue 将运行放入内存队列，队列已满时由轮询协程拾取
+func (m *RunManager) enqueue(runID uuid.UUID) {
+	select {
+	case m.queue <- runID:
+	default:
+		m.logger.Warn("Run queue is full, run will be picked up by poller", zap.String("run_id", runID.String()))
+	}
+}
+
+// worker 从队列中取出运行并执行
+func (m *RunManager) worker() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case runID := <-m.queue:
+			m.execute(runID)
+		}
+	}
+}
+
+// poll 定期回收心跳超时的运行并拾取排队中的运行
+func (m *RunManager) poll() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.recoverStaleRuns()
+		m.enqueueQueuedRuns()
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recoverStaleRuns 将失联的运行重新排队，超过最大执行次数则标记为失败
+func (m *RunManager) recoverStaleRuns() {
+	deadline := time.Now().Add(-m.config.LeaseTimeout)
+	now := time.Now()
+
+	if err := m.db.Model(&models.AgentRun{}).
+		Where("status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?) AND attempts >= ?",
+			string(RunStatusRunning), deadline, m.config.MaxAttempts).
+		Updates(map[string]interface{}{
+			"status":      string(RunStatusFailed),
+			"error":       "run exceeded maximum attempts",
+			"finished_at": &now,
+		}).Error; err != nil {
+		m.logger.Error("Failed to fail stale runs", zap.Error(err))
+	}
+
+	if err := m.db.Model(&models.AgentRun{}).
+		Where("status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", string(RunStatusRunning), deadline).
+		Update("status", string(RunStatusQueued)).Error; err != nil {
+		m.logger.Error("Failed to requeue stale runs", zap.Error(err))
+	}
+}
+
+// enqueueQueuedRuns 将数据库中排队的运行放入内存队列
+func (m *RunManager) enqueueQueuedRuns() {
+	var ids []uuid.UUID
+	if err := m.db.Model(&models.AgentRun{}).
+		Where("status = ?", string(RunStatusQueued)).
+		Order("created_at asc").
+		Limit(m.config.QueueSize).
+		Pluck("id", &ids).Error; err != nil {
+		m.logger.Error("Failed to find queued runs", zap.Error(err))
+		return
+	}
+
+	for _, id := range ids {
+		m.enqueue(id)
+	}
+}
+
+// claim 以条件更新的方式认领运行并生成新的租约，保证同一运行只被一个工作协程执行
+func (m *RunManager) claim(runID uuid.UUID) (uuid.UUID, bool, error) {
+	lease := uuid.New()
+	now := time.Now()
+	result := m.db.Model(&models.AgentRun{}).
+		Where("id = ? AND status = ?", runID, string(RunStatusQueued)).
+		Updates(map[string]interface{}{
+			"status":       string(RunStatusRunning),
+			"lease_id":     lease,
+			"heartbeat_at": &now,
+			"started_at":   gorm.Expr("COALESCE(started_at, ?)", now),
+			"attempts":     gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		return uuid.Nil, false, result.Error
+	}
+	return lease, result.RowsAffected > 0, nil
+}
+
+// leased 返回只匹配当前租约下执行中运行的更新，运行被取消或被其他工作协程重新认领后不再生效
+func (m *RunManager) leased(run *models.AgentRun) *gorm.DB {
+	return m.db.Model(&models.AgentRun{}).
+		Where("id = ? AND status = ? AND lease_id = ?", run.ID, string(RunStatusRunning), run.LeaseID)
+}
+
+// execute 执行一次运行
+func (m *RunManager) execute(runID uuid.UUID) {
+	lease, claimed, err := m.claim(runID)
+	if err != nil {
+		m.logger.Error("Failed to claim run", zap.Error(err), zap.String("run_id", runID.String()))
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var run models.AgentRun
+	if err := m.db.Preload("Agent").First(&run, "id = ?", runID).Error; err != nil {
+		m.logger.Error("Failed to load run", zap.Error(err), zap.String("run_id", runID.String()))
+		return
+	}
+	// 加载前运行已被回收并由其他工作协程认领
+	if run.LeaseID == nil || *run.LeaseID != lease {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	if run.ConversationID != nil {
+		ctx = WithConversationID(ctx, run.ConversationID.String())
+	}
+
+	m.mu.Lock()
+	m.cancels[runID] = cancel
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.cancels, runID)
+		m.mu.Unlock()
+	}()
+
+	go m.heartbeat(ctx, cancel, &run)
+
+	agent, err := m.loader.Load(ctx, run.AgentID)
+	if err != nil {
+		m.finish(&run, RunStatusFailed, "", err, nil)
+		return
+	}
+	agent.SetApprovalStore(m.approvalStore)
+	agent.AddCallback(m.stepRecorder(runID))
+
+	decisions, resuming, err := decisionsFromState(run.State, run.ApprovalID)
+	if err != nil {
+		m.finish(&run, RunStatusFailed, "", err, nil)
+		return
+	}
+
+	memory, err := m.runMemory(ctx, &run, agent, resuming)
+	if err != nil {
+		m.finish(&run, RunStatusFailed, "", err, nil)
+		return
+	}
+	agent.SetMemory(memory)
+	m.loader.AttachLongTermMemory(agent, run.Agent, run.UserID)
+
+	var content string
+	if resuming {
+		content, err = m.resume(ctx, cancel, &run, agent, decisions)
+	} else {
+		content, err = agent.Chat(ctx, run.Input)
+	}
+
+	var approvalErr *ApprovalRequiredError
+	switch {
+	case errors.As(err, &approvalErr):
+		m.pause(&run, approvalErr, memory)
+	case err != nil && ctx.Err() != nil:
+		// 运行被取消或服务正在关闭，状态已由 Cancel 更新或将由心跳回收
+		m.logger.Info("Run interrupted", zap.String("run_id", runID.String()))
+	case err != nil:
+		m.finish(&run, RunStatusFailed, "", err, memory)
+	default:
+		m.finish(&run, RunStatusCompleted, content, nil, memory)
+	}
+}
+
+// resume 按审批决定恢复运行。工具结果先持久化在运行状态中，被回收重试时直接使用，
+// 不会再次处理已完成的审批或重复执行工具
+func (m *RunManager) resume(ctx context.Context, cancel context.CancelFunc, run *models.AgentRun, agent *Agent, decisions []ApprovalDecision) (string, error) {
+	approvalID := run.ApprovalID.String()
+	results, applied, err := resultsFromState(run.State, run.ApprovalID)
+	if err != nil {
+		return "", err
+	}
+
+	if !applied {
+		if results, err = agent.ApplyToolDecisions(ctx, approvalID, decisions); err != nil {
+			return "", err
+		}
+		if err := m.saveToolResults(run, results); err != nil {
+			if errors.Is(err, errLeaseLost) {
+				cancel()
+				return "", err
+			}
+			m.logger.Warn("Failed to save tool results", zap.Error(err), zap.String("run_id", run.ID.String()))
+		}
+	}
+
+	return agent.ContinueToolCalls(ctx, approvalID, results)
+}
+
+// saveToolResults 将审批后得到的工具结果写入运行状态
+func (m *RunManager) saveToolResults(run *models.AgentRun, results []eino.ToolResult) error {
+	data, err := toJSONValue(results)
+	if err != nil {
+		return err
+	}
+
+	state := make(models.JSONMap, len(run.State)+2)
+	for key, value := range run.State {
+		state[key] = value
+	}
+	state[stateToolResults] = data
+	state[stateToolResultsApproval] = run.ApprovalID.String()
+
+	result := m.leased(run).Update("state", state)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errLeaseLost
+	}
+	run.State = state
+	return nil
+}
+
+// heartbeat 定期刷新运行心跳，发现运行已被取消或租约已失效时中断执行
+func (m *RunManager) heartbeat(ctx context.Context, cancel context.CancelFunc, run *models.AgentRun) {
+	ticker := time.NewTicker(m.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result := m.leased(run).Update("heartbeat_at", time.Now())
+			if result.Error != nil {
+				m.logger.Warn("Failed to refresh run heartbeat", zap.Error(result.Error))
+				continue
+			}
+			if result.RowsAffected == 0 {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// stepRecorder 将运行时事件持久化为运行步骤
+func (m *RunManager) stepRecorder(runID uuid.UUID) AgentRuntimeCallback {
+	var mu sync.Mutex
+	var sequence int
+	m.db.Model(&models.AgentRunStep{}).
+		Where("run_id = ?", runID).
+		Select("COALESCE(MAX(sequence), 0)").
+		Scan(&sequence)
+
+	return func(ctx context.Context, event AgentRuntimeEvent) {
+		data, err := toJSONMap(event.Data)
+		if err != nil {
+			data = models.JSONMap{"value": fmt.Sprint(event.Data)}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		sequence++
+		step := models.AgentRunStep{
+			RunID:    runID,
+			Sequence: sequence,
+			Type:     string(event.Type),
+			Data:     data,
+		}
+		if err := m.db.Create(&step).Error; err != nil {
+			m.logger.Warn("Failed to record run step", zap.Error(err), zap.String("run_id", runID.String()))
+		}
+	}
+}
+
+// runMemory 返回运行使用的记忆：关联对话时直接读写消息表，否则从运行状态恢复
+func (m *RunManager) runMemory(ctx context.Context, run *models.AgentRun, agent *Agent, resuming bool) (Memory, error) {
+	if run.ConversationID != nil {
+		// 被回收重试的运行先清理之前未完成的尝试写入的消息，暂停等待审批前写入的消息保留
+		committed := 0
+		if resuming {
+			committed = committedAttempt(run.State)
+		}
+		if run.Attempts > committed+1 {
+			if err := m.discardAttemptMessages(ctx, *run.ConversationID, run.ID, committed); err != nil {
+				return nil, fmt.Errorf("failed to clean up previous attempt: %w", err)
+			}
+		}
+
+		store := NewGormMemory(m.db, *run.ConversationID, 0).
+			WithContext(ctx).
+			WithMetadata(models.JSONMap{"run_id": run.ID.String(), "attempt": run.Attempts})
+		if run.Agent == nil {
+			return store, nil
+		}
+		return NewConversationMemory(store, run.Agent, agent), nil
+	}
+
+	memory := NewSimpleMemory(100)
+	if raw, ok := run.State["messages"]; ok {
+		var messages []eino.Message
+		if err := fromJSONValue(raw, &messages); err != nil {
+			return nil, fmt.Errorf("failed to decode run messages: %w", err)
+		}
+		for _, msg := range messages {
+			memory.AddMessage(msg)
+		}
+	}
+	return memory, nil
+}
+
+// pause 记录等待审批的运行
+func (m *RunManager) pause(run *models.AgentRun, approvalErr *ApprovalRequiredError, memory Memory) {
+	approvalID, err := uuid.Parse(approvalErr.ApprovalID)
+	if err != nil {
+		m.finish(run, RunStatusFailed, "", err, memory)
+		return
+	}
+
+	state, err := runState(memory)
+	if err != nil {
+		m.finish(run, RunStatusFailed, "", err, memory)
+		return
+	}
+	// 本次尝试到暂停为止写入的消息已提交，恢复后重试时不会被清理
+	state[stateCommittedAttempt] = run.Attempts
+
+	result := m.leased(run).
+		Updates(map[string]interface{}{
+			"status":      string(RunStatusWaitingApproval),
+			"approval_id": approvalID,
+			"state":       state,
+		})
+	if result.Error != nil {
+		m.logger.Error("Failed to pause run", zap.Error(result.Error), zap.String("run_id", run.ID.String()))
+		return
+	}
+	if result.RowsAffected == 0 {
+		// 运行已由其他工作协程接管，关闭本次执行产生的审批，避免对话一直处于等待审批状态
+		m.logger.Warn("Run lease lost before pausing", zap.String("run_id", run.ID.String()))
+		if err := m.approvalStore.Resolve(context.Background(), approvalErr.ApprovalID, nil); err != nil {
+			m.logger.Warn("Failed to close orphaned approval", zap.Error(err), zap.String("approval_id", approvalErr.ApprovalID))
+		}
+	}
+}
+
+// finish 记录运行的最终结果，关联对话的消息已由记忆写入消息表
+func (m *RunManager) finish(run *models.AgentRun, status RunStatus, output string, runErr error, memory Memory) {
+	updates := map[string]interface{}{
+		"status":      string(status),
+		"output":      output,
+		"finished_at": time.Now(),
+	}
+	if runErr != nil {
+		updates["error"] = runErr.Error()
+	}
+	// 运行结束后不再保留已消费的审批决定
+	updates["state"] = withoutDecisions(run.State)
+	if memory != nil {
+		if state, err := runState(memory); err == nil {
+			updates["state"] = state
+		}
+	}
+
+	result := m.leased(run).Updates(updates)
+	if result.Error != nil {
+		m.logger.Error("Failed to finish run", zap.Error(result.Error), zap.String("run_id", run.ID.String()))
+		return
+	}
+	if result.RowsAffected == 0 {
+		m.logger.Warn("Run lease lost before finishing", zap.String("run_id", run.ID.String()))
+	}
+}
+
+// discardAttemptMessages 删除运行在 afterAttempt 之后的尝试中写入对话的消息，并将当前分支退回到这些消息之前
+func (m *RunManager) discardAttemptMessages(ctx context.Context, conversationID, runID uuid.UUID, afterAttempt int) error {
+	attemptMessages := func(db *gorm.DB) *gorm.DB {
+		return db.Where("conversation_id = ? AND metadata->>'run_id' = ? AND COALESCE(CAST(metadata->>'attempt' AS INTEGER), 0) > ?",
+			conversationID, runID.String(), afterAttempt)
+	}
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var first models.Message
+		err := tx.Scopes(attemptMessages).Order("sequence ASC").First(&first).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Conversation{}).
+			Where("id = ?", conversationID).
+			Update("active_leaf_id", first.ParentID).Error; err != nil {
+			return err
+		}
+		return tx.Scopes(attemptMessages).Delete(&models.Message{}).Error
+	})
+}
+
+// runState 序列化运行的消息历史
+func runState(memory Memory) (models.JSONMap, error) {
+	// 对话记忆已持久化在消息表中，无需重复保存
+	if _, ok := memory.(ConversationMemory); ok {
+		return models.JSONMap{}, nil
+	}
+
+	messages, err := memory.GetMessages()
+	if err != nil {
+		return nil, err
+	}
+	data, err := toJSONValue(messages)
+	if err != nil {
+		return nil, err
+	}
+	return models.JSONMap{"messages": data}, nil
+}
+
+// 运行状态中保存审批决定和恢复进度的键
+const (
+	stateDecisions           = "decisions"
+	stateDecisionsApproval   = "decisions_approval_id"    // 决定所属的审批请求
+	stateToolResults         = "tool_results"             // 按决定执行后得到的工具结果
+	stateToolResultsApproval = "tool_results_approval_id" // 工具结果所属的审批请求
+	stateCommittedAttempt    = "committed_attempt"        // 最近一次暂停时的执行次数，此前写入的消息已提交
+)
+
+// decisionsFromState 读取运行状态中针对当前审批请求的审批决定
+func decisionsFromState(state models.JSONMap, approvalID *uuid.UUID) ([]ApprovalDecision, bool, error) {
+	raw, ok := state[stateDecisions]
+	if !ok || approvalID == nil {
+		return nil, false, nil
+	}
+	if owner, _ := state[stateDecisionsApproval].(string); owner != approvalID.String() {
+		return nil, false, nil
+	}
+
+	var decisions []ApprovalDecision
+	if err := fromJSONValue(raw, &decisions); err != nil {
+		return nil, false, fmt.Errorf("failed to decode approval decisions: %w", err)
+	}
+	return decisions, true, nil
+}
+
+// resultsFromState 读取运行状态中针对当前审批请求已得到的工具结果
+func resultsFromState(state models.JSONMap, approvalID *uuid.UUID) ([]eino.ToolResult, bool, error) {
+	raw, ok := state[stateToolResults]
+	if !ok || approvalID == nil {
+		return nil, false, nil
+	}
+	if owner, _ := state[stateToolResultsApproval].(string); owner != approvalID.String() {
+		return nil, false, nil
+	}
+
+	var results []eino.ToolResult
+	if err := fromJSONValue(raw, &results); err != nil {
+		return nil, false, fmt.Errorf("failed to decode tool results: %w", err)
+	}
+	return results, true, nil
+}
+
+// committedAttempt 返回最近一次暂停时的执行次数
+func committedAttempt(state models.JSONMap) int {
+	switch attempt := state[stateCommittedAttempt].(type) {
+	case float64:
+		return int(attempt)
+	case int:
+		return attempt
+	}
+	return 0
+}
+
+// withoutDecisions 返回去掉审批决定和恢复进度的运行状态
+func withoutDecisions(state models.JSONMap) models.JSONMap {
+	out := make(models.JSONMap, len(state))
+	for key, value := range state {
+		switch key {
+		case stateDecisions, stateDecisionsApproval, stateToolResults, stateToolResultsApproval, stateCommittedAttempt:
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// toJSONValue 将任意值转换为可存入JSONMap的通用JSON值
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// fromJSONValue 将通用JSON值解码到目标类型
+func fromJSONValue(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
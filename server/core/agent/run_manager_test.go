@@ -0,0 +1,356 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"gorm.io/gorm"
+)
+
+func TestRunStateHelpers(t *testing.T) {
+	approvalID := uuid.New()
+	other := uuid.New()
+	decisionData, err := toJSONValue([]ApprovalDecision{{ToolCallID: "c1", Action: ApprovalApprove}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultData, err := toJSONValue([]eino.ToolResult{{ToolCallID: "c1", Content: "ok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与从数据库读出的状态一致，数字为 float64
+	state := models.JSONMap{
+		"messages":               []interface{}{},
+		stateDecisions:           decisionData,
+		stateDecisionsApproval:   approvalID.String(),
+		stateToolResults:         resultData,
+		stateToolResultsApproval: approvalID.String(),
+		stateCommittedAttempt:    float64(2),
+	}
+
+	decisions, resuming, err := decisionsFromState(state, &approvalID)
+	if err != nil || !resuming || len(decisions) != 1 || decisions[0].ToolCallID != "c1" {
+		t.Errorf("decisionsFromState = %+v, %v, %v", decisions, resuming, err)
+	}
+	if _, resuming, _ := decisionsFromState(state, &other); resuming {
+		t.Error("decisions for another approval were used")
+	}
+
+	results, applied, err := resultsFromState(state, &approvalID)
+	if err != nil || !applied || len(results) != 1 || results[0].ToolCallID != "c1" {
+		t.Errorf("resultsFromState = %+v, %v, %v", results, applied, err)
+	}
+	if _, applied, _ := resultsFromState(state, &other); applied {
+		t.Error("tool results for another approval were used")
+	}
+	if _, applied, _ := resultsFromState(state, nil); applied {
+		t.Error("tool results used without an approval")
+	}
+
+	if got := committedAttempt(state); got != 2 {
+		t.Errorf("committedAttempt = %d, want 2", got)
+	}
+	if got := committedAttempt(models.JSONMap{}); got != 0 {
+		t.Errorf("committedAttempt(empty) = %d, want 0", got)
+	}
+
+	cleaned := withoutDecisions(state)
+	if len(cleaned) != 1 || cleaned["messages"] == nil {
+		t.Errorf("withoutDecisions = %v, want only messages", cleaned)
+	}
+}
+
+// newTestRunManager 创建不启动工作协程的运行管理器，由测试直接驱动各个步骤
+func newTestRunManager(t *testing.T) (*RunManager, *gorm.DB) {
+	t.Helper()
+	db := openTestDB(t)
+	manager := NewRunManager(db, nil, RunManagerConfig{
+		HeartbeatInterval: 10 * time.Millisecond,
+		LeaseTimeout:      time.Minute,
+		MaxAttempts:       3,
+	})
+	manager.approvalStore = NewInMemoryApprovalStore()
+	return manager, db
+}
+
+// createTestRun 创建处于指定状态的运行
+func createTestRun(t *testing.T, db *gorm.DB, status RunStatus, conversationID *uuid.UUID) *models.AgentRun {
+	t.Helper()
+	run := models.AgentRun{
+		AgentID:        uuid.New(),
+		UserID:         uuid.New(),
+		ConversationID: conversationID,
+		Status:         string(status),
+		Input:          "hello",
+	}
+	if err := db.Create(&run).Error; err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	return &run
+}
+
+// claimTestRun 认领运行并返回带有租约的运行记录
+func claimTestRun(t *testing.T, m *RunManager, runID uuid.UUID) *models.AgentRun {
+	t.Helper()
+	lease, claimed, err := m.claim(runID)
+	if err != nil || !claimed {
+		t.Fatalf("claim = %v, %v", claimed, err)
+	}
+	var run models.AgentRun
+	if err := m.db.First(&run, "id = ?", runID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run.LeaseID == nil || *run.LeaseID != lease {
+		t.Fatalf("lease = %v, want %v", run.LeaseID, lease)
+	}
+	return &run
+}
+
+// reloadRun 从数据库重新读取运行
+func reloadRun(t *testing.T, db *gorm.DB, runID uuid.UUID) *models.AgentRun {
+	t.Helper()
+	var run models.AgentRun
+	if err := db.First(&run, "id = ?", runID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &run
+}
+
+func TestRunManagerClaim(t *testing.T) {
+	m, db := newTestRunManager(t)
+	run := createTestRun(t, db, RunStatusQueued, nil)
+
+	claimed := claimTestRun(t, m, run.ID)
+	if RunStatus(claimed.Status) != RunStatusRunning || claimed.Attempts != 1 || claimed.StartedAt == nil {
+		t.Errorf("claimed run = status %s, attempts %d, started %v", claimed.Status, claimed.Attempts, claimed.StartedAt)
+	}
+
+	// 执行中的运行不能再次认领
+	if _, ok, err := m.claim(run.ID); err != nil || ok {
+		t.Errorf("second claim = %v, %v, want not claimed", ok, err)
+	}
+}
+
+func TestRunManagerRecoverStaleRuns(t *testing.T) {
+	m, db := newTestRunManager(t)
+	stale := time.Now().Add(-time.Hour)
+
+	retry := createTestRun(t, db, RunStatusRunning, nil)
+	exhausted := createTestRun(t, db, RunStatusRunning, nil)
+	alive := createTestRun(t, db, RunStatusRunning, nil)
+	waiting := createTestRun(t, db, RunStatusWaitingApproval, nil)
+	db.Model(retry).Updates(map[string]interface{}{"heartbeat_at": stale, "attempts": 1})
+	db.Model(exhausted).Updates(map[string]interface{}{"heartbeat_at": stale, "attempts": 3})
+	db.Model(alive).Updates(map[string]interface{}{"heartbeat_at": time.Now(), "attempts": 1})
+	db.Model(waiting).Updates(map[string]interface{}{"heartbeat_at": stale, "attempts": 1})
+
+	m.recoverStaleRuns()
+
+	tests := []struct {
+		name string
+		run  *models.AgentRun
+		want RunStatus
+	}{
+		{"stale run is requeued", retry, RunStatusQueued},
+		{"run over max attempts fails", exhausted, RunStatusFailed},
+		{"run with fresh heartbeat keeps running", alive, RunStatusRunning},
+		{"run waiting for approval is untouched", waiting, RunStatusWaitingApproval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reloadRun(t, db, tt.run.ID); RunStatus(got.Status) != tt.want {
+				t.Errorf("status = %s, want %s", got.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunManagerReclaimRace(t *testing.T) {
+	m, db := newTestRunManager(t)
+	run := createTestRun(t, db, RunStatusQueued, nil)
+
+	// 第一个工作协程认领后失联，运行被回收并由第二个工作协程认领
+	first := claimTestRun(t, m, run.ID)
+	db.Model(first).Update("heartbeat_at", time.Now().Add(-time.Hour))
+	m.recoverStaleRuns()
+	second := claimTestRun(t, m, run.ID)
+	if second.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", second.Attempts)
+	}
+
+	// 旧租约的心跳发现运行已被接管，中断第一个工作协程的执行
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		m.heartbeat(ctx, cancel, first)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat with a stale lease did not stop")
+	}
+	if ctx.Err() == nil {
+		t.Error("run context not cancelled after the lease was lost")
+	}
+
+	// 旧租约的结果和暂停都不会覆盖新的执行
+	m.finish(first, RunStatusCompleted, "stale output", nil, nil)
+	approval := &ToolApprovalState{ID: uuid.NewString(), Status: ApprovalStatusPending}
+	m.approvalStore.Save(context.Background(), approval)
+	m.pause(first, &ApprovalRequiredError{ApprovalID: approval.ID}, NewSimpleMemory(10))
+
+	got := reloadRun(t, db, run.ID)
+	if RunStatus(got.Status) != RunStatusRunning || got.Output != "" || got.ApprovalID != nil {
+		t.Errorf("run after stale updates = status %s, output %q, approval %v", got.Status, got.Output, got.ApprovalID)
+	}
+	// 旧执行产生的审批被关闭，不会阻塞对话
+	if state, _ := m.approvalStore.Get(context.Background(), approval.ID); state.Status != ApprovalStatusResolved {
+		t.Errorf("orphaned approval status = %s, want %s", state.Status, ApprovalStatusResolved)
+	}
+
+	m.finish(second, RunStatusCompleted, "output", nil, nil)
+	if got := reloadRun(t, db, run.ID); RunStatus(got.Status) != RunStatusCompleted || got.Output != "output" {
+		t.Errorf("run after finish = status %s, output %q", got.Status, got.Output)
+	}
+}
+
+func TestRunManagerPauseAndFinish(t *testing.T) {
+	m, db := newTestRunManager(t)
+	run := createTestRun(t, db, RunStatusQueued, nil)
+	claimed := claimTestRun(t, m, run.ID)
+
+	memory := NewSimpleMemory(10)
+	memory.AddMessage(eino.Message{Role: eino.RoleUser, Content: "hello"})
+	approvalID := uuid.New()
+	m.pause(claimed, &ApprovalRequiredError{ApprovalID: approvalID.String()}, memory)
+
+	paused := reloadRun(t, db, run.ID)
+	if RunStatus(paused.Status) != RunStatusWaitingApproval || paused.ApprovalID == nil || *paused.ApprovalID != approvalID {
+		t.Fatalf("paused run = status %s, approval %v", paused.Status, paused.ApprovalID)
+	}
+	if committedAttempt(paused.State) != 1 || paused.State["messages"] == nil {
+		t.Errorf("paused state = %v, want messages and committed attempt 1", paused.State)
+	}
+
+	// 提交决定后重新认领并完成，已消费的决定和恢复进度被清除
+	paused.State[stateDecisions] = []interface{}{}
+	paused.State[stateDecisionsApproval] = approvalID.String()
+	db.Model(paused).Updates(map[string]interface{}{"status": string(RunStatusQueued), "state": paused.State})
+	resumed := claimTestRun(t, m, run.ID)
+	if err := m.saveToolResults(resumed, []eino.ToolResult{{ToolCallID: "c1", Content: "ok"}}); err != nil {
+		t.Fatalf("saveToolResults: %v", err)
+	}
+	if results, applied, _ := resultsFromState(reloadRun(t, db, run.ID).State, &approvalID); !applied || len(results) != 1 {
+		t.Errorf("saved tool results = %+v, %v", results, applied)
+	}
+
+	m.finish(resumed, RunStatusCompleted, "done", nil, nil)
+	finished := reloadRun(t, db, run.ID)
+	if RunStatus(finished.Status) != RunStatusCompleted || finished.Output != "done" || finished.FinishedAt == nil {
+		t.Errorf("finished run = status %s, output %q", finished.Status, finished.Output)
+	}
+	for _, key := range []string{stateDecisions, stateToolResults, stateCommittedAttempt} {
+		if _, ok := finished.State[key]; ok {
+			t.Errorf("finished state still has %s", key)
+		}
+	}
+
+	// 运行结束后旧租约无法再写入工具结果
+	if err := m.saveToolResults(resumed, nil); !errors.Is(err, errLeaseLost) {
+		t.Errorf("saveToolResults after finish = %v, want %v", err, errLeaseLost)
+	}
+}
+
+func TestRunManagerDiscardAttemptMessages(t *testing.T) {
+	m, db := newTestRunManager(t)
+	conversation := createTestConversation(t, db)
+	runID := uuid.New()
+
+	// 运行之前的用户消息、第1次尝试暂停前写入的消息、第2次尝试中途失联时写入的消息
+	base := NewGormMemory(db, conversation.ID, 0)
+	write := func(memory *GormMemory, role, content string) {
+		t.Helper()
+		if err := memory.AddMessage(eino.Message{Role: role, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(base, eino.RoleUser, "question")
+	write(base.WithMetadata(models.JSONMap{"run_id": runID.String(), "attempt": 1}), eino.RoleAssistant, "calling tool")
+	write(base.WithMetadata(models.JSONMap{"run_id": runID.String(), "attempt": 2}), eino.RoleTool, "tool result")
+	write(base.WithMetadata(models.JSONMap{"run_id": runID.String(), "attempt": 2}), eino.RoleAssistant, "partial")
+
+	contents := func() []string {
+		t.Helper()
+		records, err := base.Records()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]string, len(records))
+		for i, r := range records {
+			out[i] = r.Content
+		}
+		return out
+	}
+
+	if err := m.discardAttemptMessages(context.Background(), conversation.ID, runID, 1); err != nil {
+		t.Fatalf("discardAttemptMessages: %v", err)
+	}
+	if got, want := contents(), []string{"question", "calling tool"}; !equalStrings(got, want) {
+		t.Errorf("after discarding attempt 2: %v, want %v", got, want)
+	}
+
+	// 新的消息接在保留的消息之后
+	write(base.WithMetadata(models.JSONMap{"run_id": runID.String(), "attempt": 3}), eino.RoleTool, "tool result")
+	if got, want := contents(), []string{"question", "calling tool", "tool result"}; !equalStrings(got, want) {
+		t.Errorf("after retry: %v, want %v", got, want)
+	}
+
+	// 未暂停过的运行重试时清理全部消息
+	if err := m.discardAttemptMessages(context.Background(), conversation.ID, runID, 0); err != nil {
+		t.Fatalf("discardAttemptMessages: %v", err)
+	}
+	if got, want := contents(), []string{"question"}; !equalStrings(got, want) {
+		t.Errorf("after discarding all attempts: %v, want %v", got, want)
+	}
+}
+
+func TestRunManagerSubmitGuard(t *testing.T) {
+	m, db := newTestRunManager(t)
+	conversation := createTestConversation(t, db)
+	errBlocked := errors.New("blocked")
+
+	tests := []struct {
+		name    string
+		guard   MessageGuard
+		wantErr error
+	}{
+		{"guard passes", func(tx *gorm.DB) error { return nil }, nil},
+		{"guard rejects", func(tx *gorm.DB) error { return errBlocked }, errBlocked},
+		{"no guard", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := models.AgentRun{AgentID: uuid.New(), UserID: uuid.New(), ConversationID: &conversation.ID, Input: "hi"}
+			err := m.Submit(context.Background(), &run, tt.guard)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Submit error = %v, want %v", err, tt.wantErr)
+			}
+
+			var count int64
+			db.Model(&models.AgentRun{}).Where("id = ?", run.ID).Count(&count)
+			if created := count == 1; created != (tt.wantErr == nil) {
+				t.Errorf("run created = %v, want %v", created, tt.wantErr == nil)
+			}
+			if tt.wantErr == nil && RunStatus(run.Status) != RunStatusQueued {
+				t.Errorf("status = %s, want %s", run.Status, RunStatusQueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,598 @@
+package agent
+
+// sandbox.go 提供代码解释器使用的沙箱执行环境
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// SandboxLanguage 表示沙箱支持的语言
+type SandboxLanguage string
+
+const (
+	// SandboxPython 在沙箱子进程中运行Python解释器
+	SandboxPython SandboxLanguage = "python"
+	// SandboxJavaScript 在沙箱子进程中使用内置的JS引擎运行代码
+	SandboxJavaScript SandboxLanguage = "javascript"
+)
+
+// 沙箱内部的目录约定
+const (
+	sandboxInputDir      = "input"
+	sandboxPythonFile    = "main.py"
+	sandboxJSFile        = "main.js"
+	sandboxWorkMount     = "/work" // 工作目录在沙箱根文件系统中的位置
+	sandboxTruncated     = "\n...[输出已截断]"
+	sandboxInitArg       = "lyss-sandbox-init" // 沙箱子进程的 argv[0]，服务以此重新执行自身进入沙箱
+	sandboxSpecEnv       = "LYSS_SANDBOX_SPEC"
+	sandboxInitFailed    = 126
+	sandboxRootfsSizeMB  = 16
+	sandboxMaxTempSizeMB = 64
+)
+
+var (
+	ErrSandboxUnsupportedLanguage  = errors.New("unsupported sandbox language")
+	ErrSandboxIsolationUnavailable = errors.New("sandbox isolation is not available on this host")
+	ErrSandboxDuplicateInput       = errors.New("duplicate sandbox input file name")
+)
+
+// SandboxConfig 沙箱配置
+type SandboxConfig struct {
+	PythonPath      string        // Python解释器路径
+	WorkDirRoot     string        // 临时工作目录的根路径，为空时使用系统临时目录
+	Timeout         time.Duration // 单次执行的墙钟时间上限
+	CPUTimeSeconds  int           // CPU时间上限（秒）
+	MemoryLimitMB   int           // 虚拟内存上限（MB）
+	MaxProcesses    int           // 最大进程数
+	MaxFileSizeMB   int           // 单个写入文件的大小上限（MB）
+	MaxOutputBytes  int           // stdout/stderr 各自的最大捕获字节数
+	MaxResultFiles  int           // 返回的产出文件数量上限
+	MaxResultFileKB int           // 返回的单个产出文件大小上限（KB）
+	ReadOnlyPaths   []string      // 以只读方式挂载到沙箱根文件系统的宿主机路径，需包含解释器及其依赖的库
+	AllowUnisolated bool          // 命名空间不可用时是否允许在无隔离模式下运行（仍限制资源，但可访问宿主机文件系统）
+}
+
+// DefaultSandboxConfig 返回默认的沙箱配置
+func DefaultSandboxConfig() SandboxConfig {
+	return SandboxConfig{
+		PythonPath:      "python3",
+		Timeout:         30 * time.Second,
+		CPUTimeSeconds:  20,
+		MemoryLimitMB:   512,
+		MaxProcesses:    32,
+		MaxFileSizeMB:   50,
+		MaxOutputBytes:  64 * 1024,
+		MaxResultFiles:  10,
+		MaxResultFileKB: 2048,
+		ReadOnlyPaths: []string{
+			"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64",
+			"/etc/alternatives", "/etc/ld.so.cache", "/etc/localtime",
+		},
+	}
+}
+
+// SandboxFile 表示以只读方式挂载到沙箱中的文件
+type SandboxFile struct {
+	Name string                                           `json:"name"`           // 沙箱内的文件名（位于 input/ 目录下）
+	Path string                                           `json:"path,omitempty"` // 宿主机上的文件路径
+	Open func(ctx context.Context) (io.ReadCloser, error) `json:"-"`              // 未设置 Path 时读取文件内容，如对象存储中的附件
+}
+
+// SandboxRequest 沙箱执行请求
+type SandboxRequest struct {
+	Language SandboxLanguage `json:"language"`
+	Code     string          `json:"code"`
+	Files    []SandboxFile   `json:"files,omitempty"`
+}
+
+// SandboxOutputFile 表示代码执行过程中产出的文件
+type SandboxOutputFile struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mime_type"`
+	Encoding  string `json:"encoding"` // text 或 base64
+	Content   string `json:"content,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// SandboxResult 沙箱执行结果
+type SandboxResult struct {
+	Language SandboxLanguage     `json:"language"`
+	Stdout   string              `json:"stdout"`
+	Stderr   string              `json:"stderr"`
+	ExitCode int                 `json:"exit_code"`
+	TimedOut bool                `json:"timed_out"`
+	Isolated bool                `json:"isolated"`
+	Duration int64               `json:"duration_ms"`
+	Files    []SandboxOutputFile `json:"files,omitempty"`
+}
+
+// Sandbox 代码执行沙箱
+type Sandbox struct {
+	config  SandboxConfig
+	isolate func(cmd *exec.Cmd) bool // 为子进程启用命名空间隔离，返回是否启用
+}
+
+// NewSandbox 创建新的沙箱
+func NewSandbox(config SandboxConfig) *Sandbox {
+	defaults := DefaultSandboxConfig()
+	if config.PythonPath == "" {
+		config.PythonPath = defaults.PythonPath
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.CPUTimeSeconds <= 0 {
+		config.CPUTimeSeconds = defaults.CPUTimeSeconds
+	}
+	if config.MemoryLimitMB <= 0 {
+		config.MemoryLimitMB = defaults.MemoryLimitMB
+	}
+	if config.MaxProcesses <= 0 {
+		config.MaxProcesses = defaults.MaxProcesses
+	}
+	if config.MaxFileSizeMB <= 0 {
+		config.MaxFileSizeMB = defaults.MaxFileSizeMB
+	}
+	if config.MaxOutputBytes <= 0 {
+		config.MaxOutputBytes = defaults.MaxOutputBytes
+	}
+	if config.MaxResultFiles <= 0 {
+		config.MaxResultFiles = defaults.MaxResultFiles
+	}
+	if config.MaxResultFileKB <= 0 {
+		config.MaxResultFileKB = defaults.MaxResultFileKB
+	}
+	if config.ReadOnlyPaths == nil {
+		config.ReadOnlyPaths = defaults.ReadOnlyPaths
+	}
+	return &Sandbox{config: config, isolate: applySandboxIsolation}
+}
+
+// sandboxSpec 传递给沙箱子进程的执行参数
+type sandboxSpec struct {
+	Language       SandboxLanguage `json:"language"`
+	Isolated       bool            `json:"isolated"`
+	RootDir        string          `json:"root_dir"` // 新根文件系统的挂载点
+	WorkDir        string          `json:"work_dir"` // 宿主机上的工作目录，隔离时挂载到 /work
+	Interpreter    string          `json:"interpreter,omitempty"`
+	ReadOnlyPaths  []string        `json:"read_only_paths"`
+	CPUTimeSeconds int             `json:"cpu_time_seconds"`
+	MemoryLimitMB  int             `json:"memory_limit_mb"`
+	MaxProcesses   int             `json:"max_processes"`
+	MaxFileSizeMB  int             `json:"max_file_size_mb"`
+}
+
+// environ 返回启动沙箱子进程的环境变量，子进程不继承服务的环境
+func (spec sandboxSpec) environ() ([]string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	return []string{sandboxSpecEnv + "=" + string(data)}, nil
+}
+
+// 以沙箱参数重新执行时进入沙箱，不再启动服务
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == sandboxInitArg && os.Getenv(sandboxSpecEnv) != "" {
+		os.Exit(runSandboxInit())
+	}
+}
+
+// Run 在沙箱中执行代码。两种语言都在隔离的子进程中执行：子进程位于独立的命名空间中，
+// 根文件系统为只读的临时文件系统，只能写入工作目录和 /tmp，并受CPU、内存、进程数和文件大小限制
+func (s *Sandbox) Run(ctx context.Context, req SandboxRequest) (*SandboxResult, error) {
+	if strings.TrimSpace(req.Code) == "" {
+		return nil, errors.New("code cannot be empty")
+	}
+
+	switch req.Language {
+	case "":
+		req.Language = SandboxPython
+	case SandboxPython, SandboxJavaScript:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSandboxUnsupportedLanguage, req.Language)
+	}
+	if !sandboxSupported {
+		return nil, ErrSandboxIsolationUnavailable
+	}
+
+	runDir, err := os.MkdirTemp(s.config.WorkDirRoot, "lyss-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox dir: %w", err)
+	}
+	defer os.RemoveAll(runDir)
+
+	spec := sandboxSpec{
+		Language:       req.Language,
+		Isolated:       true,
+		RootDir:        filepath.Join(runDir, "root"),
+		WorkDir:        filepath.Join(runDir, "work"),
+		ReadOnlyPaths:  s.config.ReadOnlyPaths,
+		CPUTimeSeconds: s.config.CPUTimeSeconds,
+		MemoryLimitMB:  s.config.MemoryLimitMB,
+		MaxProcesses:   s.config.MaxProcesses,
+		MaxFileSizeMB:  s.config.MaxFileSizeMB,
+	}
+	for _, dir := range []string{spec.RootDir, spec.WorkDir} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create sandbox dir: %w", err)
+		}
+	}
+
+	mainFile := sandboxPythonFile
+	if req.Language == SandboxJavaScript {
+		mainFile = sandboxJSFile
+	} else {
+		interpreter, paths, err := s.resolveInterpreter()
+		if err != nil {
+			return nil, err
+		}
+		spec.Interpreter = interpreter
+		spec.ReadOnlyPaths = paths
+	}
+	if err := os.WriteFile(filepath.Join(spec.WorkDir, mainFile), []byte(req.Code), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write code: %w", err)
+	}
+
+	// 准备只读输入文件
+	if err := prepareSandboxInputs(ctx, spec.WorkDir, req.Files); err != nil {
+		return nil, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate sandbox executable: %w", err)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	// 子进程为服务自身，以 sandboxInitArg 启动后完成挂载和资源限制，再执行用户代码
+	cmd := exec.CommandContext(runCtx, executable)
+	cmd.Args = []string{sandboxInitArg}
+	cmd.Dir = spec.WorkDir
+	if cmd.Env, err = spec.environ(); err != nil {
+		return nil, err
+	}
+
+	stdout := newLimitedBuffer(s.config.MaxOutputBytes)
+	stderr := newLimitedBuffer(s.config.MaxOutputBytes)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	isolated := s.isolate(cmd)
+	if !isolated && !s.config.AllowUnisolated {
+		return nil, ErrSandboxIsolationUnavailable
+	}
+
+	start := time.Now()
+	err = cmd.Start()
+	if err != nil && isolated {
+		// 宿主机不支持无特权命名空间
+		if !s.config.AllowUnisolated {
+			return nil, fmt.Errorf("%w: %v", ErrSandboxIsolationUnavailable, err)
+		}
+		spec.Isolated = false
+		cmd = cloneCommandWithoutIsolation(runCtx, cmd)
+		if cmd.Env, err = spec.environ(); err != nil {
+			return nil, err
+		}
+		isolated = false
+		err = cmd.Start()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sandbox: %w", err)
+	}
+
+	waitErr := cmd.Wait()
+	result := &SandboxResult{
+		Language: req.Language,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Isolated: isolated,
+		Duration: time.Since(start).Milliseconds(),
+	}
+
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+		result.ExitCode = -1
+	} else if waitErr != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(waitErr, &exitErr) {
+			return nil, fmt.Errorf("sandbox execution failed: %w", waitErr)
+		}
+		result.ExitCode = exitErr.ExitCode()
+		if result.ExitCode == sandboxInitFailed && isolated {
+			// 沙箱环境未能建立时不返回用户代码的执行结果
+			return nil, fmt.Errorf("%w: %s", ErrSandboxIsolationUnavailable, strings.TrimSpace(result.Stderr))
+		}
+	}
+
+	files, err := s.collectOutputFiles(spec.WorkDir, mainFile)
+	if err != nil {
+		return nil, err
+	}
+	result.Files = files
+
+	return result, nil
+}
+
+// resolveInterpreter 查找Python解释器的绝对路径。解释器不在只读挂载的路径下时（如虚拟环境），
+// 同时挂载其所在目录
+func (s *Sandbox) resolveInterpreter() (string, []string, error) {
+	interpreter, err := exec.LookPath(s.config.PythonPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("python interpreter not found: %w", err)
+	}
+	if interpreter, err = filepath.Abs(interpreter); err != nil {
+		return "", nil, err
+	}
+
+	paths := append([]string(nil), s.config.ReadOnlyPaths...)
+	candidates := []string{interpreter}
+	if resolved, err := filepath.EvalSymlinks(interpreter); err == nil {
+		candidates = append(candidates, resolved)
+	}
+	for _, candidate := range candidates {
+		covered := false
+		for _, path := range paths {
+			if candidate == path || strings.HasPrefix(candidate, strings.TrimRight(path, "/")+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			paths = append(paths, filepath.Dir(candidate))
+		}
+	}
+	return interpreter, paths, nil
+}
+
+// sandboxPythonEnv 返回Python解释器的环境变量
+func sandboxPythonEnv(home, tmpDir string) []string {
+	return []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + home,
+		"TMPDIR=" + tmpDir,
+		"LANG=C.UTF-8",
+		"PYTHONDONTWRITEBYTECODE=1",
+		"PYTHONUNBUFFERED=1",
+		"MPLBACKEND=Agg",
+	}
+}
+
+// runJavaScript 在沙箱子进程中使用内置JS引擎执行工作目录中的代码，返回退出码。
+// 运行时只能读取 input/ 目录中的文件，超时和内存超限由父进程和资源限制处理
+func runJavaScript() int {
+	code, err := os.ReadFile(sandboxJSFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox: failed to read code:", err)
+		return sandboxInitFailed
+	}
+
+	vm := goja.New()
+	printer := func(w io.Writer) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			parts := make([]string, len(call.Arguments))
+			for i, arg := range call.Arguments {
+				parts[i] = arg.String()
+			}
+			fmt.Fprintln(w, strings.Join(parts, " "))
+			return goja.Undefined()
+		}
+	}
+
+	console := vm.NewObject()
+	_ = console.Set("log", printer(os.Stdout))
+	_ = console.Set("info", printer(os.Stdout))
+	_ = console.Set("warn", printer(os.Stderr))
+	_ = console.Set("error", printer(os.Stderr))
+	_ = vm.Set("console", console)
+
+	// 仅允许读取挂载的输入文件
+	_ = vm.Set("readFile", func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(sandboxInputDir, filepath.Base(name)))
+		if err != nil {
+			return "", fmt.Errorf("file not found: %s", name)
+		}
+		return string(data), nil
+	})
+
+	value, err := vm.RunString(string(code))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if value != nil && !goja.IsUndefined(value) && !goja.IsNull(value) {
+		// 最后一个表达式的值作为输出
+		fmt.Fprintln(os.Stdout, value.String())
+	}
+	return 0
+}
+
+// prepareSandboxInputs 将输入文件以只读方式放置到沙箱的 input 目录。文件按文件名平铺，
+// 去掉目录后重名的文件会互相覆盖，因此直接拒绝
+func prepareSandboxInputs(ctx context.Context, workDir string, files []SandboxFile) error {
+	inputDir := filepath.Join(workDir, sandboxInputDir)
+	if err := os.MkdirAll(inputDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create input dir: %w", err)
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		name := filepath.Base(f.Name)
+		if name == "." || name == ".." || name == string(filepath.Separator) || name == "" {
+			return fmt.Errorf("invalid input file name: %q", f.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("%w: %s", ErrSandboxDuplicateInput, name)
+		}
+		seen[name] = true
+
+		src, err := openSandboxFile(ctx, f)
+		if err != nil {
+			return fmt.Errorf("failed to open input file %s: %w", name, err)
+		}
+
+		dstPath := filepath.Join(inputDir, name)
+		dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o444)
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("failed to create input file %s: %w", name, err)
+		}
+
+		_, err = io.Copy(dst, src)
+		src.Close()
+		dst.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy input file %s: %w", name, err)
+		}
+	}
+
+	// 输入目录本身不可写
+	return os.Chmod(inputDir, 0o555)
+}
+
+// openSandboxFile 打开输入文件
+func openSandboxFile(ctx context.Context, f SandboxFile) (io.ReadCloser, error) {
+	if f.Path == "" && f.Open != nil {
+		return f.Open(ctx)
+	}
+	return os.Open(f.Path)
+}
+
+// collectOutputFiles 收集代码执行产出的文件，mainFile 为用户代码文件，不作为结果返回
+func (s *Sandbox) collectOutputFiles(workDir, mainFile string) ([]SandboxOutputFile, error) {
+	var files []SandboxOutputFile
+	maxBytes := int64(s.config.MaxResultFileKB) * 1024
+
+	err := filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(workDir, path)
+		if info.IsDir() {
+			if rel == sandboxInputDir || strings.HasPrefix(rel, ".") && rel != "." {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == mainFile || !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(files) >= s.config.MaxResultFiles {
+			return filepath.SkipAll
+		}
+
+		out := SandboxOutputFile{
+			Name: filepath.ToSlash(rel),
+			Size: info.Size(),
+		}
+
+		data, err := readFileLimited(path, maxBytes)
+		if err != nil {
+			return nil
+		}
+		out.Truncated = info.Size() > maxBytes
+		out.MimeType = http.DetectContentType(data)
+
+		if utf8.Valid(data) && strings.HasPrefix(out.MimeType, "text/") {
+			out.Encoding = "text"
+			out.Content = string(data)
+		} else {
+			out.Encoding = "base64"
+			out.Content = base64.StdEncoding.EncodeToString(data)
+		}
+
+		files = append(files, out)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect output files: %w", err)
+	}
+
+	return files, nil
+}
+
+// readFileLimited 最多读取文件的前 limit 个字节
+func readFileLimited(path string, limit int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, limit))
+}
+
+// limitedBuffer 是一个有容量上限的并发安全写入缓冲区
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+	mu        sync.Mutex
+}
+
+func newLimitedBuffer(limit int) *limitedBuffer {
+	return &limitedBuffer{limit: limit}
+}
+
+// Write 实现 io.Writer，超出上限的内容会被丢弃
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	remaining := b.limit - b.buf.Len()
+	if remaining <= 0 {
+		b.truncated = true
+		return len(p), nil
+	}
+	if len(p) > remaining {
+		b.buf.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String 返回缓冲区内容
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.truncated {
+		return strings.ToValidUTF8(b.buf.String(), "") + sandboxTruncated
+	}
+	return b.buf.String()
+}
+
+// sandboxFilesKey 是上下文中沙箱输入文件的键
+type sandboxFilesKey struct{}
+
+// WithSandboxFiles 将对话上传的文件附加到上下文，代码解释器会以只读方式挂载它们
+func WithSandboxFiles(ctx context.Context, files []SandboxFile) context.Context {
+	return context.WithValue(ctx, sandboxFilesKey{}, files)
+}
+
+// SandboxFilesFromContext 从上下文中获取沙箱输入文件
+func SandboxFilesFromContext(ctx context.Context) []SandboxFile {
+	files, _ := ctx.Value(sandboxFilesKey{}).([]SandboxFile)
+	return files
+}
@@ -0,0 +1,356 @@
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+	"unsafe"
+)
+
+// sandboxSupported Linux 上通过命名空间和 pivot_root 隔离沙箱
+const sandboxSupported = true
+
+// Linux 常量，syscall 包中未定义
+const (
+	rlimitNPROC            = 6
+	prCapbsetDrop          = 24
+	prSetNoNewPrivs        = 38
+	linuxCapabilityVersion = 0x20080522
+	linuxMaxCapability     = 63
+	statfsReadOnly         = 0x1
+	statfsNoSUID           = 0x2
+	statfsNoDev            = 0x4
+	statfsNoExec           = 0x8
+	statfsNoAtime          = 0x400
+	statfsNoDirAtime       = 0x800
+	statfsRelAtime         = 0x1000
+)
+
+// sandboxDevices 绑定挂载到沙箱 /dev 的设备文件
+var sandboxDevices = []string{"null", "zero", "random", "urandom"}
+
+// applySandboxIsolation 为子进程启用独立的用户、网络、PID、挂载、IPC和UTS命名空间。
+// 新的网络命名空间中只有未启用的回环设备，因此代码无法访问网络。
+// 子进程在命名空间中以 root 身份完成挂载，执行用户代码前清空全部能力
+func applySandboxIsolation(cmd *exec.Cmd) bool {
+	uid := os.Getuid()
+	gid := os.Getgid()
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+		Cloneflags: syscall.CLONE_NEWUSER |
+			syscall.CLONE_NEWNET |
+			syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWIPC |
+			syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: uid, Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: gid, Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	setProcessGroupKill(cmd)
+
+	return true
+}
+
+// cloneCommandWithoutIsolation 在命名空间不可用时创建一个仅保留资源限制的命令
+func cloneCommandWithoutIsolation(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	clone := exec.CommandContext(ctx, cmd.Path)
+	clone.Args = cmd.Args
+	clone.Dir = cmd.Dir
+	clone.Env = cmd.Env
+	clone.Stdout = cmd.Stdout
+	clone.Stderr = cmd.Stderr
+	clone.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+	setProcessGroupKill(clone)
+	return clone
+}
+
+// setProcessGroupKill 超时时终止整个进程组，避免遗留子进程
+func setProcessGroupKill(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// runSandboxInit 沙箱子进程的入口：切换到只读的根文件系统，设置资源限制后执行用户代码。
+// 任一步骤失败时以 sandboxInitFailed 退出，不执行用户代码
+func runSandboxInit() int {
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxSpecEnv)), &spec); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox: invalid spec:", err)
+		return sandboxInitFailed
+	}
+
+	// 能力集和 no_new_privs 作用于线程，之后的 exec 必须在同一线程上执行
+	runtime.LockOSThread()
+
+	workDir, tmpDir := spec.WorkDir, spec.WorkDir
+	if spec.Isolated {
+		if err := enterSandboxRoot(spec); err != nil {
+			fmt.Fprintln(os.Stderr, "sandbox:", err)
+			return sandboxInitFailed
+		}
+		workDir, tmpDir = sandboxWorkMount, "/tmp"
+	}
+	if err := os.Chdir(workDir); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox:", err)
+		return sandboxInitFailed
+	}
+	if err := applySandboxLimits(spec); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox:", err)
+		return sandboxInitFailed
+	}
+	if err := dropSandboxPrivileges(spec.Isolated); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox:", err)
+		return sandboxInitFailed
+	}
+
+	if spec.Language == SandboxJavaScript {
+		return runJavaScript()
+	}
+	err := syscall.Exec(spec.Interpreter, []string{spec.Interpreter, "-I", sandboxPythonFile}, sandboxPythonEnv(workDir, tmpDir))
+	fmt.Fprintln(os.Stderr, "sandbox: failed to exec interpreter:", err)
+	return sandboxInitFailed
+}
+
+// enterSandboxRoot 在新的挂载命名空间中构建根文件系统并 pivot_root 进入：
+// 根目录为临时文件系统，只读挂载解释器所需的宿主机路径，工作目录挂载到 /work，
+// 另有独立的 /tmp、/proc 和少量设备文件。完成后根目录重新挂载为只读
+func enterSandboxRoot(spec sandboxSpec) error {
+	// 挂载事件不传播回宿主机
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	root := spec.RootDir
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV,
+		fmt.Sprintf("size=%dm,mode=0755", sandboxRootfsSizeMB)); err != nil {
+		return fmt.Errorf("failed to mount sandbox root: %w", err)
+	}
+
+	for _, path := range spec.ReadOnlyPaths {
+		if err := bindReadOnly(path, filepath.Join(root, path)); err != nil {
+			return err
+		}
+	}
+
+	work := filepath.Join(root, sandboxWorkMount)
+	if err := os.MkdirAll(work, 0o755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(spec.WorkDir, work, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to mount work dir: %w", err)
+	}
+	if err := remountBind(work, syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+		return fmt.Errorf("failed to remount work dir: %w", err)
+	}
+
+	tmp := filepath.Join(root, "tmp")
+	if err := os.MkdirAll(tmp, 0o777); err != nil {
+		return err
+	}
+	tmpSize := spec.MaxFileSizeMB
+	if tmpSize > sandboxMaxTempSizeMB {
+		tmpSize = sandboxMaxTempSizeMB
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV,
+		fmt.Sprintf("size=%dm,mode=1777", tmpSize)); err != nil {
+		return fmt.Errorf("failed to mount tmp: %w", err)
+	}
+
+	dev := filepath.Join(root, "dev")
+	if err := os.MkdirAll(dev, 0o755); err != nil {
+		return err
+	}
+	for _, name := range sandboxDevices {
+		target := filepath.Join(dev, name)
+		if err := os.WriteFile(target, nil, 0o666); err != nil {
+			return err
+		}
+		if err := syscall.Mount(filepath.Join("/dev", name), target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to mount /dev/%s: %w", name, err)
+		}
+	}
+
+	// 新的PID命名空间中的 /proc 只包含沙箱内的进程。部分容器环境不允许挂载 proc，此时不提供 /proc
+	proc := filepath.Join(root, "proc")
+	if err := os.MkdirAll(proc, 0o555); err != nil {
+		return err
+	}
+	_ = syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	oldRoot := filepath.Join(root, ".old_root")
+	if err := os.Mkdir(oldRoot, 0o700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, oldRoot); err != nil {
+		return fmt.Errorf("failed to pivot root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.old_root", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach host root: %w", err)
+	}
+	if err := os.Remove("/.old_root"); err != nil {
+		return err
+	}
+
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("failed to remount root read-only: %w", err)
+	}
+	return nil
+}
+
+// bindReadOnly 将宿主机路径只读挂载到新根文件系统中，不存在的路径跳过，符号链接按原样重建
+func bindReadOnly(source, target string) error {
+	info, err := os.Lstat(source)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+	default:
+		if err := os.WriteFile(target, nil, 0o444); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", source, err)
+	}
+	if err := remountBind(target, syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+		return fmt.Errorf("failed to remount %s read-only: %w", source, err)
+	}
+	return nil
+}
+
+// remountBind 以指定标志重新挂载绑定挂载点。用户命名空间中不能清除源挂载点已有的
+// noexec、atime 等标志，因此保留这些标志
+func remountBind(target string, flags uintptr) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(target, &stat); err != nil {
+		return err
+	}
+
+	flags |= syscall.MS_BIND | syscall.MS_REMOUNT
+	locked := map[int64]uintptr{
+		statfsReadOnly:   syscall.MS_RDONLY,
+		statfsNoSUID:     syscall.MS_NOSUID,
+		statfsNoDev:      syscall.MS_NODEV,
+		statfsNoExec:     syscall.MS_NOEXEC,
+		statfsNoAtime:    syscall.MS_NOATIME,
+		statfsNoDirAtime: syscall.MS_NODIRATIME,
+		statfsRelAtime:   syscall.MS_RELATIME,
+	}
+	for statFlag, mountFlag := range locked {
+		if int64(stat.Flags)&statFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+	if int64(stat.Flags)&(statfsNoAtime|statfsRelAtime) == 0 {
+		flags |= syscall.MS_STRICTATIME
+	}
+	return syscall.Mount("", target, "", flags, "")
+}
+
+// applySandboxLimits 设置CPU时间、内存、文件大小和进程数限制
+func applySandboxLimits(spec sandboxSpec) error {
+	memory := uint64(spec.MemoryLimitMB) << 20
+	limits := map[int]uint64{
+		syscall.RLIMIT_CPU:   uint64(spec.CPUTimeSeconds),
+		syscall.RLIMIT_FSIZE: uint64(spec.MaxFileSizeMB) << 20,
+	}
+	if spec.Language == SandboxJavaScript {
+		// JS引擎运行在Go运行时中，运行时启动时预留的虚拟地址空间远超内存上限，
+		// 因此按可写数据段限制，并让垃圾回收在接近上限时更积极地回收
+		limits[syscall.RLIMIT_DATA] = memory
+		debug.SetMemoryLimit(int64(memory) / 4 * 3)
+	} else {
+		limits[syscall.RLIMIT_AS] = memory
+	}
+	if spec.Isolated {
+		// 进程数按用户统计，无隔离时服务自身的线程也计算在内，因此只在独立的用户命名空间中限制
+		limits[rlimitNPROC] = uint64(spec.MaxProcesses)
+	}
+
+	for resource, value := range limits {
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value}); err != nil {
+			return fmt.Errorf("failed to set resource limit %d: %w", resource, err)
+		}
+	}
+	return nil
+}
+
+// capHeader 与 capData 对应 capset 系统调用的参数
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// dropSandboxPrivileges 清空能力边界集和当前线程的能力集，并禁止通过 exec 获得新权限。
+// 无隔离时进程本就没有能力，只设置 no_new_privs
+func dropSandboxPrivileges(isolated bool) error {
+	if isolated {
+		for capability := 0; capability <= linuxMaxCapability; capability++ {
+			_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(capability), 0)
+			if errno != 0 && errno != syscall.EINVAL {
+				return fmt.Errorf("failed to drop capability %d: %w", capability, errno)
+			}
+		}
+
+		header := capHeader{version: linuxCapabilityVersion}
+		var data [2]capData
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+			return fmt.Errorf("failed to clear capabilities: %w", errno)
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+	return nil
+}
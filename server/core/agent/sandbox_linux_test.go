@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// sandboxTestPython 沙箱测试使用的解释器，需位于只读挂载的系统目录中
+const sandboxTestPython = "/usr/bin/python3"
+
+// newTestSandbox 创建沙箱并确认宿主机支持命名空间隔离，不支持时跳过测试
+func newTestSandbox(t *testing.T, config SandboxConfig) *Sandbox {
+	t.Helper()
+	config.WorkDirRoot = t.TempDir()
+	if config.Timeout == 0 {
+		config.Timeout = 20 * time.Second
+	}
+	sandbox := NewSandbox(config)
+
+	_, err := sandbox.Run(context.Background(), SandboxRequest{Language: SandboxJavaScript, Code: "1"})
+	if errors.Is(err, ErrSandboxIsolationUnavailable) {
+		t.Skipf("sandbox isolation unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("probe run: %v", err)
+	}
+	return sandbox
+}
+
+// runTestPython 在沙箱中执行Python代码，没有系统Python时跳过测试
+func runTestPython(t *testing.T, sandbox *Sandbox, code string) *SandboxResult {
+	t.Helper()
+	if _, err := os.Stat(sandboxTestPython); err != nil {
+		t.Skipf("%s not available", sandboxTestPython)
+	}
+	sandbox.config.PythonPath = sandboxTestPython
+
+	result, err := sandbox.Run(context.Background(), SandboxRequest{Language: SandboxPython, Code: code})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return result
+}
+
+func TestSandboxJavaScript(t *testing.T) {
+	sandbox := newTestSandbox(t, SandboxConfig{})
+
+	result, err := sandbox.Run(context.Background(), SandboxRequest{
+		Language: SandboxJavaScript,
+		Code:     `console.log("sum", 1 + 2); readFile("data.txt").toUpperCase()`,
+		Files:    []SandboxFile{{Name: "data.txt", Path: writeTestFile(t, "hello")}},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !result.Isolated {
+		t.Error("Isolated = false, want true")
+	}
+	if result.ExitCode != 0 || result.Stdout != "sum 3\nHELLO\n" {
+		t.Errorf("exit code %d, stdout %q, stderr %q", result.ExitCode, result.Stdout, result.Stderr)
+	}
+}
+
+func TestSandboxTimeout(t *testing.T) {
+	sandbox := newTestSandbox(t, SandboxConfig{Timeout: time.Second})
+
+	result, err := sandbox.Run(context.Background(), SandboxRequest{Language: SandboxJavaScript, Code: "while (true) {}"})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !result.TimedOut || result.ExitCode != -1 {
+		t.Errorf("TimedOut = %v, exit code %d, want timeout", result.TimedOut, result.ExitCode)
+	}
+}
+
+func TestSandboxNetworkUnreachable(t *testing.T) {
+	sandbox := newTestSandbox(t, SandboxConfig{})
+
+	// 宿主机回环地址上的监听端口在沙箱的网络命名空间中不可达
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	result := runTestPython(t, sandbox, fmt.Sprintf(`
+import socket
+for host, port in [("127.0.0.1", %d), ("1.1.1.1", 53)]:
+    try:
+        socket.create_connection((host, port), timeout=2).close()
+        print("connected", host)
+    except OSError as e:
+        print("blocked", host)
+`, port))
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, stderr %q", result.ExitCode, result.Stderr)
+	}
+	if result.Stdout != "blocked 127.0.0.1\nblocked 1.1.1.1\n" {
+		t.Errorf("stdout = %q, want both connections blocked", result.Stdout)
+	}
+}
+
+func TestSandboxWritesOutsideWorkDir(t *testing.T) {
+	sandbox := newTestSandbox(t, SandboxConfig{})
+	hostDir := t.TempDir()
+
+	result := runTestPython(t, sandbox, fmt.Sprintf(`
+import os
+for path in ["/escape.txt", "/usr/escape.txt", "/etc/escape.txt", "/work/input/escape.txt", %q]:
+    try:
+        with open(path, "w") as f:
+            f.write("x")
+        print("written", path)
+    except OSError:
+        print("denied", path)
+with open("/tmp/scratch.txt", "w") as f:
+    f.write("tmp")
+with open("/work/out.txt", "w") as f:
+    f.write("result")
+`, filepath.Join(hostDir, "escape.txt")))
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, stderr %q", result.ExitCode, result.Stderr)
+	}
+	if strings.Contains(result.Stdout, "written") {
+		t.Errorf("write outside the work dir succeeded:\n%s", result.Stdout)
+	}
+	if _, err := os.Stat(filepath.Join(hostDir, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("file created in host dir: %v", err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Name != "out.txt" || result.Files[0].Content != "result" {
+		t.Errorf("Files = %+v, want out.txt", result.Files)
+	}
+}
+
+func TestSandboxMemoryLimit(t *testing.T) {
+	sandbox := newTestSandbox(t, SandboxConfig{MemoryLimitMB: 128})
+
+	result := runTestPython(t, sandbox, `
+try:
+    data = bytearray(512 * 1024 * 1024)
+    print("allocated")
+except MemoryError:
+    print("memory error")
+`)
+	if result.Stdout != "memory error\n" {
+		t.Errorf("stdout = %q, stderr %q, want memory error", result.Stdout, result.Stderr)
+	}
+}
+
+func TestSandboxRefusesUnisolatedFallback(t *testing.T) {
+	// 模拟宿主机不支持命名空间：启用隔离后启动子进程失败
+	failingIsolation := func(cmd *exec.Cmd) bool {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Chroot: "/nonexistent-sandbox-root"}
+		return true
+	}
+	noIsolation := func(cmd *exec.Cmd) bool { return false }
+
+	tests := []struct {
+		name    string
+		isolate func(cmd *exec.Cmd) bool
+	}{
+		{"start fails", failingIsolation},
+		{"isolation unsupported", noIsolation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox := NewSandbox(SandboxConfig{WorkDirRoot: t.TempDir()})
+			sandbox.isolate = tt.isolate
+			_, err := sandbox.Run(context.Background(), SandboxRequest{Language: SandboxJavaScript, Code: "1"})
+			if !errors.Is(err, ErrSandboxIsolationUnavailable) {
+				t.Errorf("error = %v, want %v", err, ErrSandboxIsolationUnavailable)
+			}
+		})
+	}
+
+	// 显式允许时退回无隔离模式，结果标记为未隔离
+	sandbox := NewSandbox(SandboxConfig{WorkDirRoot: t.TempDir(), AllowUnisolated: true})
+	sandbox.isolate = failingIsolation
+	result, err := sandbox.Run(context.Background(), SandboxRequest{Language: SandboxJavaScript, Code: `console.log("ok")`})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.Isolated || result.Stdout != "ok\n" {
+		t.Errorf("Isolated = %v, stdout %q, want unisolated run", result.Isolated, result.Stdout)
+	}
+}
+
+// writeTestFile 在临时目录中写入测试文件并返回路径
+func writeTestFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// sandboxSupported 非Linux平台无法建立隔离的根文件系统和资源限制，不执行代码
+const sandboxSupported = false
+
+// applySandboxIsolation 非Linux平台不支持命名空间隔离
+func applySandboxIsolation(cmd *exec.Cmd) bool {
+	return false
+}
+
+// cloneCommandWithoutIsolation 非Linux平台直接返回原命令
+func cloneCommandWithoutIsolation(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	return cmd
+}
+
+// runSandboxInit 非Linux平台不会启动沙箱子进程
+func runSandboxInit() int {
+	fmt.Fprintln(os.Stderr, "sandbox: unsupported platform")
+	return sandboxInitFailed
+}
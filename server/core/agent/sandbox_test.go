@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepareSandboxInputs(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(source, []byte("a,b\n1,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	workDir := t.TempDir()
+	files := []SandboxFile{
+		{Name: "data.csv", Path: source},
+		{Name: "notes.txt", Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("hello")), nil
+		}},
+	}
+	if err := prepareSandboxInputs(context.Background(), workDir, files); err != nil {
+		t.Fatalf("prepareSandboxInputs: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(workDir, sandboxInputDir, "notes.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("notes.txt = %q, %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(workDir, sandboxInputDir, "data.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o222 != 0 {
+		t.Errorf("input file mode = %v, want read-only", info.Mode())
+	}
+}
+
+func TestPrepareSandboxInputsRejectsInvalidNames(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(source, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		files []SandboxFile
+		want  error
+	}{
+		{"same name", []SandboxFile{{Name: "data.csv", Path: source}, {Name: "data.csv", Path: source}}, ErrSandboxDuplicateInput},
+		{"same base name", []SandboxFile{{Name: "a/data.csv", Path: source}, {Name: "b/data.csv", Path: source}}, ErrSandboxDuplicateInput},
+		{"empty", []SandboxFile{{Name: "", Path: source}}, nil},
+		{"parent", []SandboxFile{{Name: "..", Path: source}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := prepareSandboxInputs(context.Background(), t.TempDir(), tt.files)
+			if err == nil {
+				t.Fatal("prepareSandboxInputs succeeded, want error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	buf := newLimitedBuffer(5)
+	if n, err := buf.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if n, err := buf.Write([]byte("defgh")); n != 5 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if got, want := buf.String(), "abcde"+sandboxTruncated; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestSandboxRunValidation(t *testing.T) {
+	sandbox := NewSandbox(SandboxConfig{})
+	if _, err := sandbox.Run(context.Background(), SandboxRequest{Code: "  "}); err == nil {
+		t.Error("Run with empty code succeeded")
+	}
+	_, err := sandbox.Run(context.Background(), SandboxRequest{Language: "ruby", Code: "puts 1"})
+	if !errors.Is(err, ErrSandboxUnsupportedLanguage) {
+		t.Errorf("Run(ruby) error = %v, want %v", err, ErrSandboxUnsupportedLanguage)
+	}
+}
@@ -0,0 +1,341 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MemoryStrategy 智能体的对话记忆策略
+type MemoryStrategy string
+
+const (
+	MemoryStrategyWindow        MemoryStrategy = "window"         // 保留最近 MaxHistoryLength 条消息
+	MemoryStrategyTokenWindow   MemoryStrategy = "token_window"   // 读取全部历史，仅按上下文窗口的token预算裁剪
+	MemoryStrategySummaryBuffer MemoryStrategy = "summary_buffer" // 超过阈值时将较早的消息压缩为摘要
+)
+
+// conversationSummaryKey 摘要在 Conversation.Metadata 中的键
+const conversationSummaryKey = "summary"
+
+var ErrUnknownMemoryStrategy = errors.New("unknown memory strategy")
+
+// MemoryConfig 智能体的记忆配置，保存在 models.Agent.MemoryConfig 中
+type MemoryConfig struct {
+	Strategy MemoryStrategy `json:"strategy"`
+	// 未摘要的消息数或token数超过阈值时触发摘要，0表示不按该项触发
+	SummaryTriggerMessages int `json:"summary_trigger_messages,omitempty"`
+	SummaryTriggerTokens   int `json:"summary_trigger_tokens,omitempty"`
+	// 摘要后保留原文的最近消息数
+	SummaryKeepMessages int `json:"summary_keep_messages,omitempty"`
+	// 开启后按（智能体，用户）保存跨对话的长期记忆，每轮召回 LongTermTopK 条
+	LongTerm     bool `json:"long_term,omitempty"`
+	LongTermTopK int  `json:"long_term_top_k,omitempty"`
+}
+
+// DefaultMemoryConfig 返回默认的记忆配置
+func DefaultMemoryConfig() MemoryConfig {
+	return MemoryConfig{
+		Strategy:               MemoryStrategyWindow,
+		SummaryTriggerMessages: 40,
+		SummaryKeepMessages:    10,
+	}
+}
+
+// ParseMemoryConfig 解析记忆配置，未设置的项使用默认值
+func ParseMemoryConfig(raw models.JSONMap) (MemoryConfig, error) {
+	config := DefaultMemoryConfig()
+	if len(raw) == 0 {
+		return config, nil
+	}
+	if err := fromJSONValue(map[string]interface{}(raw), &config); err != nil {
+		return config, fmt.Errorf("invalid memory config: %w", err)
+	}
+
+	switch config.Strategy {
+	case "":
+		config.Strategy = MemoryStrategyWindow
+	case MemoryStrategyWindow, MemoryStrategyTokenWindow, MemoryStrategySummaryBuffer:
+	default:
+		return config, fmt.Errorf("%w: %s", ErrUnknownMemoryStrategy, config.Strategy)
+	}
+	if config.SummaryTriggerMessages < 0 || config.SummaryTriggerTokens < 0 || config.SummaryKeepMessages < 0 || config.LongTermTopK < 0 {
+		return config, errors.New("invalid memory config: thresholds must not be negative")
+	}
+	if config.Strategy == MemoryStrategySummaryBuffer && config.SummaryTriggerMessages == 0 && config.SummaryTriggerTokens == 0 {
+		return config, errors.New("invalid memory config: summary_buffer requires a trigger threshold")
+	}
+	return config, nil
+}
+
+// NewConversationMemory 按智能体的记忆配置构建对话记忆，store 为写入消息表的基础记忆
+func NewConversationMemory(store *GormMemory, record *models.Agent, runtime *Agent) Memory {
+	config, err := ParseMemoryConfig(record.MemoryConfig)
+	if err != nil {
+		zap.L().Warn("Invalid agent memory config, using default",
+			zap.String("agent_id", record.ID.String()), zap.Error(err))
+		config = DefaultMemoryConfig()
+	}
+
+	switch config.Strategy {
+	case MemoryStrategyTokenWindow:
+		return store.WithMaxMessages(0)
+	case MemoryStrategySummaryBuffer:
+		var tokenizer Tokenizer = approxTokenizer{}
+		if runtime.contextWindow != nil {
+			tokenizer = runtime.contextWindow.Tokenizer
+		}
+		return NewSummaryBufferMemory(store.WithMaxMessages(0), NewAgentSummarizer(runtime), tokenizer, config)
+	default:
+		return store.WithMaxMessages(record.MaxHistoryLength)
+	}
+}
+
+// Summarizer 将对话消息压缩为摘要
+type Summarizer interface {
+	Summarize(ctx context.Context, previousSummary string, messages []eino.Message) (string, error)
+}
+
+// agentSummarizer 使用智能体自身的模型生成摘要
+type agentSummarizer struct {
+	agent *Agent
+}
+
+// NewAgentSummarizer 创建使用智能体模型的摘要器
+func NewAgentSummarizer(agent *Agent) Summarizer {
+	return &agentSummarizer{agent: agent}
+}
+
+func (s *agentSummarizer) Summarize(ctx context.Context, previousSummary string, messages []eino.Message) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		switch {
+		case msg.Role == eino.RoleTool:
+			fmt.Fprintf(&transcript, "[工具结果] %s\n", msg.Content)
+		case len(msg.ToolCalls) > 0:
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&transcript, "[调用工具 %s] %s\n", call.Name, call.Arguments)
+			}
+			if msg.Content != "" {
+				fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+			}
+		default:
+			fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+		}
+	}
+
+	prompt := "请将以下对话压缩为简洁的摘要，保留用户身份、需求、已确认的事实、做出的决定和未解决的问题，不要编造内容。"
+	if previousSummary != "" {
+		prompt += "\n\n已有摘要：\n" + previousSummary
+	}
+	prompt += "\n\n新的对话内容：\n" + transcript.String() + "\n请输出合并后的完整摘要。"
+
+	return s.agent.Generate(ctx, []eino.Message{{Role: eino.RoleUser, Content: prompt}})
+}
+
+// conversationSummary 保存在对话元数据中的摘要
+type conversationSummary struct {
+	Text            string    `json:"text"`
+	CoveredSequence int64     `json:"covered_sequence"` // 已被摘要覆盖的最后一条消息序号
+	SummarizedCount int       `json:"summarized_count"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SummaryBufferMemory 摘要缓冲记忆：较早的消息压缩为摘要并以系统消息注入，最近的消息保留原文
+type SummaryBufferMemory struct {
+	store      *GormMemory
+	summarizer Summarizer
+	tokenizer  Tokenizer
+	config     MemoryConfig
+	logger     *zap.Logger
+}
+
+// NewSummaryBufferMemory 创建摘要缓冲记忆
+func NewSummaryBufferMemory(store *GormMemory, summarizer Summarizer, tokenizer Tokenizer, config MemoryConfig) *SummaryBufferMemory {
+	return &SummaryBufferMemory{
+		store:      store,
+		summarizer: summarizer,
+		tokenizer:  tokenizer,
+		config:     config,
+		logger:     zap.L().With(zap.String("component", "summary_memory")),
+	}
+}
+
+// ConversationID 返回记忆对应的对话ID
+func (m *SummaryBufferMemory) ConversationID() uuid.UUID {
+	return m.store.ConversationID()
+}
+
+// AddMessage 写入消息，新一轮用户消息写入后检查是否需要摘要
+func (m *SummaryBufferMemory) AddMessage(msg eino.Message) error {
+	if err := m.store.AddMessage(msg); err != nil {
+		return err
+	}
+
+	// 只在轮次开始时摘要，避免拆开进行中的工具调用
+	if msg.Role == eino.RoleUser {
+		if err := m.compact(); err != nil {
+			m.logger.Warn("Failed to summarize conversation",
+				zap.String("conversation_id", m.store.ConversationID().String()), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// GetMessages 返回摘要系统消息及摘要之后的消息
+func (m *SummaryBufferMemory) GetMessages() ([]eino.Message, error) {
+	summary, records, err := m.unsummarized()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]eino.Message, 0, len(records)+1)
+	if summary.Text != "" {
+		messages = append(messages, eino.Message{
+			Role:    eino.RoleSystem,
+			Content: "以下是此前对话的摘要：\n" + summary.Text,
+		})
+	}
+	messages = append(messages, m.store.toMessages(records)...)
+	return messages, nil
+}
+
+// Clear 删除对话消息及摘要
+func (m *SummaryBufferMemory) Clear() error {
+	if err := m.store.Clear(); err != nil {
+		return err
+	}
+	return m.store.db.WithContext(m.store.ctx).
+		Model(&models.Conversation{}).
+		Where("id = ?", m.store.ConversationID()).
+		Update("metadata", gorm.Expr("COALESCE(metadata, '{}'::jsonb) - ?", conversationSummaryKey)).Error
+}
+
+// compact 未摘要的消息超过阈值时，将保留窗口之前的消息并入摘要
+func (m *SummaryBufferMemory) compact() error {
+	summary, records, err := m.unsummarized()
+	if err != nil {
+		return err
+	}
+	if !m.exceedsThreshold(records) {
+		return nil
+	}
+
+	// 至少保留刚写入的用户消息；切分点向后移动到用户消息，保证工具调用与结果不被拆开
+	keep := m.config.SummaryKeepMessages
+	if keep < 1 {
+		keep = 1
+	}
+	cut := len(records) - keep
+	for cut > 0 && cut < len(records) && records[cut].Role != eino.RoleUser {
+		cut++
+	}
+	if cut <= 0 || cut >= len(records) {
+		return nil
+	}
+
+	messages := make([]eino.Message, cut)
+	for i, record := range records[:cut] {
+		messages[i] = RecordToMessage(record)
+	}
+	text, err := m.summarizer.Summarize(m.store.ctx, summary.Text, messages)
+	if err != nil {
+		return err
+	}
+
+	return m.saveSummary(summary.CoveredSequence, conversationSummary{
+		Text:            strings.TrimSpace(text),
+		CoveredSequence: records[cut-1].Sequence,
+		SummarizedCount: summary.SummarizedCount + cut,
+		UpdatedAt:       time.Now(),
+	})
+}
+
+// unsummarized 返回适用于当前分支的摘要及当前分支上摘要之后的消息。
+// 摘要覆盖的消息不在当前分支上（例如切换或编辑了分支）时忽略摘要内容，保留其序号用于乐观锁。
+func (m *SummaryBufferMemory) unsummarized() (conversationSummary, []models.Message, error) {
+	summary, err := m.loadSummary()
+	if err != nil {
+		return conversationSummary{}, nil, err
+	}
+	records, err := m.store.Records()
+	if err != nil {
+		return conversationSummary{}, nil, err
+	}
+	if summary.CoveredSequence == 0 {
+		return summary, records, nil
+	}
+
+	for i, record := range records {
+		if record.Sequence == summary.CoveredSequence {
+			return summary, records[i+1:], nil
+		}
+	}
+	return conversationSummary{CoveredSequence: summary.CoveredSequence}, records, nil
+}
+
+// exceedsThreshold 判断未摘要的消息是否超过触发阈值
+func (m *SummaryBufferMemory) exceedsThreshold(records []models.Message) bool {
+	if m.config.SummaryTriggerMessages > 0 && len(records) > m.config.SummaryTriggerMessages {
+		return true
+	}
+	if m.config.SummaryTriggerTokens > 0 {
+		tokens := 0
+		for _, record := range records {
+			tokens += CountMessageTokens(m.tokenizer, RecordToMessage(record))
+		}
+		return tokens > m.config.SummaryTriggerTokens
+	}
+	return false
+}
+
+// loadSummary 读取对话元数据中的摘要
+func (m *SummaryBufferMemory) loadSummary() (conversationSummary, error) {
+	var conversation models.Conversation
+	if err := m.store.db.WithContext(m.store.ctx).
+		Select("id", "metadata").
+		First(&conversation, "id = ?", m.store.ConversationID()).Error; err != nil {
+		return conversationSummary{}, err
+	}
+
+	var summary conversationSummary
+	if raw, ok := conversation.Metadata[conversationSummaryKey]; ok {
+		if err := fromJSONValue(raw, &summary); err != nil {
+			return conversationSummary{}, fmt.Errorf("failed to decode conversation summary: %w", err)
+		}
+	}
+	return summary, nil
+}
+
+// saveSummary 以覆盖序号作为乐观锁写入摘要，其他实例已更新摘要时放弃本次结果
+func (m *SummaryBufferMemory) saveSummary(previousCovered int64, summary conversationSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	result := m.store.db.WithContext(m.store.ctx).
+		Model(&models.Conversation{}).
+		Where("id = ? AND COALESCE((metadata->'summary'->>'covered_sequence')::bigint, 0) = ?",
+			m.store.ConversationID(), previousCovered).
+		Update("metadata", gorm.Expr("jsonb_set(COALESCE(metadata, '{}'::jsonb), ?, ?::jsonb)",
+			"{"+conversationSummaryKey+"}", string(data)))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.logger.Info("Conversation summary was updated concurrently, discarding result",
+			zap.String("conversation_id", m.store.ConversationID().String()),
+			zap.Int64("covered_sequence", previousCovered))
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+func TestParseMemoryConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     models.JSONMap
+		want    MemoryStrategy
+		wantErr bool
+	}{
+		{"empty", nil, MemoryStrategyWindow, false},
+		{"strategy omitted", models.JSONMap{"summary_keep_messages": 5}, MemoryStrategyWindow, false},
+		{"token window", models.JSONMap{"strategy": "token_window"}, MemoryStrategyTokenWindow, false},
+		{"summary buffer", models.JSONMap{"strategy": "summary_buffer", "summary_trigger_tokens": 2000}, MemoryStrategySummaryBuffer, false},
+		{"unknown strategy", models.JSONMap{"strategy": "forever"}, "", true},
+		{"negative threshold", models.JSONMap{"summary_keep_messages": -1}, "", true},
+		{"summary buffer without trigger", models.JSONMap{"strategy": "summary_buffer", "summary_trigger_messages": 0}, "", true},
+		{"wrong type", models.JSONMap{"long_term_top_k": "five"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseMemoryConfig(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMemoryConfig error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && config.Strategy != tt.want {
+				t.Errorf("strategy = %s, want %s", config.Strategy, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseMemoryConfig(models.JSONMap{"strategy": "forever"}); !errors.Is(err, ErrUnknownMemoryStrategy) {
+		t.Errorf("unknown strategy error = %v, want %v", err, ErrUnknownMemoryStrategy)
+	}
+	config, _ := ParseMemoryConfig(models.JSONMap{"strategy": "summary_buffer", "summary_trigger_tokens": 2000})
+	if config.SummaryTriggerMessages != DefaultMemoryConfig().SummaryTriggerMessages {
+		t.Errorf("trigger messages = %d, want default", config.SummaryTriggerMessages)
+	}
+}
+
+func TestNewConversationMemory(t *testing.T) {
+	store := NewGormMemory(nil, uuid.Nil, 0)
+	tests := []struct {
+		name            string
+		config          models.JSONMap
+		wantMaxMessages int
+		wantSummary     bool
+	}{
+		{"window", nil, 20, false},
+		{"token window", models.JSONMap{"strategy": "token_window"}, 0, false},
+		{"summary buffer", models.JSONMap{"strategy": "summary_buffer"}, 0, true},
+		{"invalid config falls back to window", models.JSONMap{"strategy": "forever"}, 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &models.Agent{MaxHistoryLength: 20, MemoryConfig: tt.config}
+			memory := NewConversationMemory(store, record, &Agent{})
+			if summary, ok := memory.(*SummaryBufferMemory); ok != tt.wantSummary {
+				t.Fatalf("memory = %T, want summary buffer %v", memory, tt.wantSummary)
+			} else if ok {
+				if summary.store.maxMessages != 0 {
+					t.Errorf("summary store max messages = %d, want 0", summary.store.maxMessages)
+				}
+				return
+			}
+			if got := memory.(*GormMemory).maxMessages; got != tt.wantMaxMessages {
+				t.Errorf("max messages = %d, want %d", got, tt.wantMaxMessages)
+			}
+		})
+	}
+}
+
+// stubSummarizer 记录摘要请求，返回固定格式的摘要
+type stubSummarizer struct {
+	calls    [][]eino.Message
+	previous []string
+	err      error
+}
+
+func (s *stubSummarizer) Summarize(ctx context.Context, previousSummary string, messages []eino.Message) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	s.calls = append(s.calls, messages)
+	s.previous = append(s.previous, previousSummary)
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	return strings.Join(contents, ","), nil
+}
+
+// memoryContents 返回记忆中各条消息的内容
+func memoryContents(t *testing.T, memory Memory) []string {
+	t.Helper()
+	messages, err := memory.GetMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	return contents
+}
+
+func TestSummaryBufferMemory(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+	summarizer := &stubSummarizer{}
+	store := NewGormMemory(db, conversation.ID, 0)
+	memory := NewSummaryBufferMemory(store, summarizer, approxTokenizer{}, MemoryConfig{
+		Strategy:               MemoryStrategySummaryBuffer,
+		SummaryTriggerMessages: 4,
+		SummaryKeepMessages:    2,
+	})
+
+	for _, msg := range []eino.Message{userMessage("q1"), assistantMessage("a1"), userMessage("q2"), assistantMessage("a2")} {
+		if err := memory.AddMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(summarizer.calls) != 0 {
+		t.Fatalf("summarized %d times below the threshold", len(summarizer.calls))
+	}
+
+	// 超过阈值后，切分点移动到最近的用户消息，之前的消息并入摘要
+	if err := memory.AddMessage(userMessage("q3")); err != nil {
+		t.Fatal(err)
+	}
+	if len(summarizer.calls) != 1 || len(summarizer.calls[0]) != 4 {
+		t.Fatalf("summarizer calls = %v, want one call with 4 messages", summarizer.calls)
+	}
+	want := []string{"以下是此前对话的摘要：\nq1,a1,q2,a2", "q3"}
+	if got := memoryContents(t, memory); !equalStrings(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+
+	// 再次摘要时合并已有摘要
+	for _, msg := range []eino.Message{assistantMessage("a3"), userMessage("q4"), assistantMessage("a4"), userMessage("q5"), assistantMessage("a5"), userMessage("q6")} {
+		if err := memory.AddMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(summarizer.calls) != 2 || summarizer.previous[1] != "q1,a1,q2,a2" {
+		t.Fatalf("second summary previous = %q, want first summary", summarizer.previous)
+	}
+	want = []string{"以下是此前对话的摘要：\nq3,a3,q4,a4", "q5", "a5", "q6"}
+	if got := memoryContents(t, memory); !equalStrings(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+
+	// 摘要覆盖的消息不在当前分支上时忽略摘要
+	addTestMessages(t, store.WithBranch(nil), userMessage("fresh"))
+	if got := memoryContents(t, memory); !equalStrings(got, []string{"fresh"}) {
+		t.Errorf("messages on new branch = %q, want only fresh", got)
+	}
+
+	if err := memory.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	var stored models.Conversation
+	if err := db.First(&stored, "id = ?", conversation.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Metadata[conversationSummaryKey]; ok {
+		t.Errorf("summary kept after Clear: %v", stored.Metadata)
+	}
+}
+
+func TestSummaryBufferMemorySummarizerError(t *testing.T) {
+	db := openTestDB(t)
+	conversation := createTestConversation(t, db)
+	memory := NewSummaryBufferMemory(NewGormMemory(db, conversation.ID, 0), &stubSummarizer{err: errors.New("model unavailable")},
+		approxTokenizer{}, MemoryConfig{Strategy: MemoryStrategySummaryBuffer, SummaryTriggerTokens: 1})
+
+	// 摘要失败不影响消息写入，历史保留原文
+	for _, msg := range []eino.Message{userMessage("q1"), assistantMessage("a1"), userMessage("q2")} {
+		if err := memory.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage: %v", err)
+		}
+	}
+	if got, want := memoryContents(t, memory), []string{"q1", "a1", "q2"}; !equalStrings(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
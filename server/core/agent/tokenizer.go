@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/cloudwego/eino"
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+	"go.uber.org/zap"
+)
+
+// 按OpenAI的对话格式，每条消息在内容之外约有4个token的开销，回复前缀约3个token
+const (
+	messageTokenOverhead = 4
+	replyTokenOverhead   = 3
+)
+
+// defaultEncoding 无法识别模型时使用的BPE编码
+const defaultEncoding = "cl100k_base"
+
+// Tokenizer 统计和截断文本的token
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+	// Truncate 截断文本使其不超过 maxTokens 个token
+	Truncate(text string, maxTokens int) string
+}
+
+func init() {
+	// 使用内置的BPE文件，避免运行时下载
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+var (
+	tokenizerCache   = make(map[string]Tokenizer)
+	tokenizerCacheMu sync.Mutex
+)
+
+// TokenizerFor 返回模型对应的分词器：OpenAI模型使用tiktoken兼容的BPE，其余模型使用估算
+func TokenizerFor(provider, model string) Tokenizer {
+	if provider != "openai" && provider != "azure" {
+		return approxTokenizer{}
+	}
+
+	key := provider + "/" + model
+	tokenizerCacheMu.Lock()
+	defer tokenizerCacheMu.Unlock()
+
+	if tokenizer, ok := tokenizerCache[key]; ok {
+		return tokenizer
+	}
+
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		enc, err = tiktoken.GetEncoding(defaultEncoding)
+	}
+
+	var tokenizer Tokenizer = approxTokenizer{}
+	if err != nil {
+		zap.L().Warn("Failed to load BPE encoding, falling back to estimation",
+			zap.String("model", model), zap.Error(err))
+	} else {
+		tokenizer = &bpeTokenizer{name: model, enc: enc}
+	}
+	tokenizerCache[key] = tokenizer
+	return tokenizer
+}
+
+// bpeTokenizer 基于tiktoken的精确分词器
+type bpeTokenizer struct {
+	name string
+	enc  *tiktoken.Tiktoken
+}
+
+func (t *bpeTokenizer) Name() string {
+	return "tiktoken:" + t.name
+}
+
+func (t *bpeTokenizer) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	return len(t.enc.Encode(text, nil, nil))
+}
+
+func (t *bpeTokenizer) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	tokens := t.enc.Encode(text, nil, nil)
+	if len(tokens) <= maxTokens {
+		return text
+	}
+	// 截断点可能落在多字节字符中间，去掉不完整的字符
+	return strings.ToValidUTF8(t.enc.Decode(tokens[:maxTokens]), "")
+}
+
+// approxTokenizer 估算分词器：中日韩字符按每字1个token，其他字符按每4个字符1个token
+type approxTokenizer struct{}
+
+func (approxTokenizer) Name() string {
+	return "approx"
+}
+
+func (approxTokenizer) Count(text string) int {
+	var cost float64
+	for _, r := range text {
+		cost += runeCost(r)
+	}
+	return int(math.Ceil(cost))
+}
+
+func (approxTokenizer) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	var cost float64
+	for i, r := range text {
+		cost += runeCost(r)
+		if math.Ceil(cost) > float64(maxTokens) {
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// runeCost 估算单个字符的token数
+func runeCost(r rune) float64 {
+	if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+		return 1
+	}
+	return 0.25
+}
+
+// CountMessageTokens 统计单条消息的token数，包括工具调用参数
+func CountMessageTokens(tokenizer Tokenizer, msg eino.Message) int {
+	total := messageTokenOverhead + tokenizer.Count(msg.Role) + tokenizer.Count(msg.Content)
+	for _, call := range msg.ToolCalls {
+		total += tokenizer.Count(call.ID) + tokenizer.Count(call.Name) + tokenizer.Count(call.Arguments)
+	}
+	total += tokenizer.Count(msg.ToolCallID) + tokenizer.Count(msg.Error)
+	return total
+}
+
+// CountMessagesTokens 统计一组消息作为请求发送时的token数
+func CountMessagesTokens(tokenizer Tokenizer, messages []eino.Message) int {
+	total := replyTokenOverhead
+	for _, msg := range messages {
+		total += CountMessageTokens(tokenizer, msg)
+	}
+	return total
+}
+
+// CountToolsTokens 估算工具定义占用的token数
+func CountToolsTokens(tokenizer Tokenizer, tools []Tool) int {
+	total := 0
+	for _, tool := range tools {
+		params, err := json.Marshal(tool.Parameters)
+		if err != nil {
+			params = nil
+		}
+		total += tokenizer.Count(tool.Name) + tokenizer.Count(tool.Description) + tokenizer.Count(string(params))
+	}
+	return total
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino"
+)
+
+func TestApproxTokenizer(t *testing.T) {
+	tokenizer := approxTokenizer{}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"你好", 2},
+		{"你好ab", 3},
+		{"こんにちは", 5},
+	}
+	for _, tt := range tests {
+		if got := tokenizer.Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+
+	truncates := []struct {
+		text      string
+		maxTokens int
+		want      string
+	}{
+		{"abcdefgh", 1, "abcd"},
+		{"abcdefgh", 0, ""},
+		{"你好世界", 2, "你好"},
+		{"short", 10, "short"},
+	}
+	for _, tt := range truncates {
+		if got := tokenizer.Truncate(tt.text, tt.maxTokens); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.maxTokens, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizerFor(t *testing.T) {
+	if got := TokenizerFor("anthropic", "claude").Name(); got != "approx" {
+		t.Errorf("tokenizer for anthropic = %s, want approx", got)
+	}
+
+	tokenizer := TokenizerFor("openai", "gpt-4")
+	if !strings.HasPrefix(tokenizer.Name(), "tiktoken:") {
+		t.Fatalf("tokenizer for gpt-4 = %s, want tiktoken", tokenizer.Name())
+	}
+	if TokenizerFor("openai", "gpt-4") != tokenizer {
+		t.Error("tokenizer for the same model was not cached")
+	}
+	// 未知模型回退到默认编码
+	if name := TokenizerFor("openai", "unknown-model").Name(); !strings.HasPrefix(name, "tiktoken:") {
+		t.Errorf("tokenizer for unknown model = %s, want tiktoken", name)
+	}
+
+	if got := tokenizer.Count("hello world"); got != 2 {
+		t.Errorf("Count(hello world) = %d, want 2", got)
+	}
+	text := strings.Repeat("上下文窗口", 20)
+	truncated := tokenizer.Truncate(text, 5)
+	if !utf8.ValidString(truncated) || !strings.HasPrefix(text, truncated) {
+		t.Errorf("Truncate = %q, want a valid prefix", truncated)
+	}
+	if got := tokenizer.Count(truncated); got > 5 {
+		t.Errorf("truncated text has %d tokens, want at most 5", got)
+	}
+}
+
+func TestCountMessageTokens(t *testing.T) {
+	tokenizer := approxTokenizer{}
+	plain := eino.Message{Role: "user", Content: "abcdefgh"}
+	if got, want := CountMessageTokens(tokenizer, plain), messageTokenOverhead+1+2; got != want {
+		t.Errorf("CountMessageTokens(plain) = %d, want %d", got, want)
+	}
+
+	withCall := plain
+	withCall.ToolCalls = []eino.ToolCall{{ID: "c1", Name: "calc", Arguments: `{"x":12}`}}
+	if got, want := CountMessageTokens(tokenizer, withCall), CountMessageTokens(tokenizer, plain)+1+1+2; got != want {
+		t.Errorf("CountMessageTokens(tool call) = %d, want %d", got, want)
+	}
+
+	messages := []eino.Message{plain, plain}
+	if got, want := CountMessagesTokens(tokenizer, messages), replyTokenOverhead+2*CountMessageTokens(tokenizer, plain); got != want {
+		t.Errorf("CountMessagesTokens = %d, want %d", got, want)
+	}
+
+	tools := []Tool{{Name: "calc", Description: "adds", Parameters: map[string]interface{}{}}}
+	if got := CountToolsTokens(tokenizer, tools); got != 1+1+1 {
+		t.Errorf("CountToolsTokens = %d, want 3", got)
+	}
+}
@@ -25,6 +25,7 @@ const (
 	CategoryConnector  ToolCategory = "connector"  // 外部连接器
 	CategoryDeveloper  ToolCategory = "developer"  // 开发者工具
 	CategoryCustom     ToolCategory = "custom"     // 自定义工具
+	CategoryCodeInterpreter ToolCategory = "code_interpreter" // 代码解释器
 )
 
 // ToolRegistry 管理系统中所有可用的工具
@@ -177,6 +178,8 @@ type Tool struct {
 	Category    ToolCategory           `json:"category"`
 	IsBuiltin   bool                   `json:"is_builtin"`
 	Version     string                 `json:"version,omitempty"`
+	// RequiresApproval 非空时按调用参数判断是否需要人工审批
+	RequiresApproval ApprovalPolicy `json:"-"`
 }
 
 // RegisterWebSearchTool 注册网络搜索工具
@@ -381,6 +384,8 @@ func (r *ToolRegistry) RegisterHttpRequestTool() error {
 		Category:    CategoryConnector,
 		IsBuiltin:   true,
 		Version:     "1.0",
+		// 会修改远端状态的请求需要用户确认
+		RequiresApproval: RequireApprovalForMethods("POST", "PUT", "PATCH", "DELETE"),
 		Parameters: map[string]interface{}{
 			"url": map[string]interface{}{
 				"type":        "string",
@@ -436,7 +441,7 @@ func (r *ToolRegistry) RegisterHttpRequestTool() error {
 			}
 			
 			// 执行请求
-			resp, err := r.httpClient.Do(req)
+			resp, err := r.clientFor(ctx).Do(req)
 			if err != nil {
 				return nil, err
 			}
@@ -463,6 +468,7 @@ func (r *ToolRegistry) RegisterFileReadTool(basePath string) error {
 		Category:    CategoryDeveloper,
 		IsBuiltin:   true,
 		Version:     "1.0",
+		RequiresApproval: AlwaysRequireApproval,
 		Parameters: map[string]interface{}{
 			"path": map[string]interface{}{
 				"type":        "string",
@@ -502,6 +508,52 @@ func (r *ToolRegistry) RegisterFileReadTool(basePath string) error {
 	return r.RegisterTool(fileTool)
 }
 
+// RegisterCodeInterpreterTool 注册代码解释器工具，代码在沙箱中执行
+func (r *ToolRegistry) RegisterCodeInterpreterTool(sandbox *Sandbox) error {
+	if sandbox == nil {
+		sandbox = NewSandbox(DefaultSandboxConfig())
+	}
+
+	codeTool := Tool{
+		Name:        "code_interpreter",
+		Description: "在隔离的沙箱中执行Python或JavaScript代码，用于数据分析和计算。对话中上传的文件以只读方式位于 input/ 目录，写入工作目录的文件会作为结果返回",
+		Category:    CategoryCodeInterpreter,
+		IsBuiltin:   true,
+		Version:     "1.0",
+		Parameters: map[string]interface{}{
+			"language": map[string]interface{}{
+				"type":        "string",
+				"description": "代码语言",
+				"enum":        []string{string(SandboxPython), string(SandboxJavaScript)},
+				"default":     string(SandboxPython),
+			},
+			"code": map[string]interface{}{
+				"type":        "string",
+				"description": "要执行的代码，通过print/console.log输出结果",
+			},
+		},
+		Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			code, ok := params["code"].(string)
+			if !ok || code == "" {
+				return nil, errors.New("code parameter must be a non-empty string")
+			}
+
+			language := SandboxPython
+			if langParam, ok := params["language"].(string); ok && langParam != "" {
+				language = SandboxLanguage(langParam)
+			}
+
+			return sandbox.Run(ctx, SandboxRequest{
+				Language: language,
+				Code:     code,
+				Files:    SandboxFilesFromContext(ctx),
+			})
+		},
+	}
+
+	return r.RegisterTool(codeTool)
+}
+
 // isPathSafe 检查给定路径是否在允许的基础路径�?
 func isPathSafe(path, basePath string) bool {
 	absPath, err := filepath.Abs(path)
@@ -547,6 +599,8 @@ func (r *ToolRegistry) RegisterBatchTools(toolNames []string) error {
 			err = r.RegisterTimezoneTool()
 		case "http_request":
 			err = r.RegisterHttpRequestTool()
+		case "code_interpreter":
+			err = r.RegisterCodeInterpreterTool(nil)
 		default:
 			zap.L().Warn("Unknown built-in tool", zap.String("name", name))
 			continue
@@ -595,6 +649,7 @@ func (r *ToolRegistry) ExportToolSpecifications() ([]byte, error) {
 			"parameters":  tool.Parameters,
 			"category":    tool.Category,
 			"is_builtin":  tool.IsBuiltin,
+			"requires_approval": tool.RequiresApproval != nil,
 		}
 		if tool.Version != "" {
 			spec["version"] = tool.Version
@@ -607,3 +662,19 @@ func (r *ToolRegistry) ExportToolSpecifications() ([]byte, error) {
 
 // DefaultToolRegistry 默认的工具注册表实例
 var DefaultToolRegistry = NewToolRegistry() 
+
+// httpClientKey 是上下文中 HTTP 客户端的键
+type httpClientKey struct{}
+
+// WithHTTPClient 指定联网工具在该上下文中使用的 HTTP 客户端，例如带内网地址限制的客户端
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientKey{}, client)
+}
+
+// clientFor 返回上下文指定的 HTTP 客户端，未指定时使用注册表默认客户端
+func (r *ToolRegistry) clientFor(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(httpClientKey{}).(*http.Client); ok && client != nil {
+		return client
+	}
+	return r.httpClient
+}
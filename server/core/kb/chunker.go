@@ -0,0 +1,543 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+)
+
+// 分块策略
+const (
+	ChunkStrategyRecursive = "recursive" // 依次按段落、换行、句子、字符递归切分，按字符数计长度
+	ChunkStrategyToken     = "token"     // 切分方式同 recursive，按token数计长度
+	ChunkStrategyMarkdown  = "markdown"  // 按Markdown标题切分，分块携带标题路径
+	ChunkStrategySentence  = "sentence"  // 按中英文句子切分后合并
+	ChunkStrategySemantic  = "semantic"  // 在相邻句子向量差异最大处切分
+)
+
+// DefaultChunkSize 默认的分块大小，token 策略按token计，其余策略按字符计
+const DefaultChunkSize = 1000
+
+// DefaultChunkOverlap 默认的分块重叠大小
+const DefaultChunkOverlap = 200
+
+// defaultBreakpointPercentile 语义分块中相邻句子距离超过该百分位时切分
+const defaultBreakpointPercentile = 95
+
+// semanticEmbeddingBatchSize 语义分块每批向量化的句子数
+const semanticEmbeddingBatchSize = 64
+
+// ErrUnsupportedChunkStrategy 不支持的分块策略或参数
+var ErrUnsupportedChunkStrategy = errors.New("unsupported chunk strategy")
+
+// defaultSeparators 递归切分使用的分隔符，优先级从高到低，空字符串表示按字符切分
+var defaultSeparators = []string{
+	"\n\n", "\n",
+	"。", "！", "？", "；", ". ", "! ", "? ", "; ",
+	"，", "、", ", ",
+	" ", "",
+}
+
+// markdownHeading Markdown ATX标题
+var markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+
+// ChunkingConfig 分块配置，按知识库设置
+type ChunkingConfig struct {
+	Strategy       string
+	Size           int
+	Overlap        int
+	EmbeddingModel string // semantic 策略计算句子向量使用的模型
+}
+
+// TextPiece 分块器切分出的文本片段
+type TextPiece struct {
+	Content  string
+	Headings []string // 所在的Markdown标题路径，从一级标题开始
+}
+
+// Chunker 文本分块器
+type Chunker interface {
+	// Split 将文本切分为片段
+	Split(ctx context.Context, text string) ([]TextPiece, error)
+}
+
+// NewChunker 按配置创建分块器，未设置的大小和策略使用默认值
+func NewChunker(config ChunkingConfig, embedding *EmbeddingManager) (Chunker, error) {
+	if config.Size <= 0 {
+		config.Size = DefaultChunkSize
+	}
+	if config.Overlap < 0 || config.Overlap >= config.Size {
+		return nil, fmt.Errorf("%w: overlap must be between 0 and chunk size", ErrUnsupportedChunkStrategy)
+	}
+
+	recursive := &RecursiveChunker{Size: config.Size, Overlap: config.Overlap, Length: utf8.RuneCountInString}
+	switch config.Strategy {
+	case "", ChunkStrategyRecursive:
+		return recursive, nil
+	case ChunkStrategyToken:
+		// 各向量模型的分词不尽相同，统一按 cl100k 编码计数
+		tokenizer := agent.TokenizerFor("openai", "text-embedding-3-small")
+		recursive.Length = tokenizer.Count
+		return recursive, nil
+	case ChunkStrategyMarkdown:
+		return &MarkdownChunker{splitter: recursive}, nil
+	case ChunkStrategySentence:
+		return &SentenceChunker{Size: config.Size, Overlap: config.Overlap, splitter: recursive}, nil
+	case ChunkStrategySemantic:
+		if embedding == nil {
+			return nil, fmt.Errorf("%w: semantic chunking requires an embedding manager", ErrUnsupportedChunkStrategy)
+		}
+		if _, err := embedding.GetModel(config.EmbeddingModel); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedChunkStrategy, err)
+		}
+		return &SemanticChunker{
+			Size:                 config.Size,
+			Embedding:            embedding,
+			Model:                config.EmbeddingModel,
+			BreakpointPercentile: defaultBreakpointPercentile,
+			splitter:             recursive,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedChunkStrategy, config.Strategy)
+	}
+}
+
+// ChunkSections 依次切分各段文本，分块不跨越段落边界，以便保留页码
+func ChunkSections(ctx context.Context, doc *Document, sections []Section, chunker Chunker) ([]Chunk, error) {
+	var chunks []Chunk
+	for _, section := range sections {
+		pieces, err := chunker.Split(ctx, section.Text)
+		if err != nil {
+			return nil, err
+		}
+		for _, piece := range pieces {
+			chunk := Chunk{
+				ID:         uuid.New().String(),
+				DocumentID: doc.ID,
+				Content:    piece.Content,
+			}
+			chunk.Metadata.ChunkIndex = len(chunks)
+			chunk.Metadata.PageNumber = section.PageNumber
+			chunk.Metadata.Source = doc.Name
+			chunk.Metadata.Headings = piece.Headings
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, nil
+}
+
+// RecursiveChunker 递归字符分块器：按优先级最高的分隔符切分，过长的片段用下一级分隔符继续切分，
+// 再将片段合并为不超过 Size 的分块，相邻分块重叠约 Overlap
+type RecursiveChunker struct {
+	Size       int
+	Overlap    int
+	Separators []string         // 为空时使用默认分隔符
+	Length     func(string) int // 长度计算方式，为空时按字符数计
+}
+
+// Split 将文本切分为片段
+func (c *RecursiveChunker) Split(ctx context.Context, text string) ([]TextPiece, error) {
+	pieces := make([]TextPiece, 0)
+	for _, content := range c.splitText(text) {
+		pieces = append(pieces, TextPiece{Content: content})
+	}
+	return pieces, nil
+}
+
+// splitText 切分文本，返回去除首尾空白后的非空分块
+func (c *RecursiveChunker) splitText(text string) []string {
+	separators := c.Separators
+	if len(separators) == 0 {
+		separators = defaultSeparators
+	}
+	return c.split(text, separators)
+}
+
+// split 用第一个出现在文本中的分隔符切分，分隔符保留在片段末尾
+func (c *RecursiveChunker) split(text string, separators []string) []string {
+	separator, rest := "", []string(nil)
+	for i, sep := range separators {
+		if sep == "" || strings.Contains(text, sep) {
+			separator, rest = sep, separators[i+1:]
+			break
+		}
+	}
+
+	var result, pending []string
+	for _, part := range splitKeepSeparator(text, separator) {
+		if c.length(part) <= c.Size {
+			pending = append(pending, part)
+			continue
+		}
+		if len(pending) > 0 {
+			result = append(result, mergeSplits(pending, c.Size, c.Overlap, c.length, joinPlain)...)
+			pending = nil
+		}
+		if len(rest) == 0 {
+			// 无法继续切分，单个字符也超过大小时原样保留
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+			continue
+		}
+		result = append(result, c.split(part, rest)...)
+	}
+	if len(pending) > 0 {
+		result = append(result, mergeSplits(pending, c.Size, c.Overlap, c.length, joinPlain)...)
+	}
+	return result
+}
+
+// length 计算文本长度
+func (c *RecursiveChunker) length(text string) int {
+	if c.Length == nil {
+		return utf8.RuneCountInString(text)
+	}
+	return c.Length(text)
+}
+
+// MarkdownChunker Markdown分块器：按标题切分为小节，小节过长时递归切分，分块携带所在的标题路径
+type MarkdownChunker struct {
+	splitter *RecursiveChunker
+}
+
+// Split 将文本切分为片段
+func (c *MarkdownChunker) Split(ctx context.Context, text string) ([]TextPiece, error) {
+	pieces := make([]TextPiece, 0)
+	var path []string
+	var lines []string
+	var headings []string
+	hasBody := false
+	fence := ""
+
+	// finish 切分当前小节；只有标题没有正文的小节不单独成块，标题由下级小节的标题路径保留
+	finish := func() {
+		if !hasBody {
+			return
+		}
+		contents := c.splitter.splitText(strings.Join(lines, "\n"))
+		// 小节过长时标题行可能被单独切出，并入下一个分块
+		if len(contents) > 1 && markdownHeading.MatchString(strings.TrimSpace(contents[0])) {
+			contents[1] = contents[0] + "\n\n" + contents[1]
+			contents = contents[1:]
+		}
+		for _, content := range contents {
+			pieces = append(pieces, TextPiece{Content: content, Headings: headings})
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		// 代码块中的 # 不是标题
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+		default:
+			if match := markdownHeading.FindStringSubmatch(trimmed); match != nil {
+				finish()
+
+				level := len(match[1])
+				if len(path) >= level {
+					path = path[:level-1]
+				}
+				for len(path) < level-1 {
+					path = append(path, "")
+				}
+				path = append(path, match[2])
+
+				headings = compactHeadings(path)
+				lines = []string{line}
+				hasBody = false
+				continue
+			}
+		}
+
+		lines = append(lines, line)
+		if trimmed != "" {
+			hasBody = true
+		}
+	}
+	finish()
+
+	return pieces, nil
+}
+
+// compactHeadings 复制标题路径并去除跳级产生的空标题
+func compactHeadings(path []string) []string {
+	headings := make([]string, 0, len(path))
+	for _, heading := range path {
+		if heading != "" {
+			headings = append(headings, heading)
+		}
+	}
+	return headings
+}
+
+// SentenceChunker 句子分块器：按中英文句末标点切分句子，再合并为不超过 Size 字符的分块，
+// 重叠部分由完整的句子组成
+type SentenceChunker struct {
+	Size     int
+	Overlap  int
+	splitter *RecursiveChunker // 切分超过 Size 的长句
+}
+
+// Split 将文本切分为片段
+func (c *SentenceChunker) Split(ctx context.Context, text string) ([]TextPiece, error) {
+	var sentences []string
+	for _, sentence := range splitSentences(text) {
+		if utf8.RuneCountInString(sentence) > c.Size {
+			sentences = append(sentences, c.splitter.splitText(sentence)...)
+			continue
+		}
+		sentences = append(sentences, sentence)
+	}
+
+	pieces := make([]TextPiece, 0)
+	for _, content := range mergeSplits(sentences, c.Size, c.Overlap, utf8.RuneCountInString, joinSentences) {
+		pieces = append(pieces, TextPiece{Content: content})
+	}
+	return pieces, nil
+}
+
+// SemanticChunker 语义分块器：对每个句子（连同前后各一句）向量化，
+// 相邻句子的余弦距离超过 BreakpointPercentile 百分位处切分，分块同时不超过 Size 字符
+type SemanticChunker struct {
+	Size                 int
+	Embedding            *EmbeddingManager
+	Model                string
+	BreakpointPercentile float64
+	splitter             *RecursiveChunker // 切分超过 Size 的长句
+}
+
+// Split 将文本切分为片段
+func (c *SemanticChunker) Split(ctx context.Context, text string) ([]TextPiece, error) {
+	var sentences []string
+	for _, sentence := range splitSentences(text) {
+		if utf8.RuneCountInString(sentence) > c.Size {
+			sentences = append(sentences, c.splitter.splitText(sentence)...)
+			continue
+		}
+		sentences = append(sentences, sentence)
+	}
+	if len(sentences) < 3 {
+		return c.splitter.Split(ctx, text)
+	}
+
+	// 句子连同前后各一句一起向量化，减少短句带来的噪声
+	windows := make([]string, len(sentences))
+	for i := range sentences {
+		start, end := i-1, i+2
+		if start < 0 {
+			start = 0
+		}
+		if end > len(sentences) {
+			end = len(sentences)
+		}
+		windows[i] = joinSentences(sentences[start:end])
+	}
+
+	embeddings := make([][]float32, 0, len(windows))
+	for start := 0; start < len(windows); start += semanticEmbeddingBatchSize {
+		end := start + semanticEmbeddingBatchSize
+		if end > len(windows) {
+			end = len(windows)
+		}
+		resp, err := c.Embedding.Embed(ctx, EmbeddingRequest{Texts: windows[start:end], Model: c.Model})
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed sentences: %w", err)
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, fmt.Errorf("embedding model returned %d vectors for %d sentence windows", len(resp.Embeddings), end-start)
+		}
+		embeddings = append(embeddings, resp.Embeddings...)
+	}
+
+	distances := make([]float64, len(sentences)-1)
+	for i := range distances {
+		distances[i] = 1 - float64(calculateCosineSimilarity(embeddings[i], embeddings[i+1]))
+	}
+	threshold := percentile(distances, c.BreakpointPercentile)
+
+	pieces := make([]TextPiece, 0)
+	var group []string
+	size := 0
+	for i, sentence := range sentences {
+		length := utf8.RuneCountInString(sentence)
+		if len(group) > 0 && size+length > c.Size {
+			pieces = append(pieces, TextPiece{Content: joinSentences(group)})
+			group, size = nil, 0
+		}
+		group = append(group, sentence)
+		size += length
+
+		// 距离严格大于阈值才切分，向量完全相同时只按大小切分
+		if i < len(distances) && distances[i] > threshold {
+			pieces = append(pieces, TextPiece{Content: joinSentences(group)})
+			group, size = nil, 0
+		}
+	}
+	if len(group) > 0 {
+		pieces = append(pieces, TextPiece{Content: joinSentences(group)})
+	}
+	return pieces, nil
+}
+
+// percentile 计算线性插值的百分位数
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.Inf(1)
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// splitSentences 按中英文句末标点和换行切分句子，句末的引号和括号归入当前句
+func splitSentences(text string) []string {
+	var sentences []string
+	var current strings.Builder
+	runes := []rune(text)
+
+	emit := func() {
+		if sentence := strings.TrimSpace(current.String()); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\n' {
+			emit()
+			continue
+		}
+		current.WriteRune(r)
+
+		if !isSentenceEnd(runes, i) {
+			continue
+		}
+		// 连续的句末标点（如 "？！"、"……"）和收尾的引号、括号归入当前句
+		for i+1 < len(runes) && (isTerminator(runes[i+1]) || isClosing(runes[i+1])) {
+			i++
+			current.WriteRune(runes[i])
+		}
+		emit()
+	}
+	emit()
+	return sentences
+}
+
+// isSentenceEnd 判断位置 i 的字符是否结束一个句子；英文句点后需要跟空白或位于文本末尾，避免切开小数和缩写
+func isSentenceEnd(runes []rune, i int) bool {
+	switch runes[i] {
+	case '。', '！', '？', '；', '…':
+		return true
+	case '.', '!', '?', ';':
+		return i+1 == len(runes) || unicode.IsSpace(runes[i+1]) || isClosing(runes[i+1])
+	}
+	return false
+}
+
+// isTerminator 句末标点
+func isTerminator(r rune) bool {
+	return strings.ContainsRune("。！？；….!?;", r)
+}
+
+// isClosing 收尾的引号和括号
+func isClosing(r rune) bool {
+	return strings.ContainsRune("”’」』）》】\"')]", r)
+}
+
+// splitKeepSeparator 按分隔符切分并将分隔符保留在片段末尾，分隔符为空时按字符切分
+func splitKeepSeparator(text, separator string) []string {
+	if separator == "" {
+		parts := make([]string, 0, utf8.RuneCountInString(text))
+		for _, r := range text {
+			parts = append(parts, string(r))
+		}
+		return parts
+	}
+
+	var parts []string
+	for _, part := range strings.SplitAfter(text, separator) {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// mergeSplits 将片段依次合并为长度不超过 size 的分块，新分块以上一分块末尾不超过 overlap 的片段开头
+func mergeSplits(splits []string, size, overlap int, length func(string) int, join func([]string) string) []string {
+	var chunks []string
+	var current []string
+	total := 0
+
+	emit := func() {
+		if chunk := strings.TrimSpace(join(current)); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	for _, split := range splits {
+		l := length(split)
+		if total+l > size && len(current) > 0 {
+			emit()
+			for len(current) > 0 && (total > overlap || total+l > size) {
+				total -= length(current[0])
+				current = current[1:]
+			}
+		}
+		current = append(current, split)
+		total += l
+	}
+	if len(current) > 0 {
+		emit()
+	}
+	return chunks
+}
+
+// joinPlain 直接拼接保留了分隔符的片段
+func joinPlain(parts []string) string {
+	return strings.Join(parts, "")
+}
+
+// joinSentences 拼接句子，两侧都不是中日韩文字时以空格分隔
+func joinSentences(sentences []string) string {
+	var builder strings.Builder
+	for i, sentence := range sentences {
+		if i > 0 {
+			last, _ := utf8.DecodeLastRuneInString(sentences[i-1])
+			first, _ := utf8.DecodeRuneInString(sentence)
+			if !isCJK(last) && !isCJK(first) {
+				builder.WriteString(" ")
+			}
+		}
+		builder.WriteString(sentence)
+	}
+	return builder.String()
+}
+
+// isCJK 判断是否为中日韩文字或全角标点
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r) || (r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
@@ -0,0 +1,253 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// countingEmbeddingModel 按 cat 和 car 的出现次数生成向量，用于测试语义分块
+type countingEmbeddingModel struct {
+	drop int // 返回的向量比请求少 drop 个
+}
+
+func (m *countingEmbeddingModel) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts[:len(texts)-m.drop] {
+		vectors = append(vectors, []float32{float32(strings.Count(text, "cat")), float32(strings.Count(text, "car"))})
+	}
+	return vectors, nil
+}
+
+func (m *countingEmbeddingModel) Dimensions() int   { return 2 }
+func (m *countingEmbeddingModel) ModelName() string { return "counting" }
+
+func TestNewChunker(t *testing.T) {
+	embedding := NewEmbeddingManager()
+	embedding.RegisterModel("mock", NewMockEmbeddingModel(4, "mock"))
+
+	tests := []struct {
+		name      string
+		config    ChunkingConfig
+		embedding *EmbeddingManager
+		wantErr   bool
+	}{
+		{"default", ChunkingConfig{}, nil, false},
+		{"recursive", ChunkingConfig{Strategy: ChunkStrategyRecursive, Size: 100, Overlap: 0}, nil, false},
+		{"token", ChunkingConfig{Strategy: ChunkStrategyToken, Size: 100, Overlap: 10}, nil, false},
+		{"markdown", ChunkingConfig{Strategy: ChunkStrategyMarkdown, Size: 100}, nil, false},
+		{"sentence", ChunkingConfig{Strategy: ChunkStrategySentence, Size: 100}, nil, false},
+		{"semantic", ChunkingConfig{Strategy: ChunkStrategySemantic, Size: 100, EmbeddingModel: "mock"}, embedding, false},
+		{"negative overlap", ChunkingConfig{Size: 100, Overlap: -1}, nil, true},
+		{"overlap not smaller than size", ChunkingConfig{Size: 100, Overlap: 100}, nil, true},
+		{"overlap with default size", ChunkingConfig{Overlap: DefaultChunkSize}, nil, true},
+		{"unknown strategy", ChunkingConfig{Strategy: "paragraph"}, nil, true},
+		{"semantic without embedding manager", ChunkingConfig{Strategy: ChunkStrategySemantic, EmbeddingModel: "mock"}, nil, true},
+		{"semantic with unknown model", ChunkingConfig{Strategy: ChunkStrategySemantic, EmbeddingModel: "missing"}, embedding, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewChunker(tt.config, tt.embedding)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewChunker error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrUnsupportedChunkStrategy) {
+				t.Errorf("NewChunker error = %v, want %v", err, ErrUnsupportedChunkStrategy)
+			}
+		})
+	}
+}
+
+func TestRecursiveChunker(t *testing.T) {
+	var sentences []string
+	for i := 0; i < 10; i++ {
+		sentences = append(sentences, "sentence number "+string(rune('a'+i))+".")
+	}
+	text := strings.Join(sentences, " ")
+
+	chunker := &RecursiveChunker{Size: 40, Overlap: 20}
+	pieces, err := chunker.Split(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) < 2 {
+		t.Fatalf("Split = %d pieces, want more than one", len(pieces))
+	}
+
+	joined := ""
+	for _, piece := range pieces {
+		if n := utf8.RuneCountInString(piece.Content); n > chunker.Size {
+			t.Errorf("piece %q has %d runes, want at most %d", piece.Content, n, chunker.Size)
+		}
+		joined += piece.Content + " "
+	}
+	for _, sentence := range sentences {
+		if !strings.Contains(joined, sentence) {
+			t.Errorf("sentence %q missing from chunks", sentence)
+		}
+	}
+
+	// 有重叠时相邻分块共享内容
+	if !strings.Contains(pieces[1].Content, strings.Fields(pieces[0].Content)[len(strings.Fields(pieces[0].Content))-1]) {
+		t.Errorf("pieces %q and %q do not overlap", pieces[0].Content, pieces[1].Content)
+	}
+
+	if pieces, _ := chunker.Split(context.Background(), "  \n\n "); len(pieces) != 0 {
+		t.Errorf("Split of blank text = %v, want none", pieces)
+	}
+}
+
+func TestMarkdownChunker(t *testing.T) {
+	text := strings.Join([]string{
+		"# Guide",
+		"## Install",
+		"run the installer",
+		"```",
+		"# not a heading",
+		"```",
+		"# FAQ",
+		"### Billing",
+		"refunds take 7 days",
+	}, "\n")
+
+	chunker, err := NewChunker(ChunkingConfig{Strategy: ChunkStrategyMarkdown, Size: 200}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieces, err := chunker.Split(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有标题的小节不单独成块，跳级标题不留下空标题
+	want := []struct {
+		headings string
+		contains string
+	}{
+		{"Guide > Install", "# not a heading"},
+		{"FAQ > Billing", "refunds take 7 days"},
+	}
+	if len(pieces) != len(want) {
+		t.Fatalf("Split = %d pieces, want %d: %+v", len(pieces), len(want), pieces)
+	}
+	for i, w := range want {
+		if got := strings.Join(pieces[i].Headings, " > "); got != w.headings {
+			t.Errorf("piece %d Headings = %q, want %q", i, got, w.headings)
+		}
+		if !strings.Contains(pieces[i].Content, w.contains) {
+			t.Errorf("piece %d Content = %q, want it to contain %q", i, pieces[i].Content, w.contains)
+		}
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	got := splitSentences("你好。今天天气很好！Pi is 3.14. Done?\" next\nline")
+	want := []string{"你好。", "今天天气很好！", "Pi is 3.14.", "Done?\"", "next", "line"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("splitSentences = %q, want %q", got, want)
+	}
+}
+
+func TestSentenceChunker(t *testing.T) {
+	chunker, err := NewChunker(ChunkingConfig{Strategy: ChunkStrategySentence, Size: 30, Overlap: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieces, err := chunker.Split(context.Background(), "First one here. Second one here. Third one here. "+strings.Repeat("x", 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) < 3 {
+		t.Fatalf("Split = %d pieces, want at least 3", len(pieces))
+	}
+	for _, piece := range pieces {
+		if n := utf8.RuneCountInString(piece.Content); n > 30 {
+			t.Errorf("piece %q has %d runes, want at most 30", piece.Content, n)
+		}
+	}
+	if !strings.HasPrefix(pieces[0].Content, "First one here.") {
+		t.Errorf("first piece = %q, want it to start with the first sentence", pieces[0].Content)
+	}
+}
+
+func TestSemanticChunker(t *testing.T) {
+	embedding := NewEmbeddingManager()
+	embedding.RegisterModel("counting", &countingEmbeddingModel{})
+	chunker, err := NewChunker(ChunkingConfig{Strategy: ChunkStrategySemantic, Size: 1000, EmbeddingModel: "counting"}, embedding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := "A cat naps. The cat purrs. My cat eats. A car honks. The car stops. My car runs."
+	pieces, err := chunker.Split(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A cat naps. The cat purrs. My cat eats.", "A car honks. The car stops. My car runs."}
+	var got []string
+	for _, piece := range pieces {
+		got = append(got, piece.Content)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Split = %q, want %q", got, want)
+	}
+
+	// 向量数量与句子窗口数量不一致时报错，而不是越界
+	embedding.RegisterModel("counting", &countingEmbeddingModel{drop: 1})
+	if _, err := chunker.Split(context.Background(), text); err == nil {
+		t.Error("Split with missing vectors succeeded, want error")
+	}
+
+	// 句子太少时退化为递归分块，不调用向量模型
+	if pieces, err := chunker.Split(context.Background(), "One cat. One car."); err != nil || len(pieces) != 1 {
+		t.Errorf("Split of short text = %v, %v, want one piece", pieces, err)
+	}
+}
+
+func TestChunkSections(t *testing.T) {
+	doc := &Document{ID: "d1", Name: "report.pdf"}
+	sections := []Section{{Text: "page one", PageNumber: 1}, {Text: "", PageNumber: 2}, {Text: "page three", PageNumber: 3}}
+	chunks, err := ChunkSections(context.Background(), doc, sections, &RecursiveChunker{Size: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("ChunkSections = %d chunks, want 2", len(chunks))
+	}
+	for i, want := range []struct {
+		content string
+		page    int
+	}{{"page one", 1}, {"page three", 3}} {
+		chunk := chunks[i]
+		if chunk.Content != want.content || chunk.Metadata.PageNumber != want.page || chunk.Metadata.ChunkIndex != i {
+			t.Errorf("chunk %d = %q page %d index %d, want %q page %d index %d",
+				i, chunk.Content, chunk.Metadata.PageNumber, chunk.Metadata.ChunkIndex, want.content, want.page, i)
+		}
+		if chunk.DocumentID != "d1" || chunk.Metadata.Source != "report.pdf" || chunk.ID == "" {
+			t.Errorf("chunk %d DocumentID = %q, Source = %q, ID = %q", i, chunk.DocumentID, chunk.Metadata.Source, chunk.ID)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{50, 2.5},
+		{100, 4},
+	}
+	for _, tt := range tests {
+		if got := percentile(values, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 95); !math.IsInf(got, 1) {
+		t.Errorf("percentile(nil) = %v, want +Inf", got)
+	}
+}
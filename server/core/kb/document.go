@@ -2,7 +2,9 @@ package kb
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"mime/multipart"
 	"path/filepath"
@@ -16,7 +18,7 @@ import (
 type DocumentType string
 
 const (
-	// TypeText 纯文本文?
+	// TypeText 纯文本文档
 	TypeText DocumentType = "text"
 	// TypeMarkdown Markdown文档
 	TypeMarkdown DocumentType = "markdown"
@@ -30,40 +32,51 @@ const (
 	TypeHTML DocumentType = "html"
 )
 
-// Document 表示一个文?
+// Document 表示一个文档
 type Document struct {
 	ID             string       `json:"id"`
 	KnowledgeBaseID string       `json:"knowledge_base_id"`
 	Name           string       `json:"name"`
 	Type           DocumentType `json:"type"`
 	Size           int64        `json:"size"`
-	Content        string       `json:"-"` // 原始内容，不在JSON中返?
+	Content        string       `json:"-"` // 原始内容，不在JSON中返回
+	Tags           []string     `json:"tags,omitempty"`
 	CreatedAt      time.Time    `json:"created_at"`
 	UpdatedAt      time.Time    `json:"updated_at"`
 	Metadata       interface{}  `json:"metadata,omitempty"`
 }
 
-// Chunk 表示文档的一个分?
+// Chunk 表示文档的一个分块
 type Chunk struct {
 	ID         string    `json:"id"`
 	DocumentID string    `json:"document_id"`
 	Content    string    `json:"content"`
 	Vector     []float32 `json:"-"`
 	Metadata   struct {
-		ChunkIndex int    `json:"chunk_index"`
-		PageNumber int    `json:"page_number,omitempty"`
-		Source     string `json:"source"`
+		ChunkIndex int      `json:"chunk_index"`
+		PageNumber int      `json:"page_number,omitempty"`
+		Source     string   `json:"source"`
+		Headings   []string `json:"headings,omitempty"` // 所在的Markdown标题路径
 	} `json:"metadata"`
 }
 
-// DocumentProcessor 文档处理器接?
+// Section 从文档中提取出的一段文本，PageNumber 为0表示没有页码
+type Section struct {
+	Text       string
+	PageNumber int
+}
+
+// DocumentProcessor 文档处理器接口，负责提取文档文本，分块由 Chunker 完成
 type DocumentProcessor interface {
-	// Process 处理文档并返回分块结?
-	Process(doc *Document) ([]Chunk, error)
-	// SupportsType 检查是否支持特定文档类?
+	// Extract 提取文档文本，可按页或工作表分段
+	Extract(doc *Document) ([]Section, error)
+	// SupportsType 检查是否支持特定文档类型
 	SupportsType(docType DocumentType) bool
 }
 
+// ErrUnsupportedDocumentType 没有可处理该文档类型的处理器
+var ErrUnsupportedDocumentType = errors.New("no processor found for document type")
+
 // DocumentProcessorRegistry 文档处理器注册表
 type DocumentProcessorRegistry struct {
 	processors map[DocumentType]DocumentProcessor
@@ -76,7 +89,7 @@ func NewDocumentProcessorRegistry() *DocumentProcessorRegistry {
 	}
 }
 
-// Register 注册文档处理?
+// Register 注册文档处理器
 func (r *DocumentProcessorRegistry) Register(docType DocumentType, processor DocumentProcessor) {
 	r.processors[docType] = processor
 }
@@ -85,18 +98,22 @@ func (r *DocumentProcessorRegistry) Register(docType DocumentType, processor Doc
 func (r *DocumentProcessorRegistry) GetProcessor(docType DocumentType) (DocumentProcessor, error) {
 	processor, exists := r.processors[docType]
 	if !exists {
-		return nil, errors.New("no processor found for document type: " + string(docType))
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDocumentType, docType)
 	}
 	return processor, nil
 }
 
-// ProcessDocument 处理文档
-func (r *DocumentProcessorRegistry) ProcessDocument(doc *Document) ([]Chunk, error) {
+// ProcessDocument 提取文档文本并用指定的分块器分块
+func (r *DocumentProcessorRegistry) ProcessDocument(ctx context.Context, doc *Document, chunker Chunker) ([]Chunk, error) {
 	processor, err := r.GetProcessor(doc.Type)
 	if err != nil {
 		return nil, err
 	}
-	return processor.Process(doc)
+	sections, err := processor.Extract(doc)
+	if err != nil {
+		return nil, err
+	}
+	return ChunkSections(ctx, doc, sections, chunker)
 }
 
 // NewDocument 从文件创建新文档
@@ -168,128 +185,34 @@ func getDocumentTypeFromExt(ext string) DocumentType {
 	}
 }
 
-// DefaultChunkSize 默认的文本分块大?
-const DefaultChunkSize = 1000
-
-// DefaultChunkOverlap 默认的文本分块重叠大?
-const DefaultChunkOverlap = 200
+// BasicTextProcessor 基础文本处理器，用于纯文本和Markdown文档
+type BasicTextProcessor struct{}
 
-// BasicTextProcessor 基础文本处理?
-type BasicTextProcessor struct {
-	ChunkSize    int
-	ChunkOverlap int
+// NewBasicTextProcessor 创建基础文本处理器
+func NewBasicTextProcessor() *BasicTextProcessor {
+	return &BasicTextProcessor{}
 }
 
-// NewBasicTextProcessor 创建基础文本处理?
-func NewBasicTextProcessor(chunkSize, chunkOverlap int) *BasicTextProcessor {
-	if chunkSize <= 0 {
-		chunkSize = DefaultChunkSize
-	}
-	if chunkOverlap <= 0 {
-		chunkOverlap = DefaultChunkOverlap
-	}
-	return &BasicTextProcessor{
-		ChunkSize:    chunkSize,
-		ChunkOverlap: chunkOverlap,
-	}
+// Extract 提取文档文本
+func (p *BasicTextProcessor) Extract(doc *Document) ([]Section, error) {
+	return []Section{{Text: doc.Content}}, nil
 }
 
-// Process 处理文档并返回分?
-func (p *BasicTextProcessor) Process(doc *Document) ([]Chunk, error) {
-	content := doc.Content
-	
-	// 如果文档为空，返回空结果
-	if len(content) == 0 {
-		return []Chunk{}, nil
-	}
-	
-	// 简单按照句子或段落分割
-	paragraphs := strings.Split(content, "\n\n")
-	var chunks []Chunk
-	
-	currentChunk := ""
-	chunkIndex := 0
-	
-	for _, para := range paragraphs {
-		if len(currentChunk)+len(para) > p.ChunkSize {
-			// 当前块已经足够大，创建一个新?
-			if len(currentChunk) > 0 {
-				chunks = append(chunks, Chunk{
-					ID:         uuid.New().String(),
-					DocumentID: doc.ID,
-					Content:    currentChunk,
-					Metadata: struct {
-						ChunkIndex int    `json:"chunk_index"`
-						PageNumber int    `json:"page_number,omitempty"`
-						Source     string `json:"source"`
-					}{
-						ChunkIndex: chunkIndex,
-						Source:     doc.Name,
-					},
-				})
-				chunkIndex++
-				
-				// 使用重叠部分创建下一个块
-				lastWords := getLastWords(currentChunk, p.ChunkOverlap)
-				currentChunk = lastWords
-			}
-		}
-		
-		if len(currentChunk) > 0 {
-			currentChunk += "\n\n"
-		}
-		currentChunk += para
-	}
-	
-	// 添加最后一个块
-	if len(currentChunk) > 0 {
-		chunks = append(chunks, Chunk{
-			ID:         uuid.New().String(),
-			DocumentID: doc.ID,
-			Content:    currentChunk,
-			Metadata: struct {
-				ChunkIndex int    `json:"chunk_index"`
-				PageNumber int    `json:"page_number,omitempty"`
-				Source     string `json:"source"`
-			}{
-				ChunkIndex: chunkIndex,
-				Source:     doc.Name,
-			},
-		})
-	}
-	
-	return chunks, nil
-}
-
-// SupportsType 检查是否支持特定文档类?
+// SupportsType 检查是否支持特定文档类型
 func (p *BasicTextProcessor) SupportsType(docType DocumentType) bool {
 	return docType == TypeText || docType == TypeMarkdown
 }
 
-// 获取文本的最后n个字符，确保不会拆分单词
-func getLastWords(text string, n int) string {
-	if len(text) <= n {
-		return text
-	}
-	
-	// 查找适当的断?
-	cutIndex := len(text) - n
-	for i := cutIndex; i < len(text); i++ {
-		if text[i] == ' ' || text[i] == '\n' {
-			cutIndex = i + 1
-			break
-		}
-	}
-	
-	return text[cutIndex:]
-}
-
-// DefaultProcessorRegistry 默认的文档处理器注册?
+// DefaultProcessorRegistry 默认的文档处理器注册表
 var DefaultProcessorRegistry = NewDocumentProcessorRegistry()
 
 // 初始化默认处理器
 func init() {
-	basicProcessor := NewBasicTextProcessor(DefaultChunkSize, DefaultChunkOverlap)
+	basicProcessor := NewBasicTextProcessor()
 	DefaultProcessorRegistry.Register(TypeText, basicProcessor)
 	DefaultProcessorRegistry.Register(TypeMarkdown, basicProcessor)
+	DefaultProcessorRegistry.Register(TypePDF, NewPDFProcessor())
+	DefaultProcessorRegistry.Register(TypeWord, NewWordProcessor(0))
+	DefaultProcessorRegistry.Register(TypeExcel, NewExcelProcessor(0))
+	DefaultProcessorRegistry.Register(TypeHTML, NewHTMLProcessor())
 } 
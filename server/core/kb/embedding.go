@@ -51,6 +51,13 @@ func (m *EmbeddingManager) RegisterModel(name string, model EmbeddingModel) {
 	m.models[name] = model
 }
 
+// UnregisterModel 注销向量模型
+func (m *EmbeddingManager) UnregisterModel(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.models, name)
+}
+
 // GetModel 获取向量模型
 func (m *EmbeddingManager) GetModel(name string) (EmbeddingModel, error) {
 	m.mu.RLock()
@@ -0,0 +1,374 @@
+package kb
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/llm"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultProviderBatchSize 未单独配置的提供者单次请求的文本数
+	defaultProviderBatchSize = 32
+
+	// embeddingProbeTimeout 探测向量维度的请求超时时间
+	embeddingProbeTimeout = 15 * time.Second
+
+	// embeddingProbeText 探测向量维度时发送的文本
+	embeddingProbeText = "dimension probe"
+)
+
+// providerBatchSizes 各提供者单次向量化请求的文本数上限
+var providerBatchSizes = map[models.ModelProvider]int{
+	models.ModelProviderOpenAI: 256,
+	models.ModelProviderAli:    10, // DashScope text-embedding-v3/v4 单次最多10条
+}
+
+// knownEmbeddingDimensions 常见向量模型的默认维度，其余模型在加载时发送一次请求探测
+var knownEmbeddingDimensions = map[string]int{
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+	"text-embedding-ada-002": 1536,
+	"text-embedding-v1":      1536,
+	"text-embedding-v2":      1536,
+	"text-embedding-v3":      1024,
+	"text-embedding-v4":      1024,
+}
+
+// LLMEmbeddingModel 通过 pkg/llm 适配器调用的向量模型，支持OpenAI、DashScope及本地OpenAI兼容服务。
+// 请求按 batchSize 分批，已向量化过的文本从缓存读取
+type LLMEmbeddingModel struct {
+	name       string
+	modelID    string
+	adapter    llm.Adapter
+	batchSize  int
+	dimensions int
+	cache      *EmbeddingCache
+}
+
+// NewLLMEmbeddingModel 创建向量模型，name 为注册名称，modelID 为提供者的模型标识；cache 为 nil 时不缓存
+func NewLLMEmbeddingModel(name, modelID string, adapter llm.Adapter, batchSize int, cache *EmbeddingCache) *LLMEmbeddingModel {
+	if batchSize <= 0 {
+		batchSize = defaultProviderBatchSize
+	}
+	return &LLMEmbeddingModel{
+		name:       name,
+		modelID:    modelID,
+		adapter:    adapter,
+		batchSize:  batchSize,
+		dimensions: knownEmbeddingDimensions[modelID],
+		cache:      cache,
+	}
+}
+
+// DiscoverDimensions 维度未知时发送一条文本探测向量维度，需在注册前调用
+func (m *LLMEmbeddingModel) DiscoverDimensions(ctx context.Context) error {
+	if m.dimensions > 0 {
+		return nil
+	}
+
+	vectors, err := m.request(ctx, []string{embeddingProbeText})
+	if err != nil {
+		return fmt.Errorf("failed to discover dimensions: %w", err)
+	}
+	if len(vectors[0]) == 0 {
+		return errors.New("failed to discover dimensions: empty embedding")
+	}
+	m.dimensions = len(vectors[0])
+	return nil
+}
+
+// Embed 将文本转换为向量表示，结果顺序与输入一致
+func (m *LLMEmbeddingModel) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	result := make([][]float32, len(texts))
+
+	// 缓存未命中的文本去重后再请求
+	positions := make(map[string][]int)
+	var missing []string
+	for i, text := range texts {
+		if vector, ok := m.cache.Get(m.cacheKey(text)); ok {
+			result[i] = vector
+			continue
+		}
+		if _, ok := positions[text]; !ok {
+			missing = append(missing, text)
+		}
+		positions[text] = append(positions[text], i)
+	}
+
+	for start := 0; start < len(missing); start += m.batchSize {
+		end := start + m.batchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		vectors, err := m.request(ctx, missing[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for j, vector := range vectors {
+			text := missing[start+j]
+			m.cache.Put(m.cacheKey(text), vector)
+			for _, i := range positions[text] {
+				result[i] = vector
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Dimensions 返回向量维度
+func (m *LLMEmbeddingModel) Dimensions() int {
+	return m.dimensions
+}
+
+// ModelName 返回模型名称
+func (m *LLMEmbeddingModel) ModelName() string {
+	return m.name
+}
+
+// request 发送一批文本，按响应中的序号还原顺序并检查向量维度
+func (m *LLMEmbeddingModel) request(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := m.adapter.Embedding(ctx, llm.EmbeddingRequest{Texts: texts, Model: m.modelID})
+	if err != nil {
+		return nil, fmt.Errorf("embedding model %s: %w", m.name, err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding model %s returned %d embeddings for %d texts", m.name, len(resp.Embeddings), len(texts))
+	}
+
+	// 部分兼容服务不返回序号，序号缺失或重复时按返回顺序对应
+	ordered := true
+	seen := make([]bool, len(texts))
+	for _, embedding := range resp.Embeddings {
+		if embedding.Index < 0 || embedding.Index >= len(texts) || seen[embedding.Index] {
+			ordered = false
+			break
+		}
+		seen[embedding.Index] = true
+	}
+
+	vectors := make([][]float32, len(texts))
+	for position, embedding := range resp.Embeddings {
+		if m.dimensions > 0 && len(embedding.Vector) != m.dimensions {
+			return nil, fmt.Errorf("embedding model %s returned %d dimensions, expected %d", m.name, len(embedding.Vector), m.dimensions)
+		}
+		index := position
+		if ordered {
+			index = embedding.Index
+		}
+		vectors[index] = embedding.Vector
+	}
+	return vectors, nil
+}
+
+// cacheKey 以模型和文本内容的哈希作为缓存键
+func (m *LLMEmbeddingModel) cacheKey(text string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(m.name + "\x00" + m.modelID + "\x00" + text))
+}
+
+// EmbeddingCache 按内容哈希缓存向量的LRU缓存，可在多个模型间共享。nil 缓存不保存任何内容
+type EmbeddingCache struct {
+	capacity int
+	entries  map[[sha256.Size]byte]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+}
+
+// embeddingCacheEntry 缓存条目
+type embeddingCacheEntry struct {
+	key    [sha256.Size]byte
+	vector []float32
+}
+
+// NewEmbeddingCache 创建最多保存 capacity 条向量的缓存，capacity 不大于0时返回 nil
+func NewEmbeddingCache(capacity int) *EmbeddingCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &EmbeddingCache{
+		capacity: capacity,
+		entries:  make(map[[sha256.Size]byte]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get 读取缓存的向量，返回的向量与缓存共享，调用方不应修改
+func (c *EmbeddingCache) Get(key [sha256.Size]byte) ([]float32, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*embeddingCacheEntry).vector, true
+}
+
+// Put 写入向量，超出容量时淘汰最久未使用的条目
+func (c *EmbeddingCache) Put(key [sha256.Size]byte, vector []float32) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*embeddingCacheEntry).vector = vector
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&embeddingCacheEntry{key: key, vector: vector})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*embeddingCacheEntry).key)
+	}
+}
+
+// EmbeddingLoaderConfig 向量模型加载配置
+type EmbeddingLoaderConfig struct {
+	RefreshInterval time.Duration // 重新同步模型表的间隔，不大于0时只在启动时加载
+	CacheSize       int           // 向量缓存的最大条目数，不大于0时不缓存
+}
+
+// EmbeddingModelLoader 将 models 表中启用的向量模型注册到向量化管理器，并定期同步新增、修改和停用的模型
+type EmbeddingModelLoader struct {
+	db        *gorm.DB
+	manager   *EmbeddingManager
+	decrypter agent.Decrypter
+	config    EmbeddingLoaderConfig
+	cache     *EmbeddingCache
+	loaded    map[string]time.Time // 已注册的模型名称及其记录的更新时间
+	mu        sync.Mutex
+	stop      context.CancelFunc
+	wg        sync.WaitGroup
+	logger    *zap.Logger
+}
+
+// NewEmbeddingModelLoader 创建向量模型加载器，decrypter 用于解密模型的API密钥
+func NewEmbeddingModelLoader(db *gorm.DB, manager *EmbeddingManager, decrypter agent.Decrypter, config EmbeddingLoaderConfig) *EmbeddingModelLoader {
+	return &EmbeddingModelLoader{
+		db:        db,
+		manager:   manager,
+		decrypter: decrypter,
+		config:    config,
+		cache:     NewEmbeddingCache(config.CacheSize),
+		loaded:    make(map[string]time.Time),
+		logger:    zap.L().With(zap.String("component", "embedding_loader")),
+	}
+}
+
+// Load 同步一次模型表：注册新增或修改过的模型，注销已删除或停用的模型。
+// 单个模型加载失败只记录日志，下次同步时重试
+func (l *EmbeddingModelLoader) Load(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var records []models.Model
+	if err := l.db.WithContext(ctx).
+		Where("type = ? AND status = ?", models.ModelTypeEmbedding, models.ModelStatusActive).
+		Find(&records).Error; err != nil {
+		return fmt.Errorf("failed to list embedding models: %w", err)
+	}
+
+	active := make(map[string]bool, len(records))
+	for i := range records {
+		record := &records[i]
+		active[record.Name] = true
+		if updatedAt, ok := l.loaded[record.Name]; ok && updatedAt.Equal(record.UpdatedAt) {
+			continue
+		}
+
+		model, err := l.build(ctx, record)
+		if err != nil {
+			l.logger.Warn("Failed to load embedding model", zap.String("model", record.Name), zap.Error(err))
+			continue
+		}
+		l.manager.RegisterModel(record.Name, model)
+		l.loaded[record.Name] = record.UpdatedAt
+		l.logger.Info("Registered embedding model",
+			zap.String("model", record.Name),
+			zap.String("provider", record.Provider.String()),
+			zap.Int("dimensions", model.Dimensions()))
+	}
+
+	for name := range l.loaded {
+		if !active[name] {
+			l.manager.UnregisterModel(name)
+			delete(l.loaded, name)
+			l.logger.Info("Unregistered embedding model", zap.String("model", name))
+		}
+	}
+	return nil
+}
+
+// Start 启动后台同步
+func (l *EmbeddingModelLoader) Start() {
+	if l.config.RefreshInterval <= 0 {
+		return
+	}
+
+	var ctx context.Context
+	ctx, l.stop = context.WithCancel(context.Background())
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(l.config.RefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.Load(ctx); err != nil && ctx.Err() == nil {
+					l.logger.Warn("Failed to refresh embedding models", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止后台同步
+func (l *EmbeddingModelLoader) Stop() {
+	if l.stop == nil {
+		return
+	}
+	l.stop()
+	l.wg.Wait()
+}
+
+// build 根据模型记录创建向量模型并确定维度
+func (l *EmbeddingModelLoader) build(ctx context.Context, record *models.Model) (*LLMEmbeddingModel, error) {
+	providerConfig, err := agent.ResolveProviderConfig(&models.ModelConfig{Model: record}, l.decrypter)
+	if err != nil {
+		return nil, err
+	}
+	adapter, err := llm.CreateAdapter(record.Provider, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	model := NewLLMEmbeddingModel(record.Name, record.ModelID, adapter, providerBatchSizes[record.Provider], l.cache)
+	probeCtx, cancel := context.WithTimeout(ctx, embeddingProbeTimeout)
+	defer cancel()
+	if err := model.DiscoverDimensions(probeCtx); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
@@ -0,0 +1,250 @@
+package kb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/llm"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+)
+
+// stubEmbeddingAdapter 以文本长度为首个分量返回向量，记录每次请求的文本
+type stubEmbeddingAdapter struct {
+	dimensions int
+	reverse    bool // 以倒序返回并带上序号
+	noIndex    bool // 不返回序号
+	extra      int  // 多返回的向量数
+	err        error
+	requests   [][]string
+}
+
+func (a *stubEmbeddingAdapter) Chat(ctx context.Context, request llm.ChatRequest) (*llm.ChatResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *stubEmbeddingAdapter) TestConnection(ctx context.Context) error {
+	return nil
+}
+
+func (a *stubEmbeddingAdapter) Embedding(ctx context.Context, request llm.EmbeddingRequest) (*llm.EmbeddingResponse, error) {
+	a.requests = append(a.requests, request.Texts)
+	if a.err != nil {
+		return nil, a.err
+	}
+
+	embeddings := make([]llm.EmbeddingVector, 0, len(request.Texts)+a.extra)
+	for i, text := range request.Texts {
+		vector := make([]float32, a.dimensions)
+		if a.dimensions > 0 {
+			vector[0] = float32(len(text))
+		}
+		embeddings = append(embeddings, llm.EmbeddingVector{Vector: vector, Index: i})
+	}
+	for i := 0; i < a.extra; i++ {
+		embeddings = append(embeddings, llm.EmbeddingVector{Vector: make([]float32, a.dimensions)})
+	}
+	if a.reverse {
+		for i, j := 0, len(embeddings)-1; i < j; i, j = i+1, j-1 {
+			embeddings[i], embeddings[j] = embeddings[j], embeddings[i]
+		}
+	}
+	if a.noIndex {
+		for i := range embeddings {
+			embeddings[i].Index = 0
+		}
+	}
+	return &llm.EmbeddingResponse{Embeddings: embeddings}, nil
+}
+
+func TestLLMEmbeddingModelEmbed(t *testing.T) {
+	tests := []struct {
+		name    string
+		adapter *stubEmbeddingAdapter
+	}{
+		{"ordered", &stubEmbeddingAdapter{dimensions: 3}},
+		{"reversed with index", &stubEmbeddingAdapter{dimensions: 3, reverse: true}},
+		{"without index", &stubEmbeddingAdapter{dimensions: 3, noIndex: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := NewLLMEmbeddingModel("custom", "custom-model", tt.adapter, 2, NewEmbeddingCache(10))
+			texts := []string{"a", "bb", "a", "ccc", "dddd"}
+
+			vectors, err := model.Embed(context.Background(), texts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, text := range texts {
+				if vectors[i][0] != float32(len(text)) {
+					t.Errorf("vector %d = %v, want first value %d", i, vectors[i], len(text))
+				}
+			}
+			// 重复的文本只请求一次，按批大小分批
+			if len(tt.adapter.requests) != 2 || len(tt.adapter.requests[0]) != 2 || len(tt.adapter.requests[1]) != 2 {
+				t.Errorf("requests = %v, want two batches of two texts", tt.adapter.requests)
+			}
+
+			// 已缓存的文本不再请求
+			if _, err := model.Embed(context.Background(), []string{"bb", "dddd"}); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.adapter.requests) != 2 {
+				t.Errorf("requests after cached Embed = %d, want 2", len(tt.adapter.requests))
+			}
+		})
+	}
+}
+
+func TestLLMEmbeddingModelErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modelID string
+		adapter *stubEmbeddingAdapter
+	}{
+		{"adapter error", "custom-model", &stubEmbeddingAdapter{dimensions: 3, err: errors.New("rate limited")}},
+		{"wrong count", "custom-model", &stubEmbeddingAdapter{dimensions: 3, extra: 1}},
+		{"wrong dimensions", "text-embedding-3-small", &stubEmbeddingAdapter{dimensions: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := NewLLMEmbeddingModel("custom", tt.modelID, tt.adapter, 0, nil)
+			if _, err := model.Embed(context.Background(), []string{"a", "b"}); err == nil {
+				t.Error("Embed succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestDiscoverDimensions(t *testing.T) {
+	adapter := &stubEmbeddingAdapter{dimensions: 5}
+	model := NewLLMEmbeddingModel("custom", "custom-model", adapter, 0, nil)
+	if model.Dimensions() != 0 {
+		t.Fatalf("Dimensions before discovery = %d, want 0", model.Dimensions())
+	}
+	if err := model.DiscoverDimensions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if model.Dimensions() != 5 || len(adapter.requests) != 1 {
+		t.Errorf("Dimensions = %d after %d requests, want 5 after 1", model.Dimensions(), len(adapter.requests))
+	}
+
+	// 已知维度的模型不发送探测请求
+	known := &stubEmbeddingAdapter{dimensions: 5}
+	model = NewLLMEmbeddingModel("openai", "text-embedding-3-small", known, 0, nil)
+	if err := model.DiscoverDimensions(context.Background()); err != nil || model.Dimensions() != 1536 || len(known.requests) != 0 {
+		t.Errorf("DiscoverDimensions = %v, Dimensions = %d, requests = %d, want 1536 without requests", err, model.Dimensions(), len(known.requests))
+	}
+
+	failing := NewLLMEmbeddingModel("custom", "custom-model", &stubEmbeddingAdapter{err: errors.New("unreachable")}, 0, nil)
+	if err := failing.DiscoverDimensions(context.Background()); err == nil {
+		t.Error("DiscoverDimensions with failing adapter succeeded, want error")
+	}
+	empty := NewLLMEmbeddingModel("custom", "custom-model", &stubEmbeddingAdapter{}, 0, nil)
+	if err := empty.DiscoverDimensions(context.Background()); err == nil {
+		t.Error("DiscoverDimensions with empty vector succeeded, want error")
+	}
+}
+
+func TestEmbeddingCache(t *testing.T) {
+	key := func(s string) [sha256.Size]byte { return sha256.Sum256([]byte(s)) }
+	cache := NewEmbeddingCache(2)
+	cache.Put(key("a"), []float32{1})
+	cache.Put(key("b"), []float32{2})
+	cache.Get(key("a"))
+	cache.Put(key("c"), []float32{3})
+
+	// b 最久未使用，被淘汰
+	if _, ok := cache.Get(key("b")); ok {
+		t.Error("b was not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := cache.Get(key(k)); !ok {
+			t.Errorf("%s was evicted", k)
+		}
+	}
+
+	cache.Put(key("a"), []float32{4})
+	if vector, _ := cache.Get(key("a")); vector[0] != 4 {
+		t.Errorf("a = %v, want updated vector", vector)
+	}
+
+	disabled := NewEmbeddingCache(0)
+	disabled.Put(key("a"), []float32{1})
+	if _, ok := disabled.Get(key("a")); ok {
+		t.Error("disabled cache returned a vector")
+	}
+}
+
+func TestEmbeddingModelLoader(t *testing.T) {
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []map[string]interface{}{{"embedding": []float32{0.1, 0.2, 0.3}, "index": 0}},
+		})
+	}))
+	defer server.Close()
+
+	db := testdb.Open(t, &models.Model{})
+	create := func(name string, provider models.ModelProvider, modelType models.ModelType) *models.Model {
+		record := models.Model{
+			Name:           name,
+			Provider:       provider,
+			ModelID:        name,
+			Type:           modelType,
+			Status:         models.ModelStatusActive,
+			ProviderConfig: models.ModelProviderConfig{ApiKey: "key", BaseURL: server.URL},
+		}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatal(err)
+		}
+		return &record
+	}
+	local := create("local-embedding", models.ModelProviderOpenAI, models.ModelTypeEmbedding)
+	create("chat-model", models.ModelProviderOpenAI, models.ModelTypeText)
+	create("broken-embedding", models.ModelProvider("unknown"), models.ModelTypeEmbedding)
+
+	manager := NewEmbeddingManager()
+	loader := NewEmbeddingModelLoader(db, manager, nil, EmbeddingLoaderConfig{CacheSize: 10})
+	if err := loader.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := manager.GetModel("local-embedding")
+	if err != nil {
+		t.Fatalf("GetModel: %v", err)
+	}
+	if model.Dimensions() != 3 {
+		t.Errorf("Dimensions = %d, want 3", model.Dimensions())
+	}
+	for _, name := range []string{"chat-model", "broken-embedding"} {
+		if _, err := manager.GetModel(name); err == nil {
+			t.Errorf("%s was registered", name)
+		}
+	}
+
+	// 未修改的模型不重新加载
+	if err := loader.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&probes); got != 1 {
+		t.Errorf("probes = %d, want 1", got)
+	}
+
+	if err := db.Model(local).Update("status", models.ModelStatusInactive).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.GetModel("local-embedding"); err == nil {
+		t.Error("inactive model is still registered")
+	}
+}
@@ -0,0 +1,459 @@
+package kb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FilterOp 元数据过滤的比较运算
+type FilterOp string
+
+// 过滤运算
+const (
+	FilterEq       FilterOp = "eq"       // 等于
+	FilterNe       FilterOp = "ne"       // 不等于，字段不存在时不匹配
+	FilterIn       FilterOp = "in"       // 属于给定值之一
+	FilterGt       FilterOp = "gt"       // 大于
+	FilterGte      FilterOp = "gte"      // 大于等于
+	FilterLt       FilterOp = "lt"       // 小于
+	FilterLte      FilterOp = "lte"      // 小于等于
+	FilterContains FilterOp = "contains" // 数组字段包含给定值，如 tags
+)
+
+// ErrInvalidFilter 过滤条件格式错误
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// filterField 元数据字段名，只允许字母、数字和下划线，以便安全地拼接到各后端的表达式中
+var filterField = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Filter 与向量数据库无关的元数据过滤条件。叶子条件比较一个字段，组合条件以 And 或 Or 连接子条件。
+// 字符串按字典序比较，日期使用 RFC 3339 格式的字符串即可进行范围过滤；
+// 字段不存在或类型不一致时条件不匹配
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value interface{} // 标量；Op 为 in 时为 []interface{}
+	And   []*Filter
+	Or    []*Filter
+}
+
+// ParseFilter 解析JSON形式的过滤条件，多个键之间为“且”的关系：
+//
+//	{"document_id": "d1"}                        等于
+//	{"document_id": ["d1", "d2"]}                属于，等同于 {"document_id": {"in": [...]}}
+//	{"page_number": {"gte": 2, "lt": 5}}         范围，可用 eq、ne、in、gt、gte、lt、lte、contains
+//	{"tags": {"contains": "faq"}}                数组包含
+//	{"or": [{...}, {...}]}、{"and": [...]}       组合
+//
+// 条件为空时返回 nil
+func ParseFilter(raw map[string]interface{}) (*Filter, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	// 按键排序，保证生成的表达式稳定
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var conditions []*Filter
+	for _, key := range keys {
+		value := raw[key]
+		switch key {
+		case "and", "or":
+			items, ok := value.([]interface{})
+			if !ok || len(items) == 0 {
+				return nil, fmt.Errorf("%w: %s must be a non-empty array", ErrInvalidFilter, key)
+			}
+			children := make([]*Filter, 0, len(items))
+			for _, item := range items {
+				object, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%w: %s items must be objects", ErrInvalidFilter, key)
+				}
+				child, err := ParseFilter(object)
+				if err != nil {
+					return nil, err
+				}
+				if child != nil {
+					children = append(children, child)
+				}
+			}
+			if len(children) == 0 {
+				continue
+			}
+			if key == "and" {
+				conditions = append(conditions, &Filter{And: children})
+			} else {
+				conditions = append(conditions, &Filter{Or: children})
+			}
+		default:
+			parsed, err := parseFieldFilter(key, value)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, parsed...)
+		}
+	}
+
+	switch len(conditions) {
+	case 0:
+		return nil, nil
+	case 1:
+		return conditions[0], nil
+	default:
+		return &Filter{And: conditions}, nil
+	}
+}
+
+// parseFieldFilter 解析单个字段上的条件
+func parseFieldFilter(field string, value interface{}) ([]*Filter, error) {
+	if !filterField.MatchString(field) {
+		return nil, fmt.Errorf("%w: invalid field name %q", ErrInvalidFilter, field)
+	}
+
+	operators, ok := value.(map[string]interface{})
+	if !ok {
+		if values, ok := value.([]interface{}); ok {
+			operators = map[string]interface{}{string(FilterIn): values}
+		} else {
+			operators = map[string]interface{}{string(FilterEq): value}
+		}
+	}
+	if len(operators) == 0 {
+		return nil, fmt.Errorf("%w: empty condition on %s", ErrInvalidFilter, field)
+	}
+
+	ops := make([]string, 0, len(operators))
+	for op := range operators {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	filters := make([]*Filter, 0, len(ops))
+	for _, op := range ops {
+		filter := &Filter{Field: field, Op: FilterOp(op), Value: operators[op]}
+		if err := filter.validateLeaf(); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// Validate 检查过滤条件是否合法
+func (f *Filter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if len(f.And) > 0 || len(f.Or) > 0 {
+		if f.Field != "" || (len(f.And) > 0 && len(f.Or) > 0) {
+			return fmt.Errorf("%w: a condition must be either a comparison or a single and/or group", ErrInvalidFilter)
+		}
+		for _, children := range [][]*Filter{f.And, f.Or} {
+			for _, child := range children {
+				if child == nil {
+					return fmt.Errorf("%w: nil condition", ErrInvalidFilter)
+				}
+				if err := child.Validate(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return f.validateLeaf()
+}
+
+// validateLeaf 检查字段比较条件
+func (f *Filter) validateLeaf() error {
+	if !filterField.MatchString(f.Field) {
+		return fmt.Errorf("%w: invalid field name %q", ErrInvalidFilter, f.Field)
+	}
+
+	switch f.Op {
+	case FilterEq, FilterNe, FilterContains:
+		if !isFilterScalar(f.Value) {
+			return fmt.Errorf("%w: %s on %s requires a string, number or boolean", ErrInvalidFilter, f.Op, f.Field)
+		}
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if _, ok := toFloat(f.Value); !ok {
+			if _, ok := f.Value.(string); !ok {
+				return fmt.Errorf("%w: %s on %s requires a string or number", ErrInvalidFilter, f.Op, f.Field)
+			}
+		}
+	case FilterIn:
+		values, ok := f.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return fmt.Errorf("%w: in on %s requires a non-empty array", ErrInvalidFilter, f.Field)
+		}
+		for _, value := range values {
+			if !isFilterScalar(value) {
+				return fmt.Errorf("%w: in on %s requires strings, numbers or booleans", ErrInvalidFilter, f.Field)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported operator %q", ErrInvalidFilter, f.Op)
+	}
+	return nil
+}
+
+// Match 判断元数据是否满足条件，用于内存向量数据库
+func (f *Filter) Match(metadata map[string]interface{}) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.And) > 0 {
+		for _, child := range f.And {
+			if !child.Match(metadata) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(f.Or) > 0 {
+		for _, child := range f.Or {
+			if child.Match(metadata) {
+				return true
+			}
+		}
+		return false
+	}
+
+	value, ok := metadata[f.Field]
+	if !ok {
+		return false
+	}
+
+	switch f.Op {
+	case FilterEq:
+		return filterEqual(value, f.Value)
+	case FilterNe:
+		_, comparable := compareFilterValues(value, f.Value)
+		return comparable && !filterEqual(value, f.Value)
+	case FilterIn:
+		for _, candidate := range f.Value.([]interface{}) {
+			if filterEqual(value, candidate) {
+				return true
+			}
+		}
+		return false
+	case FilterContains:
+		items, ok := toInterfaceSlice(value)
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if filterEqual(item, f.Value) {
+				return true
+			}
+		}
+		return false
+	default:
+		result, comparable := compareFilterValues(value, f.Value)
+		if !comparable {
+			return false
+		}
+		switch f.Op {
+		case FilterGt:
+			return result > 0
+		case FilterGte:
+			return result >= 0
+		case FilterLt:
+			return result < 0
+		case FilterLte:
+			return result <= 0
+		}
+		return false
+	}
+}
+
+// MilvusExpr 转换为Milvus布尔表达式，元数据保存在名为 metadata 的JSON字段中
+func (f *Filter) MilvusExpr() string {
+	if f == nil {
+		return ""
+	}
+	if len(f.And) > 0 {
+		return joinFilterExprs(f.And, (*Filter).MilvusExpr, " and ")
+	}
+	if len(f.Or) > 0 {
+		return joinFilterExprs(f.Or, (*Filter).MilvusExpr, " or ")
+	}
+
+	field := fmt.Sprintf("metadata[%s]", filterLiteral(f.Field))
+	switch f.Op {
+	case FilterIn:
+		values := f.Value.([]interface{})
+		literals := make([]string, len(values))
+		for i, value := range values {
+			literals[i] = filterLiteral(value)
+		}
+		return fmt.Sprintf("%s in [%s]", field, strings.Join(literals, ", "))
+	case FilterContains:
+		return fmt.Sprintf("json_contains(%s, %s)", field, filterLiteral(f.Value))
+	default:
+		return fmt.Sprintf("%s %s %s", field, filterOperators[f.Op], filterLiteral(f.Value))
+	}
+}
+
+// JSONPath 转换为 PostgreSQL 的 SQL/JSON 路径谓词，配合 metadata @@ ?::jsonpath 使用。
+// 使用 strict 模式，字段不存在或类型不一致时条件不匹配，与 Match 保持一致
+func (f *Filter) JSONPath() string {
+	if f == nil {
+		return ""
+	}
+	return "strict " + f.jsonPath()
+}
+
+// jsonPath 生成不带模式前缀的路径谓词
+func (f *Filter) jsonPath() string {
+	if len(f.And) > 0 {
+		return joinFilterExprs(f.And, (*Filter).jsonPath, " && ")
+	}
+	if len(f.Or) > 0 {
+		return joinFilterExprs(f.Or, (*Filter).jsonPath, " || ")
+	}
+
+	field := "$." + filterLiteral(f.Field)
+	switch f.Op {
+	case FilterIn:
+		values := f.Value.([]interface{})
+		conditions := make([]string, len(values))
+		for i, value := range values {
+			conditions[i] = fmt.Sprintf("%s == %s", field, filterLiteral(value))
+		}
+		return "(" + strings.Join(conditions, " || ") + ")"
+	case FilterContains:
+		return fmt.Sprintf("%s[*] == %s", field, filterLiteral(f.Value))
+	default:
+		operator := filterOperators[f.Op]
+		if f.Op == FilterEq {
+			operator = "=="
+		}
+		return fmt.Sprintf("%s %s %s", field, operator, filterLiteral(f.Value))
+	}
+}
+
+// filterOperators 比较运算对应的运算符，Milvus 与 SQL/JSON 路径相同
+var filterOperators = map[FilterOp]string{
+	FilterEq:  "==",
+	FilterNe:  "!=",
+	FilterGt:  ">",
+	FilterGte: ">=",
+	FilterLt:  "<",
+	FilterLte: "<=",
+}
+
+// joinFilterExprs 连接子条件的表达式
+func joinFilterExprs(children []*Filter, expr func(*Filter) string, separator string) string {
+	parts := make([]string, len(children))
+	for i, child := range children {
+		parts[i] = expr(child)
+	}
+	return "(" + strings.Join(parts, separator) + ")"
+}
+
+// filterLiteral 将值转换为字面量，字符串使用JSON转义，Milvus 与 SQL/JSON 路径均可解析
+func filterLiteral(value interface{}) string {
+	if number, ok := toFloat(value); ok {
+		return fmt.Sprintf("%v", number)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// isFilterScalar 值是否为字符串、数字或布尔值
+func isFilterScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, bool:
+		return true
+	}
+	_, ok := toFloat(value)
+	return ok
+}
+
+// filterEqual 判断两个元数据值是否相等，数字按数值比较
+func filterEqual(a, b interface{}) bool {
+	result, comparable := compareFilterValues(a, b)
+	return comparable && result == 0
+}
+
+// compareFilterValues 比较两个数字或两个字符串，类型不一致时 comparable 为 false。
+// 布尔值只用于判断相等，不相等时结果为1
+func compareFilterValues(a, b interface{}) (result int, comparable bool) {
+	if numberA, ok := toFloat(a); ok {
+		numberB, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case numberA < numberB:
+			return -1, true
+		case numberA > numberB:
+			return 1, true
+		}
+		return 0, true
+	}
+	if stringA, ok := a.(string); ok {
+		stringB, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(stringA, stringB), true
+	}
+	if boolA, ok := a.(bool); ok {
+		boolB, ok := b.(bool)
+		if !ok || boolA != boolB {
+			return 1, ok
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// toFloat 将数值类型转换为 float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// toInterfaceSlice 将数组类型的元数据值转换为 []interface{}
+func toInterfaceSlice(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return items, true
+	}
+	return nil, false
+}
@@ -0,0 +1,146 @@
+package kb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+	}{
+		{"invalid field name", map[string]interface{}{"page-number": 1}},
+		{"injection in field name", map[string]interface{}{`a" || true`: 1}},
+		{"unsupported operator", map[string]interface{}{"page_number": map[string]interface{}{"like": "a"}}},
+		{"empty condition", map[string]interface{}{"page_number": map[string]interface{}{}}},
+		{"empty in", map[string]interface{}{"document_id": []interface{}{}}},
+		{"nested value", map[string]interface{}{"document_id": map[string]interface{}{"eq": []interface{}{"a"}}}},
+		{"object in array", map[string]interface{}{"document_id": []interface{}{map[string]interface{}{}}}},
+		{"range on boolean", map[string]interface{}{"page_number": map[string]interface{}{"gt": true}}},
+		{"or not array", map[string]interface{}{"or": map[string]interface{}{"a": 1}}},
+		{"empty or", map[string]interface{}{"or": []interface{}{}}},
+		{"or item not object", map[string]interface{}{"or": []interface{}{"a"}}},
+		{"invalid nested condition", map[string]interface{}{"and": []interface{}{map[string]interface{}{"a-b": 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFilter(tt.raw); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("ParseFilter error = %v, want %v", err, ErrInvalidFilter)
+			}
+		})
+	}
+
+	if filter, err := ParseFilter(nil); filter != nil || err != nil {
+		t.Errorf("ParseFilter(nil) = %v, %v, want nil", filter, err)
+	}
+	if filter, err := ParseFilter(map[string]interface{}{"and": []interface{}{map[string]interface{}{}}}); filter != nil || err != nil {
+		t.Errorf("ParseFilter with empty group = %v, %v, want nil", filter, err)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	metadata := map[string]interface{}{
+		"document_id": "d1",
+		"page_number": 3,
+		"created_at":  "2024-05-01T00:00:00Z",
+		"tags":        []string{"faq", "billing"},
+	}
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		want bool
+	}{
+		{"eq", map[string]interface{}{"document_id": "d1"}, true},
+		{"eq mismatch", map[string]interface{}{"document_id": "d2"}, false},
+		{"eq number across types", map[string]interface{}{"page_number": 3.0}, true},
+		{"ne", map[string]interface{}{"page_number": map[string]interface{}{"ne": 4}}, true},
+		{"ne on missing field", map[string]interface{}{"missing": map[string]interface{}{"ne": 4}}, false},
+		{"ne with different type", map[string]interface{}{"page_number": map[string]interface{}{"ne": "3"}}, false},
+		{"in", map[string]interface{}{"document_id": []interface{}{"d0", "d1"}}, true},
+		{"in mismatch", map[string]interface{}{"document_id": []interface{}{"d0", "d2"}}, false},
+		{"range", map[string]interface{}{"page_number": map[string]interface{}{"gte": 3, "lt": 5}}, true},
+		{"range mismatch", map[string]interface{}{"page_number": map[string]interface{}{"gt": 3}}, false},
+		{"date range", map[string]interface{}{"created_at": map[string]interface{}{"gte": "2024-01-01", "lt": "2025-01-01"}}, true},
+		{"range with different type", map[string]interface{}{"page_number": map[string]interface{}{"lt": "5"}}, false},
+		{"contains", map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}}, true},
+		{"contains mismatch", map[string]interface{}{"tags": map[string]interface{}{"contains": "sales"}}, false},
+		{"contains on scalar", map[string]interface{}{"document_id": map[string]interface{}{"contains": "d1"}}, false},
+		{"missing field", map[string]interface{}{"missing": "x"}, false},
+		{"multiple keys", map[string]interface{}{"document_id": "d1", "page_number": 4}, false},
+		{"or", map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"document_id": "d2"},
+			map[string]interface{}{"tags": map[string]interface{}{"contains": "billing"}},
+		}}, true},
+		{"and", map[string]interface{}{"and": []interface{}{
+			map[string]interface{}{"document_id": "d1"},
+			map[string]interface{}{"page_number": 4},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			if got := filter.Match(metadata); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var filter *Filter
+	if !filter.Match(metadata) {
+		t.Error("nil filter does not match")
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  *Filter
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"leaf", &Filter{Field: "a", Op: FilterEq, Value: "x"}, false},
+		{"group", &Filter{And: []*Filter{{Field: "a", Op: FilterEq, Value: "x"}}}, false},
+		{"group with field", &Filter{Field: "a", And: []*Filter{{Field: "a", Op: FilterEq, Value: "x"}}}, true},
+		{"and with or", &Filter{And: []*Filter{{Field: "a", Op: FilterEq, Value: 1}}, Or: []*Filter{{Field: "b", Op: FilterEq, Value: 1}}}, true},
+		{"nil child", &Filter{Or: []*Filter{nil}}, true},
+		{"invalid child", &Filter{Or: []*Filter{{Field: "a", Op: "like", Value: "x"}}}, true},
+		{"in without array", &Filter{Field: "a", Op: FilterIn, Value: "x"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterMilvusExpr(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		want string
+	}{
+		{"eq", map[string]interface{}{"document_id": "d1"}, `metadata["document_id"] == "d1"`},
+		{"in", map[string]interface{}{"page_number": []interface{}{1, 2}}, `metadata["page_number"] in [1, 2]`},
+		{"contains", map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}}, `json_contains(metadata["tags"], "faq")`},
+		{"range", map[string]interface{}{"page_number": map[string]interface{}{"gte": 2, "lt": 5}}, `(metadata["page_number"] >= 2 and metadata["page_number"] < 5)`},
+		{"quoted value", map[string]interface{}{"document_id": `a"b`}, `metadata["document_id"] == "a\"b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			if got := filter.MilvusExpr(); got != tt.want {
+				t.Errorf("MilvusExpr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
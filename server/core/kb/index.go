@@ -4,17 +4,25 @@ package kb
 
 import (
 	"context"
+
+	"gorm.io/gorm"
 )
 
-// 初始化知识库模块
-func Initialize(ctx context.Context, vectorDBConfig VectorDBConfig) error {
-	// 初始化向量数据库
+// 初始化知识库模块，知识库、文档与分块记录保存在 db 中
+func Initialize(ctx context.Context, db *gorm.DB, vectorDBConfig VectorDBConfig) error {
+	// 初始化向量数据库，pgvector 默认与业务数据共用数据库
+	if vectorDBConfig.PgVectorDB == nil {
+		vectorDBConfig.PgVectorDB = db
+	}
 	if err := InitDefaultVectorDB(vectorDBConfig); err != nil {
 		return err
 	}
+	if err := DefaultVectorDB.Connect(ctx); err != nil {
+		return err
+	}
 	
 	// 初始化知识库管理器
-	InitDefaultKnowledgeBaseManager(DefaultVectorDB)
+	InitDefaultKnowledgeBaseManager(db, DefaultVectorDB)
 	
 	// 初始化检索器
 	InitDefaultRetriever()
@@ -42,6 +50,11 @@ func GetEmbeddingManager() *EmbeddingManager {
 	return DefaultEmbeddingManager
 }
 
+// 获取默认重排器注册表
+func GetRerankerManager() *RerankerManager {
+	return DefaultRerankerManager
+}
+
 // 获取默认文档处理器注册表
 func GetDocumentProcessorRegistry() *DocumentProcessorRegistry {
 	return DefaultProcessorRegistry
@@ -0,0 +1,530 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDocumentInProgress    = errors.New("document is being processed")
+	ErrDocumentNotInProgress = errors.New("document is not being processed")
+	ErrDocumentNotFailed     = errors.New("document has not failed")
+
+	// errIngestionAborted 文档在处理过程中被取消或删除
+	errIngestionAborted = errors.New("document ingestion aborted")
+)
+
+// processingStatuses 工作协程正在处理的文档状态
+var processingStatuses = []string{
+	models.DocumentStatusParsing,
+	models.DocumentStatusChunking,
+	models.DocumentStatusEmbedding,
+}
+
+// pendingStatuses 尚未结束的文档状态，可以被取消
+var pendingStatuses = append([]string{models.DocumentStatusQueued}, processingStatuses...)
+
+// IngestionConfig 文档入库流水线配置
+type IngestionConfig struct {
+	Workers            int           // 工作协程数量
+	QueueSize          int           // 内存队列长度，队列满时依赖轮询拾取
+	PollInterval       time.Duration // 轮询数据库中排队文档的间隔
+	HeartbeatInterval  time.Duration // 处理中文档的心跳间隔
+	LeaseTimeout       time.Duration // 心跳超过该时间未刷新的文档将被重新排队
+	MaxAttempts        int           // 最大处理次数
+	EmbeddingBatchSize int           // 每批向量化的分块数
+	EmbeddingRetries   int           // 每批向量化失败后的重试次数
+	RetryBackoff       time.Duration // 首次重试前的等待时间，之后每次翻倍
+}
+
+// DefaultIngestionConfig 返回默认的入库流水线配置
+func DefaultIngestionConfig() IngestionConfig {
+	return IngestionConfig{
+		Workers:            2,
+		QueueSize:          256,
+		PollInterval:       5 * time.Second,
+		HeartbeatInterval:  10 * time.Second,
+		LeaseTimeout:       time.Minute,
+		MaxAttempts:        3,
+		EmbeddingBatchSize: 32,
+		EmbeddingRetries:   3,
+		RetryBackoff:       time.Second,
+	}
+}
+
+// StartIngestion 启动入库工作协程和轮询协程，未设置的配置项使用默认值
+func (m *KnowledgeBaseManager) StartIngestion(config IngestionConfig) {
+	defaults := DefaultIngestionConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if config.LeaseTimeout <= 0 {
+		config.LeaseTimeout = defaults.LeaseTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.EmbeddingBatchSize <= 0 {
+		config.EmbeddingBatchSize = defaults.EmbeddingBatchSize
+	}
+	if config.EmbeddingRetries < 0 {
+		config.EmbeddingRetries = defaults.EmbeddingRetries
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+
+	m.ingestion = config
+	m.queue = make(chan uuid.UUID, config.QueueSize)
+	m.ctx, m.stop = context.WithCancel(context.Background())
+
+	for i := 0; i < config.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+
+	m.wg.Add(1)
+	go m.poll()
+}
+
+// StopIngestion 停止入库协程，处理中的文档会在下次启动时因心跳超时重新排队
+func (m *KnowledgeBaseManager) StopIngestion() {
+	if m.stop == nil {
+		return
+	}
+	m.stop()
+	m.wg.Wait()
+}
+
+// RetryDocument 将失败的文档重新排队
+func (m *KnowledgeBaseManager) RetryDocument(ctx context.Context, knowledgeBaseID, documentID string) (*models.Document, error) {
+	document, err := m.GetDocument(ctx, knowledgeBaseID, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.requeue(ctx, document.ID, []string{models.DocumentStatusFailed}); err != nil {
+		if errors.Is(err, errIngestionAborted) {
+			return nil, ErrDocumentNotFailed
+		}
+		return nil, err
+	}
+	return m.GetDocument(ctx, knowledgeBaseID, documentID)
+}
+
+// CancelDocument 取消排队或处理中的文档，文档标记为失败，可以重试
+func (m *KnowledgeBaseManager) CancelDocument(ctx context.Context, knowledgeBaseID, documentID string) error {
+	document, err := m.GetDocument(ctx, knowledgeBaseID, documentID)
+	if err != nil {
+		return err
+	}
+
+	result := m.db.WithContext(ctx).Model(&models.Document{}).
+		Where("id = ? AND status IN ?", document.ID, pendingStatuses).
+		Updates(map[string]interface{}{
+			"status": models.DocumentStatusFailed,
+			"error":  "cancelled",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDocumentNotInProgress
+	}
+
+	// 本实例上处理中的文档立即中断，其他实例会在心跳时发现状态变化
+	m.interrupt(document.ID)
+	return nil
+}
+
+// requeue 将处于指定状态的文档重新排队
+func (m *KnowledgeBaseManager) requeue(ctx context.Context, documentID uuid.UUID, from []string) error {
+	result := m.db.WithContext(ctx).Model(&models.Document{}).
+		Where("id = ? AND status IN ?", documentID, from).
+		Updates(map[string]interface{}{
+			"status":          models.DocumentStatusQueued,
+			"error":           "",
+			"attempts":        0,
+			"total_chunks":    0,
+			"embedded_chunks": 0,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errIngestionAborted
+	}
+
+	m.enqueue(documentID)
+	return nil
+}
+
+// interrupt 中断本实例上正在处理该文档的工作协程
+func (m *KnowledgeBaseManager) interrupt(documentID uuid.UUID) {
+	m.mu.Lock()
+	if cancel, ok := m.cancels[documentID]; ok {
+		cancel()
+	}
+	m.mu.Unlock()
+}
+
+// enqueue 将文档放入内存队列，队列已满或流水线未启动时由轮询协程拾取
+func (m *KnowledgeBaseManager) enqueue(documentID uuid.UUID) {
+	select {
+	case m.queue <- documentID:
+	default:
+		m.logger.Warn("Ingestion queue is full, document will be picked up by poller", zap.String("document_id", documentID.String()))
+	}
+}
+
+// worker 从队列中取出文档并处理
+func (m *KnowledgeBaseManager) worker() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case documentID := <-m.queue:
+			m.ingest(documentID)
+		}
+	}
+}
+
+// poll 定期回收心跳超时的文档并拾取排队中的文档
+func (m *KnowledgeBaseManager) poll() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.ingestion.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.recoverStaleDocuments()
+		m.enqueueQueuedDocuments()
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recoverStaleDocuments 将失联的文档重新排队，超过最大处理次数则标记为失败
+func (m *KnowledgeBaseManager) recoverStaleDocuments() {
+	deadline := time.Now().Add(-m.ingestion.LeaseTimeout)
+
+	if err := m.db.Model(&models.Document{}).
+		Where("status IN ? AND (heartbeat_at IS NULL OR heartbeat_at < ?) AND attempts >= ?",
+			processingStatuses, deadline, m.ingestion.MaxAttempts).
+		Updates(map[string]interface{}{
+			"status": models.DocumentStatusFailed,
+			"error":  "document exceeded maximum attempts",
+		}).Error; err != nil {
+		m.logger.Error("Failed to fail stale documents", zap.Error(err))
+	}
+
+	if err := m.db.Model(&models.Document{}).
+		Where("status IN ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", processingStatuses, deadline).
+		Update("status", models.DocumentStatusQueued).Error; err != nil {
+		m.logger.Error("Failed to requeue stale documents", zap.Error(err))
+	}
+}
+
+// enqueueQueuedDocuments 将数据库中排队的文档放入内存队列
+func (m *KnowledgeBaseManager) enqueueQueuedDocuments() {
+	var ids []uuid.UUID
+	if err := m.db.Model(&models.Document{}).
+		Where("status = ?", models.DocumentStatusQueued).
+		Order("created_at asc").
+		Limit(m.ingestion.QueueSize).
+		Pluck("id", &ids).Error; err != nil {
+		m.logger.Error("Failed to find queued documents", zap.Error(err))
+		return
+	}
+
+	for _, id := range ids {
+		m.enqueue(id)
+	}
+}
+
+// claim 以条件更新的方式认领文档，保证同一文档只被一个工作协程处理
+func (m *KnowledgeBaseManager) claim(documentID uuid.UUID) (bool, error) {
+	result := m.db.Model(&models.Document{}).
+		Where("id = ? AND status = ?", documentID, models.DocumentStatusQueued).
+		Updates(map[string]interface{}{
+			"status":          models.DocumentStatusParsing,
+			"heartbeat_at":    time.Now(),
+			"attempts":        gorm.Expr("attempts + 1"),
+			"total_chunks":    0,
+			"embedded_chunks": 0,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ingest 处理一个文档：解析、切分、分批向量化，最后替换文档的分块
+func (m *KnowledgeBaseManager) ingest(documentID uuid.UUID) {
+	claimed, err := m.claim(documentID)
+	if err != nil {
+		m.logger.Error("Failed to claim document", zap.Error(err), zap.String("document_id", documentID.String()))
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	m.cancels[documentID] = cancel
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.cancels, documentID)
+		m.mu.Unlock()
+	}()
+
+	go m.heartbeat(ctx, cancel, documentID)
+
+	var document models.Document
+	if err := m.db.WithContext(ctx).First(&document, "id = ?", documentID).Error; err != nil {
+		m.logger.Error("Failed to load document", zap.Error(err), zap.String("document_id", documentID.String()))
+		return
+	}
+
+	record, err := m.GetKnowledgeBase(ctx, document.KnowledgeBaseID.String())
+	if err == nil {
+		var chunks []Chunk
+		chunks, err = m.process(ctx, record, &document)
+		if err == nil {
+			err = m.commitChunks(ctx, record, &document, chunks)
+		}
+	}
+
+	switch {
+	case err == nil:
+	case m.ctx.Err() != nil:
+		// 服务正在关闭，文档将由心跳超时回收后重新处理
+		m.logger.Info("Document ingestion interrupted", zap.String("document_id", documentID.String()))
+	case errors.Is(err, errIngestionAborted) || ctx.Err() != nil:
+		// 文档已被取消或删除，状态由取消方更新
+		m.logger.Info("Document ingestion cancelled", zap.String("document_id", documentID.String()))
+	default:
+		m.fail(documentID, err)
+	}
+}
+
+// process 解析、切分文档并分批向量化写入向量数据库，失败时清理本次写入的向量
+func (m *KnowledgeBaseManager) process(ctx context.Context, record *models.KnowledgeBase, document *models.Document) ([]Chunk, error) {
+	// 解析
+	doc := fromRecord(document)
+	processor, err := m.processor.GetProcessor(doc.Type)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := processor.Extract(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract document: %w", err)
+	}
+
+	// 切分
+	if err := m.setStage(ctx, document.ID, models.DocumentStatusChunking, nil); err != nil {
+		return nil, err
+	}
+	chunker, err := m.chunker(record)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := ChunkSections(ctx, doc, sections, chunker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to chunk document: %w", err)
+	}
+	if len(chunks) == 0 {
+		return nil, ErrEmptyDocument
+	}
+
+	// 向量化
+	if err := m.setStage(ctx, document.ID, models.DocumentStatusEmbedding, map[string]interface{}{
+		"total_chunks": len(chunks),
+	}); err != nil {
+		return nil, err
+	}
+
+	collection := collectionName(record.ID.String())
+	inserted := make([]string, 0, len(chunks))
+	for start := 0; start < len(chunks); start += m.ingestion.EmbeddingBatchSize {
+		end := start + m.ingestion.EmbeddingBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		batch := chunks[start:end]
+
+		if err := m.embedBatch(ctx, record, document, collection, batch); err != nil {
+			m.discardVectors(collection, inserted, document.ID)
+			return nil, err
+		}
+		for _, chunk := range batch {
+			inserted = append(inserted, chunk.ID)
+		}
+
+		if err := m.setStage(ctx, document.ID, models.DocumentStatusEmbedding, map[string]interface{}{
+			"embedded_chunks": end,
+		}); err != nil {
+			m.discardVectors(collection, inserted, document.ID)
+			return nil, err
+		}
+	}
+
+	return chunks, nil
+}
+
+// embedBatch 向量化一批分块并写入向量数据库，向量化失败时按退避时间重试。
+// 文档类型和上传时间一并写入元数据，供检索时过滤
+func (m *KnowledgeBaseManager) embedBatch(ctx context.Context, record *models.KnowledgeBase, document *models.Document, collection string, batch []Chunk) error {
+	texts := make([]string, len(batch))
+	for i, chunk := range batch {
+		texts[i] = chunk.Content
+	}
+
+	var resp *EmbeddingResponse
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = m.embedding.Embed(ctx, EmbeddingRequest{
+			Texts: texts,
+			Model: record.EmbeddingModel,
+		})
+		if err == nil {
+			break
+		}
+		if attempt >= m.ingestion.EmbeddingRetries || ctx.Err() != nil {
+			return fmt.Errorf("failed to generate embeddings: %w", err)
+		}
+
+		wait := m.ingestion.RetryBackoff << attempt
+		m.logger.Warn("Embedding batch failed, retrying", zap.Int("attempt", attempt+1), zap.Duration("wait", wait), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	createdAt := document.CreatedAt.UTC().Format(time.RFC3339)
+	tags := []string(document.Tags)
+	if tags == nil {
+		tags = []string{} // 保证 tags 为数组，contains 过滤在各后端表现一致
+	}
+	ids := make([]string, len(batch))
+	metadata := make([]map[string]interface{}, len(batch))
+	for i := range batch {
+		chunk := &batch[i]
+		ids[i] = chunk.ID
+		chunk.Vector = resp.Embeddings[i]
+		metadata[i] = map[string]interface{}{
+			"content":       chunk.Content,
+			"document_id":   chunk.DocumentID,
+			"document_type": document.Type,
+			"chunk_index":   chunk.Metadata.ChunkIndex,
+			"page_number":   chunk.Metadata.PageNumber,
+			"source":        chunk.Metadata.Source,
+			"headings":      strings.Join(chunk.Metadata.Headings, headingSeparator),
+			"tags":          tags,
+			"created_at":    createdAt,
+		}
+	}
+
+	if err := m.vectorDB.InsertVectors(ctx, collection, ids, resp.Embeddings, metadata); err != nil {
+		return fmt.Errorf("failed to insert vectors: %w", err)
+	}
+	return nil
+}
+
+// setStage 推进处理中文档的状态，文档已被取消或删除时返回 errIngestionAborted
+func (m *KnowledgeBaseManager) setStage(ctx context.Context, documentID uuid.UUID, status string, updates map[string]interface{}) error {
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["status"] = status
+	updates["heartbeat_at"] = time.Now()
+
+	result := m.db.WithContext(ctx).Model(&models.Document{}).
+		Where("id = ? AND status IN ?", documentID, processingStatuses).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errIngestionAborted
+	}
+	return nil
+}
+
+// heartbeat 定期刷新文档心跳，发现文档已被取消或删除时中断处理
+func (m *KnowledgeBaseManager) heartbeat(ctx context.Context, cancel context.CancelFunc, documentID uuid.UUID) {
+	ticker := time.NewTicker(m.ingestion.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result := m.db.Model(&models.Document{}).
+				Where("id = ? AND status IN ?", documentID, processingStatuses).
+				Update("heartbeat_at", time.Now())
+			if result.Error != nil {
+				m.logger.Warn("Failed to refresh document heartbeat", zap.Error(result.Error))
+				continue
+			}
+			if result.RowsAffected == 0 {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// fail 将处理中的文档标记为失败
+func (m *KnowledgeBaseManager) fail(documentID uuid.UUID, cause error) {
+	m.logger.Warn("Document ingestion failed", zap.String("document_id", documentID.String()), zap.Error(cause))
+	if err := m.db.Model(&models.Document{}).
+		Where("id = ? AND status IN ?", documentID, processingStatuses).
+		Updates(map[string]interface{}{
+			"status": models.DocumentStatusFailed,
+			"error":  cause.Error(),
+		}).Error; err != nil {
+		m.logger.Error("Failed to mark document as failed", zap.String("document_id", documentID.String()), zap.Error(err))
+	}
+}
+
+// discardVectors 删除没有对应分块记录的向量，避免被检索到
+func (m *KnowledgeBaseManager) discardVectors(collection string, ids []string, documentID uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+	// 处理可能已被取消，使用独立的上下文清理
+	if err := m.vectorDB.DeleteVectors(context.Background(), collection, ids); err != nil {
+		m.logger.Warn("Failed to delete orphaned vectors", zap.String("document_id", documentID.String()), zap.Error(err))
+	}
+}
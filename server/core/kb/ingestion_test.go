@@ -0,0 +1,289 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+func TestEmbedBatchTagsFilter(t *testing.T) {
+	ctx := context.Background()
+	embedding := NewEmbeddingManager()
+	embedding.RegisterModel("mock", NewMockEmbeddingModel(4, "mock"))
+	vectorDB := NewInMemoryVectorDB()
+	manager := NewKnowledgeBaseManager(nil, vectorDB, embedding, nil)
+
+	record := &models.KnowledgeBase{ID: uuid.New(), EmbeddingModel: "mock"}
+	collection := collectionName(record.ID.String())
+	if err := vectorDB.CreateCollection(ctx, collection, 4); err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+
+	documents := []*models.Document{
+		{ID: uuid.New(), Type: string(TypeText), Tags: []string{"faq", "billing"}},
+		{ID: uuid.New(), Type: string(TypeText), Tags: []string{"billing"}},
+		{ID: uuid.New(), Type: string(TypeText)}, // 没有标签的文档
+	}
+	for i, document := range documents {
+		chunk := Chunk{ID: "c" + string(rune('1'+i)), DocumentID: document.ID.String(), Content: "content"}
+		if err := manager.embedBatch(ctx, record, document, collection, []Chunk{chunk}); err != nil {
+			t.Fatalf("embedBatch: %v", err)
+		}
+	}
+
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{"faq", []string{"c1"}},
+		{"billing", []string{"c1", "c2"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			filter, err := ParseFilter(map[string]interface{}{"tags": map[string]interface{}{"contains": tt.tag}})
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			results, err := vectorDB.Search(ctx, collection, []float32{0.1, 0.1, 0.1, 0.1}, 10, filter)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			got := resultIDs(results)
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search with tag %q = %v, want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" faq ", "", "faq", "FAQ", strings.Repeat("长", maxTagRunes+5)})
+	want := []string{"faq", "FAQ", strings.Repeat("长", maxTagRunes)}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("normalizeTags = %v, want %v", got, want)
+	}
+
+	if got := normalizeTags(nil); got == nil || len(got) != 0 {
+		t.Errorf("normalizeTags(nil) = %#v, want empty slice", got)
+	}
+
+	many := make([]string, maxDocumentTags+5)
+	for i := range many {
+		many[i] = string(rune('a' + i))
+	}
+	if got := normalizeTags(many); len(got) != maxDocumentTags {
+		t.Errorf("len(normalizeTags) = %d, want %d", len(got), maxDocumentTags)
+	}
+}
+
+// startIngestionContext 设置入库上下文，测试中直接同步调用 ingest 而不启动工作协程
+func startIngestionContext(t *testing.T, manager *KnowledgeBaseManager) {
+	t.Helper()
+	manager.ctx, manager.stop = context.WithCancel(context.Background())
+	t.Cleanup(manager.stop)
+}
+
+// loadDocument 从数据库读取文档的最新状态
+func loadDocument(t *testing.T, manager *KnowledgeBaseManager, document *models.Document) *models.Document {
+	t.Helper()
+	stored, err := manager.GetDocument(context.Background(), document.KnowledgeBaseID.String(), document.ID.String())
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	return stored
+}
+
+func TestIngest(t *testing.T) {
+	manager, db, record := newTestManager(t)
+	startIngestionContext(t, manager)
+	document := addTestDocument(t, manager, record, strings.Repeat("refund policy. ", 20))
+
+	manager.ingest(document.ID)
+
+	stored := loadDocument(t, manager, document)
+	if stored.Status != models.DocumentStatusIndexed || stored.Error != "" {
+		t.Fatalf("Status = %q, Error = %q, want indexed", stored.Status, stored.Error)
+	}
+	if stored.ChunkCount == 0 || stored.TotalChunks != stored.ChunkCount || stored.EmbeddedChunks != stored.ChunkCount {
+		t.Errorf("ChunkCount = %d, TotalChunks = %d, EmbeddedChunks = %d, want equal and non-zero", stored.ChunkCount, stored.TotalChunks, stored.EmbeddedChunks)
+	}
+	if stored.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", stored.Attempts)
+	}
+	if documents, chunks := counts(t, db, record.ID); documents != 1 || chunks != stored.ChunkCount {
+		t.Errorf("counts = (%d, %d), want (1, %d)", documents, chunks, stored.ChunkCount)
+	}
+
+	results, err := manager.vectorDB.Search(context.Background(), collectionName(record.ID.String()), []float32{0.1, 0.1, 0.1, 0.1}, 100, nil)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != stored.ChunkCount {
+		t.Errorf("vectors = %d, want %d", len(results), stored.ChunkCount)
+	}
+
+	// 已索引的文档不会被再次认领
+	manager.ingest(document.ID)
+	if again := loadDocument(t, manager, document); again.Attempts != 1 {
+		t.Errorf("Attempts after second ingest = %d, want 1", again.Attempts)
+	}
+}
+
+func TestIngestFailures(t *testing.T) {
+	t.Run("empty document", func(t *testing.T) {
+		manager, _, record := newTestManager(t)
+		startIngestionContext(t, manager)
+		document := addTestDocument(t, manager, record, "")
+
+		manager.ingest(document.ID)
+
+		stored := loadDocument(t, manager, document)
+		if stored.Status != models.DocumentStatusFailed || stored.Error != ErrEmptyDocument.Error() {
+			t.Errorf("Status = %q, Error = %q, want failed with %q", stored.Status, stored.Error, ErrEmptyDocument)
+		}
+	})
+
+	t.Run("embedding error", func(t *testing.T) {
+		manager, db, record := newTestManager(t)
+		startIngestionContext(t, manager)
+		manager.ingestion.EmbeddingRetries = 0
+		document := addTestDocument(t, manager, record, "refund policy")
+		if err := db.Model(&models.KnowledgeBase{}).Where("id = ?", record.ID).Update("embedding_model", "missing").Error; err != nil {
+			t.Fatal(err)
+		}
+
+		manager.ingest(document.ID)
+
+		stored := loadDocument(t, manager, document)
+		if stored.Status != models.DocumentStatusFailed || stored.Error == "" {
+			t.Errorf("Status = %q, Error = %q, want failed with error", stored.Status, stored.Error)
+		}
+		if documents, chunks := counts(t, db, record.ID); documents != 0 || chunks != 0 {
+			t.Errorf("counts = (%d, %d), want (0, 0)", documents, chunks)
+		}
+	})
+}
+
+func TestDocumentStateTransitions(t *testing.T) {
+	ctx := context.Background()
+	manager, _, record := newTestManager(t)
+	kbID := record.ID.String()
+	document := addTestDocument(t, manager, record, "hello")
+	docID := document.ID.String()
+
+	// 排队中的文档不能重试或重建索引
+	if _, err := manager.RetryDocument(ctx, kbID, docID); !errors.Is(err, ErrDocumentNotFailed) {
+		t.Errorf("RetryDocument on queued document error = %v, want %v", err, ErrDocumentNotFailed)
+	}
+	if _, err := manager.ReindexDocument(ctx, kbID, docID); !errors.Is(err, ErrDocumentInProgress) {
+		t.Errorf("ReindexDocument on queued document error = %v, want %v", err, ErrDocumentInProgress)
+	}
+
+	if err := manager.CancelDocument(ctx, kbID, docID); err != nil {
+		t.Fatalf("CancelDocument: %v", err)
+	}
+	if stored := loadDocument(t, manager, document); stored.Status != models.DocumentStatusFailed || stored.Error != "cancelled" {
+		t.Errorf("after cancel Status = %q, Error = %q, want failed and cancelled", stored.Status, stored.Error)
+	}
+	if err := manager.CancelDocument(ctx, kbID, docID); !errors.Is(err, ErrDocumentNotInProgress) {
+		t.Errorf("second CancelDocument error = %v, want %v", err, ErrDocumentNotInProgress)
+	}
+
+	retried, err := manager.RetryDocument(ctx, kbID, docID)
+	if err != nil {
+		t.Fatalf("RetryDocument: %v", err)
+	}
+	if retried.Status != models.DocumentStatusQueued || retried.Error != "" || retried.Attempts != 0 {
+		t.Errorf("after retry Status = %q, Error = %q, Attempts = %d, want queued and reset", retried.Status, retried.Error, retried.Attempts)
+	}
+
+	// 失败的文档可以重建索引
+	if err := manager.CancelDocument(ctx, kbID, docID); err != nil {
+		t.Fatalf("CancelDocument: %v", err)
+	}
+	if reindexed, err := manager.ReindexDocument(ctx, kbID, docID); err != nil || reindexed.Status != models.DocumentStatusQueued {
+		t.Errorf("ReindexDocument on failed document = %v, %v, want queued", reindexed, err)
+	}
+
+	unknown := uuid.NewString()
+	if _, err := manager.RetryDocument(ctx, kbID, unknown); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("RetryDocument on unknown document error = %v, want %v", err, ErrDocumentNotFound)
+	}
+	if _, err := manager.ReindexDocument(ctx, kbID, unknown); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("ReindexDocument on unknown document error = %v, want %v", err, ErrDocumentNotFound)
+	}
+	if err := manager.CancelDocument(ctx, kbID, unknown); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("CancelDocument on unknown document error = %v, want %v", err, ErrDocumentNotFound)
+	}
+}
+
+func TestRecoverStaleDocuments(t *testing.T) {
+	manager, db, record := newTestManager(t)
+	stale := addTestDocument(t, manager, record, "stale")
+	exhausted := addTestDocument(t, manager, record, "exhausted")
+	active := addTestDocument(t, manager, record, "active")
+
+	old := time.Now().Add(-2 * manager.ingestion.LeaseTimeout)
+	updates := []struct {
+		document  *models.Document
+		heartbeat time.Time
+		attempts  int
+	}{
+		{stale, old, 1},
+		{exhausted, old, manager.ingestion.MaxAttempts},
+		{active, time.Now(), 1},
+	}
+	for _, u := range updates {
+		if err := db.Model(&models.Document{}).Where("id = ?", u.document.ID).Updates(map[string]interface{}{
+			"status":       models.DocumentStatusEmbedding,
+			"heartbeat_at": u.heartbeat,
+			"attempts":     u.attempts,
+		}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager.recoverStaleDocuments()
+
+	tests := []struct {
+		name     string
+		document *models.Document
+		want     string
+	}{
+		{"stale", stale, models.DocumentStatusQueued},
+		{"exhausted", exhausted, models.DocumentStatusFailed},
+		{"active", active, models.DocumentStatusEmbedding},
+	}
+	for _, tt := range tests {
+		if got := loadDocument(t, manager, tt.document).Status; got != tt.want {
+			t.Errorf("%s document Status = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStartIngestion(t *testing.T) {
+	manager, _, record := newTestManager(t)
+	manager.StartIngestion(IngestionConfig{Workers: 1, PollInterval: 10 * time.Millisecond})
+	t.Cleanup(manager.StopIngestion)
+
+	document := addTestDocument(t, manager, record, "refund policy")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored := loadDocument(t, manager, document)
+		if stored.Status == models.DocumentStatusIndexed {
+			break
+		}
+		if stored.Status == models.DocumentStatusFailed || time.Now().After(deadline) {
+			t.Fatalf("Status = %q, Error = %q, want indexed", stored.Status, stored.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,306 @@
+package kb
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// keywordDocument 关键词索引中的一个分块
+type keywordDocument struct {
+	id       string
+	content  string
+	metadata map[string]interface{}
+	length   int
+}
+
+// keywordPosting 词项在分块中的出现次数
+type keywordPosting struct {
+	doc int
+	tf  int
+}
+
+// bm25Index 知识库分块的BM25倒排索引，构建后只读，可并发查询
+type bm25Index struct {
+	docs      []keywordDocument
+	postings  map[string][]keywordPosting
+	avgLength float64
+}
+
+// newBM25Index 为分块建立倒排索引
+func newBM25Index(docs []keywordDocument) *bm25Index {
+	index := &bm25Index{
+		docs:     docs,
+		postings: make(map[string][]keywordPosting),
+	}
+
+	total := 0
+	for i := range docs {
+		tokens := keywordTokens(docs[i].content)
+		docs[i].length = len(tokens)
+		total += len(tokens)
+
+		counts := make(map[string]int)
+		for _, token := range tokens {
+			counts[token]++
+		}
+		for token, tf := range counts {
+			index.postings[token] = append(index.postings[token], keywordPosting{doc: i, tf: tf})
+		}
+	}
+	if len(docs) > 0 {
+		index.avgLength = float64(total) / float64(len(docs))
+	}
+	return index
+}
+
+// search 返回BM25得分最高的 topK 个满足过滤条件的分块
+func (idx *bm25Index) search(query string, topK int, filter *Filter) []SearchResult {
+	if len(idx.docs) == 0 {
+		return nil
+	}
+
+	// 查询中重复的词项只计一次
+	seen := make(map[string]bool)
+	scores := make(map[int]float64)
+	n := float64(len(idx.docs))
+	for _, token := range keywordTokens(query) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+
+		postings := idx.postings[token]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, posting := range postings {
+			tf := float64(posting.tf)
+			norm := 1 - bm25B + bm25B*float64(idx.docs[posting.doc].length)/idx.avgLength
+			scores[posting.doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	matched := make([]int, 0, len(scores))
+	for doc := range scores {
+		if filter.Match(idx.docs[doc].metadata) {
+			matched = append(matched, doc)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if scores[matched[i]] != scores[matched[j]] {
+			return scores[matched[i]] > scores[matched[j]]
+		}
+		return idx.docs[matched[i]].id < idx.docs[matched[j]].id
+	})
+	if len(matched) > topK {
+		matched = matched[:topK]
+	}
+
+	results := make([]SearchResult, len(matched))
+	for i, doc := range matched {
+		results[i] = SearchResult{
+			ChunkID:  idx.docs[doc].id,
+			Content:  idx.docs[doc].content,
+			Score:    float32(scores[doc]),
+			Metadata: idx.docs[doc].metadata,
+		}
+	}
+	return results
+}
+
+// keywordTokens 将文本切分为检索词项。中日韩文字没有空格分词，按单字和相邻两字切分；
+// 字母和数字组成的词转为小写，其中以 _ - . 连接的标识符（如 ERR-1024、v1.2.3、user_id）
+// 既作为整体，也拆分为各部分，以便精确匹配编号和错误码
+func keywordTokens(text string) []string {
+	var tokens []string
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case isCJKLetter(r):
+			start := i
+			for i < len(runes) && isCJKLetter(runes[i]) {
+				i++
+			}
+			for j := start; j < i; j++ {
+				tokens = append(tokens, string(runes[j]))
+				if j+1 < i {
+					tokens = append(tokens, string(runes[j:j+2]))
+				}
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			start := i
+			compound := false
+			for i < len(runes) {
+				if isWordRune(runes[i]) {
+					i++
+					continue
+				}
+				// 连接符两侧都是字母或数字时视为标识符的一部分
+				if isIdentifierConnector(runes[i]) && i+1 < len(runes) && isWordRune(runes[i+1]) {
+					compound = true
+					i++
+					continue
+				}
+				break
+			}
+			word := strings.ToLower(string(runes[start:i]))
+			tokens = append(tokens, word)
+			if compound {
+				for _, part := range strings.FieldsFunc(word, isIdentifierConnector) {
+					tokens = append(tokens, part)
+				}
+			}
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+// isCJKLetter 是否为中日韩文字，不含全角标点
+func isCJKLetter(r rune) bool {
+	return isCJK(r) && unicode.IsLetter(r)
+}
+
+// isWordRune 是否为组成非中日韩词语的字符
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !isCJKLetter(r)
+}
+
+// isIdentifierConnector 是否为标识符中的连接符
+func isIdentifierConnector(r rune) bool {
+	return r == '_' || r == '-' || r == '.'
+}
+
+// keywordIndexCache 按知识库缓存关键词索引，超出容量时淘汰最久未使用的索引。
+// 知识库的分块提交或删除时会更新知识库记录，索引按记录的更新时间和分块数判断是否过期
+type keywordIndexCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+}
+
+// keywordIndexEntry 缓存条目，同一知识库的索引只由一个协程构建
+type keywordIndexEntry struct {
+	knowledgeBaseID string
+	version         string
+	index           *bm25Index
+	mu              sync.Mutex
+}
+
+// newKeywordIndexCache 创建最多保存 capacity 个知识库索引的缓存
+func newKeywordIndexCache(capacity int) *keywordIndexCache {
+	return &keywordIndexCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// entry 获取知识库的缓存条目，不存在时创建
+func (c *keywordIndexCache) entry(knowledgeBaseID string) *keywordIndexEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[knowledgeBaseID]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*keywordIndexEntry)
+	}
+
+	entry := &keywordIndexEntry{knowledgeBaseID: knowledgeBaseID}
+	c.entries[knowledgeBaseID] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*keywordIndexEntry).knowledgeBaseID)
+	}
+	return entry
+}
+
+// keywordIndex 返回知识库的关键词索引，索引过期时从分块表重建
+func (m *KnowledgeBaseManager) keywordIndex(ctx context.Context, cache *keywordIndexCache, record *models.KnowledgeBase) (*bm25Index, error) {
+	version := fmt.Sprintf("%d:%d", record.UpdatedAt.UnixNano(), record.ChunkCount)
+	entry := cache.entry(record.ID.String())
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.index != nil && entry.version == version {
+		return entry.index, nil
+	}
+
+	docs, err := m.keywordDocuments(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	entry.index = newBM25Index(docs)
+	entry.version = version
+	return entry.index, nil
+}
+
+// keywordDocuments 读取知识库的所有分块，元数据与写入向量数据库的一致，以便使用相同的过滤条件
+func (m *KnowledgeBaseManager) keywordDocuments(ctx context.Context, record *models.KnowledgeBase) ([]keywordDocument, error) {
+	var rows []struct {
+		ID           string
+		DocumentID   string
+		ChunkIndex   int
+		PageNumber   int
+		Headings     string
+		Content      string
+		DocumentName string
+		DocumentType string
+		Tags         models.StringArray `gorm:"type:text[]"`
+		CreatedAt    time.Time
+	}
+	if err := m.db.WithContext(ctx).Table("chunks").
+		Select("chunks.id, chunks.document_id, chunks.chunk_index, chunks.page_number, chunks.headings, chunks.content, "+
+			"documents.name AS document_name, documents.type AS document_type, documents.tags, documents.created_at").
+		Joins("JOIN documents ON documents.id = chunks.document_id").
+		Where("chunks.knowledge_base_id = ?", record.ID).
+		Order("chunks.document_id, chunks.chunk_index").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load chunks: %w", err)
+	}
+
+	docs := make([]keywordDocument, len(rows))
+	for i, row := range rows {
+		tags := []string(row.Tags)
+		if tags == nil {
+			tags = []string{}
+		}
+		docs[i] = keywordDocument{
+			id:      row.ID,
+			content: row.Content,
+			metadata: map[string]interface{}{
+				"document_id":   row.DocumentID,
+				"document_type": row.DocumentType,
+				"chunk_index":   row.ChunkIndex,
+				"page_number":   row.PageNumber,
+				"source":        row.DocumentName,
+				"headings":      row.Headings,
+				"tags":          tags,
+				"created_at":    row.CreatedAt.UTC().Format(time.RFC3339),
+			},
+		}
+	}
+	return docs, nil
+}
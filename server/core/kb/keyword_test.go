@@ -0,0 +1,116 @@
+package kb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+)
+
+func TestKeywordTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Refund Policy", []string{"refund", "policy"}},
+		{"退款政策", []string{"退", "退款", "款", "款政", "政", "政策", "策"}},
+		{"错误ERR-1024", []string{"错", "错误", "误", "err-1024", "err", "1024"}},
+		{"v1.2.3 user_id.", []string{"v1.2.3", "v1", "2", "3", "user_id", "user", "id"}},
+	}
+	for _, tt := range tests {
+		if got := keywordTokens(tt.text); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("keywordTokens(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBM25Search(t *testing.T) {
+	index := newBM25Index([]keywordDocument{
+		{id: "c1", content: "refund policy: refunds are processed within 7 days"},
+		{id: "c2", content: "refund refund refund"},
+		{id: "c3", content: "shipping policy"},
+		{id: "c4", content: "error code ERR-1024 means the refund failed"},
+	})
+
+	got := resultIDs(index.search("refund", 10, nil))
+	// c2 词频最高；c1 与 c4 各出现一次，c1 更短排在前面；refunds 不做词形还原
+	if strings.Join(got, ",") != "c2,c1,c4" {
+		t.Errorf("search(refund) = %v, want [c2 c1 c4]", got)
+	}
+
+	if got := resultIDs(index.search("ERR-1024", 10, nil)); strings.Join(got, ",") != "c4" {
+		t.Errorf("search(ERR-1024) = %v, want [c4]", got)
+	}
+	if got := resultIDs(index.search("refund", 1, nil)); len(got) != 1 {
+		t.Errorf("search with topK 1 returned %d results", len(got))
+	}
+	if got := index.search("missing", 10, nil); len(got) != 0 {
+		t.Errorf("search(missing) = %v, want no results", resultIDs(got))
+	}
+}
+
+func TestKeywordSearchTagsFilter(t *testing.T) {
+	index := newBM25Index([]keywordDocument{
+		{id: "c1", content: "refund policy", metadata: map[string]interface{}{"tags": []string{"faq"}}},
+		{id: "c2", content: "refund process", metadata: map[string]interface{}{"tags": []string{}}},
+	})
+
+	filter, err := ParseFilter(map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}})
+	if err != nil {
+		t.Fatalf("ParseFilter: %v", err)
+	}
+	got := resultIDs(index.search("refund", 10, filter))
+	if strings.Join(got, ",") != "c1" {
+		t.Errorf("search with tag filter = %v, want [c1]", got)
+	}
+}
+
+func TestKeywordDocumentsTags(t *testing.T) {
+	db := testdb.Open(t, &models.Document{}, &models.Chunk{})
+	manager := NewKnowledgeBaseManager(db, NewInMemoryVectorDB(), NewEmbeddingManager(), nil)
+	record := &models.KnowledgeBase{ID: uuid.New()}
+
+	tagged := models.Document{KnowledgeBaseID: record.ID, Name: "faq.md", Type: string(TypeMarkdown), Tags: []string{"faq", "a,b"}, Status: models.DocumentStatusIndexed, EmbeddingModel: "mock"}
+	untagged := models.Document{KnowledgeBaseID: record.ID, Name: "notes.txt", Type: string(TypeText), Status: models.DocumentStatusIndexed, EmbeddingModel: "mock"}
+	for _, document := range []*models.Document{&tagged, &untagged} {
+		if err := db.Create(document).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	chunks := []models.Chunk{
+		{ID: "c1", DocumentID: tagged.ID, KnowledgeBaseID: record.ID, Content: "refund policy"},
+		{ID: "c2", DocumentID: untagged.ID, KnowledgeBaseID: record.ID, Content: "refund process"},
+		{ID: "c3", DocumentID: uuid.New(), KnowledgeBaseID: uuid.New(), Content: "other knowledge base"},
+	}
+	if err := db.Create(&chunks).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := manager.keywordDocuments(context.Background(), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{}
+	for _, doc := range docs {
+		docTags, ok := doc.metadata["tags"].([]string)
+		if !ok {
+			t.Fatalf("%s tags = %#v, want []string", doc.id, doc.metadata["tags"])
+		}
+		tags[doc.id] = strings.Join(docTags, "|")
+	}
+	if len(tags) != 2 || tags["c1"] != "faq|a,b" || tags["c2"] != "" {
+		t.Errorf("tags = %v, want c1 tagged and c2 untagged", tags)
+	}
+
+	// 从数据库读出的标签与写入向量数据库的元数据使用同一过滤条件
+	filter, err := ParseFilter(map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(newBM25Index(docs).search("refund", 10, filter)); strings.Join(got, ",") != "c1" {
+		t.Errorf("search with tag filter = %v, want [c1]", got)
+	}
+}
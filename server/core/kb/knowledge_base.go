@@ -2,396 +2,500 @@ package kb
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"mime/multipart"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// KnowledgeBase 知识库
-type KnowledgeBase struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	DocumentCount int       `json:"document_count"`
-	ChunkCount   int       `json:"chunk_count"`
-	EmbeddingModel string    `json:"embedding_model"`
-	Metadata     interface{} `json:"metadata,omitempty"`
-}
+// chunkInsertBatchSize 分块记录批量写入的大小
+const chunkInsertBatchSize = 100
+
+// headingSeparator 分块标题路径的连接符
+const headingSeparator = " > "
+
+// 文档标签的数量与长度上限
+const (
+	maxDocumentTags = 20
+	maxTagRunes     = 50
+)
 
-// KnowledgeBaseManager 知识库管理器
+var (
+	// ErrKnowledgeBaseNotFound 知识库不存在
+	ErrKnowledgeBaseNotFound = errors.New("knowledge base not found")
+	// ErrDocumentNotFound 文档不存在
+	ErrDocumentNotFound = errors.New("document not found")
+	// ErrEmptyDocument 文档处理后没有产生任何分块
+	ErrEmptyDocument = errors.New("document processing resulted in no chunks")
+	// ErrDuplicateDocument 知识库中已有内容相同的文档
+	ErrDuplicateDocument = errors.New("document with the same content already exists")
+)
+
+// KnowledgeBaseManager 知识库管理器：数据库保存知识库、文档与分块，向量数据库保存分块向量
 type KnowledgeBaseManager struct {
+	db        *gorm.DB
 	vectorDB  VectorDatabase
 	embedding *EmbeddingManager
 	processor *DocumentProcessorRegistry
-	knowledgeBases map[string]*KnowledgeBase // id -> KnowledgeBase
-	documents     map[string][]*Document     // knowledgeBaseId -> []Document
-	mu            sync.RWMutex
+
+	// 文档入库流水线，见 ingestion.go
+	ingestion IngestionConfig
+	queue     chan uuid.UUID
+	cancels   map[uuid.UUID]context.CancelFunc
+	mu        sync.Mutex
+	ctx       context.Context
+	stop      context.CancelFunc
+	wg        sync.WaitGroup
+
+	logger *zap.Logger
 }
 
 // NewKnowledgeBaseManager 创建知识库管理器
-func NewKnowledgeBaseManager(vectorDB VectorDatabase, embedding *EmbeddingManager, processor *DocumentProcessorRegistry) *KnowledgeBaseManager {
+func NewKnowledgeBaseManager(db *gorm.DB, vectorDB VectorDatabase, embedding *EmbeddingManager, processor *DocumentProcessorRegistry) *KnowledgeBaseManager {
 	return &KnowledgeBaseManager{
-		vectorDB:       vectorDB,
-		embedding:      embedding,
-		processor:      processor,
-		knowledgeBases: make(map[string]*KnowledgeBase),
-		documents:      make(map[string][]*Document),
+		db:        db,
+		vectorDB:  vectorDB,
+		embedding: embedding,
+		processor: processor,
+		ingestion: DefaultIngestionConfig(),
+		cancels:   make(map[uuid.UUID]context.CancelFunc),
+		logger:    zap.L().With(zap.String("component", "knowledge_base")),
 	}
 }
 
-// CreateKnowledgeBase 创建知识库
-func (m *KnowledgeBaseManager) CreateKnowledgeBase(ctx context.Context, name, description, embeddingModel string) (*KnowledgeBase, error) {
+// collectionName 返回知识库对应的向量集合名，Milvus集合名不允许包含连字符
+func collectionName(knowledgeBaseID string) string {
+	return "kb_" + strings.ReplaceAll(knowledgeBaseID, "-", "")
+}
+
+// CreateKnowledgeBase 保存知识库记录并创建向量集合，集合创建失败时不保存记录
+func (m *KnowledgeBaseManager) CreateKnowledgeBase(ctx context.Context, record *models.KnowledgeBase) error {
 	// 验证向量模型是否存在
-	if _, err := m.embedding.GetModel(embeddingModel); err != nil {
-		return nil, fmt.Errorf("invalid embedding model: %w", err)
-	}
-	
-	now := time.Now()
-	kbID := uuid.New().String()
-	
-	// 创建知识库对象
-	kb := &KnowledgeBase{
-		ID:             kbID,
-		Name:           name,
-		Description:    description,
-		CreatedAt:      now,
-		UpdatedAt:      now,
-		DocumentCount:  0,
-		ChunkCount:     0,
-		EmbeddingModel: embeddingModel,
-	}
-	
-	// 创建向量数据库集合
-	model, _ := m.embedding.GetModel(embeddingModel)
-	dimension := model.Dimensions()
-	if err := m.vectorDB.CreateCollection(ctx, kbID, dimension); err != nil {
-		return nil, fmt.Errorf("failed to create vector collection: %w", err)
-	}
-	
-	// 保存知识库
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	m.knowledgeBases[kbID] = kb
-	m.documents[kbID] = []*Document{}
-	
-	return kb, nil
+	model, err := m.embedding.GetModel(record.EmbeddingModel)
+	if err != nil {
+		return fmt.Errorf("invalid embedding model: %w", err)
+	}
+
+	// 验证分块和检索设置
+	if _, err := m.chunker(record); err != nil {
+		return err
+	}
+	if err := ValidateRetrievalSettings(record); err != nil {
+		return err
+	}
+
+	// 创建时零值字段会被列默认值替换，分块重叠或关键词权重为0时需要在创建后写回
+	overlap, keywordWeight := record.ChunkOverlap, record.KeywordWeight
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		if record.ChunkOverlap != overlap || record.KeywordWeight != keywordWeight {
+			if err := tx.Model(record).Updates(map[string]interface{}{
+				"chunk_overlap":  overlap,
+				"keyword_weight": keywordWeight,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		if err := m.vectorDB.CreateCollection(ctx, collectionName(record.ID.String()), model.Dimensions()); err != nil {
+			return fmt.Errorf("failed to create vector collection: %w", err)
+		}
+		return nil
+	})
 }
 
-// GetKnowledgeBase 获取知识库
-func (m *KnowledgeBaseManager) GetKnowledgeBase(id string) (*KnowledgeBase, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
-	kb, exists := m.knowledgeBases[id]
-	if !exists {
-		return nil, errors.New("knowledge base not found")
-	}
-	
-	return kb, nil
+// EnsureCollections 确保所有知识库的向量集合存在，启动时调用
+func (m *KnowledgeBaseManager) EnsureCollections(ctx context.Context) error {
+	records, err := m.ListKnowledgeBases(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		model, err := m.embedding.GetModel(record.EmbeddingModel)
+		if err == nil {
+			err = m.vectorDB.CreateCollection(ctx, collectionName(record.ID.String()), model.Dimensions())
+		}
+		if err != nil {
+			m.logger.Warn("Failed to ensure vector collection",
+				zap.String("knowledge_base_id", record.ID.String()), zap.Error(err))
+		}
+	}
+	return nil
 }
 
-// ListKnowledgeBases 列出所有知识库
-func (m *KnowledgeBaseManager) ListKnowledgeBases() []*KnowledgeBase {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
-	kbs := make([]*KnowledgeBase, 0, len(m.knowledgeBases))
-	for _, kb := range m.knowledgeBases {
-		kbs = append(kbs, kb)
-	}
-	
-	return kbs
+// GetKnowledgeBase 获取知识库
+func (m *KnowledgeBaseManager) GetKnowledgeBase(ctx context.Context, id string) (*models.KnowledgeBase, error) {
+	kbID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrKnowledgeBaseNotFound
+	}
+
+	var record models.KnowledgeBase
+	if err := m.db.WithContext(ctx).First(&record, "id = ?", kbID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKnowledgeBaseNotFound
+		}
+		return nil, err
+	}
+	return &record, nil
 }
 
-// UpdateKnowledgeBase 更新知识库
-func (m *KnowledgeBaseManager) UpdateKnowledgeBase(id, name, description string) (*KnowledgeBase, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	kb, exists := m.knowledgeBases[id]
-	if !exists {
-		return nil, errors.New("knowledge base not found")
-	}
-	
-	if name != "" {
-		kb.Name = name
-	}
-	
-	if description != "" {
-		kb.Description = description
-	}
-	
-	kb.UpdatedAt = time.Now()
-	
-	return kb, nil
+// ListKnowledgeBases 列出所有知识库
+func (m *KnowledgeBaseManager) ListKnowledgeBases(ctx context.Context) ([]models.KnowledgeBase, error) {
+	var records []models.KnowledgeBase
+	if err := m.db.WithContext(ctx).Order("created_at").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
 }
 
-// DeleteKnowledgeBase 删除知识库
+// DeleteKnowledgeBase 删除知识库及其文档、分块和向量集合
 func (m *KnowledgeBaseManager) DeleteKnowledgeBase(ctx context.Context, id string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	if _, exists := m.knowledgeBases[id]; !exists {
-		return errors.New("knowledge base not found")
-	}
-	
-	// 删除向量数据库集合
-	if err := m.vectorDB.DropCollection(ctx, id); err != nil {
+	record, err := m.GetKnowledgeBase(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Chunk{}, "knowledge_base_id = ?", record.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Document{}, "knowledge_base_id = ?", record.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(record).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// 记录删除后再删除向量集合，失败时只留下无引用的集合
+	if err := m.vectorDB.DropCollection(ctx, collectionName(id)); err != nil {
 		return fmt.Errorf("failed to drop vector collection: %w", err)
 	}
-	
-	// 删除知识库和相关文档
-	delete(m.knowledgeBases, id)
-	delete(m.documents, id)
-	
 	return nil
 }
 
 // AddDocument 添加文档到知识库
-func (m *KnowledgeBaseManager) AddDocument(ctx context.Context, knowledgeBaseID string, file *multipart.FileHeader) (*Document, error) {
-	m.mu.Lock()
-	kb, exists := m.knowledgeBases[knowledgeBaseID]
-	if !exists {
-		m.mu.Unlock()
-		return nil, errors.New("knowledge base not found")
-	}
-	m.mu.Unlock()
-	
+func (m *KnowledgeBaseManager) AddDocument(ctx context.Context, knowledgeBaseID string, file *multipart.FileHeader, tags []string) (*models.Document, error) {
 	// 创建文档
 	doc, err := NewDocument(knowledgeBaseID, file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create document: %w", err)
 	}
-	
-	// 处理文档
-	chunks, err := m.processor.ProcessDocument(doc)
+	doc.Tags = tags
+
+	return m.addDocument(ctx, doc)
+}
+
+// AddTextDocument 添加文本到知识库
+func (m *KnowledgeBaseManager) AddTextDocument(ctx context.Context, knowledgeBaseID, name, content string, docType DocumentType, tags []string) (*models.Document, error) {
+	doc := NewTextDocument(knowledgeBaseID, name, content, docType)
+	doc.Tags = tags
+	return m.addDocument(ctx, doc)
+}
+
+// addDocument 保存文档记录并放入入库队列，由工作协程异步解析、切分和向量化
+func (m *KnowledgeBaseManager) addDocument(ctx context.Context, doc *Document) (*models.Document, error) {
+	record, err := m.GetKnowledgeBase(ctx, doc.KnowledgeBaseID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process document: %w", err)
+		return nil, err
+	}
+
+	// 在入库前检查文档类型，避免排队后才失败
+	if _, err := m.processor.GetProcessor(doc.Type); err != nil {
+		return nil, err
 	}
-	
-	if len(chunks) == 0 {
-		return nil, errors.New("document processing resulted in no chunks")
+
+	sum := sha256.Sum256([]byte(doc.Content))
+	checksum := hex.EncodeToString(sum[:])
+
+	// 同一知识库中内容相同的文档只保留一份，失败的文档可以重新上传
+	var duplicates int64
+	if err := m.db.WithContext(ctx).Model(&models.Document{}).
+		Where("knowledge_base_id = ? AND checksum = ? AND status <> ?", record.ID, checksum, models.DocumentStatusFailed).
+		Count(&duplicates).Error; err != nil {
+		return nil, err
+	}
+	if duplicates > 0 {
+		return nil, ErrDuplicateDocument
+	}
+
+	document := models.Document{
+		KnowledgeBaseID: record.ID,
+		Name:            doc.Name,
+		Type:            string(doc.Type),
+		Size:            doc.Size,
+		Checksum:        checksum,
+		Tags:            normalizeTags(doc.Tags),
+		Status:          models.DocumentStatusQueued,
+		EmbeddingModel:  record.EmbeddingModel,
+		Content:         []byte(doc.Content),
+	}
+	if err := m.db.WithContext(ctx).Create(&document).Error; err != nil {
+		return nil, err
 	}
-	
-	// 转换文档块为文本
-	texts := make([]string, len(chunks))
+
+	m.enqueue(document.ID)
+
+	// 返回的记录不包含原始内容
+	document.Content = nil
+	return &document, nil
+}
+
+// commitChunks 在事务中用新分块替换文档的旧分块并将文档标记为已索引，提交后删除旧向量。
+// 文档行加锁，处理期间被取消或删除的文档不会提交；知识库计数以增量更新，并发上传不会互相覆盖
+func (m *KnowledgeBaseManager) commitChunks(ctx context.Context, record *models.KnowledgeBase, document *models.Document, chunks []Chunk) error {
+	collection := collectionName(record.ID.String())
+	newChunkIDs := make([]string, len(chunks))
+	rows := make([]models.Chunk, len(chunks))
 	for i, chunk := range chunks {
-		texts[i] = chunk.Content
+		newChunkIDs[i] = chunk.ID
+		rows[i] = models.Chunk{
+			ID:              chunk.ID,
+			DocumentID:      document.ID,
+			KnowledgeBaseID: record.ID,
+			ChunkIndex:      chunk.Metadata.ChunkIndex,
+			PageNumber:      chunk.Metadata.PageNumber,
+			Headings:        strings.Join(chunk.Metadata.Headings, headingSeparator),
+			Content:         chunk.Content,
+		}
 	}
-	
-	// 生成向量嵌入
-	resp, err := m.embedding.Embed(ctx, EmbeddingRequest{
-		Texts: texts,
-		Model: kb.EmbeddingModel,
+
+	var oldChunkIDs []string
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.Document
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "status", "chunk_count", "indexed_at").
+			First(&current, "id = ?", document.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errIngestionAborted
+			}
+			return err
+		}
+		if current.Status != models.DocumentStatusEmbedding {
+			return errIngestionAborted
+		}
+
+		if err := tx.Model(&models.Chunk{}).Where("document_id = ?", document.ID).Pluck("id", &oldChunkIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Chunk{}, "document_id = ?", document.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(rows, chunkInsertBatchSize).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Document{}).Where("id = ?", document.ID).Updates(map[string]interface{}{
+			"status":          models.DocumentStatusIndexed,
+			"error":           "",
+			"chunk_count":     len(chunks),
+			"embedding_model": record.EmbeddingModel,
+			"indexed_at":      time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+
+		// 有已提交分块的文档计入知识库统计，重建索引时只更新分块数的差值
+		documentDelta, chunkDelta := 1, len(chunks)
+		if current.IndexedAt != nil {
+			documentDelta, chunkDelta = 0, len(chunks)-current.ChunkCount
+		}
+		return tx.Model(&models.KnowledgeBase{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+			"document_count": gorm.Expr("document_count + ?", documentDelta),
+			"chunk_count":    gorm.Expr("chunk_count + ?", chunkDelta),
+		}).Error
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
-	}
-	
-	// 准备向量插入数据
-	ids := make([]string, len(chunks))
-	vectors := resp.Embeddings
-	metadata := make([]map[string]interface{}, len(chunks))
-	
-	for i, chunk := range chunks {
-		ids[i] = chunk.ID
-		chunk.Vector = vectors[i]
-		
-		// 设置元数据
-		metadata[i] = map[string]interface{}{
-			"content":      chunk.Content,
-			"document_id":  chunk.DocumentID,
-			"chunk_index":  chunk.Metadata.ChunkIndex,
-			"page_number":  chunk.Metadata.PageNumber,
-			"source":       chunk.Metadata.Source,
+		m.discardVectors(collection, newChunkIDs, document.ID)
+		return err
+	}
+
+	if len(oldChunkIDs) > 0 {
+		if err := m.vectorDB.DeleteVectors(ctx, collection, oldChunkIDs); err != nil {
+			m.logger.Warn("Failed to delete replaced vectors", zap.String("document_id", document.ID.String()), zap.Error(err))
 		}
 	}
-	
-	// 插入向量
-	if err := m.vectorDB.InsertVectors(ctx, knowledgeBaseID, ids, vectors, metadata); err != nil {
-		return nil, fmt.Errorf("failed to insert vectors: %w", err)
-	}
-	
-	// 更新知识库信息
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	kb.DocumentCount++
-	kb.ChunkCount += len(chunks)
-	kb.UpdatedAt = time.Now()
-	
-	// 保存文档
-	m.documents[knowledgeBaseID] = append(m.documents[knowledgeBaseID], doc)
-	
-	return doc, nil
+	return nil
 }
 
-// AddTextDocument 添加文本到知识库
-func (m *KnowledgeBaseManager) AddTextDocument(ctx context.Context, knowledgeBaseID, name, content string, docType DocumentType) (*Document, error) {
-	m.mu.Lock()
-	kb, exists := m.knowledgeBases[knowledgeBaseID]
-	if !exists {
-		m.mu.Unlock()
-		return nil, errors.New("knowledge base not found")
-	}
-	m.mu.Unlock()
-	
-	// 创建文档
-	doc := NewTextDocument(knowledgeBaseID, name, content, docType)
-	
-	// 处理文档
-	chunks, err := m.processor.ProcessDocument(doc)
+// GetDocuments 获取知识库的所有文档
+func (m *KnowledgeBaseManager) GetDocuments(ctx context.Context, knowledgeBaseID string) ([]models.Document, error) {
+	record, err := m.GetKnowledgeBase(ctx, knowledgeBaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []models.Document
+	if err := m.db.WithContext(ctx).Omit("content").
+		Where("knowledge_base_id = ?", record.ID).
+		Order("created_at").
+		Find(&documents).Error; err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// GetDocument 获取知识库中的单个文档，包含原始内容
+func (m *KnowledgeBaseManager) GetDocument(ctx context.Context, knowledgeBaseID, documentID string) (*models.Document, error) {
+	docID, err := uuid.Parse(documentID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process document: %w", err)
+		return nil, ErrDocumentNotFound
 	}
-	
-	if len(chunks) == 0 {
-		return nil, errors.New("document processing resulted in no chunks")
+
+	var document models.Document
+	if err := m.db.WithContext(ctx).
+		Where("id = ? AND knowledge_base_id = ?", docID, knowledgeBaseID).
+		First(&document).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, err
 	}
-	
-	// 转换文档块为文本
-	texts := make([]string, len(chunks))
-	for i, chunk := range chunks {
-		texts[i] = chunk.Content
+	return &document, nil
+}
+
+// ReindexDocument 将已索引或失败的文档重新排队，使用知识库当前的向量模型重新分块和向量化。
+// 新分块提交前原有的分块和向量保持可用
+func (m *KnowledgeBaseManager) ReindexDocument(ctx context.Context, knowledgeBaseID, documentID string) (*models.Document, error) {
+	document, err := m.GetDocument(ctx, knowledgeBaseID, documentID)
+	if err != nil {
+		return nil, err
 	}
-	
-	// 生成向量嵌入
-	resp, err := m.embedding.Embed(ctx, EmbeddingRequest{
-		Texts: texts,
-		Model: kb.EmbeddingModel,
+
+	if err := m.requeue(ctx, document.ID, []string{models.DocumentStatusIndexed, models.DocumentStatusFailed}); err != nil {
+		if errors.Is(err, errIngestionAborted) {
+			return nil, ErrDocumentInProgress
+		}
+		return nil, err
+	}
+	return m.GetDocument(ctx, knowledgeBaseID, documentID)
+}
+
+// DeleteDocument 从知识库删除文档及其分块和向量
+func (m *KnowledgeBaseManager) DeleteDocument(ctx context.Context, knowledgeBaseID, documentID string) error {
+	document, err := m.GetDocument(ctx, knowledgeBaseID, documentID)
+	if err != nil {
+		return err
+	}
+
+	var chunkIDs []string
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.Document
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "status", "chunk_count", "indexed_at").
+			First(&current, "id = ?", document.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrDocumentNotFound
+			}
+			return err
+		}
+
+		if err := tx.Model(&models.Chunk{}).Where("document_id = ?", document.ID).Pluck("id", &chunkIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Chunk{}, "document_id = ?", document.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Document{}, "id = ?", document.ID).Error; err != nil {
+			return err
+		}
+
+		if current.IndexedAt == nil {
+			return nil
+		}
+		return tx.Model(&models.KnowledgeBase{}).Where("id = ?", document.KnowledgeBaseID).Updates(map[string]interface{}{
+			"document_count": gorm.Expr("document_count - 1"),
+			"chunk_count":    gorm.Expr("chunk_count - ?", current.ChunkCount),
+		}).Error
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
-	}
-	
-	// 准备向量插入数据
-	ids := make([]string, len(chunks))
-	vectors := resp.Embeddings
-	metadata := make([]map[string]interface{}, len(chunks))
-	
-	for i, chunk := range chunks {
-		ids[i] = chunk.ID
-		chunk.Vector = vectors[i]
-		
-		// 设置元数据
-		metadata[i] = map[string]interface{}{
-			"content":      chunk.Content,
-			"document_id":  chunk.DocumentID,
-			"chunk_index":  chunk.Metadata.ChunkIndex,
-			"page_number":  chunk.Metadata.PageNumber,
-			"source":       chunk.Metadata.Source,
+		return err
+	}
+
+	// 中断正在处理该文档的工作协程，已写入的新向量由工作协程清理
+	m.interrupt(document.ID)
+
+	// 删除文档关联的所有向量
+	if len(chunkIDs) > 0 {
+		if err := m.vectorDB.DeleteVectors(ctx, collectionName(knowledgeBaseID), chunkIDs); err != nil {
+			return fmt.Errorf("failed to delete vectors: %w", err)
 		}
 	}
-	
-	// 插入向量
-	if err := m.vectorDB.InsertVectors(ctx, knowledgeBaseID, ids, vectors, metadata); err != nil {
-		return nil, fmt.Errorf("failed to insert vectors: %w", err)
-	}
-	
-	// 更新知识库信息
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	kb.DocumentCount++
-	kb.ChunkCount += len(chunks)
-	kb.UpdatedAt = time.Now()
-	
-	// 保存文档
-	m.documents[knowledgeBaseID] = append(m.documents[knowledgeBaseID], doc)
-	
-	return doc, nil
+
+	return nil
 }
 
-// GetDocuments 获取知识库的所有文档
-func (m *KnowledgeBaseManager) GetDocuments(knowledgeBaseID string) ([]*Document, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
-	if _, exists := m.knowledgeBases[knowledgeBaseID]; !exists {
-		return nil, errors.New("knowledge base not found")
-	}
-	
-	docs, exists := m.documents[knowledgeBaseID]
-	if !exists {
-		return []*Document{}, nil
-	}
-	
-	return docs, nil
+// chunker 按知识库的分块设置创建分块器
+func (m *KnowledgeBaseManager) chunker(record *models.KnowledgeBase) (Chunker, error) {
+	return NewChunker(ChunkingConfig{
+		Strategy:       record.ChunkStrategy,
+		Size:           record.ChunkSize,
+		Overlap:        record.ChunkOverlap,
+		EmbeddingModel: record.EmbeddingModel,
+	}, m.embedding)
 }
 
-// DeleteDocument 从知识库删除文档
-func (m *KnowledgeBaseManager) DeleteDocument(ctx context.Context, knowledgeBaseID, documentID string) error {
-	m.mu.Lock()
-	kb, exists := m.knowledgeBases[knowledgeBaseID]
-	if !exists {
-		m.mu.Unlock()
-		return errors.New("knowledge base not found")
-	}
-	
-	docs, exists := m.documents[knowledgeBaseID]
-	if !exists {
-		m.mu.Unlock()
-		return errors.New("no documents in knowledge base")
-	}
-	
-	// 查找文档
-	var docToDelete *Document
-	var newDocs []*Document
-	var docIndex int = -1
-	
-	for i, doc := range docs {
-		if doc.ID == documentID {
-			docToDelete = doc
-			docIndex = i
-		} else {
-			newDocs = append(newDocs, doc)
+// fromRecord 由文档记录构造用于处理的文档
+func fromRecord(document *models.Document) *Document {
+	return &Document{
+		ID:              document.ID.String(),
+		KnowledgeBaseID: document.KnowledgeBaseID.String(),
+		Name:            document.Name,
+		Type:            DocumentType(document.Type),
+		Size:            document.Size,
+		Content:         string(document.Content),
+		Tags:            document.Tags,
+		CreatedAt:       document.CreatedAt,
+		UpdatedAt:       document.UpdatedAt,
+	}
+}
+
+// normalizeTags 去除标签首尾空白，丢弃空标签和重复标签，超出上限的部分被截断。
+// 标签区分大小写，与过滤条件的比较方式一致
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if runes := []rune(tag); len(runes) > maxTagRunes {
+			tag = string(runes[:maxTagRunes])
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+		if len(normalized) == maxDocumentTags {
+			break
 		}
 	}
-	
-	if docToDelete == nil {
-		m.mu.Unlock()
-		return errors.New("document not found")
-	}
-	
-	// 更新文档列表
-	m.documents[knowledgeBaseID] = newDocs
-	m.mu.Unlock()
-	
-	// 构建删除表达式（所有与此文档关联的向量）
-	// 注意：这里应该是向量数据库中特定的实现
-	// 在实际应用中可能需要先查询所有文档相关的向量ID
-	expr := fmt.Sprintf("document_id == '%s'", documentID)
-	
-	// 删除向量数据
-	// TODO: 实现根据表达式删除向量的功能
-	// 目前简化处理，假设我们已知所有向量ID
-	
-	// 更新知识库信息
-	m.mu.Lock()
-	kb.DocumentCount--
-	// 注意：这里需要知道删除了多少个chunk
-	// kb.ChunkCount -= deletedChunksCount
-	kb.UpdatedAt = time.Now()
-	m.mu.Unlock()
-	
-	return nil
+	return normalized
 }
 
 // DefaultKnowledgeBaseManager 默认的知识库管理器
 var DefaultKnowledgeBaseManager *KnowledgeBaseManager
 
 // 初始化默认知识库管理器
-func InitDefaultKnowledgeBaseManager(vectorDB VectorDatabase) {
+func InitDefaultKnowledgeBaseManager(db *gorm.DB, vectorDB VectorDatabase) {
 	DefaultKnowledgeBaseManager = NewKnowledgeBaseManager(
+		db,
 		vectorDB,
 		DefaultEmbeddingManager,
 		DefaultProcessorRegistry,
 	)
-} 
+}
@@ -0,0 +1,306 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestManager 创建使用测试数据库、内存向量库和 mock 向量模型的知识库管理器，并创建一个知识库
+func newTestManager(t *testing.T) (*KnowledgeBaseManager, *gorm.DB, *models.KnowledgeBase) {
+	t.Helper()
+	db := testdb.Open(t, &models.KnowledgeBase{}, &models.Document{}, &models.Chunk{})
+	embedding := NewEmbeddingManager()
+	embedding.RegisterModel("mock", NewMockEmbeddingModel(4, "mock"))
+	manager := NewKnowledgeBaseManager(db, NewInMemoryVectorDB(), embedding, DefaultProcessorRegistry)
+
+	record := &models.KnowledgeBase{
+		Name:           "docs",
+		Type:           "file",
+		EmbeddingModel: "mock",
+		ChunkStrategy:  ChunkStrategyRecursive,
+		ChunkSize:      100,
+		ChunkOverlap:   20,
+		RetrievalMode:  "hybrid",
+		FusionMethod:   "rrf",
+		KeywordWeight:  0.5,
+		Status:         "active",
+		CreatedBy:      uuid.New(),
+	}
+	if err := manager.CreateKnowledgeBase(context.Background(), record); err != nil {
+		t.Fatalf("CreateKnowledgeBase: %v", err)
+	}
+	return manager, db, record
+}
+
+// addTestDocument 添加一个文本文档，流水线未启动时文档保持排队状态
+func addTestDocument(t *testing.T, manager *KnowledgeBaseManager, record *models.KnowledgeBase, content string) *models.Document {
+	t.Helper()
+	document, err := manager.AddTextDocument(context.Background(), record.ID.String(), "notes.txt", content, TypeText, nil)
+	if err != nil {
+		t.Fatalf("AddTextDocument: %v", err)
+	}
+	return document
+}
+
+// setStatus 直接修改文档状态，模拟流水线的处理阶段
+func setStatus(t *testing.T, db *gorm.DB, documentID uuid.UUID, status string) {
+	t.Helper()
+	if err := db.Model(&models.Document{}).Where("id = ?", documentID).Update("status", status).Error; err != nil {
+		t.Fatalf("set status: %v", err)
+	}
+}
+
+// testChunks 构造指定数量的分块
+func testChunks(document *models.Document, n int) []Chunk {
+	chunks := make([]Chunk, n)
+	for i := range chunks {
+		chunks[i].ID = uuid.New().String()
+		chunks[i].DocumentID = document.ID.String()
+		chunks[i].Content = "content"
+		chunks[i].Metadata.ChunkIndex = i
+	}
+	return chunks
+}
+
+// counts 返回知识库的文档数和分块数
+func counts(t *testing.T, db *gorm.DB, id uuid.UUID) (int, int) {
+	t.Helper()
+	var record models.KnowledgeBase
+	if err := db.First(&record, "id = ?", id).Error; err != nil {
+		t.Fatalf("load knowledge base: %v", err)
+	}
+	return record.DocumentCount, record.ChunkCount
+}
+
+func TestAddTextDocument(t *testing.T) {
+	ctx := context.Background()
+	manager, _, record := newTestManager(t)
+
+	document, err := manager.AddTextDocument(ctx, record.ID.String(), "notes.txt", "hello", TypeText, []string{" faq ", "faq"})
+	if err != nil {
+		t.Fatalf("AddTextDocument: %v", err)
+	}
+	if document.Status != models.DocumentStatusQueued {
+		t.Errorf("Status = %q, want %q", document.Status, models.DocumentStatusQueued)
+	}
+	if document.Content != nil {
+		t.Errorf("Content = %q, want nil", document.Content)
+	}
+	if len(document.Tags) != 1 || document.Tags[0] != "faq" {
+		t.Errorf("Tags = %v, want [faq]", document.Tags)
+	}
+
+	stored, err := manager.GetDocument(ctx, record.ID.String(), document.ID.String())
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	if string(stored.Content) != "hello" || stored.Checksum == "" {
+		t.Errorf("stored Content = %q, Checksum = %q, want content and checksum", stored.Content, stored.Checksum)
+	}
+
+	documents, err := manager.GetDocuments(ctx, record.ID.String())
+	if err != nil {
+		t.Fatalf("GetDocuments: %v", err)
+	}
+	if len(documents) != 1 || documents[0].Content != nil {
+		t.Errorf("GetDocuments = %d documents, want 1 without content", len(documents))
+	}
+}
+
+func TestAddTextDocumentErrors(t *testing.T) {
+	ctx := context.Background()
+	manager, db, record := newTestManager(t)
+	existing := addTestDocument(t, manager, record, "duplicate")
+
+	tests := []struct {
+		name            string
+		knowledgeBaseID string
+		content         string
+		docType         DocumentType
+		wantErr         error
+	}{
+		{"invalid knowledge base id", "invalid", "a", TypeText, ErrKnowledgeBaseNotFound},
+		{"unknown knowledge base", uuid.NewString(), "a", TypeText, ErrKnowledgeBaseNotFound},
+		{"unsupported type", record.ID.String(), "a", DocumentType("rtf"), ErrUnsupportedDocumentType},
+		{"duplicate content", record.ID.String(), "duplicate", TypeText, ErrDuplicateDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.AddTextDocument(ctx, tt.knowledgeBaseID, "notes.txt", tt.content, tt.docType, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddTextDocument error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 失败的文档可以重新上传
+	setStatus(t, db, existing.ID, models.DocumentStatusFailed)
+	if _, err := manager.AddTextDocument(ctx, record.ID.String(), "notes.txt", "duplicate", TypeText, nil); err != nil {
+		t.Errorf("AddTextDocument after failure error = %v, want nil", err)
+	}
+}
+
+func TestGetDocumentNotFound(t *testing.T) {
+	ctx := context.Background()
+	manager, _, record := newTestManager(t)
+	other, _, otherRecord := newTestManager(t)
+	document := addTestDocument(t, manager, record, "hello")
+	otherDocument := addTestDocument(t, other, otherRecord, "hello")
+
+	tests := []struct {
+		name       string
+		documentID string
+	}{
+		{"invalid id", "invalid"},
+		{"unknown id", uuid.NewString()},
+		{"other knowledge base", otherDocument.ID.String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := manager.GetDocument(ctx, record.ID.String(), tt.documentID); !errors.Is(err, ErrDocumentNotFound) {
+				t.Errorf("GetDocument error = %v, want %v", err, ErrDocumentNotFound)
+			}
+			if err := manager.DeleteDocument(ctx, record.ID.String(), tt.documentID); !errors.Is(err, ErrDocumentNotFound) {
+				t.Errorf("DeleteDocument error = %v, want %v", err, ErrDocumentNotFound)
+			}
+		})
+	}
+
+	if _, err := manager.GetDocument(ctx, record.ID.String(), document.ID.String()); err != nil {
+		t.Errorf("GetDocument error = %v, want nil", err)
+	}
+}
+
+func TestCommitChunksCounts(t *testing.T) {
+	ctx := context.Background()
+	manager, db, record := newTestManager(t)
+	document := addTestDocument(t, manager, record, "hello")
+
+	// 只有向量化阶段的文档可以提交
+	if err := manager.commitChunks(ctx, record, document, testChunks(document, 3)); !errors.Is(err, errIngestionAborted) {
+		t.Fatalf("commitChunks on queued document error = %v, want %v", err, errIngestionAborted)
+	}
+
+	setStatus(t, db, document.ID, models.DocumentStatusEmbedding)
+	if err := manager.commitChunks(ctx, record, document, testChunks(document, 3)); err != nil {
+		t.Fatalf("commitChunks: %v", err)
+	}
+	if documents, chunks := counts(t, db, record.ID); documents != 1 || chunks != 3 {
+		t.Errorf("counts = (%d, %d), want (1, 3)", documents, chunks)
+	}
+
+	stored, err := manager.GetDocument(ctx, record.ID.String(), document.ID.String())
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	if stored.Status != models.DocumentStatusIndexed || stored.ChunkCount != 3 || stored.IndexedAt == nil {
+		t.Errorf("document Status = %q, ChunkCount = %d, IndexedAt = %v, want indexed with 3 chunks", stored.Status, stored.ChunkCount, stored.IndexedAt)
+	}
+
+	// 重建索引只更新分块数的差值，旧分块被替换
+	setStatus(t, db, document.ID, models.DocumentStatusEmbedding)
+	if err := manager.commitChunks(ctx, record, document, testChunks(document, 2)); err != nil {
+		t.Fatalf("commitChunks reindex: %v", err)
+	}
+	if documents, chunks := counts(t, db, record.ID); documents != 1 || chunks != 2 {
+		t.Errorf("counts after reindex = (%d, %d), want (1, 2)", documents, chunks)
+	}
+	var rows int64
+	db.Model(&models.Chunk{}).Where("document_id = ?", document.ID).Count(&rows)
+	if rows != 2 {
+		t.Errorf("chunk rows = %d, want 2", rows)
+	}
+
+	if err := manager.DeleteDocument(ctx, record.ID.String(), document.ID.String()); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if documents, chunks := counts(t, db, record.ID); documents != 0 || chunks != 0 {
+		t.Errorf("counts after delete = (%d, %d), want (0, 0)", documents, chunks)
+	}
+	db.Model(&models.Chunk{}).Where("document_id = ?", document.ID).Count(&rows)
+	if rows != 0 {
+		t.Errorf("chunk rows after delete = %d, want 0", rows)
+	}
+}
+
+func TestDeleteUnindexedDocumentKeepsCounts(t *testing.T) {
+	ctx := context.Background()
+	manager, db, record := newTestManager(t)
+	indexed := addTestDocument(t, manager, record, "indexed")
+	setStatus(t, db, indexed.ID, models.DocumentStatusEmbedding)
+	if err := manager.commitChunks(ctx, record, indexed, testChunks(indexed, 2)); err != nil {
+		t.Fatalf("commitChunks: %v", err)
+	}
+
+	// 未索引过的文档不计入统计，删除时不影响计数
+	queued := addTestDocument(t, manager, record, "queued")
+	if err := manager.DeleteDocument(ctx, record.ID.String(), queued.ID.String()); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if documents, chunks := counts(t, db, record.ID); documents != 1 || chunks != 2 {
+		t.Errorf("counts = (%d, %d), want (1, 2)", documents, chunks)
+	}
+}
+
+func TestDeleteKnowledgeBase(t *testing.T) {
+	ctx := context.Background()
+	manager, db, record := newTestManager(t)
+	document := addTestDocument(t, manager, record, "hello")
+	setStatus(t, db, document.ID, models.DocumentStatusEmbedding)
+	if err := manager.commitChunks(ctx, record, document, testChunks(document, 2)); err != nil {
+		t.Fatalf("commitChunks: %v", err)
+	}
+
+	if err := manager.DeleteKnowledgeBase(ctx, record.ID.String()); err != nil {
+		t.Fatalf("DeleteKnowledgeBase: %v", err)
+	}
+	if _, err := manager.GetKnowledgeBase(ctx, record.ID.String()); !errors.Is(err, ErrKnowledgeBaseNotFound) {
+		t.Errorf("GetKnowledgeBase error = %v, want %v", err, ErrKnowledgeBaseNotFound)
+	}
+	var documents, chunks int64
+	db.Model(&models.Document{}).Where("knowledge_base_id = ?", record.ID).Count(&documents)
+	db.Model(&models.Chunk{}).Where("knowledge_base_id = ?", record.ID).Count(&chunks)
+	if documents != 0 || chunks != 0 {
+		t.Errorf("remaining documents = %d, chunks = %d, want 0", documents, chunks)
+	}
+	if err := manager.DeleteKnowledgeBase(ctx, record.ID.String()); !errors.Is(err, ErrKnowledgeBaseNotFound) {
+		t.Errorf("second DeleteKnowledgeBase error = %v, want %v", err, ErrKnowledgeBaseNotFound)
+	}
+}
+
+func TestCreateKnowledgeBaseZeroValues(t *testing.T) {
+	manager, db, _ := newTestManager(t)
+	record := &models.KnowledgeBase{
+		Name:           "docs",
+		Type:           "file",
+		EmbeddingModel: "mock",
+		ChunkStrategy:  ChunkStrategyRecursive,
+		ChunkSize:      100,
+		ChunkOverlap:   0,
+		KeywordWeight:  0,
+		CreatedBy:      uuid.New(),
+	}
+	if err := manager.CreateKnowledgeBase(context.Background(), record); err != nil {
+		t.Fatalf("CreateKnowledgeBase: %v", err)
+	}
+	if record.ChunkOverlap != 0 || record.KeywordWeight != 0 {
+		t.Errorf("record ChunkOverlap = %d, KeywordWeight = %v, want 0", record.ChunkOverlap, record.KeywordWeight)
+	}
+
+	var stored models.KnowledgeBase
+	if err := db.First(&stored, "id = ?", record.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ChunkOverlap != 0 || stored.KeywordWeight != 0 {
+		t.Errorf("stored ChunkOverlap = %d, KeywordWeight = %v, want 0", stored.ChunkOverlap, stored.KeywordWeight)
+	}
+	if _, err := manager.chunker(&stored); err != nil {
+		t.Errorf("chunker: %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package kb
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxHeadingLevel Word 大纲级别1-9对应标题，更低级别视为正文
+const maxHeadingLevel = 9
+
+// DefaultMaxZipEntrySize 压缩包格式文档（docx、xlsx）中单个文件解压后的默认大小上限
+const DefaultMaxZipEntrySize = 100 << 20
+
+var (
+	ErrZipEntryTooLarge = errors.New("archive entry exceeds the extracted size limit")
+	errZipEntryNotFound = errors.New("archive entry not found")
+)
+
+// WordProcessor Word(.docx)文档处理器，保留段落、标题层级和表格
+type WordProcessor struct {
+	maxEntrySize int64
+}
+
+// NewWordProcessor 创建Word文档处理器，maxEntrySize 为压缩包中单个文件解压后的大小上限，0 使用默认值
+func NewWordProcessor(maxEntrySize int64) *WordProcessor {
+	if maxEntrySize <= 0 {
+		maxEntrySize = DefaultMaxZipEntrySize
+	}
+	return &WordProcessor{maxEntrySize: maxEntrySize}
+}
+
+// Extract 提取文档文本
+func (p *WordProcessor) Extract(doc *Document) ([]Section, error) {
+	text, err := extractDocx(doc.Content, p.maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	return []Section{{Text: text}}, nil
+}
+
+// SupportsType 检查是否支持特定文档类型
+func (p *WordProcessor) SupportsType(docType DocumentType) bool {
+	return docType == TypeWord
+}
+
+// extractDocx 将 word/document.xml 转换为文本：标题以Markdown标题标记输出，表格以Markdown表格输出
+func extractDocx(content string, maxEntrySize int64) (string, error) {
+	archive, err := zip.NewReader(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("invalid docx file: %w", err)
+	}
+
+	headingLevels := map[string]int{}
+	styles, err := openZipEntry(archive, "word/styles.xml", maxEntrySize)
+	switch {
+	case err == nil:
+		headingLevels, err = parseDocxHeadingStyles(styles)
+		styles.Close()
+		if err != nil {
+			return "", fmt.Errorf("invalid docx styles: %w", err)
+		}
+	case !errors.Is(err, errZipEntryNotFound):
+		return "", fmt.Errorf("invalid docx file: %w", err)
+	}
+
+	body, err := openZipEntry(archive, "word/document.xml", maxEntrySize)
+	if err != nil {
+		return "", fmt.Errorf("invalid docx file: %w", err)
+	}
+	defer body.Close()
+
+	blocks, err := parseDocxBody(body, headingLevels)
+	if err != nil {
+		return "", fmt.Errorf("invalid docx document: %w", err)
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// parseDocxHeadingStyles 读取样式表中的标题样式，返回样式ID到标题级别的映射
+func parseDocxHeadingStyles(r io.Reader) (map[string]int, error) {
+	levels := map[string]int{}
+	decoder := xml.NewDecoder(r)
+	styleID := ""
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return levels, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "style":
+				styleID = xmlAttr(t, "styleId")
+			case "name":
+				name := strings.ToLower(xmlAttr(t, "val"))
+				if name == "title" {
+					levels[styleID] = 1
+				} else if level, ok := headingLevel(name, "heading "); ok {
+					levels[styleID] = level
+				}
+			case "outlineLvl":
+				if level, ok := outlineLevel(xmlAttr(t, "val")); ok && styleID != "" {
+					levels[styleID] = level
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "style" {
+				styleID = ""
+			}
+		}
+	}
+}
+
+// docxTable 正在解析的表格
+type docxTable struct {
+	rows [][]string
+	row  []string
+	cell []string
+}
+
+// parseDocxBody 按文档顺序输出段落和表格，嵌套表格并入外层单元格
+func parseDocxBody(r io.Reader, headingLevels map[string]int) ([]string, error) {
+	var blocks []string
+	var tables []*docxTable
+	var paragraph strings.Builder
+	level := 0
+
+	// emit 将段落或表格文本写入当前单元格或正文
+	emit := func(text string) {
+		if text == "" {
+			return
+		}
+		if len(tables) > 0 {
+			table := tables[len(tables)-1]
+			table.cell = append(table.cell, text)
+			return
+		}
+		blocks = append(blocks, text)
+	}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+				level = 0
+			case "pStyle":
+				// 缺少样式表时按内置样式ID（Heading1等）识别标题
+				style := xmlAttr(t, "val")
+				if l, ok := headingLevels[style]; ok {
+					level = l
+				} else if l, ok := headingLevel(style, "Heading"); ok {
+					level = l
+				}
+			case "outlineLvl":
+				if l, ok := outlineLevel(xmlAttr(t, "val")); ok {
+					level = l
+				}
+			case "t":
+				var text string
+				if err := decoder.DecodeElement(&text, &t); err != nil {
+					return nil, err
+				}
+				paragraph.WriteString(text)
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			case "tbl":
+				tables = append(tables, &docxTable{})
+			case "tr":
+				if len(tables) > 0 {
+					tables[len(tables)-1].row = nil
+				}
+			case "tc":
+				if len(tables) > 0 {
+					tables[len(tables)-1].cell = nil
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p":
+				text := strings.TrimSpace(paragraph.String())
+				if text != "" && level > 0 && len(tables) == 0 {
+					text = strings.Repeat("#", level) + " " + text
+				}
+				emit(text)
+				paragraph.Reset()
+				level = 0
+			case "tc":
+				if len(tables) > 0 {
+					table := tables[len(tables)-1]
+					table.row = append(table.row, strings.Join(table.cell, " "))
+				}
+			case "tr":
+				if len(tables) > 0 {
+					table := tables[len(tables)-1]
+					table.rows = append(table.rows, table.row)
+				}
+			case "tbl":
+				if len(tables) > 0 {
+					table := tables[len(tables)-1]
+					tables = tables[:len(tables)-1]
+					emit(renderTable(table.rows))
+				}
+			}
+		}
+	}
+}
+
+// renderTable 将表格渲染为Markdown表格，第一行作为表头
+func renderTable(rows [][]string) string {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	for i, row := range rows {
+		cells := make([]string, columns)
+		for j := range cells {
+			if j < len(row) {
+				cells[j] = strings.ReplaceAll(strings.Join(strings.Fields(row[j]), " "), "|", "\\|")
+			}
+		}
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString("| " + strings.Join(cells, " | ") + " |")
+		if i == 0 {
+			builder.WriteString("\n|" + strings.Repeat(" --- |", columns))
+		}
+	}
+	return builder.String()
+}
+
+// headingLevel 解析带有指定前缀的标题样式名中的级别，如 "heading 2"
+func headingLevel(value, prefix string) (int, bool) {
+	if !strings.HasPrefix(value, prefix) {
+		return 0, false
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(value, prefix)))
+	if err != nil || level < 1 || level > maxHeadingLevel {
+		return 0, false
+	}
+	return level, true
+}
+
+// outlineLevel 将从0开始的大纲级别转换为标题级别
+func outlineLevel(value string) (int, bool) {
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level >= maxHeadingLevel {
+		return 0, false
+	}
+	return level + 1, true
+}
+
+// xmlAttr 按本地名获取属性值，忽略命名空间前缀
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// openZipEntry 打开压缩包中的文件，声明的解压大小或实际读取的数据超过 limit 时返回 ErrZipEntryTooLarge
+func openZipEntry(archive *zip.Reader, name string, limit int64) (io.ReadCloser, error) {
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+		if file.UncompressedSize64 > uint64(limit) {
+			return nil, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrZipEntryTooLarge, name, file.UncompressedSize64, limit)
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		// 声明的大小不可信，读取时再按上限截断并报错
+		return &limitedZipEntry{
+			reader: &io.LimitedReader{R: entry, N: limit + 1},
+			closer: entry,
+			name:   name,
+			limit:  limit,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errZipEntryNotFound, name)
+}
+
+// limitedZipEntry 读取超过上限时返回错误而不是静默截断
+type limitedZipEntry struct {
+	reader *io.LimitedReader
+	closer io.Closer
+	name   string
+	limit  int64
+}
+
+// Read 实现 io.Reader
+func (e *limitedZipEntry) Read(p []byte) (int, error) {
+	n, err := e.reader.Read(p)
+	if e.reader.N <= 0 {
+		return n, fmt.Errorf("%w: %s exceeds %d bytes", ErrZipEntryTooLarge, e.name, e.limit)
+	}
+	return n, err
+}
+
+// Close 实现 io.Closer
+func (e *limitedZipEntry) Close() error {
+	return e.closer.Close()
+}
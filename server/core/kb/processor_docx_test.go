@@ -0,0 +1,94 @@
+package kb
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// zipArchive 将文件打包为 zip 内容
+func zipArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+const docxStyles = `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:style w:styleId="Title"><w:name w:val="Title"/></w:style>
+<w:style w:styleId="Custom"><w:name w:val="My Heading"/><w:pPr><w:outlineLvl w:val="2"/></w:pPr></w:style>
+</w:styles>`
+
+const docxBody = `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr><w:r><w:t>Guide</w:t></w:r></w:p>
+<w:p><w:pPr><w:pStyle w:val="Heading2"/></w:pPr><w:r><w:t>Install</w:t></w:r></w:p>
+<w:p><w:pPr><w:pStyle w:val="Custom"/></w:pPr><w:r><w:t>Notes</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Run </w:t></w:r><w:r><w:t>setup</w:t></w:r></w:p>
+<w:p></w:p>
+<w:tbl>
+<w:tr><w:tc><w:p><w:r><w:t>Name</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Value</w:t></w:r></w:p></w:tc></w:tr>
+<w:tr><w:tc><w:p><w:r><w:t>a|b</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>1</w:t></w:r></w:p></w:tc></w:tr>
+</w:tbl>
+</w:body></w:document>`
+
+func TestExtractDocx(t *testing.T) {
+	content := zipArchive(t, map[string]string{"word/styles.xml": docxStyles, "word/document.xml": docxBody})
+	sections, err := NewWordProcessor(0).Extract(&Document{Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# Guide\n\n## Install\n\n### Notes\n\nRun setup\n\n| Name | Value |\n| --- | --- |\n| a\\|b | 1 |"
+	if len(sections) != 1 || sections[0].Text != want {
+		t.Errorf("Extract = %+v, want %q", sections, want)
+	}
+
+	// 没有样式表时按内置样式ID识别标题
+	content = zipArchive(t, map[string]string{"word/document.xml": docxBody})
+	text, err := extractDocx(content, DefaultMaxZipEntrySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text, "Guide\n\n## Install\n\nNotes\n\n") {
+		t.Errorf("extractDocx without styles = %q", text)
+	}
+}
+
+func TestExtractDocxErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{"not a zip", "plain text", nil},
+		{"missing document", zipArchive(t, map[string]string{"word/styles.xml": docxStyles}), nil},
+		{"invalid xml", zipArchive(t, map[string]string{"word/document.xml": "<w:document><w:body>"}), nil},
+		{"document too large", zipArchive(t, map[string]string{"word/document.xml": docxBody + strings.Repeat(" ", 2<<10)}), ErrZipEntryTooLarge},
+		{"styles too large", zipArchive(t, map[string]string{"word/styles.xml": strings.Repeat(" ", 2<<10), "word/document.xml": docxBody}), ErrZipEntryTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractDocx(tt.content, 1<<10)
+			if err == nil {
+				t.Fatal("extractDocx succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("extractDocx error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package kb
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// boilerplateTokens class/id 中出现这些词的元素视为导航、广告等页面框架内容
+var boilerplateTokens = map[string]bool{
+	"nav": true, "navbar": true, "navigation": true, "menu": true, "sidebar": true,
+	"breadcrumb": true, "breadcrumbs": true, "cookie": true, "cookies": true,
+	"advert": true, "advertisement": true, "ads": true, "share": true, "social": true,
+	"footer": true,
+}
+
+// HTMLProcessor HTML文档处理器，去除脚本、导航、页眉页脚等框架内容后转换为文本，
+// 标题、列表和表格以Markdown形式保留
+type HTMLProcessor struct{}
+
+// NewHTMLProcessor 创建HTML文档处理器
+func NewHTMLProcessor() *HTMLProcessor {
+	return &HTMLProcessor{}
+}
+
+// Extract 提取文档文本
+func (p *HTMLProcessor) Extract(doc *Document) ([]Section, error) {
+	text, err := extractHTML(doc.Content)
+	if err != nil {
+		return nil, err
+	}
+	return []Section{{Text: text}}, nil
+}
+
+// SupportsType 检查是否支持特定文档类型
+func (p *HTMLProcessor) SupportsType(docType DocumentType) bool {
+	return docType == TypeHTML
+}
+
+// extractHTML 解析HTML并提取正文，页面有 main 或 article 元素时只提取其中的内容
+func extractHTML(content string) (string, error) {
+	// 按 meta 声明或内容检测编码，兼容GBK等非UTF-8页面
+	reader, err := charset.NewReader(strings.NewReader(content), "text/html")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect html encoding: %w", err)
+	}
+	root, err := html.Parse(reader)
+	if err != nil {
+		return "", fmt.Errorf("invalid html file: %w", err)
+	}
+
+	renderer := &htmlRenderer{}
+	body := findElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Body })
+	article := findElement(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.Main || n.DataAtom == atom.Article || htmlAttr(n, "role") == "main"
+	})
+	switch {
+	case article != nil:
+		renderer.walk(article)
+	case body != nil:
+		// 没有正文标记时从 body 提取，页面级的页眉页脚一并去除
+		renderer.page = true
+		renderer.walk(body)
+	default:
+		renderer.walk(root)
+	}
+	renderer.flush()
+
+	// 正文没有一级标题时以页面标题作为标题
+	if title := findElement(root, func(n *html.Node) bool { return n.DataAtom == atom.Title }); title != nil && !renderer.hasTitle {
+		if text := collapseSpace(textContent(title)); text != "" {
+			renderer.blocks = append([]string{"# " + text}, renderer.blocks...)
+		}
+	}
+
+	return strings.Join(renderer.blocks, "\n\n"), nil
+}
+
+// htmlRenderer 将HTML节点树渲染为以空行分隔的文本块
+type htmlRenderer struct {
+	blocks   []string
+	line     strings.Builder
+	page     bool // 从 body 开始提取，需要去除页面级的页眉页脚
+	hasTitle bool
+}
+
+// walk 渲染节点及其子节点
+func (r *htmlRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		// 源码中的换行只是空白，换行由 br 和块级元素产生
+		r.line.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Data))
+		return
+	case html.ElementNode:
+		if r.boilerplate(n) {
+			return
+		}
+
+		switch n.DataAtom {
+		case atom.Br:
+			r.line.WriteString("\n")
+			return
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			r.flush()
+			level := int(n.Data[1] - '0')
+			if text := collapseSpace(textContent(n)); text != "" {
+				r.blocks = append(r.blocks, strings.Repeat("#", level)+" "+text)
+				r.hasTitle = r.hasTitle || level == 1
+			}
+			return
+		case atom.Pre:
+			r.flush()
+			if text := strings.Trim(textContent(n), "\n"); strings.TrimSpace(text) != "" {
+				r.blocks = append(r.blocks, text)
+			}
+			return
+		case atom.Table:
+			r.flush()
+			if text := renderTable(htmlTableRows(n)); text != "" {
+				r.blocks = append(r.blocks, text)
+			}
+			return
+		case atom.Li:
+			r.flush()
+			item := &htmlRenderer{page: r.page}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				item.walk(c)
+			}
+			item.flush()
+			if len(item.blocks) > 0 {
+				r.blocks = append(r.blocks, "- "+strings.Join(item.blocks, "\n  "))
+			}
+			return
+		}
+
+		if isBlockElement(n.DataAtom) {
+			r.flush()
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				r.walk(c)
+			}
+			r.flush()
+			return
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+// flush 将当前累积的行内文本作为一个文本块输出
+func (r *htmlRenderer) flush() {
+	lines := strings.Split(r.line.String(), "\n")
+	r.line.Reset()
+
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = collapseSpace(line); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) > 0 {
+		r.blocks = append(r.blocks, strings.Join(kept, "\n"))
+	}
+}
+
+// boilerplate 判断元素是否为脚本、隐藏内容或导航、广告等框架内容
+func (r *htmlRenderer) boilerplate(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Iframe, atom.Svg, atom.Canvas,
+		atom.Head, atom.Nav, atom.Aside, atom.Form, atom.Button, atom.Select, atom.Textarea,
+		atom.Input, atom.Object, atom.Embed:
+		return true
+	case atom.Header, atom.Footer:
+		// 文章内的页眉通常包含标题，只去除页面级的页眉页脚
+		return r.page
+	}
+
+	if _, hidden := htmlAttrOK(n, "hidden"); hidden || htmlAttr(n, "aria-hidden") == "true" {
+		return true
+	}
+	if style := strings.ReplaceAll(strings.ToLower(htmlAttr(n, "style")), " ", ""); strings.Contains(style, "display:none") {
+		return true
+	}
+	switch htmlAttr(n, "role") {
+	case "navigation", "complementary", "search":
+		return true
+	case "banner", "contentinfo":
+		return r.page
+	}
+
+	for _, value := range []string{htmlAttr(n, "class"), htmlAttr(n, "id")} {
+		for _, token := range strings.FieldsFunc(strings.ToLower(value), func(c rune) bool {
+			return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9')
+		}) {
+			if boilerplateTokens[token] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBlockElement 块级元素前后断开文本
+func isBlockElement(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header, atom.Footer,
+		atom.Ul, atom.Ol, atom.Dl, atom.Dt, atom.Dd, atom.Blockquote, atom.Figure, atom.Figcaption,
+		atom.Address, atom.Details, atom.Summary, atom.Hr, atom.Body:
+		return true
+	}
+	return false
+}
+
+// htmlTableRows 提取表格各行单元格文本，嵌套表格的内容并入所在单元格
+func htmlTableRows(table *html.Node) [][]string {
+	var rows [][]string
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Tr:
+				var cells []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						cells = append(cells, collapseSpace(textContent(cell)))
+					}
+				}
+				rows = append(rows, cells)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				collect(c)
+			}
+		}
+	}
+	collect(table)
+	return rows
+}
+
+// textContent 返回节点的文本内容，跳过脚本和样式
+func textContent(n *html.Node) string {
+	var builder strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			builder.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template:
+				return
+			case atom.Br:
+				builder.WriteString("\n")
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return builder.String()
+}
+
+// findElement 深度优先查找第一个满足条件的元素
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// htmlAttr 获取元素属性值
+func htmlAttr(n *html.Node, key string) string {
+	value, _ := htmlAttrOK(n, key)
+	return value
+}
+
+// htmlAttrOK 获取元素属性值及属性是否存在
+func htmlAttrOK(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// collapseSpace 将连续空白合并为一个空格并去除首尾空白
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,48 @@
+package kb
+
+import "testing"
+
+func TestExtractHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "page without article",
+			content: `<html><head><title>Page Title</title><script>var x = 1</script></head><body>
+<header>Site header</header><nav>Home | About</nav><div class="sidebar">Links</div>
+<h2>Section</h2><p>Hello <b>world</b><br>second
+line</p>
+<ul><li>one</li><li>two</li></ul>
+<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>
+<div style="display: none">hidden</div><p hidden>also hidden</p>
+<footer>Copyright</footer></body></html>`,
+			want: "# Page Title\n\n## Section\n\nHello world\nsecond line\n\n- one\n\n- two\n\n| A | B |\n| --- | --- |\n| 1 | 2 |",
+		},
+		{
+			name: "article",
+			content: `<html><head><title>Site</title></head><body><nav>menu</nav>
+<article><header><h1>Post</h1></header><p>Body text</p><pre>  code
+  block</pre></article>
+<footer>f</footer></body></html>`,
+			want: "# Post\n\nBody text\n\n  code\n  block",
+		},
+		{
+			name:    "gbk encoding",
+			content: "<html><head><meta charset=\"gbk\"></head><body><p>\xc4\xe3\xba\xc3</p></body></html>",
+			want:    "你好",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sections, err := NewHTMLProcessor().Extract(&Document{Content: tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sections) != 1 || sections[0].Text != tt.want {
+				t.Errorf("Extract = %+v, want %q", sections, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package kb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFProcessor PDF文档处理器，逐页提取文本，分块记录所在页码
+type PDFProcessor struct{}
+
+// NewPDFProcessor 创建PDF文档处理器
+func NewPDFProcessor() *PDFProcessor {
+	return &PDFProcessor{}
+}
+
+// Extract 提取文档文本
+func (p *PDFProcessor) Extract(doc *Document) ([]Section, error) {
+	return extractPDF(doc.Content)
+}
+
+// SupportsType 检查是否支持特定文档类型
+func (p *PDFProcessor) SupportsType(docType DocumentType) bool {
+	return docType == TypePDF
+}
+
+// extractPDF 按页提取PDF文本，页码从1开始，没有文本的页（如扫描件）被跳过
+func extractPDF(content string) (sections []Section, err error) {
+	// 解析库在遇到损坏的文件时可能panic，转换为错误返回
+	defer func() {
+		if r := recover(); r != nil {
+			sections, err = nil, fmt.Errorf("invalid pdf file: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pdf file: %w", err)
+	}
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract text from page %d: %w", i, err)
+		}
+		text = normalizeText(text)
+		if text == "" {
+			continue
+		}
+
+		sections = append(sections, Section{Text: text, PageNumber: i})
+	}
+	return sections, nil
+}
+
+// normalizeText 去除行尾空白并合并多余的空行，段落之间保留一个空行
+func normalizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	var builder strings.Builder
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\u00a0")
+		if strings.TrimSpace(line) == "" {
+			blank = builder.Len() > 0
+			continue
+		}
+		if builder.Len() > 0 {
+			if blank {
+				builder.WriteString("\n\n")
+			} else {
+				builder.WriteString("\n")
+			}
+		}
+		builder.WriteString(line)
+		blank = false
+	}
+	return builder.String()
+}
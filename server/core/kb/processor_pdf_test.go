@@ -0,0 +1,27 @@
+package kb
+
+import "testing"
+
+func TestExtractPDFInvalid(t *testing.T) {
+	for _, content := range []string{"", "not a pdf", "%PDF-1.4\n1 0 obj\n<<"} {
+		if _, err := NewPDFProcessor().Extract(&Document{Content: content}); err == nil {
+			t.Errorf("Extract(%q) succeeded, want error", content)
+		}
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"line one  \r\nline two\t", "line one\nline two"},
+		{"\n\n para one\n\n\n\npara two\n\n", " para one\n\npara two"},
+		{"   \n \n", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeText(tt.text); got != tt.want {
+			t.Errorf("normalizeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package kb
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExcelProcessor Excel(.xlsx)文档处理器，以首个非空行为表头，每行输出为一条"表头: 值"记录。
+// 每个工作表作为一段，分块的 PageNumber 为工作表序号（从1开始）
+type ExcelProcessor struct {
+	maxEntrySize int64
+}
+
+// NewExcelProcessor 创建Excel文档处理器，maxEntrySize 为压缩包中单个文件解压后的大小上限，0 使用默认值
+func NewExcelProcessor(maxEntrySize int64) *ExcelProcessor {
+	if maxEntrySize <= 0 {
+		maxEntrySize = DefaultMaxZipEntrySize
+	}
+	return &ExcelProcessor{maxEntrySize: maxEntrySize}
+}
+
+// Extract 提取文档文本
+func (p *ExcelProcessor) Extract(doc *Document) ([]Section, error) {
+	return extractXlsx(doc.Content, p.maxEntrySize)
+}
+
+// SupportsType 检查是否支持特定文档类型
+func (p *ExcelProcessor) SupportsType(docType DocumentType) bool {
+	return docType == TypeExcel
+}
+
+// xlsxSheet 工作簿中的工作表
+type xlsxSheet struct {
+	name string
+	path string
+}
+
+// xlsxRow 工作表中的一行，cells 按列序号索引
+type xlsxRow struct {
+	number int
+	cells  map[int]string
+}
+
+// extractXlsx 按工作表提取行记录
+func extractXlsx(content string, maxEntrySize int64) ([]Section, error) {
+	archive, err := zip.NewReader(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %w", err)
+	}
+
+	sheets, err := readXlsxSheets(archive, maxEntrySize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx workbook: %w", err)
+	}
+
+	var sharedStrings []string
+	entry, err := openZipEntry(archive, "xl/sharedStrings.xml", maxEntrySize)
+	switch {
+	case err == nil:
+		sharedStrings, err = parseXlsxSharedStrings(entry)
+		entry.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid xlsx shared strings: %w", err)
+		}
+	case !errors.Is(err, errZipEntryNotFound):
+		return nil, fmt.Errorf("invalid xlsx file: %w", err)
+	}
+
+	var sections []Section
+	for i, sheet := range sheets {
+		entry, err := openZipEntry(archive, sheet.path, maxEntrySize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid xlsx workbook: %w", err)
+		}
+		rows, err := parseXlsxRows(entry, sharedStrings)
+		entry.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid xlsx sheet %s: %w", sheet.name, err)
+		}
+
+		if text := xlsxRecords(sheet.name, rows); text != "" {
+			sections = append(sections, Section{Text: text, PageNumber: i + 1})
+		}
+	}
+	return sections, nil
+}
+
+// readXlsxSheets 读取工作簿中的工作表名称及其在压缩包中的路径，按工作簿中的顺序返回
+func readXlsxSheets(archive *zip.Reader, maxEntrySize int64) ([]xlsxSheet, error) {
+	rels, err := openZipEntry(archive, "xl/_rels/workbook.xml.rels", maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	defer rels.Close()
+
+	targets := map[string]string{}
+	decoder := xml.NewDecoder(rels)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := token.(xml.StartElement); ok && t.Name.Local == "Relationship" {
+			target := xmlAttr(t, "Target")
+			// 目标路径可以是相对 xl/ 的路径或以 / 开头的包内绝对路径
+			if strings.HasPrefix(target, "/") {
+				target = strings.TrimPrefix(target, "/")
+			} else {
+				target = path.Join("xl", target)
+			}
+			targets[xmlAttr(t, "Id")] = target
+		}
+	}
+
+	workbook, err := openZipEntry(archive, "xl/workbook.xml", maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	defer workbook.Close()
+
+	var sheets []xlsxSheet
+	decoder = xml.NewDecoder(workbook)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return sheets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := token.(xml.StartElement); ok && t.Name.Local == "sheet" {
+			target, ok := targets[xmlAttr(t, "id")]
+			if !ok {
+				continue
+			}
+			sheets = append(sheets, xlsxSheet{name: xmlAttr(t, "name"), path: target})
+		}
+	}
+}
+
+// parseXlsxSharedStrings 读取共享字符串表，富文本片段拼接为一个字符串，注音不计入
+func parseXlsxSharedStrings(r io.Reader) ([]string, error) {
+	var values []string
+	var current strings.Builder
+	phonetic := false
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "rPh":
+				phonetic = true
+			case "t":
+				var text string
+				if err := decoder.DecodeElement(&text, &t); err != nil {
+					return nil, err
+				}
+				if !phonetic {
+					current.WriteString(text)
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				values = append(values, current.String())
+			case "rPh":
+				phonetic = false
+			}
+		}
+	}
+}
+
+// parseXlsxRows 读取工作表中的行，单元格值按类型解析为文本
+func parseXlsxRows(r io.Reader, sharedStrings []string) ([]xlsxRow, error) {
+	var rows []xlsxRow
+	var row xlsxRow
+	var cellRef, cellType, value string
+	var inline strings.Builder
+	column := 0
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				number, _ := strconv.Atoi(xmlAttr(t, "r"))
+				if number == 0 {
+					number = len(rows) + 1
+				}
+				row = xlsxRow{number: number, cells: map[int]string{}}
+				column = 0
+			case "c":
+				cellRef, cellType, value = xmlAttr(t, "r"), xmlAttr(t, "t"), ""
+				inline.Reset()
+			case "v":
+				if err := decoder.DecodeElement(&value, &t); err != nil {
+					return nil, err
+				}
+			case "t":
+				// 内联字符串 <is><t>...</t></is>
+				var text string
+				if err := decoder.DecodeElement(&text, &t); err != nil {
+					return nil, err
+				}
+				inline.WriteString(text)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "c":
+				if index, ok := columnIndex(cellRef); ok {
+					column = index
+				}
+				if text := strings.TrimSpace(xlsxCellValue(cellType, value, inline.String(), sharedStrings)); text != "" {
+					row.cells[column] = text
+				}
+				column++
+			case "row":
+				if len(row.cells) > 0 {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+}
+
+// xlsxCellValue 按单元格类型取值，数字和日期保留原始存储值
+func xlsxCellValue(cellType, value, inline string, sharedStrings []string) string {
+	switch cellType {
+	case "s":
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 || index >= len(sharedStrings) {
+			return ""
+		}
+		return sharedStrings[index]
+	case "inlineStr":
+		return inline
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return value
+	}
+}
+
+// xlsxRecords 以首行为表头将其余各行转换为记录，记录之间以空行分隔
+func xlsxRecords(sheetName string, rows []xlsxRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+
+	headers := rows[0].cells
+	columns := make([]int, 0, len(headers))
+	for _, row := range rows {
+		for column := range row.cells {
+			columns = append(columns, column)
+		}
+	}
+	columns = uniqueSorted(columns)
+
+	// 只有表头行时直接输出表头
+	if len(rows) == 1 {
+		values := make([]string, 0, len(columns))
+		for _, column := range columns {
+			values = append(values, headers[column])
+		}
+		return fmt.Sprintf("%s\n%s", sheetName, strings.Join(values, "\n"))
+	}
+
+	records := make([]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		lines := []string{fmt.Sprintf("%s 第%d行", sheetName, row.number)}
+		for _, column := range columns {
+			value, ok := row.cells[column]
+			if !ok {
+				continue
+			}
+			header := headers[column]
+			if header == "" {
+				header = columnName(column)
+			}
+			lines = append(lines, header+": "+value)
+		}
+		records = append(records, strings.Join(lines, "\n"))
+	}
+	return strings.Join(records, "\n\n")
+}
+
+// columnIndex 解析单元格引用（如 "AB12"）中的列序号，从0开始
+func columnIndex(ref string) (int, bool) {
+	index := 0
+	letters := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		index = index*26 + int(r-'A') + 1
+		letters++
+	}
+	if letters == 0 {
+		return 0, false
+	}
+	return index - 1, true
+}
+
+// columnName 将从0开始的列序号转换为列名（如 "AB"）
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// uniqueSorted 对列序号去重并升序排列
+func uniqueSorted(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	result := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
@@ -0,0 +1,117 @@
+package kb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// xlsxFiles 返回包含两个工作表的工作簿文件，第二个工作表为空
+func xlsxFiles() map[string]string {
+	return map[string]string{
+		"xl/_rels/workbook.xml.rels": `<Relationships>
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/>
+</Relationships>`,
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>
+<sheet name="Prices" sheetId="1" r:id="rId1"/>
+<sheet name="Empty" sheetId="2" r:id="rId2"/>
+<sheet name="Missing" sheetId="3" r:id="rId9"/>
+</sheets></workbook>`,
+		"xl/sharedStrings.xml": `<sst>
+<si><t>Item</t></si>
+<si><t>Price</t></si>
+<si><r><t>Tea</t></r><r><t xml:space="preserve"> Cup</t></r><rPh><t>ignored</t></rPh></si>
+</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2"><v>3.5</v></c><c r="D2" t="b"><v>1</v></c></row>
+<row r="3"><c r="A3" t="inlineStr"><is><t>Coffee</t></is></c><c r="B3" t="s"><v>99</v></c></row>
+<row r="4"></row>
+</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData/></worksheet>`,
+	}
+}
+
+func TestExtractXlsx(t *testing.T) {
+	content := zipArchive(t, xlsxFiles())
+	sections, err := NewExcelProcessor(0).Extract(&Document{Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Prices 第2行\nItem: Tea Cup\nPrice: 3.5\nD: TRUE\n\nPrices 第3行\nItem: Coffee"
+	if len(sections) != 1 || sections[0].Text != want || sections[0].PageNumber != 1 {
+		t.Errorf("Extract = %+v, want one section on page 1 with %q", sections, want)
+	}
+}
+
+func TestExtractXlsxErrors(t *testing.T) {
+	withFile := func(name, content string) map[string]string {
+		files := xlsxFiles()
+		files[name] = content
+		return files
+	}
+	withoutFile := func(name string) map[string]string {
+		files := xlsxFiles()
+		delete(files, name)
+		return files
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{"not a zip", "plain text", nil},
+		{"missing relationships", zipArchive(t, withoutFile("xl/_rels/workbook.xml.rels")), nil},
+		{"missing sheet", zipArchive(t, withoutFile("xl/worksheets/sheet1.xml")), nil},
+		{"invalid sheet xml", zipArchive(t, withFile("xl/worksheets/sheet1.xml", "<worksheet><sheetData>")), nil},
+		{"sheet too large", zipArchive(t, withFile("xl/worksheets/sheet2.xml", strings.Repeat(" ", 2<<10))), ErrZipEntryTooLarge},
+		{"shared strings too large", zipArchive(t, withFile("xl/sharedStrings.xml", strings.Repeat(" ", 2<<10))), ErrZipEntryTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractXlsx(tt.content, 1<<10)
+			if err == nil {
+				t.Fatal("extractXlsx succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("extractXlsx error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestXlsxRecordsHeaderOnly(t *testing.T) {
+	rows := []xlsxRow{{number: 1, cells: map[int]string{0: "Item", 2: "Price"}}}
+	if got, want := xlsxRecords("Sheet1", rows), "Sheet1\nItem\nPrice"; got != want {
+		t.Errorf("xlsxRecords = %q, want %q", got, want)
+	}
+	if got := xlsxRecords("Sheet1", nil); got != "" {
+		t.Errorf("xlsxRecords(nil) = %q, want empty", got)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref    string
+		want   int
+		wantOK bool
+	}{
+		{"A1", 0, true},
+		{"Z9", 25, true},
+		{"AA1", 26, true},
+		{"AB12", 27, true},
+		{"12", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := columnIndex(tt.ref)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("columnIndex(%q) = %d, %v, want %d, %v", tt.ref, got, ok, tt.want, tt.wantOK)
+		}
+		if tt.wantOK && !strings.HasPrefix(tt.ref, columnName(got)) {
+			t.Errorf("columnName(%d) = %q, want prefix of %q", got, columnName(got), tt.ref)
+		}
+	}
+}
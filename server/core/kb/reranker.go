@@ -0,0 +1,338 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+)
+
+// 内置重排器的注册名称
+const (
+	RerankerMock         = "mock"          // 按查询词覆盖率打分，用于测试
+	RerankerMMR          = "mmr"           // 最大边际相关性，兼顾相关性与多样性
+	RerankerCrossEncoder = "cross-encoder" // Cohere/Jina 兼容的重排接口，需在配置中启用
+	RerankerLLM          = "llm"           // 大模型逐条打分，需在配置中启用
+)
+
+// defaultMMRLambda MMR 中相关性所占的比重，其余为与已选结果差异性的比重
+const defaultMMRLambda = 0.5
+
+// RerankRequest 重排请求
+type RerankRequest struct {
+	Query          string
+	Results        []SearchResult
+	EmbeddingModel string // 知识库使用的向量模型，供需要计算向量的重排器使用
+}
+
+// Reranker 重排器接口。返回按重排顺序排列的结果，Score 为重排器给出的相关性分数
+type Reranker interface {
+	Rerank(ctx context.Context, req RerankRequest) ([]SearchResult, error)
+}
+
+// RerankerManager 重排器注册表
+type RerankerManager struct {
+	rerankers map[string]Reranker
+	mu        sync.RWMutex
+}
+
+// NewRerankerManager 创建重排器注册表
+func NewRerankerManager() *RerankerManager {
+	return &RerankerManager{
+		rerankers: make(map[string]Reranker),
+	}
+}
+
+// RegisterReranker 注册重排器，同名的重排器将被替换
+func (m *RerankerManager) RegisterReranker(name string, reranker Reranker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rerankers[name] = reranker
+}
+
+// UnregisterReranker 注销重排器
+func (m *RerankerManager) UnregisterReranker(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.rerankers, name)
+}
+
+// GetReranker 获取重排器
+func (m *RerankerManager) GetReranker(name string) (Reranker, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reranker, exists := m.rerankers[name]
+	if !exists {
+		return nil, errors.New("reranker not found: " + name)
+	}
+	return reranker, nil
+}
+
+// ListRerankers 列出所有已注册的重排器名称
+func (m *RerankerManager) ListRerankers() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.rerankers))
+	for name := range m.rerankers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MockReranker 模拟的重排器（用于测试），分数为查询词项在分块中出现的比例
+type MockReranker struct{}
+
+// NewMockReranker 创建模拟重排器
+func NewMockReranker() *MockReranker {
+	return &MockReranker{}
+}
+
+// Rerank 按查询词覆盖率从高到低排列，分数相同时保持原有顺序
+func (m *MockReranker) Rerank(ctx context.Context, req RerankRequest) ([]SearchResult, error) {
+	terms := make(map[string]bool)
+	for _, token := range keywordTokens(req.Query) {
+		terms[token] = true
+	}
+
+	results := make([]SearchResult, len(req.Results))
+	for i, result := range req.Results {
+		matched := make(map[string]bool)
+		for _, token := range keywordTokens(result.Content) {
+			if terms[token] {
+				matched[token] = true
+			}
+		}
+		result.Score = 0
+		if len(terms) > 0 {
+			result.Score = float32(len(matched)) / float32(len(terms))
+		}
+		results[i] = result
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// MMRReranker 最大边际相关性重排器：依次选出与查询相关且与已选结果差异最大的分块，
+// 避免返回多个内容重复的分块。分数为分块与查询的余弦相似度
+type MMRReranker struct {
+	embedding *EmbeddingManager
+	lambda    float64
+}
+
+// NewMMRReranker 创建MMR重排器，lambda 为相关性所占的比重（0～1），越小结果越分散
+func NewMMRReranker(embedding *EmbeddingManager, lambda float64) *MMRReranker {
+	if lambda < 0 || lambda > 1 {
+		lambda = defaultMMRLambda
+	}
+	return &MMRReranker{
+		embedding: embedding,
+		lambda:    lambda,
+	}
+}
+
+// Rerank 使用知识库的向量模型计算查询与分块、分块与分块之间的相似度
+func (m *MMRReranker) Rerank(ctx context.Context, req RerankRequest) ([]SearchResult, error) {
+	if len(req.Results) == 0 {
+		return nil, nil
+	}
+
+	texts := make([]string, 0, len(req.Results)+1)
+	texts = append(texts, req.Query)
+	for _, result := range req.Results {
+		texts = append(texts, result.Content)
+	}
+	resp, err := m.embedding.Embed(ctx, EmbeddingRequest{Texts: texts, Model: req.EmbeddingModel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed for mmr: %w", err)
+	}
+	query, vectors := resp.Embeddings[0], resp.Embeddings[1:]
+
+	relevance := make([]float64, len(vectors))
+	for i, vector := range vectors {
+		relevance[i] = float64(calculateCosineSimilarity(query, vector))
+	}
+
+	// redundancy[i] 为候选 i 与已选结果的最大相似度
+	redundancy := make([]float64, len(vectors))
+	selected := make([]bool, len(vectors))
+	results := make([]SearchResult, 0, len(vectors))
+	for len(results) < len(vectors) {
+		best, bestScore := -1, 0.0
+		for i := range vectors {
+			if selected[i] {
+				continue
+			}
+			score := m.lambda*relevance[i] - (1-m.lambda)*redundancy[i]
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		selected[best] = true
+		result := req.Results[best]
+		result.Score = float32(math.Max(relevance[best], 0)) // 负的余弦相似度视为完全无关
+		results = append(results, result)
+
+		for i := range vectors {
+			if !selected[i] {
+				if similarity := float64(calculateCosineSimilarity(vectors[i], vectors[best])); similarity > redundancy[i] {
+					redundancy[i] = similarity
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// RerankConfig 重排阶段配置
+type RerankConfig struct {
+	CandidateMultiplier int // 召回 topK 的倍数作为重排候选
+	MaxCandidates       int // 重排候选数上限，限制重排接口和大模型的调用量
+}
+
+// DefaultRerankConfig 返回默认的重排阶段配置
+func DefaultRerankConfig() RerankConfig {
+	return RerankConfig{
+		CandidateMultiplier: 4,
+		MaxCandidates:       50,
+	}
+}
+
+// RerankingRetriever 在检索器之后增加重排阶段：知识库设置了重排器时多召回一些候选，
+// 重排后丢弃低于分数阈值的结果并截取 topK；未设置重排器时直接返回内部检索器的结果
+type RerankingRetriever struct {
+	retriever Retriever
+	kb        *KnowledgeBaseManager
+	rerankers *RerankerManager
+	config    RerankConfig
+	logger    *zap.Logger
+}
+
+// NewRerankingRetriever 创建带重排阶段的检索器，未设置的配置项使用默认值
+func NewRerankingRetriever(retriever Retriever, kb *KnowledgeBaseManager, rerankers *RerankerManager, config RerankConfig) *RerankingRetriever {
+	defaults := DefaultRerankConfig()
+	if config.CandidateMultiplier <= 0 {
+		config.CandidateMultiplier = defaults.CandidateMultiplier
+	}
+	if config.MaxCandidates <= 0 {
+		config.MaxCandidates = defaults.MaxCandidates
+	}
+	return &RerankingRetriever{
+		retriever: retriever,
+		kb:        kb,
+		rerankers: rerankers,
+		config:    config,
+		logger:    zap.L().With(zap.String("component", "reranking_retriever")),
+	}
+}
+
+// Retrieve 检索并重排，重排器不可用或重排失败时退回内部检索器的排序
+func (r *RerankingRetriever) Retrieve(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
+	record, err := r.kb.GetKnowledgeBase(ctx, req.KnowledgeBaseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get knowledge base: %w", err)
+	}
+	if record.Reranker == "" {
+		return r.retriever.Retrieve(ctx, req)
+	}
+
+	topK := req.TopK
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	candidates := topK * r.config.CandidateMultiplier
+	if candidates > r.config.MaxCandidates {
+		candidates = r.config.MaxCandidates
+	}
+	if candidates < topK {
+		candidates = topK
+	}
+
+	candidateReq := req
+	candidateReq.TopK = candidates
+	resp, err := r.retriever.Retrieve(ctx, candidateReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{
+		Results: r.rerankResults(ctx, record, req.Query, resp.Results, topK),
+		Query:   resp.Query,
+	}, nil
+}
+
+// rerankResults 重排候选结果，分数归一化到 0～1 后丢弃低于阈值的结果并截取 topK。
+// 重排失败时保留检索顺序，不应用阈值
+func (r *RerankingRetriever) rerankResults(ctx context.Context, record *models.KnowledgeBase, query string, candidates []SearchResult, topK int) []SearchResult {
+	results, err := r.rerank(ctx, record.Reranker, record.EmbeddingModel, query, candidates)
+	if err != nil {
+		r.logger.Warn("Rerank failed, using retrieval order",
+			zap.String("knowledge_base_id", record.ID.String()),
+			zap.String("reranker", record.Reranker),
+			zap.Error(err))
+		results = candidates
+	} else {
+		normalizeRerankScores(results)
+		kept := results[:0]
+		for _, result := range results {
+			if float64(result.Score) >= record.RerankThreshold {
+				kept = append(kept, result)
+			}
+		}
+		results = kept
+	}
+
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results
+}
+
+// rerank 使用指定的重排器对候选结果重排
+func (r *RerankingRetriever) rerank(ctx context.Context, name, embeddingModel, query string, results []SearchResult) ([]SearchResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+	reranker, err := r.rerankers.GetReranker(name)
+	if err != nil {
+		return nil, err
+	}
+	return reranker.Rerank(ctx, RerankRequest{
+		Query:          query,
+		Results:        results,
+		EmbeddingModel: embeddingModel,
+	})
+}
+
+// normalizeRerankScores 将重排分数归一化到 0～1，使阈值对不同重排器含义一致。
+// 分数都已在 0～1 之间时视为相关性概率保持不变；否则视为未归一化的 logit，
+// 整组经 sigmoid 变换，排序不变
+func normalizeRerankScores(results []SearchResult) {
+	for _, result := range results {
+		if result.Score < 0 || result.Score > 1 || math.IsNaN(float64(result.Score)) {
+			for i := range results {
+				results[i].Score = float32(1 / (1 + math.Exp(-float64(results[i].Score))))
+			}
+			return
+		}
+	}
+}
+
+// DefaultRerankerManager 默认的重排器注册表
+var DefaultRerankerManager = NewRerankerManager()
+
+// 注册内置重排器
+func init() {
+	DefaultRerankerManager.RegisterReranker(RerankerMock, NewMockReranker())
+	DefaultRerankerManager.RegisterReranker(RerankerMMR, NewMMRReranker(DefaultEmbeddingManager, defaultMMRLambda))
+}
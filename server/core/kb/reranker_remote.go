@@ -0,0 +1,286 @@
+package kb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/llm"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultRerankTimeout 重排接口的请求超时时间
+	defaultRerankTimeout = 30 * time.Second
+
+	// defaultLLMRerankConcurrency 大模型逐条打分的并发请求数
+	defaultLLMRerankConcurrency = 4
+
+	// llmRerankMaxChars 发送给大模型打分的分块内容的最大字符数
+	llmRerankMaxChars = 2000
+
+	// llmRerankMaxScore 大模型打分的满分，分数除以满分后作为相关性分数
+	llmRerankMaxScore = 10
+)
+
+// llmRerankPrompt 大模型逐条打分的系统提示词
+const llmRerankPrompt = "你是检索结果的相关性评估员。根据文档片段能在多大程度上回答用户问题，给出0到10之间的整数评分：" +
+	"0表示完全无关，10表示能直接完整地回答问题。只输出评分数字，不要输出其他内容。"
+
+// llmScorePattern 从大模型的回复中提取评分
+var llmScorePattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// CrossEncoderConfig 交叉编码器重排接口配置
+type CrossEncoderConfig struct {
+	BaseURL string        // 接口地址，请求发送到 BaseURL + "/rerank"，如 https://api.jina.ai/v1、https://api.cohere.com/v2
+	APIKey  string        // 本地服务可以为空
+	Model   string        // 重排模型，如 jina-reranker-v2-base-multilingual、rerank-v3.5
+	Timeout time.Duration // 请求超时时间
+}
+
+// CrossEncoderReranker 调用 Cohere/Jina 兼容的重排接口（Jina、Cohere 以及 vLLM、Xinference 等本地服务），
+// 由交叉编码器模型同时读取查询与分块给出相关性分数
+type CrossEncoderReranker struct {
+	config CrossEncoderConfig
+	client *http.Client
+}
+
+// NewCrossEncoderReranker 创建交叉编码器重排器
+func NewCrossEncoderReranker(config CrossEncoderConfig) *CrossEncoderReranker {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultRerankTimeout
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &CrossEncoderReranker{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// crossEncoderRequest 重排接口请求
+type crossEncoderRequest struct {
+	Model           string   `json:"model,omitempty"`
+	Query           string   `json:"query"`
+	Documents       []string `json:"documents"`
+	TopN            int      `json:"top_n"`
+	ReturnDocuments bool     `json:"return_documents"`
+}
+
+// crossEncoderResponse 重排接口响应，results 按相关性从高到低排列
+type crossEncoderResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// Rerank 发送查询与全部候选分块，按接口返回的顺序排列
+func (c *CrossEncoderReranker) Rerank(ctx context.Context, req RerankRequest) ([]SearchResult, error) {
+	if len(req.Results) == 0 {
+		return nil, nil
+	}
+
+	documents := make([]string, len(req.Results))
+	for i, result := range req.Results {
+		documents[i] = result.Content
+	}
+	body, err := json.Marshal(crossEncoderRequest{
+		Model:     c.config.Model,
+		Query:     req.Query,
+		Documents: documents,
+		TopN:      len(documents),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.BaseURL+"/rerank", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.config.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("rerank request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return nil, fmt.Errorf("rerank request failed with status %d: %s", httpResp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var resp crossEncoderResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode rerank response: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(resp.Results))
+	seen := make([]bool, len(req.Results))
+	for _, item := range resp.Results {
+		if item.Index < 0 || item.Index >= len(req.Results) || seen[item.Index] {
+			return nil, fmt.Errorf("rerank response contains invalid index %d", item.Index)
+		}
+		seen[item.Index] = true
+		result := req.Results[item.Index]
+		result.Score = float32(item.RelevanceScore)
+		results = append(results, result)
+	}
+
+	// 部分服务不保证按分数排序
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// LLMReranker 大模型逐条打分的重排器：对每个候选分块请求一次大模型给出0到10分的评分，
+// 分数除以10作为相关性分数。每次重排时按模型配置ID重新加载模型配置，修改配置后立即生效
+type LLMReranker struct {
+	db            *gorm.DB
+	decrypter     agent.Decrypter
+	modelConfigID uuid.UUID
+	concurrency   int
+}
+
+// NewLLMReranker 创建大模型重排器，modelConfigID 为打分使用的模型配置
+func NewLLMReranker(db *gorm.DB, decrypter agent.Decrypter, modelConfigID uuid.UUID, concurrency int) *LLMReranker {
+	if concurrency <= 0 {
+		concurrency = defaultLLMRerankConcurrency
+	}
+	return &LLMReranker{
+		db:            db,
+		decrypter:     decrypter,
+		modelConfigID: modelConfigID,
+		concurrency:   concurrency,
+	}
+}
+
+// Rerank 并发为每个分块打分，任一分块打分失败时返回错误
+func (l *LLMReranker) Rerank(ctx context.Context, req RerankRequest) ([]SearchResult, error) {
+	if len(req.Results) == 0 {
+		return nil, nil
+	}
+
+	adapter, err := l.adapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]SearchResult, len(req.Results))
+	copy(results, req.Results)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	semaphore := make(chan struct{}, l.concurrency)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				return
+			}
+
+			score, err := l.score(ctx, adapter, req.Query, results[i].Content)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i].Score = score
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// adapter 加载模型配置并创建大模型适配器
+func (l *LLMReranker) adapter(ctx context.Context) (llm.Adapter, error) {
+	var modelConfig models.ModelConfig
+	if err := l.db.WithContext(ctx).Preload("Model").First(&modelConfig, "id = ?", l.modelConfigID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("model config %s not found", l.modelConfigID)
+		}
+		return nil, err
+	}
+	if modelConfig.Model == nil {
+		return nil, fmt.Errorf("model config %s has no model", l.modelConfigID)
+	}
+
+	providerConfig, err := agent.ResolveProviderConfig(&modelConfig, l.decrypter)
+	if err != nil {
+		return nil, err
+	}
+	return llm.CreateAdapter(modelConfig.Model.Provider, providerConfig)
+}
+
+// score 请求大模型为单个分块打分，返回 0～1 之间的分数
+func (l *LLMReranker) score(ctx context.Context, adapter llm.Adapter, query, content string) (float32, error) {
+	if utf8.RuneCountInString(content) > llmRerankMaxChars {
+		content = string([]rune(content)[:llmRerankMaxChars])
+	}
+
+	resp, err := adapter.Chat(ctx, llm.ChatRequest{
+		ConfigID: l.modelConfigID,
+		Messages: []llm.Message{
+			{Role: "system", Content: llmRerankPrompt},
+			{Role: "user", Content: fmt.Sprintf("用户问题：%s\n\n文档片段：\n%s", query, content)},
+		},
+		MaxTokens:   16,
+		Temperature: 0,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("llm rerank failed: %w", err)
+	}
+
+	match := llmScorePattern.FindString(resp.Message.Content)
+	if match == "" {
+		return 0, fmt.Errorf("llm rerank returned no score: %q", resp.Message.Content)
+	}
+	score, err := strconv.ParseFloat(match, 64)
+	if err != nil {
+		return 0, fmt.Errorf("llm rerank returned invalid score: %q", resp.Message.Content)
+	}
+	if score > llmRerankMaxScore {
+		score = llmRerankMaxScore
+	}
+	return float32(score / llmRerankMaxScore), nil
+}
@@ -0,0 +1,128 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+)
+
+// scoreReranker 按给定分数重排，用于模拟输出未归一化分数的重排器
+type scoreReranker struct {
+	scores map[string]float32
+	err    error
+}
+
+func (s *scoreReranker) Rerank(ctx context.Context, req RerankRequest) ([]SearchResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	results := make([]SearchResult, len(req.Results))
+	for i, result := range req.Results {
+		result.Score = s.scores[result.ChunkID]
+		results[i] = result
+	}
+	return results, nil
+}
+
+func newTestRerankingRetriever() *RerankingRetriever {
+	rerankers := NewRerankerManager()
+	rerankers.RegisterReranker(RerankerMock, NewMockReranker())
+	return NewRerankingRetriever(nil, nil, rerankers, RerankConfig{})
+}
+
+func rerankCandidates() []SearchResult {
+	return []SearchResult{
+		{ChunkID: "c1", Content: "退款需要在七天内申请", Score: 0.9},
+		{ChunkID: "c2", Content: "refund policy and refund process", Score: 0.8},
+		{ChunkID: "c3", Content: "shipping policy", Score: 0.7},
+		{ChunkID: "c4", Content: "refund", Score: 0.6},
+	}
+}
+
+func TestRerankResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		topK      int
+		want      []string
+	}{
+		{"ordering", 0, 10, []string{"c2", "c3", "c4", "c1"}},
+		{"threshold", 0.5, 10, []string{"c2", "c3", "c4"}},
+		{"threshold drops all", 1.01, 10, []string{}},
+		{"top n", 0, 2, []string{"c2", "c3"}},
+		{"threshold and top n", 0.6, 1, []string{"c2"}},
+	}
+
+	// 查询词为 refund 和 policy：c2 全部命中，c3、c4 命中一半，c1 未命中
+	retriever := newTestRerankingRetriever()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &models.KnowledgeBase{ID: uuid.New(), Reranker: RerankerMock, RerankThreshold: tt.threshold}
+			results := retriever.rerankResults(context.Background(), record, "refund policy", rerankCandidates(), tt.topK)
+			if got := resultIDs(results); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("rerankResults = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerankResultsNormalizesScores(t *testing.T) {
+	retriever := newTestRerankingRetriever()
+	retriever.rerankers.RegisterReranker("logit", &scoreReranker{scores: map[string]float32{
+		"c1": 3, "c2": 0, "c3": -2, "c4": 1,
+	}})
+
+	record := &models.KnowledgeBase{ID: uuid.New(), Reranker: "logit", RerankThreshold: 0.5}
+	results := retriever.rerankResults(context.Background(), record, "refund", rerankCandidates(), 10)
+
+	// sigmoid(0) = 0.5 恰好达到阈值，sigmoid(-2) 低于阈值
+	if got := resultIDs(results); strings.Join(got, ",") != "c1,c2,c4" {
+		t.Fatalf("rerankResults = %v, want [c1 c2 c4]", got)
+	}
+	for _, result := range results {
+		if result.Score < 0 || result.Score > 1 {
+			t.Errorf("score of %s = %v, want within [0, 1]", result.ChunkID, result.Score)
+		}
+	}
+	if want := float32(1 / (1 + math.Exp(-3))); results[0].Score != want {
+		t.Errorf("score of c1 = %v, want %v", results[0].Score, want)
+	}
+}
+
+func TestRerankResultsFallback(t *testing.T) {
+	retriever := newTestRerankingRetriever()
+	retriever.rerankers.RegisterReranker("broken", &scoreReranker{err: errors.New("unavailable")})
+
+	// 重排失败时保留检索顺序且不应用阈值
+	record := &models.KnowledgeBase{ID: uuid.New(), Reranker: "broken", RerankThreshold: 0.95}
+	results := retriever.rerankResults(context.Background(), record, "refund", rerankCandidates(), 3)
+	if got := resultIDs(results); strings.Join(got, ",") != "c1,c2,c3" {
+		t.Errorf("rerankResults = %v, want [c1 c2 c3]", got)
+	}
+}
+
+func TestNormalizeRerankScores(t *testing.T) {
+	probabilities := []SearchResult{{Score: 0}, {Score: 0.25}, {Score: 1}}
+	normalizeRerankScores(probabilities)
+	for i, want := range []float32{0, 0.25, 1} {
+		if probabilities[i].Score != want {
+			t.Errorf("score %d = %v, want %v unchanged", i, probabilities[i].Score, want)
+		}
+	}
+
+	logits := []SearchResult{{Score: 4.5}, {Score: 0.3}, {Score: -7}}
+	normalizeRerankScores(logits)
+	for i := range logits {
+		if logits[i].Score <= 0 || logits[i].Score >= 1 {
+			t.Errorf("score %d = %v, want within (0, 1)", i, logits[i].Score)
+		}
+		if i > 0 && logits[i].Score >= logits[i-1].Score {
+			t.Errorf("normalization changed the order: %v >= %v", logits[i].Score, logits[i-1].Score)
+		}
+	}
+}
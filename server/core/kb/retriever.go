@@ -5,13 +5,18 @@ import (
 	"fmt"
 
 	"github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
 )
 
+// defaultTopK 未指定 top_k 时返回的结果数
+const defaultTopK = 5
+
 // QueryRequest 知识库查询请求
 type QueryRequest struct {
-	KnowledgeBaseID string `json:"knowledge_base_id"`
-	Query           string `json:"query"`
-	TopK            int    `json:"top_k"`
+	KnowledgeBaseID string  `json:"knowledge_base_id"`
+	Query           string  `json:"query"`
+	TopK            int     `json:"top_k"`
+	Filter          *Filter `json:"-"` // 元数据过滤条件，为空时不过滤
 }
 
 // QueryResponse 知识库查询响应
@@ -43,30 +48,20 @@ func NewVectorRetriever(kb *KnowledgeBaseManager, embedding *EmbeddingManager) *
 // Retrieve 实现检索功能
 func (r *VectorRetriever) Retrieve(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
 	// 获取知识库信息
-	kb, err := r.kb.GetKnowledgeBase(req.KnowledgeBaseID)
+	kb, err := r.kb.GetKnowledgeBase(ctx, req.KnowledgeBaseID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get knowledge base: %w", err)
 	}
 	
-	// 对查询进行向量化
-	resp, err := r.embedding.Embed(ctx, EmbeddingRequest{
-		Texts: []string{req.Query},
-		Model: kb.EmbeddingModel,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to embed query: %w", err)
-	}
-	
 	// 设置默认topK
 	topK := req.TopK
 	if topK <= 0 {
-		topK = 5
+		topK = defaultTopK
 	}
 	
-	// 搜索相似向量
-	results, err := r.kb.vectorDB.Search(ctx, req.KnowledgeBaseID, resp.Embeddings[0], topK)
+	results, err := r.search(ctx, kb, req.Query, topK, req.Filter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search vectors: %w", err)
+		return nil, err
 	}
 	
 	return &QueryResponse{
@@ -75,12 +70,30 @@ func (r *VectorRetriever) Retrieve(ctx context.Context, req QueryRequest) (*Quer
 	}, nil
 }
 
+// search 对查询进行向量化并搜索相似向量
+func (r *VectorRetriever) search(ctx context.Context, kb *models.KnowledgeBase, query string, topK int, filter *Filter) ([]SearchResult, error) {
+	resp, err := r.embedding.Embed(ctx, EmbeddingRequest{
+		Texts: []string{query},
+		Model: kb.EmbeddingModel,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	
+	results, err := r.kb.vectorDB.Search(ctx, collectionName(kb.ID.String()), resp.Embeddings[0], topK, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search vectors: %w", err)
+	}
+	return results, nil
+}
+
 // DefaultRetriever 默认的检索器
 var DefaultRetriever Retriever
 
-// InitDefaultRetriever 初始化默认检索器
+// InitDefaultRetriever 初始化默认检索器，按知识库的检索设置进行向量、关键词或混合检索，并按需重排
 func InitDefaultRetriever() {
-	DefaultRetriever = NewVectorRetriever(DefaultKnowledgeBaseManager, DefaultEmbeddingManager)
+	hybrid := NewHybridRetriever(DefaultKnowledgeBaseManager, DefaultEmbeddingManager, DefaultHybridConfig())
+	DefaultRetriever = NewRerankingRetriever(hybrid, DefaultKnowledgeBaseManager, DefaultRerankerManager, DefaultRerankConfig())
 }
 
 // RAGTool RAG工具
@@ -114,6 +127,14 @@ func RegisterRAGTool(registry *agent.ToolRegistry, retriever Retriever) error {
 				"description": "返回的最大结果数",
 				"default":     5,
 			},
+			"filters": map[string]interface{}{
+				"type": "object",
+				"description": "可选的元数据过滤条件，多个字段之间为“且”的关系。" +
+					"值为标量表示等于，如 {\"document_id\": \"...\"}；值为数组表示属于其中之一；" +
+					"范围条件如 {\"created_at\": {\"gte\": \"2024-01-01T00:00:00Z\"}}，可用 eq、ne、in、gt、gte、lt、lte；" +
+					"数组字段包含如 {\"tags\": {\"contains\": \"faq\"}}；组合条件使用 {\"or\": [...]} 或 {\"and\": [...]}。" +
+					"可用字段包括 document_id、document_type、source、page_number、created_at",
+			},
 		},
 		Handler: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 			// 提取参数
@@ -134,11 +155,25 @@ func RegisterRAGTool(registry *agent.ToolRegistry, retriever Retriever) error {
 				}
 			}
 			
+			var filter *Filter
+			if filtersParam, ok := params["filters"]; ok && filtersParam != nil {
+				filtersMap, ok := filtersParam.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("filters must be an object")
+				}
+				parsed, err := ParseFilter(filtersMap)
+				if err != nil {
+					return nil, err
+				}
+				filter = parsed
+			}
+			
 			// 创建请求
 			req := QueryRequest{
 				KnowledgeBaseID: kbID,
 				Query:           query,
 				TopK:            topK,
+				Filter:          filter,
 			}
 			
 			// 执行检索
@@ -219,8 +254,15 @@ func ApplyRAG(ctx context.Context, kbID string, query string, agent *agent.Agent
 		return agent.Chat(ctx, query)
 	}
 	
-	// 生成增强提示
-	augmentedPrompt := GeneratePromptFromResults(resp.Results, query)
+	// 生成增强提示，检索内容最多占用一半的上下文预算，超出时丢弃排名靠后的片段
+	results := resp.Results
+	augmentedPrompt := GeneratePromptFromResults(results, query)
+	if budget := agent.ContextBudget() / 2; budget > 0 {
+		for len(results) > 1 && agent.CountTokens(augmentedPrompt) > budget {
+			results = results[:len(results)-1]
+			augmentedPrompt = GeneratePromptFromResults(results, query)
+		}
+	}
 	
 	// 让Agent处理增强后的提示
 	return agent.Chat(ctx, augmentedPrompt)
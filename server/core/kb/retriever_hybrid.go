@@ -0,0 +1,243 @@
+package kb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+)
+
+// 检索模式
+const (
+	RetrievalModeVector  = "vector"  // 仅向量检索
+	RetrievalModeKeyword = "keyword" // 仅BM25关键词检索
+	RetrievalModeHybrid  = "hybrid"  // 向量与关键词检索结果融合，默认
+)
+
+// 融合方式
+const (
+	FusionRRF      = "rrf"      // 倒数排名融合，只使用名次，默认
+	FusionWeighted = "weighted" // 两路得分分别归一化到 [0,1] 后加权求和
+)
+
+// DefaultKeywordWeight 混合检索中关键词结果的默认权重，向量结果的权重为 1 减去该值
+const DefaultKeywordWeight = 0.5
+
+// ErrInvalidRetrievalSettings 知识库的检索设置无效
+var ErrInvalidRetrievalSettings = errors.New("invalid retrieval settings")
+
+// HybridConfig 混合检索器配置
+type HybridConfig struct {
+	RRFK                int // 倒数排名融合的平滑常数
+	CandidateMultiplier int // 每路检索取 topK 的倍数作为候选参与融合
+	MaxKeywordIndexes   int // 内存中缓存关键词索引的知识库数量上限
+}
+
+// DefaultHybridConfig 返回默认的混合检索器配置
+func DefaultHybridConfig() HybridConfig {
+	return HybridConfig{
+		RRFK:                60,
+		CandidateMultiplier: 4,
+		MaxKeywordIndexes:   32,
+	}
+}
+
+// ValidateRetrievalSettings 检查知识库的检索模式、融合方式、关键词权重和重排分数阈值，未设置的模式和融合方式使用默认值
+func ValidateRetrievalSettings(record *models.KnowledgeBase) error {
+	switch record.RetrievalMode {
+	case "", RetrievalModeVector, RetrievalModeKeyword, RetrievalModeHybrid:
+	default:
+		return fmt.Errorf("%w: unsupported retrieval mode %q", ErrInvalidRetrievalSettings, record.RetrievalMode)
+	}
+	switch record.FusionMethod {
+	case "", FusionRRF, FusionWeighted:
+	default:
+		return fmt.Errorf("%w: unsupported fusion method %q", ErrInvalidRetrievalSettings, record.FusionMethod)
+	}
+	if record.KeywordWeight < 0 || record.KeywordWeight > 1 {
+		return fmt.Errorf("%w: keyword weight must be between 0 and 1", ErrInvalidRetrievalSettings)
+	}
+	if record.RerankThreshold < 0 || record.RerankThreshold > 1 {
+		return fmt.Errorf("%w: rerank threshold must be between 0 and 1", ErrInvalidRetrievalSettings)
+	}
+	return nil
+}
+
+// HybridRetriever 混合检索器：向量检索召回语义相近的内容，BM25关键词检索召回包含相同编号、
+// 错误信息等字面内容的分块，两路结果按知识库的设置融合
+type HybridRetriever struct {
+	kb       *KnowledgeBaseManager
+	vector   *VectorRetriever
+	keywords *keywordIndexCache
+	config   HybridConfig
+	logger   *zap.Logger
+}
+
+// NewHybridRetriever 创建混合检索器，未设置的配置项使用默认值
+func NewHybridRetriever(kb *KnowledgeBaseManager, embedding *EmbeddingManager, config HybridConfig) *HybridRetriever {
+	defaults := DefaultHybridConfig()
+	if config.RRFK <= 0 {
+		config.RRFK = defaults.RRFK
+	}
+	if config.CandidateMultiplier <= 0 {
+		config.CandidateMultiplier = defaults.CandidateMultiplier
+	}
+	if config.MaxKeywordIndexes <= 0 {
+		config.MaxKeywordIndexes = defaults.MaxKeywordIndexes
+	}
+	return &HybridRetriever{
+		kb:       kb,
+		vector:   NewVectorRetriever(kb, embedding),
+		keywords: newKeywordIndexCache(config.MaxKeywordIndexes),
+		config:   config,
+		logger:   zap.L().With(zap.String("component", "hybrid_retriever")),
+	}
+}
+
+// Retrieve 按知识库的检索模式检索，混合模式下一路失败时只使用另一路的结果
+func (r *HybridRetriever) Retrieve(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
+	record, err := r.kb.GetKnowledgeBase(ctx, req.KnowledgeBaseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get knowledge base: %w", err)
+	}
+	if err := req.Filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	topK := req.TopK
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+
+	var results []SearchResult
+	switch record.RetrievalMode {
+	case RetrievalModeVector:
+		results, err = r.vector.search(ctx, record, req.Query, topK, req.Filter)
+	case RetrievalModeKeyword:
+		results, err = r.keywordSearch(ctx, record, req.Query, topK, req.Filter)
+	default:
+		results, err = r.hybridSearch(ctx, record, req.Query, topK, req.Filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{
+		Results: results,
+		Query:   req.Query,
+	}, nil
+}
+
+// keywordSearch 在知识库的关键词索引中检索
+func (r *HybridRetriever) keywordSearch(ctx context.Context, record *models.KnowledgeBase, query string, topK int, filter *Filter) ([]SearchResult, error) {
+	index, err := r.kb.keywordIndex(ctx, r.keywords, record)
+	if err != nil {
+		return nil, err
+	}
+	return index.search(query, topK, filter), nil
+}
+
+// hybridSearch 并行执行两路检索并融合结果
+func (r *HybridRetriever) hybridSearch(ctx context.Context, record *models.KnowledgeBase, query string, topK int, filter *Filter) ([]SearchResult, error) {
+	candidates := topK * r.config.CandidateMultiplier
+
+	var vectorResults, keywordResults []SearchResult
+	var vectorErr, keywordErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		vectorResults, vectorErr = r.vector.search(ctx, record, query, candidates, filter)
+	}()
+	go func() {
+		defer wg.Done()
+		keywordResults, keywordErr = r.keywordSearch(ctx, record, query, candidates, filter)
+	}()
+	wg.Wait()
+
+	switch {
+	case vectorErr != nil && keywordErr != nil:
+		return nil, vectorErr
+	case vectorErr != nil:
+		r.logger.Warn("Vector search failed, using keyword results only",
+			zap.String("knowledge_base_id", record.ID.String()), zap.Error(vectorErr))
+	case keywordErr != nil:
+		r.logger.Warn("Keyword search failed, using vector results only",
+			zap.String("knowledge_base_id", record.ID.String()), zap.Error(keywordErr))
+	}
+
+	weights := []float64{1 - record.KeywordWeight, record.KeywordWeight}
+	lists := [][]SearchResult{vectorResults, keywordResults}
+	var fused []SearchResult
+	if record.FusionMethod == FusionWeighted {
+		fused = weightedFusion(lists, weights)
+	} else {
+		fused = reciprocalRankFusion(lists, weights, r.config.RRFK)
+	}
+	if len(fused) > topK {
+		fused = fused[:topK]
+	}
+	return fused, nil
+}
+
+// reciprocalRankFusion 倒数排名融合：分块的得分为各路结果中 权重/(k+名次) 之和，名次从1开始
+func reciprocalRankFusion(lists [][]SearchResult, weights []float64, k int) []SearchResult {
+	scores := make(map[string]float64)
+	for i, results := range lists {
+		for rank, result := range results {
+			scores[result.ChunkID] += weights[i] / float64(k+rank+1)
+		}
+	}
+	return fusedResults(lists, scores)
+}
+
+// weightedFusion 加权融合：各路得分按最小最大值归一化后加权求和，只出现在一路中的分块另一路计0分
+func weightedFusion(lists [][]SearchResult, weights []float64) []SearchResult {
+	scores := make(map[string]float64)
+	for i, results := range lists {
+		if len(results) == 0 {
+			continue
+		}
+		lowest, highest := results[0].Score, results[0].Score
+		for _, result := range results {
+			if result.Score < lowest {
+				lowest = result.Score
+			}
+			if result.Score > highest {
+				highest = result.Score
+			}
+		}
+		for _, result := range results {
+			normalized := 1.0
+			if highest > lowest {
+				normalized = float64(result.Score-lowest) / float64(highest-lowest)
+			}
+			scores[result.ChunkID] += weights[i] * normalized
+		}
+	}
+	return fusedResults(lists, scores)
+}
+
+// fusedResults 按融合得分从高到低排列分块，内容和元数据取自最先出现的结果
+func fusedResults(lists [][]SearchResult, scores map[string]float64) []SearchResult {
+	fused := make([]SearchResult, 0, len(scores))
+	seen := make(map[string]bool, len(scores))
+	for _, results := range lists {
+		for _, result := range results {
+			if seen[result.ChunkID] {
+				continue
+			}
+			seen[result.ChunkID] = true
+			result.Score = float32(scores[result.ChunkID])
+			fused = append(fused, result)
+		}
+	}
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i].Score > fused[j].Score
+	})
+	return fused
+}
@@ -0,0 +1,37 @@
+package kb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReciprocalRankFusion(t *testing.T) {
+	vector := []SearchResult{{ChunkID: "a", Score: 0.9}, {ChunkID: "b", Score: 0.8}, {ChunkID: "c", Score: 0.7}}
+	keyword := []SearchResult{{ChunkID: "c", Score: 12}, {ChunkID: "b", Score: 3}}
+
+	// b: 1/62 + 1/62，c: 1/63 + 1/61，a: 1/61
+	fused := reciprocalRankFusion([][]SearchResult{vector, keyword}, []float64{1, 1}, 60)
+	if got := resultIDs(fused); strings.Join(got, ",") != "c,b,a" {
+		t.Errorf("reciprocalRankFusion = %v, want [c b a]", got)
+	}
+
+	// 关键词一路权重为0时只按向量结果的名次排列
+	fused = reciprocalRankFusion([][]SearchResult{vector, keyword}, []float64{1, 0}, 60)
+	if got := resultIDs(fused); strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("weighted reciprocalRankFusion = %v, want [a b c]", got)
+	}
+}
+
+func TestWeightedFusion(t *testing.T) {
+	vector := []SearchResult{{ChunkID: "a", Score: 0.9}, {ChunkID: "b", Score: 0.5}}
+	keyword := []SearchResult{{ChunkID: "b", Score: 10}, {ChunkID: "c", Score: 2}}
+
+	// a: 0.5*1，b: 0.5*0 + 0.5*1，c: 0.5*0，分数相同时保持先出现的顺序
+	fused := weightedFusion([][]SearchResult{vector, keyword}, []float64{0.5, 0.5})
+	if got := resultIDs(fused); strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("weightedFusion = %v, want [a b c]", got)
+	}
+	if fused[0].Score != 0.5 || fused[2].Score != 0 {
+		t.Errorf("weightedFusion scores = %v, %v, want 0.5 and 0", fused[0].Score, fused[2].Score)
+	}
+}
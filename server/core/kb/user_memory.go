@@ -0,0 +1,394 @@
+package kb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/eino"
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 长期记忆的相似度阈值
+const (
+	memoryRecallMinScore = 0.3  // 低于该分数的记忆不注入
+	memoryDuplicateScore = 0.92 // 高于该分数视为同一条记忆，更新而不是新增
+	maxExtractedMemories = 5    // 每轮最多提取的记忆条数
+)
+
+// userMemoryCollection 所有长期记忆共用的向量集合，按 agent_id 与 user_id 元数据区分
+const userMemoryCollection = "user_memories"
+
+var ErrUserMemoryNotFound = errors.New("user memory not found")
+
+// UserMemoryStore 按（智能体，用户）保存长期记忆：数据库保存原文，向量数据库用于语义召回
+type UserMemoryStore struct {
+	db             *gorm.DB
+	vectorDB       VectorDatabase
+	embedding      *EmbeddingManager
+	embeddingModel string
+	logger         *zap.Logger
+}
+
+// NewUserMemoryStore 创建长期记忆存储，vectorDB 为空时按更新时间召回最近的记忆
+func NewUserMemoryStore(db *gorm.DB, vectorDB VectorDatabase, embedding *EmbeddingManager, embeddingModel string) *UserMemoryStore {
+	return &UserMemoryStore{
+		db:             db,
+		vectorDB:       vectorDB,
+		embedding:      embedding,
+		embeddingModel: embeddingModel,
+		logger:         zap.L().With(zap.String("component", "user_memory")),
+	}
+}
+
+// ForUser 实现 agent.LongTermMemoryProvider
+func (s *UserMemoryStore) ForUser(agentID, userID uuid.UUID, runtime *agent.Agent, topK int) agent.LongTermMemory {
+	return &userMemory{
+		store:   s,
+		agentID: agentID,
+		userID:  userID,
+		runtime: runtime,
+		topK:    topK,
+	}
+}
+
+// List 列出用户在智能体下的全部记忆
+func (s *UserMemoryStore) List(ctx context.Context, agentID, userID uuid.UUID) ([]models.UserMemory, error) {
+	var memories []models.UserMemory
+	if err := s.db.WithContext(ctx).
+		Where("agent_id = ? AND user_id = ?", agentID, userID).
+		Order("updated_at DESC").
+		Find(&memories).Error; err != nil {
+		return nil, err
+	}
+	return memories, nil
+}
+
+// Get 获取单条记忆
+func (s *UserMemoryStore) Get(ctx context.Context, id uuid.UUID) (*models.UserMemory, error) {
+	var memory models.UserMemory
+	if err := s.db.WithContext(ctx).First(&memory, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserMemoryNotFound
+		}
+		return nil, err
+	}
+	return &memory, nil
+}
+
+// Update 修改记忆内容并重新向量化
+func (s *UserMemoryStore) Update(ctx context.Context, memory *models.UserMemory, content string) error {
+	if err := s.db.WithContext(ctx).Model(memory).Updates(map[string]interface{}{
+		"content": content,
+		"source":  "edited",
+	}).Error; err != nil {
+		return err
+	}
+	return s.index(ctx, memory)
+}
+
+// Delete 删除单条记忆及其向量
+func (s *UserMemoryStore) Delete(ctx context.Context, memory *models.UserMemory) error {
+	if err := s.db.WithContext(ctx).Delete(memory).Error; err != nil {
+		return err
+	}
+	if s.vectorDB == nil {
+		return nil
+	}
+	return s.vectorDB.DeleteVectors(ctx, userMemoryCollection, []string{memory.ID.String()})
+}
+
+// DeleteAll 删除用户在智能体下的全部记忆及其向量
+func (s *UserMemoryStore) DeleteAll(ctx context.Context, agentID, userID uuid.UUID) error {
+	var ids []string
+	if err := s.db.WithContext(ctx).Model(&models.UserMemory{}).
+		Where("agent_id = ? AND user_id = ?", agentID, userID).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).
+		Where("agent_id = ? AND user_id = ?", agentID, userID).
+		Delete(&models.UserMemory{}).Error; err != nil {
+		return err
+	}
+	if s.vectorDB == nil {
+		return nil
+	}
+	return s.vectorDB.DeleteVectors(ctx, userMemoryCollection, ids)
+}
+
+// remember 保存一条记忆，与已有记忆高度相似时更新已有记忆
+func (s *UserMemoryStore) remember(ctx context.Context, agentID, userID uuid.UUID, content string, conversationID *uuid.UUID) error {
+	if s.vectorDB != nil {
+		vector, err := s.embed(ctx, content)
+		if err != nil {
+			return err
+		}
+		if err := s.vectorDB.CreateCollection(ctx, userMemoryCollection, len(vector)); err != nil {
+			return err
+		}
+		results, err := s.vectorDB.Search(ctx, userMemoryCollection, vector, 1, memoryFilter(agentID, userID))
+		if err != nil {
+			return err
+		}
+		if len(results) > 0 && results[0].Score >= memoryDuplicateScore {
+			if id, err := uuid.Parse(results[0].ChunkID); err == nil {
+				existing, err := s.Get(ctx, id)
+				if err == nil {
+					return s.replace(ctx, existing, content, vector)
+				}
+				if !errors.Is(err, ErrUserMemoryNotFound) {
+					return err
+				}
+			}
+		}
+
+		memory := models.UserMemory{
+			AgentID:        agentID,
+			UserID:         userID,
+			Content:        content,
+			Source:         "extracted",
+			ConversationID: conversationID,
+		}
+		if err := s.db.WithContext(ctx).Create(&memory).Error; err != nil {
+			return err
+		}
+		return s.insertVector(ctx, &memory, vector)
+	}
+
+	// 没有向量数据库时按原文去重
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.UserMemory{}).
+		Where("agent_id = ? AND user_id = ? AND content = ?", agentID, userID, content).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Create(&models.UserMemory{
+		AgentID:        agentID,
+		UserID:         userID,
+		Content:        content,
+		Source:         "extracted",
+		ConversationID: conversationID,
+	}).Error
+}
+
+// replace 用新提取的内容更新已有记忆
+func (s *UserMemoryStore) replace(ctx context.Context, memory *models.UserMemory, content string, vector []float32) error {
+	if err := s.db.WithContext(ctx).Model(memory).Update("content", content).Error; err != nil {
+		return err
+	}
+	memory.Content = content
+	if err := s.vectorDB.DeleteVectors(ctx, userMemoryCollection, []string{memory.ID.String()}); err != nil {
+		return err
+	}
+	return s.insertVector(ctx, memory, vector)
+}
+
+// index 重新计算记忆的向量
+func (s *UserMemoryStore) index(ctx context.Context, memory *models.UserMemory) error {
+	if s.vectorDB == nil {
+		return nil
+	}
+	vector, err := s.embed(ctx, memory.Content)
+	if err != nil {
+		return err
+	}
+	if err := s.vectorDB.CreateCollection(ctx, userMemoryCollection, len(vector)); err != nil {
+		return err
+	}
+	if err := s.vectorDB.DeleteVectors(ctx, userMemoryCollection, []string{memory.ID.String()}); err != nil {
+		return err
+	}
+	return s.insertVector(ctx, memory, vector)
+}
+
+// insertVector 写入记忆向量
+func (s *UserMemoryStore) insertVector(ctx context.Context, memory *models.UserMemory, vector []float32) error {
+	return s.vectorDB.InsertVectors(ctx, userMemoryCollection,
+		[]string{memory.ID.String()},
+		[][]float32{vector},
+		[]map[string]interface{}{{
+			"content":  memory.Content,
+			"agent_id": memory.AgentID.String(),
+			"user_id":  memory.UserID.String(),
+		}})
+}
+
+// recall 按语义相似度召回记忆，没有向量数据库时返回最近更新的记忆
+func (s *UserMemoryStore) recall(ctx context.Context, agentID, userID uuid.UUID, query string, topK int) ([]string, error) {
+	if s.vectorDB == nil {
+		var memories []models.UserMemory
+		if err := s.db.WithContext(ctx).
+			Where("agent_id = ? AND user_id = ?", agentID, userID).
+			Order("updated_at DESC").
+			Limit(topK).
+			Find(&memories).Error; err != nil {
+			return nil, err
+		}
+		contents := make([]string, len(memories))
+		for i, memory := range memories {
+			contents[i] = memory.Content
+		}
+		return contents, nil
+	}
+
+	// 用户还没有任何记忆时无需向量化查询
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.UserMemory{}).
+		Where("agent_id = ? AND user_id = ?", agentID, userID).
+		Count(&count).Error; err != nil || count == 0 {
+		return nil, err
+	}
+
+	vector, err := s.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.vectorDB.CreateCollection(ctx, userMemoryCollection, len(vector)); err != nil {
+		return nil, err
+	}
+	results, err := s.vectorDB.Search(ctx, userMemoryCollection, vector, topK, memoryFilter(agentID, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	// 以数据库为准，过滤已删除的记忆并使用最新内容
+	ids := make([]uuid.UUID, 0, len(results))
+	for _, result := range results {
+		if result.Score < memoryRecallMinScore {
+			continue
+		}
+		if id, err := uuid.Parse(result.ChunkID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var memories []models.UserMemory
+	if err := s.db.WithContext(ctx).
+		Where("id IN ? AND agent_id = ? AND user_id = ?", ids, agentID, userID).
+		Find(&memories).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]string, len(memories))
+	for _, memory := range memories {
+		byID[memory.ID] = memory.Content
+	}
+
+	contents := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if content, ok := byID[id]; ok {
+			contents = append(contents, content)
+		}
+	}
+	return contents, nil
+}
+
+// embed 向量化单段文本
+func (s *UserMemoryStore) embed(ctx context.Context, text string) ([]float32, error) {
+	resp, err := s.embedding.Embed(ctx, EmbeddingRequest{
+		Texts: []string{text},
+		Model: s.embeddingModel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) == 0 {
+		return nil, errors.New("embedding model returned no vectors")
+	}
+	return resp.Embeddings[0], nil
+}
+
+// memoryFilter 将共用集合中的检索限定在（智能体，用户）的记忆内
+func memoryFilter(agentID, userID uuid.UUID) *Filter {
+	return &Filter{And: []*Filter{
+		{Field: "agent_id", Op: FilterEq, Value: agentID.String()},
+		{Field: "user_id", Op: FilterEq, Value: userID.String()},
+	}}
+}
+
+// userMemory 绑定到具体（智能体，用户）的长期记忆
+type userMemory struct {
+	store   *UserMemoryStore
+	agentID uuid.UUID
+	userID  uuid.UUID
+	runtime *agent.Agent
+	topK    int
+}
+
+// Recall 召回与本轮输入相关的记忆
+func (m *userMemory) Recall(ctx context.Context, query string) ([]string, error) {
+	return m.store.recall(ctx, m.agentID, m.userID, query, m.topK)
+}
+
+// Observe 使用智能体的模型从对话中提取关于用户的持久信息
+func (m *userMemory) Observe(ctx context.Context, messages []eino.Message) error {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+
+	prompt := "从以下对话中提取关于用户的、在今后的对话中仍然有用的事实，例如身份、偏好、长期目标和重要约定。" +
+		"忽略一次性的问题和寒暄，不要推测。以JSON字符串数组输出，每条不超过50字，没有可提取的内容时输出 []。\n\n" +
+		transcript.String()
+
+	output, err := m.runtime.Generate(ctx, []eino.Message{{Role: eino.RoleUser, Content: prompt}})
+	if err != nil {
+		return err
+	}
+
+	facts, err := parseExtractedFacts(output)
+	if err != nil {
+		return err
+	}
+
+	var conversationID *uuid.UUID
+	if id, err := uuid.Parse(agent.ConversationIDFromContext(ctx)); err == nil {
+		conversationID = &id
+	}
+	for _, fact := range facts {
+		if err := m.store.remember(ctx, m.agentID, m.userID, fact, conversationID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExtractedFacts 解析模型输出的JSON数组，容忍前后的说明文字和代码块
+func parseExtractedFacts(output string) ([]string, error) {
+	start := strings.Index(output, "[")
+	end := strings.LastIndex(output, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("unexpected memory extraction output: %q", output)
+	}
+
+	var raw []string
+	if err := json.Unmarshal([]byte(output[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("invalid memory extraction output: %w", err)
+	}
+
+	facts := make([]string, 0, len(raw))
+	for _, fact := range raw {
+		if fact = strings.TrimSpace(fact); fact != "" {
+			facts = append(facts, fact)
+		}
+		if len(facts) == maxExtractedMemories {
+			break
+		}
+	}
+	return facts, nil
+}
@@ -0,0 +1,179 @@
+package kb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/testdb"
+)
+
+// topicEmbeddingModel 按文本中的关键词返回固定方向的向量，便于构造相似与不相似的记忆
+type topicEmbeddingModel struct{}
+
+func (topicEmbeddingModel) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		switch {
+		case strings.Contains(text, "tea"):
+			vectors[i] = []float32{1, 0}
+		default:
+			vectors[i] = []float32{0, 1}
+		}
+	}
+	return vectors, nil
+}
+
+func (topicEmbeddingModel) Dimensions() int   { return 2 }
+func (topicEmbeddingModel) ModelName() string { return "topic" }
+
+// newTestUserMemoryStore 创建长期记忆存储，withVectors 为 false 时不使用向量数据库
+func newTestUserMemoryStore(t *testing.T, withVectors bool) *UserMemoryStore {
+	t.Helper()
+	db := testdb.Open(t, &models.UserMemory{})
+	if !withVectors {
+		return NewUserMemoryStore(db, nil, nil, "")
+	}
+	embedding := NewEmbeddingManager()
+	embedding.RegisterModel("topic", topicEmbeddingModel{})
+	return NewUserMemoryStore(db, NewInMemoryVectorDB(), embedding, "topic")
+}
+
+func memoryContents(memories []models.UserMemory) []string {
+	contents := make([]string, len(memories))
+	for i, memory := range memories {
+		contents[i] = memory.Content
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func TestParseExtractedFacts(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{"plain array", `["likes tea", "lives in Paris"]`, []string{"likes tea", "lives in Paris"}, false},
+		{"code block", "```json\n[\"likes tea\"]\n```", []string{"likes tea"}, false},
+		{"blank entries", `["  ", " likes tea "]`, []string{"likes tea"}, false},
+		{"empty", `[]`, []string{}, false},
+		{"capped", `["1","2","3","4","5","6"]`, []string{"1", "2", "3", "4", "5"}, false},
+		{"no array", "nothing to remember", nil, true},
+		{"invalid json", `[likes tea]`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtractedFacts(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExtractedFacts error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("facts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserMemoryStoreWithoutVectors(t *testing.T) {
+	ctx := context.Background()
+	store := newTestUserMemoryStore(t, false)
+	agentID, userID, otherUser := uuid.New(), uuid.New(), uuid.New()
+
+	for _, content := range []string{"likes tea", "likes tea", "lives in Paris"} {
+		if err := store.remember(ctx, agentID, userID, content, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.remember(ctx, agentID, otherUser, "likes coffee", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有向量数据库时按原文去重
+	memories, err := store.List(ctx, agentID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memoryContents(memories); strings.Join(got, "|") != "likes tea|lives in Paris" {
+		t.Errorf("memories = %q, want tea and Paris", got)
+	}
+
+	recalled, err := store.recall(ctx, agentID, userID, "anything", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recalled) != 1 || recalled[0] == "likes coffee" {
+		t.Errorf("recalled = %q, want one of the user's memories", recalled)
+	}
+
+	if err := store.DeleteAll(ctx, agentID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if memories, _ := store.List(ctx, agentID, userID); len(memories) != 0 {
+		t.Errorf("%d memories after DeleteAll, want 0", len(memories))
+	}
+	if memories, _ := store.List(ctx, agentID, otherUser); len(memories) != 1 {
+		t.Errorf("DeleteAll removed another user's memories")
+	}
+}
+
+func TestUserMemoryStoreWithVectors(t *testing.T) {
+	ctx := context.Background()
+	store := newTestUserMemoryStore(t, true)
+	agentID, userID, otherUser := uuid.New(), uuid.New(), uuid.New()
+
+	if recalled, err := store.recall(ctx, agentID, userID, "tea", 5); err != nil || len(recalled) != 0 {
+		t.Fatalf("recall without memories = %q, %v, want none", recalled, err)
+	}
+
+	for _, content := range []string{"likes tea", "lives in Paris"} {
+		if err := store.remember(ctx, agentID, userID, content, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.remember(ctx, agentID, otherUser, "likes green tea", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 高度相似的记忆更新已有记录而不是新增
+	if err := store.remember(ctx, agentID, userID, "likes black tea", nil); err != nil {
+		t.Fatal(err)
+	}
+	memories, err := store.List(ctx, agentID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memoryContents(memories); strings.Join(got, "|") != "likes black tea|lives in Paris" {
+		t.Errorf("memories = %q, want the tea memory replaced", got)
+	}
+
+	// 只召回相似度足够且属于该用户的记忆
+	recalled, err := store.recall(ctx, agentID, userID, "what tea", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(recalled, "|") != "likes black tea" {
+		t.Errorf("recalled = %q, want only the user's tea memory", recalled)
+	}
+
+	// 删除后不再召回
+	var tea models.UserMemory
+	for _, memory := range memories {
+		if strings.Contains(memory.Content, "tea") {
+			tea = memory
+		}
+	}
+	if err := store.Delete(ctx, &tea); err != nil {
+		t.Fatal(err)
+	}
+	if recalled, _ := store.recall(ctx, agentID, userID, "what tea", 5); len(recalled) != 0 {
+		t.Errorf("recalled after Delete = %q, want none", recalled)
+	}
+	if recalled, _ := store.recall(ctx, agentID, otherUser, "what tea", 5); strings.Join(recalled, "|") != "likes green tea" {
+		t.Errorf("other user's recall = %q, want green tea", recalled)
+	}
+}
@@ -12,15 +12,35 @@ import (
 
 	"github.com/milvus-io/milvus-sdk-go/v2/client"
 	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"gorm.io/gorm"
+)
+
+// 向量数据库类型
+const (
+	VectorDBProviderMilvus   = "milvus"   // Milvus，默认
+	VectorDBProviderMemory   = "memory"   // 进程内存，重启后数据丢失，仅用于开发测试
+	VectorDBProviderPgVector = "pgvector" // PostgreSQL pgvector 扩展，与业务数据共用数据库
 )
 
 // VectorDBConfig 向量数据库配置
 type VectorDBConfig struct {
+	Provider string // milvus, memory, pgvector，为空时使用 Milvus
+
 	// Milvus配置
 	MilvusHost     string
 	MilvusPort     int
 	MilvusUsername string
 	MilvusPassword string
+
+	// pgvector配置
+	PgVectorDB             *gorm.DB // 为空时使用知识库模块的数据库
+	PgVectorIndex          string   // hnsw, ivfflat, none，为空时使用 hnsw
+	PgVectorMetric         string   // cosine, l2, ip，为空时使用 cosine
+	PgVectorLists          int      // IVFFlat 聚类数
+	PgVectorProbes         int      // IVFFlat 查询时探测的聚类数，0 使用扩展默认值
+	PgVectorM              int      // HNSW 每个节点的最大连接数
+	PgVectorEfConstruction int      // HNSW 构建索引时的候选集大小
+	PgVectorEfSearch       int      // HNSW 查询时的候选集大小，0 使用扩展默认值
 }
 
 // SearchResult 搜索结果
@@ -48,8 +68,8 @@ type VectorDatabase interface {
 	// InsertVectors 插入向量
 	InsertVectors(ctx context.Context, collectionName string, ids []string, vectors [][]float32, metadata []map[string]interface{}) error
 	
-	// Search 搜索向量
-	Search(ctx context.Context, collectionName string, vector []float32, topK int) ([]SearchResult, error)
+	// Search 搜索向量，filter 为 nil 时不过滤
+	Search(ctx context.Context, collectionName string, vector []float32, topK int, filter *Filter) ([]SearchResult, error)
 	
 	// DeleteVectors 删除向量
 	DeleteVectors(ctx context.Context, collectionName string, ids []string) error
@@ -284,7 +304,7 @@ func (m *MilvusDB) InsertVectors(ctx context.Context, collectionName string, ids
 }
 
 // Search 执行向量搜索
-func (m *MilvusDB) Search(ctx context.Context, collectionName string, vector []float32, topK int) ([]SearchResult, error) {
+func (m *MilvusDB) Search(ctx context.Context, collectionName string, vector []float32, topK int, filter *Filter) ([]SearchResult, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
@@ -295,6 +315,9 @@ func (m *MilvusDB) Search(ctx context.Context, collectionName string, vector []f
 	if _, exists := m.collections[collectionName]; !exists {
 		return nil, fmt.Errorf("collection %s does not exist", collectionName)
 	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
 	
 	// 准备搜索参数
 	vectors := []entity.Vector{entity.FloatVector(vector)}
@@ -303,12 +326,12 @@ func (m *MilvusDB) Search(ctx context.Context, collectionName string, vector []f
 		return nil, fmt.Errorf("failed to create search param: %w", err)
 	}
 	
-	// 执行搜索
+	// 执行搜索，过滤条件转换为布尔表达式
 	searchResult, err := m.client.Search(
 		ctx,
 		collectionName,
-		"",
-		"",
+		nil,
+		filter.MilvusExpr(),
 		[]string{"id", "content", "metadata"},
 		vectors,
 		"vector",
@@ -387,7 +410,16 @@ var DefaultVectorDB VectorDatabase
 
 // 初始化默认向量数据库
 func InitDefaultVectorDB(config VectorDBConfig) error {
-	DefaultVectorDB = NewMilvusDB(config)
+	switch config.Provider {
+	case "", VectorDBProviderMilvus:
+		DefaultVectorDB = NewMilvusDB(config)
+	case VectorDBProviderMemory:
+		DefaultVectorDB = NewInMemoryVectorDB()
+	case VectorDBProviderPgVector:
+		DefaultVectorDB = NewPgVectorDB(config)
+	default:
+		return fmt.Errorf("unsupported vector database provider: %s", config.Provider)
+	}
 	return nil
 }
 
@@ -491,7 +523,7 @@ func (db *InMemoryVectorDB) InsertVectors(ctx context.Context, collectionName st
 }
 
 // Search 搜索向量
-func (db *InMemoryVectorDB) Search(ctx context.Context, collectionName string, vector []float32, topK int) ([]SearchResult, error) {
+func (db *InMemoryVectorDB) Search(ctx context.Context, collectionName string, vector []float32, topK int, filter *Filter) ([]SearchResult, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	
@@ -499,6 +531,9 @@ func (db *InMemoryVectorDB) Search(ctx context.Context, collectionName string, v
 	if !exists {
 		return nil, fmt.Errorf("collection %s does not exist", collectionName)
 	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
 	
 	type ScoredResult struct {
 		ID       string
@@ -509,8 +544,11 @@ func (db *InMemoryVectorDB) Search(ctx context.Context, collectionName string, v
 	
 	results := make([]ScoredResult, 0, len(collection.vectors))
 	
-	// 计算所有向量的距离
+	// 计算所有满足过滤条件的向量的距离
 	for id, data := range collection.vectors {
+		if !filter.Match(data.Metadata) {
+			continue
+		}
 		score := calculateCosineSimilarity(vector, data.Vector)
 		results = append(results, ScoredResult{
 			ID:       id,
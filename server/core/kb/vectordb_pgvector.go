@@ -0,0 +1,471 @@
+package kb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// pgvector 索引类型
+const (
+	PgVectorIndexHNSW    = "hnsw"    // 默认，空表上即可建立，召回率高
+	PgVectorIndexIVFFlat = "ivfflat" // 构建快、占用小，需在数据量较大后重建以保证召回率
+	PgVectorIndexNone    = "none"    // 不建索引，精确扫描
+)
+
+// pgvector 距离度量
+const (
+	PgVectorMetricCosine = "cosine" // 默认
+	PgVectorMetricL2     = "l2"
+	PgVectorMetricIP     = "ip" // 内积，适用于已归一化的向量
+)
+
+const (
+	// pgVectorRegistryTable 记录集合与数据表对应关系的表
+	pgVectorRegistryTable = "vector_collections"
+
+	// pgVectorMaxIndexDimensions pgvector 的 HNSW/IVFFlat 索引支持的最大维度，超出时不建索引
+	pgVectorMaxIndexDimensions = 2000
+
+	// pgVectorMaxTableName 数据表名的最大长度，为索引名后缀预留空间（PostgreSQL 标识符最长63字节）
+	pgVectorMaxTableName = 48
+
+	// pgVectorInsertBatchSize 单条 INSERT 语句写入的行数，避免超出参数个数上限
+	pgVectorInsertBatchSize = 1000
+
+	defaultPgVectorLists          = 100
+	defaultPgVectorM              = 16
+	defaultPgVectorEfConstruction = 64
+)
+
+// pgVectorCollection 集合对应的数据表
+type pgVectorCollection struct {
+	table     string
+	dimension int
+	metric    string
+}
+
+// PgVectorDB 基于 PostgreSQL pgvector 扩展的向量数据库实现。每个集合对应一张数据表，
+// 元数据以 jsonb 保存，插入和删除在事务中执行
+type PgVectorDB struct {
+	db          *gorm.DB
+	config      VectorDBConfig
+	collections map[string]pgVectorCollection
+	mu          sync.RWMutex
+}
+
+// NewPgVectorDB 创建pgvector数据库实例，config.PgVectorDB 不能为空
+func NewPgVectorDB(config VectorDBConfig) *PgVectorDB {
+	if config.PgVectorIndex == "" {
+		config.PgVectorIndex = PgVectorIndexHNSW
+	}
+	if config.PgVectorMetric == "" {
+		config.PgVectorMetric = PgVectorMetricCosine
+	}
+	if config.PgVectorLists <= 0 {
+		config.PgVectorLists = defaultPgVectorLists
+	}
+	if config.PgVectorM <= 0 {
+		config.PgVectorM = defaultPgVectorM
+	}
+	if config.PgVectorEfConstruction <= 0 {
+		config.PgVectorEfConstruction = defaultPgVectorEfConstruction
+	}
+	return &PgVectorDB{
+		db:          config.PgVectorDB,
+		config:      config,
+		collections: make(map[string]pgVectorCollection),
+	}
+}
+
+// Connect 启用 vector 扩展并创建集合登记表
+func (p *PgVectorDB) Connect(ctx context.Context) error {
+	if p.db == nil {
+		return errors.New("pgvector requires a database connection")
+	}
+	if _, err := pgVectorOperator(p.config.PgVectorMetric); err != nil {
+		return err
+	}
+	switch p.config.PgVectorIndex {
+	case PgVectorIndexHNSW, PgVectorIndexIVFFlat, PgVectorIndexNone:
+	default:
+		return fmt.Errorf("unsupported pgvector index type: %s", p.config.PgVectorIndex)
+	}
+
+	db := p.db.WithContext(ctx)
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		return fmt.Errorf("failed to enable pgvector extension: %w", err)
+	}
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + pgVectorRegistryTable + ` (
+		name varchar(255) PRIMARY KEY,
+		table_name varchar(63) NOT NULL UNIQUE,
+		dimension integer NOT NULL,
+		metric varchar(20) NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`).Error; err != nil {
+		return fmt.Errorf("failed to create vector collection registry: %w", err)
+	}
+	return nil
+}
+
+// Disconnect 断开连接，数据库连接由调用方管理，这里只清空缓存
+func (p *PgVectorDB) Disconnect(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.collections = make(map[string]pgVectorCollection)
+	return nil
+}
+
+// CreateCollection 创建集合对应的数据表和向量索引，集合已存在时直接返回。
+// 距离度量在创建时确定，之后修改配置只影响新建的集合
+func (p *PgVectorDB) CreateCollection(ctx context.Context, name string, dimension int) error {
+	if dimension <= 0 {
+		return fmt.Errorf("invalid vector dimension: %d", dimension)
+	}
+	if _, err := p.collection(ctx, name); err == nil {
+		return nil
+	}
+
+	collection := pgVectorCollection{
+		table:     pgVectorTableName(name),
+		dimension: dimension,
+		metric:    p.config.PgVectorMetric,
+	}
+	table := quoteIdentifier(collection.table)
+
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id varchar(100) PRIMARY KEY,
+			embedding vector(%d) NOT NULL,
+			content text NOT NULL DEFAULT '',
+			metadata jsonb NOT NULL DEFAULT '{}'
+		)`, table, dimension)).Error; err != nil {
+			return fmt.Errorf("failed to create vector table: %w", err)
+		}
+
+		if index := p.indexDefinition(collection); index != "" {
+			if err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s %s",
+				quoteIdentifier(collection.table+"_embedding_idx"), table, index)).Error; err != nil {
+				return fmt.Errorf("failed to create vector index: %w", err)
+			}
+		}
+		if err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING gin (metadata jsonb_path_ops)",
+			quoteIdentifier(collection.table+"_metadata_idx"), table)).Error; err != nil {
+			return fmt.Errorf("failed to create metadata index: %w", err)
+		}
+
+		// 并发创建同一集合时以先登记的为准
+		return tx.Exec("INSERT INTO "+pgVectorRegistryTable+" (name, table_name, dimension, metric) VALUES (?, ?, ?, ?) ON CONFLICT (name) DO NOTHING",
+			name, collection.table, collection.dimension, collection.metric).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.collections, name)
+	p.mu.Unlock()
+	return nil
+}
+
+// DropCollection 删除集合对应的数据表
+func (p *PgVectorDB) DropCollection(ctx context.Context, name string) error {
+	collection, err := p.collection(ctx, name)
+	if err != nil {
+		return nil // 集合不存在，直接返回
+	}
+
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DROP TABLE IF EXISTS " + quoteIdentifier(collection.table)).Error; err != nil {
+			return fmt.Errorf("failed to drop vector table: %w", err)
+		}
+		return tx.Exec("DELETE FROM "+pgVectorRegistryTable+" WHERE name = ?", name).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.collections, name)
+	p.mu.Unlock()
+	return nil
+}
+
+// InsertVectors 在一个事务中写入向量，ID已存在时覆盖
+func (p *PgVectorDB) InsertVectors(ctx context.Context, collectionName string, ids []string, vectors [][]float32, metadata []map[string]interface{}) error {
+	if len(ids) != len(vectors) || len(ids) != len(metadata) {
+		return errors.New("ids, vectors, and metadata must have the same length")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	collection, err := p.collection(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(ids); start += pgVectorInsertBatchSize {
+			end := start + pgVectorInsertBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+
+			rows := make([]string, 0, end-start)
+			args := make([]interface{}, 0, (end-start)*4)
+			for i := start; i < end; i++ {
+				if len(vectors[i]) != collection.dimension {
+					return fmt.Errorf("vector dimension %d does not match collection dimension %d", len(vectors[i]), collection.dimension)
+				}
+
+				// content 单独存列，其余字段作为元数据
+				content, _ := metadata[i]["content"].(string)
+				fields := make(map[string]interface{}, len(metadata[i]))
+				for key, value := range metadata[i] {
+					if key != "content" {
+						fields[key] = value
+					}
+				}
+				metadataJSON, err := json.Marshal(fields)
+				if err != nil {
+					return fmt.Errorf("failed to marshal metadata: %w", err)
+				}
+
+				rows = append(rows, "(?, ?::vector, ?, ?::jsonb)")
+				args = append(args, ids[i], vectorLiteral(vectors[i]), content, string(metadataJSON))
+			}
+
+			query := fmt.Sprintf(`INSERT INTO %s (id, embedding, content, metadata) VALUES %s
+				ON CONFLICT (id) DO UPDATE SET embedding = EXCLUDED.embedding, content = EXCLUDED.content, metadata = EXCLUDED.metadata`,
+				quoteIdentifier(collection.table), strings.Join(rows, ", "))
+			if err := tx.Exec(query, args...).Error; err != nil {
+				return fmt.Errorf("failed to insert vectors: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Search 搜索最相似的向量，过滤条件转换为 jsonpath 谓词。
+// 分数越大越相似：余弦为 1-距离，内积为内积值，L2 为 1/(1+距离)
+func (p *PgVectorDB) Search(ctx context.Context, collectionName string, vector []float32, topK int, filter *Filter) ([]SearchResult, error) {
+	collection, err := p.collection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	if len(vector) != collection.dimension {
+		return nil, fmt.Errorf("vector dimension %d does not match collection dimension %d", len(vector), collection.dimension)
+	}
+	if topK <= 0 {
+		topK = 10
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	operator, err := pgVectorOperator(collection.metric)
+	if err != nil {
+		return nil, err
+	}
+	distance := fmt.Sprintf("embedding %s ?::vector", operator)
+	literal := vectorLiteral(vector)
+	where := ""
+	args := []interface{}{literal}
+	if filter != nil {
+		where = " WHERE metadata @@ ?::jsonpath"
+		args = append(args, filter.JSONPath())
+	}
+	args = append(args, literal, topK)
+	query := fmt.Sprintf("SELECT id, content, metadata, %s AS score FROM %s%s ORDER BY %s LIMIT ?",
+		pgVectorScore(collection.metric, distance), quoteIdentifier(collection.table), where, distance)
+
+	var rows []struct {
+		ID       string
+		Content  string
+		Metadata string
+		Score    float32
+	}
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 查询参数只在当前事务内生效
+		if p.config.PgVectorEfSearch > 0 {
+			if err := tx.Exec(fmt.Sprintf("SET LOCAL hnsw.ef_search = %d", p.config.PgVectorEfSearch)).Error; err != nil {
+				return err
+			}
+		}
+		if p.config.PgVectorProbes > 0 {
+			if err := tx.Exec(fmt.Sprintf("SET LOCAL ivfflat.probes = %d", p.config.PgVectorProbes)).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Raw(query, args...).Scan(&rows).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute search: %w", err)
+	}
+
+	results := make([]SearchResult, len(rows))
+	for i, row := range rows {
+		var metadata map[string]interface{}
+		if err := json.Unmarshal([]byte(row.Metadata), &metadata); err != nil {
+			// 忽略解析错误，使用空元数据
+			metadata = make(map[string]interface{})
+		}
+		results[i] = SearchResult{
+			ChunkID:  row.ID,
+			Content:  row.Content,
+			Score:    row.Score,
+			Metadata: metadata,
+		}
+	}
+	return results, nil
+}
+
+// DeleteVectors 在一个事务中删除向量
+func (p *PgVectorDB) DeleteVectors(ctx context.Context, collectionName string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	collection, err := p.collection(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM "+quoteIdentifier(collection.table)+" WHERE id IN ?", ids).Error; err != nil {
+			return fmt.Errorf("failed to delete vectors: %w", err)
+		}
+		return nil
+	})
+}
+
+// collection 查找集合对应的数据表，其他实例创建的集合从登记表读取
+func (p *PgVectorDB) collection(ctx context.Context, name string) (pgVectorCollection, error) {
+	p.mu.RLock()
+	collection, ok := p.collections[name]
+	p.mu.RUnlock()
+	if ok {
+		return collection, nil
+	}
+
+	var record struct {
+		TableName string
+		Dimension int
+		Metric    string
+	}
+	result := p.db.WithContext(ctx).
+		Raw("SELECT table_name, dimension, metric FROM "+pgVectorRegistryTable+" WHERE name = ?", name).
+		Scan(&record)
+	if result.Error != nil {
+		return pgVectorCollection{}, fmt.Errorf("failed to look up collection %s: %w", name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return pgVectorCollection{}, fmt.Errorf("collection %s does not exist", name)
+	}
+
+	collection = pgVectorCollection{table: record.TableName, dimension: record.Dimension, metric: record.Metric}
+	p.mu.Lock()
+	p.collections[name] = collection
+	p.mu.Unlock()
+	return collection, nil
+}
+
+// indexDefinition 返回向量索引的定义，不建索引时返回空字符串
+func (p *PgVectorDB) indexDefinition(collection pgVectorCollection) string {
+	if collection.dimension > pgVectorMaxIndexDimensions {
+		return ""
+	}
+
+	ops := map[string]string{
+		PgVectorMetricCosine: "vector_cosine_ops",
+		PgVectorMetricL2:     "vector_l2_ops",
+		PgVectorMetricIP:     "vector_ip_ops",
+	}[collection.metric]
+
+	switch p.config.PgVectorIndex {
+	case PgVectorIndexHNSW:
+		return fmt.Sprintf("USING hnsw (embedding %s) WITH (m = %d, ef_construction = %d)",
+			ops, p.config.PgVectorM, p.config.PgVectorEfConstruction)
+	case PgVectorIndexIVFFlat:
+		return fmt.Sprintf("USING ivfflat (embedding %s) WITH (lists = %d)", ops, p.config.PgVectorLists)
+	default:
+		return ""
+	}
+}
+
+// pgVectorOperator 返回距离度量对应的 pgvector 运算符
+func pgVectorOperator(metric string) (string, error) {
+	switch metric {
+	case PgVectorMetricCosine:
+		return "<=>", nil
+	case PgVectorMetricL2:
+		return "<->", nil
+	case PgVectorMetricIP:
+		return "<#>", nil // 返回内积的相反数
+	default:
+		return "", fmt.Errorf("unsupported pgvector metric: %s", metric)
+	}
+}
+
+// pgVectorScore 将距离表达式转换为越大越相似的分数
+func pgVectorScore(metric, distance string) string {
+	switch metric {
+	case PgVectorMetricL2:
+		return fmt.Sprintf("1 / (1 + (%s))", distance)
+	case PgVectorMetricIP:
+		return fmt.Sprintf("-(%s)", distance)
+	default:
+		return fmt.Sprintf("1 - (%s)", distance)
+	}
+}
+
+// pgVectorTableName 将集合名称转换为合法的表名，过长时截断并附加哈希以保证唯一
+func pgVectorTableName(name string) string {
+	var builder strings.Builder
+	builder.WriteString("vec_")
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('_')
+		}
+	}
+
+	table := builder.String()
+	if len(table) <= pgVectorMaxTableName && table == "vec_"+name {
+		return table
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:8])
+	if len(table) > pgVectorMaxTableName-len(suffix) {
+		table = table[:pgVectorMaxTableName-len(suffix)]
+	}
+	return table + suffix
+}
+
+// quoteIdentifier 为SQL标识符加双引号
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// vectorLiteral 将向量转换为 pgvector 的文本表示，如 [0.1,0.2]
+func vectorLiteral(vector []float32) string {
+	var builder strings.Builder
+	builder.WriteByte('[')
+	for i, value := range vector {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(strconv.FormatFloat(float64(value), 'f', -1, 32))
+	}
+	builder.WriteByte(']')
+	return builder.String()
+}
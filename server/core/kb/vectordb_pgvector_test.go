@@ -0,0 +1,154 @@
+package kb
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// pgVectorTestDSNEnv 集成测试使用的数据库连接串，未设置时跳过，数据库需已安装 pgvector 扩展
+const pgVectorTestDSNEnv = "LYSS_TEST_PGVECTOR_DSN"
+
+func TestFilterJSONPath(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		want string
+	}{
+		{"eq", map[string]interface{}{"document_id": "d1"}, `strict $."document_id" == "d1"`},
+		{"in", map[string]interface{}{"document_id": []interface{}{"d1", "d2"}}, `strict ($."document_id" == "d1" || $."document_id" == "d2")`},
+		{"range", map[string]interface{}{"page_number": map[string]interface{}{"gte": 2, "lt": 5}}, `strict ($."page_number" >= 2 && $."page_number" < 5)`},
+		{"contains", map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}}, `strict $."tags"[*] == "faq"`},
+		{"or", map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"document_id": "d1"},
+			map[string]interface{}{"page_number": map[string]interface{}{"ne": 3}},
+		}}, `strict ($."document_id" == "d1" || $."page_number" != 3)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			if got := filter.JSONPath(); got != tt.want {
+				t.Errorf("JSONPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPgVectorDB(t *testing.T) {
+	dsn := os.Getenv(pgVectorTestDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", pgVectorTestDSNEnv)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	ctx := context.Background()
+
+	// 数据量很小，不建索引以保证结果精确
+	vectorDB := NewPgVectorDB(VectorDBConfig{PgVectorDB: db, PgVectorIndex: PgVectorIndexNone})
+	if err := vectorDB.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	collection := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err := vectorDB.CreateCollection(ctx, collection, 3); err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	defer func() {
+		if err := vectorDB.DropCollection(ctx, collection); err != nil {
+			t.Errorf("DropCollection: %v", err)
+		}
+	}()
+
+	ids := []string{"c1", "c2", "c3"}
+	vectors := [][]float32{{1, 0, 0}, {0.8, 0.6, 0}, {0, 0, 1}}
+	metadata := []map[string]interface{}{
+		{"content": "first", "document_id": "d1", "page_number": 1, "tags": []interface{}{"faq", "billing"}},
+		{"content": "second", "document_id": "d1", "page_number": 3, "tags": []interface{}{"billing"}},
+		{"content": "third", "document_id": "d2", "page_number": 5, "tags": []interface{}{}},
+	}
+	if err := vectorDB.InsertVectors(ctx, collection, ids, vectors, metadata); err != nil {
+		t.Fatalf("InsertVectors: %v", err)
+	}
+
+	query := []float32{1, 0, 0}
+	results, err := vectorDB.Search(ctx, collection, query, 3, nil)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := resultIDs(results); strings.Join(got, ",") != "c1,c2,c3" {
+		t.Fatalf("Search order = %v, want [c1 c2 c3]", got)
+	}
+	if results[0].Content != "first" {
+		t.Errorf("Content = %q, want %q", results[0].Content, "first")
+	}
+	if results[0].Score < results[1].Score || results[1].Score < results[2].Score {
+		t.Errorf("scores not descending: %v, %v, %v", results[0].Score, results[1].Score, results[2].Score)
+	}
+
+	filterTests := []struct {
+		name string
+		raw  map[string]interface{}
+		want []string
+	}{
+		{"eq", map[string]interface{}{"document_id": "d2"}, []string{"c3"}},
+		{"in", map[string]interface{}{"document_id": []interface{}{"d1", "d2"}}, []string{"c1", "c2", "c3"}},
+		{"range", map[string]interface{}{"page_number": map[string]interface{}{"gte": 2, "lt": 5}}, []string{"c2"}},
+		{"contains", map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}}, []string{"c1"}},
+		{"ne", map[string]interface{}{"document_id": map[string]interface{}{"ne": "d1"}}, []string{"c3"}},
+		{"missing field", map[string]interface{}{"author": "x"}, nil},
+		{"or", map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"document_id": "d2"},
+			map[string]interface{}{"tags": map[string]interface{}{"contains": "faq"}},
+		}}, []string{"c1", "c3"}},
+	}
+	for _, tt := range filterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			results, err := vectorDB.Search(ctx, collection, query, 10, filter)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			got := resultIDs(results)
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search with filter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := vectorDB.DeleteVectors(ctx, collection, []string{"c1"}); err != nil {
+		t.Fatalf("DeleteVectors: %v", err)
+	}
+	results, err = vectorDB.Search(ctx, collection, query, 3, nil)
+	if err != nil {
+		t.Fatalf("Search after delete: %v", err)
+	}
+	if got := resultIDs(results); strings.Join(got, ",") != "c2,c3" {
+		t.Errorf("Search after delete = %v, want [c2 c3]", got)
+	}
+}
+
+// resultIDs 按顺序取出搜索结果的分块ID
+func resultIDs(results []SearchResult) []string {
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ChunkID
+	}
+	return ids
+}
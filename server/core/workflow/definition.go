@@ -0,0 +1,220 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// NodeType 表示工作流节点类型
+type NodeType string
+
+const (
+	NodeLLM       NodeType = "llm"                 // 调用大模型
+	NodeAgent     NodeType = "agent"               // 调用智能体
+	NodeTool      NodeType = "tool"                // 调用注册的工具
+	NodeKnowledge NodeType = "knowledge_retrieval" // 知识库检索
+	NodeCondition NodeType = "condition"           // 条件分支
+	NodeLoop      NodeType = "loop"                // 对列表逐项执行子流程
+	NodeCode      NodeType = "code"                // 在沙箱中执行代码
+	NodeHTTP      NodeType = "http"                // 发送HTTP请求
+	NodeTemplate  NodeType = "template"            // 渲染文本模板
+	NodeVariable  NodeType = "variable"            // 变量赋值
+)
+
+// 边的出口句柄
+const (
+	HandleDefault = ""      // 节点成功后走的默认出口
+	HandleTrue    = "true"  // 条件成立
+	HandleFalse   = "false" // 条件不成立
+	HandleError   = "error" // 节点执行失败
+)
+
+var (
+	ErrInvalidDefinition = errors.New("invalid workflow definition")
+	ErrUnknownNodeType   = errors.New("unknown workflow node type")
+)
+
+// requiredConfig 各类型节点必须提供的配置项
+var requiredConfig = map[NodeType][]string{
+	NodeLLM:       {"model_config_id", "prompt"},
+	NodeAgent:     {"agent_id", "input"},
+	NodeTool:      {"tool"},
+	NodeKnowledge: {"knowledge_base_id", "query"},
+	NodeCondition: {"conditions"},
+	NodeLoop:      {"items", "body"},
+	NodeCode:      {"language", "code"},
+	NodeHTTP:      {"url"},
+	NodeTemplate:  {"template"},
+	NodeVariable:  {"assignments"},
+}
+
+// Definition 工作流定义，保存在 Application.Config 的 workflow 字段中
+type Definition struct {
+	Version     string            `json:"version,omitempty"`
+	Inputs      []InputDefinition `json:"inputs,omitempty"`
+	Nodes       []Node            `json:"nodes"`
+	Edges       []Edge            `json:"edges"`
+	Outputs     map[string]string `json:"outputs,omitempty"` // 输出名称 -> 变量模板
+	MaxParallel int               `json:"max_parallel,omitempty"`
+}
+
+// InputDefinition 工作流输入参数
+type InputDefinition struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type,omitempty"` // string, number, boolean, object, array
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+// Node 工作流节点
+type Node struct {
+	ID     string                 `json:"id"`
+	Type   NodeType               `json:"type"`
+	Name   string                 `json:"name,omitempty"`
+	Config map[string]interface{} `json:"config"`
+}
+
+// Edge 工作流中连接两个节点的边
+type Edge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Handle string `json:"handle,omitempty"` // 源节点的出口：空、true、false 或 error
+}
+
+// ParseDefinition 从 Application.Config 中解析工作流定义
+func ParseDefinition(config string) (*Definition, error) {
+	if config == "" {
+		return nil, fmt.Errorf("%w: empty config", ErrInvalidDefinition)
+	}
+
+	var wrapper struct {
+		Workflow *Definition `json:"workflow"`
+	}
+	if err := json.Unmarshal([]byte(config), &wrapper); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	if wrapper.Workflow == nil {
+		return nil, fmt.Errorf("%w: missing workflow field", ErrInvalidDefinition)
+	}
+
+	return wrapper.Workflow, nil
+}
+
+// Validate 校验工作流定义：节点唯一、类型已知、配置完整、边有效且无环
+func (d *Definition) Validate() error {
+	if len(d.Nodes) == 0 {
+		return fmt.Errorf("%w: workflow has no nodes", ErrInvalidDefinition)
+	}
+
+	nodes := make(map[string]*Node, len(d.Nodes))
+	for i := range d.Nodes {
+		node := &d.Nodes[i]
+		if node.ID == "" {
+			return fmt.Errorf("%w: node %d has no id", ErrInvalidDefinition, i)
+		}
+		if node.ID == inputsNamespace || node.ID == varsNamespace || node.ID == loopNamespace {
+			return fmt.Errorf("%w: node id %q is reserved", ErrInvalidDefinition, node.ID)
+		}
+		if _, exists := nodes[node.ID]; exists {
+			return fmt.Errorf("%w: duplicate node id %q", ErrInvalidDefinition, node.ID)
+		}
+		required, ok := requiredConfig[node.Type]
+		if !ok {
+			return fmt.Errorf("%w: node %q: %v %q", ErrInvalidDefinition, node.ID, ErrUnknownNodeType, node.Type)
+		}
+		for _, key := range required {
+			if _, ok := node.Config[key]; !ok {
+				return fmt.Errorf("%w: node %q missing config %q", ErrInvalidDefinition, node.ID, key)
+			}
+		}
+		if node.Type == NodeLoop {
+			body, err := loopBody(node)
+			if err != nil {
+				return fmt.Errorf("%w: node %q: %v", ErrInvalidDefinition, node.ID, err)
+			}
+			if err := body.Validate(); err != nil {
+				return fmt.Errorf("node %q body: %w", node.ID, err)
+			}
+		}
+		nodes[node.ID] = node
+	}
+
+	for _, edge := range d.Edges {
+		from, ok := nodes[edge.From]
+		if !ok {
+			return fmt.Errorf("%w: edge references unknown node %q", ErrInvalidDefinition, edge.From)
+		}
+		if _, ok := nodes[edge.To]; !ok {
+			return fmt.Errorf("%w: edge references unknown node %q", ErrInvalidDefinition, edge.To)
+		}
+		switch edge.Handle {
+		case HandleDefault, HandleError:
+		case HandleTrue, HandleFalse:
+			if from.Type != NodeCondition {
+				return fmt.Errorf("%w: handle %q is only valid on condition nodes", ErrInvalidDefinition, edge.Handle)
+			}
+		default:
+			return fmt.Errorf("%w: unknown edge handle %q", ErrInvalidDefinition, edge.Handle)
+		}
+	}
+
+	if _, err := d.topologicalOrder(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// topologicalOrder 返回节点的拓扑顺序，存在环时返回错误
+func (d *Definition) topologicalOrder() ([]string, error) {
+	inDegree := make(map[string]int, len(d.Nodes))
+	for _, node := range d.Nodes {
+		inDegree[node.ID] = 0
+	}
+	for _, edge := range d.Edges {
+		inDegree[edge.To]++
+	}
+
+	var queue []string
+	for _, node := range d.Nodes {
+		if inDegree[node.ID] == 0 {
+			queue = append(queue, node.ID)
+		}
+	}
+
+	order := make([]string, 0, len(d.Nodes))
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		order = append(order, id)
+		for _, edge := range d.Edges {
+			if edge.From != id {
+				continue
+			}
+			inDegree[edge.To]--
+			if inDegree[edge.To] == 0 {
+				queue = append(queue, edge.To)
+			}
+		}
+	}
+
+	if len(order) != len(d.Nodes) {
+		return nil, fmt.Errorf("%w: workflow contains a cycle", ErrInvalidDefinition)
+	}
+	return order, nil
+}
+
+// loopBody 解析循环节点的子流程定义
+func loopBody(node *Node) (*Definition, error) {
+	data, err := json.Marshal(node.Config["body"])
+	if err != nil {
+		return nil, err
+	}
+
+	var body Definition
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("invalid loop body: %v", err)
+	}
+	return &body, nil
+}
@@ -0,0 +1,425 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+	"go.uber.org/zap"
+)
+
+// 节点执行状态
+const (
+	StatusSuccess = "success" // 执行成功
+	StatusFailed  = "failed"  // 执行失败
+	StatusSkipped = "skipped" // 所在分支未被激活
+)
+
+// defaultMaxParallel 同时执行的节点数上限
+const defaultMaxParallel = 4
+
+var ErrMissingInput = errors.New("missing required workflow input")
+
+// NodeResult 节点执行结果
+type NodeResult struct {
+	Output map[string]interface{} // 写入变量表的输出，可通过 {{节点ID.字段}} 引用
+	Handle string                 // 激活的出口，条件节点为 true/false
+}
+
+// NodeExecutor 节点执行器
+type NodeExecutor interface {
+	Execute(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error)
+}
+
+// NodeTrace 单个节点的执行记录
+type NodeTrace struct {
+	NodeID     string                 `json:"node_id"`
+	NodeType   NodeType               `json:"node_type"`
+	Status     string                 `json:"status"`
+	Handle     string                 `json:"handle,omitempty"`
+	Input      map[string]interface{} `json:"input,omitempty"`
+	Output     map[string]interface{} `json:"output,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	StartedAt  time.Time              `json:"started_at"`
+	DurationMs int64                  `json:"duration_ms"`
+}
+
+// Result 工作流执行结果
+type Result struct {
+	Status     string                 `json:"status"`
+	Outputs    map[string]interface{} `json:"outputs,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Trace      []NodeTrace            `json:"trace"`
+	DurationMs int64                  `json:"duration_ms"`
+}
+
+// Execution 一次工作流执行的上下文
+type Execution struct {
+	Vars   *Variables
+	UserID uuid.UUID // 发起执行的用户，节点以该用户的权限访问资源
+	engine *Engine
+	prefix string // 子流程节点的跟踪前缀
+	trace  *[]NodeTrace
+	mu     *sync.Mutex
+}
+
+// Engine 基于有向无环图的工作流引擎
+type Engine struct {
+	executors map[NodeType]NodeExecutor
+	logger    *zap.Logger
+}
+
+// NewEngine 创建工作流引擎并注册内置节点
+func NewEngine(deps Dependencies) *Engine {
+	engine := &Engine{
+		executors: make(map[NodeType]NodeExecutor),
+		logger:    zap.L().With(zap.String("component", "workflow_engine")),
+	}
+	registerBuiltinExecutors(engine, deps)
+	return engine
+}
+
+// RegisterExecutor 注册或替换节点执行器
+func (e *Engine) RegisterExecutor(nodeType NodeType, executor NodeExecutor) {
+	e.executors[nodeType] = executor
+}
+
+// Execute 以指定用户的身份执行工作流
+func (e *Engine) Execute(ctx context.Context, def *Definition, inputs map[string]interface{}, userID uuid.UUID) (*Result, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+
+	resolvedInputs, err := applyInputs(def.Inputs, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	trace := []NodeTrace{}
+	exec := &Execution{
+		Vars:   NewVariables(resolvedInputs),
+		UserID: userID,
+		engine: e,
+		trace:  &trace,
+		mu:     &sync.Mutex{},
+	}
+
+	result := &Result{Status: StatusSuccess}
+	if err := exec.run(ctx, def); err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	} else {
+		result.Outputs = resolveOutputs(def, exec.Vars)
+	}
+	result.Trace = trace
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	return result, nil
+}
+
+// AgentRunner 将引擎适配为工作流智能体的执行器，执行失败时返回错误
+func (e *Engine) AgentRunner() agent.WorkflowRunner {
+	return func(ctx context.Context, definition json.RawMessage, inputs map[string]interface{}, userID uuid.UUID) (map[string]interface{}, error) {
+		var def Definition
+		if err := json.Unmarshal(definition, &def); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+		}
+
+		result, err := e.Execute(ctx, &def, inputs, userID)
+		if err != nil {
+			return nil, err
+		}
+		if result.Status != StatusSuccess {
+			return nil, fmt.Errorf("workflow failed: %s", result.Error)
+		}
+		return result.Outputs, nil
+	}
+}
+
+// RunSubflow 在给定变量表上执行子流程，跟踪记录以 prefix 为前缀合并到当前执行
+func (x *Execution) RunSubflow(ctx context.Context, def *Definition, vars *Variables, prefix string) error {
+	sub := &Execution{
+		Vars:   vars,
+		UserID: x.UserID,
+		engine: x.engine,
+		prefix: x.prefix + prefix,
+		trace:  x.trace,
+		mu:     x.mu,
+	}
+	return sub.run(ctx, def)
+}
+
+// nodeCompletion 节点完成后回传给调度循环的结果
+type nodeCompletion struct {
+	nodeID string
+	result *NodeResult
+	err    error
+}
+
+// run 按依赖关系调度节点，互不依赖的节点并行执行
+func (x *Execution) run(ctx context.Context, def *Definition) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	nodes := make(map[string]*Node, len(def.Nodes))
+	for i := range def.Nodes {
+		nodes[def.Nodes[i].ID] = &def.Nodes[i]
+	}
+
+	incoming := make(map[string][]int)
+	outgoing := make(map[string][]int)
+	for i, edge := range def.Edges {
+		incoming[edge.To] = append(incoming[edge.To], i)
+		outgoing[edge.From] = append(outgoing[edge.From], i)
+	}
+
+	// 边状态：0 未决，1 激活，-1 未激活
+	edgeState := make([]int, len(def.Edges))
+	pending := make(map[string]int, len(nodes))
+	for id := range nodes {
+		pending[id] = len(incoming[id])
+	}
+
+	maxParallel := def.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = defaultMaxParallel
+	}
+	sem := make(chan struct{}, maxParallel)
+	done := make(chan nodeCompletion)
+	running := 0
+	remaining := len(nodes)
+
+	var launch func(id string)
+	var resolveEdges func(id string, active func(edge Edge) bool)
+
+	launch = func(id string) {
+		running++
+		go func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result, err := x.executeNode(ctx, nodes[id])
+			done <- nodeCompletion{nodeID: id, result: result, err: err}
+		}()
+	}
+
+	// resolveEdges 决定节点出边的状态，并启动或跳过依赖已全部就绪的下游节点
+	resolveEdges = func(id string, active func(edge Edge) bool) {
+		remaining--
+		for _, idx := range outgoing[id] {
+			edge := def.Edges[idx]
+			if active(edge) {
+				edgeState[idx] = 1
+			} else {
+				edgeState[idx] = -1
+			}
+
+			pending[edge.To]--
+			if pending[edge.To] > 0 {
+				continue
+			}
+
+			activated := false
+			for _, in := range incoming[edge.To] {
+				if edgeState[in] == 1 {
+					activated = true
+					break
+				}
+			}
+			if activated {
+				launch(edge.To)
+			} else {
+				x.record(NodeTrace{
+					NodeID:    x.prefix + edge.To,
+					NodeType:  nodes[edge.To].Type,
+					Status:    StatusSkipped,
+					StartedAt: time.Now(),
+				})
+				resolveEdges(edge.To, func(Edge) bool { return false })
+			}
+		}
+	}
+
+	for _, node := range def.Nodes {
+		if pending[node.ID] == 0 {
+			launch(node.ID)
+		}
+	}
+
+	var runErr error
+	for running > 0 {
+		completion := <-done
+		running--
+
+		if runErr != nil {
+			continue
+		}
+
+		if completion.err != nil {
+			if !hasErrorEdge(def.Edges, outgoing[completion.nodeID]) {
+				runErr = fmt.Errorf("node %q failed: %w", completion.nodeID, completion.err)
+				cancel()
+				continue
+			}
+			// 存在错误边时由错误分支处理失败
+			x.Vars.Set(completion.nodeID, map[string]interface{}{"error": completion.err.Error()})
+			resolveEdges(completion.nodeID, func(edge Edge) bool { return edge.Handle == HandleError })
+			continue
+		}
+
+		handle := completion.result.Handle
+		resolveEdges(completion.nodeID, func(edge Edge) bool {
+			return edge.Handle == HandleDefault || (edge.Handle != HandleError && edge.Handle == handle)
+		})
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	if remaining != 0 {
+		return fmt.Errorf("workflow stopped with %d unresolved node(s)", remaining)
+	}
+	return ctx.Err()
+}
+
+// executeNode 执行单个节点并记录跟踪信息
+func (x *Execution) executeNode(ctx context.Context, node *Node) (*NodeResult, error) {
+	trace := NodeTrace{
+		NodeID:    x.prefix + node.ID,
+		NodeType:  node.Type,
+		StartedAt: time.Now(),
+	}
+	if input, ok := x.Vars.Resolve(node.Config).(map[string]interface{}); ok {
+		trace.Input = input
+	}
+
+	result, err := x.dispatch(ctx, node)
+	trace.DurationMs = time.Since(trace.StartedAt).Milliseconds()
+
+	if err != nil {
+		trace.Status = StatusFailed
+		trace.Error = err.Error()
+		x.record(trace)
+		return nil, err
+	}
+
+	output, err := normalizeOutput(result.Output)
+	if err != nil {
+		trace.Status = StatusFailed
+		trace.Error = err.Error()
+		x.record(trace)
+		return nil, err
+	}
+	result.Output = output
+	x.Vars.Set(node.ID, output)
+
+	trace.Status = StatusSuccess
+	trace.Handle = result.Handle
+	trace.Output = output
+	x.record(trace)
+
+	return result, nil
+}
+
+// dispatch 调用节点类型对应的执行器
+func (x *Execution) dispatch(ctx context.Context, node *Node) (result *NodeResult, err error) {
+	executor, ok := x.engine.executors[node.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownNodeType, node.Type)
+	}
+
+	// 单个节点的异常不应导致整个服务崩溃
+	defer func() {
+		if r := recover(); r != nil {
+			x.engine.logger.Error("Workflow node panicked", zap.String("node_id", node.ID), zap.Any("panic", r))
+			err = fmt.Errorf("node panicked: %v", r)
+		}
+	}()
+
+	result, err = executor.Execute(ctx, x, node)
+	if err == nil && result == nil {
+		result = &NodeResult{}
+	}
+	return result, err
+}
+
+// record 追加跟踪记录
+func (x *Execution) record(trace NodeTrace) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	*x.trace = append(*x.trace, trace)
+}
+
+// hasErrorEdge 判断出边中是否存在错误边
+func hasErrorEdge(edges []Edge, indexes []int) bool {
+	for _, idx := range indexes {
+		if edges[idx].Handle == HandleError {
+			return true
+		}
+	}
+	return false
+}
+
+// applyInputs 校验必填输入并填充默认值
+func applyInputs(defs []InputDefinition, inputs map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(inputs))
+	for key, value := range inputs {
+		resolved[key] = value
+	}
+
+	for _, def := range defs {
+		if _, ok := resolved[def.Name]; ok {
+			continue
+		}
+		if def.Default != nil {
+			resolved[def.Name] = def.Default
+			continue
+		}
+		if def.Required {
+			return nil, fmt.Errorf("%w: %s", ErrMissingInput, def.Name)
+		}
+	}
+
+	normalized, err := normalizeOutput(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// resolveOutputs 解析工作流输出
+func resolveOutputs(def *Definition, vars *Variables) map[string]interface{} {
+	if len(def.Outputs) == 0 {
+		return vars.Snapshot()
+	}
+
+	outputs := make(map[string]interface{}, len(def.Outputs))
+	for name, expr := range def.Outputs {
+		outputs[name] = vars.Resolve(expr)
+	}
+	return outputs
+}
+
+// normalizeOutput 将节点输出转换为通用JSON结构，便于按路径引用
+func normalizeOutput(output map[string]interface{}) (map[string]interface{}, error) {
+	if output == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, fmt.Errorf("node output is not serializable: %w", err)
+	}
+
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
@@ -0,0 +1,353 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+)
+
+// stubNode 创建由测试执行器处理的节点，action 决定执行器的行为
+func stubNode(id, action string) Node {
+	return Node{ID: id, Type: NodeTemplate, Config: map[string]interface{}{"template": action}}
+}
+
+// branchNode 创建测试条件节点，handle 为激活的出口
+func branchNode(id, handle string) Node {
+	return Node{ID: id, Type: NodeCondition, Config: map[string]interface{}{"conditions": handle}}
+}
+
+// stubEngine 创建引擎并以测试执行器替换模板与条件节点：
+// 模板节点按 template 配置成功、失败或 panic，条件节点返回 conditions 配置的出口
+func stubEngine(t *testing.T) *Engine {
+	t.Helper()
+	engine := NewEngine(Dependencies{})
+	engine.RegisterExecutor(NodeTemplate, ExecutorFunc(func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		switch action, _ := node.Config["template"].(string); action {
+		case "fail":
+			return nil, errors.New("stub failure")
+		case "panic":
+			panic("stub panic")
+		default:
+			return &NodeResult{Output: map[string]interface{}{"value": action}}, nil
+		}
+	}))
+	engine.RegisterExecutor(NodeCondition, ExecutorFunc(func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		handle, _ := node.Config["conditions"].(string)
+		return &NodeResult{Handle: handle}, nil
+	}))
+	return engine
+}
+
+// traceStatuses 按节点ID汇总执行记录的状态
+func traceStatuses(result *Result) map[string]string {
+	statuses := make(map[string]string, len(result.Trace))
+	for _, trace := range result.Trace {
+		statuses[trace.NodeID] = trace.Status
+	}
+	return statuses
+}
+
+func TestExecutionRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		def        *Definition
+		wantStatus string
+		wantError  string
+		wantNodes  map[string]string // 节点ID -> 状态，未列出的节点不应执行
+	}{
+		{
+			name: "error edge handles failure",
+			def: &Definition{
+				Nodes: []Node{stubNode("a", "fail"), stubNode("recover", "ok"), stubNode("next", "ok")},
+				Edges: []Edge{
+					{From: "a", To: "recover", Handle: HandleError},
+					{From: "a", To: "next"},
+				},
+			},
+			wantStatus: StatusSuccess,
+			wantNodes:  map[string]string{"a": StatusFailed, "recover": StatusSuccess, "next": StatusSkipped},
+		},
+		{
+			name: "failure without error edge stops the workflow",
+			def: &Definition{
+				Nodes: []Node{stubNode("a", "fail"), stubNode("b", "ok")},
+				Edges: []Edge{{From: "a", To: "b"}},
+			},
+			wantStatus: StatusFailed,
+			wantError:  `node "a" failed`,
+			wantNodes:  map[string]string{"a": StatusFailed},
+		},
+		{
+			name: "inactive branch is skipped downstream",
+			def: &Definition{
+				Nodes: []Node{
+					branchNode("if", HandleTrue),
+					stubNode("yes", "ok"),
+					stubNode("no", "ok"),
+					stubNode("no_next", "ok"),
+					stubNode("join", "ok"),
+				},
+				Edges: []Edge{
+					{From: "if", To: "yes", Handle: HandleTrue},
+					{From: "if", To: "no", Handle: HandleFalse},
+					{From: "no", To: "no_next"},
+					{From: "yes", To: "join"},
+					{From: "no_next", To: "join"},
+				},
+			},
+			wantStatus: StatusSuccess,
+			wantNodes: map[string]string{
+				"if":      StatusSuccess,
+				"yes":     StatusSuccess,
+				"no":      StatusSkipped,
+				"no_next": StatusSkipped,
+				"join":    StatusSuccess,
+			},
+		},
+		{
+			name: "node with only inactive inputs is skipped",
+			def: &Definition{
+				Nodes: []Node{branchNode("if", HandleFalse), stubNode("yes", "ok")},
+				Edges: []Edge{{From: "if", To: "yes", Handle: HandleTrue}},
+			},
+			wantStatus: StatusSuccess,
+			wantNodes:  map[string]string{"if": StatusSuccess, "yes": StatusSkipped},
+		},
+		{
+			name: "panic is recovered as node failure",
+			def: &Definition{
+				Nodes: []Node{stubNode("a", "panic"), stubNode("b", "ok")},
+				Edges: []Edge{{From: "a", To: "b"}},
+			},
+			wantStatus: StatusFailed,
+			wantError:  "node panicked: stub panic",
+			wantNodes:  map[string]string{"a": StatusFailed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := stubEngine(t).Execute(context.Background(), tt.def, nil, uuid.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.wantStatus {
+				t.Fatalf("status = %s (%s), want %s", result.Status, result.Error, tt.wantStatus)
+			}
+			if !strings.Contains(result.Error, tt.wantError) {
+				t.Errorf("error = %q, want %q", result.Error, tt.wantError)
+			}
+
+			statuses := traceStatuses(result)
+			if len(statuses) != len(tt.wantNodes) {
+				t.Errorf("trace = %v, want %v", statuses, tt.wantNodes)
+			}
+			for id, want := range tt.wantNodes {
+				if statuses[id] != want {
+					t.Errorf("node %s status = %q, want %q", id, statuses[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestExecutionRunErrorEdgeOutput(t *testing.T) {
+	def := &Definition{
+		Nodes:   []Node{stubNode("a", "fail"), stubNode("recover", "ok")},
+		Edges:   []Edge{{From: "a", To: "recover", Handle: HandleError}},
+		Outputs: map[string]string{"error": "{{a.error}}"},
+	}
+	result, err := stubEngine(t).Execute(context.Background(), def, nil, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Outputs["error"]; got != "stub failure" {
+		t.Errorf("error output = %v, want stub failure", got)
+	}
+}
+
+func TestExecutionRunMaxParallel(t *testing.T) {
+	const maxParallel = 2
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	engine := NewEngine(Dependencies{})
+	engine.RegisterExecutor(NodeTemplate, ExecutorFunc(func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return &NodeResult{}, nil
+	}))
+
+	def := &Definition{MaxParallel: maxParallel}
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		def.Nodes = append(def.Nodes, stubNode(id, "ok"))
+	}
+	result, err := engine.Execute(context.Background(), def, nil, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusSuccess {
+		t.Fatalf("status = %s (%s), want %s", result.Status, result.Error, StatusSuccess)
+	}
+	if peak > maxParallel {
+		t.Errorf("%d nodes ran concurrently, want at most %d", peak, maxParallel)
+	}
+	if peak < 2 {
+		t.Errorf("independent nodes ran sequentially, peak concurrency %d", peak)
+	}
+}
+
+func TestExecutionRunSubflow(t *testing.T) {
+	body := map[string]interface{}{
+		"nodes": []interface{}{
+			map[string]interface{}{"id": "render", "type": "template", "config": map[string]interface{}{"template": "item-{{loop.item}}"}},
+		},
+		"outputs": map[string]interface{}{"text": "{{render.text}}"},
+	}
+	def := &Definition{
+		Inputs: []InputDefinition{{Name: "items", Required: true}},
+		Nodes: []Node{{ID: "each", Type: NodeLoop, Config: map[string]interface{}{
+			"items": "{{inputs.items}}",
+			"body":  body,
+		}}},
+		Outputs: map[string]string{"results": "{{each.results}}"},
+	}
+
+	result, err := NewEngine(Dependencies{}).Execute(context.Background(), def, map[string]interface{}{
+		"items": []interface{}{"x", "y"},
+	}, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusSuccess {
+		t.Fatalf("status = %s (%s), want %s", result.Status, result.Error, StatusSuccess)
+	}
+
+	results, _ := result.Outputs["results"].([]interface{})
+	if len(results) != 2 {
+		t.Fatalf("results = %v, want 2 iterations", result.Outputs["results"])
+	}
+	for i, want := range []string{"item-x", "item-y"} {
+		output, _ := results[i].(map[string]interface{})
+		if output["text"] != want {
+			t.Errorf("results[%d] = %v, want text %s", i, results[i], want)
+		}
+	}
+
+	// 子流程的节点以迭代前缀记录在同一跟踪中
+	statuses := traceStatuses(result)
+	for _, id := range []string{"each", "each[0].render", "each[1].render"} {
+		if statuses[id] != StatusSuccess {
+			t.Errorf("node %s status = %q, want %q", id, statuses[id], StatusSuccess)
+		}
+	}
+}
+
+func TestExecutionRunSubflowFailure(t *testing.T) {
+	engine := stubEngine(t)
+	def := &Definition{
+		Nodes: []Node{{ID: "each", Type: NodeLoop, Config: map[string]interface{}{
+			"items": []interface{}{1},
+			"body": map[string]interface{}{
+				"nodes": []interface{}{
+					map[string]interface{}{"id": "inner", "type": "template", "config": map[string]interface{}{"template": "fail"}},
+				},
+			},
+		}}},
+	}
+	result, err := engine.Execute(context.Background(), def, nil, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusFailed {
+		t.Fatalf("status = %s, want %s", result.Status, StatusFailed)
+	}
+	if !strings.Contains(result.Error, "iteration 0") || !strings.Contains(result.Error, "stub failure") {
+		t.Errorf("error = %q, want iteration failure", result.Error)
+	}
+	if statuses := traceStatuses(result); statuses["each[0].inner"] != StatusFailed {
+		t.Errorf("inner node status = %q, want %q", statuses["each[0].inner"], StatusFailed)
+	}
+}
+
+func TestExecuteMissingInput(t *testing.T) {
+	def := &Definition{
+		Inputs: []InputDefinition{{Name: "query", Required: true}},
+		Nodes:  []Node{stubNode("a", "ok")},
+	}
+	if _, err := stubEngine(t).Execute(context.Background(), def, nil, uuid.New()); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("Execute error = %v, want %v", err, ErrMissingInput)
+	}
+}
+
+func TestWorkflowAgentRunsEngine(t *testing.T) {
+	registry := agent.NewToolRegistry()
+	if err := registry.RegisterCalculatorTool(); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.RegisterWebSearchTool(func(ctx context.Context, query string) ([]map[string]interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	factory := agent.NewAgentFactory(registry, nil)
+	factory.SetWorkflowRunner(NewEngine(Dependencies{}).AgentRunner())
+
+	definition := map[string]interface{}{
+		"inputs": []interface{}{map[string]interface{}{"name": "name", "required": true}},
+		"nodes": []interface{}{
+			map[string]interface{}{"id": "greet", "type": "template", "config": map[string]interface{}{"template": "hello {{inputs.name}}"}},
+		},
+		"outputs": map[string]interface{}{"text": "{{greet.text}}"},
+	}
+	wf, err := factory.CreateAgent(context.Background(), "default_workflow", "wf", "", "test-model", "test", map[string]interface{}{
+		"workflow": definition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var run *agent.Tool
+	for i := range wf.Tools {
+		if wf.Tools[i].Name == "run_workflow" {
+			run = &wf.Tools[i]
+		}
+	}
+	if run == nil {
+		t.Fatal("workflow agent has no run_workflow tool")
+	}
+
+	ctx := agent.WithUserID(context.Background(), uuid.New())
+	output, err := run.Handler(ctx, map[string]interface{}{"inputs": map[string]interface{}{"name": "lyss"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := output.(map[string]interface{})["text"]; got != "hello lyss" {
+		t.Errorf("workflow output = %v, want hello lyss", got)
+	}
+
+	// 工作流失败以工具错误返回给智能体
+	if _, err := run.Handler(ctx, map[string]interface{}{}); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("run without inputs error = %v, want %v", err, ErrMissingInput)
+	}
+
+	// 未提供工作流定义时无法创建
+	if _, err := factory.CreateAgent(context.Background(), "default_workflow", "wf", "", "test-model", "test", nil); err == nil {
+		t.Error("CreateAgent without workflow succeeded, want error")
+	}
+}
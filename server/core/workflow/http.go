@@ -0,0 +1,113 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// maxHTTPRedirects HTTP节点跟随重定向的次数上限
+const maxHTTPRedirects = 5
+
+var ErrHTTPDestinationDenied = errors.New("http destination is not allowed")
+
+// deniedNetworks HTTP节点禁止访问的地址段：本机、内网、链路本地（含云元数据地址）、保留及组播地址
+var deniedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// newGuardedHTTPClient 创建只能访问公网地址的HTTP客户端。检查在建立连接时针对解析后的IP进行，
+// 每次重定向都会重新连接并检查，域名解析结果变化也无法绕过
+func newGuardedHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: denyInternalAddress,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil, // 经代理访问时无法检查目标地址
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: checkHTTPRedirect,
+	}
+}
+
+// checkHTTPRedirect 限制重定向次数，并拒绝跳转到非HTTP协议
+func checkHTTPRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxHTTPRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxHTTPRedirects)
+	}
+	return validateHTTPURL(req.URL)
+}
+
+// validateHTTPURL 只允许带主机名的 http/https 地址
+func validateHTTPURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrHTTPDestinationDenied, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", ErrHTTPDestinationDenied)
+	}
+	return nil
+}
+
+// denyInternalAddress 在连接前检查实际要连接的IP
+func denyInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isDeniedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrHTTPDestinationDenied, host)
+	}
+	return nil
+}
+
+// isDeniedIP 判断IP是否属于禁止访问的地址段
+func isDeniedIP(ip net.IP) bool {
+	for _, network := range deniedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mustParseCIDRs 解析固定的地址段列表
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
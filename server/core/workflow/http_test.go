@@ -0,0 +1,192 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+)
+
+func TestIsDeniedIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isDeniedIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("isDeniedIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenyInternalAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:443", true},
+		{"169.254.169.254:80", true},
+		{"localhost:80", true}, // 未解析的主机名不允许直接连接
+		{"8.8.8.8:443", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := denyInternalAddress("tcp", tt.address, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("denyInternalAddress(%s) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrHTTPDestinationDenied) {
+				t.Errorf("error = %v, want %v", err, ErrHTTPDestinationDenied)
+			}
+		})
+	}
+}
+
+func TestCheckHTTPRedirect(t *testing.T) {
+	request := func(raw string) *http.Request {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Request{URL: u}
+	}
+	via := func(n int) []*http.Request {
+		return make([]*http.Request, n)
+	}
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		via     []*http.Request
+		wantErr bool
+	}{
+		{"https redirect", request("https://example.com/next"), via(1), false},
+		{"last allowed redirect", request("http://example.com/next"), via(maxHTTPRedirects - 1), false},
+		{"too many redirects", request("http://example.com/next"), via(maxHTTPRedirects), true},
+		{"file scheme", request("file:///etc/passwd"), via(1), true},
+		{"gopher scheme", request("gopher://example.com/"), via(1), true},
+		{"missing host", request("http:///path"), via(1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkHTTPRedirect(tt.req, tt.via); (err != nil) != tt.wantErr {
+				t.Errorf("checkHTTPRedirect error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// newLoopbackServer 启动只监听本机的测试服务器，hits 记录收到的请求数
+func newLoopbackServer(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.Write([]byte("internal"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGuardedHTTPClientDeniesLoopback(t *testing.T) {
+	var hits int32
+	server := newLoopbackServer(t, &hits)
+
+	client := newGuardedHTTPClient(defaultHTTPTimeout)
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("request to loopback succeeded, want denied")
+	}
+	if !errors.Is(err, ErrHTTPDestinationDenied) {
+		t.Errorf("error = %v, want %v", err, ErrHTTPDestinationDenied)
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("loopback server received %d request(s), want 0", n)
+	}
+}
+
+func TestCheckHTTPRedirectRejectsScheme(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// 使用普通连接访问测试服务器，只检查重定向策略
+	client := &http.Client{CheckRedirect: checkHTTPRedirect}
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("redirect to file scheme followed, want error")
+	}
+	if !errors.Is(err, ErrHTTPDestinationDenied) {
+		t.Errorf("error = %v, want %v", err, ErrHTTPDestinationDenied)
+	}
+}
+
+func TestNodesCannotReachLoopback(t *testing.T) {
+	var hits int32
+	server := newLoopbackServer(t, &hits)
+
+	registry := agent.NewToolRegistry()
+	if err := registry.RegisterHttpRequestTool(); err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine(Dependencies{ToolRegistry: registry})
+
+	tests := []struct {
+		name string
+		node Node
+	}{
+		{"http node", Node{ID: "fetch", Type: NodeHTTP, Config: map[string]interface{}{
+			"url": server.URL,
+		}}},
+		{"http_request tool", Node{ID: "fetch", Type: NodeTool, Config: map[string]interface{}{
+			"tool":      "http_request",
+			"arguments": map[string]interface{}{"url": server.URL, "method": "GET"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := &Definition{Nodes: []Node{tt.node}}
+			result, err := engine.Execute(context.Background(), def, nil, uuid.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != StatusFailed {
+				t.Fatalf("status = %s, want %s", result.Status, StatusFailed)
+			}
+			if !strings.Contains(result.Error, ErrHTTPDestinationDenied.Error()) {
+				t.Errorf("error = %q, want destination denied", result.Error)
+			}
+		})
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("loopback server received %d request(s), want 0", n)
+	}
+}
@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/models"
+	"github.com/zhuiye8/Lyss/server/pkg/llm"
+	"gorm.io/gorm"
+)
+
+// ModelConfigLLMClient 根据模型配置ID调用 pkg/llm 适配器
+type ModelConfigLLMClient struct {
+	db        *gorm.DB
+	decrypter agent.Decrypter
+}
+
+// NewModelConfigLLMClient 创建基于模型配置的大模型客户端
+func NewModelConfigLLMClient(db *gorm.DB, decrypter agent.Decrypter) *ModelConfigLLMClient {
+	return &ModelConfigLLMClient{
+		db:        db,
+		decrypter: decrypter,
+	}
+}
+
+// Complete 执行一次对话补全
+func (c *ModelConfigLLMClient) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	configID, err := uuid.Parse(req.ModelConfigID)
+	if err != nil {
+		return "", fmt.Errorf("invalid model_config_id: %w", err)
+	}
+
+	var modelConfig models.ModelConfig
+	if err := c.db.WithContext(ctx).Preload("Model").First(&modelConfig, "id = ?", configID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("model config %s not found", configID)
+		}
+		return "", err
+	}
+	if modelConfig.Model == nil {
+		return "", fmt.Errorf("model config %s has no model", configID)
+	}
+
+	providerConfig, err := agent.ResolveProviderConfig(&modelConfig, c.decrypter)
+	if err != nil {
+		return "", err
+	}
+
+	adapter, err := llm.CreateAdapter(modelConfig.Model.Provider, providerConfig)
+	if err != nil {
+		return "", err
+	}
+
+	var messages []llm.Message
+	if req.SystemPrompt != "" {
+		messages = append(messages, llm.Message{Role: "system", Content: req.SystemPrompt})
+	}
+	messages = append(messages, llm.Message{Role: "user", Content: req.Prompt})
+
+	chatReq := llm.ChatRequest{
+		ConfigID:  configID,
+		Messages:  messages,
+		MaxTokens: req.MaxTokens,
+	}
+	if req.Temperature != nil {
+		chatReq.Temperature = float32(*req.Temperature)
+	} else if modelConfig.Parameters.Temperature != nil {
+		chatReq.Temperature = *modelConfig.Parameters.Temperature
+	}
+	if chatReq.MaxTokens == 0 && modelConfig.Parameters.MaxTokens != nil {
+		chatReq.MaxTokens = *modelConfig.Parameters.MaxTokens
+	}
+
+	resp, err := adapter.Chat(ctx, chatReq)
+	if err != nil {
+		return "", err
+	}
+	return resp.Message.Content, nil
+}
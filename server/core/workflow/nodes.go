@@ -0,0 +1,629 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+)
+
+// codeResultMarker 代码节点在标准输出中标记返回值的前缀
+const codeResultMarker = "__WORKFLOW_RESULT__"
+
+// 默认限制
+const (
+	defaultLoopMaxIterations = 100
+	defaultHTTPTimeout       = 30 * time.Second
+	maxHTTPResponseBytes     = 1 << 20
+	defaultRetrievalTopK     = 5
+)
+
+var ErrDependencyUnavailable = errors.New("workflow node dependency is not configured")
+
+// LLMRequest 大模型节点的调用请求
+type LLMRequest struct {
+	ModelConfigID string
+	SystemPrompt  string
+	Prompt        string
+	Temperature   *float64
+	MaxTokens     int
+}
+
+// LLMClient 大模型调用接口
+type LLMClient interface {
+	Complete(ctx context.Context, req LLMRequest) (string, error)
+}
+
+// AuthorizeFunc 检查执行工作流的用户能否使用指定资源
+type AuthorizeFunc func(resourceID, userID uuid.UUID) error
+
+// Dependencies 内置节点依赖的外部组件，未提供的组件对应节点执行时报错
+type Dependencies struct {
+	LLM                    LLMClient
+	AgentLoader            *agent.AgentLoader
+	ToolRegistry           *agent.ToolRegistry
+	Retriever              kb.Retriever
+	Sandbox                *agent.Sandbox
+	HTTPClient             *http.Client  // 为空时使用禁止访问内网地址的客户端
+	AuthorizeAgent         AuthorizeFunc // 智能体节点的权限检查
+	AuthorizeKnowledgeBase AuthorizeFunc // 知识库检索节点的权限检查
+}
+
+// ExecutorFunc 将函数适配为节点执行器
+type ExecutorFunc func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error)
+
+// Execute 实现 NodeExecutor 接口
+func (f ExecutorFunc) Execute(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+	return f(ctx, exec, node)
+}
+
+// registerBuiltinExecutors 注册内置节点执行器
+func registerBuiltinExecutors(e *Engine, deps Dependencies) {
+	if deps.HTTPClient == nil {
+		deps.HTTPClient = newGuardedHTTPClient(defaultHTTPTimeout)
+	}
+
+	e.RegisterExecutor(NodeLLM, ExecutorFunc(llmNode(deps.LLM)))
+	e.RegisterExecutor(NodeAgent, ExecutorFunc(agentNode(deps.AgentLoader, deps.AuthorizeAgent)))
+	e.RegisterExecutor(NodeTool, ExecutorFunc(toolNode(deps.ToolRegistry, deps.HTTPClient)))
+	e.RegisterExecutor(NodeKnowledge, ExecutorFunc(knowledgeNode(deps.Retriever, deps.AuthorizeKnowledgeBase)))
+	e.RegisterExecutor(NodeCondition, ExecutorFunc(conditionNode))
+	e.RegisterExecutor(NodeLoop, ExecutorFunc(loopNode))
+	e.RegisterExecutor(NodeCode, ExecutorFunc(codeNode(deps.Sandbox)))
+	e.RegisterExecutor(NodeHTTP, ExecutorFunc(httpNode(deps.HTTPClient)))
+	e.RegisterExecutor(NodeTemplate, ExecutorFunc(templateNode))
+	e.RegisterExecutor(NodeVariable, ExecutorFunc(variableNode))
+}
+
+// llmNode 调用大模型，输出 {text}
+func llmNode(client LLMClient) ExecutorFunc {
+	return func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		if client == nil {
+			return nil, fmt.Errorf("%w: llm", ErrDependencyUnavailable)
+		}
+
+		req := LLMRequest{
+			ModelConfigID: configString(exec, node, "model_config_id"),
+			SystemPrompt:  configString(exec, node, "system_prompt"),
+			Prompt:        configString(exec, node, "prompt"),
+			MaxTokens:     configInt(exec, node, "max_tokens", 0),
+		}
+		if temperature, ok := toFloat(exec.Vars.Resolve(node.Config["temperature"])); ok {
+			req.Temperature = &temperature
+		}
+
+		text, err := client.Complete(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return &NodeResult{Output: map[string]interface{}{"text": text}}, nil
+	}
+}
+
+// agentNode 调用执行用户有权访问的智能体，输出 {text}
+func agentNode(loader *agent.AgentLoader, authorize AuthorizeFunc) ExecutorFunc {
+	return func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		if loader == nil {
+			return nil, fmt.Errorf("%w: agent loader", ErrDependencyUnavailable)
+		}
+		if authorize == nil {
+			return nil, fmt.Errorf("%w: agent authorization", ErrDependencyUnavailable)
+		}
+
+		agentID, err := uuid.Parse(configString(exec, node, "agent_id"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid agent_id: %w", err)
+		}
+		if err := authorize(agentID, exec.UserID); err != nil {
+			return nil, fmt.Errorf("agent %s: %w", agentID, err)
+		}
+
+		runtimeAgent, err := loader.Load(ctx, agentID)
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := runtimeAgent.Chat(ctx, configString(exec, node, "input"))
+		if err != nil {
+			return nil, err
+		}
+		return &NodeResult{Output: map[string]interface{}{"text": text}}, nil
+	}
+}
+
+// toolNode 调用注册的工具，输出 {result}。联网工具与HTTP节点使用同一个受限客户端
+func toolNode(registry *agent.ToolRegistry, client *http.Client) ExecutorFunc {
+	return func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		if registry == nil {
+			return nil, fmt.Errorf("%w: tool registry", ErrDependencyUnavailable)
+		}
+
+		tool, err := registry.GetTool(configString(exec, node, "tool"))
+		if err != nil {
+			return nil, err
+		}
+
+		args, _ := exec.Vars.Resolve(node.Config["arguments"]).(map[string]interface{})
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+
+		// 工作流无人值守运行，需要审批的调用直接拒绝
+		if tool.RequiresApproval != nil && tool.RequiresApproval(args) {
+			return nil, fmt.Errorf("tool %q requires approval and cannot run inside a workflow", tool.Name)
+		}
+
+		result, err := tool.Handler(agent.WithHTTPClient(ctx, client), args)
+		if err != nil {
+			return nil, err
+		}
+		return &NodeResult{Output: map[string]interface{}{"result": result}}, nil
+	}
+}
+
+// knowledgeNode 检索执行用户有权访问的知识库，输出 {results, context}
+func knowledgeNode(retriever kb.Retriever, authorize AuthorizeFunc) ExecutorFunc {
+	return func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		if retriever == nil {
+			return nil, fmt.Errorf("%w: retriever", ErrDependencyUnavailable)
+		}
+		if authorize == nil {
+			return nil, fmt.Errorf("%w: knowledge base authorization", ErrDependencyUnavailable)
+		}
+
+		knowledgeBaseID, err := uuid.Parse(configString(exec, node, "knowledge_base_id"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid knowledge_base_id: %w", err)
+		}
+		if err := authorize(knowledgeBaseID, exec.UserID); err != nil {
+			return nil, fmt.Errorf("knowledge base %s: %w", knowledgeBaseID, err)
+		}
+
+		filters, _ := exec.Vars.Resolve(node.Config["filters"]).(map[string]interface{})
+		filter, err := kb.ParseFilter(filters)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := retriever.Retrieve(ctx, kb.QueryRequest{
+			KnowledgeBaseID: knowledgeBaseID.String(),
+			Query:           configString(exec, node, "query"),
+			TopK:            configInt(exec, node, "top_k", defaultRetrievalTopK),
+			Filter:          filter,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		contents := make([]string, 0, len(resp.Results))
+		for _, result := range resp.Results {
+			contents = append(contents, result.Content)
+		}
+
+		return &NodeResult{Output: map[string]interface{}{
+			"results": resp.Results,
+			"context": strings.Join(contents, "\n\n"),
+		}}, nil
+	}
+}
+
+// conditionNode 条件分支，输出 {result}，并激活 true/false 出口
+func conditionNode(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+	conditions, ok := node.Config["conditions"].([]interface{})
+	if !ok {
+		return nil, errors.New("conditions must be a list")
+	}
+
+	useOr := strings.EqualFold(configString(exec, node, "logic"), "or")
+	result := !useOr
+	for i, raw := range conditions {
+		cond, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("condition %d must be an object", i)
+		}
+
+		operator, _ := cond["operator"].(string)
+		matched, err := evaluateCondition(exec.Vars.Resolve(cond["left"]), operator, exec.Vars.Resolve(cond["right"]))
+		if err != nil {
+			return nil, fmt.Errorf("condition %d: %w", i, err)
+		}
+
+		if useOr && matched {
+			result = true
+			break
+		}
+		if !useOr && !matched {
+			result = false
+			break
+		}
+	}
+
+	handle := HandleFalse
+	if result {
+		handle = HandleTrue
+	}
+	return &NodeResult{Output: map[string]interface{}{"result": result}, Handle: handle}, nil
+}
+
+// evaluateCondition 计算单个比较条件
+func evaluateCondition(left interface{}, operator string, right interface{}) (bool, error) {
+	switch operator {
+	case "eq", "==":
+		return valuesEqual(left, right), nil
+	case "ne", "!=":
+		return !valuesEqual(left, right), nil
+	case "gt", "gte", "lt", "lte", ">", ">=", "<", "<=":
+		l, lok := toFloat(left)
+		r, rok := toFloat(right)
+		if !lok || !rok {
+			return false, fmt.Errorf("operator %q requires numeric operands", operator)
+		}
+		switch operator {
+		case "gt", ">":
+			return l > r, nil
+		case "gte", ">=":
+			return l >= r, nil
+		case "lt", "<":
+			return l < r, nil
+		default:
+			return l <= r, nil
+		}
+	case "contains":
+		return containsValue(left, right), nil
+	case "not_contains":
+		return !containsValue(left, right), nil
+	case "in":
+		return containsValue(right, left), nil
+	case "empty":
+		return isEmpty(left), nil
+	case "not_empty":
+		return !isEmpty(left), nil
+	default:
+		return false, fmt.Errorf("unknown operator %q", operator)
+	}
+}
+
+// loopNode 对列表中的每一项执行子流程，输出 {results, count}
+func loopNode(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+	body, err := loopBody(node)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := exec.Vars.Resolve(node.Config["items"]).([]interface{})
+	if !ok {
+		return nil, errors.New("loop items must resolve to a list")
+	}
+
+	maxIterations := configInt(exec, node, "max_iterations", defaultLoopMaxIterations)
+	if len(items) > maxIterations {
+		return nil, fmt.Errorf("loop has %d items, exceeding max_iterations %d", len(items), maxIterations)
+	}
+
+	parallel, _ := node.Config["parallel"].(bool)
+	concurrency := 1
+	if parallel {
+		concurrency = defaultMaxParallel
+	}
+
+	results := make([]interface{}, len(items))
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			vars := exec.Vars.Clone()
+			vars.Set(loopNamespace, map[string]interface{}{"item": item, "index": i})
+
+			prefix := fmt.Sprintf("%s[%d].", node.ID, i)
+			if err := exec.RunSubflow(ctx, body, vars, prefix); err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = iterationOutput(body, vars)
+		}(i, item)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %w", i, err)
+		}
+	}
+
+	return &NodeResult{Output: map[string]interface{}{
+		"results": results,
+		"count":   len(items),
+	}}, nil
+}
+
+// iterationOutput 收集单次迭代的输出，未定义 outputs 时返回子流程各节点的输出
+func iterationOutput(body *Definition, vars *Variables) interface{} {
+	if len(body.Outputs) > 0 {
+		return resolveOutputs(body, vars)
+	}
+
+	outputs := make(map[string]interface{}, len(body.Nodes))
+	for _, n := range body.Nodes {
+		if value, ok := vars.Get(n.ID); ok {
+			outputs[n.ID] = value
+		}
+	}
+	return outputs
+}
+
+// codeNode 在沙箱中执行代码，代码需定义 main(inputs) 函数，输出 {result, stdout, stderr}
+func codeNode(sandbox *agent.Sandbox) ExecutorFunc {
+	return func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		if sandbox == nil {
+			return nil, fmt.Errorf("%w: sandbox", ErrDependencyUnavailable)
+		}
+
+		inputs := exec.Vars.Resolve(node.Config["inputs"])
+		if inputs == nil {
+			inputs = map[string]interface{}{}
+		}
+		inputsJSON, err := json.Marshal(inputs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode code inputs: %w", err)
+		}
+		// 再次编码为字符串字面量，Python 与 JavaScript 均可直接使用
+		inputsLiteral, _ := json.Marshal(string(inputsJSON))
+
+		language := agent.SandboxLanguage(configString(exec, node, "language"))
+		code, _ := node.Config["code"].(string)
+
+		var script string
+		switch language {
+		case agent.SandboxPython:
+			script = fmt.Sprintf("import json as __wf_json\ninputs = __wf_json.loads(%s)\n%s\nprint(%q + __wf_json.dumps(main(inputs)))\n",
+				inputsLiteral, code, codeResultMarker)
+		case agent.SandboxJavaScript:
+			script = fmt.Sprintf("var inputs = JSON.parse(%s);\n%s\nconsole.log(%q + JSON.stringify(main(inputs)));\n",
+				inputsLiteral, code, codeResultMarker)
+		default:
+			return nil, fmt.Errorf("%w: %s", agent.ErrSandboxUnsupportedLanguage, language)
+		}
+
+		result, err := sandbox.Run(ctx, agent.SandboxRequest{Language: language, Code: script})
+		if err != nil {
+			return nil, err
+		}
+		if result.TimedOut {
+			return nil, errors.New("code execution timed out")
+		}
+		if result.ExitCode != 0 {
+			return nil, fmt.Errorf("code exited with status %d: %s", result.ExitCode, result.Stderr)
+		}
+
+		var value interface{}
+		var stdout []string
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			if strings.HasPrefix(line, codeResultMarker) {
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, codeResultMarker)), &value); err != nil {
+					return nil, fmt.Errorf("failed to decode code result: %w", err)
+				}
+				continue
+			}
+			stdout = append(stdout, line)
+		}
+
+		return &NodeResult{Output: map[string]interface{}{
+			"result": value,
+			"stdout": strings.TrimRight(strings.Join(stdout, "\n"), "\n"),
+			"stderr": result.Stderr,
+		}}, nil
+	}
+}
+
+// httpNode 发送HTTP请求，只允许 http/https，输出 {status_code, headers, body}
+func httpNode(client *http.Client) ExecutorFunc {
+	return func(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+		method := strings.ToUpper(configString(exec, node, "method"))
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		var body io.Reader
+		switch payload := exec.Vars.Resolve(node.Config["body"]).(type) {
+		case nil:
+		case string:
+			body = strings.NewReader(payload)
+		default:
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode request body: %w", err)
+			}
+			body = bytes.NewReader(data)
+		}
+
+		timeout := time.Duration(configInt(exec, node, "timeout_seconds", 0)) * time.Second
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		target, err := url.Parse(configString(exec, node, "url"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid url: %w", err)
+		}
+		if err := validateHTTPURL(target); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if headers, ok := exec.Vars.Resolve(node.Config["headers"]).(map[string]interface{}); ok {
+			for key, value := range headers {
+				req.Header.Set(key, stringify(value))
+			}
+		}
+		if body != nil && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseBytes))
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed interface{} = string(data)
+		var decoded interface{}
+		if json.Unmarshal(data, &decoded) == nil {
+			parsed = decoded
+		}
+
+		headers := make(map[string]interface{}, len(resp.Header))
+		for key := range resp.Header {
+			headers[key] = resp.Header.Get(key)
+		}
+
+		output := map[string]interface{}{
+			"status_code": resp.StatusCode,
+			"headers":     headers,
+			"body":        parsed,
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("http request failed with status %d", resp.StatusCode)
+		}
+		return &NodeResult{Output: output}, nil
+	}
+}
+
+// templateNode 渲染文本模板，输出 {text}
+func templateNode(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+	return &NodeResult{Output: map[string]interface{}{
+		"text": configString(exec, node, "template"),
+	}}, nil
+}
+
+// variableNode 将值写入 vars 命名空间，输出赋值结果
+func variableNode(ctx context.Context, exec *Execution, node *Node) (*NodeResult, error) {
+	assignments, ok := node.Config["assignments"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("assignments must be an object")
+	}
+
+	output := make(map[string]interface{}, len(assignments))
+	for name, raw := range assignments {
+		value := exec.Vars.Resolve(raw)
+		exec.Vars.Assign(name, value)
+		output[name] = value
+	}
+	return &NodeResult{Output: output}, nil
+}
+
+// configString 读取并渲染字符串配置
+func configString(exec *Execution, node *Node, key string) string {
+	value, ok := node.Config[key]
+	if !ok {
+		return ""
+	}
+	return stringify(exec.Vars.Resolve(value))
+}
+
+// configInt 读取整数配置
+func configInt(exec *Execution, node *Node, key string, fallback int) int {
+	value, ok := toFloat(exec.Vars.Resolve(node.Config[key]))
+	if !ok {
+		return fallback
+	}
+	return int(value)
+}
+
+// toFloat 尝试将值转换为数字
+func toFloat(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case float64:
+		return typed, true
+	case float32:
+		return float64(typed), true
+	case int:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case json.Number:
+		f, err := typed.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// valuesEqual 比较两个值，数字按数值比较
+func valuesEqual(left, right interface{}) bool {
+	if l, ok := toFloat(left); ok {
+		if r, ok := toFloat(right); ok {
+			return l == r
+		}
+	}
+	if reflect.DeepEqual(left, right) {
+		return true
+	}
+	return stringify(left) == stringify(right)
+}
+
+// containsValue 判断容器（字符串、列表或对象键）是否包含目标
+func containsValue(container, target interface{}) bool {
+	switch typed := container.(type) {
+	case string:
+		return strings.Contains(typed, stringify(target))
+	case []interface{}:
+		for _, item := range typed {
+			if valuesEqual(item, target) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		_, ok := typed[stringify(target)]
+		return ok
+	}
+	return false
+}
+
+// isEmpty 判断值是否为空
+func isEmpty(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(typed) == ""
+	case []interface{}:
+		return len(typed) == 0
+	case map[string]interface{}:
+		return len(typed) == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 变量的保留命名空间
+const (
+	inputsNamespace = "inputs" // 工作流输入
+	varsNamespace   = "vars"   // 变量赋值节点写入的变量
+	loopNamespace   = "loop"   // 循环子流程中的当前项与索引
+)
+
+// templatePattern 匹配 {{ path.to.value }} 形式的变量引用
+var templatePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_\-\.\[\]]+)\s*\}\}`)
+
+// Variables 节点之间传递的变量，按命名空间存储：inputs、vars 以及各节点ID
+type Variables struct {
+	data map[string]interface{}
+	mu   sync.RWMutex
+}
+
+// NewVariables 创建变量表
+func NewVariables(inputs map[string]interface{}) *Variables {
+	if inputs == nil {
+		inputs = map[string]interface{}{}
+	}
+	return &Variables{
+		data: map[string]interface{}{
+			inputsNamespace: inputs,
+			varsNamespace:   map[string]interface{}{},
+		},
+	}
+}
+
+// Set 设置命名空间的值，通常为节点输出
+func (v *Variables) Set(namespace string, value interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.data[namespace] = value
+}
+
+// Assign 写入 vars 命名空间中的变量
+func (v *Variables) Assign(name string, value interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vars, _ := v.data[varsNamespace].(map[string]interface{})
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	vars[name] = value
+	v.data[varsNamespace] = vars
+}
+
+// Get 按路径读取变量，路径形如 node.field.items[0].name
+func (v *Variables) Get(path string) (interface{}, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var current interface{} = v.data
+	for _, part := range splitPath(path) {
+		switch container := current.(type) {
+		case map[string]interface{}:
+			value, ok := container[part]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, false
+			}
+			current = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// Clone 复制变量表，循环节点的每次迭代在副本上执行
+func (v *Variables) Clone() *Variables {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	data := make(map[string]interface{}, len(v.data))
+	for key, value := range v.data {
+		data[key] = value
+	}
+	if vars, ok := data[varsNamespace].(map[string]interface{}); ok {
+		copied := make(map[string]interface{}, len(vars))
+		for key, value := range vars {
+			copied[key] = value
+		}
+		data[varsNamespace] = copied
+	}
+	return &Variables{data: data}
+}
+
+// Snapshot 返回当前变量的浅拷贝
+func (v *Variables) Snapshot() map[string]interface{} {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	snapshot := make(map[string]interface{}, len(v.data))
+	for key, value := range v.data {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// Render 渲染字符串模板，未定义的变量渲染为空字符串
+func (v *Variables) Render(template string) string {
+	return templatePattern.ReplaceAllStringFunc(template, func(match string) string {
+		path := templatePattern.FindStringSubmatch(match)[1]
+		value, ok := v.Get(path)
+		if !ok {
+			return ""
+		}
+		return stringify(value)
+	})
+}
+
+// Resolve 递归解析配置值中的变量引用，整个字符串仅为单个引用时保留原始类型
+func (v *Variables) Resolve(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		if match := templatePattern.FindStringSubmatch(typed); match != nil && match[0] == strings.TrimSpace(typed) {
+			resolved, _ := v.Get(match[1])
+			return resolved
+		}
+		return v.Render(typed)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			resolved[key] = v.Resolve(item)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, len(typed))
+		for i, item := range typed {
+			resolved[i] = v.Resolve(item)
+		}
+		return resolved
+	default:
+		return value
+	}
+}
+
+// splitPath 将 a.b[0].c 拆分为 [a b 0 c]
+func splitPath(path string) []string {
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+
+	var parts []string
+	for _, part := range strings.Split(path, ".") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// stringify 将变量值转换为字符串，复合类型输出JSON
+func stringify(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(typed)
+		if err != nil {
+			return fmt.Sprint(typed)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
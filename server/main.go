@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -19,12 +20,20 @@ import (
 	"gorm.io/gorm/logger"
 
 	"github.com/zhuiye8/Lyss/server/api/agent"
+	"github.com/zhuiye8/Lyss/server/api/agentrun"
+	"github.com/zhuiye8/Lyss/server/api/attachment"
 	"github.com/zhuiye8/Lyss/server/api/application"
 	"github.com/zhuiye8/Lyss/server/api/auth"
 	"github.com/zhuiye8/Lyss/server/api/config"
 	"github.com/zhuiye8/Lyss/server/api/conversation"
+	"github.com/zhuiye8/Lyss/server/api/feedback"
+	"github.com/zhuiye8/Lyss/server/api/knowledge"
+	"github.com/zhuiye8/Lyss/server/api/memory"
 	"github.com/zhuiye8/Lyss/server/api/model"
 	"github.com/zhuiye8/Lyss/server/api/project"
+	coreAgent "github.com/zhuiye8/Lyss/server/core/agent"
+	"github.com/zhuiye8/Lyss/server/core/kb"
+	"github.com/zhuiye8/Lyss/server/core/workflow"
 	"github.com/zhuiye8/Lyss/server/models"
 	authPkg "github.com/zhuiye8/Lyss/server/pkg/auth"
 	"github.com/zhuiye8/Lyss/server/pkg/encryption"
@@ -71,12 +80,20 @@ func main() {
 			&models.ModelConfig{},
 			&models.Model{},
 			&models.KnowledgeBase{},
+			&models.Document{},
+			&models.Chunk{},
 			&models.Project{},
 			&models.Application{},
 			&models.Agent{},
 			&models.AgentKnowledgeBase{},
 			&models.Conversation{},
 			&models.Message{},
+			&models.MessageFeedback{},
+			&models.Attachment{},
+			&models.ToolApproval{},
+			&models.AgentRun{},
+			&models.AgentRunStep{},
+			&models.UserMemory{},
 			&models.Log{},
 			&models.SystemMetric{},
 		); err != nil {
@@ -105,6 +122,66 @@ func main() {
 		zap.L().Fatal("Failed to initialize encryption service", zap.Error(err))
 	}
 
+	// 初始化智能体加载器
+	agentLoader := coreAgent.NewAgentLoader(db, encryptionService, coreAgent.DefaultToolRegistry)
+
+	// 初始化知识库模块，向量数据库不可用时退回内存实现
+	vectorDBConfig := kb.VectorDBConfig{
+		Provider:       viper.GetString("vector_db.provider"),
+		MilvusHost:     viper.GetString("milvus.host"),
+		MilvusPort:     viper.GetInt("milvus.port"),
+		MilvusUsername: viper.GetString("milvus.user"),
+		MilvusPassword: viper.GetString("milvus.password"),
+
+		PgVectorIndex:          viper.GetString("pgvector.index"),
+		PgVectorMetric:         viper.GetString("pgvector.metric"),
+		PgVectorLists:          viper.GetInt("pgvector.lists"),
+		PgVectorProbes:         viper.GetInt("pgvector.probes"),
+		PgVectorM:              viper.GetInt("pgvector.m"),
+		PgVectorEfConstruction: viper.GetInt("pgvector.ef_construction"),
+		PgVectorEfSearch:       viper.GetInt("pgvector.ef_search"),
+	}
+	if err := kb.Initialize(context.Background(), db, vectorDBConfig); err != nil {
+		zap.L().Warn("Failed to initialize vector database, falling back to in-memory store", zap.Error(err))
+		if err := kb.Initialize(context.Background(), db, kb.VectorDBConfig{Provider: kb.VectorDBProviderMemory}); err != nil {
+			zap.L().Fatal("Failed to initialize knowledge base module", zap.Error(err))
+		}
+	}
+
+	// 注册模型表中的向量模型，知识库集合的维度依赖已注册的模型
+	embeddingLoader := kb.NewEmbeddingModelLoader(db, kb.GetEmbeddingManager(), encryptionService, kb.EmbeddingLoaderConfig{
+		RefreshInterval: viper.GetDuration("embedding.refresh_interval"),
+		CacheSize:       viper.GetInt("embedding.cache_size"),
+	})
+	if err := embeddingLoader.Load(context.Background()); err != nil {
+		zap.L().Warn("Failed to load embedding models", zap.Error(err))
+	}
+	embeddingLoader.Start()
+	defer embeddingLoader.Stop()
+
+	// 注册需要外部服务的重排器，知识库通过 reranker 字段选用
+	if baseURL := viper.GetString("knowledge.rerank.cross_encoder.base_url"); baseURL != "" {
+		kb.GetRerankerManager().RegisterReranker(kb.RerankerCrossEncoder, kb.NewCrossEncoderReranker(kb.CrossEncoderConfig{
+			BaseURL: baseURL,
+			APIKey:  viper.GetString("knowledge.rerank.cross_encoder.api_key"),
+			Model:   viper.GetString("knowledge.rerank.cross_encoder.model"),
+			Timeout: viper.GetDuration("knowledge.rerank.cross_encoder.timeout"),
+		}))
+	}
+	if configID := viper.GetString("knowledge.rerank.llm.model_config_id"); configID != "" {
+		modelConfigID, err := uuid.Parse(configID)
+		if err != nil {
+			zap.L().Warn("Invalid model config ID for LLM reranker", zap.String("model_config_id", configID), zap.Error(err))
+		} else {
+			kb.GetRerankerManager().RegisterReranker(kb.RerankerLLM,
+				kb.NewLLMReranker(db, encryptionService, modelConfigID, viper.GetInt("knowledge.rerank.llm.concurrency")))
+		}
+	}
+
+	// 初始化用户长期记忆
+	userMemoryStore := kb.NewUserMemoryStore(db, kb.GetVectorDB(), kb.GetEmbeddingManager(), viper.GetString("memory.embedding_model"))
+	agentLoader.SetLongTermMemoryProvider(userMemoryStore)
+
 	// 初始化认证中间件
 	authMiddleware := middleware.NewAuthMiddleware(jwtManager)
 
@@ -116,10 +193,6 @@ func main() {
 	projectService := project.NewService(db)
 	projectHandler := project.NewHandler(projectService, authMiddleware)
 
-	// 初始化应用服务
-	applicationService := application.NewService(db)
-	applicationHandler := application.NewHandler(applicationService, authMiddleware)
-
 	// 初始化配置服务
 	configService := config.NewService(db)
 	configHandler := config.NewHandler(configService, authMiddleware)
@@ -129,13 +202,90 @@ func main() {
 	modelHandler := model.NewHandler(modelService, authMiddleware)
 
 	// 初始化智能体服务
-	agentService := agent.NewService(db)
+	orchestrator := coreAgent.NewOrchestrator(agentLoader, coreAgent.OrchestratorConfig{
+		MaxDepth:      viper.GetInt("orchestration.max_depth"),
+		MaxHandoffs:   viper.GetInt("orchestration.max_handoffs"),
+		MaxAgentCalls: viper.GetInt("orchestration.max_agent_calls"),
+	})
+	agentService := agent.NewService(db, orchestrator)
 	agentHandler := agent.NewHandler(agentService, authMiddleware)
 
 	// 初始化对话服务
-	conversationService := conversation.NewService(db)
+	conversationSearcher := conversation.NewSearcher(db, conversation.SearchConfig{
+		TextSearchConfig: viper.GetString("search.text_search_config"),
+		VectorDB:         kb.GetVectorDB(),
+		Embedding:        kb.GetEmbeddingManager(),
+		EmbeddingModel:   viper.GetString("search.embedding_model"),
+		IndexInterval:    viper.GetDuration("search.index_interval"),
+	})
+	if err := conversationSearcher.EnsureIndex(); err != nil {
+		zap.L().Warn("Failed to prepare conversation search index", zap.Error(err))
+	}
+	conversationSearcher.Start()
+	defer conversationSearcher.Stop()
+	conversationTitler := conversation.NewTitler(db, configService, workflow.NewModelConfigLLMClient(db, encryptionService),
+		viper.GetDuration("conversation.auto_title_timeout"))
+	defer conversationTitler.Stop()
+	attachmentService := attachment.NewService(db, configService, attachment.Config{
+		LocalDir:       viper.GetString("attachments.local_dir"),
+		MaxSize:        viper.GetInt64("attachments.max_size"),
+		MaxParsedRunes: viper.GetInt("attachments.max_parsed_chars"),
+	})
+	attachmentHandler := attachment.NewHandler(attachmentService, authMiddleware)
+	runManager := coreAgent.NewRunManager(db, agentLoader, coreAgent.RunManagerConfig{
+		Workers: viper.GetInt("agent_runs.workers"),
+	})
+	runManager.Start()
+	defer runManager.Stop()
+	conversationService := conversation.NewService(db, agentLoader, conversationSearcher, conversationTitler, attachmentService, runManager)
 	conversationHandler := conversation.NewHandler(conversationService, authMiddleware)
 
+	// 初始化智能体运行服务
+	agentRunService := agentrun.NewService(db, runManager)
+	agentRunHandler := agentrun.NewHandler(agentRunService, authMiddleware)
+
+	// 初始化长期记忆服务
+	memoryService := memory.NewService(db, userMemoryStore)
+	memoryHandler := memory.NewHandler(memoryService, authMiddleware)
+
+	// 初始化反馈审核服务
+	feedbackService := feedback.NewService(db)
+	feedbackHandler := feedback.NewHandler(feedbackService, authMiddleware)
+
+	// 初始化知识库服务，docx/xlsx 按配置限制解压后的大小
+	maxExtractedSize := viper.GetInt64("knowledge.max_extracted_size")
+	kb.GetDocumentProcessorRegistry().Register(kb.TypeWord, kb.NewWordProcessor(maxExtractedSize))
+	kb.GetDocumentProcessorRegistry().Register(kb.TypeExcel, kb.NewExcelProcessor(maxExtractedSize))
+	knowledgeService := knowledge.NewService(db, kb.GetKnowledgeBaseManager(), kb.GetRetriever(), knowledge.Config{
+		DefaultEmbeddingModel: viper.GetString("knowledge.embedding_model"),
+		MaxDocumentSize:       viper.GetInt64("knowledge.max_document_size"),
+	})
+	if err := knowledgeService.Restore(context.Background()); err != nil {
+		zap.L().Warn("Failed to restore knowledge bases", zap.Error(err))
+	}
+	kb.GetKnowledgeBaseManager().StartIngestion(kb.IngestionConfig{
+		Workers:            viper.GetInt("knowledge.ingestion.workers"),
+		EmbeddingBatchSize: viper.GetInt("knowledge.ingestion.embedding_batch_size"),
+		EmbeddingRetries:   viper.GetInt("knowledge.ingestion.embedding_retries"),
+		MaxAttempts:        viper.GetInt("knowledge.ingestion.max_attempts"),
+	})
+	defer kb.GetKnowledgeBaseManager().StopIngestion()
+	knowledgeHandler := knowledge.NewHandler(knowledgeService, authMiddleware)
+
+	// 初始化应用服务，工作流节点复用智能体与知识库服务的权限检查
+	workflowEngine := workflow.NewEngine(workflow.Dependencies{
+		LLM:                    workflow.NewModelConfigLLMClient(db, encryptionService),
+		AgentLoader:            agentLoader,
+		ToolRegistry:           coreAgent.DefaultToolRegistry,
+		Retriever:              kb.GetRetriever(),
+		Sandbox:                coreAgent.NewSandbox(coreAgent.DefaultSandboxConfig()),
+		AuthorizeAgent:         agentService.Authorize,
+		AuthorizeKnowledgeBase: knowledgeService.Authorize,
+	})
+	coreAgent.DefaultAgentFactory.SetWorkflowRunner(workflowEngine.AgentRunner())
+	applicationService := application.NewService(db, workflowEngine)
+	applicationHandler := application.NewHandler(applicationService, authMiddleware)
+
 	// 初始化仪表盘服务
 	dashboardService := dashboard.NewService(db)
 	dashboardHandler := dashboard.NewHandler(dashboardService, authMiddleware)
@@ -194,6 +344,11 @@ func main() {
 		// 注册新增的处理器路由
 		agentHandler.RegisterRoutes(api)
 		conversationHandler.RegisterRoutes(api)
+		agentRunHandler.RegisterRoutes(api)
+		memoryHandler.RegisterRoutes(api)
+		feedbackHandler.RegisterRoutes(api)
+		attachmentHandler.RegisterRoutes(api)
+		knowledgeHandler.RegisterRoutes(api)
 		dashboardHandler.RegisterRoutes(api)
 	}
 
@@ -243,6 +398,32 @@ func loadConfig() {
 	viper.SetDefault("app.version", "0.1.0")
 	viper.SetDefault("app.env", "development")
 	viper.SetDefault("app.port", "8080")
+	viper.SetDefault("agent_runs.workers", 4)
+	viper.SetDefault("orchestration.max_depth", 2)
+	viper.SetDefault("orchestration.max_handoffs", 5)
+	viper.SetDefault("orchestration.max_agent_calls", 20)
+	viper.SetDefault("memory.embedding_model", "mock")
+	viper.SetDefault("search.text_search_config", "chinese")
+	viper.SetDefault("search.embedding_model", "mock")
+	viper.SetDefault("search.index_interval", "30s")
+	viper.SetDefault("conversation.auto_title_timeout", "30s")
+	viper.SetDefault("attachments.local_dir", "./data/attachments")
+	viper.SetDefault("attachments.max_size", 20<<20)
+	viper.SetDefault("attachments.max_parsed_chars", 50000)
+	viper.SetDefault("vector_db.provider", "milvus")
+	viper.SetDefault("pgvector.index", "hnsw")
+	viper.SetDefault("pgvector.metric", "cosine")
+	viper.SetDefault("embedding.refresh_interval", "1m")
+	viper.SetDefault("embedding.cache_size", 10000)
+	viper.SetDefault("knowledge.embedding_model", "mock")
+	viper.SetDefault("knowledge.max_document_size", 20<<20)
+	viper.SetDefault("knowledge.max_extracted_size", kb.DefaultMaxZipEntrySize)
+	viper.SetDefault("knowledge.ingestion.workers", 2)
+	viper.SetDefault("knowledge.ingestion.embedding_batch_size", 32)
+	viper.SetDefault("knowledge.ingestion.embedding_retries", 3)
+	viper.SetDefault("knowledge.ingestion.max_attempts", 3)
+	viper.SetDefault("knowledge.rerank.cross_encoder.timeout", "30s")
+	viper.SetDefault("knowledge.rerank.llm.concurrency", 4)
 	viper.SetDefault("jwt.expiry", "24h")
 	viper.SetDefault("jwt.refresh_expiry", "168h") // 7天
 	viper.SetDefault("jwt.secret", "your-secret-key-change-me")
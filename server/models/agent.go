@@ -8,6 +8,8 @@ import (
 	"gorm.io/gorm"
 	"database/sql/driver"
 	"errors"
+	"fmt"
+	"strings"
 )
 
 // Agent 智能体模型
@@ -21,6 +23,7 @@ type Agent struct {
 	Tools           JSONMap        `gorm:"type:jsonb" json:"tools"`
 	Variables       JSONMap        `gorm:"type:jsonb" json:"variables"`
 	MaxHistoryLength int           `gorm:"default:10" json:"max_history_length"`
+	MemoryConfig    JSONMap        `gorm:"type:jsonb" json:"memory_config"` // 记忆策略及阈值，见 core/agent.MemoryConfig
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
@@ -59,6 +62,77 @@ func (j JSONMap) Value() (driver.Value, error) {
 	return json.Marshal(j)
 }
 
+// StringArray 是Postgres text[] 字段的辅助类型
+type StringArray []string
+
+// Scan 实现 sql.Scanner 接口，解析 {a,"b c"} 形式的数组字面量
+func (a *StringArray) Scan(value interface{}) error {
+	var literal string
+	switch v := value.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		literal = string(v)
+	case string:
+		literal = v
+	default:
+		return errors.New("无法将数据库值转换为StringArray")
+	}
+
+	if len(literal) < 2 || literal[0] != '{' || literal[len(literal)-1] != '}' {
+		return fmt.Errorf("无效的数组字面量: %q", literal)
+	}
+	body := literal[1 : len(literal)-1]
+	result := StringArray{}
+	for i := 0; i < len(body); {
+		var element strings.Builder
+		quoted := body[i] == '"'
+		if quoted {
+			i++
+			for i < len(body) && body[i] != '"' {
+				if body[i] == '\\' && i+1 < len(body) {
+					i++
+				}
+				element.WriteByte(body[i])
+				i++
+			}
+			i++ // 结束引号
+		} else {
+			for i < len(body) && body[i] != ',' {
+				element.WriteByte(body[i])
+				i++
+			}
+		}
+		// 未加引号的 NULL 表示空元素
+		if quoted || element.String() != "NULL" {
+			result = append(result, element.String())
+		}
+		i++ // 分隔逗号
+	}
+	*a = result
+	return nil
+}
+
+// Value 实现 driver.Valuer 接口，元素一律加引号输出
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	var literal strings.Builder
+	literal.WriteByte('{')
+	for i, element := range a {
+		if i > 0 {
+			literal.WriteByte(',')
+		}
+		literal.WriteByte('"')
+		literal.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(element))
+		literal.WriteByte('"')
+	}
+	literal.WriteByte('}')
+	return literal.String(), nil
+}
+
 // AgentKnowledgeBase 智能体与知识库的多对多关联
 type AgentKnowledgeBase struct {
 	AgentID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"agent_id"`
@@ -83,6 +157,7 @@ type AgentResponse struct {
 	Tools            JSONMap        `json:"tools"`
 	Variables        JSONMap        `json:"variables"`
 	MaxHistoryLength int            `json:"max_history_length"`
+	MemoryConfig     JSONMap        `json:"memory_config,omitempty"`
 	KnowledgeBaseIDs []uuid.UUID    `json:"knowledge_base_ids,omitempty"`
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
@@ -100,6 +175,7 @@ func (a *Agent) ToResponse(knowledgeBaseIDs []uuid.UUID) AgentResponse {
 		Tools:            a.Tools,
 		Variables:        a.Variables,
 		MaxHistoryLength: a.MaxHistoryLength,
+		MemoryConfig:     a.MemoryConfig,
 		KnowledgeBaseIDs: knowledgeBaseIDs,
 		CreatedAt:        a.CreatedAt,
 		UpdatedAt:        a.UpdatedAt,
@@ -115,6 +191,7 @@ type CreateAgentRequest struct {
 	Tools            JSONMap   `json:"tools"`
 	Variables        JSONMap   `json:"variables"`
 	MaxHistoryLength int       `json:"max_history_length"`
+	MemoryConfig     JSONMap   `json:"memory_config"`
 	KnowledgeBaseIDs []uuid.UUID `json:"knowledge_base_ids"`
 }
 
@@ -127,6 +204,7 @@ type UpdateAgentRequest struct {
 	Tools            JSONMap   `json:"tools"`
 	Variables        JSONMap   `json:"variables"`
 	MaxHistoryLength int       `json:"max_history_length"`
+	MemoryConfig     JSONMap   `json:"memory_config"`
 	KnowledgeBaseIDs []uuid.UUID `json:"knowledge_base_ids"`
 }
 
@@ -144,3 +222,11 @@ type UpdateToolsRequest struct {
 type TestAgentRequest struct {
 	Message string `json:"message" binding:"required"`
 } 
+
+// OrchestrateAgentsRequest 多智能体编排请求
+type OrchestrateAgentsRequest struct {
+	EntryAgentID uuid.UUID `json:"entry_agent_id" binding:"required"`
+	Mode         string    `json:"mode" binding:"omitempty,oneof=supervisor handoff"`
+	Input        string    `json:"input" binding:"required"`
+	Scratchpad   JSONMap   `json:"scratchpad"`
+}
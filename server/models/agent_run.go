@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AgentRun 智能体的一次异步运行
+type AgentRun struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	AgentID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"agent_id"`
+	ConversationID *uuid.UUID `gorm:"type:uuid;index" json:"conversation_id,omitempty"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Status         string     `gorm:"type:varchar(20);not null;default:'queued';index" json:"status"` // queued, running, waiting_approval, completed, failed, cancelled
+	Input          string     `gorm:"type:text;not null" json:"input"`
+	Output         string     `gorm:"type:text" json:"output"`
+	Error          string     `gorm:"type:text" json:"error"`
+	State          JSONMap    `gorm:"type:jsonb" json:"-"` // 序列化的消息历史与待恢复的审批决定
+	ApprovalID     *uuid.UUID `gorm:"type:uuid" json:"approval_id,omitempty"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	LeaseID        *uuid.UUID `gorm:"type:uuid" json:"-"` // 每次认领生成的租约，只有持有当前租约的工作协程能更新运行
+	HeartbeatAt    *time.Time `json:"-"`                  // 执行中的工作协程定期刷新，超时视为已失联
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// 关联
+	Agent *Agent         `gorm:"foreignKey:AgentID" json:"-"`
+	Steps []AgentRunStep `gorm:"foreignKey:RunID" json:"-"`
+}
+
+// AgentRunStep 运行过程中持久化的运行时事件
+type AgentRunStep struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	RunID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_agent_run_steps_run_seq" json:"run_id"`
+	Sequence  int       `gorm:"not null;uniqueIndex:idx_agent_run_steps_run_seq" json:"sequence"`
+	Type      string    `gorm:"type:varchar(32);not null" json:"type"` // 对应 AgentRuntimeEventType
+	Data      JSONMap   `gorm:"type:jsonb" json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BeforeCreate 在创建运行前生成UUID
+func (r *AgentRun) BeforeCreate(tx *gorm.DB) error {
+	r.ID = uuid.New()
+	return nil
+}
+
+// BeforeCreate 在创建运行步骤前生成UUID
+func (s *AgentRunStep) BeforeCreate(tx *gorm.DB) error {
+	s.ID = uuid.New()
+	return nil
+}
+
+// AgentRunResponse 是返回给客户端的运行数据结构
+type AgentRunResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	AgentID        uuid.UUID  `json:"agent_id"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	Status         string     `json:"status"`
+	Input          string     `json:"input"`
+	Output         string     `json:"output,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	ApprovalID     *uuid.UUID `json:"approval_id,omitempty"`
+	Attempts       int        `json:"attempts"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ToResponse 将运行模型转换为对外响应
+func (r *AgentRun) ToResponse() AgentRunResponse {
+	return AgentRunResponse{
+		ID:             r.ID,
+		AgentID:        r.AgentID,
+		ConversationID: r.ConversationID,
+		Status:         r.Status,
+		Input:          r.Input,
+		Output:         r.Output,
+		Error:          r.Error,
+		ApprovalID:     r.ApprovalID,
+		Attempts:       r.Attempts,
+		StartedAt:      r.StartedAt,
+		FinishedAt:     r.FinishedAt,
+		CreatedAt:      r.CreatedAt,
+		UpdatedAt:      r.UpdatedAt,
+	}
+}
+
+// StartAgentRunRequest 启动运行请求
+type StartAgentRunRequest struct {
+	Input          string     `json:"input" binding:"required"`
+	ConversationID *uuid.UUID `json:"conversation_id"`
+}
+
+// ResumeAgentRunRequest 恢复等待审批的运行请求
+type ResumeAgentRunRequest struct {
+	Decisions []ToolApprovalDecision `json:"decisions" binding:"required,min=1,dive"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStringArrayScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    StringArray
+		wantErr bool
+	}{
+		{"nil", nil, nil, false},
+		{"empty", "{}", StringArray{}, false},
+		{"plain elements", []byte("{tea,green}"), StringArray{"tea", "green"}, false},
+		{"quoted elements", `{"a b","x\"y","c\\d",""}`, StringArray{"a b", `x"y`, `c\d`, ""}, false},
+		{"null element", `{a,NULL,"NULL"}`, StringArray{"a", "NULL"}, false},
+		{"not an array", "tea", nil, true},
+		{"unsupported type", 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got StringArray
+			err := got.Scan(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Scan(%v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringArrayValueRoundTrip(t *testing.T) {
+	if v, err := StringArray(nil).Value(); err != nil || v != nil {
+		t.Errorf("nil Value = %v, %v, want nil", v, err)
+	}
+
+	tags := StringArray{"tea", "a,b", `x"y`, `c\d`, "NULL", ""}
+	v, err := tags.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got StringArray
+	if err := got.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tags) {
+		t.Errorf("round trip = %#v, want %#v", got, tags)
+	}
+}
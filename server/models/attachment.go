@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 附件类型
+const (
+	AttachmentKindImage    = "image"    // 图片，发送给支持视觉的模型
+	AttachmentKindDocument = "document" // 文档，可解析为文本注入上下文
+	AttachmentKindFile     = "file"     // 其他文件，仅以文件名告知模型
+)
+
+// Attachment 用户上传的附件，消息通过元数据中的附件ID引用
+type Attachment struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ConversationID  *uuid.UUID `gorm:"type:uuid;index" json:"conversation_id,omitempty"` // 首次随消息发送时绑定
+	FileName        string     `gorm:"type:varchar(255);not null" json:"file_name"`
+	ContentType     string     `gorm:"type:varchar(128);not null" json:"content_type"`
+	Size            int64      `gorm:"not null" json:"size"`
+	Kind            string     `gorm:"type:varchar(16);not null" json:"kind"` // image, document, file
+	StorageProvider string     `gorm:"type:varchar(16);not null" json:"-"`    // 上传时使用的存储，读取时按此选择
+	StorageKey      string     `gorm:"type:varchar(512);not null" json:"-"`
+	ExtractedText   string     `gorm:"type:text" json:"-"` // 上传时选择解析的文档内容
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// 关联
+	User *User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// BeforeCreate 在创建附件前生成UUID
+func (a *Attachment) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// AttachmentResponse 是返回给客户端的附件数据结构
+type AttachmentResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	FileName       string     `json:"file_name"`
+	ContentType    string     `json:"content_type"`
+	Size           int64      `json:"size"`
+	Kind           string     `json:"kind"`
+	Parsed         bool       `json:"parsed"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ToResponse 将附件转换为对外响应
+func (a *Attachment) ToResponse() AttachmentResponse {
+	return AttachmentResponse{
+		ID:             a.ID,
+		ConversationID: a.ConversationID,
+		FileName:       a.FileName,
+		ContentType:    a.ContentType,
+		Size:           a.Size,
+		Kind:           a.Kind,
+		Parsed:         a.ExtractedText != "",
+		CreatedAt:      a.CreatedAt,
+	}
+}
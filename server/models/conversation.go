@@ -9,15 +9,22 @@ import (
 
 // Conversation 对话模型
 type Conversation struct {
-	ID        uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
-	Title     string         `gorm:"type:varchar(255)" json:"title"`
-	AgentID   uuid.UUID      `gorm:"type:uuid;not null" json:"agent_id"`
-	UserID    uuid.UUID      `gorm:"type:uuid;not null" json:"user_id"`
-	Status    string         `gorm:"type:varchar(16);default:'active'" json:"status"` // active, archived
-	Metadata  JSONMap        `gorm:"type:jsonb" json:"metadata"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID       uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	Title    string    `gorm:"type:varchar(255)" json:"title"`
+	AgentID  uuid.UUID `gorm:"type:uuid;not null" json:"agent_id"`
+	UserID   uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
+	Status   string    `gorm:"type:varchar(16);default:'active'" json:"status"` // active, archived
+	Metadata JSONMap   `gorm:"type:jsonb" json:"metadata"`
+	// 首轮对话后自动生成的主题标签与检测到的语言
+	Tags         StringArray `gorm:"type:text[]" json:"tags"`
+	Language     string      `gorm:"type:varchar(16)" json:"language"`
+	AutoTitledAt *time.Time  `json:"auto_titled_at,omitempty"`
+	DefaultTitle bool        `gorm:"not null;default:false" json:"-"` // 标题为创建时按时间生成的默认标题，可被自动命名替换
+	// 当前分支的最后一条消息，历史沿该消息的祖先读取
+	ActiveLeafID *uuid.UUID     `gorm:"type:uuid" json:"active_leaf_id,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// 关联
 	Agent    *Agent    `gorm:"foreignKey:AgentID" json:"-"`
@@ -27,14 +34,17 @@ type Conversation struct {
 
 // Message 消息模型
 type Message struct {
-	ID             uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	ConversationID uuid.UUID `gorm:"type:uuid;not null" json:"conversation_id"`
-	Role           string    `gorm:"type:varchar(16);not null" json:"role"` // user, assistant, system
-	Content        string    `gorm:"type:text;not null" json:"content"`
-	Tokens         int       `json:"tokens"`
-	Feedback       string    `gorm:"type:varchar(16)" json:"feedback"` // positive, negative, null
-	Metadata       JSONMap   `gorm:"type:jsonb" json:"metadata"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	ConversationID uuid.UUID  `gorm:"type:uuid;not null" json:"conversation_id"`
+	ParentID       *uuid.UUID `gorm:"type:uuid;index" json:"parent_id,omitempty"`  // 上一条消息，编辑或重新生成时同一父消息下产生多个分支
+	Sequence       int64      `gorm:"type:bigserial;autoIncrement;index" json:"-"` // 数据库分配的全局递增序号，用于稳定排序
+	Role           string     `gorm:"type:varchar(16);not null" json:"role"`       // user, assistant, system, tool
+	Content        string     `gorm:"type:text;not null" json:"content"`
+	Tokens         int        `json:"tokens"`
+	Feedback       string     `gorm:"type:varchar(16)" json:"feedback"` // positive, negative, null
+	Metadata       JSONMap    `gorm:"type:jsonb" json:"metadata"`
+	IndexedAt      *time.Time `gorm:"index" json:"-"` // 写入语义检索向量索引的时间，为空表示尚未索引
+	CreatedAt      time.Time  `json:"created_at"`
 
 	// 关联
 	Conversation *Conversation `gorm:"foreignKey:ConversationID" json:"-"`
@@ -54,27 +64,41 @@ func (m *Message) BeforeCreate(tx *gorm.DB) error {
 
 // ConversationResponse 是返回给客户端的对话数据结构
 type ConversationResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Title     string    `json:"title"`
-	AgentID   uuid.UUID `json:"agent_id"`
-	AgentName string    `json:"agent_name,omitempty"`
-	UserID    uuid.UUID `json:"user_id"`
-	Status    string    `json:"status"`
-	Metadata  JSONMap   `json:"metadata"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           uuid.UUID  `json:"id"`
+	Title        string     `json:"title"`
+	AgentID      uuid.UUID  `json:"agent_id"`
+	AgentName    string     `json:"agent_name,omitempty"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Status       string     `json:"status"`
+	Metadata     JSONMap    `json:"metadata"`
+	Tags         []string   `json:"tags"`
+	Language     string     `json:"language,omitempty"`
+	ActiveLeafID *uuid.UUID `json:"active_leaf_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
 
 // MessageResponse 是返回给客户端的消息数据结构
 type MessageResponse struct {
-	ID             uuid.UUID `json:"id"`
-	ConversationID uuid.UUID `json:"conversation_id"`
-	Role           string    `json:"role"`
-	Content        string    `json:"content"`
-	Tokens         int       `json:"tokens"`
-	Feedback       string    `json:"feedback,omitempty"`
-	Metadata       JSONMap   `json:"metadata,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             uuid.UUID      `json:"id"`
+	ConversationID uuid.UUID      `json:"conversation_id"`
+	ParentID       *uuid.UUID     `json:"parent_id,omitempty"`
+	Role           string         `json:"role"`
+	Content        string         `json:"content"`
+	Tokens         int            `json:"tokens"`
+	Feedback       string         `json:"feedback,omitempty"`
+	Metadata       JSONMap        `json:"metadata,omitempty"`
+	Branch         *MessageBranch `json:"branch,omitempty"`
+	// 消息引用的附件
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+}
+
+// MessageBranch 消息在同一父消息下的分支位置，用于在不同版本之间切换
+type MessageBranch struct {
+	Index      int         `json:"index"`       // 当前消息在兄弟消息中的位置，从0开始
+	Count      int         `json:"count"`       // 兄弟消息总数（含自身）
+	SiblingIDs []uuid.UUID `json:"sibling_ids"` // 按创建顺序排列的兄弟消息ID
 }
 
 // ToResponse 将完整对话模型转换为对外响应
@@ -85,15 +109,18 @@ func (c *Conversation) ToResponse() ConversationResponse {
 	}
 
 	return ConversationResponse{
-		ID:        c.ID,
-		Title:     c.Title,
-		AgentID:   c.AgentID,
-		AgentName: agentName,
-		UserID:    c.UserID,
-		Status:    c.Status,
-		Metadata:  c.Metadata,
-		CreatedAt: c.CreatedAt,
-		UpdatedAt: c.UpdatedAt,
+		ID:           c.ID,
+		Title:        c.Title,
+		AgentID:      c.AgentID,
+		AgentName:    agentName,
+		UserID:       c.UserID,
+		Status:       c.Status,
+		Metadata:     c.Metadata,
+		Tags:         c.Tags,
+		Language:     c.Language,
+		ActiveLeafID: c.ActiveLeafID,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
 	}
 }
 
@@ -102,6 +129,7 @@ func (m *Message) ToResponse() MessageResponse {
 	return MessageResponse{
 		ID:             m.ID,
 		ConversationID: m.ConversationID,
+		ParentID:       m.ParentID,
 		Role:           m.Role,
 		Content:        m.Content,
 		Tokens:         m.Tokens,
@@ -120,8 +148,19 @@ type CreateConversationRequest struct {
 
 // SendMessageRequest 发送消息请求
 type SendMessageRequest struct {
-	Content string  `json:"content" binding:"required"`
-	Stream  bool    `json:"stream"`
+	Content       string      `json:"content" binding:"required"`
+	Stream        bool        `json:"stream"`
+	AttachmentIDs []uuid.UUID `json:"attachment_ids" binding:"max=10"` // 已上传的附件
+}
+
+// EditMessageRequest 编辑用户消息并重新发送请求，新消息作为原消息的兄弟分支
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// SwitchBranchRequest 切换当前分支请求
+type SwitchBranchRequest struct {
+	MessageID uuid.UUID `json:"message_id" binding:"required"`
 }
 
 // RegenerateRequest 重新生成回复请求
@@ -129,7 +168,66 @@ type RegenerateRequest struct {
 	Stream bool `json:"stream"`
 }
 
-// MessageFeedbackRequest 提供消息反馈请求
+// MessageFeedbackRequest 提供消息反馈请求，feedback 与 rating 至少提供一项
 type MessageFeedbackRequest struct {
-	Feedback string `json:"feedback" binding:"required,oneof=positive negative"`
-} 
+	Feedback        string   `json:"feedback" binding:"omitempty,oneof=positive negative"`
+	Rating          int      `json:"rating" binding:"omitempty,min=1,max=5"`
+	Reasons         []string `json:"reasons" binding:"omitempty,max=7,dive,oneof=inaccurate incomplete irrelevant unsafe outdated formatting other"`
+	Comment         string   `json:"comment" binding:"max=2000"`
+	CorrectedAnswer string   `json:"corrected_answer"`
+}
+
+// ConversationExportVersion 对话导出格式的版本
+const ConversationExportVersion = 1
+
+// ConversationExport 对话导出与导入使用的JSON结构
+type ConversationExport struct {
+	Version       int                    `json:"version"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Conversations []ExportedConversation `json:"conversations"`
+}
+
+// ExportedConversation 导出的单个对话，消息为当前分支上的消息
+type ExportedConversation struct {
+	ID           uuid.UUID         `json:"id"`
+	Title        string            `json:"title"`
+	AgentID      uuid.UUID         `json:"agent_id"`
+	AgentName    string            `json:"agent_name,omitempty"`
+	SystemPrompt string            `json:"system_prompt,omitempty"`
+	Status       string            `json:"status"`
+	Metadata     JSONMap           `json:"metadata,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	Messages     []ExportedMessage `json:"messages"`
+}
+
+// ExportedMessage 导出的消息
+type ExportedMessage struct {
+	ID        uuid.UUID  `json:"id"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Tokens    int        `json:"tokens,omitempty"`
+	Feedback  string     `json:"feedback,omitempty"`
+	Metadata  JSONMap    `json:"metadata,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ImportConversationsResponse 导入对话结果
+type ImportConversationsResponse struct {
+	Imported      int                    `json:"imported"`
+	Conversations []ConversationResponse `json:"conversations"`
+}
+
+// MessageSearchResult 对话历史搜索结果
+type MessageSearchResult struct {
+	MessageID         uuid.UUID `json:"message_id"`
+	ConversationID    uuid.UUID `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	AgentID           uuid.UUID `json:"agent_id"`
+	Role              string    `json:"role"`
+	Feedback          string    `json:"feedback,omitempty"`
+	Snippet           string    `json:"snippet"` // 匹配内容片段，命中的词以 <mark> 标记
+	Score             float64   `json:"score"`
+	CreatedAt         time.Time `json:"created_at"`
+}
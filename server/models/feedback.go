@@ -0,0 +1,146 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 反馈审核状态
+const (
+	FeedbackStatusPending   = "pending"   // 待审核
+	FeedbackStatusReviewed  = "reviewed"  // 已审核
+	FeedbackStatusDismissed = "dismissed" // 已忽略
+)
+
+// 反馈原因分类
+const (
+	FeedbackReasonInaccurate = "inaccurate" // 内容错误
+	FeedbackReasonIncomplete = "incomplete" // 回答不完整
+	FeedbackReasonIrrelevant = "irrelevant" // 答非所问
+	FeedbackReasonUnsafe     = "unsafe"     // 不安全或不当内容
+	FeedbackReasonOutdated   = "outdated"   // 信息过时
+	FeedbackReasonFormatting = "formatting" // 格式问题
+	FeedbackReasonOther      = "other"      // 其他
+)
+
+// 评分阈值，低于等于 NegativeRatingMax 视为差评，高于等于 PositiveRatingMin 视为好评
+const (
+	NegativeRatingMax = 2
+	PositiveRatingMin = 4
+)
+
+// MessageFeedback 用户对助手消息的反馈记录，管理员可审核并给出修正答案
+type MessageFeedback struct {
+	ID              uuid.UUID   `gorm:"type:uuid;primary_key" json:"id"`
+	MessageID       uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_message_feedbacks_message_user" json:"message_id"`
+	UserID          uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_message_feedbacks_message_user" json:"user_id"`
+	ConversationID  uuid.UUID   `gorm:"type:uuid;not null" json:"conversation_id"`
+	AgentID         uuid.UUID   `gorm:"type:uuid;not null;index:idx_message_feedbacks_agent_status" json:"agent_id"`
+	Rating          int         `gorm:"type:smallint;not null" json:"rating"` // 1-5
+	Reasons         StringArray `gorm:"type:text[]" json:"reasons"`
+	Comment         string      `gorm:"type:text" json:"comment"`
+	CorrectedAnswer string      `gorm:"type:text" json:"corrected_answer"`
+	Status          string      `gorm:"type:varchar(16);not null;default:'pending';index:idx_message_feedbacks_agent_status" json:"status"`
+	ReviewerID      *uuid.UUID  `gorm:"type:uuid" json:"reviewer_id,omitempty"`
+	ReviewNote      string      `gorm:"type:text" json:"review_note"`
+	ReviewedAt      *time.Time  `json:"reviewed_at,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+
+	// 关联
+	Message  *Message `gorm:"foreignKey:MessageID" json:"-"`
+	Agent    *Agent   `gorm:"foreignKey:AgentID" json:"-"`
+	User     *User    `gorm:"foreignKey:UserID" json:"-"`
+	Reviewer *User    `gorm:"foreignKey:ReviewerID" json:"-"`
+}
+
+// BeforeCreate 在创建反馈前生成UUID
+func (f *MessageFeedback) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsNegative 是否为差评
+func (f *MessageFeedback) IsNegative() bool {
+	return f.Rating <= NegativeRatingMax
+}
+
+// MessageFeedbackResponse 是返回给客户端的反馈数据结构
+type MessageFeedbackResponse struct {
+	ID              uuid.UUID  `json:"id"`
+	MessageID       uuid.UUID  `json:"message_id"`
+	ConversationID  uuid.UUID  `json:"conversation_id"`
+	AgentID         uuid.UUID  `json:"agent_id"`
+	AgentName       string     `json:"agent_name,omitempty"`
+	UserID          uuid.UUID  `json:"user_id"`
+	Rating          int        `json:"rating"`
+	Reasons         []string   `json:"reasons"`
+	Comment         string     `json:"comment,omitempty"`
+	CorrectedAnswer string     `json:"corrected_answer,omitempty"`
+	Status          string     `json:"status"`
+	ReviewerID      *uuid.UUID `json:"reviewer_id,omitempty"`
+	ReviewerName    string     `json:"reviewer_name,omitempty"`
+	ReviewNote      string     `json:"review_note,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	// 审核队列中附带被评价的回复及其对应的用户提问
+	MessageContent  string `json:"message_content,omitempty"`
+	QuestionContent string `json:"question_content,omitempty"`
+}
+
+// ToResponse 将反馈记录转换为对外响应
+func (f *MessageFeedback) ToResponse() MessageFeedbackResponse {
+	response := MessageFeedbackResponse{
+		ID:              f.ID,
+		MessageID:       f.MessageID,
+		ConversationID:  f.ConversationID,
+		AgentID:         f.AgentID,
+		UserID:          f.UserID,
+		Rating:          f.Rating,
+		Reasons:         f.Reasons,
+		Comment:         f.Comment,
+		CorrectedAnswer: f.CorrectedAnswer,
+		Status:          f.Status,
+		ReviewerID:      f.ReviewerID,
+		ReviewNote:      f.ReviewNote,
+		ReviewedAt:      f.ReviewedAt,
+		CreatedAt:       f.CreatedAt,
+		UpdatedAt:       f.UpdatedAt,
+	}
+	if f.Agent != nil {
+		response.AgentName = f.Agent.Name
+	}
+	if f.Reviewer != nil {
+		response.ReviewerName = f.Reviewer.Username
+	}
+	if f.Message != nil {
+		response.MessageContent = f.Message.Content
+	}
+	return response
+}
+
+// ReviewFeedbackRequest 审核反馈请求
+type ReviewFeedbackRequest struct {
+	Status          string `json:"status" binding:"required,oneof=reviewed dismissed pending"`
+	ReviewNote      string `json:"review_note" binding:"max=2000"`
+	CorrectedAnswer string `json:"corrected_answer"`
+}
+
+// FeedbackStats 反馈汇总统计
+type FeedbackStats struct {
+	AgentID        *uuid.UUID     `json:"agent_id,omitempty"`
+	AgentName      string         `json:"agent_name,omitempty"`
+	Total          int64          `json:"total"`
+	Positive       int64          `json:"positive"`
+	Negative       int64          `json:"negative"`
+	AverageRating  float64        `json:"average_rating"`
+	PendingReviews int64          `json:"pending_reviews"`
+	Corrected      int64          `json:"corrected"`
+	Reasons        map[string]int `json:"reasons"`
+}
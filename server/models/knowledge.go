@@ -9,16 +9,28 @@ import (
 
 // KnowledgeBase 模型表示知识库
 type KnowledgeBase struct {
-	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	Name        string    `gorm:"type:varchar(100);not null" json:"name"`
-	Description string    `gorm:"type:text" json:"description"`
-	Type        string    `gorm:"type:varchar(50);not null" json:"type"` // file, web, database
-	Config      string    `gorm:"type:jsonb" json:"config"`
-	Status      string    `gorm:"type:varchar(20);default:'active'" json:"status"`
-	CreatedBy   uuid.UUID `gorm:"type:uuid;not null" json:"created_by"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
+	Name            string         `gorm:"type:varchar(100);not null" json:"name"`
+	Description     string         `gorm:"type:text" json:"description"`
+	Type            string         `gorm:"type:varchar(50);not null" json:"type"` // file, web, database
+	Config          string         `gorm:"type:jsonb" json:"config"`
+	Status          string         `gorm:"type:varchar(20);default:'active'" json:"status"`
+	EmbeddingModel  string         `gorm:"type:varchar(100);not null;default:'mock'" json:"embedding_model"`
+	ChunkStrategy   string         `gorm:"type:varchar(20);not null;default:'recursive'" json:"chunk_strategy"` // recursive, token, markdown, sentence, semantic
+	ChunkSize       int            `gorm:"not null;default:1000" json:"chunk_size"`                             // token 策略按token计，其余按字符计
+	ChunkOverlap    int            `gorm:"not null;default:200" json:"chunk_overlap"`
+	RetrievalMode   string         `gorm:"type:varchar(20);not null;default:'hybrid'" json:"retrieval_mode"` // vector, keyword, hybrid
+	FusionMethod    string         `gorm:"type:varchar(20);not null;default:'rrf'" json:"fusion_method"`     // rrf, weighted
+	KeywordWeight   float64        `gorm:"not null;default:0.5" json:"keyword_weight"`                       // 混合检索中关键词结果的权重，向量结果为 1-权重
+	Reranker        string         `gorm:"type:varchar(50);not null;default:''" json:"reranker"`             // 重排器名称，为空时不重排
+	RerankThreshold float64        `gorm:"not null;default:0" json:"rerank_threshold"`                       // 重排分数低于该值的结果被丢弃
+	ProjectID       *uuid.UUID     `gorm:"type:uuid;index" json:"project_id,omitempty"`                      // 所属项目，项目所有者同样可以管理
+	DocumentCount   int            `gorm:"not null;default:0" json:"document_count"`                         // 已索引的文档数，随文档变更原子更新
+	ChunkCount      int            `gorm:"not null;default:0" json:"chunk_count"`
+	CreatedBy       uuid.UUID      `gorm:"type:uuid;not null;index" json:"created_by"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // BeforeCreate 在创建知识库前生成UUID
@@ -29,28 +41,155 @@ func (kb *KnowledgeBase) BeforeCreate(tx *gorm.DB) error {
 
 // KnowledgeBaseResponse 是返回给客户端的知识库数据结构
 type KnowledgeBaseResponse struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Type        string    `json:"type"`
-	Config      string    `json:"config"`
-	Status      string    `json:"status"`
-	CreatedBy   uuid.UUID `json:"created_by"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Type            string     `json:"type"`
+	Config          string     `json:"config"`
+	Status          string     `json:"status"`
+	EmbeddingModel  string     `json:"embedding_model"`
+	ChunkStrategy   string     `json:"chunk_strategy"`
+	ChunkSize       int        `json:"chunk_size"`
+	ChunkOverlap    int        `json:"chunk_overlap"`
+	RetrievalMode   string     `json:"retrieval_mode"`
+	FusionMethod    string     `json:"fusion_method"`
+	KeywordWeight   float64    `json:"keyword_weight"`
+	Reranker        string     `json:"reranker,omitempty"`
+	RerankThreshold float64    `json:"rerank_threshold"`
+	ProjectID       *uuid.UUID `json:"project_id,omitempty"`
+	DocumentCount   int        `json:"document_count"`
+	ChunkCount      int        `json:"chunk_count"`
+	CreatedBy       uuid.UUID  `json:"created_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 // ToResponse 将完整知识库模型转换为对外响应
 func (kb *KnowledgeBase) ToResponse() KnowledgeBaseResponse {
 	return KnowledgeBaseResponse{
-		ID:          kb.ID,
-		Name:        kb.Name,
-		Description: kb.Description,
-		Type:        kb.Type,
-		Config:      kb.Config,
-		Status:      kb.Status,
-		CreatedBy:   kb.CreatedBy,
-		CreatedAt:   kb.CreatedAt,
-		UpdatedAt:   kb.UpdatedAt,
+		ID:              kb.ID,
+		Name:            kb.Name,
+		Description:     kb.Description,
+		Type:            kb.Type,
+		Config:          kb.Config,
+		Status:          kb.Status,
+		EmbeddingModel:  kb.EmbeddingModel,
+		ChunkStrategy:   kb.ChunkStrategy,
+		ChunkSize:       kb.ChunkSize,
+		ChunkOverlap:    kb.ChunkOverlap,
+		RetrievalMode:   kb.RetrievalMode,
+		FusionMethod:    kb.FusionMethod,
+		KeywordWeight:   kb.KeywordWeight,
+		Reranker:        kb.Reranker,
+		RerankThreshold: kb.RerankThreshold,
+		ProjectID:       kb.ProjectID,
+		DocumentCount:   kb.DocumentCount,
+		ChunkCount:      kb.ChunkCount,
+		CreatedBy:       kb.CreatedBy,
+		CreatedAt:       kb.CreatedAt,
+		UpdatedAt:       kb.UpdatedAt,
 	}
-} 
\ No newline at end of file
+}
+
+// 文档状态，文档按 queued → parsing → chunking → embedding → indexed 推进，任一阶段失败或被取消时为 failed
+const (
+	DocumentStatusQueued    = "queued"    // 等待处理
+	DocumentStatusParsing   = "parsing"   // 解析文档内容
+	DocumentStatusChunking  = "chunking"  // 切分文档
+	DocumentStatusEmbedding = "embedding" // 分批向量化并写入向量数据库
+	DocumentStatusIndexed   = "indexed"   // 已索引，可被检索
+	DocumentStatusFailed    = "failed"    // 处理失败或被取消，错误信息见 Error
+)
+
+// Document 知识库中的文档，保存原始内容以便重试和重建索引
+type Document struct {
+	ID              uuid.UUID   `gorm:"type:uuid;primary_key" json:"id"`
+	KnowledgeBaseID uuid.UUID   `gorm:"type:uuid;not null;index:idx_documents_kb_checksum" json:"knowledge_base_id"`
+	Name            string      `gorm:"type:varchar(255);not null" json:"name"`
+	Type            string      `gorm:"type:varchar(20);not null" json:"type"`
+	Size            int64       `gorm:"not null" json:"size"`
+	Checksum        string      `gorm:"type:varchar(64);not null;index:idx_documents_kb_checksum" json:"checksum"` // 内容的SHA-256
+	Tags            StringArray `gorm:"type:text[]" json:"tags"`                                                   // 文档标签，写入分块元数据，检索时可按 tags 过滤
+	Status          string      `gorm:"type:varchar(20);not null;index" json:"status"`
+	Error           string      `gorm:"type:text" json:"error,omitempty"`
+	ChunkCount      int         `gorm:"not null;default:0" json:"chunk_count"`             // 当前生效的分块数，计入知识库统计
+	TotalChunks     int         `gorm:"not null;default:0" json:"total_chunks"`            // 本次处理切分出的分块数
+	EmbeddedChunks  int         `gorm:"not null;default:0" json:"embedded_chunks"`         // 本次处理已向量化的分块数
+	EmbeddingModel  string      `gorm:"type:varchar(100);not null" json:"embedding_model"` // 索引时使用的向量模型
+	Attempts        int         `gorm:"not null;default:0" json:"attempts"`
+	HeartbeatAt     *time.Time  `json:"-"`
+	IndexedAt       *time.Time  `json:"indexed_at,omitempty"` // 非空表示文档已有生效的分块
+	Content         []byte      `gorm:"type:bytea" json:"-"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// BeforeCreate 在创建文档前生成UUID
+func (d *Document) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// Chunk 文档分块，ID与向量数据库中的向量ID一致
+type Chunk struct {
+	ID              string    `gorm:"type:varchar(100);primary_key" json:"id"`
+	DocumentID      uuid.UUID `gorm:"type:uuid;not null;index" json:"document_id"`
+	KnowledgeBaseID uuid.UUID `gorm:"type:uuid;not null;index" json:"knowledge_base_id"`
+	ChunkIndex      int       `gorm:"not null" json:"chunk_index"`
+	PageNumber      int       `json:"page_number,omitempty"`
+	Headings        string    `gorm:"type:text" json:"headings,omitempty"` // Markdown标题路径，以 " > " 连接
+	Content         string    `gorm:"type:text;not null" json:"content"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// CreateKnowledgeBaseRequest 创建知识库的请求
+type CreateKnowledgeBaseRequest struct {
+	Name            string     `json:"name" binding:"required,min=1,max=100"`
+	Description     string     `json:"description"`
+	Type            string     `json:"type" binding:"omitempty,oneof=file web database"`
+	Config          string     `json:"config"`
+	EmbeddingModel  string     `json:"embedding_model"` // 为空时使用默认向量模型
+	ChunkStrategy   string     `json:"chunk_strategy" binding:"omitempty,oneof=recursive token markdown sentence semantic"`
+	ChunkSize       int        `json:"chunk_size" binding:"omitempty,min=50,max=8000"`
+	ChunkOverlap    *int       `json:"chunk_overlap" binding:"omitempty,min=0"`
+	RetrievalMode   string     `json:"retrieval_mode" binding:"omitempty,oneof=vector keyword hybrid"`
+	FusionMethod    string     `json:"fusion_method" binding:"omitempty,oneof=rrf weighted"`
+	KeywordWeight   *float64   `json:"keyword_weight" binding:"omitempty,min=0,max=1"`
+	Reranker        string     `json:"reranker" binding:"omitempty,max=50"` // 为空时不重排
+	RerankThreshold float64    `json:"rerank_threshold" binding:"omitempty,min=0,max=1"`
+	ProjectID       *uuid.UUID `json:"project_id"`
+}
+
+// UpdateKnowledgeBaseRequest 更新知识库的请求，向量模型创建后不可修改，分块设置对之后入库或重建索引的文档生效，
+// 检索设置立即生效
+type UpdateKnowledgeBaseRequest struct {
+	Name            string   `json:"name" binding:"omitempty,min=1,max=100"`
+	Description     string   `json:"description"`
+	Config          string   `json:"config"`
+	Status          string   `json:"status" binding:"omitempty,oneof=active archived"`
+	ChunkStrategy   string   `json:"chunk_strategy" binding:"omitempty,oneof=recursive token markdown sentence semantic"`
+	ChunkSize       int      `json:"chunk_size" binding:"omitempty,min=50,max=8000"`
+	ChunkOverlap    *int     `json:"chunk_overlap" binding:"omitempty,min=0"`
+	RetrievalMode   string   `json:"retrieval_mode" binding:"omitempty,oneof=vector keyword hybrid"`
+	FusionMethod    string   `json:"fusion_method" binding:"omitempty,oneof=rrf weighted"`
+	KeywordWeight   *float64 `json:"keyword_weight" binding:"omitempty,min=0,max=1"`
+	Reranker        *string  `json:"reranker" binding:"omitempty,max=50"` // 空字符串表示关闭重排
+	RerankThreshold *float64 `json:"rerank_threshold" binding:"omitempty,min=0,max=1"`
+}
+
+// AddTextDocumentRequest 以文本形式添加文档的请求
+type AddTextDocumentRequest struct {
+	Name    string   `json:"name" binding:"required,max=255"`
+	Content string   `json:"content" binding:"required"`
+	Type    string   `json:"type" binding:"omitempty,oneof=text markdown"`
+	Tags    []string `json:"tags"`
+}
+
+// QueryKnowledgeBaseRequest 知识库检索请求
+type QueryKnowledgeBaseRequest struct {
+	Query   string                 `json:"query" binding:"required"`
+	TopK    int                    `json:"top_k" binding:"omitempty,min=1,max=50"`
+	Filters map[string]interface{} `json:"filters"` // 元数据过滤条件，格式见 kb.ParseFilter
+}
@@ -216,7 +216,7 @@ type Model struct {
 	ModelID         string            `gorm:"type:varchar(100);not null" json:"model_id"`
 	Type            ModelType         `gorm:"type:varchar(20);not null" json:"type"`
 	Description     string            `gorm:"type:text" json:"description"`
-	Capabilities    []string          `gorm:"type:text[]" json:"capabilities"`
+	Capabilities    StringArray       `gorm:"type:text[]" json:"capabilities"`
 	Parameters      ModelParameters   `gorm:"type:jsonb" json:"parameters"`
 	MaxTokens       int               `gorm:"not null" json:"max_tokens"`
 	TokenCostPrompt float64           `gorm:"not null" json:"token_cost_prompt"`
@@ -232,6 +232,22 @@ type Model struct {
 	DeletedAt       gorm.DeletedAt    `gorm:"index" json:"-"`
 }
 
+// ModelCapabilityVision 模型能力：支持图像输入
+const ModelCapabilityVision = "vision"
+
+// SupportsVision 模型是否支持图像输入
+func (m *Model) SupportsVision() bool {
+	if m.Type == ModelTypeMultimodal {
+		return true
+	}
+	for _, capability := range m.Capabilities {
+		if capability == ModelCapabilityVision {
+			return true
+		}
+	}
+	return false
+}
+
 // BeforeCreate 在创建模型前生成UUID
 func (m *Model) BeforeCreate(tx *gorm.DB) error {
 	m.ID = uuid.New()
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ToolApproval 等待人工审批的工具调用记录
+type ToolApproval struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	AgentID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"agent_id"`
+	ConversationID *uuid.UUID `gorm:"type:uuid;index" json:"conversation_id,omitempty"`
+	Status         string     `gorm:"type:varchar(16);not null;default:'pending'" json:"status"` // pending, resolved
+	State          JSONMap    `gorm:"type:jsonb" json:"state"`                                   // 暂停时的运行状态
+	Decisions      JSONMap    `gorm:"type:jsonb" json:"decisions"`                               // 用户的审批决定
+	ResolvedBy     *uuid.UUID `gorm:"type:uuid" json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// 关联
+	Agent        *Agent        `gorm:"foreignKey:AgentID" json:"-"`
+	Conversation *Conversation `gorm:"foreignKey:ConversationID" json:"-"`
+}
+
+// ToolApprovalResponse 是返回给客户端的审批数据结构
+type ToolApprovalResponse struct {
+	ID             uuid.UUID   `json:"id"`
+	AgentID        uuid.UUID   `json:"agent_id"`
+	ConversationID *uuid.UUID  `json:"conversation_id,omitempty"`
+	Status         string      `json:"status"`
+	ToolCalls      interface{} `json:"tool_calls"`
+	Decisions      JSONMap     `json:"decisions,omitempty"`
+	ResolvedAt     *time.Time  `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// ToResponse 将审批记录转换为对外响应
+func (a *ToolApproval) ToResponse() ToolApprovalResponse {
+	var toolCalls interface{}
+	if a.State != nil {
+		toolCalls = a.State["pending"]
+	}
+
+	return ToolApprovalResponse{
+		ID:             a.ID,
+		AgentID:        a.AgentID,
+		ConversationID: a.ConversationID,
+		Status:         a.Status,
+		ToolCalls:      toolCalls,
+		Decisions:      a.Decisions,
+		ResolvedAt:     a.ResolvedAt,
+		CreatedAt:      a.CreatedAt,
+	}
+}
+
+// ToolApprovalDecision 单个工具调用的审批决定
+type ToolApprovalDecision struct {
+	ToolCallID string `json:"tool_call_id" binding:"required"`
+	Action     string `json:"action" binding:"required,oneof=approve edit reject"`
+	Arguments  string `json:"arguments"` // action为edit时的新参数（JSON字符串）
+	Reason     string `json:"reason"`
+}
+
+// ToolApprovalDecisionRequest 提交审批决定请求
+type ToolApprovalDecisionRequest struct {
+	Decisions []ToolApprovalDecision `json:"decisions" binding:"required,min=1,dive"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserMemory 智能体针对某个用户保存的长期记忆
+type UserMemory struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	AgentID        uuid.UUID  `gorm:"type:uuid;not null;index:idx_user_memories_agent_user" json:"agent_id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index:idx_user_memories_agent_user" json:"user_id"`
+	Content        string     `gorm:"type:text;not null" json:"content"`
+	Source         string     `gorm:"type:varchar(16);not null;default:'extracted'" json:"source"` // extracted, edited
+	ConversationID *uuid.UUID `gorm:"type:uuid" json:"conversation_id,omitempty"`                  // 提取来源对话
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// 关联
+	Agent *Agent `gorm:"foreignKey:AgentID" json:"-"`
+	User  *User  `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// BeforeCreate 在创建记忆前生成UUID
+func (m *UserMemory) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// UserMemoryResponse 是返回给客户端的长期记忆数据结构
+type UserMemoryResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	AgentID        uuid.UUID  `json:"agent_id"`
+	Content        string     `json:"content"`
+	Source         string     `json:"source"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ToResponse 将长期记忆转换为对外响应
+func (m *UserMemory) ToResponse() UserMemoryResponse {
+	return UserMemoryResponse{
+		ID:             m.ID,
+		AgentID:        m.AgentID,
+		Content:        m.Content,
+		Source:         m.Source,
+		ConversationID: m.ConversationID,
+		CreatedAt:      m.CreatedAt,
+		UpdatedAt:      m.UpdatedAt,
+	}
+}
+
+// UpdateUserMemoryRequest 编辑长期记忆请求
+type UpdateUserMemoryRequest struct {
+	Content string `json:"content" binding:"required,max=2000"`
+}
@@ -0,0 +1,29 @@
+package llm
+
+import "github.com/zhuiye8/Lyss/server/models"
+
+const (
+	// dashScopeCompatibleURL 阿里云百炼(DashScope)的OpenAI兼容接口地址
+	dashScopeCompatibleURL = "https://dashscope.aliyuncs.com/compatible-mode/v1"
+
+	// localCompatibleURL 本地服务的默认地址（Ollama 的OpenAI兼容接口）
+	localCompatibleURL = "http://localhost:11434/v1"
+)
+
+// NewAliAdapter 创建阿里云通义千问适配器，通过DashScope的OpenAI兼容接口调用对话和向量模型
+func NewAliAdapter(config models.ModelProviderConfig) *OpenAIAdapter {
+	if config.BaseURL == "" {
+		config.BaseURL = dashScopeCompatibleURL
+	}
+	return NewOpenAIAdapter(config)
+}
+
+// NewLocalAdapter 创建本地部署服务（Ollama、vLLM、LocalAI 等OpenAI兼容服务）的适配器，API密钥可选
+func NewLocalAdapter(config models.ModelProviderConfig) *OpenAIAdapter {
+	if config.BaseURL == "" {
+		config.BaseURL = localCompatibleURL
+	}
+	adapter := NewOpenAIAdapter(config)
+	adapter.keyOptional = true
+	return adapter
+}
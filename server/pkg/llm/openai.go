@@ -18,6 +18,9 @@ type OpenAIAdapter struct {
 	baseURL    string
 	orgID      string
 	httpClient *http.Client
+
+	// keyOptional 为 true 时允许不提供API密钥，用于本地部署的兼容服务
+	keyOptional bool
 }
 
 // NewOpenAIAdapter 创建OpenAI适配?
@@ -109,7 +112,7 @@ type OpenAIEmbeddingResponse struct {
 
 // Chat 实现对话方法
 func (a *OpenAIAdapter) Chat(ctx context.Context, request ChatRequest) (*ChatResponse, error) {
-	if a.apiKey == "" {
+	if a.apiKey == "" && !a.keyOptional {
 		return nil, ErrAPIKeyRequired
 	}
 	
@@ -166,7 +169,9 @@ func (a *OpenAIAdapter) Chat(ctx context.Context, request ChatRequest) (*ChatRes
 	}
 	
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
 	if a.orgID != "" {
 		req.Header.Set("OpenAI-Organization", a.orgID)
 	}
@@ -236,7 +241,7 @@ func (a *OpenAIAdapter) Chat(ctx context.Context, request ChatRequest) (*ChatRes
 
 // Embedding 实现嵌入方法
 func (a *OpenAIAdapter) Embedding(ctx context.Context, request EmbeddingRequest) (*EmbeddingResponse, error) {
-	if a.apiKey == "" {
+	if a.apiKey == "" && !a.keyOptional {
 		return nil, ErrAPIKeyRequired
 	}
 	
@@ -265,7 +270,9 @@ func (a *OpenAIAdapter) Embedding(ctx context.Context, request EmbeddingRequest)
 	}
 	
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
 	if a.orgID != "" {
 		req.Header.Set("OpenAI-Organization", a.orgID)
 	}
@@ -318,7 +325,7 @@ func (a *OpenAIAdapter) Embedding(ctx context.Context, request EmbeddingRequest)
 
 // TestConnection 测试连接
 func (a *OpenAIAdapter) TestConnection(ctx context.Context) error {
-	if a.apiKey == "" {
+	if a.apiKey == "" && !a.keyOptional {
 		return ErrAPIKeyRequired
 	}
 	
@@ -328,7 +335,9 @@ func (a *OpenAIAdapter) TestConnection(ctx context.Context) error {
 		return err
 	}
 	
-	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
 	if a.orgID != "" {
 		req.Header.Set("OpenAI-Organization", a.orgID)
 	}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "attachments/u1/a1.txt"
+	if err := store.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	// 覆盖写入
+	if err := store.Put(ctx, key, strings.NewReader("hello again"), -1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello again" {
+		t.Errorf("Get = %q, want %q", data, "hello again")
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get after delete error = %v, want %v", err, ErrObjectNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of missing object error = %v, want nil", err)
+	}
+}
+
+func TestLocalStorageRejectsInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "/", "../outside.txt", "attachments/../../outside.txt"} {
+		t.Run(key, func(t *testing.T) {
+			if err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+				t.Error("Put succeeded, want error")
+			}
+			if _, err := store.Get(ctx, key); err == nil || errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Get error = %v, want invalid key error", err)
+			}
+			if err := store.Delete(ctx, key); err == nil {
+				t.Error("Delete succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"default provider", Config{LocalDir: t.TempDir()}, false},
+		{"local", Config{Provider: ProviderLocal, LocalDir: t.TempDir()}, false},
+		{"local without directory", Config{Provider: ProviderLocal}, true},
+		{"unsupported provider", Config{Provider: "ftp"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("New error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 存储提供商
+const (
+	ProviderLocal = "local" // 本地磁盘
+	ProviderS3    = "s3"    // S3兼容的对象存储，例如 AWS S3、MinIO
+)
+
+// ErrObjectNotFound 存储中不存在指定对象
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage 文件存储接口
+type Storage interface {
+	// Put 写入对象，size 未知时传 -1
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(ctx context.Context, key string) error
+}
+
+// S3Config S3兼容存储配置
+type S3Config struct {
+	Endpoint  string // 为空时使用 AWS S3，MinIO 等需填写例如 localhost:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// Config 存储配置
+type Config struct {
+	Provider string
+	LocalDir string // 本地存储的根目录
+	S3       S3Config
+}
+
+// New 根据配置创建存储
+func New(config Config) (Storage, error) {
+	switch config.Provider {
+	case "", ProviderLocal:
+		return NewLocalStorage(config.LocalDir)
+	case ProviderS3:
+		return NewS3Storage(config.S3)
+	default:
+		return nil, fmt.Errorf("unsupported storage provider: %s", config.Provider)
+	}
+}